@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// ErrInjected is the error returned by faults a Chaos store injects
+var ErrInjected = errors.New("chaos: injected fault")
+
+// ChaosConfig sets the faults injected into writes (INSERT batches) and queries
+type ChaosConfig struct {
+	// WriteErrorRate is the share of batch sends that fail, 0 to 1
+	WriteErrorRate float64
+	// WriteLatency is added before every batch send
+	WriteLatency time.Duration
+	// QueryErrorRate is the share of Query, QueryRow, and Exec calls that fail, 0 to 1
+	QueryErrorRate float64
+	// QueryLatency is added before every query
+	QueryLatency time.Duration
+}
+
+// Validate checks the rates and latencies are in range
+func (c ChaosConfig) Validate() error {
+	if c.WriteErrorRate < 0 || c.WriteErrorRate > 1 || c.QueryErrorRate < 0 || c.QueryErrorRate > 1 {
+		return fmt.Errorf("invalid chaos config: error rates must be between 0 and 1")
+	}
+	if c.WriteLatency < 0 || c.QueryLatency < 0 {
+		return fmt.Errorf("invalid chaos config: latencies can't be negative")
+	}
+	return nil
+}
+
+// ChaosStats counts the faults injected so far
+type ChaosStats struct {
+	WriteErrors  int64 `json:"write_errors"`
+	QueryErrors  int64 `json:"query_errors"`
+	DelayedCalls int64 `json:"delayed_calls"`
+}
+
+// Chaos injects errors and latency into the stores it wraps, so retry, dead-letter, and
+// backpressure behavior can be exercised without breaking a real ClickHouse. It is meant
+// for development only. The config can be changed while stores are in use.
+type Chaos struct {
+	mu     sync.RWMutex
+	config ChaosConfig
+
+	writeErrors  atomic.Int64
+	queryErrors  atomic.Int64
+	delayedCalls atomic.Int64
+}
+
+// NewChaos creates a fault injector with the given config
+func NewChaos(config ChaosConfig) (*Chaos, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Chaos{config: config}, nil
+}
+
+// Config returns the current config
+func (c *Chaos) Config() ChaosConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+// SetConfig replaces the config; it applies to calls made from now on
+func (c *Chaos) SetConfig(config ChaosConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.config = config
+	c.mu.Unlock()
+	return nil
+}
+
+// Stats returns the faults injected so far
+func (c *Chaos) Stats() ChaosStats {
+	return ChaosStats{
+		WriteErrors:  c.writeErrors.Load(),
+		QueryErrors:  c.queryErrors.Load(),
+		DelayedCalls: c.delayedCalls.Load(),
+	}
+}
+
+// Wrap returns store with faults injected. Ping and Close are passed through, so health
+// checks reflect the real server.
+func (c *Chaos) Wrap(store Store) Store {
+	return &chaosStore{Store: store, chaos: c}
+}
+
+// delay waits for latency, returning early with ctx's error if it is done first
+func (c *Chaos) delay(ctx context.Context, latency time.Duration) error {
+	if latency <= 0 {
+		return nil
+	}
+	c.delayedCalls.Add(1)
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// query applies the query faults to a call
+func (c *Chaos) query(ctx context.Context) error {
+	config := c.Config()
+	if err := c.delay(ctx, config.QueryLatency); err != nil {
+		return err
+	}
+	if rand.Float64() < config.QueryErrorRate {
+		c.queryErrors.Add(1)
+		return ErrInjected
+	}
+	return nil
+}
+
+type chaosStore struct {
+	Store
+	chaos *Chaos
+}
+
+func (s *chaosStore) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	if err := s.chaos.query(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.Query(ctx, query, args...)
+}
+
+func (s *chaosStore) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	if err := s.chaos.query(ctx); err != nil {
+		return errRow{err}
+	}
+	return s.Store.QueryRow(ctx, query, args...)
+}
+
+func (s *chaosStore) Exec(ctx context.Context, query string, args ...any) error {
+	if err := s.chaos.query(ctx); err != nil {
+		return err
+	}
+	return s.Store.Exec(ctx, query, args...)
+}
+
+// PrepareBatch injects the write faults when the batch is sent, where a real insert fails
+func (s *chaosStore) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	batch, err := s.Store.PrepareBatch(ctx, query, opts...)
+	if err != nil || !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "INSERT") {
+		return batch, err
+	}
+	return &chaosBatch{Batch: batch, ctx: ctx, chaos: s.chaos}, nil
+}
+
+type chaosBatch struct {
+	driver.Batch
+	ctx   context.Context
+	chaos *Chaos
+}
+
+func (b *chaosBatch) Send() error {
+	config := b.chaos.Config()
+	if err := b.chaos.delay(b.ctx, config.WriteLatency); err != nil {
+		b.Batch.Abort()
+		return err
+	}
+	if rand.Float64() < config.WriteErrorRate {
+		b.chaos.writeErrors.Add(1)
+		b.Batch.Abort()
+		return ErrInjected
+	}
+	return b.Batch.Send()
+}
+
+// errRow is a driver.Row that fails with err
+type errRow struct {
+	err error
+}
+
+func (r errRow) Err() error           { return r.err }
+func (r errRow) Scan(...any) error    { return r.err }
+func (r errRow) ScanStruct(any) error { return r.err }
@@ -11,14 +11,21 @@ import (
 	"github.com/aidenappl/monitor-core/structs"
 )
 
-// Conn is the global ClickHouse connection
-var Conn driver.Conn
-
 // Database is the current database name
 var Database string
 
-// Connect establishes a connection to ClickHouse with retry logic
-func Connect(ctx context.Context, addr, database, username, password string) error {
+// Connect establishes a connection to ClickHouse with retry logic and returns it as the store
+func Connect(ctx context.Context, addr, database, username, password string) (Store, error) {
+	conn, err := open(ctx, addr, database, username, password)
+	if err != nil {
+		return nil, err
+	}
+	Database = database
+	return conn, nil
+}
+
+// open connects to a ClickHouse server, retrying with linear backoff
+func open(ctx context.Context, addr, database, username, password string) (driver.Conn, error) {
 	var conn driver.Conn
 	var err error
 
@@ -56,23 +63,45 @@ func Connect(ctx context.Context, addr, database, username, password string) err
 
 		// Success
 		log.Printf("connected to ClickHouse at %s", addr)
-		Conn = conn
-		Database = database
-		return nil
+		return conn, nil
 	}
 
-	return fmt.Errorf("failed to connect to clickhouse after 10 attempts: %w", err)
+	return nil, fmt.Errorf("failed to connect to clickhouse after 10 attempts: %w", err)
 }
 
-// WriteBatch inserts a batch of events into ClickHouse
-func WriteBatch(ctx context.Context, events []*structs.Event) error {
+// writeEvents inserts events through store, with database as the default database
+// Events of tenants and routed envs are written to their own tables
+func writeEvents(ctx context.Context, store Store, database string, events []*structs.Event) error {
 	if len(events) == 0 {
 		return nil
 	}
+	if len(Routes) == 0 && len(Tenants) == 0 {
+		return writeTable(ctx, store, tableFor(database, "", ""), events)
+	}
 
-	batch, err := Conn.PrepareBatch(ctx, fmt.Sprintf(`
-		INSERT INTO %s.events (
+	byTable := make(map[string][]*structs.Event)
+	for _, event := range events {
+		table := tableFor(database, event.Tenant, event.Env)
+		byTable[table] = append(byTable[table], event)
+	}
+	for table, tableEvents := range byTable {
+		if err := writeTable(ctx, store, table, tableEvents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTable inserts events into a single events table
+func writeTable(ctx context.Context, store Store, table string, events []*structs.Event) error {
+	labels := ""
+	for _, column := range LabelColumns {
+		labels += ",\n\t\t\t" + column
+	}
+	batch, err := store.PrepareBatch(ctx, fmt.Sprintf(`
+		INSERT INTO %s (
 			timestamp,
+			received_at,
 			service,
 			env,
 			job_id,
@@ -81,16 +110,17 @@ func WriteBatch(ctx context.Context, events []*structs.Event) error {
 			user_id,
 			name,
 			level,
-			data
+			data%s
 		)
-	`, Database))
+	`, table, labels))
 	if err != nil {
 		return fmt.Errorf("failed to prepare batch: %w", err)
 	}
 
 	for _, event := range events {
-		err := batch.Append(
+		values := []any{
 			event.Timestamp,
+			event.ReceivedAt,
 			event.Service,
 			event.Env,
 			event.JobID,
@@ -100,8 +130,11 @@ func WriteBatch(ctx context.Context, events []*structs.Event) error {
 			event.Name,
 			event.Level,
 			event.DataJSON(),
-		)
-		if err != nil {
+		}
+		for _, column := range LabelColumns {
+			values = append(values, event.Labels[column])
+		}
+		if err := batch.Append(values...); err != nil {
 			return fmt.Errorf("failed to append event to batch: %w", err)
 		}
 	}
@@ -113,17 +146,20 @@ func WriteBatch(ctx context.Context, events []*structs.Event) error {
 	return nil
 }
 
-// Close closes the ClickHouse connection
-func Close() error {
-	if Conn != nil {
-		return Conn.Close()
-	}
-	return nil
+// Writer writes event batches to the store, implementing the pipeline.Writer interface
+type Writer struct {
+	Store Store
+	// Replica, when set, receives a copy of every batch written to the primary
+	Replica *Replica
 }
 
-// Writer wraps WriteBatch to implement the services.Writer interface
-type Writer struct{}
-
+// WriteBatch inserts a batch of events into the store
 func (w *Writer) WriteBatch(ctx context.Context, events []*structs.Event) error {
-	return WriteBatch(ctx, events)
+	if err := writeEvents(ctx, w.Store, Database, events); err != nil {
+		return err
+	}
+	if w.Replica != nil {
+		w.Replica.Enqueue(events)
+	}
+	return nil
 }
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// migrationDatabase is the database name used in migration files; it is replaced
+// with the configured database when migrations run
+const migrationDatabase = "monitor"
+
+var (
+	migrationDatabaseRegex = regexp.MustCompile(`\b` + migrationDatabase + `\.`)
+	createDatabaseRegex    = regexp.MustCompile(`(?i)^CREATE DATABASE IF NOT EXISTS ` + migrationDatabase + `\b`)
+	eventsTableRegex       = regexp.MustCompile(`\b` + migrationDatabase + `\.` + EventsTable + `\b`)
+)
+
+// Migrate applies the .sql migrations in migrations that haven't been applied yet, then
+// the storage layout. Statements that alter the events table are also applied to every
+// routed table so their schemas stay identical.
+func Migrate(ctx context.Context, store Store, migrations fs.FS) error {
+	if err := store.Exec(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", Database)); err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	if err := store.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.schema_migrations
+		(
+			version String,
+			applied_at DateTime DEFAULT now()
+		)
+		ENGINE = ReplacingMergeTree
+		ORDER BY version
+	`, Database)); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied := make(map[string]bool)
+	rows, err := store.Query(ctx, fmt.Sprintf("SELECT version FROM %s.schema_migrations FINAL", Database))
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if applied[file] {
+			continue
+		}
+
+		b, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range splitStatements(string(b)) {
+			for _, query := range expandStatement(stmt) {
+				if err := store.Exec(ctx, query); err != nil {
+					return fmt.Errorf("migration %s failed: %w", file, err)
+				}
+			}
+		}
+
+		if err := store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.schema_migrations (version) VALUES (?)", Database), file); err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", file, err)
+		}
+		log.Printf("applied migration %s", file)
+	}
+
+	return ApplyStorageLayout(ctx, store)
+}
+
+// ApplyStorageLayout creates the routed and tenant tables, adds the label columns and sets
+// the TTL of every table, and rebuilds the Merge table queries read from
+func ApplyStorageLayout(ctx context.Context, store Store) error {
+	defaultTable := fmt.Sprintf("%s.%s", Database, EventsTable)
+
+	statements := []string{
+		fmt.Sprintf("ALTER TABLE %s MODIFY TTL toDate(timestamp) + INTERVAL %d DAY", defaultTable, RetentionDays),
+	}
+	statements = append(statements, labelColumnStatements(defaultTable)...)
+
+	routes := routedTables()
+	databases := map[string]bool{Database: true}
+	tables := map[string]bool{EventsTable: true}
+	for _, route := range routes {
+		statements = append(statements,
+			fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", route.Database),
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s AS %s", route.QualifiedName(), defaultTable),
+			fmt.Sprintf("ALTER TABLE %s MODIFY TTL toDate(timestamp) + INTERVAL %d DAY", route.QualifiedName(), route.RetentionDays),
+		)
+		statements = append(statements, labelColumnStatements(route.QualifiedName())...)
+		databases[route.Database] = true
+		tables[route.Table] = true
+	}
+	tenants := TenantTables()
+	for _, tenant := range tenants {
+		statements = append(statements,
+			fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", tenant.Database),
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s AS %s", tenant.QualifiedName(), defaultTable),
+			fmt.Sprintf("ALTER TABLE %s MODIFY TTL toDate(timestamp) + INTERVAL %d DAY", tenant.QualifiedName(), tenant.RetentionDays),
+		)
+		statements = append(statements, labelColumnStatements(tenant.QualifiedName())...)
+	}
+
+	if RollupRetentionDays > 0 {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s.%s MODIFY TTL hour + INTERVAL %d DAY", Database, RollupTable, RollupRetentionDays))
+	}
+	if MinuteRollupDays > 0 {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s.%s MODIFY TTL minute + INTERVAL %d DAY", Database, MinuteRollupTable, MinuteRollupDays))
+	}
+
+	mergeTable := fmt.Sprintf("%s.%s", Database, MergeTable)
+	if len(routes) > 0 {
+		statements = append(statements, fmt.Sprintf(
+			"CREATE OR REPLACE TABLE %s AS %s ENGINE = Merge(REGEXP('%s'), '%s')",
+			mergeTable, defaultTable, anchoredAlternation(databases), anchoredAlternation(tables),
+		))
+	} else {
+		statements = append(statements, fmt.Sprintf("DROP TABLE IF EXISTS %s", mergeTable))
+	}
+
+	for _, stmt := range statements {
+		if err := store.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to apply storage layout (%s): %w", stmt, err)
+		}
+	}
+
+	log.Printf("storage layout applied: %s kept %d days, %d routed tables, %d tenant tables", defaultTable, RetentionDays, len(routes), len(tenants))
+	return nil
+}
+
+// labelColumnStatements add the configured label columns to table; columns removed from
+// the config are left in place, so their data survives
+func labelColumnStatements(table string) []string {
+	statements := make([]string, len(LabelColumns))
+	for i, column := range LabelColumns {
+		statements[i] = fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s LowCardinality(String) DEFAULT ''", table, column)
+	}
+	return statements
+}
+
+// expandStatement rewrites the migration database name and repeats events table
+// statements for each routed and tenant table
+func expandStatement(stmt string) []string {
+	if createDatabaseRegex.MatchString(stmt) {
+		return []string{createDatabaseRegex.ReplaceAllString(stmt, "CREATE DATABASE IF NOT EXISTS "+Database)}
+	}
+
+	queries := []string{migrationDatabaseRegex.ReplaceAllString(stmt, Database+".")}
+
+	// Routed tables are created from the events table, so only later changes need replaying
+	if eventsTableRegex.MatchString(stmt) && !strings.HasPrefix(strings.ToUpper(stmt), "CREATE TABLE") {
+		for _, route := range append(routedTables(), TenantTables()...) {
+			queries = append(queries, eventsTableRegex.ReplaceAllString(stmt, route.QualifiedName()))
+		}
+	}
+	return queries
+}
+
+// splitStatements splits a migration file on semicolons, dropping comments and blank statements
+func splitStatements(sql string) []string {
+	var lines []string
+	for _, line := range strings.Split(sql, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	var statements []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
+
+func anchoredAlternation(names map[string]bool) string {
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return "^(" + strings.Join(list, "|") + ")$"
+}
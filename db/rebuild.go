@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// createTableRegex matches the table name of a CREATE TABLE statement
+var createTableRegex = regexp.MustCompile(`(?is)^CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?[a-zA-Z0-9_.]+`)
+
+// RebuildOptions configures a blue/green rebuild of an events table
+type RebuildOptions struct {
+	// Table is the table to rebuild, database.table or a table in the default database
+	Table string
+	// Schema is a CREATE TABLE statement for the new table; its table name is replaced
+	Schema string
+	// KeepOld keeps the previous table as <table>_old_<timestamp> instead of dropping it
+	KeepOld bool
+}
+
+// Rebuild replaces an events table with one created from a new schema without downtime:
+//
+//  1. create <table>_rebuild from the schema
+//  2. dual-write: a materialized view copies every new insert into it
+//  3. copy rows inserted before the view existed, one day per INSERT SELECT
+//  4. atomically EXCHANGE the two tables, then copy rows that landed in the
+//     old table between dropping the view and the exchange
+//
+// Rows inserted while the view is being created or dropped may be copied twice.
+// EXCHANGE TABLES requires the Atomic database engine (the default).
+func Rebuild(ctx context.Context, store Store, opts RebuildOptions) error {
+	database, table := Database, opts.Table
+	if db, t, ok := strings.Cut(opts.Table, "."); ok {
+		database, table = db, t
+	}
+	if !identifierRegex.MatchString(database) || !identifierRegex.MatchString(table) {
+		return fmt.Errorf("invalid table name %q", opts.Table)
+	}
+	statements := splitStatements(opts.Schema)
+	if len(statements) != 1 || !createTableRegex.MatchString(statements[0]) {
+		return fmt.Errorf("schema must be a single CREATE TABLE statement")
+	}
+
+	retention, err := tableRetention(database, table)
+	if err != nil {
+		return err
+	}
+
+	current := database + "." + table
+	shadow := current + "_rebuild"
+	view := current + "_rebuild_mv"
+
+	var engine string
+	if err := store.QueryRow(ctx, "SELECT engine FROM system.databases WHERE name = ?", database).Scan(&engine); err != nil {
+		return fmt.Errorf("failed to read database %s: %w", database, err)
+	}
+	if engine != "Atomic" {
+		return fmt.Errorf("database %s uses the %s engine; EXCHANGE TABLES requires Atomic", database, engine)
+	}
+	if exists, err := tableExists(ctx, store, database, table); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("table %s does not exist", current)
+	}
+	if exists, err := tableExists(ctx, store, database, table+"_rebuild"); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("%s already exists from an earlier rebuild; drop it and %s first", shadow, view)
+	}
+
+	// 1. New table
+	stmt := createTableRegex.ReplaceAllString(statements[0], "CREATE TABLE "+shadow)
+	if err := store.Exec(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to create %s: %w", shadow, err)
+	}
+	if err := store.Exec(ctx, fmt.Sprintf("ALTER TABLE %s MODIFY TTL toDate(timestamp) + INTERVAL %d DAY", shadow, retention)); err != nil {
+		return fmt.Errorf("failed to set TTL on %s: %w", shadow, err)
+	}
+
+	columns, err := sharedColumns(ctx, store, database, table, table+"_rebuild")
+	if err != nil {
+		return err
+	}
+	columnList := strings.Join(columns, ", ")
+	log.Printf("rebuild: created %s, copying columns %s", shadow, columnList)
+
+	// 2. Dual-write
+	var cutover time.Time
+	if err := store.QueryRow(ctx, "SELECT now64(3)").Scan(&cutover); err != nil {
+		return err
+	}
+	if err := store.Exec(ctx, fmt.Sprintf("CREATE MATERIALIZED VIEW %s TO %s AS SELECT %s FROM %s", view, shadow, columnList, current)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", view, err)
+	}
+	log.Printf("rebuild: dual-writing new inserts into %s", shadow)
+
+	// 3. Copy existing rows
+	if err := copyByDay(ctx, store, current, shadow, columnList, fmt.Sprintf("_inserted_at < %s", dateTime64Literal(cutover))); err != nil {
+		return fmt.Errorf("%w (the view %s is still dual-writing; drop it and %s to start over)", err, view, shadow)
+	}
+
+	// 4. Swap
+	var viewDropped time.Time
+	if err := store.QueryRow(ctx, "SELECT now64(3)").Scan(&viewDropped); err != nil {
+		return err
+	}
+	if err := store.Exec(ctx, "DROP VIEW "+view); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", view, err)
+	}
+	if err := store.Exec(ctx, fmt.Sprintf("EXCHANGE TABLES %s AND %s", current, shadow)); err != nil {
+		return fmt.Errorf("failed to exchange %s and %s: %w", current, shadow, err)
+	}
+	log.Printf("rebuild: %s now uses the new schema", current)
+
+	// shadow now holds the old table
+	if err := store.Exec(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE _inserted_at >= %s",
+		current, columnList, columnList, shadow, dateTime64Literal(viewDropped))); err != nil {
+		return fmt.Errorf("failed to copy rows inserted during the swap from %s: %w", shadow, err)
+	}
+
+	if opts.KeepOld {
+		old := fmt.Sprintf("%s_old_%s", current, time.Now().UTC().Format("20060102150405"))
+		if err := store.Exec(ctx, fmt.Sprintf("RENAME TABLE %s TO %s", shadow, old)); err != nil {
+			return fmt.Errorf("failed to rename old table: %w", err)
+		}
+		log.Printf("rebuild: previous table kept as %s", old)
+	} else if err := store.Exec(ctx, "DROP TABLE "+shadow); err != nil {
+		return fmt.Errorf("failed to drop old table: %w", err)
+	}
+
+	log.Printf("rebuild: %s complete", current)
+	return nil
+}
+
+// copyByDay copies the rows of src matching where into dst, one day per INSERT SELECT,
+// logging progress after each day
+func copyByDay(ctx context.Context, store Store, src, dst, columns, where string) error {
+	rows, err := store.Query(ctx, fmt.Sprintf("SELECT toDate(timestamp) AS day, count() FROM %s WHERE %s GROUP BY day ORDER BY day", src, where))
+	if err != nil {
+		return fmt.Errorf("failed to plan copy: %w", err)
+	}
+	type chunk struct {
+		day  time.Time
+		rows uint64
+	}
+	var chunks []chunk
+	var total uint64
+	for rows.Next() {
+		var c chunk
+		if err := rows.Scan(&c.day, &c.rows); err != nil {
+			rows.Close()
+			return err
+		}
+		chunks = append(chunks, c)
+		total += c.rows
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("rebuild: copying %d rows over %d days", total, len(chunks))
+	start := time.Now()
+	var copied uint64
+	for i, c := range chunks {
+		day := c.day.Format("2006-01-02")
+		if err := store.Exec(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE toDate(timestamp) = '%s' AND %s",
+			dst, columns, columns, src, day, where)); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", day, err)
+		}
+
+		copied += c.rows
+		elapsed := time.Since(start)
+		remaining := time.Duration(float64(elapsed) / float64(copied) * float64(total-copied))
+		log.Printf("rebuild: copied %s (%d/%d days, %d/%d rows, %.1f%%, ~%s left)",
+			day, i+1, len(chunks), copied, total, 100*float64(copied)/float64(total), remaining.Round(time.Second))
+	}
+	return nil
+}
+
+// sharedColumns returns the columns present in both tables, in the order of to. Columns
+// only in the new table are filled by their defaults.
+func sharedColumns(ctx context.Context, store Store, database, from, to string) ([]string, error) {
+	rows, err := store.Query(ctx, `
+		SELECT name FROM system.columns
+		WHERE database = ? AND table = ?
+			AND default_kind NOT IN ('MATERIALIZED', 'ALIAS')
+			AND name IN (SELECT name FROM system.columns WHERE database = ? AND table = ?)
+		ORDER BY position
+	`, database, to, database, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("the new schema shares no columns with the current table")
+	}
+	for _, required := range []string{"timestamp", "_inserted_at"} {
+		found := false
+		for _, name := range columns {
+			found = found || name == required
+		}
+		if !found {
+			return nil, fmt.Errorf("the new schema must keep the %s column", required)
+		}
+	}
+	return columns, rows.Err()
+}
+
+func tableExists(ctx context.Context, store Store, database, table string) (bool, error) {
+	var count uint64
+	if err := store.QueryRow(ctx, "SELECT count() FROM system.tables WHERE database = ? AND name = ?", database, table).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check for %s.%s: %w", database, table, err)
+	}
+	return count > 0, nil
+}
+
+// tableRetention returns the configured retention of an events table
+func tableRetention(database, table string) (int, error) {
+	if database == Database && table == EventsTable {
+		return RetentionDays, nil
+	}
+	for _, route := range Routes {
+		if route.Database == database && route.Table == table {
+			return route.RetentionDays, nil
+		}
+	}
+	return 0, fmt.Errorf("%s.%s is not the events table or a routed table", database, table)
+}
+
+func dateTime64Literal(t time.Time) string {
+	return fmt.Sprintf("toDateTime64('%s', 3, 'UTC')", t.UTC().Format("2006-01-02 15:04:05.000"))
+}
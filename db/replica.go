@@ -0,0 +1,296 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	// replicaRetries is how many times a batch is written to the replica before it is dead-lettered
+	replicaRetries = 5
+
+	// replicaMaxBackoff caps the delay between replica write attempts
+	replicaMaxBackoff = 30 * time.Second
+
+	// replicaReplayInterval is how often dead-lettered batches are retried
+	replicaReplayInterval = time.Minute
+
+	// replicaBufferBatches is how many batches may wait for the replica before new ones are dead-lettered
+	replicaBufferBatches = 64
+)
+
+// Replica asynchronously copies every batch written to the primary cluster to a
+// secondary ClickHouse cluster. Batches that keep failing are written to a
+// dead-letter directory as NDJSON and replayed until the replica accepts them,
+// so the primary write path never waits on the secondary.
+type Replica struct {
+	store    Store
+	database string
+	dlqDir   string
+	batches  chan []*structs.Event
+
+	mu     sync.Mutex
+	closed bool
+
+	written    atomic.Int64
+	retried    atomic.Int64
+	deadLetter atomic.Int64
+	dropped    atomic.Int64
+}
+
+// ReplicaStats is a snapshot of replica counters
+type ReplicaStats struct {
+	Pending    int   `json:"pending"`
+	Written    int64 `json:"written"`
+	Retried    int64 `json:"retried"`
+	DeadLetter int64 `json:"dead_letter"`
+	Dropped    int64 `json:"dropped"`
+}
+
+// ConnectReplica connects to the secondary cluster. Without a dlqDir, batches that
+// exhaust their retries are dropped.
+func ConnectReplica(ctx context.Context, addr, database, username, password, dlqDir string) (*Replica, error) {
+	conn, err := open(ctx, addr, database, username, password)
+	if err != nil {
+		return nil, err
+	}
+	if dlqDir != "" {
+		if err := os.MkdirAll(dlqDir, 0o755); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create replica dlq dir: %w", err)
+		}
+	}
+	return &Replica{
+		store:    conn,
+		database: database,
+		dlqDir:   dlqDir,
+		batches:  make(chan []*structs.Event, replicaBufferBatches),
+	}, nil
+}
+
+// Wrap replaces the replica's store with wrap(store), e.g. to inject faults. It must be
+// called before Run.
+func (r *Replica) Wrap(wrap func(Store) Store) {
+	r.store = wrap(r.store)
+}
+
+// Enqueue schedules a copy of events for the replica without blocking
+func (r *Replica) Enqueue(events []*structs.Event) {
+	if len(events) == 0 {
+		return
+	}
+	// The batcher reuses its batch slice after a write
+	batch := make([]*structs.Event, len(events))
+	copy(batch, events)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		r.spill(batch)
+		return
+	}
+	select {
+	case r.batches <- batch:
+	default:
+		r.spill(batch)
+	}
+}
+
+// Run writes queued batches to the replica and periodically replays the
+// dead-letter directory until ctx is cancelled
+func (r *Replica) Run(ctx context.Context) {
+	ticker := time.NewTicker(replicaReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case batch := <-r.batches:
+			r.write(ctx, batch)
+		case <-ticker.C:
+			r.replay(ctx)
+		}
+	}
+}
+
+// Close dead-letters batches still waiting for the replica and closes the connection.
+// It must be called after Run has returned and the primary writer has flushed.
+func (r *Replica) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	for {
+		select {
+		case batch := <-r.batches:
+			r.spill(batch)
+			continue
+		default:
+		}
+		break
+	}
+	r.mu.Unlock()
+	return r.store.Close()
+}
+
+// Stats returns the replica counters
+func (r *Replica) Stats() ReplicaStats {
+	return ReplicaStats{
+		Pending:    len(r.batches),
+		Written:    r.written.Load(),
+		Retried:    r.retried.Load(),
+		DeadLetter: r.deadLetter.Load(),
+		Dropped:    r.dropped.Load(),
+	}
+}
+
+// write sends a batch to the replica with exponential backoff, dead-lettering it on failure
+func (r *Replica) write(ctx context.Context, batch []*structs.Event) {
+	backoff := time.Second
+	var err error
+	for attempt := 1; attempt <= replicaRetries; attempt++ {
+		if err = writeEvents(ctx, r.store, r.database, batch); err == nil {
+			r.written.Add(int64(len(batch)))
+			return
+		}
+		if attempt == replicaRetries {
+			break
+		}
+		r.retried.Add(1)
+		select {
+		case <-ctx.Done():
+			r.mu.Lock()
+			r.spill(batch)
+			r.mu.Unlock()
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, replicaMaxBackoff)
+	}
+	log.Printf("replica write failed after %d attempts: %v", replicaRetries, err)
+	r.mu.Lock()
+	r.spill(batch)
+	r.mu.Unlock()
+}
+
+// spill writes a batch to the dead-letter directory. Callers must hold r.mu.
+func (r *Replica) spill(batch []*structs.Event) {
+	if r.dlqDir == "" {
+		r.dropped.Add(int64(len(batch)))
+		return
+	}
+
+	name := filepath.Join(r.dlqDir, fmt.Sprintf("%d.ndjson", time.Now().UnixNano()))
+	if err := writeNDJSON(name, batch); err != nil {
+		log.Printf("failed to dead-letter replica batch: %v", err)
+		r.dropped.Add(int64(len(batch)))
+		return
+	}
+	r.deadLetter.Add(int64(len(batch)))
+}
+
+// replay retries dead-lettered batches oldest first, stopping at the first failure
+func (r *Replica) replay(ctx context.Context) {
+	if r.dlqDir == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(r.dlqDir)
+	if err != nil {
+		log.Printf("failed to read replica dlq dir: %v", err)
+		return
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".ndjson") {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		path := filepath.Join(r.dlqDir, file)
+		batch, err := readNDJSON(path)
+		if err != nil {
+			log.Printf("skipping unreadable replica dlq file %s: %v", file, err)
+			continue
+		}
+		if err := writeEvents(ctx, r.store, r.database, batch); err != nil {
+			log.Printf("replica dlq replay failed, will retry: %v", err)
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("failed to remove replayed replica dlq file %s: %v", file, err)
+			return
+		}
+		r.written.Add(int64(len(batch)))
+		r.deadLetter.Add(-int64(len(batch)))
+	}
+}
+
+// spilledEvent is a dead-lettered event, which keeps its tenant so it is replayed into the
+// tenant's table
+type spilledEvent struct {
+	*structs.Event
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// writeNDJSON writes events to path atomically, one JSON object per line
+func writeNDJSON(path string, events []*structs.Event) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, event := range events {
+		if err := enc.Encode(spilledEvent{event, event.Tenant}); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readNDJSON reads events written by writeNDJSON
+func readNDJSON(path string) ([]*structs.Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []*structs.Event
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		event := spilledEvent{Event: &structs.Event{}}
+		if err := dec.Decode(&event); err != nil {
+			return nil, err
+		}
+		event.Event.Tenant = event.Tenant
+		events = append(events, event.Event)
+	}
+	return events, nil
+}
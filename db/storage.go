@@ -0,0 +1,282 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventsTable is the default events table
+const EventsTable = "events"
+
+// MergeTable is the read-only table spanning the default and routed events tables
+const MergeTable = "events_all"
+
+// RollupTable keeps hourly aggregates of events for longer than the events themselves
+const RollupTable = "events_hourly"
+
+// MinuteRollupTable keeps per-minute aggregates of events
+const MinuteRollupTable = "events_minutely"
+
+// identifierRegex validates database and table names from configuration
+var identifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// TableRoute stores the events of one env, or of one tenant, in their own table with
+// independent retention
+type TableRoute struct {
+	Env           string
+	Tenant        string
+	Database      string
+	Table         string
+	RetentionDays int
+}
+
+// QualifiedName returns database.table
+func (r TableRoute) QualifiedName() string {
+	return r.Database + "." + r.Table
+}
+
+var (
+	// Routes maps env to its table (set by ConfigureStorage)
+	Routes map[string]TableRoute
+	// Tenants maps tenant to the table holding all of its events, which the Merge table
+	// doesn't span (set by ConfigureTenants)
+	Tenants map[string]TableRoute
+	// RetentionDays is the retention of the default events table
+	RetentionDays = 30
+	// RollupRetentionDays is how long hourly rollups are kept, 0 when they are disabled
+	RollupRetentionDays int
+	// MinuteRollupDays is how long minute rollups are kept, 0 when they are disabled
+	MinuteRollupDays int
+	// ReplayTable holds events replayed from archives, or nil without one (set by
+	// ConfigureReplay)
+	ReplayTable *TableRoute
+	// LabelColumns are the extra label columns of every events table, in the order they
+	// are written (set by ConfigureLabelColumns)
+	LabelColumns []string
+)
+
+// builtinColumns are the columns every events table has, which label columns can't reuse
+var builtinColumns = map[string]bool{
+	"timestamp": true, "received_at": true, "service": true, "env": true, "job_id": true,
+	"request_id": true, "trace_id": true, "user_id": true, "name": true, "level": true,
+	"data": true, "_inserted_at": true,
+}
+
+// ConfigureLabelColumns sets the extra label columns, e.g. region or cluster. Each is a
+// LowCardinality(String) column added to every events table by ApplyStorageLayout.
+func ConfigureLabelColumns(columns []string) error {
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if !identifierRegex.MatchString(column) {
+			return fmt.Errorf("label column %q: invalid column name", column)
+		}
+		if builtinColumns[strings.ToLower(column)] || strings.HasPrefix(column, "_") {
+			return fmt.Errorf("label column %q: name is reserved", column)
+		}
+		if seen[column] {
+			return fmt.Errorf("label column %q: listed twice", column)
+		}
+		seen[column] = true
+	}
+	LabelColumns = columns
+	return nil
+}
+
+// ConfigureStorage sets the default retention and parses env routes of the form
+// env=[database.]table:days, e.g. {"staging": "events_staging:7", "production": "monitor_prod.events:90"}
+func ConfigureStorage(retentionDays int, routes map[string]string) error {
+	RetentionDays = retentionDays
+	Routes = make(map[string]TableRoute, len(routes))
+
+	for env, spec := range routes {
+		target, days, ok := strings.Cut(spec, ":")
+		if !ok {
+			return fmt.Errorf("route %s: expected [database.]table:days", env)
+		}
+		retention, err := strconv.Atoi(days)
+		if err != nil || retention <= 0 {
+			return fmt.Errorf("route %s: retention must be a positive number of days", env)
+		}
+
+		route := TableRoute{Env: env, Database: Database, Table: target, RetentionDays: retention}
+		if database, table, ok := strings.Cut(target, "."); ok {
+			route.Database, route.Table = database, table
+		}
+		if !identifierRegex.MatchString(route.Database) || !identifierRegex.MatchString(route.Table) {
+			return fmt.Errorf("route %s: invalid table name %q", env, target)
+		}
+		if route.Database == Database && (route.Table == EventsTable || route.Table == MergeTable) {
+			return fmt.Errorf("route %s: table %s is reserved", env, route.QualifiedName())
+		}
+		for _, other := range Routes {
+			if other.QualifiedName() == route.QualifiedName() && other.RetentionDays != route.RetentionDays {
+				return fmt.Errorf("routes %s and %s share %s with different retention", other.Env, env, route.QualifiedName())
+			}
+		}
+		Routes[env] = route
+	}
+	return nil
+}
+
+// ConfigureReplay sets the [database.]table archived events are replayed into, kept for
+// retentionDays. It is created and altered like a routed table, and is read through the
+// Merge table, but nothing is routed to it. An empty target disables replay.
+func ConfigureReplay(target string, retentionDays int) error {
+	ReplayTable = nil
+	if target == "" {
+		return nil
+	}
+	if retentionDays <= 0 {
+		return fmt.Errorf("replay table: retention must be a positive number of days")
+	}
+
+	table := TableRoute{Database: Database, Table: target, RetentionDays: retentionDays}
+	if database, name, ok := strings.Cut(target, "."); ok {
+		table.Database, table.Table = database, name
+	}
+	if !identifierRegex.MatchString(table.Database) || !identifierRegex.MatchString(table.Table) {
+		return fmt.Errorf("replay table: invalid table name %q", target)
+	}
+	if table.Database == Database && (table.Table == EventsTable || table.Table == MergeTable) {
+		return fmt.Errorf("replay table: table %s is reserved", table.QualifiedName())
+	}
+	for _, route := range Routes {
+		if route.QualifiedName() == table.QualifiedName() {
+			return fmt.Errorf("replay table: %s is the table of env %s", table.QualifiedName(), route.Env)
+		}
+	}
+	ReplayTable = &table
+	return nil
+}
+
+// ConfigureTenants parses tenant tables of the form tenant=[database.]table:days, e.g.
+// {"acme": "acme.events:90"}. A tenant's events are written to and read from its table
+// only, so it must be apart from the tables the Merge table spans; call it after
+// ConfigureStorage and ConfigureReplay.
+func ConfigureTenants(tenants map[string]string) error {
+	Tenants = make(map[string]TableRoute, len(tenants))
+
+	shared := map[string]bool{Database: true}
+	sharedTables := map[string]bool{EventsTable: true, MergeTable: true}
+	for _, route := range routedTables() {
+		shared[route.Database] = true
+		sharedTables[route.Table] = true
+	}
+
+	for tenant, spec := range tenants {
+		target, days, ok := strings.Cut(spec, ":")
+		if !ok {
+			return fmt.Errorf("tenant %s: expected [database.]table:days", tenant)
+		}
+		retention, err := strconv.Atoi(days)
+		if err != nil || retention <= 0 {
+			return fmt.Errorf("tenant %s: retention must be a positive number of days", tenant)
+		}
+
+		route := TableRoute{Tenant: tenant, Database: Database, Table: target, RetentionDays: retention}
+		if database, table, ok := strings.Cut(target, "."); ok {
+			route.Database, route.Table = database, table
+		}
+		if !identifierRegex.MatchString(route.Database) || !identifierRegex.MatchString(route.Table) {
+			return fmt.Errorf("tenant %s: invalid table name %q", tenant, target)
+		}
+		// The Merge table reads every table of these names in these databases
+		if shared[route.Database] && sharedTables[route.Table] {
+			return fmt.Errorf("tenant %s: table %s would be read through %s", tenant, route.QualifiedName(), MergeTable)
+		}
+		for _, other := range Tenants {
+			if other.QualifiedName() == route.QualifiedName() {
+				return fmt.Errorf("tenants %s and %s share %s", other.Tenant, tenant, route.QualifiedName())
+			}
+		}
+		Tenants[tenant] = route
+	}
+	return nil
+}
+
+// TenantTable returns the table of tenant, or false when it has none
+func TenantTable(tenant string) (TableRoute, bool) {
+	route, ok := Tenants[tenant]
+	return route, ok
+}
+
+// ReadTable returns the table queries should read from; with routes or a replay table
+// configured this is a Merge table spanning every events table
+func ReadTable() string {
+	if len(Routes) == 0 && ReplayTable == nil {
+		return fmt.Sprintf("%s.%s", Database, EventsTable)
+	}
+	return fmt.Sprintf("%s.%s", Database, MergeTable)
+}
+
+// tableFor returns the table events of a tenant or env are written to, where database
+// replaces the primary database (replicas may use a different database name)
+func tableFor(database, tenant, env string) string {
+	route, ok := Tenants[tenant]
+	if !ok {
+		route, ok = Routes[env]
+	}
+	if ok {
+		if route.Database == Database {
+			return database + "." + route.Table
+		}
+		return route.QualifiedName()
+	}
+	return fmt.Sprintf("%s.%s", database, EventsTable)
+}
+
+// Retention returns how long events of a tenant or env are kept
+func Retention(tenant, env string) time.Duration {
+	days := RetentionDays
+	if route, ok := Tenants[tenant]; ok {
+		days = route.RetentionDays
+	} else if route, ok := Routes[env]; ok {
+		days = route.RetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// EventTables returns the default events table followed by each distinct routed table
+func EventTables() []TableRoute {
+	tables := []TableRoute{{Database: Database, Table: EventsTable, RetentionDays: RetentionDays}}
+	seen := map[string]bool{tables[0].QualifiedName(): true}
+	for _, route := range routedTables() {
+		if !seen[route.QualifiedName()] {
+			seen[route.QualifiedName()] = true
+			tables = append(tables, route)
+		}
+	}
+	return tables
+}
+
+// TenantTables returns each tenant table, sorted for stable output
+func TenantTables() []TableRoute {
+	tables := make([]TableRoute, 0, len(Tenants))
+	for _, route := range Tenants {
+		tables = append(tables, route)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].QualifiedName() < tables[j].QualifiedName()
+	})
+	return tables
+}
+
+// routedTables returns every routed table, sorted for stable output, followed by the
+// replay table
+func routedTables() []TableRoute {
+	routes := make([]TableRoute, 0, len(Routes)+1)
+	for _, route := range Routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].QualifiedName() < routes[j].QualifiedName()
+	})
+	if ReplayTable != nil {
+		routes = append(routes, *ReplayTable)
+	}
+	return routes
+}
@@ -0,0 +1,18 @@
+package db
+
+import (
+	"context"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// Store is the ClickHouse access monitor-core needs. A driver.Conn satisfies it, and a
+// fake can stand in for it in tests or to put another backend behind the same queries.
+type Store interface {
+	Query(ctx context.Context, query string, args ...any) (driver.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...any) driver.Row
+	Exec(ctx context.Context, query string, args ...any) error
+	PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
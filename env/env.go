@@ -3,19 +3,139 @@ package env
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var (
 	Port               = getEnv("HTTP_PORT", "8080")
+	Listeners          = getEnvMap("LISTENERS")
+	AdminAddr          = getEnv("ADMIN_ADDR", "")
+	IngestTimeout      = getEnvDuration("INGEST_TIMEOUT", 15*time.Second)
+	QueryTimeout       = getEnvDuration("QUERY_TIMEOUT", 60*time.Second)
+	ExportTimeout      = getEnvDuration("EXPORT_TIMEOUT", 10*time.Minute)
+	StreamTimeout      = getEnvDuration("STREAM_TIMEOUT", time.Hour)
+	DecompressedLimit  = getEnvInt("MAX_DECOMPRESSED_SIZE", 100*1024*1024)
+	MaxResultSeries    = getEnvInt("MAX_RESULT_SERIES", 10000)
+	MaxResultPoints    = getEnvInt("MAX_RESULT_POINTS", 1000000)
+	MaxResultBytes     = getEnvInt("MAX_RESULT_BYTES", 64*1024*1024)
+	QueryCacheTTL      = getEnvDuration("QUERY_CACHE_TTL", 0)
+	DefaultQueryRange  = getEnvDuration("QUERY_DEFAULT_RANGE", 24*time.Hour)
+	MaxQueryRange      = getEnvDuration("QUERY_MAX_RANGE", 90*24*time.Hour)
+	QueryRangeDefaults = getEnvMap("QUERY_DEFAULT_RANGES")
+	QueryRangeMaxes    = getEnvMap("QUERY_MAX_RANGES")
 	ClickHouseAddr     = getEnv("CLICKHOUSE_ADDR", "localhost:9000")
 	ClickHouseDatabase = getEnv("CLICKHOUSE_DATABASE", "monitor")
 	ClickHouseUsername = getEnv("CLICKHOUSE_USERNAME", "default")
 	ClickHousePassword = getEnv("CLICKHOUSE_PASSWORD", "")
+	ReplicaAddr        = getEnv("REPLICA_CLICKHOUSE_ADDR", "")
+	ReplicaDatabase    = getEnv("REPLICA_CLICKHOUSE_DATABASE", ClickHouseDatabase)
+	ReplicaUsername    = getEnv("REPLICA_CLICKHOUSE_USERNAME", ClickHouseUsername)
+	ReplicaPassword    = getEnv("REPLICA_CLICKHOUSE_PASSWORD", ClickHousePassword)
+	ReplicaDLQDir      = getEnv("REPLICA_DLQ_DIR", "")
 	APIKey             = getEnv("API_KEY", "")
+	AdminAPIKey        = getEnv("ADMIN_API_KEY", "")
+	AccessRolesConfig  = getEnv("ACCESS_ROLES_CONFIG", "")
+	SensitiveKeys      = getEnvList("SENSITIVE_KEYS")
+	SensitiveKeysMode  = getEnv("SENSITIVE_KEYS_MODE", "mask")
+	OIDCIssuer         = getEnv("OIDC_ISSUER", "")
+	OIDCClientID       = getEnv("OIDC_CLIENT_ID", "")
+	OIDCClientSecret   = getEnv("OIDC_CLIENT_SECRET", "")
+	OIDCRedirectURL    = getEnv("OIDC_REDIRECT_URL", "")
+	OIDCGroupsClaim    = getEnv("OIDC_GROUPS_CLAIM", "groups")
+	OIDCRoleMap        = getEnvMap("OIDC_ROLE_MAP")
+	OIDCSessionSecret  = getEnv("OIDC_SESSION_SECRET", "")
+	OIDCSessionTTL     = getEnvDuration("OIDC_SESSION_TTL", 12*time.Hour)
 	BatchSize          = getEnvInt("BATCH_SIZE", 1000)
 	FlushInterval      = getEnvDuration("FLUSH_INTERVAL", 5*time.Second)
 	QueueSize          = getEnvInt("QUEUE_SIZE", 100000)
+	WatchdogIntervals  = getEnvInt("WATCHDOG_INTERVALS", 10)
+	WatchdogRestart    = getEnvBool("WATCHDOG_RESTART", false)
+	IngestRateLimit    = getEnvFloat("INGEST_RATE_LIMIT", 0)
+	IngestRateBurst    = getEnvInt("INGEST_RATE_BURST", 0)
+	IngestQuotas       = getEnvMap("INGEST_QUOTAS")
+	QuotaShedOrder     = getEnvList("QUOTA_SHED_ORDER")
+	QuotaWindow        = getEnvDuration("QUOTA_WINDOW", time.Minute)
+	TailSampleRate     = getEnvFloat("TAIL_SAMPLE_RATE", 1)
+	TailSampleWait     = getEnvDuration("TAIL_SAMPLE_WAIT", 10*time.Second)
+	TailSampleSlow     = getEnvDuration("TAIL_SAMPLE_SLOW", 0)
+	TailSampleTraces   = getEnvInt("TAIL_SAMPLE_MAX_TRACES", 10000)
+	TailSampleEvents   = getEnvInt("TAIL_SAMPLE_MAX_EVENTS", 1000)
+	RetentionDays      = getEnvInt("RETENTION_DAYS", 30)
+	EnvRoutes          = getEnvMap("ENV_ROUTES")
+	TenantTables       = getEnvMap("TENANT_TABLES")
+	RollupRetention    = getEnvInt("ROLLUP_RETENTION_DAYS", 0)
+	RollupMinuteDays   = getEnvInt("ROLLUP_MINUTE_RETENTION_DAYS", 0)
+	RollupFields       = getEnvList("ROLLUP_FIELDS")
+	SpanMetrics        = getEnvBool("SPAN_METRICS", false)
+	LabelColumns       = getEnvList("LABEL_COLUMNS")
+	AutoMigrate        = getEnvBool("AUTO_MIGRATE", false)
+	TimestampPolicy    = getEnv("TIMESTAMP_POLICY", "record")
+	MaxClockSkew       = getEnvDuration("MAX_CLOCK_SKEW", 5*time.Minute)
+	MaxEventAge        = getEnvDuration("MAX_EVENT_AGE", 24*time.Hour)
+	LevelMap           = getEnvMap("LEVEL_MAP")
+	AllowedLevels      = getEnvList("ALLOWED_LEVELS")
+	DefaultLevel       = getEnv("DEFAULT_LEVEL", "info")
+	StrictLevels       = getEnvBool("STRICT_LEVELS", false)
+	DerivedFields      = getEnv("DERIVED_FIELDS", "")
+	MaxEventSize       = getEnvInt("MAX_EVENT_SIZE", 256*1024)
+	MaxFieldSize       = getEnvInt("MAX_FIELD_SIZE", 32*1024)
+	OffloadThreshold   = getEnvInt("PAYLOAD_OFFLOAD_THRESHOLD", 64*1024)
+	PayloadBucket      = getEnv("PAYLOAD_BUCKET", "")
+	PayloadPrefix      = getEnv("PAYLOAD_PREFIX", "payloads/")
+	PayloadEndpoint    = getEnv("PAYLOAD_ENDPOINT", "")
+	PayloadRegion      = getEnv("PAYLOAD_REGION", "us-east-1")
+	PayloadAccessKeyID = getEnv("PAYLOAD_ACCESS_KEY_ID", "")
+	PayloadSecretKey   = getEnv("PAYLOAD_SECRET_ACCESS_KEY", "")
+	ArchiveBucket      = getEnv("ARCHIVE_BUCKET", "")
+	ArchivePrefix      = getEnv("ARCHIVE_PREFIX", "archive/")
+	ArchiveEndpoint    = getEnv("ARCHIVE_ENDPOINT", "")
+	ArchiveRegion      = getEnv("ARCHIVE_REGION", "us-east-1")
+	ArchiveAccessKeyID = getEnv("ARCHIVE_ACCESS_KEY_ID", "")
+	ArchiveSecretKey   = getEnv("ARCHIVE_SECRET_ACCESS_KEY", "")
+	ReplayTable        = getEnv("REPLAY_TABLE", "")
+	ReplayRetention    = getEnvInt("REPLAY_RETENTION_DAYS", 14)
+	UIEnabled          = getEnvBool("UI_ENABLED", true)
+	SelfMonitoring     = getEnvBool("SELF_MONITORING", true)
+	SlowQueryThreshold = getEnvDuration("SLOW_QUERY_THRESHOLD", 2*time.Second)
+	HealthInterval     = getEnvDuration("HEALTH_CHECK_INTERVAL", 30*time.Second)
+	HealthHistory      = getEnvDuration("HEALTH_HISTORY", 24*time.Hour)
+	ChaosMode          = getEnvBool("CHAOS_MODE", false)
+	ChaosWriteErrors   = getEnvFloat("CHAOS_WRITE_ERROR_RATE", 0)
+	ChaosWriteLatency  = getEnvDuration("CHAOS_WRITE_LATENCY", 0)
+	ChaosQueryErrors   = getEnvFloat("CHAOS_QUERY_ERROR_RATE", 0)
+	ChaosQueryLatency  = getEnvDuration("CHAOS_QUERY_LATENCY", 0)
+	StatsDAddr         = getEnv("STATSD_ADDR", "")
+	StatsDService      = getEnv("STATSD_SERVICE", "statsd")
+	SyslogUDPAddr      = getEnv("SYSLOG_UDP_ADDR", "")
+	SyslogTCPAddr      = getEnv("SYSLOG_TCP_ADDR", "")
+	SyslogService      = getEnv("SYSLOG_SERVICE", "syslog")
+	NATSURL            = getEnv("NATS_URL", "")
+	NATSStream         = getEnv("NATS_STREAM", "")
+	NATSSubject        = getEnv("NATS_SUBJECT", "")
+	NATSConsumer       = getEnv("NATS_CONSUMER", "monitor-core")
+	LokiService        = getEnv("LOKI_SERVICE", "loki")
+	PromService        = getEnv("PROM_SERVICE", "prometheus")
+	SentryProjects     = getEnvMap("SENTRY_PROJECTS")
+	RUMToken           = getEnv("RUM_TOKEN", "")
+	RUMAllowedOrigins  = getEnvList("RUM_ALLOWED_ORIGINS")
+	RUMService         = getEnv("RUM_SERVICE", "web")
+	WebhookConfig      = getEnv("WEBHOOK_CONFIG", "")
+	WebhookSecrets     = getEnvMap("WEBHOOK_SECRETS")
+	AlertsEnabled      = getEnvBool("ALERTS_ENABLED", false)
+	AlertEvalInterval  = getEnvDuration("ALERT_EVAL_INTERVAL", time.Minute)
+	AlertChannels      = getEnv("ALERT_CHANNELS", "")
+	AlertExternalURL   = getEnv("ALERT_EXTERNAL_URL", "")
+	AlertSourceService = getEnv("ALERTMANAGER_SERVICE", "alertmanager")
+	SchemaDrift        = getEnvBool("SCHEMA_DRIFT_ENABLED", false)
+	DriftInterval      = getEnvDuration("SCHEMA_DRIFT_INTERVAL", 10*time.Minute)
+	DriftChannel       = getEnv("SCHEMA_DRIFT_CHANNEL", "")
+	StatusPageToken    = getEnv("STATUS_PAGE_TOKEN", "")
+	StatusPageConfig   = getEnv("STATUS_PAGE_CONFIG", "")
+	VaultAddr          = getEnv("VAULT_ADDR", "")
+	VaultToken         = getEnv("VAULT_TOKEN", "")
+	VaultK8sRole       = getEnv("VAULT_KUBERNETES_ROLE", "")
+	VaultK8sPath       = getEnv("VAULT_KUBERNETES_PATH", "kubernetes")
 )
 
 func getEnv(key, defaultVal string) string {
@@ -34,6 +154,24 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getEnvFloat(key string, defaultVal float64) float64 {
+	if val := os.Getenv(key); val != "" {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	}
+	return defaultVal
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if val := os.Getenv(key); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return defaultVal
+}
+
 func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
 	if val := os.Getenv(key); val != "" {
 		if d, err := time.ParseDuration(val); err == nil {
@@ -42,3 +180,33 @@ func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
 	}
 	return defaultVal
 }
+
+// getEnvList parses a comma-separated list, skipping empty entries
+func getEnvList(key string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getEnvMap parses "key=value,key2=value2" into a map
+func getEnvMap(key string) map[string]string {
+	return parseMap(os.Getenv(key))
+}
+
+func parseMap(s string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if k, v = strings.TrimSpace(k), strings.TrimSpace(v); k != "" {
+			result[k] = v
+		}
+	}
+	return result
+}
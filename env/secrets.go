@@ -0,0 +1,213 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// kubernetesTokenPath is where Kubernetes mounts the pod's service account token
+const kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// LoadSecrets resolves the secret settings. Each can be read from the file named by
+// <NAME>_FILE (e.g. a Kubernetes secret volume) instead of the variable itself, and a
+// value of the form vault:<path>#<field> is read from Vault's KV engine (v1 or v2).
+func LoadSecrets(ctx context.Context) error {
+	secrets := []struct {
+		name  string
+		value *string
+	}{
+		{"CLICKHOUSE_PASSWORD", &ClickHousePassword},
+		{"REPLICA_CLICKHOUSE_PASSWORD", &ReplicaPassword},
+		{"API_KEY", &APIKey},
+		{"ADMIN_API_KEY", &AdminAPIKey},
+		{"OIDC_CLIENT_SECRET", &OIDCClientSecret},
+		{"OIDC_SESSION_SECRET", &OIDCSessionSecret},
+		{"PAYLOAD_SECRET_ACCESS_KEY", &PayloadSecretKey},
+		{"ARCHIVE_SECRET_ACCESS_KEY", &ArchiveSecretKey},
+	}
+
+	vault := &vaultClient{addr: strings.TrimSuffix(VaultAddr, "/"), token: VaultToken, cache: map[string]map[string]interface{}{}}
+	if VaultToken == "" {
+		token, err := readSecretFile("VAULT_TOKEN")
+		if err != nil {
+			return err
+		}
+		vault.token = token
+	}
+
+	for _, s := range secrets {
+		if os.Getenv(s.name) == "" {
+			value, err := readSecretFile(s.name)
+			if err != nil {
+				return err
+			}
+			if value != "" {
+				*s.value = value
+			}
+		}
+		value, err := vault.resolve(ctx, s.name, *s.value)
+		if err != nil {
+			return err
+		}
+		*s.value = value
+	}
+
+	// The replica password defaults to the primary's, which may only now be resolved
+	if os.Getenv("REPLICA_CLICKHOUSE_PASSWORD") == "" && os.Getenv("REPLICA_CLICKHOUSE_PASSWORD_FILE") == "" {
+		ReplicaPassword = ClickHousePassword
+	}
+
+	// Webhook secrets are a map: the file holds the whole list, and each value may be a Vault reference
+	if os.Getenv("WEBHOOK_SECRETS") == "" {
+		list, err := readSecretFile("WEBHOOK_SECRETS")
+		if err != nil {
+			return err
+		}
+		if list != "" {
+			WebhookSecrets = parseMap(list)
+		}
+	}
+	for source, secret := range WebhookSecrets {
+		value, err := vault.resolve(ctx, "WEBHOOK_SECRETS", secret)
+		if err != nil {
+			return err
+		}
+		WebhookSecrets[source] = value
+	}
+
+	return nil
+}
+
+// readSecretFile reads the file named by <name>_FILE, if set, without trailing newlines
+func readSecretFile(name string) (string, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// vaultClient reads secrets from Vault, authenticating with a token or, when
+// VAULT_KUBERNETES_ROLE is set, the pod's service account
+type vaultClient struct {
+	addr  string
+	token string
+	// cache holds each path's data, so several fields of one secret are read once
+	cache map[string]map[string]interface{}
+}
+
+// resolve returns value, or the secret it references when it has the vault: prefix
+func (v *vaultClient) resolve(ctx context.Context, name, value string) (string, error) {
+	ref, ok := strings.CutPrefix(value, "vault:")
+	if !ok {
+		return value, nil
+	}
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("invalid vault reference for %s: expected vault:<path>#<field>", name)
+	}
+	if v.addr == "" {
+		return "", fmt.Errorf("%s references vault but VAULT_ADDR is not set", name)
+	}
+
+	data, err := v.read(ctx, strings.Trim(path, "/"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from vault: %w", name, err)
+	}
+	secret, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("failed to read %s from vault: field %s not found in %s", name, field, path)
+	}
+	return secret, nil
+}
+
+func (v *vaultClient) read(ctx context.Context, path string) (map[string]interface{}, error) {
+	if data, ok := v.cache[path]; ok {
+		return data, nil
+	}
+	if v.token == "" {
+		if err := v.loginKubernetes(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, "/v1/"+path, nil, &resp); err != nil {
+		return nil, err
+	}
+	data := resp.Data
+	// KV v2 nests the secret under data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	v.cache[path] = data
+	return data, nil
+}
+
+func (v *vaultClient) loginKubernetes(ctx context.Context) error {
+	if VaultK8sRole == "" {
+		return fmt.Errorf("VAULT_TOKEN or VAULT_KUBERNETES_ROLE is required")
+	}
+	jwt, err := os.ReadFile(kubernetesTokenPath)
+	if err != nil {
+		return fmt.Errorf("failed to read service account token: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"role": VaultK8sRole, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "/v1/auth/"+strings.Trim(VaultK8sPath, "/")+"/login", body, &resp); err != nil {
+		return fmt.Errorf("kubernetes login failed: %w", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return fmt.Errorf("kubernetes login failed: no token in response")
+	}
+	v.token = resp.Auth.ClientToken
+	return nil
+}
+
+func (v *vaultClient) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,236 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// BenchConfig configures a benchmark: an ingest run with query clients alongside it
+type BenchConfig struct {
+	Ingest Config
+	// QueryWorkers is the number of concurrent query clients (0 = ingest only)
+	QueryWorkers int
+	// Settle is how long to wait after the load before reading the insert stats, so the
+	// last batch.flushed events are written
+	Settle time.Duration
+}
+
+// benchQuery is a query the bench clients run in rotation
+type benchQuery struct {
+	name   string
+	method string
+	path   string
+	body   func(from, to time.Time) interface{}
+}
+
+var benchQueries = []benchQuery{
+	{name: "events", method: http.MethodGet, path: "/v1/events?limit=100"},
+	{name: "analytics", method: http.MethodPost, path: "/v1/analytics", body: func(from, to time.Time) interface{} {
+		return structs.AnalyticsQuery{Aggregation: "count", GroupBy: []string{"service", "level"}, From: from, To: to}
+	}},
+	{name: "timeseries", method: http.MethodPost, path: "/v1/timeseries", body: func(from, to time.Time) interface{} {
+		return structs.TimeSeriesQuery{Aggregation: "p95", Field: "data.duration_ms", Interval: "minute", GroupBy: []string{"service"}, From: from, To: to}
+	}},
+	{name: "labels", method: http.MethodGet, path: "/v1/labels/service/values"},
+}
+
+// QueryStats are the results of one bench query
+type QueryStats struct {
+	Errors  atomic.Int64
+	Latency Latencies
+}
+
+// ServerStats are the deltas of the target's /health counters over the run
+type ServerStats struct {
+	Enqueued int64
+	Dropped  int64
+	Rejected int64
+	Pending  int64
+}
+
+// InsertStats summarize the target's batch.flushed and batch.failed self-monitoring events
+type InsertStats struct {
+	Batches   int64
+	Failed    int64
+	Events    int64
+	AvgMs     float64
+	P95Ms     float64
+	Available bool
+}
+
+// Report is the outcome of a benchmark
+type Report struct {
+	Duration time.Duration
+	Ingest   *Counts
+	Queries  map[string]*QueryStats
+	Server   ServerStats
+	Inserts  InsertStats
+}
+
+// Bench drives ingest at the configured rate with QueryWorkers clients querying
+// concurrently, then collects the target's own counters and insert stats. queries is
+// the sender used for the query API, which may use a different key than ingest.
+func Bench(ctx context.Context, ingest, queries *Sender, config BenchConfig, out io.Writer) (*Report, error) {
+	before, err := serverStats(ctx, ingest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /health: %w", err)
+	}
+
+	report := &Report{Queries: map[string]*QueryStats{}}
+	for _, q := range benchQueries {
+		report.Queries[q.name] = &QueryStats{}
+	}
+
+	start := time.Now()
+	queryCtx, stopQueries := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < config.QueryWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := i; queryCtx.Err() == nil; n++ {
+				q := benchQueries[n%len(benchQueries)]
+				var body interface{}
+				if q.body != nil {
+					body = q.body(start.Add(-time.Hour), time.Now())
+				}
+				began := time.Now()
+				err := queries.Do(queryCtx, q.method, q.path, body, nil)
+				if queryCtx.Err() != nil {
+					return
+				}
+				stats := report.Queries[q.name]
+				stats.Latency.Add(time.Since(began))
+				if err != nil {
+					stats.Errors.Add(1)
+				}
+			}
+		}(i)
+	}
+
+	report.Ingest = Run(ctx, ingest, config.Ingest, out)
+	stopQueries()
+	wg.Wait()
+	report.Duration = time.Since(start)
+
+	fmt.Fprintf(out, "waiting %v for the last batches to be written...\n", config.Settle)
+	select {
+	case <-time.After(config.Settle):
+	case <-ctx.Done():
+	}
+
+	after, err := serverStats(context.Background(), ingest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /health: %w", err)
+	}
+	report.Server = ServerStats{
+		Enqueued: after.Enqueued - before.Enqueued,
+		Dropped:  after.Dropped - before.Dropped,
+		Rejected: after.Rejected - before.Rejected,
+		Pending:  after.Pending,
+	}
+	report.Inserts = insertStats(context.Background(), queries, start, time.Now())
+	return report, nil
+}
+
+func serverStats(ctx context.Context, sender *Sender) (ServerStats, error) {
+	var health ServerStats
+	var resp struct {
+		Enqueued int64 `json:"enqueued"`
+		Dropped  int64 `json:"dropped"`
+		Rejected int64 `json:"rejected"`
+		Pending  int64 `json:"pending"`
+	}
+	if err := sender.Do(ctx, http.MethodGet, "/health", nil, &resp); err != nil {
+		return health, err
+	}
+	return ServerStats(resp), nil
+}
+
+// insertStats reads the batch events monitor-core emits about itself; Available is false
+// when they can't be read (e.g. self-monitoring is disabled)
+func insertStats(ctx context.Context, sender *Sender, from, to time.Time) InsertStats {
+	stats := InsertStats{Available: true}
+	value := func(name string, aggregation structs.AggregationType, field string) float64 {
+		query := structs.AnalyticsQuery{
+			Aggregation: aggregation,
+			Field:       field,
+			Filters: []structs.QueryFilter{
+				{Field: "service", Operator: "eq", Value: "monitor-core"},
+				{Field: "name", Operator: "eq", Value: name},
+			},
+			From: from,
+			To:   to,
+		}
+		var resp struct {
+			Data structs.AnalyticsResult `json:"data"`
+		}
+		if err := sender.Do(ctx, http.MethodPost, "/v1/analytics", query, &resp); err != nil {
+			stats.Available = false
+			return 0
+		}
+		if len(resp.Data.Data) == 0 {
+			return 0
+		}
+		return resp.Data.Data[0].Value
+	}
+
+	stats.Batches = int64(value("batch.flushed", "count", ""))
+	stats.Failed = int64(value("batch.failed", "count", ""))
+	if stats.Batches > 0 {
+		stats.Events = int64(value("batch.flushed", "sum", "data.size"))
+		stats.AvgMs = value("batch.flushed", "avg", "data.duration_ms")
+		stats.P95Ms = value("batch.flushed", "p95", "data.duration_ms")
+	}
+	stats.Available = stats.Available && stats.Batches+stats.Failed > 0
+	return stats
+}
+
+// Print writes the report as text
+func (r *Report) Print(out io.Writer) {
+	seconds := r.Duration.Seconds()
+	p := r.Ingest.Latency.Percentiles(50, 90, 99)
+	fmt.Fprintf(out, "\nDuration       %v\n", r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(out, "\nIngest\n")
+	fmt.Fprintf(out, "  sent         %d (%.0f events/s)\n", r.Ingest.Sent.Load(), float64(r.Ingest.Sent.Load())/seconds)
+	fmt.Fprintf(out, "  failed       %d\n", r.Ingest.Failed.Load())
+	fmt.Fprintf(out, "  throttled    %d\n", r.Ingest.Throttled.Load())
+	fmt.Fprintf(out, "  requests     %d, latency p50 %v  p90 %v  p99 %v\n", r.Ingest.Latency.Count(), round(p[0]), round(p[1]), round(p[2]))
+
+	fmt.Fprintf(out, "\nQueries\n")
+	for _, q := range benchQueries {
+		stats := r.Queries[q.name]
+		if stats.Latency.Count() == 0 {
+			continue
+		}
+		p := stats.Latency.Percentiles(50, 90, 99)
+		fmt.Fprintf(out, "  %-12s %d (%.1f/s), %d errors, latency p50 %v  p90 %v  p99 %v\n",
+			q.name, stats.Latency.Count(), float64(stats.Latency.Count())/seconds, stats.Errors.Load(), round(p[0]), round(p[1]), round(p[2]))
+	}
+
+	fmt.Fprintf(out, "\nServer\n")
+	fmt.Fprintf(out, "  enqueued     %d\n", r.Server.Enqueued)
+	fmt.Fprintf(out, "  dropped      %d\n", r.Server.Dropped)
+	fmt.Fprintf(out, "  rejected     %d\n", r.Server.Rejected)
+	fmt.Fprintf(out, "  pending      %d\n", r.Server.Pending)
+
+	fmt.Fprintf(out, "\nClickHouse inserts\n")
+	if !r.Inserts.Available {
+		fmt.Fprintf(out, "  unavailable (needs SELF_MONITORING and a query key)\n")
+		return
+	}
+	fmt.Fprintf(out, "  batches      %d (%d failed)\n", r.Inserts.Batches, r.Inserts.Failed)
+	fmt.Fprintf(out, "  events       %d\n", r.Inserts.Events)
+	fmt.Fprintf(out, "  duration     avg %.1fms  p95 %.1fms\n", r.Inserts.AvgMs, r.Inserts.P95Ms)
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(100 * time.Microsecond)
+}
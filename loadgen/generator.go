@@ -0,0 +1,204 @@
+// Package loadgen produces realistic synthetic events and sends them to a monitor-core
+// instance, for load tests, benchmarks, and demo environments.
+package loadgen
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// serviceNames are used for the first services; later ones are numbered
+var serviceNames = []string{"api", "checkout", "search", "auth", "billing", "notifications", "inventory", "gateway"}
+
+var (
+	methods   = []string{"GET", "GET", "GET", "POST", "PUT", "DELETE"}
+	countries = []string{"US", "US", "DE", "GB", "FR", "BR", "IN", "JP"}
+	agents    = []string{"Mozilla/5.0 (Macintosh)", "Mozilla/5.0 (Windows NT 10.0)", "Mozilla/5.0 (iPhone)", "curl/8.4.0", "okhttp/4.12"}
+	tables    = []string{"users", "orders", "sessions", "products", "payments"}
+	queues    = []string{"emails", "exports", "webhooks", "reports"}
+)
+
+// endpoint is a route of a service with its typical latency
+type endpoint struct {
+	path   string
+	median float64 // median latency in milliseconds
+}
+
+// service is a fake service with its own endpoints and error bursts
+type service struct {
+	name      string
+	endpoints []endpoint
+	// errorRate is the share of requests failing outside a burst
+	errorRate float64
+	// burstUntil is when the current error burst ends and nextBurst when the next begins
+	burstUntil time.Time
+	nextBurst  time.Time
+}
+
+// Generator produces events for a fixed set of services. Latencies follow a log-normal
+// distribution, each service has occasional error bursts, and data is nested like real
+// application events. A Generator is not safe for concurrent use.
+type Generator struct {
+	rng      *rand.Rand
+	services []*service
+	envs     []string
+	users    int
+}
+
+// NewGenerator creates a generator for n services spread over envs, seeded so runs can
+// be reproduced
+func NewGenerator(n int, envs []string, seed int64) *Generator {
+	if len(envs) == 0 {
+		envs = []string{"production"}
+	}
+	g := &Generator{rng: rand.New(rand.NewSource(seed)), envs: envs, users: 5000}
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("service-%d", i+1)
+		if i < len(serviceNames) {
+			name = serviceNames[i]
+		}
+		s := &service{name: name, errorRate: 0.002 + g.rng.Float64()*0.01}
+		for j := 0; j < 3+g.rng.Intn(5); j++ {
+			s.endpoints = append(s.endpoints, endpoint{
+				path:   fmt.Sprintf("/v1/%s/%s", name, []string{"items", "search", "status", "orders", "profile", "export", "sync", "health"}[j]),
+				median: 5 + g.rng.ExpFloat64()*60,
+			})
+		}
+		s.nextBurst = start.Add(g.burstGap())
+		g.services = append(g.services, s)
+	}
+	return g
+}
+
+// burstGap is the time until a service's next error burst, five minutes on average
+func (g *Generator) burstGap() time.Duration {
+	return time.Duration(g.rng.ExpFloat64() * float64(5*time.Minute))
+}
+
+// Next returns an event timestamped at now
+func (g *Generator) Next(now time.Time) *structs.Event {
+	s := g.services[g.rng.Intn(len(g.services))]
+	if now.After(s.nextBurst) {
+		s.burstUntil = now.Add(30*time.Second + time.Duration(g.rng.Intn(60))*time.Second)
+		s.nextBurst = s.burstUntil.Add(g.burstGap())
+	}
+	bursting := now.Before(s.burstUntil)
+
+	event := &structs.Event{
+		Timestamp: now.UTC(),
+		Service:   s.name,
+		Env:       g.envs[g.rng.Intn(len(g.envs))],
+		TraceID:   g.uuid(),
+		Level:     "info",
+	}
+
+	switch roll := g.rng.Float64(); {
+	case roll < 0.7:
+		g.request(event, s, bursting)
+	case roll < 0.9:
+		g.query(event, bursting)
+	default:
+		g.job(event, bursting)
+	}
+	return event
+}
+
+func (g *Generator) request(event *structs.Event, s *service, bursting bool) {
+	ep := s.endpoints[g.rng.Intn(len(s.endpoints))]
+	errorRate := s.errorRate
+	if bursting {
+		errorRate = 0.3
+	}
+
+	status := 200
+	switch roll := g.rng.Float64(); {
+	case roll < errorRate:
+		status = []int{500, 502, 503, 504}[g.rng.Intn(4)]
+		event.Level = "error"
+	case roll < errorRate+0.03:
+		status = []int{400, 401, 404, 429}[g.rng.Intn(4)]
+		event.Level = "warn"
+	case g.rng.Float64() < 0.1:
+		status = 201
+	}
+
+	latency := g.latency(ep.median)
+	if bursting {
+		latency *= 3
+	}
+
+	event.Name = "http.request"
+	event.RequestID = g.uuid()
+	event.UserID = fmt.Sprintf("user-%d", g.rng.Intn(g.users))
+	event.Data = map[string]interface{}{
+		"method":      methods[g.rng.Intn(len(methods))],
+		"path":        ep.path,
+		"status":      status,
+		"duration_ms": latency,
+		"bytes":       200 + g.rng.Intn(20000),
+		"client": map[string]interface{}{
+			"ip":         fmt.Sprintf("10.%d.%d.%d", g.rng.Intn(256), g.rng.Intn(256), g.rng.Intn(256)),
+			"country":    countries[g.rng.Intn(len(countries))],
+			"user_agent": agents[g.rng.Intn(len(agents))],
+		},
+	}
+}
+
+func (g *Generator) query(event *structs.Event, bursting bool) {
+	latency := g.latency(2)
+	if bursting {
+		latency *= 10
+	}
+	if latency > 500 {
+		event.Level = "warn"
+	}
+
+	event.Name = "db.query"
+	event.Data = map[string]interface{}{
+		"table":       tables[g.rng.Intn(len(tables))],
+		"operation":   []string{"select", "select", "select", "insert", "update"}[g.rng.Intn(5)],
+		"duration_ms": latency,
+		"rows":        g.rng.Intn(500),
+	}
+}
+
+func (g *Generator) job(event *structs.Event, bursting bool) {
+	processed := 1 + g.rng.Intn(1000)
+	failed := 0
+	if bursting || g.rng.Float64() < 0.05 {
+		failed = 1 + g.rng.Intn(processed)
+		event.Level = "warn"
+	}
+
+	event.Name = "job.completed"
+	event.JobID = g.uuid()
+	event.Data = map[string]interface{}{
+		"queue":       queues[g.rng.Intn(len(queues))],
+		"attempts":    1 + g.rng.Intn(3),
+		"duration_ms": g.latency(800),
+		"result": map[string]interface{}{
+			"processed": processed,
+			"failed":    failed,
+		},
+	}
+}
+
+// latency samples a log-normal latency in milliseconds around median
+func (g *Generator) latency(median float64) float64 {
+	ms := median * math.Exp(0.6*g.rng.NormFloat64())
+	return math.Round(ms*100) / 100
+}
+
+func (g *Generator) uuid() string {
+	b := make([]byte, 16)
+	g.rng.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,47 @@
+package loadgen
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Latencies collects request durations for percentiles
+type Latencies struct {
+	mu        sync.Mutex
+	durations []time.Duration
+}
+
+// Add records a duration
+func (l *Latencies) Add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.durations = append(l.durations, d)
+}
+
+// Count returns the number of recorded durations
+func (l *Latencies) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.durations)
+}
+
+// Percentiles returns the given percentiles (0-100) by the nearest-rank method, zero
+// when nothing was recorded
+func (l *Latencies) Percentiles(ps ...float64) []time.Duration {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.durations...)
+	l.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	out := make([]time.Duration, len(ps))
+	if len(sorted) == 0 {
+		return out
+	}
+	for i, p := range ps {
+		rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		out[i] = sorted[max(0, min(rank, len(sorted)-1))]
+	}
+	return out
+}
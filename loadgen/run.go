@@ -0,0 +1,129 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// tick is how often a run generates the events due at its rate
+const tick = 100 * time.Millisecond
+
+// Config configures a generation run
+type Config struct {
+	Services  int
+	Envs      []string
+	Rate      int // events per second
+	Duration  time.Duration
+	BatchSize int
+	Workers   int
+	Seed      int64
+}
+
+// Counts tallies the events of a run
+type Counts struct {
+	Sent      atomic.Int64
+	Failed    atomic.Int64
+	Throttled atomic.Int64
+	// Latency records how long each request took
+	Latency Latencies
+}
+
+// Run generates events at the configured rate for the duration (or until ctx is done),
+// sending them in batches from Workers goroutines, and reports progress to out every 10s.
+// Batches that can't be sent as fast as they are generated are dropped and counted as
+// failed, so the rate stays honest under backpressure.
+func Run(ctx context.Context, sender *Sender, config Config, out io.Writer) *Counts {
+	// Workers keep the parent context, so batches generated just before the end still go out
+	runCtx, cancel := context.WithTimeout(ctx, config.Duration)
+	defer cancel()
+
+	counts := &Counts{}
+	batches := make(chan []*structs.Event, config.Workers*2)
+	var wg sync.WaitGroup
+	var reportOnce sync.Once
+	for i := 0; i < config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if err := SendCounted(ctx, sender, batch, counts); err != nil {
+					reportOnce.Do(func() { fmt.Fprintf(out, "send failed: %v\n", err) })
+				}
+			}
+		}()
+	}
+
+	generator := NewGenerator(config.Services, config.Envs, config.Seed)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	progress := time.NewTicker(10 * time.Second)
+	defer progress.Stop()
+
+	start := time.Now()
+	generated := 0
+	batch := make([]*structs.Event, 0, config.BatchSize)
+	for done := false; !done; {
+		select {
+		case <-runCtx.Done():
+			done = true
+		case now := <-ticker.C:
+			// Generate what the rate calls for so far, so slow ticks catch up
+			due := int(now.Sub(start).Seconds()*float64(config.Rate)) - generated
+			for i := 0; i < due; i++ {
+				batch = append(batch, generator.Next(now))
+				if len(batch) == config.BatchSize {
+					batch = enqueue(batches, batch, counts, config.BatchSize)
+				}
+			}
+			generated += due
+			if len(batch) > 0 {
+				batch = enqueue(batches, batch, counts, config.BatchSize)
+			}
+		case <-progress.C:
+			elapsed := time.Since(start)
+			fmt.Fprintf(out, "%s: %d sent (%.0f/s), %d failed, %d throttled\n",
+				elapsed.Round(time.Second), counts.Sent.Load(), float64(counts.Sent.Load())/elapsed.Seconds(),
+				counts.Failed.Load(), counts.Throttled.Load())
+		}
+	}
+
+	close(batches)
+	wg.Wait()
+	return counts
+}
+
+// enqueue hands a batch to the workers, dropping it when they are all busy, and returns
+// an empty batch to fill next
+func enqueue(batches chan<- []*structs.Event, batch []*structs.Event, counts *Counts, size int) []*structs.Event {
+	select {
+	case batches <- batch:
+	default:
+		counts.Failed.Add(int64(len(batch)))
+	}
+	return make([]*structs.Event, 0, size)
+}
+
+// SendCounted sends a batch and counts the result; 429 and 503 responses count as throttled
+func SendCounted(ctx context.Context, sender *Sender, batch []*structs.Event, counts *Counts) error {
+	start := time.Now()
+	err := sender.Send(ctx, batch)
+	counts.Latency.Add(time.Since(start))
+	var status *StatusError
+	switch {
+	case err == nil:
+		counts.Sent.Add(int64(len(batch)))
+	case errors.As(err, &status) && (status.Status == http.StatusTooManyRequests || status.Status == http.StatusServiceUnavailable):
+		counts.Throttled.Add(int64(len(batch)))
+	default:
+		counts.Failed.Add(int64(len(batch)))
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package loadgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Sender posts events to a monitor-core instance as gzipped NDJSON
+type Sender struct {
+	target string
+	apiKey string
+	client *http.Client
+}
+
+// NewSender creates a sender for the instance at target (e.g. http://localhost:8080)
+func NewSender(target, apiKey string) *Sender {
+	return &Sender{
+		target: strings.TrimSuffix(target, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// StatusError is returned for a response other than 200, so callers can tell
+// backpressure (429 and 503) from failures
+type StatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("ingest returned %d: %s", e.Status, e.Body)
+}
+
+// Send posts events to /v1/events
+func (s *Sender) Send(ctx context.Context, events []*structs.Event) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	encoder := json.NewEncoder(gz)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.target+"/v1/events", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	if s.apiKey != "" {
+		req.Header.Set("X-Api-Key", s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{Status: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Do sends a JSON request (body may be nil) and decodes the response into out, if set
+func (s *Sender) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.target+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.apiKey != "" {
+		req.Header.Set("X-Api-Key", s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{Status: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -2,17 +2,26 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/aidenappl/monitor-core/db"
 	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/loadgen"
 	"github.com/aidenappl/monitor-core/middleware"
+	"github.com/aidenappl/monitor-core/migrations"
+	"github.com/aidenappl/monitor-core/pipeline"
 	"github.com/aidenappl/monitor-core/routes"
 	"github.com/aidenappl/monitor-core/services"
 	"github.com/gorilla/mux"
@@ -20,11 +29,6 @@ import (
 )
 
 func main() {
-	// Validate configuration
-	if env.APIKey == "" {
-		log.Println("WARNING: API_KEY is not set, authentication is disabled")
-	}
-
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -33,73 +37,370 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// "monitor-core generate" sends synthetic events to a running instance and needs no ClickHouse
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		if err := generate(ctx, os.Args[2:]); err != nil {
+			log.Fatalf("❌ generate failed: %v", err)
+		}
+		return
+	}
+
+	// "monitor-core bench" drives ingest and query load against a running instance and reports on it
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := bench(ctx, os.Args[2:]); err != nil {
+			log.Fatalf("❌ bench failed: %v", err)
+		}
+		return
+	}
+
+	// Secrets from *_FILE variables and Vault references
+	if err := env.LoadSecrets(ctx); err != nil {
+		log.Fatalf("❌ failed to load secrets: %v", err)
+	}
+
 	// Connect to ClickHouse
-	if err := db.Connect(ctx, env.ClickHouseAddr, env.ClickHouseDatabase, env.ClickHouseUsername, env.ClickHousePassword); err != nil {
+	store, err := db.Connect(ctx, env.ClickHouseAddr, env.ClickHouseDatabase, env.ClickHouseUsername, env.ClickHousePassword)
+	if err != nil {
 		log.Fatalf("❌ failed to connect to ClickHouse: %v", err)
 	}
-	defer db.Close()
+	defer store.Close()
+	svc := services.New(store)
 
-	// Create event queue
-	queue := services.NewQueue(env.QueueSize)
-	routes.Queue = queue
+	// Per-env tables and retention
+	if err := db.ConfigureStorage(env.RetentionDays, env.EnvRoutes); err != nil {
+		log.Fatalf("❌ invalid storage configuration: %v", err)
+	}
+	if err := db.ConfigureReplay(env.ReplayTable, env.ReplayRetention); err != nil {
+		log.Fatalf("❌ invalid replay configuration: %v", err)
+	}
+	if err := db.ConfigureTenants(env.TenantTables); err != nil {
+		log.Fatalf("❌ invalid tenant tables: %v", err)
+	}
+	if env.RollupRetention > 0 {
+		if err := services.EnableRollups(env.RollupRetention, env.RollupMinuteDays, env.RollupFields); err != nil {
+			log.Fatalf("❌ invalid rollup configuration: %v", err)
+		}
+	}
+	if env.SpanMetrics {
+		if err := services.EnableSpanMetrics(); err != nil {
+			log.Fatalf("❌ invalid span metrics configuration: %v", err)
+		}
+	}
+	if err := db.ConfigureLabelColumns(env.LabelColumns); err != nil {
+		log.Fatalf("❌ invalid label columns: %v", err)
+	}
+	services.SetLabelColumns(db.LabelColumns)
 
-	// Create and start batcher
-	writer := &db.Writer{}
-	batcher := services.NewBatcher(queue, writer, env.BatchSize, env.FlushInterval)
-	go batcher.Run(ctx)
+	// "monitor-core migrate rebuild" swaps an events table for one with a new schema
+	if len(os.Args) > 2 && os.Args[1] == "migrate" && os.Args[2] == "rebuild" {
+		if err := rebuild(ctx, store, os.Args[3:]); err != nil {
+			log.Fatalf("❌ rebuild failed: %v", err)
+		}
+		return
+	}
 
-	// Setup router
-	r := mux.NewRouter()
-	r.Use(middleware.RequestIDMiddleware)
-	r.Use(middleware.LoggingMiddleware)
-	r.Use(middleware.MuxHeaderMiddleware)
+	// "monitor-core migrate" applies pending migrations and the storage layout, then exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := db.Migrate(ctx, store, migrations.FS); err != nil {
+			log.Fatalf("❌ migration failed: %v", err)
+		}
+		return
+	}
+	if env.AutoMigrate {
+		if err := db.Migrate(ctx, store, migrations.FS); err != nil {
+			log.Fatalf("❌ migration failed: %v", err)
+		}
+	}
 
-	r.HandleFunc("/health", routes.HealthHandler).Methods(http.MethodGet)
+	// Lookup tables for dict.<name> fields
+	if err := svc.LoadLookups(ctx); err != nil {
+		log.Printf("WARNING: lookups unavailable (run migrations): %v", err)
+	}
 
-	// V1 API routes (with auth middleware)
-	v1 := r.PathPrefix("/v1").Subrouter()
-	v1.Use(middleware.AuthMiddleware)
+	// Access roles restricting what keys and OIDC users can read and call
+	if err := services.LoadAccessRoles(env.AccessRolesConfig); err != nil {
+		log.Fatalf("❌ failed to load access roles: %v", err)
+	}
 
-	v1.HandleFunc("/events", routes.IngestEventsHandler).Methods(http.MethodPost)
-	v1.HandleFunc("/events", routes.QueryEventsHandler).Methods(http.MethodGet)
-	v1.HandleFunc("/labels/{label}/values", routes.GetLabelValuesHandler).Methods(http.MethodGet)
-	v1.HandleFunc("/data/keys", routes.GetDataKeysHandler).Methods(http.MethodGet)
-	v1.HandleFunc("/data/values", routes.GetDataValuesHandler).Methods(http.MethodGet)
+	// Data keys hidden from callers without the pii:read scope
+	if err := services.ConfigureSensitiveKeys(env.SensitiveKeys, env.SensitiveKeysMode); err != nil {
+		log.Fatalf("❌ invalid sensitive keys: %v", err)
+	}
 
-	// Analytics routes (Grafana-compatible)
-	v1.HandleFunc("/analytics", routes.AnalyticsHandler).Methods(http.MethodPost)
-	v1.HandleFunc("/analytics", routes.AnalyticsQueryHandler).Methods(http.MethodGet)
-	v1.HandleFunc("/timeseries", routes.TimeSeriesHandler).Methods(http.MethodPost)
-	v1.HandleFunc("/timeseries", routes.TimeSeriesQueryHandler).Methods(http.MethodGet)
-	v1.HandleFunc("/topn", routes.TopNHandler).Methods(http.MethodPost)
-	v1.HandleFunc("/gauge", routes.GaugeHandler).Methods(http.MethodPost)
-	v1.HandleFunc("/compare", routes.CompareHandler).Methods(http.MethodPost)
+	// Managed API keys issued, rotated, and revoked through the admin API
+	if err := svc.LoadAPIKeys(ctx); err != nil {
+		log.Printf("WARNING: managed api keys unavailable (run migrations): %v", err)
+	}
 
-	// CORS Middleware
-	corsMiddleware := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowCredentials: true,
-		AllowedHeaders:   []string{"X-Requested-With", "Content-Type", "Origin", "Authorization", "Accept", "X-Api-Key", "Referer", "Dnt", "User-Agent"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+	// Ingest pipeline rules managed through the admin API
+	if err := svc.LoadPipelineRules(ctx); err != nil {
+		log.Printf("WARNING: pipeline rules unavailable (run migrations): %v", err)
+	}
+
+	// Optional OIDC login for people using the query and admin APIs
+	var oidc *services.OIDCProvider
+	if env.OIDCIssuer != "" {
+		if env.OIDCSessionSecret == "" {
+			log.Fatalf("❌ OIDC_SESSION_SECRET is required with OIDC_ISSUER")
+		}
+		oidc, err = services.NewOIDCProvider(ctx, services.OIDCConfig{
+			Issuer:       env.OIDCIssuer,
+			ClientID:     env.OIDCClientID,
+			ClientSecret: env.OIDCClientSecret,
+			RedirectURL:  env.OIDCRedirectURL,
+			GroupsClaim:  env.OIDCGroupsClaim,
+			RoleMap:      env.OIDCRoleMap,
+		})
+		if err != nil {
+			log.Fatalf("❌ failed to configure OIDC: %v", err)
+		}
+	}
+
+	if env.APIKey == "" && !services.APIKeysConfigured() {
+		log.Println("WARNING: API_KEY is not set and no managed keys exist, authentication is disabled")
+	}
+
+	// Policy for client timestamps with skewed clocks
+	if err := services.SetTimestampPolicy(env.TimestampPolicy, env.MaxClockSkew, env.MaxEventAge); err != nil {
+		log.Fatalf("❌ invalid timestamp policy: %v", err)
+	}
+
+	// Level normalization across producers
+	if err := services.ConfigureLevels(env.LevelMap, env.AllowedLevels, env.DefaultLevel, env.StrictLevels); err != nil {
+		log.Fatalf("❌ invalid level configuration: %v", err)
+	}
+
+	// Per-tenant and per-service quotas, shedding low levels first
+	if err := services.ConfigureQuotas(env.IngestQuotas, env.QuotaShedOrder, env.QuotaWindow); err != nil {
+		log.Fatalf("❌ invalid ingest quotas: %v", err)
+	}
+
+	// Traces held at ingest and kept or dropped whole
+	if err := services.ConfigureTailSampling(env.TailSampleRate, env.TailSampleWait, env.TailSampleSlow, env.TailSampleTraces, env.TailSampleEvents); err != nil {
+		log.Fatalf("❌ invalid tail sampling: %v", err)
+	}
+
+	// Fields computed at ingest
+	derived, err := services.ParseDerivedFields(env.DerivedFields)
+	if err != nil {
+		log.Fatalf("❌ invalid derived fields: %v", err)
+	}
+	services.SetDerivedFields(derived)
+
+	services.SetSizeLimits(env.MaxEventSize, env.MaxFieldSize)
+	services.SetResultCaps(env.MaxResultSeries, env.MaxResultPoints, env.MaxResultBytes)
+	services.SetQueryCache(env.QueryCacheTTL)
+	if err := services.ConfigureTimeRanges(env.DefaultQueryRange, env.MaxQueryRange, env.QueryRangeDefaults, env.QueryRangeMaxes); err != nil {
+		log.Fatalf("❌ invalid query time ranges: %v", err)
+	}
+
+	// Optional object storage for large payloads
+	if env.PayloadBucket != "" {
+		store := services.NewS3Store(env.PayloadEndpoint, env.PayloadBucket, env.PayloadRegion, env.PayloadAccessKeyID, env.PayloadSecretKey)
+		services.EnablePayloadOffload(store, env.OffloadThreshold, env.PayloadPrefix)
+	}
+
+	// Optional Parquet archives to replay into the replay table
+	if env.ArchiveBucket != "" {
+		services.EnableArchiveReplay(env.ArchiveEndpoint, env.ArchiveBucket, env.ArchiveRegion, env.ArchivePrefix, env.ArchiveAccessKeyID, env.ArchiveSecretKey)
+	}
+
+	// Webhook sources (built-ins plus WEBHOOK_CONFIG, enabled by their secrets)
+	webhooks, err := services.LoadWebhookSources(env.WebhookConfig, env.WebhookSecrets)
+	if err != nil {
+		log.Fatalf("❌ failed to load webhook sources: %v", err)
+	}
+
+	// Channels alert rules notify in the Alertmanager webhook format (or their own templates)
+	if err := services.LoadAlertChannels(env.AlertChannels, env.AlertExternalURL); err != nil {
+		log.Fatalf("❌ failed to load alert channels: %v", err)
+	}
+
+	// Development-only fault injection on writes and queries, after startup so it can't fail it
+	var chaos *db.Chaos
+	if env.ChaosMode {
+		chaos, err = db.NewChaos(db.ChaosConfig{
+			WriteErrorRate: env.ChaosWriteErrors,
+			WriteLatency:   env.ChaosWriteLatency,
+			QueryErrorRate: env.ChaosQueryErrors,
+			QueryLatency:   env.ChaosQueryLatency,
+		})
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		store = chaos.Wrap(store)
+		svc = services.New(store)
+		log.Println("WARNING: CHAOS_MODE is on, injecting faults into ClickHouse writes and queries (never use it in production)")
+	}
+
+	// Lookups, managed API keys, and pipeline rules are refreshed for changes made on other
+	// instances (from here on, svc queries through chaos when it is on)
+	go svc.RunLookupRefresh(ctx, time.Minute)
+	go svc.RunAPIKeyRefresh(ctx, time.Minute)
+	go svc.RunPipelineRuleRefresh(ctx, time.Minute)
+
+	// Public status page components (availability from SLO queries and heartbeats)
+	var statusPages *services.StatusPages
+	if env.StatusPageConfig != "" {
+		statusPages, err = svc.LoadStatusPage(env.StatusPageConfig)
+		if err != nil {
+			log.Fatalf("❌ failed to load status page: %v", err)
+		}
+	}
+
+	// Optional secondary cluster that receives a copy of every batch
+	writer := &db.Writer{Store: store}
+	var replica *db.Replica
+	if env.ReplicaAddr != "" {
+		replica, err = db.ConnectReplica(ctx, env.ReplicaAddr, env.ReplicaDatabase, env.ReplicaUsername, env.ReplicaPassword, env.ReplicaDLQDir)
+		if err != nil {
+			log.Fatalf("❌ failed to connect to replica ClickHouse: %v", err)
+		}
+		if chaos != nil {
+			replica.Wrap(chaos.Wrap)
+		}
+		writer.Replica = replica
+		go replica.Run(ctx)
+	}
+
+	// Create the ingest pipeline; the watchdog reports (and optionally replaces) a batcher that stops flushing
+	pipe := pipeline.New(writer, pipeline.Config{
+		QueueSize:         env.QueueSize,
+		BatchSize:         env.BatchSize,
+		FlushInterval:     env.FlushInterval,
+		Prepare:           services.PrepareEvent,
+		WatchdogIntervals: env.WatchdogIntervals,
+		WatchdogRestart:   env.WatchdogRestart,
 	})
+	queue := pipe.Queue()
 
-	// Launch Server
-	fmt.Printf("✅ monitor-core running on port %s\n", env.Port)
-	fmt.Println()
+	if env.SelfMonitoring {
+		services.EnableSelfMonitoring(pipe, env.SlowQueryThreshold)
+	}
+	go pipe.Run(ctx)
+	if services.TailSamplingEnabled() {
+		go services.RunTailSampling(ctx, queue)
+	}
+
+	// Dependency health is checked periodically and kept for /v1/admin/health/history
+	if env.HealthInterval > 0 {
+		go services.RunHealthChecks(ctx, store, pipe, env.HealthInterval, int(env.HealthHistory/env.HealthInterval))
+	}
+
+	// Alert rules are evaluated on the instances with ALERTS_ENABLED (one, since firing state is in memory)
+	if env.AlertsEnabled {
+		if env.AlertEvalInterval <= 0 {
+			log.Fatalf("❌ ALERT_EVAL_INTERVAL must be positive")
+		}
+		go svc.RunAlertEngine(ctx, env.AlertEvalInterval)
+	}
+
+	// Schema drift is compared with the previous window in memory, so it runs on one instance too
+	if env.SchemaDrift {
+		if env.DriftInterval <= 0 {
+			log.Fatalf("❌ SCHEMA_DRIFT_INTERVAL must be positive")
+		}
+		if err := services.CheckSchemaDriftChannel(env.DriftChannel); err != nil {
+			log.Fatalf("❌ invalid SCHEMA_DRIFT_CHANNEL: %v", err)
+		}
+		go svc.RunSchemaDrift(ctx, env.DriftInterval, env.DriftChannel)
+	}
+
+	// Rollups outlive raw events; rolling up an hour again is harmless, so every instance runs them
+	if services.RollupsEnabled() {
+		go svc.RunRollups(ctx, time.Hour, env.MaxEventAge)
+	}
+
+	// Backfills bypass the queue and write each partition directly
+	backfiller := services.NewBackfiller(writer, env.BatchSize)
+
+	// Optional StatsD listener
+	if env.StatsDAddr != "" {
+		statsd := services.NewStatsDListener(queue, env.StatsDService)
+		go func() {
+			if err := statsd.Run(ctx, env.StatsDAddr); err != nil {
+				log.Printf("statsd listener error: %v", err)
+			}
+		}()
+	}
+
+	// Optional syslog listeners
+	if env.SyslogUDPAddr != "" || env.SyslogTCPAddr != "" {
+		syslog := services.NewSyslogListener(queue, env.SyslogService)
+		if env.SyslogUDPAddr != "" {
+			go func() {
+				if err := syslog.RunUDP(ctx, env.SyslogUDPAddr); err != nil {
+					log.Printf("syslog udp listener error: %v", err)
+				}
+			}()
+		}
+		if env.SyslogTCPAddr != "" {
+			go func() {
+				if err := syslog.RunTCP(ctx, env.SyslogTCPAddr); err != nil {
+					log.Printf("syslog tcp listener error: %v", err)
+				}
+			}()
+		}
+	}
+
+	// Optional NATS JetStream consumer; it stops pulling before the pipeline shuts down and
+	// closes after, so the last events written are still acked
+	var jetStream *services.JetStreamConsumer
+	if env.NATSURL != "" {
+		jetStream, err = services.NewJetStreamConsumer(pipe, services.JetStreamConfig{
+			URL:      env.NATSURL,
+			Stream:   env.NATSStream,
+			Subject:  env.NATSSubject,
+			Consumer: env.NATSConsumer,
+		})
+		if err != nil {
+			log.Fatalf("❌ invalid NATS JetStream configuration: %v", err)
+		}
+		go jetStream.Run(ctx)
+	}
 
-	server := &http.Server{
-		Addr:         ":" + env.Port,
-		Handler:      corsMiddleware.Handler(r),
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	// Launch servers, one per listener
+	listeners, err := parseListeners(env.Listeners, env.Port, env.AdminAddr)
+	if err != nil {
+		log.Fatalf("❌ invalid listener configuration: %v", err)
 	}
+	// Per-key usage is written once a minute
+	go svc.RunMetering(ctx, time.Minute)
+
+	// Ingest is limited per client and rejected while the queue is full
+	limiter := middleware.NewRateLimiter(env.IngestRateLimit, env.IngestRateBurst, queue, env.FlushInterval)
 
-	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("HTTP server error: %v", err)
+	handlers := routes.New(svc, routes.Config{
+		Queue:       queue,
+		Replica:     replica,
+		Chaos:       chaos,
+		Backfiller:  backfiller,
+		OIDC:        oidc,
+		Webhooks:    webhooks,
+		StatusPages: statusPages,
+	})
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, l := range listeners {
+		ln, err := listen(l.Addr)
+		if err != nil {
+			log.Fatalf("❌ failed to listen on %s: %v", l.Addr, err)
+		}
+		server := &http.Server{
+			Handler: newRouter(l.Surfaces, limiter, handlers),
+			// Read and write deadlines are set per route by middleware.Timeout
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       60 * time.Second,
 		}
-	}()
+		servers = append(servers, server)
+
+		go func() {
+			if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("HTTP server error on %s: %v", l.Addr, err)
+			}
+		}()
+		fmt.Printf("✅ monitor-core listening on %s (%s)\n", l.Addr, strings.Join(l.Surfaces, ", "))
+	}
+	fmt.Println()
 
 	// Wait for shutdown signal
 	<-sigChan
@@ -109,13 +410,475 @@ func main() {
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("HTTP server shutdown error: %v", err)
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP server shutdown error: %v", err)
+		}
 	}
 
+	// Stop pulling from NATS, release the held traces that are kept, then write what is still
+	// queued (acking the NATS messages written) before stopping the rest
+	if jetStream != nil {
+		jetStream.Stop()
+	}
+	services.FlushTailSampling(queue)
+	if err := pipe.Shutdown(shutdownCtx); err != nil {
+		log.Printf("pipeline shutdown error: %v", err)
+	}
+	if jetStream != nil {
+		jetStream.Close()
+	}
 	cancel()
-	queue.Close()
-	time.Sleep(2 * time.Second)
+
+	// Batches the replica hasn't written yet are dead-lettered for the next start
+	if writer.Replica != nil {
+		if err := writer.Replica.Close(); err != nil {
+			log.Printf("replica close error: %v", err)
+		}
+	}
 
 	log.Println("shutdown complete")
 }
+
+// rebuild parses the arguments of "migrate rebuild [-table events] [-keep-old] <schema.sql>"
+func rebuild(ctx context.Context, store db.Store, args []string) error {
+	flags := flag.NewFlagSet("migrate rebuild", flag.ExitOnError)
+	table := flags.String("table", db.EventsTable, "table to rebuild (database.table for routed tables)")
+	keepOld := flags.Bool("keep-old", false, "keep the previous table as <table>_old_<timestamp>")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monitor-core migrate rebuild [-table events] [-keep-old] <schema.sql>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	schema, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return db.Rebuild(ctx, store, db.RebuildOptions{Table: *table, Schema: string(schema), KeepOld: *keepOld})
+}
+
+// generate parses the arguments of "generate [-services 5] [-rate 1000] [-duration 10m] ..."
+func generate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	target, apiKey, config := loadgenFlags(flags, 10*time.Minute)
+	flags.Parse(args)
+	if err := config.finish(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("generating %d events/s from %d services for %v to %s", config.Rate, config.Services, config.Duration, *target)
+	start := time.Now()
+	counts := loadgen.Run(ctx, loadgen.NewSender(*target, *apiKey), config.Config, os.Stdout)
+	elapsed := time.Since(start)
+	log.Printf("done in %v: %d sent (%.0f/s), %d failed, %d throttled",
+		elapsed.Round(time.Second), counts.Sent.Load(), float64(counts.Sent.Load())/elapsed.Seconds(), counts.Failed.Load(), counts.Throttled.Load())
+	return nil
+}
+
+// bench parses the arguments of "bench [-rate 5000] [-query-workers 4] [-duration 1m] ..."
+func bench(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	target, apiKey, config := loadgenFlags(flags, time.Minute)
+	queryKey := flags.String("query-key", "", "query API key (defaults to -api-key)")
+	benchConfig := loadgen.BenchConfig{}
+	flags.IntVar(&benchConfig.QueryWorkers, "query-workers", 4, "concurrent query clients (0 = ingest only)")
+	flags.DurationVar(&benchConfig.Settle, "settle", 10*time.Second, "wait after the load before reading insert stats")
+	flags.Parse(args)
+	if err := config.finish(); err != nil {
+		return err
+	}
+	if benchConfig.QueryWorkers < 0 {
+		return fmt.Errorf("-query-workers can't be negative")
+	}
+	if *queryKey == "" {
+		queryKey = apiKey
+	}
+	benchConfig.Ingest = config.Config
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("benchmarking %s for %v: %d events/s with %d query workers", *target, config.Duration, config.Rate, benchConfig.QueryWorkers)
+	report, err := loadgen.Bench(ctx, loadgen.NewSender(*target, *apiKey), loadgen.NewSender(*target, *queryKey), benchConfig, os.Stdout)
+	if err != nil {
+		return err
+	}
+	report.Print(os.Stdout)
+	return nil
+}
+
+// loadgenConfig is a loadgen.Config being parsed from flags
+type loadgenConfig struct {
+	loadgen.Config
+	envs *string
+}
+
+// loadgenFlags registers the flags generate and bench share
+func loadgenFlags(flags *flag.FlagSet, duration time.Duration) (target, apiKey *string, config *loadgenConfig) {
+	target = flags.String("target", "http://localhost:"+env.Port, "base URL of the monitor-core instance")
+	apiKey = flags.String("api-key", env.APIKey, "ingest API key (defaults to API_KEY)")
+	config = &loadgenConfig{}
+	flags.IntVar(&config.Services, "services", 5, "number of fake services")
+	flags.IntVar(&config.Rate, "rate", 1000, "events per second")
+	flags.DurationVar(&config.Duration, "duration", duration, "how long to generate")
+	flags.IntVar(&config.BatchSize, "batch", 500, "events per request")
+	flags.IntVar(&config.Workers, "workers", 4, "concurrent requests")
+	flags.Int64Var(&config.Seed, "seed", time.Now().UnixNano(), "random seed, to reproduce a run")
+	config.envs = flags.String("envs", "production,staging", "comma-separated envs to spread events over")
+	return target, apiKey, config
+}
+
+// finish validates the parsed flags
+func (c *loadgenConfig) finish() error {
+	if c.Services <= 0 || c.Rate <= 0 || c.BatchSize <= 0 || c.Workers <= 0 {
+		return fmt.Errorf("-services, -rate, -batch, and -workers must be positive")
+	}
+	c.Envs = strings.Split(*c.envs, ",")
+	return nil
+}
+
+// API surfaces a listener can serve
+const (
+	surfaceIngest = "ingest"
+	surfaceQuery  = "query"
+	surfaceAdmin  = "admin"
+)
+
+// listener is an address and the API surfaces served on it
+type listener struct {
+	Addr     string
+	Surfaces []string
+}
+
+// parseListeners parses LISTENERS ("addr=surface+surface,..."). Without it, every surface
+// is served on HTTP_PORT. ADMIN_ADDR moves the admin surface to a listener of its own.
+func parseListeners(config map[string]string, port, adminAddr string) ([]listener, error) {
+	var listeners []listener
+	if len(config) == 0 {
+		surfaces := []string{surfaceIngest, surfaceQuery}
+		if adminAddr == "" {
+			surfaces = append(surfaces, surfaceAdmin)
+		}
+		listeners = append(listeners, listener{Addr: ":" + port, Surfaces: surfaces})
+	}
+
+	addrs := make([]string, 0, len(config))
+	for addr := range config {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		l := listener{Addr: addr}
+		for _, surface := range strings.Split(config[addr], "+") {
+			switch surface = strings.TrimSpace(surface); surface {
+			case surfaceIngest, surfaceQuery, surfaceAdmin:
+				if surface == surfaceAdmin && adminAddr != "" {
+					return nil, fmt.Errorf("%s serves the admin surface, which ADMIN_ADDR already moves to %s", addr, adminAddr)
+				}
+				l.Surfaces = append(l.Surfaces, surface)
+			default:
+				return nil, fmt.Errorf("unknown surface %q for %s (expected %s, %s, or %s)", surface, addr, surfaceIngest, surfaceQuery, surfaceAdmin)
+			}
+		}
+		listeners = append(listeners, l)
+	}
+
+	if adminAddr != "" {
+		if _, ok := config[adminAddr]; ok {
+			return nil, fmt.Errorf("ADMIN_ADDR %s is also in LISTENERS", adminAddr)
+		}
+		listeners = append(listeners, listener{Addr: adminAddr, Surfaces: []string{surfaceAdmin}})
+	}
+	return listeners, nil
+}
+
+// newRouter builds the handler for a listener serving the given surfaces, with h serving
+// the routes that read or write ClickHouse
+func newRouter(surfaces []string, limiter *middleware.RateLimiter, h *routes.Handlers) http.Handler {
+	serves := func(surface string) bool {
+		return slices.Contains(surfaces, surface)
+	}
+
+	// Per-route timeouts: ingest is short, queries longer, event exports and bulk jobs longer,
+	// and ingest streams longest. Every class is metered per API key.
+	ingestTimeout := middleware.Timeout(env.IngestTimeout)
+	streamTimeout := middleware.Timeout(env.StreamTimeout)
+	queryTimeout := middleware.Timeout(env.QueryTimeout)
+	exportTimeout := middleware.Timeout(env.ExportTimeout)
+	ingestMeter := middleware.Meter(services.UsageIngest)
+	queryMeter := middleware.Meter(services.UsageQuery)
+	exportMeter := middleware.Meter(services.UsageExport)
+
+	// Ingest bodies may be gzip, zstd, or snappy; streams have no size cap but their line length
+	decompress := middleware.Decompress(routes.MaxRequestBodySize, int64(env.DecompressedLimit))
+	streamDecompress := middleware.Decompress(0, 0)
+
+	ingest := func(next http.HandlerFunc) http.HandlerFunc {
+		return ingestMeter(limiter.Wrap(ingestTimeout(decompress(next))))
+	}
+	stream := func(next http.HandlerFunc) http.HandlerFunc {
+		return ingestMeter(limiter.Wrap(streamTimeout(streamDecompress(next))))
+	}
+	query := func(next http.HandlerFunc) http.HandlerFunc {
+		return queryMeter(queryTimeout(middleware.QueryStats(next)))
+	}
+	export := func(next http.HandlerFunc) http.HandlerFunc {
+		return exportMeter(exportTimeout(next))
+	}
+	// Large reads of stored events get the export deadline but report their query stats
+	exportQuery := func(next http.HandlerFunc) http.HandlerFunc {
+		return exportMeter(exportTimeout(middleware.QueryStats(next)))
+	}
+
+	// Setup router
+	r := mux.NewRouter()
+	r.Use(middleware.RequestIDMiddleware)
+	r.Use(middleware.LoggingMiddleware)
+	r.Use(middleware.MuxHeaderMiddleware)
+
+	r.HandleFunc("/health", h.HealthHandler).Methods(http.MethodGet)
+
+	// Admin routes, metrics, and profiling check the admin API key. They are registered
+	// before /v1 so its subrouter doesn't shadow /v1/admin.
+	if serves(surfaceAdmin) {
+		admin := r.PathPrefix("/v1/admin").Subrouter()
+		admin.Use(middleware.AdminAuthMiddleware)
+
+		admin.HandleFunc("/storage", query(h.GetStorageStatsHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/health/history", query(routes.GetHealthHistoryHandler)).Methods(http.MethodGet)
+		if h.ChaosEnabled() {
+			admin.HandleFunc("/chaos", query(h.GetChaosHandler)).Methods(http.MethodGet)
+			admin.HandleFunc("/chaos", query(h.PutChaosHandler)).Methods(http.MethodPut)
+		}
+		admin.HandleFunc("/events/delete", export(h.DeleteEventsHandler)).Methods(http.MethodPost)
+		admin.HandleFunc("/events/redact", export(h.RedactEventsHandler)).Methods(http.MethodPost)
+		admin.HandleFunc("/mutations", query(h.GetMutationsHandler)).Methods(http.MethodGet)
+		if services.ReplayEnabled() {
+			admin.HandleFunc("/replays", export(h.StartReplayHandler)).Methods(http.MethodPost)
+			admin.HandleFunc("/replays", query(routes.ListReplaysHandler)).Methods(http.MethodGet)
+			admin.HandleFunc("/replays/{id}", query(routes.GetReplayHandler)).Methods(http.MethodGet)
+			admin.HandleFunc("/replays/{id}", query(routes.CancelReplayHandler)).Methods(http.MethodDelete)
+		}
+		admin.HandleFunc("/lookups", query(h.ListLookupsHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/lookups/{name}", query(h.GetLookupHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/lookups/{name}", query(h.PutLookupHandler)).Methods(http.MethodPut)
+		admin.HandleFunc("/lookups/{name}", query(h.DeleteLookupHandler)).Methods(http.MethodDelete)
+		admin.HandleFunc("/pipeline/rules", query(h.ListPipelineRulesHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/pipeline/rules/{name}", query(h.GetPipelineRuleHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/pipeline/rules/{name}", query(h.PutPipelineRuleHandler)).Methods(http.MethodPut)
+		admin.HandleFunc("/pipeline/rules/{name}", query(h.DeletePipelineRuleHandler)).Methods(http.MethodDelete)
+		admin.HandleFunc("/pipeline/rules/{name}/versions", query(h.ListPipelineRuleVersionsHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/pipeline/test", query(routes.TestPipelineRulesHandler)).Methods(http.MethodPost)
+		admin.HandleFunc("/keys", query(h.ListAPIKeysHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/keys", query(h.CreateAPIKeyHandler)).Methods(http.MethodPost)
+		admin.HandleFunc("/keys/usage", query(h.ListKeyUsageHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/keys/{id}/rotate", query(h.RotateAPIKeyHandler)).Methods(http.MethodPost)
+		admin.HandleFunc("/keys/{id}", query(h.GetAPIKeyHandler)).Methods(http.MethodGet)
+		admin.HandleFunc("/keys/{id}", query(h.RevokeAPIKeyHandler)).Methods(http.MethodDelete)
+		admin.HandleFunc("/keys/{id}/usage", query(h.GetKeyUsageHandler)).Methods(http.MethodGet)
+
+		internal := r.NewRoute().Subrouter()
+		internal.Use(middleware.AdminAuthMiddleware)
+
+		internal.HandleFunc("/metrics", query(h.MetricsHandler)).Methods(http.MethodGet)
+		internal.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		internal.HandleFunc("/debug/pprof/profile", export(pprof.Profile))
+		internal.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		internal.HandleFunc("/debug/pprof/trace", export(pprof.Trace))
+		internal.PathPrefix("/debug/pprof/").HandlerFunc(export(pprof.Index))
+	}
+
+	// OIDC login for human users of the query and admin surfaces
+	if h.LoginEnabled() && (serves(surfaceQuery) || serves(surfaceAdmin)) {
+		auth := r.PathPrefix("/auth").Subrouter()
+		auth.HandleFunc("/login", h.LoginHandler).Methods(http.MethodGet)
+		auth.HandleFunc("/callback", h.CallbackHandler).Methods(http.MethodGet)
+		auth.HandleFunc("/logout", routes.LogoutHandler).Methods(http.MethodPost)
+		auth.Handle("/me", middleware.QueryAuthMiddleware(http.HandlerFunc(routes.MeHandler))).Methods(http.MethodGet)
+	}
+
+	if serves(surfaceIngest) {
+		// V1 ingest routes take API keys only
+		v1 := r.PathPrefix("/v1").Subrouter()
+		v1.Use(middleware.AuthMiddleware)
+
+		// Browser RUM beacons use the public RUM token instead of the API key
+		rum := r.PathPrefix("/v1/rum").Subrouter()
+		rum.Use(middleware.RUMMiddleware)
+
+		rum.HandleFunc("", ingest(h.RUMHandler)).Methods(http.MethodPost)
+		rum.HandleFunc("", ingest(h.RUMBeaconHandler)).Methods(http.MethodGet)
+
+		// Inbound webhooks are authenticated by each source's signature
+		r.HandleFunc("/v1/webhooks/{source}", ingest(h.WebhookHandler)).Methods(http.MethodPost)
+
+		v1.HandleFunc("/events", ingest(h.IngestEventsHandler)).Methods(http.MethodPost)
+		v1.HandleFunc("/events/stream", stream(h.StreamEventsHandler)).Methods(http.MethodPost)
+		v1.HandleFunc("/backfill", export(decompress(h.BackfillHandler))).Methods(http.MethodPost)
+
+		// Log drain routes (Heroku logplex and RFC6587 framed syslog over HTTPS)
+		v1.HandleFunc("/drains/heroku", ingest(h.HerokuDrainHandler)).Methods(http.MethodPost)
+		v1.HandleFunc("/drains/syslog", ingest(h.SyslogDrainHandler)).Methods(http.MethodPost)
+
+		// Kinesis Data Firehose HTTP endpoint destination (CloudWatch Logs subscriptions)
+		v1.HandleFunc("/firehose", ingest(h.FirehoseHandler)).Methods(http.MethodPost)
+
+		// OpenTelemetry log and trace exports over OTLP/HTTP (Collector otlphttp exporter, SDKs)
+		v1.HandleFunc("/otlp/logs", ingest(h.OTLPLogsHandler)).Methods(http.MethodPost)
+		v1.HandleFunc("/otlp/traces", ingest(h.OTLPTracesHandler)).Methods(http.MethodPost)
+
+		// Prometheus remote write (samples as events named after the metric)
+		v1.HandleFunc("/prom/write", ingest(h.PromWriteHandler)).Methods(http.MethodPost)
+
+		// Alertmanager webhook receiver (Prometheus alerts as alert.firing/alert.resolved events)
+		v1.HandleFunc("/alertmanager", ingest(h.AlertmanagerHandler)).Methods(http.MethodPost)
+
+		// Loki push API compatibility (Promtail, Vector, Fluent Bit)
+		loki := r.PathPrefix("/loki/api/v1").Subrouter()
+		loki.Use(middleware.AuthMiddleware)
+
+		loki.HandleFunc("/push", ingest(h.LokiPushHandler)).Methods(http.MethodPost)
+
+		// Sentry SDK envelope ingestion (DSN: http://<API_KEY>@host/<project>)
+		sentry := r.PathPrefix("/api").Subrouter()
+		sentry.Use(middleware.SentryAuthMiddleware)
+
+		sentry.HandleFunc("/{project}/envelope/", ingest(h.SentryEnvelopeHandler)).Methods(http.MethodPost)
+	}
+
+	if serves(surfaceQuery) {
+		// Query routes also accept OIDC sessions
+		api := r.PathPrefix("/v1").Subrouter()
+		api.Use(middleware.QueryAuthMiddleware)
+
+		// The status page uses its own shareable token instead of the API key
+		status := r.NewRoute().Subrouter()
+		status.Use(middleware.StatusMiddleware)
+
+		status.HandleFunc("/v1/status", query(h.StatusHandler)).Methods(http.MethodGet)
+		status.HandleFunc("/status", query(h.StatusPageHandler)).Methods(http.MethodGet)
+
+		api.HandleFunc("/events", exportQuery(h.QueryEventsHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/events/summary", query(h.EventsSummaryHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/events/{id}/payload", export(routes.GetPayloadHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/labels/{label}/values", query(h.GetLabelValuesHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/data/keys", query(h.GetDataKeysHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/data/values", query(h.GetDataValuesHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/cardinality", query(h.GetCardinalityHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/event-names", query(h.GetEventNamesHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/schema-drift", query(h.GetSchemaDriftHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/duplicates", query(h.GetDuplicatesHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/ingest-lag", query(h.GetIngestLagHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/quotas", query(routes.GetQuotasHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/traces/critical-path", query(h.CriticalPathOperationsHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/traces/flamegraph", query(h.FlameGraphHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/traces/{id}", query(h.TraceExistsHandler)).Methods(http.MethodHead)
+		api.HandleFunc("/traces/{id}", exportQuery(h.GetTraceHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/traces/{id}/logs", exportQuery(h.GetTraceLogsHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/traces/{id}/critical-path", query(h.TraceCriticalPathHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/requests/{id}", query(h.RequestExistsHandler)).Methods(http.MethodHead)
+		api.HandleFunc("/requests/{id}/trace", exportQuery(h.GetRequestTraceHandler)).Methods(http.MethodGet)
+
+		// Analytics routes (Grafana-compatible)
+		api.HandleFunc("/analytics", query(h.AnalyticsHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/analytics", query(h.AnalyticsQueryHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/timeseries", query(h.TimeSeriesHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/timeseries", query(h.TimeSeriesQueryHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/topn", query(h.TopNHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/gauge", query(h.GaugeHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/compare", query(h.CompareHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/compare/filters", query(h.FilterCompareHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/ratio", query(h.RatioHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/query/validate", query(routes.ValidateQueryHandler)).Methods(http.MethodPost)
+
+		// Saved queries
+		api.HandleFunc("/queries", query(h.ListSavedQueriesHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/queries/{name}", query(h.GetSavedQueryHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/queries/{name}", query(h.PutSavedQueryHandler)).Methods(http.MethodPut)
+		api.HandleFunc("/queries/{name}", query(h.DeleteSavedQueryHandler)).Methods(http.MethodDelete)
+		api.HandleFunc("/queries/{name}/run", query(h.RunSavedQueryHandler)).Methods(http.MethodGet, http.MethodPost)
+
+		// Query history and starred queries of the caller
+		api.HandleFunc("/history", query(h.ListQueryHistoryHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/history/starred", query(h.ListStarredQueriesHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/history/{id}/star", query(h.StarQueryHandler)).Methods(http.MethodPut)
+		api.HandleFunc("/history/{id}/star", query(h.UnstarQueryHandler)).Methods(http.MethodDelete)
+		api.HandleFunc("/history/{id}/run", query(h.RunHistoryQueryHandler)).Methods(http.MethodPost)
+
+		// Alerting
+		api.HandleFunc("/alerts/backtest", query(h.AlertBacktestHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/alerts/rules", query(h.ListAlertRulesHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/alerts/rules/{name}", query(h.GetAlertRuleHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/alerts/rules/{name}", query(h.PutAlertRuleHandler)).Methods(http.MethodPut)
+		api.HandleFunc("/alerts/rules/{name}", query(h.DeleteAlertRuleHandler)).Methods(http.MethodDelete)
+
+		// Saved queries and alert rules as code
+		api.HandleFunc("/config/export", query(h.ExportConfigHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/config/export", query(h.ApplyConfigHandler)).Methods(http.MethodPut)
+
+		// Reports
+		api.HandleFunc("/reports/digest", query(h.DigestHandler)).Methods(http.MethodPost)
+
+		// Incidents
+		api.HandleFunc("/incidents", query(h.ListIncidentsHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/incidents", query(h.CreateIncidentHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/incidents/{id}", query(h.GetIncidentHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/incidents/{id}", query(h.UpdateIncidentHandler)).Methods(http.MethodPut)
+		api.HandleFunc("/incidents/{id}", query(h.DeleteIncidentHandler)).Methods(http.MethodDelete)
+		api.HandleFunc("/incidents/{id}/events", export(h.ExportIncidentEventsHandler)).Methods(http.MethodGet)
+
+		// Snapshots
+		api.HandleFunc("/snapshots", query(h.ListSnapshotsHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/snapshots", export(h.CreateSnapshotHandler)).Methods(http.MethodPost)
+		api.HandleFunc("/snapshots/{id}", query(h.GetSnapshotHandler)).Methods(http.MethodGet)
+		api.HandleFunc("/snapshots/{id}", query(h.DeleteSnapshotHandler)).Methods(http.MethodDelete)
+
+		// Embedded web UI
+		if env.UIEnabled {
+			r.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+			r.PathPrefix("/ui/").Handler(middleware.UIAuthMiddleware(routes.UIHandler())).Methods(http.MethodGet)
+		}
+	}
+
+	// CORS Middleware
+	corsMiddleware := cors.New(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+		AllowedHeaders:   []string{"X-Requested-With", "Content-Type", "Origin", "Authorization", "Accept", "X-Api-Key", "X-Sentry-Auth", "X-Rum-Token", "X-Status-Token", "If-Match", "If-None-Match", "Referer", "Dnt", "User-Agent"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"},
+		ExposedHeaders:   []string{"ETag", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
+	})
+
+	return corsMiddleware.Handler(r)
+}
+
+// listen opens a listener for a TCP address or, with a unix: prefix, a unix socket
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// A socket left by an unclean shutdown would make the bind fail
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
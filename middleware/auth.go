@@ -2,24 +2,143 @@ package middleware
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/services"
 )
 
-// AuthMiddleware checks the X-Api-Key header
+// AuthMiddleware checks the API key sent by the client
 func AuthMiddleware(next http.Handler) http.Handler {
+	return requireAPIKey(next, GetAPIKey)
+}
+
+// SentryAuthMiddleware checks the API key sent by Sentry SDKs as the DSN public key
+func SentryAuthMiddleware(next http.Handler) http.Handler {
+	return requireAPIKey(next, GetSentryKey)
+}
+
+// AdminAuthMiddleware checks the admin API key on the admin surface, falling back
+// to the regular API keys when ADMIN_API_KEY is not set. OIDC users need the admin role,
+// and keys with an access role are never admins.
+func AdminAuthMiddleware(next http.Handler) http.Handler {
+	fallback := AuthMiddleware(unrestricted(next))
+	if env.AdminAPIKey != "" {
+		fallback = requireKey(next, GetAPIKey, func() bool { return true }, func(key string) (string, bool) {
+			return "", key == env.AdminAPIKey
+		})
+	}
+	return withSession(services.RoleAdmin, fallback, next)
+}
+
+// requireAPIKey accepts API_KEY and the managed keys. Authentication is enforced once
+// either exists.
+func requireAPIKey(next http.Handler, extract func(*http.Request) string) http.Handler {
+	enforced := func() bool {
+		return env.APIKey != "" || services.APIKeysConfigured()
+	}
+	valid := func(key string) (string, bool) {
+		if env.APIKey != "" && key == env.APIKey {
+			return "", true
+		}
+		return services.LookupAPIKey(key)
+	}
+	return requireKey(next, extract, enforced, valid)
+}
+
+// requireKey checks the extracted key with valid, which returns the key's access role
+func requireKey(next http.Handler, extract func(*http.Request) string, enforced func() bool, valid func(string) (string, bool)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// If no API key is configured, allow all requests (for development)
-		if env.APIKey == "" {
-			next.ServeHTTP(w, r)
+		if !enforced() {
+			next.ServeHTTP(w, r.WithContext(services.WithPrincipal(r.Context(), "anonymous")))
 			return
 		}
 
-		if r.Header.Get("X-Api-Key") != env.APIKey {
+		key := extract(r)
+		role, ok := valid(key)
+		if !ok {
+			services.EmitInternal("auth.failed", "warn", map[string]interface{}{
+				"client_ip":  GetClientIPFromContext(r.Context()),
+				"request_id": GetRequestID(r.Context()),
+				"method":     r.Method,
+				"path":       r.URL.Path,
+			})
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		serveWithRole(w, r.WithContext(services.WithPrincipal(r.Context(), "key:"+services.KeyID(key))), next, role)
+	})
+}
+
+// serveWithRole serves next with the access role in the context, so the query builders
+// restrict what it reads, after checking the role may call the endpoint
+func serveWithRole(w http.ResponseWriter, r *http.Request, next http.Handler, name string) {
+	role, ok := services.LookupAccessRole(name)
+	if ok && role != nil && !role.AllowsEndpoint(r.URL.Path) {
+		ok = false
+	}
+	if !ok {
+		services.EmitInternal("auth.forbidden", "warn", map[string]interface{}{
+			"role":       name,
+			"client_ip":  GetClientIPFromContext(r.Context()),
+			"request_id": GetRequestID(r.Context()),
+			"method":     r.Method,
+			"path":       r.URL.Path,
+		})
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(services.WithAccessRole(r.Context(), role)))
+}
+
+// unrestricted rejects requests restricted by an access role
+func unrestricted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if services.AccessRoleFromContext(r.Context()) != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// GetAPIKey extracts the API key from the X-Api-Key header, a Bearer token,
+// the password of HTTP basic auth (for shippers that can't set custom headers),
+// or the Kinesis Firehose access key header
+func GetAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if key := r.Header.Get("X-Amz-Firehose-Access-Key"); key != "" {
+		return key
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(authHeader, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	return ""
+}
+
+// GetSentryKey extracts sentry_key from the X-Sentry-Auth header or the sentry_key
+// query parameter (used by browser SDKs), falling back to the regular API key sources
+func GetSentryKey(r *http.Request) string {
+	if auth, ok := strings.CutPrefix(r.Header.Get("X-Sentry-Auth"), "Sentry "); ok {
+		for _, part := range strings.Split(auth, ",") {
+			if key, ok := strings.CutPrefix(strings.TrimSpace(part), "sentry_key="); ok {
+				return key
+			}
+		}
+	}
+	if key := r.URL.Query().Get("sentry_key"); key != "" {
+		return key
+	}
+	return GetAPIKey(r)
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DecodedKey holds the Content-Encoding Decompress decoded the request body from, if any
+const DecodedKey contextKey = "decoded"
+
+// GetDecodedEncoding returns the Content-Encoding the request body was decoded from
+// ("gzip", "zstd", or "snappy"), or "" when it is read as sent
+func GetDecodedEncoding(ctx context.Context) string {
+	encoding, _ := ctx.Value(DecodedKey).(string)
+	return encoding
+}
+
+// Decompress decodes request bodies sent with Content-Encoding gzip, zstd, or snappy (the
+// block format of Prometheus remote write), so handlers read the plain body, and records
+// the encoding for GetDecodedEncoding. maxBody caps the body as sent and maxDecoded the
+// body once decoded, so a small compressed body can't expand without bound; 0 disables a
+// cap. Other encodings get 415.
+func Decompress(maxBody, maxDecoded int64) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if maxBody > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+			}
+
+			var decoded io.ReadCloser
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			switch encoding {
+			case "", "identity":
+				next(w, r)
+				return
+			case "gzip", "x-gzip":
+				encoding = "gzip"
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+					return
+				}
+				decoded = zr
+			case "zstd":
+				options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+				if maxDecoded > 0 {
+					options = append(options, zstd.WithDecoderMaxMemory(uint64(maxDecoded)))
+				}
+				zr, err := zstd.NewReader(r.Body, options...)
+				if err != nil {
+					http.Error(w, "Invalid zstd body", http.StatusBadRequest)
+					return
+				}
+				decoded = zr.IOReadCloser()
+			case "snappy":
+				// Snappy blocks aren't streamed, but the header gives the decoded length
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					http.Error(w, "Failed to read request body", http.StatusBadRequest)
+					return
+				}
+				n, err := snappy.DecodedLen(body)
+				if err != nil {
+					http.Error(w, "Invalid snappy body", http.StatusBadRequest)
+					return
+				}
+				if maxDecoded > 0 && int64(n) > maxDecoded {
+					http.Error(w, "Decompressed body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				plain, err := snappy.Decode(nil, body)
+				if err != nil {
+					http.Error(w, "Invalid snappy body", http.StatusBadRequest)
+					return
+				}
+				decoded = io.NopCloser(bytes.NewReader(plain))
+			default:
+				http.Error(w, "Unsupported Content-Encoding "+encoding+" (use gzip, zstd, or snappy)", http.StatusUnsupportedMediaType)
+				return
+			}
+			defer decoded.Close()
+
+			if maxDecoded > 0 {
+				decoded = http.MaxBytesReader(w, decoded, maxDecoded)
+			}
+			r.Body = decoded
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+			next(w, r.WithContext(context.WithValue(r.Context(), DecodedKey, encoding)))
+		}
+	}
+}
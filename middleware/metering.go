@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// Meter records the requests, errors, and bytes of each API key for a request class.
+// It runs inside the auth middleware, so the key has already been checked.
+func Meter(class string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := GetSentryKey(r)
+			if key == "" {
+				next(w, r)
+				return
+			}
+
+			body := &countingReader{ReadCloser: r.Body}
+			r.Body = body
+			mw := &meteredResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+			next(mw, r)
+
+			services.RecordUsage(services.KeyID(key), class, mw.statusCode, body.n, mw.n)
+		}
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type meteredResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	n          int64
+}
+
+func (mw *meteredResponseWriter) WriteHeader(code int) {
+	mw.statusCode = code
+	mw.ResponseWriter.WriteHeader(code)
+}
+
+func (mw *meteredResponseWriter) Write(p []byte) (int, error) {
+	n, err := mw.ResponseWriter.Write(p)
+	mw.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection
+func (mw *meteredResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// QueryStats collects the stats of the ClickHouse queries a request runs, which query
+// handlers return in the response's meta block
+func QueryStats(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(services.WithQueryStats(r.Context())))
+	}
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/pipeline"
+)
+
+// rateLimitIdle is how long an unused bucket is kept before it is swept
+const rateLimitIdle = 10 * time.Minute
+
+// RateLimiter limits ingest requests per client with a token bucket, keyed by the API key
+// (or the client IP without one), and rejects ingest outright while the event queue is full
+type RateLimiter struct {
+	rate  float64
+	burst int
+	queue *pipeline.Queue
+	// drainInterval is how long a full queue takes to make room (the flush interval)
+	drainInterval time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate requests per second per client with bursts
+// of up to burst requests. A zero rate disables per-client limiting; queue backpressure
+// still applies.
+func NewRateLimiter(rate float64, burst int, queue *pipeline.Queue, drainInterval time.Duration) *RateLimiter {
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &RateLimiter{
+		rate:          rate,
+		burst:         burst,
+		queue:         queue,
+		drainInterval: drainInterval,
+		buckets:       make(map[string]*bucket),
+		lastSweep:     time.Now(),
+	}
+}
+
+// Wrap applies the limiter to a handler. Every limited response carries X-RateLimit-Limit,
+// X-RateLimit-Remaining, and X-RateLimit-Reset (seconds until the bucket is full);
+// rejections add Retry-After.
+func (l *RateLimiter) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.queue != nil && l.queue.Saturated() {
+			retryAfter := max(1, int(math.Ceil(l.drainInterval.Seconds())))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Event queue is full, retry later", http.StatusServiceUnavailable)
+			return
+		}
+
+		if l.rate <= 0 {
+			next(w, r)
+			return
+		}
+
+		allowed, remaining, reset, retryAfter := l.take(l.clientKey(r), time.Now())
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(reset))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// take spends a token from the client's bucket, returning whether the request is allowed,
+// the whole tokens left, and the seconds until the bucket is full and until a token is available
+func (l *RateLimiter) take(key string, now time.Time) (allowed bool, remaining, reset, retryAfter int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > rateLimitIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		allowed = true
+	} else {
+		retryAfter = int(math.Ceil((1 - b.tokens) / l.rate))
+	}
+	remaining = int(b.tokens)
+	reset = int(math.Ceil((float64(l.burst) - b.tokens) / l.rate))
+	return allowed, remaining, reset, retryAfter
+}
+
+// clientKey identifies the client by its API key, falling back to its IP
+func (l *RateLimiter) clientKey(r *http.Request) string {
+	if key := GetSentryKey(r); key != "" {
+		return "key:" + key
+	}
+	return "ip:" + GetClientIPFromContext(r.Context())
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// RUMMiddleware guards browser beacon routes with the public RUM token and the origin allowlist
+// The RUM token is embedded in web pages, so it only grants write access to RUM beacons
+func RUMMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env.RUMToken == "" {
+			http.Error(w, "RUM ingestion is disabled", http.StatusNotFound)
+			return
+		}
+
+		token := r.Header.Get("X-Rum-Token")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if token != env.RUMToken {
+			rejectToken(w, r, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		if len(env.RUMAllowedOrigins) > 0 && !slices.Contains(env.RUMAllowedOrigins, getOrigin(r)) {
+			rejectToken(w, r, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectToken logs a failed public token check as an auth.failed self event
+func rejectToken(w http.ResponseWriter, r *http.Request, reason string, status int) {
+	services.EmitInternal("auth.failed", "warn", map[string]interface{}{
+		"client_ip":  GetClientIPFromContext(r.Context()),
+		"request_id": GetRequestID(r.Context()),
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"origin":     getOrigin(r),
+		"reason":     reason,
+	})
+	http.Error(w, http.StatusText(status), status)
+}
+
+// getOrigin returns the Origin header, or the origin of the Referer for requests
+// that don't send one (image beacons and some sendBeacon implementations)
+func getOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	if u, err := url.Parse(r.Header.Get("Referer")); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return ""
+}
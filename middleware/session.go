@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// SessionKey holds the OIDC session of the request, if it was authenticated by one
+const SessionKey contextKey = "session"
+
+// GetSession returns the request's OIDC session, or nil
+func GetSession(ctx context.Context) *services.Session {
+	session, _ := ctx.Value(SessionKey).(*services.Session)
+	return session
+}
+
+// QueryAuthMiddleware accepts an OIDC session for any role, or an API key. Ingest routes
+// use AuthMiddleware, so human logins can't write events.
+func QueryAuthMiddleware(next http.Handler) http.Handler {
+	return withSession(services.RoleQuery, AuthMiddleware(next), next)
+}
+
+// UIAuthMiddleware sends browsers without a session to the OIDC login when it is configured.
+// Without OIDC the UI is a static shell that calls the API with a key entered by the user.
+func UIAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env.OIDCIssuer != "" && !sessionFromCookie(r).HasRole(services.RoleQuery) {
+			http.Redirect(w, r, "/auth/login?return="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withSession serves authorized with the session (and its access role) in the context
+// when the request carries a session granting role, and fallback (API key auth) otherwise
+func withSession(role string, fallback, authorized http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if session := sessionFromCookie(r); session.HasRole(role) {
+			ctx := context.WithValue(r.Context(), SessionKey, session)
+			ctx = services.WithPrincipal(ctx, "user:"+session.Subject)
+			serveWithRole(w, r.WithContext(ctx), authorized, session.Role)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+func sessionFromCookie(r *http.Request) *services.Session {
+	if env.OIDCSessionSecret == "" {
+		return nil
+	}
+	cookie, err := r.Cookie(services.SessionCookie)
+	if err != nil {
+		return nil
+	}
+	var session services.Session
+	if err := services.VerifyValue([]byte(env.OIDCSessionSecret), cookie.Value, &session); err != nil {
+		return nil
+	}
+	return &session
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/env"
+)
+
+// StatusMiddleware guards the status page with STATUS_PAGE_TOKEN. The token is shared with
+// whoever should see the page, so it grants nothing but the status page.
+func StatusMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env.StatusPageToken == "" {
+			http.Error(w, "Status page is disabled", http.StatusNotFound)
+			return
+		}
+
+		token := r.Header.Get("X-Status-Token")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if token != env.StatusPageToken {
+			rejectToken(w, r, "invalid status page token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// timeoutGrace is how long past the deadline the connection stays writable, so a handler
+// whose query was cancelled can still send its error response
+const timeoutGrace = 5 * time.Second
+
+// Timeout bounds a handler by d: the request context gets a deadline, which cancels the
+// handler's ClickHouse queries when it passes, and the connection's read and write
+// deadlines are set to match. A zero d leaves the handler unbounded.
+func Timeout(d time.Duration) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if d <= 0 {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			deadline := time.Now().Add(d)
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+
+			// Not every ResponseWriter supports deadlines; the context still applies
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(deadline)
+			_ = rc.SetWriteDeadline(deadline.Add(timeoutGrace))
+
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
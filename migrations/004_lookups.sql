@@ -0,0 +1,38 @@
+-- Admin-managed lookup tables (e.g. service -> team), queried as dict.<name>
+CREATE TABLE IF NOT EXISTS monitor.lookup_definitions
+(
+    name String,
+    source String,
+    version UInt64,
+    deleted UInt8 DEFAULT 0,
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY name;
+
+CREATE TABLE IF NOT EXISTS monitor.lookups
+(
+    name String,
+    version UInt64,
+    key String,
+    value String
+)
+ENGINE = MergeTree
+ORDER BY (name, version, key);
+
+-- Only the current version of each lookup is loaded
+CREATE DICTIONARY IF NOT EXISTS monitor.lookups_dict
+(
+    name String,
+    key String,
+    value String
+)
+PRIMARY KEY name, key
+SOURCE(CLICKHOUSE(QUERY '
+    SELECT l.name, l.key, l.value
+    FROM monitor.lookups AS l
+    INNER JOIN (SELECT name, version FROM monitor.lookup_definitions FINAL WHERE deleted = 0) AS d
+        ON l.name = d.name AND l.version = d.version
+'))
+LIFETIME(MIN 30 MAX 60)
+LAYOUT(COMPLEX_KEY_HASHED());
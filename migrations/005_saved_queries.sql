@@ -0,0 +1,12 @@
+-- Saved query definitions with declared variables
+CREATE TABLE IF NOT EXISTS monitor.saved_queries
+(
+    name String,
+    type LowCardinality(String),
+    query String,
+    variables String,
+    deleted UInt8 DEFAULT 0,
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY name;
@@ -0,0 +1,15 @@
+-- Per-minute request metering by API key (keys are stored as a hash prefix, never in full)
+CREATE TABLE IF NOT EXISTS monitor.key_usage
+(
+    minute DateTime('UTC'),
+    key_id LowCardinality(String),
+    class LowCardinality(String),
+    requests UInt64,
+    errors UInt64,
+    bytes_in UInt64,
+    bytes_out UInt64
+)
+ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(minute)
+ORDER BY (key_id, class, minute)
+TTL minute + INTERVAL 90 DAY;
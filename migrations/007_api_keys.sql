@@ -0,0 +1,14 @@
+-- Managed API keys (stored as SHA-256 hashes); a rotated key stays valid until expires_at
+CREATE TABLE IF NOT EXISTS monitor.api_keys
+(
+    key_id String,
+    key_hash String,
+    name String,
+    created_at DateTime64(3, 'UTC'),
+    expires_at Nullable(DateTime64(3, 'UTC')),
+    replaced_by String DEFAULT '',
+    revoked UInt8 DEFAULT 0,
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY key_id;
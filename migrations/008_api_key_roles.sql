@@ -0,0 +1,2 @@
+-- Access role of a managed API key; empty means unrestricted
+ALTER TABLE monitor.api_keys ADD COLUMN IF NOT EXISTS role String DEFAULT '' AFTER name;
@@ -0,0 +1,10 @@
+-- Alert rules evaluated by the alert engine
+CREATE TABLE IF NOT EXISTS monitor.alert_rules
+(
+    name String,
+    rule String,
+    deleted UInt8 DEFAULT 0,
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY name;
@@ -0,0 +1,10 @@
+-- Incidents linking alerts, affected services, and resolution notes
+CREATE TABLE IF NOT EXISTS monitor.incidents
+(
+    id String,
+    incident String,
+    deleted UInt8 DEFAULT 0,
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY id;
@@ -0,0 +1,10 @@
+-- Dashboard snapshots: frozen queries and their results
+CREATE TABLE IF NOT EXISTS monitor.snapshots
+(
+    id String,
+    snapshot String,
+    deleted UInt8 DEFAULT 0,
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY id;
@@ -0,0 +1,27 @@
+-- Queries each principal (OIDC user or API key) ran, kept for 90 days
+CREATE TABLE IF NOT EXISTS monitor.query_history
+(
+    principal String,
+    id String,
+    type LowCardinality(String),
+    query String,
+    ran_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMM(ran_at)
+ORDER BY (principal, id, ran_at)
+TTL toDateTime(ran_at) + INTERVAL 90 DAY;
+
+-- Starred queries, copied from the history so they outlive it
+CREATE TABLE IF NOT EXISTS monitor.starred_queries
+(
+    principal String,
+    id String,
+    type String,
+    query String,
+    title String,
+    deleted UInt8 DEFAULT 0,
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (principal, id);
@@ -0,0 +1,11 @@
+-- Ingest pipeline rules (enrich, sample, redact, route); every change is a new version
+CREATE TABLE IF NOT EXISTS monitor.pipeline_rules
+(
+    name String,
+    version UInt32,
+    rule String,
+    deleted UInt8 DEFAULT 0,
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = MergeTree
+ORDER BY (name, version);
@@ -0,0 +1,21 @@
+-- Hourly aggregates kept after raw events expire; field is '' for the event count and
+-- the registered numeric field (e.g. data.duration_ms) otherwise. Rolling up an hour again
+-- writes the same rows, which replace the previous ones.
+CREATE TABLE IF NOT EXISTS monitor.events_hourly
+(
+    hour DateTime('UTC'),
+    service LowCardinality(String),
+    env LowCardinality(String),
+    name LowCardinality(String),
+    level LowCardinality(String),
+    field LowCardinality(String),
+    count UInt64,
+    sum Float64,
+    min Float64,
+    max Float64,
+    quantiles AggregateFunction(quantileTDigest, Float64)
+)
+ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY (field, service, env, name, level, hour)
+TTL hour + INTERVAL 400 DAY;
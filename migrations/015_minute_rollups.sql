@@ -0,0 +1,19 @@
+-- Per-minute aggregates, the same as events_hourly, for minute time series over rollups
+CREATE TABLE IF NOT EXISTS monitor.events_minutely
+(
+    minute DateTime('UTC'),
+    service LowCardinality(String),
+    env LowCardinality(String),
+    name LowCardinality(String),
+    level LowCardinality(String),
+    field LowCardinality(String),
+    count UInt64,
+    sum Float64,
+    min Float64,
+    max Float64,
+    quantiles AggregateFunction(quantileTDigest, Float64)
+)
+ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMMDD(minute)
+ORDER BY (field, service, env, name, level, minute)
+TTL minute + INTERVAL 7 DAY;
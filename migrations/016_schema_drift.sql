@@ -0,0 +1,30 @@
+-- Learned data shape of each event name: the types each data key was seen with. key is ''
+-- for the event itself, so names without data keys are known too.
+CREATE TABLE IF NOT EXISTS monitor.event_schemas
+(
+    name LowCardinality(String),
+    key String,
+    type LowCardinality(String),
+    events SimpleAggregateFunction(sum, UInt64),
+    first_seen SimpleAggregateFunction(min, DateTime64(3, 'UTC')),
+    last_seen SimpleAggregateFunction(max, DateTime64(3, 'UTC'))
+)
+ENGINE = AggregatingMergeTree
+ORDER BY (name, key, type);
+
+-- Schema drift found by comparing each window of events with the learned shapes
+CREATE TABLE IF NOT EXISTS monitor.schema_drifts
+(
+    detected_at DateTime64(3, 'UTC'),
+    name LowCardinality(String),
+    key String,
+    kind LowCardinality(String),
+    type LowCardinality(String),
+    previous_types Array(String),
+    services Array(String),
+    events UInt64
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMM(detected_at)
+ORDER BY (name, detected_at)
+TTL toDate(detected_at) + INTERVAL 90 DAY;
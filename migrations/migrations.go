@@ -0,0 +1,9 @@
+// Package migrations embeds the ClickHouse schema migrations so the binary can apply them
+package migrations
+
+import "embed"
+
+// FS holds the numbered .sql migration files
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// batcher collects events from the queue and flushes them in batches
+type batcher struct {
+	queue         *Queue
+	writer        Writer
+	hooks         *hooks
+	batchSize     int
+	flushInterval time.Duration
+	batch         []*structs.Event
+
+	// progress is when the batcher last flushed or went idle and flushStart when the write
+	// in progress began (0 when none), in unix nanoseconds; both are read by the watchdog
+	progress   atomic.Int64
+	flushStart atomic.Int64
+	batched    atomic.Int64
+
+	// stop is closed when the watchdog replaces this batcher
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// run is the batcher loop. It returns once the queue is closed and drained, the context
+// is done, or the batcher is abandoned.
+func (b *batcher) run(ctx context.Context) {
+	// Abandoning the batcher cancels its in-flight write
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			b.handBack()
+			return
+
+		case <-ctx.Done():
+			if b.stopped() {
+				b.handBack()
+				return
+			}
+			if len(b.batch) > 0 {
+				b.flush(context.Background())
+			}
+			return
+
+		case event, ok := <-b.queue.Events():
+			if !ok {
+				if len(b.batch) > 0 {
+					b.flush(ctx)
+				}
+				return
+			}
+			b.batch = append(b.batch, event)
+			b.batched.Store(int64(len(b.batch)))
+			if len(b.batch) >= b.batchSize {
+				b.flush(ctx)
+			}
+
+		case <-ticker.C:
+			if len(b.batch) > 0 {
+				b.flush(ctx)
+			} else {
+				b.progress.Store(time.Now().UnixNano())
+			}
+		}
+	}
+}
+
+func (b *batcher) flush(ctx context.Context) {
+	if len(b.batch) == 0 {
+		return
+	}
+
+	start := time.Now()
+	b.flushStart.Store(start.UnixNano())
+	err := b.writer.WriteBatch(ctx, b.batch)
+	duration := time.Since(start)
+	b.flushStart.Store(0)
+
+	// A write cancelled by the watchdog keeps its batch for the replacement batcher
+	if err != nil && b.stopped() {
+		log.Printf("abandoned write of %d events after %v: %v", len(b.batch), duration, err)
+		return
+	}
+
+	if err != nil {
+		log.Printf("failed to write batch of %d events: %v", len(b.batch), err)
+	} else {
+		log.Printf("flushed %d events in %v", len(b.batch), duration)
+	}
+	b.hooks.flushed(b.batch, duration, err)
+
+	b.batch = b.batch[:0]
+	b.batched.Store(0)
+	b.progress.Store(time.Now().UnixNano())
+}
+
+// abandon stops the batcher, cancelling its in-flight write
+func (b *batcher) abandon() {
+	b.stopOnce.Do(func() { close(b.stop) })
+}
+
+func (b *batcher) stopped() bool {
+	select {
+	case <-b.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// handBack returns the unwritten batch of an abandoned batcher to the queue
+func (b *batcher) handBack() {
+	if len(b.batch) == 0 {
+		return
+	}
+	if requeued := b.queue.requeue(b.batch); requeued < len(b.batch) {
+		log.Printf("dropped %d events from abandoned batcher: queue full", len(b.batch)-requeued)
+	}
+	b.batch = b.batch[:0]
+	b.batched.Store(0)
+}
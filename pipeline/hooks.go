@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// FlushHook is called after every batch write with the batch, how long the write took,
+// and the error when it failed
+type FlushHook func(batch []*structs.Event, duration time.Duration, err error)
+
+// DropHook is called with the number of events dropped because the queue was full or closed
+type DropHook func(count int)
+
+// RejectHook is called for each event rejected by Config.Prepare
+type RejectHook func(event *structs.Event, err error)
+
+// Stall describes a batcher that has not flushed while events were waiting
+type Stall struct {
+	// Duration is how long the batcher has gone without flushing (or, on recovery, how long it was stalled)
+	Duration time.Duration
+	// Flushing is how long the write in progress has taken, zero when none is
+	Flushing  time.Duration
+	Pending   int
+	Batched   int
+	Restarted bool
+}
+
+// StallHook is called when the watchdog detects a stall, and again with recovered once
+// flushing resumes
+type StallHook func(stall Stall, recovered bool)
+
+// hooks holds the callbacks registered on a pipeline. They may be registered at any time
+// and run synchronously, so they should be fast. They are called without the lock held,
+// since a hook may enqueue events that trigger hooks in turn.
+type hooks struct {
+	mu     sync.RWMutex
+	flush  []FlushHook
+	drop   []DropHook
+	reject []RejectHook
+	stall  []StallHook
+}
+
+func (h *hooks) flushed(batch []*structs.Event, duration time.Duration, err error) {
+	h.mu.RLock()
+	registered := h.flush
+	h.mu.RUnlock()
+	for _, hook := range registered {
+		hook(batch, duration, err)
+	}
+}
+
+func (h *hooks) dropped(count int) {
+	h.mu.RLock()
+	registered := h.drop
+	h.mu.RUnlock()
+	for _, hook := range registered {
+		hook(count)
+	}
+}
+
+func (h *hooks) rejected(event *structs.Event, err error) {
+	h.mu.RLock()
+	registered := h.reject
+	h.mu.RUnlock()
+	for _, hook := range registered {
+		hook(event, err)
+	}
+}
+
+func (h *hooks) stalled(stall Stall, recovered bool) {
+	h.mu.RLock()
+	registered := h.stall
+	h.mu.RUnlock()
+	for _, hook := range registered {
+		hook(stall, recovered)
+	}
+}
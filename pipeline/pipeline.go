@@ -0,0 +1,156 @@
+// Package pipeline is monitor-core's in-process ingest pipeline: a bounded queue of
+// events drained by a batcher that writes them in batches. It has no global state, so
+// other programs can embed it and write to the same ClickHouse schema with db.Writer:
+//
+//	p := pipeline.New(&db.Writer{Store: store}, pipeline.Config{Prepare: services.PrepareEvent})
+//	go p.Run(ctx)
+//	p.Enqueue(&structs.Event{Service: "billing", Name: "invoice.paid", Level: "info"})
+//	defer p.Shutdown(context.Background())
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Writer is the interface for writing event batches
+type Writer interface {
+	WriteBatch(ctx context.Context, events []*structs.Event) error
+}
+
+// Config configures a pipeline; zero values use the defaults
+type Config struct {
+	// QueueSize is how many events may wait to be written (default 100000)
+	QueueSize int
+	// BatchSize is how many events are written at once (default 1000)
+	BatchSize int
+	// FlushInterval is how long a partial batch may wait (default 5s)
+	FlushInterval time.Duration
+	// Prepare, when set, is applied to each event as it is enqueued
+	Prepare PrepareFunc
+	// WatchdogIntervals is how many flush intervals without progress make a stall (0 = no watchdog)
+	WatchdogIntervals int
+	// WatchdogRestart replaces a stalled batcher instead of only reporting it
+	WatchdogRestart bool
+}
+
+// Pipeline is a queue and the batcher writing it
+type Pipeline struct {
+	config Config
+	writer Writer
+	queue  *Queue
+	hooks  *hooks
+
+	// done is closed when the current batcher exits without being abandoned
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// New creates a pipeline writing to writer. Call Run to start writing.
+func New(writer Writer, config Config) *Pipeline {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100000
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1000
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+
+	h := &hooks{}
+	return &Pipeline{
+		config: config,
+		writer: writer,
+		queue:  newQueue(config.QueueSize, config.Prepare, h),
+		hooks:  h,
+		done:   make(chan struct{}),
+	}
+}
+
+// Queue returns the pipeline's queue
+func (p *Pipeline) Queue() *Queue {
+	return p.queue
+}
+
+// Enqueue prepares an event and queues it, returning false when it was rejected or dropped
+func (p *Pipeline) Enqueue(event *structs.Event) bool {
+	return p.queue.Enqueue(event)
+}
+
+// OnFlush registers a hook for batch writes. The batch must not be retained after the hook returns.
+func (p *Pipeline) OnFlush(hook FlushHook) {
+	p.hooks.mu.Lock()
+	defer p.hooks.mu.Unlock()
+	p.hooks.flush = append(p.hooks.flush, hook)
+}
+
+// OnDrop registers a hook for events dropped by a full or closed queue
+func (p *Pipeline) OnDrop(hook DropHook) {
+	p.hooks.mu.Lock()
+	defer p.hooks.mu.Unlock()
+	p.hooks.drop = append(p.hooks.drop, hook)
+}
+
+// OnReject registers a hook for events rejected by Config.Prepare
+func (p *Pipeline) OnReject(hook RejectHook) {
+	p.hooks.mu.Lock()
+	defer p.hooks.mu.Unlock()
+	p.hooks.reject = append(p.hooks.reject, hook)
+}
+
+// OnStall registers a hook for batcher stalls and recoveries detected by the watchdog
+func (p *Pipeline) OnStall(hook StallHook) {
+	p.hooks.mu.Lock()
+	defer p.hooks.mu.Unlock()
+	p.hooks.stall = append(p.hooks.stall, hook)
+}
+
+// Run writes queued events until the queue is closed and drained or ctx is done (after
+// flushing the partial batch), and returns once the last batch is written
+func (p *Pipeline) Run(ctx context.Context) {
+	b := p.newBatcher()
+	p.start(ctx, b)
+	if p.config.WatchdogIntervals > 0 {
+		go p.watch(ctx, b)
+	}
+	<-p.done
+}
+
+// Shutdown closes the queue and waits until the events already in it are written or ctx is done
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	p.queue.Close()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline) newBatcher() *batcher {
+	b := &batcher{
+		queue:         p.queue,
+		writer:        p.writer,
+		hooks:         p.hooks,
+		batchSize:     p.config.BatchSize,
+		flushInterval: p.config.FlushInterval,
+		batch:         make([]*structs.Event, 0, p.config.BatchSize),
+		stop:          make(chan struct{}),
+	}
+	b.progress.Store(time.Now().UnixNano())
+	return b
+}
+
+// start runs a batcher; an abandoned one exits without ending the pipeline
+func (p *Pipeline) start(ctx context.Context, b *batcher) {
+	go func() {
+		b.run(ctx)
+		if !b.stopped() {
+			p.doneOnce.Do(func() { close(p.done) })
+		}
+	}()
+}
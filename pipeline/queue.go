@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// PrepareFunc is applied to every event before it is queued; an error rejects the event
+type PrepareFunc func(event *structs.Event, receivedAt time.Time) error
+
+// ErrHeld is returned by a PrepareFunc that keeps the event to queue later with Release.
+// Held events count as accepted.
+var ErrHeld = errors.New("event held")
+
+// Queue is a buffered channel for events
+type Queue struct {
+	events   chan *structs.Event
+	prepare  PrepareFunc
+	hooks    *hooks
+	dropped  atomic.Int64
+	enqueued atomic.Int64
+	rejected atomic.Int64
+
+	// mu guards closed so late events never send on a closed channel
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newQueue(size int, prepare PrepareFunc, h *hooks) *Queue {
+	return &Queue{
+		events:  make(chan *structs.Event, size),
+		prepare: prepare,
+		hooks:   h,
+	}
+}
+
+// Enqueue prepares an event and adds it to the queue
+// Returns false if the event was rejected by the prepare function or the queue is full (event dropped)
+func (q *Queue) Enqueue(event *structs.Event) bool {
+	if q.prepare != nil {
+		if err := q.prepare(event, time.Now().UTC()); errors.Is(err, ErrHeld) {
+			return true
+		} else if err != nil {
+			q.rejected.Add(1)
+			q.hooks.rejected(event, err)
+			return false
+		}
+	}
+
+	if !q.send(event) {
+		q.drop(1)
+		return false
+	}
+	q.enqueued.Add(1)
+	return true
+}
+
+// send adds an event without blocking, reporting false when the queue is full or closed.
+// Hooks run after the lock is released, since they may enqueue events themselves.
+func (q *Queue) send(event *structs.Event) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+
+	select {
+	case q.events <- event:
+		return true
+	default:
+		log.Printf("queue overflow: dropped event %s", event.Name)
+		return false
+	}
+}
+
+func (q *Queue) drop(count int) {
+	q.dropped.Add(int64(count))
+	q.hooks.dropped(count)
+}
+
+// requeue returns already prepared events to the queue, dropping those that do not fit
+func (q *Queue) requeue(events []*structs.Event) int {
+	for i, event := range events {
+		if !q.send(event) {
+			q.drop(len(events) - i)
+			return i
+		}
+	}
+	return len(events)
+}
+
+// Release queues events held by the prepare function, which are not prepared again, and
+// returns how many were queued; the rest are dropped
+func (q *Queue) Release(events []*structs.Event) int {
+	queued := q.requeue(events)
+	q.enqueued.Add(int64(queued))
+	return queued
+}
+
+// Reject reports events held by the prepare function that won't be queued, counting
+// them as rejected with err
+func (q *Queue) Reject(events []*structs.Event, err error) {
+	q.rejected.Add(int64(len(events)))
+	for _, event := range events {
+		q.hooks.rejected(event, err)
+	}
+}
+
+// Events returns the channel for consuming events
+func (q *Queue) Events() <-chan *structs.Event {
+	return q.events
+}
+
+// Stats returns queue statistics
+func (q *Queue) Stats() (enqueued, dropped int64, pending int) {
+	return q.enqueued.Load(), q.dropped.Load(), len(q.events)
+}
+
+// Saturated reports whether the queue is full, so new events would be dropped
+func (q *Queue) Saturated() bool {
+	return len(q.events) >= cap(q.events)
+}
+
+// Rejected returns the number of events rejected by the prepare function
+func (q *Queue) Rejected() int64 {
+	return q.rejected.Load()
+}
+
+// Close closes the queue channel
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"time"
+)
+
+// maxStackDump caps the goroutine dump logged when the batcher stalls
+const maxStackDump = 64 * 1024
+
+// watch checks the batcher every flush interval and reports a stall when it has not
+// flushed for Config.WatchdogIntervals intervals while events are waiting (a stuck
+// ClickHouse connection or a deadlock). Each stall is logged with a goroutine dump and
+// passed to the stall hooks; with Config.WatchdogRestart, the stuck batcher is abandoned,
+// its write is cancelled, and a new batcher takes over the queue and the unwritten batch.
+func (p *Pipeline) watch(ctx context.Context, batcher *batcher) {
+	ticker := time.NewTicker(p.config.FlushInterval)
+	defer ticker.Stop()
+
+	limit := time.Duration(p.config.WatchdogIntervals) * p.config.FlushInterval
+	restart := p.config.WatchdogRestart
+	var stalledAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		since := time.Since(time.Unix(0, batcher.progress.Load()))
+		_, _, pending := p.queue.Stats()
+		batched := int(batcher.batched.Load())
+		if since < limit || (pending == 0 && batched == 0) {
+			if !stalledAt.IsZero() {
+				log.Printf("batcher recovered after %v", time.Since(stalledAt).Round(time.Second))
+				p.hooks.stalled(Stall{Duration: time.Since(stalledAt)}, true)
+				stalledAt = time.Time{}
+			}
+			continue
+		}
+		if !stalledAt.IsZero() && !restart {
+			continue
+		}
+		if stalledAt.IsZero() {
+			stalledAt = time.Now()
+		}
+
+		stall := Stall{Duration: since, Pending: pending, Batched: batched, Restarted: restart}
+		diagnostics := ""
+		if start := batcher.flushStart.Load(); start != 0 {
+			stall.Flushing = time.Since(time.Unix(0, start))
+			diagnostics = ", write in progress for " + stall.Flushing.Round(time.Second).String()
+		}
+		stack := make([]byte, maxStackDump)
+		stack = stack[:runtime.Stack(stack, true)]
+		log.Printf("batcher stalled: no flush for %v with %d events pending and %d batched%s\n%s",
+			since.Round(time.Second), pending, batched, diagnostics, stack)
+		p.hooks.stalled(stall, false)
+
+		if restart {
+			next := p.newBatcher()
+			batcher.abandon()
+			p.start(ctx, next)
+			batcher = next
+			log.Printf("batcher restarted")
+		}
+	}
+}
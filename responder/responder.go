@@ -1,7 +1,9 @@
 package responder
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -16,6 +18,7 @@ type Response struct {
 	Success    bool        `json:"success"`
 	Message    string      `json:"message"`
 	Pagination *Pagination `json:"pagination,omitempty"`
+	Meta       interface{} `json:"meta,omitempty"`
 	Data       interface{} `json:"data"`
 }
 
@@ -49,6 +52,32 @@ func NewWithCount(w http.ResponseWriter, data interface{}, count int, next, prev
 	}
 }
 
+// NewWithCountAndMeta responds like NewWithCount with a meta block about how the data was produced
+func NewWithCountAndMeta(w http.ResponseWriter, data interface{}, count int, next, previous string, meta interface{}, message ...string) {
+	response := Response{
+		Success: true,
+		Data:    data,
+		Pagination: &Pagination{
+			Count:    count,
+			Next:     next,
+			Previous: previous,
+		},
+		Meta:    meta,
+		Message: DefaultSuccessMessage,
+	}
+
+	if len(message) > 0 {
+		response.Message = message[0]
+	}
+
+	response.Message = strings.ToLower(response.Message)
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
 func New(w http.ResponseWriter, data interface{}, message ...string) {
 	response := Response{
 		Success: true,
@@ -68,6 +97,27 @@ func New(w http.ResponseWriter, data interface{}, message ...string) {
 	}
 }
 
+// NewWithMeta responds like New with a meta block about how the data was produced
+func NewWithMeta(w http.ResponseWriter, data interface{}, meta interface{}, message ...string) {
+	response := Response{
+		Success: true,
+		Data:    data,
+		Meta:    meta,
+		Message: DefaultSuccessMessage,
+	}
+
+	if len(message) > 0 {
+		response.Message = message[0]
+	}
+
+	response.Message = strings.ToLower(response.Message)
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
 func Error(w http.ResponseWriter, statusCode int, message string) {
 	log.Printf("[%d] %s", statusCode, message)
 
@@ -83,6 +133,12 @@ func Error(w http.ResponseWriter, statusCode int, message string) {
 }
 
 func ErrorWithCause(w http.ResponseWriter, statusCode int, message string, err error) {
+	// A request that ran past its route timeout is reported as such, not as a server error
+	if errors.Is(err, context.DeadlineExceeded) {
+		statusCode = http.StatusGatewayTimeout
+		message += ": request timed out"
+	}
+
 	log.Printf("[%d] %s: %v", statusCode, message, err)
 
 	response := Response{
@@ -0,0 +1,473 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/gorilla/mux"
+)
+
+// GetStorageStatsHandler reports row counts and sizes of the events tables
+func (h *Handlers) GetStorageStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.svc.GetStorageStats(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get storage stats", err)
+		return
+	}
+
+	responder.New(w, stats)
+}
+
+// GetHealthHistoryHandler handles GET /v1/admin/health/history
+// Reports recent dependency health checks with uptime and degraded periods
+func GetHealthHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	var from time.Time
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			responder.Error(w, http.StatusBadRequest, "invalid from: expected RFC3339")
+			return
+		}
+		from = t
+	}
+
+	responder.New(w, services.GetHealthHistory(from))
+}
+
+// chaosConfig is the JSON form of db.ChaosConfig, with latencies in milliseconds
+type chaosConfig struct {
+	WriteErrorRate float64 `json:"write_error_rate"`
+	WriteLatencyMs int64   `json:"write_latency_ms"`
+	QueryErrorRate float64 `json:"query_error_rate"`
+	QueryLatencyMs int64   `json:"query_latency_ms"`
+}
+
+// GetChaosHandler handles GET /v1/admin/chaos (only with CHAOS_MODE)
+// Reports the injected faults and how many have fired
+func (h *Handlers) GetChaosHandler(w http.ResponseWriter, r *http.Request) {
+	config := h.chaos.Config()
+	responder.New(w, map[string]interface{}{
+		"config": chaosConfig{
+			WriteErrorRate: config.WriteErrorRate,
+			WriteLatencyMs: config.WriteLatency.Milliseconds(),
+			QueryErrorRate: config.QueryErrorRate,
+			QueryLatencyMs: config.QueryLatency.Milliseconds(),
+		},
+		"stats": h.chaos.Stats(),
+	})
+}
+
+// PutChaosHandler handles PUT /v1/admin/chaos (only with CHAOS_MODE)
+// Replaces the injected faults; all zero stops injecting
+func (h *Handlers) PutChaosHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+
+	var body chaosConfig
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	err := h.chaos.SetConfig(db.ChaosConfig{
+		WriteErrorRate: body.WriteErrorRate,
+		WriteLatency:   time.Duration(body.WriteLatencyMs) * time.Millisecond,
+		QueryErrorRate: body.QueryErrorRate,
+		QueryLatency:   time.Duration(body.QueryLatencyMs) * time.Millisecond,
+	})
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	responder.New(w, body, "chaos config updated")
+}
+
+// DeleteEventsHandler handles POST /v1/admin/events/delete
+// Deletes the events matching the filters and time range, or counts them with dry_run
+func (h *Handlers) DeleteEventsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var query structs.DeleteQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	result, err := h.svc.DeleteEvents(r.Context(), &query)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "unsupported") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to delete events", err)
+		return
+	}
+
+	if result.DryRun {
+		responder.New(w, result, "dry run, no events deleted")
+		return
+	}
+	responder.New(w, result)
+}
+
+// RedactEventsHandler handles POST /v1/admin/events/redact
+// Masks data keys of the events matching the filters and time range, or counts them with dry_run
+func (h *Handlers) RedactEventsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var query structs.RedactQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	result, err := h.svc.RedactEvents(r.Context(), &query)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "unsupported") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to redact events", err)
+		return
+	}
+
+	if result.DryRun {
+		responder.New(w, result, "dry run, no events redacted")
+		return
+	}
+	responder.New(w, result)
+}
+
+// GetMutationsHandler lists recent mutations on the events tables, filtered by ?id=
+func (h *Handlers) GetMutationsHandler(w http.ResponseWriter, r *http.Request) {
+	mutations, err := h.svc.GetMutations(r.Context(), r.URL.Query().Get("id"))
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get mutations", err)
+		return
+	}
+
+	responder.New(w, mutations)
+}
+
+// StartReplayHandler handles POST /v1/admin/replays
+// Starts re-inserting the archived events matching the filters and time range into the
+// replay table, or counts them with dry_run
+func (h *Handlers) StartReplayHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var query structs.ReplayQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	job, err := h.svc.StartReplay(r.Context(), &query)
+	if err != nil {
+		if errors.Is(err, services.ErrReplayRunning) {
+			responder.Error(w, http.StatusConflict, err.Error())
+			return
+		}
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to start replay", err)
+		return
+	}
+
+	if query.DryRun {
+		responder.New(w, job, "dry run, no events replayed")
+		return
+	}
+	responder.New(w, job, "replay started")
+}
+
+// ListReplaysHandler lists the replays started on this instance, newest first
+func ListReplaysHandler(w http.ResponseWriter, r *http.Request) {
+	responder.New(w, services.ListReplays())
+}
+
+// GetReplayHandler returns the progress of a replay
+func GetReplayHandler(w http.ResponseWriter, r *http.Request) {
+	job := services.GetReplay(mux.Vars(r)["id"])
+	if job == nil {
+		responder.Error(w, http.StatusNotFound, "replay not found")
+		return
+	}
+
+	responder.New(w, job)
+}
+
+// CancelReplayHandler stops a running replay
+func CancelReplayHandler(w http.ResponseWriter, r *http.Request) {
+	job := services.CancelReplay(mux.Vars(r)["id"])
+	if job == nil {
+		responder.Error(w, http.StatusNotFound, "replay not found")
+		return
+	}
+
+	responder.New(w, job, "replay cancelling")
+}
+
+// ListLookupsHandler lists the lookup tables usable as dict.<name>
+func (h *Handlers) ListLookupsHandler(w http.ResponseWriter, r *http.Request) {
+	lookups, err := h.svc.ListLookups(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list lookups", err)
+		return
+	}
+
+	responder.New(w, lookups)
+}
+
+// GetLookupHandler returns a lookup table with its entries
+func (h *Handlers) GetLookupHandler(w http.ResponseWriter, r *http.Request) {
+	lookup, err := h.svc.GetLookup(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get lookup", err)
+		return
+	}
+	if lookup == nil {
+		responder.Error(w, http.StatusNotFound, "lookup not found")
+		return
+	}
+
+	responder.New(w, lookup)
+}
+
+// PutLookupHandler creates or replaces a lookup table
+func (h *Handlers) PutLookupHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+
+	var body struct {
+		Source  string            `json:"source"`
+		Entries map[string]string `json:"entries"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := h.svc.PutLookup(r.Context(), name, body.Source, body.Entries); err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to save lookup", err)
+		return
+	}
+
+	responder.New(w, services.Lookup{Name: name, Source: body.Source, Count: uint64(len(body.Entries))}, "lookup saved")
+}
+
+// DeleteLookupHandler removes a lookup table
+func (h *Handlers) DeleteLookupHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteLookup(r.Context(), mux.Vars(r)["name"]); err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to delete lookup", err)
+		return
+	}
+
+	responder.New(w, nil, "lookup deleted")
+}
+
+// ListKeyUsageHandler lists the API keys seen since ?from= (default 30 days) with their totals
+func (h *Handlers) ListKeyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	from, _ := parseTimeRange(r.URL.Query().Get("from"), "")
+	if from.IsZero() {
+		from = time.Now().UTC().AddDate(0, 0, -30)
+	}
+
+	keys, err := h.svc.ListKeyUsage(r.Context(), from)
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list key usage", err)
+		return
+	}
+
+	responder.New(w, keys)
+}
+
+// GetKeyUsageHandler returns the usage of an API key over ?from=&to= in ?interval= buckets
+func (h *Handlers) GetKeyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to := parseTimeRange(q.Get("from"), q.Get("to"))
+
+	usage, err := h.svc.GetKeyUsage(r.Context(), mux.Vars(r)["id"], from, to, structs.IntervalType(q.Get("interval")))
+	if err != nil {
+		if strings.Contains(err.Error(), "unsupported") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get key usage", err)
+		return
+	}
+
+	responder.New(w, usage)
+}
+
+// ListAPIKeysHandler lists the managed API keys with when each was last used, optionally
+// filtered by ?name_prefix=, ?role=, and ?revoked=true|false
+func (h *Handlers) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.svc.ListAPIKeys(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list api keys", err)
+		return
+	}
+
+	q := r.URL.Query()
+	filtered := make([]services.APIKey, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasPrefix(key.Name, q.Get("name_prefix")) {
+			continue
+		}
+		if q.Has("role") && key.Role != q.Get("role") {
+			continue
+		}
+		if revoked := q.Get("revoked"); revoked != "" && strconv.FormatBool(key.Revoked) != revoked {
+			continue
+		}
+		filtered = append(filtered, key)
+	}
+
+	responder.New(w, filtered)
+}
+
+// GetAPIKeyHandler returns a managed API key (without the key itself or its last use)
+func (h *Handlers) GetAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := h.svc.GetAPIKey(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get api key", err)
+		return
+	}
+	if key == nil {
+		responder.Error(w, http.StatusNotFound, "api key not found")
+		return
+	}
+
+	setETag(w, key)
+	responder.New(w, key)
+}
+
+// CreateAPIKeyHandler issues a new API key; the key is only returned in this response
+func (h *Handlers) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+
+	var body struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	key, err := h.svc.CreateAPIKey(r.Context(), body.Name, body.Role)
+	if err != nil {
+		if strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to create api key", err)
+		return
+	}
+
+	responder.New(w, key, "api key created")
+}
+
+// RotateAPIKeyHandler issues a replacement key; the old key stays valid for ?grace= (default 24h).
+// If-Match makes the rotation conditional on the old key's current version.
+func (h *Handlers) RotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.checkAPIKeyPrecondition(w, r) {
+		return
+	}
+
+	grace := 24 * time.Hour
+	if s := r.URL.Query().Get("grace"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			responder.Error(w, http.StatusBadRequest, "invalid grace period: "+err.Error())
+			return
+		}
+		grace = d
+	}
+
+	key, err := h.svc.RotateAPIKey(r.Context(), mux.Vars(r)["id"], grace)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to rotate api key", err)
+		return
+	}
+	if key == nil {
+		responder.Error(w, http.StatusNotFound, "api key not found")
+		return
+	}
+
+	responder.New(w, key, "api key rotated")
+}
+
+// RevokeAPIKeyHandler invalidates an API key immediately, conditional on If-Match
+func (h *Handlers) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.checkAPIKeyPrecondition(w, r) {
+		return
+	}
+	revoked, err := h.svc.RevokeAPIKey(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to revoke api key", err)
+		return
+	}
+	if !revoked {
+		responder.Error(w, http.StatusNotFound, "api key not found")
+		return
+	}
+
+	responder.New(w, nil, "api key revoked")
+}
+
+// checkAPIKeyPrecondition checks a conditional request against the {id} key's current version
+func (h *Handlers) checkAPIKeyPrecondition(w http.ResponseWriter, r *http.Request) bool {
+	if !hasPrecondition(r) {
+		return true
+	}
+	current, err := h.svc.GetAPIKey(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get api key", err)
+		return false
+	}
+	return checkPrecondition(w, r, current, current != nil)
+}
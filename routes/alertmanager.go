@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// AlertmanagerHandler handles POST /v1/alertmanager requests
+// Accepts Alertmanager webhook notifications (a webhook_configs receiver pointed here with
+// the API key as a bearer token) and stores each alert as an event
+func (h *Handlers) AlertmanagerHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	events, err := services.ParseAlertmanagerWebhook(body, env.AlertSourceService)
+	if err != nil {
+		log.Printf("failed to parse alertmanager webhook: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		h.enqueue(r, event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"accepted": len(events),
+	})
+}
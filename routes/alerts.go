@@ -0,0 +1,163 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/gorilla/mux"
+)
+
+// AlertBacktestHandler handles POST /v1/alerts/backtest requests
+// Evaluates a proposed alert rule over a past time range
+func (h *Handlers) AlertBacktestHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var query structs.AlertBacktestQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if query.Rule.Aggregation != "" && !validAggregations[query.Rule.Aggregation] {
+		responder.Error(w, http.StatusBadRequest, "invalid aggregation type")
+		return
+	}
+	if query.Rule.Interval != "" && !validIntervals[query.Rule.Interval] {
+		responder.Error(w, http.StatusBadRequest, "invalid interval type")
+		return
+	}
+
+	result, err := h.svc.BacktestAlertRule(r.Context(), &query)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "too many") || strings.Contains(err.Error(), "too large") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to backtest alert rule", err)
+		return
+	}
+
+	respondQuery(w, r, result)
+}
+
+// ListAlertRulesHandler handles GET /v1/alerts/rules, optionally filtered by ?name_prefix=,
+// ?channel=, and ?label=key:value
+func (h *Handlers) ListAlertRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.svc.ListAlertRules(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list alert rules", err)
+		return
+	}
+
+	q := r.URL.Query()
+	labelKey, labelValue, hasLabel := strings.Cut(q.Get("label"), ":")
+	filtered := make([]structs.AlertRule, 0, len(rules))
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule.Name, q.Get("name_prefix")) {
+			continue
+		}
+		if channel := q.Get("channel"); channel != "" && !slices.Contains(rule.Channels, channel) {
+			continue
+		}
+		if hasLabel && rule.Labels[labelKey] != labelValue {
+			continue
+		}
+		filtered = append(filtered, rule)
+	}
+
+	responder.New(w, filtered)
+}
+
+// GetAlertRuleHandler handles GET /v1/alerts/rules/{name}
+func (h *Handlers) GetAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule, err := h.svc.GetAlertRule(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get alert rule", err)
+		return
+	}
+	if rule == nil {
+		responder.Error(w, http.StatusNotFound, "alert rule not found")
+		return
+	}
+
+	setETag(w, rule)
+	responder.New(w, rule)
+}
+
+// PutAlertRuleHandler handles PUT /v1/alerts/rules/{name}, creating or replacing an alert rule.
+// If-Match and If-None-Match make the write conditional on its current version.
+func (h *Handlers) PutAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var rule structs.AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	rule.Name = mux.Vars(r)["name"]
+
+	if rule.Aggregation != "" && !validAggregations[rule.Aggregation] {
+		responder.Error(w, http.StatusBadRequest, "invalid aggregation type")
+		return
+	}
+	if rule.Interval != "" && !validIntervals[rule.Interval] {
+		responder.Error(w, http.StatusBadRequest, "invalid interval type")
+		return
+	}
+
+	if !h.checkAlertRulePrecondition(w, r, rule.Name) {
+		return
+	}
+	if err := h.svc.PutAlertRule(r.Context(), &rule); err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to save alert rule", err)
+		return
+	}
+
+	setETag(w, rule)
+	responder.New(w, rule, "alert rule saved")
+}
+
+// DeleteAlertRuleHandler handles DELETE /v1/alerts/rules/{name}, conditional on If-Match
+func (h *Handlers) DeleteAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.checkAlertRulePrecondition(w, r, name) {
+		return
+	}
+	if err := h.svc.DeleteAlertRule(r.Context(), name); err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to delete alert rule", err)
+		return
+	}
+
+	responder.New(w, nil, "alert rule deleted")
+}
+
+// checkAlertRulePrecondition checks a conditional request against the alert rule's
+// current version
+func (h *Handlers) checkAlertRulePrecondition(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !hasPrecondition(r) {
+		return true
+	}
+	current, err := h.svc.GetAlertRule(r.Context(), name)
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get alert rule", err)
+		return false
+	}
+	return checkPrecondition(w, r, current, current != nil)
+}
@@ -28,6 +28,7 @@ var validAggregations = map[structs.AggregationType]bool{
 	structs.AggP90:         true,
 	structs.AggP95:         true,
 	structs.AggP99:         true,
+	structs.AggErrorRate:   true,
 }
 
 // validIntervals defines allowed interval types
@@ -41,7 +42,7 @@ var validIntervals = map[structs.IntervalType]bool{
 
 // AnalyticsHandler handles POST /v1/analytics requests
 // Allows complex analytics queries with grouping and aggregation
-func AnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) AnalyticsHandler(w http.ResponseWriter, r *http.Request) {
 	// Limit request body size
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
 
@@ -63,7 +64,7 @@ func AnalyticsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := services.QueryAnalytics(r.Context(), &query)
+	result, err := h.svc.QueryAnalytics(r.Context(), &query)
 	if err != nil {
 		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") {
 			responder.Error(w, http.StatusBadRequest, err.Error())
@@ -73,12 +74,13 @@ func AnalyticsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responder.New(w, result)
+	h.svc.RecordQuery(r.Context(), services.SavedQueryAnalytics, &query)
+	respondQuery(w, r, result)
 }
 
 // TimeSeriesHandler handles POST /v1/timeseries requests
 // Returns time-bucketed data for charting
-func TimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) TimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
 
 	var query structs.TimeSeriesQuery
@@ -107,7 +109,14 @@ func TimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := services.QueryTimeSeries(r.Context(), &query)
+	if wantsNDJSON(r) {
+		if h.streamTimeSeries(w, r, &query) {
+			h.svc.RecordQuery(r.Context(), services.SavedQueryTimeSeries, &query)
+		}
+		return
+	}
+
+	result, err := h.svc.QueryTimeSeries(r.Context(), &query)
 	if err != nil {
 		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "too many") || strings.Contains(err.Error(), "too large") {
 			responder.Error(w, http.StatusBadRequest, err.Error())
@@ -116,13 +125,15 @@ func TimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
 		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to execute time series query", err)
 		return
 	}
+	h.svc.RecordQuery(r.Context(), services.SavedQueryTimeSeries, &query)
+	h.svc.AnnotateTimeSeries(r.Context(), &query, result)
 
-	responder.New(w, result)
+	respondQuery(w, r, result)
 }
 
 // TopNHandler handles POST /v1/topn requests
 // Returns top N values grouped by a field
-func TopNHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) TopNHandler(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
 
 	var query structs.TopNQuery
@@ -147,7 +158,7 @@ func TopNHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := services.QueryTopN(r.Context(), &query)
+	result, err := h.svc.QueryTopN(r.Context(), &query)
 	if err != nil {
 		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") {
 			responder.Error(w, http.StatusBadRequest, err.Error())
@@ -157,16 +168,21 @@ func TopNHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responder.New(w, result)
+	h.svc.RecordQuery(r.Context(), services.SavedQueryTopN, &query)
+	respondQuery(w, r, result)
 }
 
 // GaugeHandler handles POST /v1/gauge requests
 // Returns a single aggregated value
-func GaugeHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GaugeHandler(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
 
-	var query structs.GaugeQuery
-	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+	// A body with gauges is a batch, whose other fields are shared by every gauge
+	var body struct {
+		structs.GaugeQuery
+		Gauges []structs.GaugeQuery `json:"gauges"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		if err == io.EOF {
 			responder.Error(w, http.StatusBadRequest, "request body is required")
 			return
@@ -175,6 +191,34 @@ func GaugeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if body.Gauges != nil {
+		for _, g := range body.Gauges {
+			if g.Aggregation != "" && !validAggregations[g.Aggregation] {
+				responder.Error(w, http.StatusBadRequest, "invalid aggregation type: "+string(g.Aggregation))
+				return
+			}
+		}
+		result, err := h.svc.QueryGauges(r.Context(), &structs.GaugeBatchQuery{
+			Gauges:            body.Gauges,
+			Filters:           body.Filters,
+			From:              body.From,
+			To:                body.To,
+			Sparkline:         body.Sparkline,
+			SparklineInterval: body.SparklineInterval,
+		})
+		if err != nil {
+			if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") {
+				responder.Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to execute gauge query", err)
+			return
+		}
+		respondQuery(w, r, result)
+		return
+	}
+
+	query := body.GaugeQuery
 	if query.Aggregation == "" {
 		query.Aggregation = structs.AggCount
 	} else if !validAggregations[query.Aggregation] {
@@ -182,7 +226,7 @@ func GaugeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := services.QueryGauge(r.Context(), &query)
+	result, err := h.svc.QueryGauge(r.Context(), &query)
 	if err != nil {
 		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") {
 			responder.Error(w, http.StatusBadRequest, err.Error())
@@ -192,12 +236,13 @@ func GaugeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responder.New(w, result)
+	h.svc.RecordQuery(r.Context(), services.SavedQueryGauge, &query)
+	respondQuery(w, r, result)
 }
 
 // CompareHandler handles POST /v1/compare requests
 // Compares current period with a previous period
-func CompareHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) CompareHandler(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
 
 	var query structs.CompareQuery
@@ -222,7 +267,7 @@ func CompareHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := services.QueryCompare(r.Context(), &query)
+	result, err := h.svc.QueryCompare(r.Context(), &query)
 	if err != nil {
 		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") {
 			responder.Error(w, http.StatusBadRequest, err.Error())
@@ -232,12 +277,114 @@ func CompareHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responder.New(w, result)
+	h.svc.RecordQuery(r.Context(), services.SavedQueryCompare, &query)
+	respondQuery(w, r, result)
+}
+
+// FilterCompareHandler handles POST /v1/compare/filters requests
+// Runs the same aggregation over two filter sets (A/B) with optional aligned series
+func (h *Handlers) FilterCompareHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var query structs.FilterCompareQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	// Validate time range
+	if query.From.IsZero() || query.To.IsZero() {
+		responder.Error(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+	if query.Aggregation == "" {
+		query.Aggregation = structs.AggCount
+	} else if !validAggregations[query.Aggregation] {
+		responder.Error(w, http.StatusBadRequest, "invalid aggregation type")
+		return
+	}
+	if query.Interval != "" && !validIntervals[query.Interval] {
+		responder.Error(w, http.StatusBadRequest, "invalid interval type")
+		return
+	}
+
+	result, err := h.svc.QueryFilterCompare(r.Context(), &query)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "too") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to execute compare query", err)
+		return
+	}
+
+	respondQuery(w, r, result)
+}
+
+// RatioHandler handles POST /v1/ratio requests
+// Divides an aggregation over the numerator filters by the denominator's in one query
+func (h *Handlers) RatioHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var query structs.RatioQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if query.Aggregation == "" {
+		query.Aggregation = structs.AggCount
+	}
+	if query.Interval != "" && !validIntervals[query.Interval] {
+		responder.Error(w, http.StatusBadRequest, "invalid interval type")
+		return
+	}
+
+	result, err := h.svc.QueryRatio(r.Context(), &query)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "too many") || strings.Contains(err.Error(), "too large") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to execute ratio query", err)
+		return
+	}
+
+	respondQuery(w, r, result)
+}
+
+// ValidateQueryHandler handles POST /v1/query/validate requests
+// Checks a query body for any query endpoint without executing it
+func ValidateQueryHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var body struct {
+		Type  string          `json:"type"`
+		Query json.RawMessage `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	responder.New(w, services.ValidateQuery(r.Context(), body.Type, body.Query))
 }
 
 // AnalyticsQueryHandler handles GET /v1/analytics requests
 // Simple query-string based analytics for easy Grafana integration
-func AnalyticsQueryHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) AnalyticsQueryHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 
 	query := structs.AnalyticsQuery{
@@ -254,27 +401,39 @@ func AnalyticsQueryHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Parse group_by (comma-separated)
 	if groupBy := q.Get("group_by"); groupBy != "" {
-		query.GroupBy = strings.Split(groupBy, ",")
+		query.GroupBy = splitGroupBy(groupBy)
 	}
 
 	// Parse time range
 	query.From, query.To = parseTimeRange(q.Get("from"), q.Get("to"))
 
-	// Parse ordering
+	// Parse ordering; sort is comma-separated keys, each descending with a leading "-"
 	query.OrderBy = q.Get("order_by")
 	query.OrderDesc = q.Get("order") == "desc"
+	if sort := q.Get("sort"); sort != "" {
+		for _, key := range splitGroupBy(sort) {
+			by := strings.TrimPrefix(key, "-")
+			query.Sort = append(query.Sort, structs.SortKey{By: by, Desc: by != key})
+		}
+	}
 
-	// Parse limit
+	// Parse limit and paging
 	if limit := q.Get("limit"); limit != "" {
 		if l, err := strconv.Atoi(limit); err == nil {
 			query.Limit = l
 		}
 	}
+	if offset := q.Get("offset"); offset != "" {
+		if o, err := strconv.Atoi(offset); err == nil {
+			query.Offset = o
+		}
+	}
+	query.Cursor = q.Get("cursor")
 
 	// Parse filters from query string
 	query.Filters = parseFiltersFromQuery(q)
 
-	result, err := services.QueryAnalytics(r.Context(), &query)
+	result, err := h.svc.QueryAnalytics(r.Context(), &query)
 	if err != nil {
 		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "too many") {
 			responder.Error(w, http.StatusBadRequest, err.Error())
@@ -284,19 +443,22 @@ func AnalyticsQueryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responder.New(w, result)
+	respondQuery(w, r, result)
 }
 
 // TimeSeriesQueryHandler handles GET /v1/timeseries requests
 // Simple query-string based time series for easy Grafana integration
-func TimeSeriesQueryHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) TimeSeriesQueryHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 
 	query := structs.TimeSeriesQuery{
-		Aggregation: structs.AggregationType(q.Get("aggregation")),
-		Field:       q.Get("field"),
-		Interval:    structs.IntervalType(q.Get("interval")),
-		FillZeros:   q.Get("fill_zeros") == "true",
+		Aggregation:   structs.AggregationType(q.Get("aggregation")),
+		Field:         q.Get("field"),
+		Interval:      structs.IntervalType(q.Get("interval")),
+		FillZeros:     q.Get("fill_zeros") == "true",
+		CompareOffset: q.Get("compare_offset"),
+		Window:        q.Get("window"),
+		Spans:         q.Get("spans") == "true",
 	}
 
 	if query.Aggregation == "" {
@@ -314,7 +476,7 @@ func TimeSeriesQueryHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Parse group_by (comma-separated)
 	if groupBy := q.Get("group_by"); groupBy != "" {
-		query.GroupBy = strings.Split(groupBy, ",")
+		query.GroupBy = splitGroupBy(groupBy)
 	}
 
 	// Parse time range
@@ -323,7 +485,12 @@ func TimeSeriesQueryHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse filters from query string
 	query.Filters = parseFiltersFromQuery(q)
 
-	result, err := services.QueryTimeSeries(r.Context(), &query)
+	if wantsNDJSON(r) {
+		h.streamTimeSeries(w, r, &query)
+		return
+	}
+
+	result, err := h.svc.QueryTimeSeries(r.Context(), &query)
 	if err != nil {
 		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required") || strings.Contains(err.Error(), "too many") || strings.Contains(err.Error(), "too large") {
 			responder.Error(w, http.StatusBadRequest, err.Error())
@@ -332,22 +499,113 @@ func TimeSeriesQueryHandler(w http.ResponseWriter, r *http.Request) {
 		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to execute time series query", err)
 		return
 	}
+	h.svc.AnnotateTimeSeries(r.Context(), &query, result)
+
+	respondQuery(w, r, result)
+}
+
+// wantsNDJSON reports whether the client asked for a streamed NDJSON response
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// streamTimeSeries responds with one time series per line, each flushed as soon as it's
+// read, so grouped queries with many series are never held in memory whole. A result cut
+// short by a result cap names the cap in the X-Result-Truncated trailer. It reports
+// whether the whole result was written.
+func (h *Handlers) streamTimeSeries(w http.ResponseWriter, r *http.Request, query *structs.TimeSeriesQuery) bool {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Trailer", "X-Result-Truncated")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	written := 0
+	err := h.svc.StreamTimeSeries(r.Context(), query, func(ts structs.TimeSeries) error {
+		if err := enc.Encode(ts); err != nil {
+			return err
+		}
+		written++
+		rc.Flush()
+		return nil
+	})
+	if err != nil && written == 0 {
+		// Nothing has been streamed yet, so the error can still be the response
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to execute time series query", err)
+	}
+	if stats := services.QueryStatsFrom(r.Context()); stats != nil && stats.Truncated != nil {
+		w.Header().Set("X-Result-Truncated", stats.Truncated.Limit)
+	}
+	return err == nil
+}
 
+// respondQuery responds with a query result and, in the meta block, the stats of the
+// ClickHouse queries behind it
+func respondQuery(w http.ResponseWriter, r *http.Request, result interface{}) {
+	if stats := services.QueryStatsFrom(r.Context()); stats != nil {
+		if stats.Truncated != nil {
+			responder.NewWithMeta(w, result, stats, "partial result, truncated")
+			return
+		}
+		responder.NewWithMeta(w, result, stats)
+		return
+	}
 	responder.New(w, result)
 }
 
+// respondQueryPage responds like respondQuery with a page of a paginated result
+func respondQueryPage(w http.ResponseWriter, r *http.Request, result interface{}, count int, next, previous string) {
+	if stats := services.QueryStatsFrom(r.Context()); stats != nil {
+		if stats.Truncated != nil {
+			responder.NewWithCountAndMeta(w, result, count, next, previous, stats, "partial result, truncated")
+			return
+		}
+		responder.NewWithCountAndMeta(w, result, count, next, previous, stats)
+		return
+	}
+	responder.NewWithCount(w, result, count, next, previous)
+}
+
+// splitGroupBy splits a comma-separated group_by, keeping commas inside
+// parentheses such as bucket(data.duration_ms, 100)
+func splitGroupBy(groupBy string) []string {
+	var fields []string
+	depth, start := 0, 0
+	for i, c := range groupBy {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				fields = append(fields, strings.TrimSpace(groupBy[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, strings.TrimSpace(groupBy[start:]))
+}
+
 // analyticsReservedParams are query params that are not filters
 var analyticsReservedParams = map[string]bool{
-	"from":        true,
-	"to":          true,
-	"limit":       true,
-	"aggregation": true,
-	"field":       true,
-	"group_by":    true,
-	"order_by":    true,
-	"order":       true,
-	"interval":    true,
-	"fill_zeros":  true,
+	"from":           true,
+	"to":             true,
+	"limit":          true,
+	"offset":         true,
+	"cursor":         true,
+	"aggregation":    true,
+	"field":          true,
+	"group_by":       true,
+	"order_by":       true,
+	"order":          true,
+	"sort":           true,
+	"interval":       true,
+	"fill_zeros":     true,
+	"compare_offset": true,
+	"window":         true,
 }
 
 // parseTimeRange parses from/to time values
@@ -0,0 +1,132 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/middleware"
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// oidcStateCookie carries the state, nonce, and PKCE verifier of a login in progress
+const oidcStateCookie = "monitor_oidc_state"
+
+type oidcState struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	Return    string `json:"return"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// LoginHandler handles GET /auth/login, redirecting to the OIDC provider.
+// ?return= is where to send the user after logging in (a local path).
+func (h *Handlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	ret := r.URL.Query().Get("return")
+	if !strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") {
+		ret = "/"
+	}
+	state := oidcState{
+		State:     services.RandomToken(),
+		Nonce:     services.RandomToken(),
+		Verifier:  services.RandomToken(),
+		Return:    ret,
+		ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+	}
+	value, err := services.SignValue([]byte(env.OIDCSessionSecret), state)
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to start login", err)
+		return
+	}
+
+	setAuthCookie(w, oidcStateCookie, value, "/auth", 10*time.Minute)
+	http.Redirect(w, r, h.oidc.AuthCodeURL(state.State, state.Nonce, state.Verifier), http.StatusFound)
+}
+
+// CallbackHandler handles GET /auth/callback, exchanging the code for a session cookie
+func (h *Handlers) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, "login session not found, start again at /auth/login")
+		return
+	}
+	setAuthCookie(w, oidcStateCookie, "", "/auth", -1)
+
+	var state oidcState
+	if err := services.VerifyValue([]byte(env.OIDCSessionSecret), cookie.Value, &state); err != nil || time.Now().Unix() > state.ExpiresAt {
+		responder.Error(w, http.StatusBadRequest, "invalid login session, start again at /auth/login")
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		responder.Error(w, http.StatusUnauthorized, "login failed: "+e)
+		return
+	}
+	if q.Get("state") != state.State {
+		responder.Error(w, http.StatusBadRequest, "invalid login state")
+		return
+	}
+
+	identity, err := h.oidc.Exchange(r.Context(), q.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		services.EmitInternal("auth.failed", "warn", map[string]interface{}{
+			"client_ip":  middleware.GetClientIPFromContext(r.Context()),
+			"request_id": middleware.GetRequestID(r.Context()),
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"reason":     err.Error(),
+		})
+		responder.Error(w, http.StatusUnauthorized, "login failed: "+err.Error())
+		return
+	}
+
+	session := services.Session{OIDCIdentity: *identity, ExpiresAt: time.Now().Add(env.OIDCSessionTTL).Unix()}
+	value, err := services.SignValue([]byte(env.OIDCSessionSecret), session)
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to create session", err)
+		return
+	}
+	setAuthCookie(w, services.SessionCookie, value, "/", env.OIDCSessionTTL)
+
+	services.EmitInternal("auth.login", "info", map[string]interface{}{
+		"email": identity.Email,
+		"role":  identity.Role,
+	})
+	http.Redirect(w, r, state.Return, http.StatusFound)
+}
+
+// LogoutHandler handles POST /auth/logout, clearing the session cookie
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	setAuthCookie(w, services.SessionCookie, "", "/", -1)
+	responder.New(w, nil, "logged out")
+}
+
+// MeHandler handles GET /auth/me, returning the logged-in user
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSession(r.Context())
+	if session == nil {
+		responder.Error(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	responder.New(w, session)
+}
+
+func setAuthCookie(w http.ResponseWriter, name, value, path string, ttl time.Duration) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(env.OIDCRedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(ttl.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
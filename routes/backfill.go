@@ -0,0 +1,65 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// backfillFailure is the response to a backfill whose insert failed: the events and
+// partitions written before it are stored, so a retry need only send the rest
+type backfillFailure struct {
+	Error string `json:"error"`
+	*services.BackfillResult
+}
+
+// BackfillHandler handles POST /v1/backfill requests
+// Accepts NDJSON like /v1/events, but writes synchronously and responds once the events are stored.
+// With rebuild_rollups=true, the rollups of hours already rolled up are rebuilt before responding.
+func (h *Handlers) BackfillHandler(w http.ResponseWriter, r *http.Request) {
+	rebuildRollups := r.URL.Query().Get("rebuild_rollups") == "true"
+	if rebuildRollups && !services.RollupsEnabled() {
+		http.Error(w, "Invalid rebuild_rollups: rollups are not enabled (ROLLUP_RETENTION_DAYS)", http.StatusBadRequest)
+		return
+	}
+
+	var events []*structs.Event
+	tenant := services.TenantFromContext(r.Context())
+	if _, err := parseEvents(r.Body, func(event *structs.Event) {
+		event.Tenant = tenant
+		events = append(events, event)
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.backfiller.Write(r.Context(), events)
+	if errors.Is(err, services.ErrBackfillWrite) {
+		log.Printf("failed to backfill events: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(backfillFailure{Error: fmt.Sprintf("Backfill failed: %v", err), BackfillResult: result})
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Backfill failed: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if rebuildRollups {
+		if err := h.svc.RollUpLate(r.Context()); err != nil {
+			log.Printf("failed to rebuild rollups after a backfill: %v", err)
+			result.RollupError = err.Error()
+		} else {
+			result.RollupsRebuilt = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
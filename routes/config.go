@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+	"go.yaml.in/yaml/v3"
+)
+
+// maxConfigBundleSize caps an applied bundle, which holds every saved object
+const maxConfigBundleSize = 10 << 20
+
+// ExportConfigHandler handles GET /v1/config/export
+// Writes the bundle itself rather than a response envelope, so it can be committed to git and
+// applied as is; ?format=yaml (or Accept: application/yaml) writes YAML instead of JSON
+func (h *Handlers) ExportConfigHandler(w http.ResponseWriter, r *http.Request) {
+	bundle, err := h.svc.ExportConfig(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to export config", err)
+		return
+	}
+
+	if wantsYAML(r.URL.Query().Get("format"), r.Header.Get("Accept")) {
+		body, err := configToYAML(bundle)
+		if err != nil {
+			responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to encode config", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(body)
+		return
+	}
+
+	w.Header().Set("Content-Type", responder.ContentTypeJSON)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(bundle)
+}
+
+// ApplyConfigHandler handles PUT /v1/config/export
+// Applies a bundle from GET /v1/config/export (JSON, or YAML with Content-Type
+// application/yaml or ?format=yaml). ?prune=true deletes objects missing from the bundle and
+// ?dry_run=true only reports the changes.
+func (h *Handlers) ApplyConfigHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigBundleSize))
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if len(body) == 0 {
+		responder.Error(w, http.StatusBadRequest, "request body is required")
+		return
+	}
+
+	var bundle services.ConfigBundle
+	if wantsYAML(r.URL.Query().Get("format"), r.Header.Get("Content-Type")) {
+		err = configFromYAML(body, &bundle)
+	} else {
+		err = json.Unmarshal(body, &bundle)
+	}
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	result, err := h.svc.ApplyConfig(r.Context(), &bundle, q.Get("prune") == "true", q.Get("dry_run") == "true")
+	if err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to apply config", err)
+		return
+	}
+
+	message := "config applied"
+	if result.DryRun {
+		message = "config dry run"
+	}
+	responder.New(w, result, message)
+}
+
+func wantsYAML(format, contentType string) bool {
+	return format == "yaml" || strings.Contains(contentType, "yaml")
+}
+
+// configToYAML converts the bundle through JSON, so YAML keys and values match the JSON
+// bundle (queries are raw JSON, which YAML can't encode directly)
+func configToYAML(bundle *services.ConfigBundle) ([]byte, error) {
+	b, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(tree)
+}
+
+func configFromYAML(body []byte, bundle *services.ConfigBundle) error {
+	var tree interface{}
+	if err := yaml.Unmarshal(body, &tree); err != nil {
+		return err
+	}
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, bundle)
+}
@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// HerokuDrainHandler handles POST /v1/drains/heroku requests
+// Accepts Heroku logplex HTTPS drain payloads; the app is identified by the service query param
+func (h *Handlers) HerokuDrainHandler(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	if service == "" {
+		http.Error(w, "service parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	h.handleSyslogDrain(w, r, services.DrainOptions{
+		Service: service,
+		Env:     r.URL.Query().Get("env"),
+		Heroku:  true,
+	})
+}
+
+// SyslogDrainHandler handles POST /v1/drains/syslog requests
+// Accepts generic RFC6587 framed syslog over HTTPS; APP-NAME maps to service unless overridden
+func (h *Handlers) SyslogDrainHandler(w http.ResponseWriter, r *http.Request) {
+	h.handleSyslogDrain(w, r, services.DrainOptions{
+		Service:        r.URL.Query().Get("service"),
+		Env:            r.URL.Query().Get("env"),
+		DefaultService: env.SyslogService,
+	})
+}
+
+func (h *Handlers) handleSyslogDrain(w http.ResponseWriter, r *http.Request, opts services.DrainOptions) {
+	events, err := services.ParseSyslogDrain(r.Body, opts)
+	if err != nil {
+		log.Printf("failed to parse drain payload: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid drain payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		h.enqueue(r, event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accepted": len(events),
+	})
+}
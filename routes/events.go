@@ -2,51 +2,49 @@ package routes
 
 import (
 	"bufio"
-	"compress/gzip"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/aidenappl/monitor-core/services"
 	"github.com/aidenappl/monitor-core/structs"
 )
 
-// MaxRequestBodySize limits request body to 10MB
+// MaxRequestBodySize limits request body to 10MB; ingest bodies are limited as sent, before
+// decompression, by the Decompress middleware
 const MaxRequestBodySize = 10 * 1024 * 1024
 
-// Queue is the global event queue (set from main.go)
-var Queue *services.Queue
-
 // HealthHandler returns queue stats
-func HealthHandler(w http.ResponseWriter, r *http.Request) {
-	enqueued, dropped, pending := Queue.Stats()
+func (h *Handlers) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	enqueued, dropped, pending := h.queue.Stats()
+	truncatedEvents, truncatedFields := services.TruncationStats()
+	health := map[string]interface{}{
+		"status":           "ok",
+		"enqueued":         enqueued,
+		"dropped":          dropped,
+		"rejected":         h.queue.Rejected(),
+		"pending":          pending,
+		"truncated_events": truncatedEvents,
+		"truncated_fields": truncatedFields,
+	}
+	if h.replica != nil {
+		health["replica"] = h.replica.Stats()
+	}
+	if h.chaos != nil {
+		health["chaos"] = h.chaos.Stats()
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":   "ok",
-		"enqueued": enqueued,
-		"dropped":  dropped,
-		"pending":  pending,
-	})
+	json.NewEncoder(w).Encode(health)
 }
 
 // IngestEventsHandler processes incoming NDJSON events
-func IngestEventsHandler(w http.ResponseWriter, r *http.Request) {
-	// Limit request body size
-	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
-
-	bodyReader, err := getBodyReader(r)
-	if err != nil {
-		log.Printf("failed to get body reader: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
-	}
-	defer bodyReader.Close()
-
-	count, err := parseAndEnqueue(bodyReader)
+func (h *Handlers) IngestEventsHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := h.parseAndEnqueue(r)
 	if err != nil {
 		log.Printf("failed to parse events: %v", err)
 		http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
@@ -60,19 +58,136 @@ func IngestEventsHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func getBodyReader(r *http.Request) (io.ReadCloser, error) {
-	contentEncoding := r.Header.Get("Content-Encoding")
-	if strings.Contains(strings.ToLower(contentEncoding), "gzip") {
-		gzReader, err := gzip.NewReader(r.Body)
+// maxStreamLine is the longest event line a stream accepts
+const maxStreamLine = 1024 * 1024
+
+// maxStreamErrors caps the line errors listed in a stream's response; all are counted
+const maxStreamErrors = 100
+
+// streamBackoff is how long a stream waits for room while the queue is full
+const streamBackoff = 100 * time.Millisecond
+
+var errLineTooLong = fmt.Errorf("line too long (max %d bytes)", maxStreamLine)
+
+// streamLineError is an NDJSON line a stream couldn't ingest
+type streamLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// streamResult is the response to a stream, sent once the body ends
+type streamResult struct {
+	Accepted int               `json:"accepted"`
+	Invalid  int               `json:"invalid"`
+	Rejected int               `json:"rejected"`
+	Errors   []streamLineError `json:"errors,omitempty"`
+	// Error is set when the stream ended early, such as on its timeout
+	Error string `json:"error,omitempty"`
+}
+
+// StreamEventsHandler handles POST /v1/events/stream
+// Takes NDJSON in one long-lived request: each line is enqueued as soon as it is read and
+// bad lines are counted instead of failing the stream. While the queue is full, reading
+// pauses, so a fast agent is slowed down instead of losing events.
+func (h *Handlers) StreamEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var result streamResult
+	lineError := func(lineNum int, err error) {
+		if len(result.Errors) < maxStreamErrors {
+			result.Errors = append(result.Errors, streamLineError{Line: lineNum, Error: err.Error()})
+		}
+	}
+
+	reader := bufio.NewReaderSize(r.Body, 64*1024)
+	for lineNum := 1; ; lineNum++ {
+		line, err := readStreamLine(reader)
+		if err == errLineTooLong {
+			result.Invalid++
+			lineError(lineNum, err)
+			continue
+		}
+
+		if len(line) > 0 {
+			var event structs.Event
+			if jsonErr := json.Unmarshal(line, &event); jsonErr != nil {
+				result.Invalid++
+				lineError(lineNum, fmt.Errorf("invalid JSON: %w", jsonErr))
+			} else if validErr := event.Validate(); validErr != nil {
+				result.Invalid++
+				lineError(lineNum, validErr)
+			} else if !h.waitForQueue(r) {
+				result.Error = fmt.Sprintf("stream ended at line %d: %v", lineNum, r.Context().Err())
+				break
+			} else if h.enqueue(r, &event) {
+				result.Accepted++
+			} else {
+				result.Rejected++
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+			result.Error = fmt.Sprintf("stream ended at line %d: %v", lineNum, err)
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// readStreamLine reads the next line without its line ending. A line over maxStreamLine
+// is skipped and reported as errLineTooLong.
+func readStreamLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong && len(line)+len(chunk) > maxStreamLine+2 {
+			tooLong, line = true, nil
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if tooLong && (err == nil || err == io.EOF) {
+			return nil, errLineTooLong
+		}
+		return bytes.TrimRight(line, "\r\n"), err
+	}
+}
+
+// waitForQueue blocks while the queue is full, reporting false if the request ends first
+func (h *Handlers) waitForQueue(r *http.Request) bool {
+	for h.queue.Saturated() {
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-time.After(streamBackoff):
 		}
-		return gzReader, nil
 	}
-	return r.Body, nil
+	return true
+}
+
+func (h *Handlers) parseAndEnqueue(r *http.Request) (int, error) {
+	return parseEvents(r.Body, func(event *structs.Event) {
+		h.enqueue(r, event)
+	})
+}
+
+// enqueue queues an event under the tenant of the request's access role, so it is written
+// to the tenant's table
+func (h *Handlers) enqueue(r *http.Request, event *structs.Event) bool {
+	event.Tenant = services.TenantFromContext(r.Context())
+	return h.queue.Enqueue(event)
 }
 
-func parseAndEnqueue(reader io.Reader) (int, error) {
+// parseEvents reads and validates NDJSON events, calling fn for each one
+func parseEvents(reader io.Reader, fn func(*structs.Event)) (int, error) {
 	scanner := bufio.NewScanner(reader)
 	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 
@@ -96,7 +211,7 @@ func parseAndEnqueue(reader io.Reader) (int, error) {
 			return count, fmt.Errorf("line %d: %w", lineNum, err)
 		}
 
-		Queue.Enqueue(&event)
+		fn(&event)
 		count++
 	}
 
@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// FirehoseHandler handles POST /v1/firehose requests
+// Implements the Kinesis Data Firehose HTTP endpoint delivery contract
+func (h *Handlers) FirehoseHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get("X-Amz-Firehose-Request-Id")
+	var req services.FirehoseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		firehoseRespond(w, requestID, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.RequestID != "" {
+		requestID = req.RequestID
+	}
+
+	opts := services.FirehoseOptions{DefaultService: "firehose"}
+	if attrs := r.Header.Get("X-Amz-Firehose-Common-Attributes"); attrs != "" {
+		var common struct {
+			CommonAttributes map[string]string `json:"commonAttributes"`
+		}
+		if err := json.Unmarshal([]byte(attrs), &common); err == nil {
+			opts.Service = common.CommonAttributes["service"]
+			opts.Env = common.CommonAttributes["env"]
+		}
+	}
+
+	events, err := services.ParseFirehoseRecords(&req, opts)
+	if err != nil {
+		log.Printf("failed to parse firehose records: %v", err)
+		firehoseRespond(w, requestID, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, event := range events {
+		h.enqueue(r, event)
+	}
+
+	firehoseRespond(w, requestID, http.StatusOK, "")
+}
+
+// firehoseRespond writes the acknowledgement format Firehose requires
+func firehoseRespond(w http.ResponseWriter, requestID string, statusCode int, errorMessage string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(services.FirehoseResponse{
+		RequestID:    requestID,
+		Timestamp:    time.Now().UnixMilli(),
+		ErrorMessage: errorMessage,
+	})
+}
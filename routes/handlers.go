@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// Handlers serves the routes that read or write ClickHouse or the ingest pipeline, through
+// the services and components it is built with; routes that need neither are plain functions
+type Handlers struct {
+	svc         *services.Service
+	queue       *pipeline.Queue
+	replica     *db.Replica
+	chaos       *db.Chaos
+	backfiller  *services.Backfiller
+	oidc        *services.OIDCProvider
+	webhooks    map[string]*services.WebhookSource
+	statusPages *services.StatusPages
+}
+
+// Config holds the components the handlers use besides the service. Queue and Backfiller
+// are required; the rest are nil when their feature isn't configured.
+type Config struct {
+	// Queue is the ingest queue events are enqueued on
+	Queue *pipeline.Queue
+	// Replica is the secondary cluster writer (REPLICA_ADDR)
+	Replica *db.Replica
+	// Chaos is the fault injector (CHAOS_MODE)
+	Chaos *db.Chaos
+	// Backfiller writes historical events
+	Backfiller *services.Backfiller
+	// OIDC is the provider for human logins (OIDC_ISSUER)
+	OIDC *services.OIDCProvider
+	// Webhooks are the webhook sources by name
+	Webhooks map[string]*services.WebhookSource
+	// StatusPages generates the public status page (STATUS_PAGE_CONFIG)
+	StatusPages *services.StatusPages
+}
+
+// New returns the handlers backed by svc and the components in cfg
+func New(svc *services.Service, cfg Config) *Handlers {
+	return &Handlers{
+		svc:         svc,
+		queue:       cfg.Queue,
+		replica:     cfg.Replica,
+		chaos:       cfg.Chaos,
+		backfiller:  cfg.Backfiller,
+		oidc:        cfg.OIDC,
+		webhooks:    cfg.Webhooks,
+		statusPages: cfg.StatusPages,
+	}
+}
+
+// ChaosEnabled reports whether the handlers have a fault injector to configure
+func (h *Handlers) ChaosEnabled() bool {
+	return h.chaos != nil
+}
+
+// LoginEnabled reports whether the handlers have an OIDC provider to log people in with
+func (h *Handlers) LoginEnabled() bool {
+	return h.oidc != nil
+}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/gorilla/mux"
+)
+
+// ListQueryHistoryHandler handles GET /v1/history
+// Returns the caller's recent analytics, time series, top N, gauge, and compare queries
+func (h *Handlers) ListQueryHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	history, err := h.svc.ListQueryHistory(r.Context(), limit)
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list query history", err)
+		return
+	}
+
+	responder.New(w, history)
+}
+
+// ListStarredQueriesHandler handles GET /v1/history/starred
+func (h *Handlers) ListStarredQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	starred, err := h.svc.ListStarredQueries(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list starred queries", err)
+		return
+	}
+
+	responder.New(w, starred)
+}
+
+// StarQueryHandler handles PUT /v1/history/{id}/star with an optional {"title": ...}
+func (h *Handlers) StarQueryHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var body struct {
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	q, ok := h.getHistoryQuery(w, r)
+	if !ok {
+		return
+	}
+	if err := h.svc.StarQuery(r.Context(), q, body.Title); err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to star query", err)
+		return
+	}
+
+	responder.New(w, q, "query starred")
+}
+
+// UnstarQueryHandler handles DELETE /v1/history/{id}/star
+func (h *Handlers) UnstarQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.UnstarQuery(r.Context(), mux.Vars(r)["id"]); err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to unstar query", err)
+		return
+	}
+
+	responder.New(w, nil, "query unstarred")
+}
+
+// RunHistoryQueryHandler handles POST /v1/history/{id}/run
+// Runs a starred or recent query again; relative time ranges resolve against now
+func (h *Handlers) RunHistoryQueryHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := h.getHistoryQuery(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := h.svc.RunHistoryQuery(r.Context(), q)
+	if err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to run query", err)
+		return
+	}
+
+	respondQuery(w, r, result)
+}
+
+// getHistoryQuery looks up the {id} query of the caller, responding when it can't
+func (h *Handlers) getHistoryQuery(w http.ResponseWriter, r *http.Request) (*services.HistoryQuery, bool) {
+	q, err := h.svc.GetHistoryQuery(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get query", err)
+		return nil, false
+	}
+	if q == nil {
+		responder.Error(w, http.StatusNotFound, "query not found in history")
+		return nil, false
+	}
+	return q, true
+}
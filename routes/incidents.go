@@ -0,0 +1,146 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/gorilla/mux"
+)
+
+// ListIncidentsHandler handles GET /v1/incidents, optionally filtered by ?status=open|resolved
+func (h *Handlers) ListIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	incidents, err := h.svc.ListIncidents(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list incidents", err)
+		return
+	}
+
+	responder.New(w, incidents)
+}
+
+// GetIncidentHandler handles GET /v1/incidents/{id}
+func (h *Handlers) GetIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	incident, err := h.svc.GetIncident(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get incident", err)
+		return
+	}
+	if incident == nil {
+		responder.Error(w, http.StatusNotFound, "incident not found")
+		return
+	}
+
+	responder.New(w, incident)
+}
+
+// CreateIncidentHandler handles POST /v1/incidents, opening an incident (optionally from
+// firing alerts)
+func (h *Handlers) CreateIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeIncidentRequest(w, r)
+	if !ok {
+		return
+	}
+
+	incident, err := h.svc.CreateIncident(r.Context(), req)
+	if err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to create incident", err)
+		return
+	}
+
+	responder.New(w, incident, "incident created")
+}
+
+// UpdateIncidentHandler handles PUT /v1/incidents/{id}; a resolved_at resolves the incident
+func (h *Handlers) UpdateIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeIncidentRequest(w, r)
+	if !ok {
+		return
+	}
+
+	incident, err := h.svc.UpdateIncident(r.Context(), mux.Vars(r)["id"], req)
+	if err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to update incident", err)
+		return
+	}
+	if incident == nil {
+		responder.Error(w, http.StatusNotFound, "incident not found")
+		return
+	}
+
+	responder.New(w, incident, "incident updated")
+}
+
+// DeleteIncidentHandler handles DELETE /v1/incidents/{id}
+func (h *Handlers) DeleteIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteIncident(r.Context(), mux.Vars(r)["id"]); err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to delete incident", err)
+		return
+	}
+
+	responder.New(w, nil, "incident deleted")
+}
+
+// ExportIncidentEventsHandler handles GET /v1/incidents/{id}/events
+// Streams the affected services' events during the incident as NDJSON, for post-incident
+// review; takes the same filters as /v1/events
+func (h *Handlers) ExportIncidentEventsHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseQueryParams(r)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	incident, err := h.svc.GetIncident(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get incident", err)
+		return
+	}
+	if incident == nil {
+		responder.Error(w, http.StatusNotFound, "incident not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"incident-%s.ndjson\"", incident.ID))
+	written, err := h.svc.ExportIncidentEvents(r.Context(), incident, params, w)
+	if err != nil && written == 0 {
+		// Nothing has been streamed yet, so the error can still be the response
+		w.Header().Del("Content-Disposition")
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to export incident events", err)
+	}
+}
+
+func decodeIncidentRequest(w http.ResponseWriter, r *http.Request) (*structs.IncidentRequest, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var req structs.IncidentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return nil, false
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return nil, false
+	}
+	return &req, true
+}
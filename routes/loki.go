@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/middleware"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// LokiPushHandler handles POST /loki/api/v1/push requests
+// Accepts Loki's snappy-compressed protobuf and JSON push formats (Promtail, Vector, Fluent Bit)
+func (h *Handlers) LokiPushHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	snappyDecoded := middleware.GetDecodedEncoding(r.Context()) == "snappy"
+	events, err := services.ParseLokiPush(body, r.Header.Get("Content-Type"), snappyDecoded, env.LokiService)
+	if err != nil {
+		log.Printf("failed to parse loki push: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid push request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		h.enqueue(r, event)
+	}
+
+	// Loki responds with 204 No Content on success
+	w.WriteHeader(http.StatusNoContent)
+}
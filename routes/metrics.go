@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// MetricsHandler exposes the health counters in the Prometheus text format
+func (h *Handlers) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	enqueued, dropped, pending := h.queue.Stats()
+	truncatedEvents, truncatedFields := services.TruncationStats()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "monitor_events_enqueued_total", "counter", "Events accepted into the queue", enqueued)
+	writeMetric(w, "monitor_events_dropped_total", "counter", "Events dropped because the queue was full", dropped)
+	writeMetric(w, "monitor_events_rejected_total", "counter", "Events rejected by ingest policies", h.queue.Rejected())
+	writeMetric(w, "monitor_events_shed_total", "counter", "Events shed by ingest quotas", services.ShedStats())
+	if services.TailSamplingEnabled() {
+		held, maxEvents, kept, dropped, capped := services.TailSampleStats()
+		writeMetric(w, "monitor_tail_traces_held", "gauge", "Traces held for tail sampling", held)
+		writeMetric(w, "monitor_tail_trace_events_max", "gauge", "Events held per trace before it is decided early", maxEvents)
+		writeMetric(w, "monitor_tail_traces_kept_total", "counter", "Traces kept by tail sampling", kept)
+		writeMetric(w, "monitor_tail_traces_dropped_total", "counter", "Traces dropped by tail sampling", dropped)
+		writeMetric(w, "monitor_tail_traces_capped_total", "counter", "Traces decided early for reaching the per-trace event limit", capped)
+	}
+	writeMetric(w, "monitor_queue_pending", "gauge", "Events waiting in the queue", pending)
+	writeMetric(w, "monitor_events_truncated_total", "counter", "Events with truncated fields", truncatedEvents)
+	writeMetric(w, "monitor_fields_truncated_total", "counter", "Truncated fields", truncatedFields)
+	if h.replica != nil {
+		stats := h.replica.Stats()
+		writeMetric(w, "monitor_replica_pending", "gauge", "Batches waiting for the replica", stats.Pending)
+		writeMetric(w, "monitor_replica_written_total", "counter", "Batches written to the replica", stats.Written)
+		writeMetric(w, "monitor_replica_retried_total", "counter", "h.replica write retries", stats.Retried)
+		writeMetric(w, "monitor_replica_dead_letter_total", "counter", "Batches spilled to the replica dead-letter directory", stats.DeadLetter)
+		writeMetric(w, "monitor_replica_dropped_total", "counter", "Batches dropped by the replica writer", stats.Dropped)
+	}
+	writeMetric(w, "go_goroutines", "gauge", "Number of goroutines", runtime.NumGoroutine())
+	writeMetric(w, "go_memstats_heap_alloc_bytes", "gauge", "Heap bytes allocated and in use", mem.HeapAlloc)
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
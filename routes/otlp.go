@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aidenappl/monitor-core/services"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// OTLPLogsHandler handles POST /v1/otlp/logs requests
+// Accepts OTLP/HTTP log exports in protobuf and JSON, as sent by the OpenTelemetry Collector
+// and SDKs
+func (h *Handlers) OTLPLogsHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	events, err := services.ParseOTLPLogs(body, contentType)
+	if err != nil {
+		log.Printf("failed to parse otlp logs: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid export request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rejected := 0
+	for _, event := range events {
+		if !h.enqueue(r, event) {
+			rejected++
+		}
+	}
+
+	writeOTLPResponse(w, contentType, "rejectedLogRecords", rejected)
+}
+
+// OTLPTracesHandler handles POST /v1/otlp/traces requests
+// Accepts OTLP/HTTP trace exports in protobuf and JSON, storing each span as an event
+func (h *Handlers) OTLPTracesHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	events, err := services.ParseOTLPTraces(body, contentType)
+	if err != nil {
+		log.Printf("failed to parse otlp traces: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid export request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rejected := 0
+	for _, event := range events {
+		if !h.enqueue(r, event) {
+			rejected++
+		}
+	}
+
+	writeOTLPResponse(w, contentType, "rejectedSpans", rejected)
+}
+
+// writeOTLPResponse writes an Export*ServiceResponse in the encoding of the request. Events
+// the queue rejected are reported as a partial success, which exporters don't retry.
+// rejectedField is the JSON name of the signal's rejected count, always field 1 in protobuf.
+func writeOTLPResponse(w http.ResponseWriter, contentType, rejectedField string, rejected int) {
+	message := fmt.Sprintf("%d events rejected by ingest policies or a full queue", rejected)
+
+	if strings.HasPrefix(contentType, "application/json") {
+		response := map[string]interface{}{}
+		if rejected > 0 {
+			response["partialSuccess"] = map[string]interface{}{
+				rejectedField:  fmt.Sprint(rejected),
+				"errorMessage": message,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// partial_success=1 { rejected_<signal>=1, error_message=2 }
+	var response []byte
+	if rejected > 0 {
+		var partial []byte
+		partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+		partial = protowire.AppendVarint(partial, uint64(rejected))
+		partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+		partial = protowire.AppendString(partial, message)
+		response = protowire.AppendTag(response, 1, protowire.BytesType)
+		response = protowire.AppendBytes(response, partial)
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/gorilla/mux"
+)
+
+// GetPayloadHandler handles GET /v1/events/{id}/payload requests
+// Returns the full data of an event whose payload was offloaded; {id} is its data._payload_id
+func GetPayloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !services.PayloadOffloadEnabled() {
+		responder.Error(w, http.StatusNotFound, "payload storage is not configured")
+		return
+	}
+
+	// Payloads aren't stored with their labels, so they can't be checked against a role
+	if services.AccessRoleFromContext(r.Context()).RestrictsEvents() {
+		responder.Error(w, http.StatusForbidden, "payloads are not available to roles restricted by service or env")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if !structs.IsValidID(id) {
+		responder.Error(w, http.StatusBadRequest, "id must be a valid UUID")
+		return
+	}
+
+	payload, err := services.GetPayload(r.Context(), id)
+	if errors.Is(err, services.ErrObjectNotFound) {
+		responder.Error(w, http.StatusNotFound, "payload not found")
+		return
+	}
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusBadGateway, "failed to fetch payload", err)
+		return
+	}
+
+	responder.New(w, json.RawMessage(payload))
+}
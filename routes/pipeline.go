@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/gorilla/mux"
+)
+
+// ListPipelineRulesHandler lists the current version of every ingest pipeline rule
+func (h *Handlers) ListPipelineRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.svc.ListPipelineRules(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list pipeline rules", err)
+		return
+	}
+
+	responder.New(w, rules)
+}
+
+// GetPipelineRuleHandler returns the current version of a pipeline rule
+func (h *Handlers) GetPipelineRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule, err := h.svc.GetPipelineRule(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get pipeline rule", err)
+		return
+	}
+	if rule == nil {
+		responder.Error(w, http.StatusNotFound, "pipeline rule not found")
+		return
+	}
+
+	setETag(w, rule)
+	responder.New(w, rule)
+}
+
+// ListPipelineRuleVersionsHandler lists every version of a pipeline rule, newest first
+func (h *Handlers) ListPipelineRuleVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	versions, err := h.svc.ListPipelineRuleVersions(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list pipeline rule versions", err)
+		return
+	}
+	if len(versions) == 0 {
+		responder.Error(w, http.StatusNotFound, "pipeline rule not found")
+		return
+	}
+
+	responder.New(w, versions)
+}
+
+// PutPipelineRuleHandler creates a pipeline rule or stores a new version of it
+func (h *Handlers) PutPipelineRuleHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+
+	var rule services.PipelineRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	rule.Name = mux.Vars(r)["name"]
+
+	if !h.checkPipelineRulePrecondition(w, r, rule.Name) {
+		return
+	}
+
+	if err := h.svc.PutPipelineRule(r.Context(), &rule); err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to save pipeline rule", err)
+		return
+	}
+
+	setETag(w, rule)
+	responder.New(w, rule, "pipeline rule saved")
+}
+
+// DeletePipelineRuleHandler stops a pipeline rule; its versions are kept
+func (h *Handlers) DeletePipelineRuleHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.checkPipelineRulePrecondition(w, r, name) {
+		return
+	}
+
+	deleted, err := h.svc.DeletePipelineRule(r.Context(), name)
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to delete pipeline rule", err)
+		return
+	}
+	if !deleted {
+		responder.Error(w, http.StatusNotFound, "pipeline rule not found")
+		return
+	}
+
+	responder.New(w, nil, "pipeline rule deleted")
+}
+
+// TestPipelineRulesHandler handles POST /v1/admin/pipeline/test
+// Runs the rules on {"event": ...} without ingesting it, with an optional {"rule": ...}
+// tried in place of the saved rule of the same name
+func TestPipelineRulesHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+
+	var body struct {
+		Event *structs.Event         `json:"event"`
+		Rule  *services.PipelineRule `json:"rule"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if body.Event == nil {
+		responder.Error(w, http.StatusBadRequest, "event is required")
+		return
+	}
+
+	result, err := services.TestPipelineRules(body.Event, body.Rule)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	responder.New(w, result)
+}
+
+// checkPipelineRulePrecondition checks If-Match and If-None-Match against the current
+// version of the {name} rule
+func (h *Handlers) checkPipelineRulePrecondition(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !hasPrecondition(r) {
+		return true
+	}
+	current, err := h.svc.GetPipelineRule(r.Context(), name)
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get pipeline rule", err)
+		return false
+	}
+	return checkPrecondition(w, r, current, current != nil)
+}
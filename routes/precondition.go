@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// setETag sets the ETag header to the version of a saved object
+func setETag(w http.ResponseWriter, v interface{}) {
+	if etag, err := services.ETag(v); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+// hasPrecondition reports whether the request is conditional, so handlers only read the
+// current object when they have to
+func hasPrecondition(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != ""
+}
+
+// checkPrecondition enforces If-Match and If-None-Match against the current version of a
+// saved object, if it exists, so clients like Terraform don't overwrite
+// changes they haven't seen. It responds 412 and returns false when they fail.
+// If-Match: * requires the object to exist, and If-None-Match: * requires it not to.
+func checkPrecondition(w http.ResponseWriter, r *http.Request, current interface{}, exists bool) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+
+	var etag string
+	if exists {
+		var err error
+		if etag, err = services.ETag(current); err != nil {
+			responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to check precondition", err)
+			return false
+		}
+		w.Header().Set("ETag", etag)
+	}
+
+	if ifMatch != "" && (!exists || !etagListMatches(ifMatch, etag)) {
+		responder.Error(w, http.StatusPreconditionFailed, "precondition failed: the resource has changed or doesn't exist")
+		return false
+	}
+	if ifNoneMatch != "" && exists && etagListMatches(ifNoneMatch, etag) {
+		responder.Error(w, http.StatusPreconditionFailed, "precondition failed: the resource already exists")
+		return false
+	}
+	return true
+}
+
+// etagListMatches reports whether a comma-separated If-Match or If-None-Match list is *
+// or contains etag. Weak validators (W/"...") match their strong form.
+func etagListMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
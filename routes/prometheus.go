@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// PromWriteHandler handles POST /v1/prom/write requests
+// Accepts Prometheus remote write 1.0 (snappy-compressed protobuf, decoded by the
+// decompress middleware) and stores each sample as an event
+func (h *Handlers) PromWriteHandler(w http.ResponseWriter, r *http.Request) {
+	// Remote write 2.0 senders fall back to 1.0 on 415
+	if strings.Contains(r.Header.Get("Content-Type"), "io.prometheus.write.v2") {
+		http.Error(w, "Only remote write 1.0 (prometheus.WriteRequest) is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	events, err := services.ParsePromWrite(body, env.PromService)
+	if err != nil {
+		log.Printf("failed to parse prometheus remote write: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid write request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		h.enqueue(r, event)
+	}
+
+	// Prometheus expects 204 No Content on success
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/gorilla/mux"
+)
+
+// ListSavedQueriesHandler handles GET /v1/queries, optionally filtered by ?type= and ?name_prefix=
+func (h *Handlers) ListSavedQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	queries, err := h.svc.ListSavedQueries(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list saved queries", err)
+		return
+	}
+
+	q := r.URL.Query()
+	filtered := make([]services.SavedQuery, 0, len(queries))
+	for _, query := range queries {
+		if strings.HasPrefix(query.Name, q.Get("name_prefix")) && (q.Get("type") == "" || query.Type == q.Get("type")) {
+			filtered = append(filtered, query)
+		}
+	}
+
+	responder.New(w, filtered)
+}
+
+// GetSavedQueryHandler handles GET /v1/queries/{name}
+func (h *Handlers) GetSavedQueryHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := h.svc.GetSavedQuery(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get saved query", err)
+		return
+	}
+	if query == nil {
+		responder.Error(w, http.StatusNotFound, "saved query not found")
+		return
+	}
+
+	setETag(w, query)
+	responder.New(w, query)
+}
+
+// PutSavedQueryHandler handles PUT /v1/queries/{name}, creating or replacing a saved query.
+// If-Match and If-None-Match make the write conditional on its current version.
+func (h *Handlers) PutSavedQueryHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var query services.SavedQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	query.Name = mux.Vars(r)["name"]
+
+	if !h.checkSavedQueryPrecondition(w, r, query.Name) {
+		return
+	}
+	if err := h.svc.PutSavedQuery(r.Context(), &query); err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to save query", err)
+		return
+	}
+
+	setETag(w, query)
+	responder.New(w, query, "query saved")
+}
+
+// DeleteSavedQueryHandler handles DELETE /v1/queries/{name}, conditional on If-Match
+func (h *Handlers) DeleteSavedQueryHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.checkSavedQueryPrecondition(w, r, name) {
+		return
+	}
+	if err := h.svc.DeleteSavedQuery(r.Context(), name); err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to delete saved query", err)
+		return
+	}
+
+	responder.New(w, nil, "saved query deleted")
+}
+
+// RunSavedQueryHandler handles POST /v1/queries/{name}/run with a body of
+// {"variables": {...}}, and GET /v1/queries/{name}/run with variables as query params
+func (h *Handlers) RunSavedQueryHandler(w http.ResponseWriter, r *http.Request) {
+	values := make(map[string]interface{})
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+		var body struct {
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if body.Variables != nil {
+			values = body.Variables
+		}
+	} else {
+		for key, v := range r.URL.Query() {
+			values[key] = strings.Join(v, ",")
+		}
+	}
+
+	query, err := h.svc.GetSavedQuery(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get saved query", err)
+		return
+	}
+	if query == nil {
+		responder.Error(w, http.StatusNotFound, "saved query not found")
+		return
+	}
+
+	result, err := h.svc.RunSavedQuery(r.Context(), query, values)
+	if err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to run saved query", err)
+		return
+	}
+
+	respondQuery(w, r, result)
+}
+
+// checkSavedQueryPrecondition checks a conditional request against the saved query's
+// current version
+func (h *Handlers) checkSavedQueryPrecondition(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !hasPrecondition(r) {
+		return true
+	}
+	current, err := h.svc.GetSavedQuery(r.Context(), name)
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get saved query", err)
+		return false
+	}
+	return checkPrecondition(w, r, current, current != nil)
+}
+
+// isQueryError reports whether a query builder error is caused by the request
+func isQueryError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "invalid") || strings.Contains(msg, "required") ||
+		strings.Contains(msg, "unsupported") || strings.Contains(msg, "too many") || strings.Contains(msg, "too large")
+}
@@ -1,6 +1,9 @@
 package routes
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -11,24 +14,28 @@ import (
 	"github.com/gorilla/mux"
 )
 
-func QueryEventsHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) QueryEventsHandler(w http.ResponseWriter, r *http.Request) {
 	params, err := parseQueryParams(r)
 	if err != nil {
 		responder.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	result, err := services.QueryEvents(r.Context(), params)
+	result, err := h.svc.QueryEvents(r.Context(), params)
 	if err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to query events", err)
 		return
 	}
 
 	nextURL, prevURL := buildPaginationURLs(r, params, result.Total)
-	responder.NewWithCount(w, result.Events, result.Total, nextURL, prevURL)
+	respondQueryPage(w, r, result.Events, result.Total, nextURL, prevURL)
 }
 
-func GetLabelValuesHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetLabelValuesHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	label := vars["label"]
 
@@ -38,9 +45,13 @@ func GetLabelValuesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := services.GetLabelValues(r.Context(), label, params)
+	opts := services.LabelValuesOptions{
+		Search:     r.URL.Query().Get("search"),
+		WithCounts: r.URL.Query().Get("with_counts") == "true",
+	}
+	result, err := h.svc.GetLabelValues(r.Context(), label, params, opts)
 	if err != nil {
-		if strings.Contains(err.Error(), "invalid label") {
+		if strings.Contains(err.Error(), "invalid") {
 			responder.Error(w, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -48,26 +59,34 @@ func GetLabelValuesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responder.New(w, result.Values)
+	if opts.WithCounts {
+		respondQuery(w, r, result.Counts)
+		return
+	}
+	respondQuery(w, r, result.Values)
 }
 
-func GetDataKeysHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetDataKeysHandler(w http.ResponseWriter, r *http.Request) {
 	params, err := parseQueryParams(r)
 	if err != nil {
 		responder.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	result, err := services.GetDataKeys(r.Context(), params)
+	result, err := h.svc.GetDataKeys(r.Context(), params)
 	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get data keys", err)
 		return
 	}
 
-	responder.New(w, result.Keys)
+	respondQuery(w, r, result.Keys)
 }
 
-func GetDataValuesHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetDataValuesHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	if key == "" {
 		responder.Error(w, http.StatusBadRequest, "key parameter is required")
@@ -80,22 +99,52 @@ func GetDataValuesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := services.GetDataValues(r.Context(), key, params)
+	result, err := h.svc.GetDataValues(r.Context(), key, params)
 	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get data values", err)
 		return
 	}
 
-	responder.New(w, result.Values)
+	respondQuery(w, r, result.Values)
+}
+
+// EventsSummaryHandler handles GET /v1/events/summary requests
+// Counts the events matching the same filters as /v1/events for the explorer header
+func (h *Handlers) EventsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseQueryParams(r)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := h.svc.QueryEventSummary(r.Context(), params)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "too large") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to summarize events", err)
+		return
+	}
+
+	respondQuery(w, r, result)
 }
 
 // reservedParams are query params that are not filters
 var reservedParams = map[string]bool{
-	"from":   true,
-	"to":     true,
-	"limit":  true,
-	"offset": true,
-	"key":    true,
+	"from":        true,
+	"to":          true,
+	"limit":       true,
+	"offset":      true,
+	"key":         true,
+	"filters":     true,
+	"search":      true,
+	"with_counts": true,
+	"tolerance":   true,
 }
 
 // validOperators maps suffix to operator
@@ -177,6 +226,13 @@ func parseQueryParams(r *http.Request) (services.QueryParams, error) {
 		}
 	}
 
+	// Analytics-style filters, as a JSON array
+	if filters := q.Get("filters"); filters != "" {
+		if err := json.Unmarshal([]byte(filters), &params.QueryFilters); err != nil {
+			return params, fmt.Errorf("invalid filters: %w", err)
+		}
+	}
+
 	// Parse filters
 	for key, values := range q {
 		if reservedParams[key] || len(values) == 0 {
@@ -232,3 +288,296 @@ func buildPaginationURLs(r *http.Request, params services.QueryParams, total int
 
 	return next, prev
 }
+
+// GetCardinalityHandler reports distinct values per label and the data keys with the most
+func (h *Handlers) GetCardinalityHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseQueryParams(r)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := h.svc.GetCardinality(r.Context(), params)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get cardinality", err)
+		return
+	}
+
+	respondQuery(w, r, report)
+}
+
+// GetEventNamesHandler lists the event names seen with their counts, services, and data keys
+func (h *Handlers) GetEventNamesHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseQueryParams(r)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := h.svc.GetEventNames(r.Context(), params)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get event names", err)
+		return
+	}
+
+	respondQuery(w, r, result)
+}
+
+// GetSchemaDriftHandler lists the schema drift recorded over ?from=&to= (default 7 days),
+// optionally for one ?name= and ?kind=, newest first, up to ?limit= (default 100, max 1000)
+func (h *Handlers) GetSchemaDriftHandler(w http.ResponseWriter, r *http.Request) {
+	// Drift is found across every service of the shared tables, so it can't be checked against a role
+	if services.AccessRoleFromContext(r.Context()).RestrictsEvents() || services.TenantFromContext(r.Context()) != "" {
+		responder.Error(w, http.StatusForbidden, "schema drift is not available to roles restricted by service, env, or tenant")
+		return
+	}
+
+	q := r.URL.Query()
+	from, to := parseTimeRange(q.Get("from"), q.Get("to"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	result, err := h.svc.GetSchemaDrifts(r.Context(), from, to, q.Get("name"), q.Get("kind"), limit)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get schema drift", err)
+		return
+	}
+
+	respondQuery(w, r, result)
+}
+
+// GetDuplicatesHandler reports the producers of likely duplicate events; ?tolerance= (default
+// 1s) is how close copies' timestamps must be
+func (h *Handlers) GetDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseQueryParams(r)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var tolerance time.Duration
+	if s := r.URL.Query().Get("tolerance"); s != "" {
+		if tolerance, err = time.ParseDuration(s); err != nil {
+			responder.Error(w, http.StatusBadRequest, "invalid tolerance: "+err.Error())
+			return
+		}
+	}
+
+	report, err := h.svc.GetDuplicates(r.Context(), params, tolerance)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "too large") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to find duplicates", err)
+		return
+	}
+
+	respondQuery(w, r, report)
+}
+
+// GetIngestLagHandler reports ingest lag percentiles by service
+func (h *Handlers) GetIngestLagHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseQueryParams(r)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := h.svc.GetIngestLag(r.Context(), params)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "too large") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get ingest lag", err)
+		return
+	}
+
+	respondQuery(w, r, report)
+}
+
+// GetQuotasHandler handles GET /v1/quotas requests
+// Lists the current window of each tenant and service quota with the events it shed by level
+func GetQuotasHandler(w http.ResponseWriter, r *http.Request) {
+	responder.New(w, services.GetQuotaUsage(r.Context()))
+}
+
+// TraceExistsHandler handles HEAD /v1/traces/{id}
+// Responds 200 when events of the trace exist and 404 otherwise, so UIs can decide whether
+// to link to a trace
+func (h *Handlers) TraceExistsHandler(w http.ResponseWriter, r *http.Request) {
+	h.idExists(w, r, "trace_id")
+}
+
+// FlameGraphHandler handles GET /v1/traces/flamegraph
+// Merges the spans of ?service= and ?name= across the most recent traces in the range into
+// one call tree; ?traces= (default 100, max 1000) is how many traces are merged
+func (h *Handlers) FlameGraphHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseQueryParams(r)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	var traces int
+	if s := q.Get("traces"); s != "" {
+		if traces, err = strconv.Atoi(s); err != nil || traces <= 0 {
+			responder.Error(w, http.StatusBadRequest, "invalid traces: must be a positive integer")
+			return
+		}
+	}
+
+	graph, err := h.svc.GetFlameGraph(r.Context(), params, q.Get("service"), q.Get("name"), traces)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "too large") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to build flame graph", err)
+		return
+	}
+
+	respondQuery(w, r, graph)
+}
+
+// GetTraceHandler handles GET /v1/traces/{id}
+// Returns the spans of a trace, or 404 when it has none
+func (h *Handlers) GetTraceHandler(w http.ResponseWriter, r *http.Request) {
+	trace, err := h.svc.GetTrace(r.Context(), mux.Vars(r)["id"])
+	writeTrace(w, r, trace, err)
+}
+
+// GetRequestTraceHandler handles GET /v1/requests/{id}/trace
+// Pivots from an event to its trace by the request ID, for events without a trace ID
+func (h *Handlers) GetRequestTraceHandler(w http.ResponseWriter, r *http.Request) {
+	trace, err := h.svc.GetRequestTrace(r.Context(), mux.Vars(r)["id"])
+	writeTrace(w, r, trace, err)
+}
+
+// writeTrace responds with a trace, 404 when there is none, or the lookup error
+func writeTrace(w http.ResponseWriter, r *http.Request, trace *services.Trace, err error) {
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get trace", err)
+		return
+	}
+	if trace == nil {
+		responder.Error(w, http.StatusNotFound, "trace not found")
+		return
+	}
+
+	respondQuery(w, r, trace)
+}
+
+// GetTraceLogsHandler handles GET /v1/traces/{id}/logs
+// Returns the log-style events correlated with a trace; ?limit= (default 500, max 5000)
+func (h *Handlers) GetTraceLogsHandler(w http.ResponseWriter, r *http.Request) {
+	var limit int
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			responder.Error(w, http.StatusBadRequest, "invalid limit: must be a positive integer")
+			return
+		}
+	}
+
+	logs, err := h.svc.GetTraceLogs(r.Context(), mux.Vars(r)["id"], limit)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get trace logs", err)
+		return
+	}
+
+	respondQuery(w, r, logs)
+}
+
+// TraceCriticalPathHandler handles GET /v1/traces/{id}/critical-path
+// Returns the chain of spans the trace's latency is spent in, or 404 when it has no spans
+func (h *Handlers) TraceCriticalPathHandler(w http.ResponseWriter, r *http.Request) {
+	path, err := h.svc.GetCriticalPath(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get critical path", err)
+		return
+	}
+	if path == nil {
+		responder.Error(w, http.StatusNotFound, "trace not found")
+		return
+	}
+
+	respondQuery(w, r, path)
+}
+
+// CriticalPathOperationsHandler handles GET /v1/traces/critical-path
+// Reports the operations most often on the critical path of the most recent traces in the
+// range; ?traces= (default 100, max 1000) is how many traces are analyzed
+func (h *Handlers) CriticalPathOperationsHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseQueryParams(r)
+	if err != nil {
+		responder.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var traces int
+	if s := r.URL.Query().Get("traces"); s != "" {
+		if traces, err = strconv.Atoi(s); err != nil || traces <= 0 {
+			responder.Error(w, http.StatusBadRequest, "invalid traces: must be a positive integer")
+			return
+		}
+	}
+
+	report, err := h.svc.GetCriticalPathReport(r.Context(), params, traces)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "too large") {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get critical path operations", err)
+		return
+	}
+
+	respondQuery(w, r, report)
+}
+
+// RequestExistsHandler handles HEAD /v1/requests/{id}
+func (h *Handlers) RequestExistsHandler(w http.ResponseWriter, r *http.Request) {
+	h.idExists(w, r, "request_id")
+}
+
+// idExists answers an existence check with a status and no body. Found IDs stay found
+// until their events expire, so the answer is cacheable; missing ones may arrive any time.
+func (h *Handlers) idExists(w http.ResponseWriter, r *http.Request, column string) {
+	exists, err := h.svc.EventExists(r.Context(), column, mux.Vars(r)["id"])
+	switch {
+	case err != nil && strings.Contains(err.Error(), "invalid"):
+		w.WriteHeader(http.StatusBadRequest)
+	case err != nil:
+		log.Printf("failed to look up %s: %v", column, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	case exists:
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
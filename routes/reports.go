@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// DigestHandler handles POST /v1/reports/digest requests
+// Summarizes a time range against the previous period for scheduled email reports
+func (h *Handlers) DigestHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var query structs.DigestQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil && err != io.EOF {
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	digest, err := h.svc.GenerateDigest(r.Context(), &query, h.statusPages)
+	if err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to generate digest", err)
+		return
+	}
+
+	respondQuery(w, r, digest)
+}
@@ -0,0 +1,65 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// RUMHandler handles POST /v1/rum requests
+// Accepts a single beacon or an array of beacons; all beacons must be valid or none are accepted
+func (h *Handlers) RUMHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxRUMBodySize)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	beacons, err := services.ParseRUMBeacons(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid beacon: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	h.enqueueRUMBeacons(w, r, beacons)
+}
+
+// RUMBeaconHandler handles GET /v1/rum requests, with the beacon in query parameters
+func (h *Handlers) RUMBeaconHandler(w http.ResponseWriter, r *http.Request) {
+	beacon, err := services.RUMBeaconFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid beacon: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	h.enqueueRUMBeacons(w, r, []services.RUMBeacon{beacon})
+}
+
+func (h *Handlers) enqueueRUMBeacons(w http.ResponseWriter, r *http.Request, beacons []services.RUMBeacon) {
+	now := time.Now().UTC()
+	userAgent := r.Header.Get("User-Agent")
+
+	events := make([]*structs.Event, 0, len(beacons))
+	for i, beacon := range beacons {
+		event, err := services.BuildRUMEvent(beacon, env.RUMService, userAgent, now)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid beacon %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		events = append(events, event)
+	}
+
+	for _, event := range events {
+		h.enqueue(r, event)
+	}
+
+	// Beacons are fire-and-forget, so there is nothing to return
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/env"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/gorilla/mux"
+)
+
+// SentryEnvelopeHandler handles POST /api/{project}/envelope/ requests
+// Accepts envelopes from Sentry SDKs pointed at a DSN for this server
+func (h *Handlers) SentryEnvelopeHandler(w http.ResponseWriter, r *http.Request) {
+	project := mux.Vars(r)["project"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	service := env.SentryProjects[project]
+	if service == "" {
+		service = "project-" + project
+	}
+
+	events, eventID, err := services.ParseSentryEnvelope(body, service)
+	if err != nil {
+		log.Printf("failed to parse sentry envelope: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid envelope: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		h.enqueue(r, event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id": eventID,
+	})
+}
@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/gorilla/mux"
+)
+
+// ListSnapshotsHandler handles GET /v1/snapshots, optionally filtered by ?incident_id=
+func (h *Handlers) ListSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := h.svc.ListSnapshots(r.Context(), r.URL.Query().Get("incident_id"))
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to list snapshots", err)
+		return
+	}
+
+	responder.New(w, snapshots)
+}
+
+// GetSnapshotHandler handles GET /v1/snapshots/{id}
+func (h *Handlers) GetSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := h.svc.GetSnapshot(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to get snapshot", err)
+		return
+	}
+	if snapshot == nil {
+		responder.Error(w, http.StatusNotFound, "snapshot not found")
+		return
+	}
+
+	responder.New(w, snapshot)
+}
+
+// CreateSnapshotHandler handles POST /v1/snapshots
+// Runs every panel's query now and stores the results with the queries, so the snapshot
+// keeps showing the same data after the events expire
+func (h *Handlers) CreateSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var snapshot services.Snapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		if err == io.EOF {
+			responder.Error(w, http.StatusBadRequest, "request body is required")
+			return
+		}
+		responder.Error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if err := h.svc.CreateSnapshot(r.Context(), &snapshot); err != nil {
+		if isQueryError(err) {
+			responder.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to create snapshot", err)
+		return
+	}
+
+	responder.New(w, snapshot, "snapshot created")
+}
+
+// DeleteSnapshotHandler handles DELETE /v1/snapshots/{id}
+func (h *Handlers) DeleteSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteSnapshot(r.Context(), mux.Vars(r)["id"]); err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to delete snapshot", err)
+		return
+	}
+
+	responder.New(w, nil, "snapshot deleted")
+}
@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/responder"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// StatusHandler handles GET /v1/status
+// Returns per-component availability over the last 90 days as JSON
+func (h *Handlers) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if h.statusPages == nil {
+		responder.Error(w, http.StatusNotFound, "status page is not configured")
+		return
+	}
+	page, err := h.statusPages.Get(r.Context())
+	if err != nil {
+		responder.ErrorWithCause(w, http.StatusInternalServerError, "failed to generate status page", err)
+		return
+	}
+
+	responder.New(w, page)
+}
+
+// StatusPageHandler handles GET /status, rendering the status page as HTML
+func (h *Handlers) StatusPageHandler(w http.ResponseWriter, r *http.Request) {
+	if h.statusPages == nil {
+		http.Error(w, "Status page is not configured", http.StatusNotFound)
+		return
+	}
+	page, err := h.statusPages.Get(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to generate status page", http.StatusInternalServerError)
+		return
+	}
+
+	// The page is self-contained, so the policy only allows its inline styles
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, page); err != nil {
+		services.EmitInternal("status.render_failed", "error", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"percent": func(p *float64) string {
+		if p == nil {
+			return "no data"
+		}
+		return fmt.Sprintf("%.3f%%", *p)
+	},
+	// dayClass colors a day by its availability against the component's target
+	"dayClass": func(p *float64, target float64) string {
+		switch {
+		case p == nil:
+			return "none"
+		case *p >= target:
+			return "up"
+		case *p >= target-1:
+			return "degraded"
+		default:
+			return "down"
+		}
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 860px; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+h1 { font-size: 1.5rem; }
+.banner { padding: .75rem 1rem; border-radius: 6px; color: #fff; margin-bottom: 1.5rem; }
+.banner.operational { background: #1a7f37; }
+.banner.degraded, .banner.unknown { background: #bf8700; }
+.banner.down { background: #cf222e; }
+.component { margin-bottom: 1.5rem; }
+.component header { display: flex; justify-content: space-between; margin-bottom: .25rem; }
+.state { text-transform: capitalize; }
+.days { display: flex; gap: 2px; }
+.days span { flex: 1; height: 28px; border-radius: 2px; }
+.up { background: #2da44e; }
+.degraded { background: #d4a72c; }
+.down { background: #cf222e; }
+.none { background: #d0d7de; }
+footer, .range { color: #656d76; font-size: .8rem; }
+.range { display: flex; justify-content: space-between; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="banner {{.Status}}">{{if eq .Status "operational"}}All systems operational{{else}}Some systems are {{.Status}}{{end}}</div>
+{{range .Components}}{{$target := .Target}}
+<section class="component">
+<header><strong>{{.Name}}</strong><span class="state">{{.Status}}</span></header>
+<div class="days">{{range .Days}}<span class="{{dayClass .Availability $target}}" title="{{.Date}}: {{percent .Availability}}"></span>{{end}}</div>
+<div class="range"><span>90 days ago</span><span>{{percent .Availability}} available</span><span>Today</span></div>
+</section>
+{{end}}
+<footer>Updated {{.GeneratedAt.Format "2006-01-02 15:04 UTC"}}</footer>
+</body>
+</html>
+`))
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/aidenappl/monitor-core/ui"
+)
+
+// UIHandler serves the embedded web UI under /ui/. It only calls the API from its own
+// scripts, so the policy allows nothing else.
+func UIHandler() http.Handler {
+	files := http.StripPrefix("/ui/", http.FileServer(http.FS(ui.FS)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	})
+}
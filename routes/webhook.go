@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/gorilla/mux"
+)
+
+// WebhookHandler handles POST /v1/webhooks/{source} requests
+// Requests are authenticated by the source's signature scheme rather than the API key
+func (h *Handlers) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["source"]
+	source, ok := h.webhooks[name]
+	if !ok {
+		http.Error(w, "Unknown webhook source", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := source.VerifySignature(r, body, now); err != nil {
+		services.EmitInternal("auth.failed", "warn", map[string]interface{}{
+			"method":         r.Method,
+			"path":           r.URL.Path,
+			"webhook_source": name,
+			"reason":         err.Error(),
+		})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := source.MapWebhook(name, r, body, now)
+	if err != nil {
+		log.Printf("failed to map %s webhook: %v", name, err)
+		http.Error(w, "Failed to map webhook: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	h.enqueue(r, event)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"accepted": 1,
+	})
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/aidenappl/monitor-core/db"
+)
+
+// AccessRole restricts the events a key or OIDC user can read and the endpoints they
+// can call. Empty lists don't restrict.
+type AccessRole struct {
+	Name     string   `json:"-"`
+	Services []string `json:"services"`
+	Envs     []string `json:"envs"`
+	// Endpoints are path prefixes, e.g. /v1/analytics or /v1/queries
+	Endpoints []string `json:"endpoints"`
+	// Scopes grant extra permissions, e.g. pii:read
+	Scopes []string `json:"scopes"`
+	// Tenant is a TENANT_TABLES entry; the role's keys write to and read from its table
+	// instead of the shared tables
+	Tenant string `json:"tenant"`
+}
+
+type accessRoleKey struct{}
+
+type principalKey struct{}
+
+var (
+	accessRolesMu sync.RWMutex
+	accessRoles   = map[string]*AccessRole{}
+)
+
+// LoadAccessRoles reads the access roles from a JSON file mapping role names to roles
+func LoadAccessRoles(configPath string) error {
+	roles := make(map[string]*AccessRole)
+	if configPath != "" {
+		b, err := os.ReadFile(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to read access roles: %w", err)
+		}
+		if err := json.Unmarshal(b, &roles); err != nil {
+			return fmt.Errorf("invalid access roles: %w", err)
+		}
+	}
+
+	for name, role := range roles {
+		if !safeIdentifierRegex.MatchString(name) || roleRank[name] > 0 {
+			return fmt.Errorf("invalid access role name: %s", name)
+		}
+		if role == nil {
+			return fmt.Errorf("invalid access role %s: role is empty", name)
+		}
+		for _, endpoint := range role.Endpoints {
+			if !strings.HasPrefix(endpoint, "/") {
+				return fmt.Errorf("invalid access role %s: endpoint %q must be a path", name, endpoint)
+			}
+		}
+		for _, scope := range role.Scopes {
+			if scope != ScopePIIRead {
+				return fmt.Errorf("invalid access role %s: unknown scope %q", name, scope)
+			}
+		}
+		if _, ok := db.TenantTable(role.Tenant); role.Tenant != "" && !ok {
+			return fmt.Errorf("invalid access role %s: tenant %q has no table in TENANT_TABLES", name, role.Tenant)
+		}
+		role.Name = name
+	}
+
+	accessRolesMu.Lock()
+	accessRoles = roles
+	accessRolesMu.Unlock()
+	return nil
+}
+
+// LookupAccessRole resolves the role of a key or session. Unrestricted principals (no role,
+// or the admin and query OIDC roles) get nil; ok is false for a role that isn't configured,
+// which callers must treat as denied.
+func LookupAccessRole(name string) (role *AccessRole, ok bool) {
+	if name == "" || roleRank[name] > 0 {
+		return nil, true
+	}
+	accessRolesMu.RLock()
+	defer accessRolesMu.RUnlock()
+	role, ok = accessRoles[name]
+	return role, ok
+}
+
+// WithAccessRole returns a context whose queries are restricted by role
+func WithAccessRole(ctx context.Context, role *AccessRole) context.Context {
+	if role == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, accessRoleKey{}, role)
+}
+
+// AccessRoleFromContext returns the role restricting the request, or nil
+func AccessRoleFromContext(ctx context.Context) *AccessRole {
+	role, _ := ctx.Value(accessRoleKey{}).(*AccessRole)
+	return role
+}
+
+// TenantFromContext returns the tenant of the request's role, or "" for the shared tables
+func TenantFromContext(ctx context.Context) string {
+	if role := AccessRoleFromContext(ctx); role != nil {
+		return role.Tenant
+	}
+	return ""
+}
+
+// WithPrincipal returns a context identifying who made the request: user:<subject> for
+// OIDC sessions, key:<key ID> for API keys, or anonymous when auth isn't enforced
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns who made the request, or ""
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// AllowsEndpoint reports whether the role may call path. The login routes are always allowed.
+func (r *AccessRole) AllowsEndpoint(path string) bool {
+	if len(r.Endpoints) == 0 || strings.HasPrefix(path, "/auth/") {
+		return true
+	}
+	for _, endpoint := range r.Endpoints {
+		prefix := strings.TrimSuffix(endpoint, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictsEvents reports whether the role limits which events can be read
+func (r *AccessRole) RestrictsEvents() bool {
+	return r != nil && (len(r.Services) > 0 || len(r.Envs) > 0)
+}
+
+// accessClause is the WHERE condition limiting a query to the services and envs of the
+// request's role, or "" when it isn't restricted
+func accessClause(ctx context.Context) (string, []interface{}) {
+	role := AccessRoleFromContext(ctx)
+	if !role.RestrictsEvents() {
+		return "", nil
+	}
+
+	var parts []string
+	var args []interface{}
+	for _, restriction := range []struct {
+		column string
+		values []string
+	}{{"service", role.Services}, {"env", role.Envs}} {
+		if len(restriction.values) == 0 {
+			continue
+		}
+		placeholders := make([]string, len(restriction.values))
+		for i, v := range restriction.values {
+			placeholders[i] = "?"
+			args = append(args, v)
+		}
+		parts = append(parts, fmt.Sprintf("%s IN (%s)", restriction.column, strings.Join(placeholders, ", ")))
+	}
+	return strings.Join(parts, " AND "), args
+}
+
+// applyAccess adds the access clause of the request's role to a query builder
+func applyAccess(ctx context.Context, builder sq.SelectBuilder) sq.SelectBuilder {
+	if clause, args := accessClause(ctx); clause != "" {
+		builder = builder.Where(clause, args...)
+	}
+	return builder
+}
@@ -0,0 +1,412 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// alertTopGroups is how many top_by values notifications list
+const alertTopGroups = 5
+
+// activeAlert is an alert the engine has fired and not yet resolved
+type activeAlert struct {
+	groups      map[string]string
+	labels      map[string]string
+	annotations map[string]string
+	startsAt    time.Time
+	// top and explorerURL are filled in when the alert starts firing
+	top         []structs.TopNRow
+	explorerURL string
+
+	// firedAt is when the engine fired the alert and lastBreach when it last breached
+	firedAt    time.Time
+	lastBreach time.Time
+	// notified is when each channel was last sent the alert, and escalations how many of
+	// the rule's escalation steps have been taken
+	notified    map[string]time.Time
+	escalations int
+}
+
+// alertEngine keeps the firing alerts of each rule between evaluations, keyed by rule name
+// and then by fingerprint, with the rule last evaluated so deleted rules notify its channels
+type alertEngine struct {
+	svc    *Service
+	firing map[string]map[string]*activeAlert
+	rules  map[string]*structs.AlertRule
+}
+
+// RunAlertEngine evaluates every alert rule each interval. Alerts that start firing or
+// resolve are emitted as alert.firing and alert.resolved self events and sent to the
+// rule's channels in the Alertmanager webhook format.
+// Firing alerts are kept in memory, so run the engine on one instance (ALERTS_ENABLED);
+// after a restart, alerts that are still breaching fire again.
+func (s *Service) RunAlertEngine(ctx context.Context, interval time.Duration) {
+	engine := &alertEngine{svc: s, firing: map[string]map[string]*activeAlert{}, rules: map[string]*structs.AlertRule{}}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := engine.evaluate(ctx, time.Now().UTC()); err != nil {
+				log.Printf("alert evaluation failed: %v", err)
+			}
+		}
+	}
+}
+
+// evaluate runs every rule once, then applies inhibitions; rules that were deleted
+// resolve their alerts
+func (e *alertEngine) evaluate(ctx context.Context, now time.Time) error {
+	rules, err := e.svc.ListAlertRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	breaching := make(map[string]map[string]*activeAlert, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		alerts, err := e.svc.evaluateAlertRule(ctx, rule, now)
+		if err != nil {
+			EmitInternal("alert.eval_failed", "error", map[string]interface{}{
+				"rule":  rule.Name,
+				"error": err.Error(),
+			})
+			continue
+		}
+		breaching[rule.Name] = alerts
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		alerts, ok := breaching[rule.Name]
+		if !ok {
+			continue
+		}
+		e.inhibit(rule, alerts, breaching)
+		e.update(ctx, rule, alerts, now)
+	}
+
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		seen[rule.Name] = true
+	}
+	for name := range e.firing {
+		if !seen[name] {
+			deleted := *e.rules[name]
+			deleted.ResolveAfter = ""
+			e.update(ctx, &deleted, nil, now)
+		}
+	}
+	return nil
+}
+
+// inhibit drops a rule's breaching alerts that aren't firing yet while an alert of one of
+// its inhibiting rules is breaching (or, if that rule failed to evaluate, still firing).
+// Alerts that were already firing stay firing until they stop breaching.
+func (e *alertEngine) inhibit(rule *structs.AlertRule, alerts map[string]*activeAlert, breaching map[string]map[string]*activeAlert) {
+	for _, inhibition := range rule.InhibitedBy {
+		sources, ok := breaching[inhibition.Rule]
+		if !ok {
+			sources = e.firing[inhibition.Rule]
+		}
+		for fingerprint, alert := range alerts {
+			if _, firing := e.firing[rule.Name][fingerprint]; firing {
+				continue
+			}
+			for _, source := range sources {
+				if labelsEqual(alert.labels, source.labels, inhibition.Equal) {
+					delete(alerts, fingerprint)
+					break
+				}
+			}
+		}
+	}
+}
+
+// labelsEqual reports whether two label sets have the same values for names
+func labelsEqual(a, b map[string]string, names []string) bool {
+	for _, name := range names {
+		if a[name] != b[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateAlertRule returns the alerts of a rule that are breaching now: series whose last
+// For complete buckets all breach the rule, keyed by fingerprint
+func (s *Service) evaluateAlertRule(ctx context.Context, rule *structs.AlertRule, now time.Time) (map[string]*activeAlert, error) {
+	if err := setAlertRuleDefaults(rule); err != nil {
+		return nil, err
+	}
+	to := truncateTime(now, rule.Interval)
+	from := to
+	for range rule.For {
+		from = truncateTime(from.Add(-time.Nanosecond), rule.Interval)
+	}
+
+	series, err := s.queryAlertSeries(ctx, rule, from, to.Add(-time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+
+	breaching := map[string]*activeAlert{}
+	for _, s := range series {
+		for _, firing := range evaluateAlertSeries(rule, s) {
+			if !firing.End.Equal(to) {
+				continue
+			}
+			labels := map[string]string{alertNameLabel: rule.Name}
+			maps.Copy(labels, s.Groups)
+			maps.Copy(labels, rule.Labels)
+
+			annotations := map[string]string{"summary": alertSummary(rule, firing.Peak)}
+			maps.Copy(annotations, rule.Annotations)
+
+			breaching[alertFingerprint(labels)] = &activeAlert{groups: s.Groups, labels: labels, annotations: annotations, startsAt: firing.Start}
+		}
+	}
+	return breaching, nil
+}
+
+// alertSummary describes a firing alert, e.g. "checkout_errors is 153 (gt 50)" or, for
+// composite rules, "checkout_errors: error_rate gt 5 and count gt 100 (error_rate 7.5)"
+func alertSummary(rule *structs.AlertRule, peak float64) string {
+	if len(rule.Conditions) == 0 {
+		return fmt.Sprintf("%s is %g (%s %g)", rule.Name, peak, rule.Operator, rule.Threshold)
+	}
+	parts := make([]string, len(rule.Conditions))
+	for i, c := range rule.Conditions {
+		parts[i] = fmt.Sprintf("%s %s %g", alertConditionName(c), c.Operator, c.Threshold)
+	}
+	join := " and "
+	if rule.Match == "any" {
+		join = " or "
+	}
+	return fmt.Sprintf("%s: %s (%s %g)", rule.Name, strings.Join(parts, join), alertConditionName(rule.Conditions[0]), peak)
+}
+
+func alertConditionName(c structs.AlertCondition) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return string(c.Aggregation)
+}
+
+// update compares a rule's breaching alerts to the ones firing, emits the changes, and
+// sends the notifications that are due: new and resolved alerts, escalations, and repeats
+func (e *alertEngine) update(ctx context.Context, rule *structs.AlertRule, breaching map[string]*activeAlert, now time.Time) {
+	firing := e.firing[rule.Name]
+	if firing == nil {
+		firing = map[string]*activeAlert{}
+	}
+	resolveAfter := alertDuration(rule.ResolveAfter)
+	repeat := alertDuration(rule.RepeatInterval)
+	sendResolved := rule.SendResolved == nil || *rule.SendResolved
+
+	notify := map[string][]AlertmanagerAlert{}
+	for fingerprint, alert := range breaching {
+		if current, ok := firing[fingerprint]; ok {
+			current.lastBreach = now
+			continue
+		}
+		e.svc.alertDrilldown(ctx, rule, alert, now)
+		alert.firedAt, alert.lastBreach = now, now
+		alert.notified = map[string]time.Time{}
+		firing[fingerprint] = alert
+
+		am := alert.toAlertmanager(fingerprint, time.Time{})
+		emitAlertEvent(rule, am)
+		for _, channel := range rule.Channels {
+			notify[channel] = append(notify[channel], am)
+			alert.notified[channel] = now
+		}
+	}
+
+	for fingerprint, alert := range firing {
+		if _, ok := breaching[fingerprint]; ok || now.Sub(alert.lastBreach) < resolveAfter {
+			continue
+		}
+		delete(firing, fingerprint)
+
+		am := alert.toAlertmanager(fingerprint, now)
+		emitAlertEvent(rule, am)
+		if sendResolved {
+			for channel := range alert.notified {
+				notify[channel] = append(notify[channel], am)
+			}
+		}
+	}
+
+	// Alerts waiting out resolve_after aren't escalated or repeated
+	for fingerprint, alert := range firing {
+		if alert.lastBreach.Before(now) {
+			continue
+		}
+		am := alert.toAlertmanager(fingerprint, time.Time{})
+		for i := alert.escalations; i < len(rule.Escalations); i++ {
+			step := rule.Escalations[i]
+			if now.Sub(alert.firedAt) < alertDuration(step.After) {
+				break
+			}
+			alert.escalations = i + 1
+			EmitInternal("alert.escalated", "warn", map[string]interface{}{
+				"alertname":   rule.Name,
+				"fingerprint": fingerprint,
+				"after":       step.After,
+				"channels":    step.Channels,
+			})
+			for _, channel := range step.Channels {
+				if _, ok := alert.notified[channel]; !ok {
+					notify[channel] = append(notify[channel], am)
+					alert.notified[channel] = now
+				}
+			}
+		}
+		if repeat == 0 {
+			continue
+		}
+		for channel, last := range alert.notified {
+			if now.Sub(last) >= repeat {
+				notify[channel] = append(notify[channel], am)
+				alert.notified[channel] = now
+			}
+		}
+	}
+
+	if len(firing) == 0 {
+		delete(e.firing, rule.Name)
+		delete(e.rules, rule.Name)
+	} else {
+		e.firing[rule.Name] = firing
+		e.rules[rule.Name] = rule
+	}
+	for channel, alerts := range notify {
+		notifyAlertChannel(ctx, rule, channel, alerts)
+	}
+}
+
+// emitAlertEvent stores an alert that started firing or resolved as a self event
+func emitAlertEvent(rule *structs.AlertRule, alert AlertmanagerAlert) {
+	name, level := "alert.firing", "warn"
+	data := map[string]interface{}{
+		"alertname":   rule.Name,
+		"fingerprint": alert.Fingerprint,
+		"labels":      alert.Labels,
+		"annotations": alert.Annotations,
+		"starts_at":   alert.StartsAt,
+		"source":      "monitor-core",
+	}
+	if alert.Status == "resolved" {
+		name, level = "alert.resolved", "info"
+		data["ends_at"] = alert.EndsAt
+	} else if severity, ok := alertmanagerSeverityLevels[alert.Labels["severity"]]; ok {
+		level = severity
+	}
+	EmitInternal(name, level, data)
+}
+
+// alertDuration parses a validated rule duration such as 15m; empty is 0
+func alertDuration(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, _ := parseOffset("duration", s)
+	return d
+}
+
+// toAlertmanager converts the alert to the webhook format; a non-zero endsAt resolves it
+func (a *activeAlert) toAlertmanager(fingerprint string, endsAt time.Time) AlertmanagerAlert {
+	status := "firing"
+	if !endsAt.IsZero() {
+		status = "resolved"
+	}
+	return AlertmanagerAlert{
+		Status:       status,
+		Labels:       a.labels,
+		Annotations:  a.annotations,
+		StartsAt:     a.startsAt,
+		EndsAt:       endsAt,
+		GeneratorURL: a.explorerURL,
+		Fingerprint:  fingerprint,
+		Top:          a.top,
+	}
+}
+
+// alertDrilldown adds what makes a new alert actionable from the notification: a link to
+// its events in the explorer, and with top_by the top values since it started breaching
+func (s *Service) alertDrilldown(ctx context.Context, rule *structs.AlertRule, alert *activeAlert, now time.Time) {
+	condition := alertConditions(rule)[0]
+	filters := append(slices.Clone(rule.Filters), condition.Filters...)
+	for _, g := range rule.GroupBy {
+		filters = append(filters, structs.QueryFilter{Field: g, Operator: "eq", Value: alert.groups[g]})
+	}
+	alert.explorerURL = alertExplorerURL(filters, alert.startsAt, now)
+
+	if rule.TopBy == "" {
+		return
+	}
+	result, err := s.QueryTopN(ctx, &structs.TopNQuery{
+		Aggregation: condition.Aggregation,
+		Field:       condition.Field,
+		GroupBy:     rule.TopBy,
+		Filters:     filters,
+		From:        alert.startsAt,
+		To:          now,
+		Limit:       alertTopGroups,
+	})
+	if err != nil {
+		log.Printf("alert %s: top %s failed: %v", rule.Name, rule.TopBy, err)
+		return
+	}
+	alert.top = result.Data
+	if len(alert.top) > 0 {
+		parts := make([]string, len(alert.top))
+		for i, row := range alert.top {
+			parts[i] = fmt.Sprintf("%s (%g)", row.Key, row.Value)
+		}
+		alert.annotations["top"] = strings.Join(parts, ", ")
+	}
+}
+
+// alertExplorerParams are the fields the explorer has their own inputs for
+var alertExplorerParams = map[string]bool{"service": true, "env": true, "level": true, "name": true}
+
+// alertExplorerURL links to the events matching filters in the web UI's event explorer,
+// or returns "" without ALERT_EXTERNAL_URL. Filters without their own input are passed as
+// the JSON filters the events API takes.
+func alertExplorerURL(filters []structs.QueryFilter, from, to time.Time) string {
+	if alertExternalURL == "" {
+		return ""
+	}
+	params := url.Values{}
+	params.Set("from", from.UTC().Format(time.RFC3339))
+	params.Set("to", to.UTC().Format(time.RFC3339))
+	var rest []structs.QueryFilter
+	for _, f := range filters {
+		if value, ok := f.Value.(string); ok && alertExplorerParams[f.Field] && f.Operator == "eq" && params.Get(f.Field) == "" {
+			params.Set(f.Field, value)
+			continue
+		}
+		rest = append(rest, f)
+	}
+	if len(rest) > 0 {
+		b, err := json.Marshal(rest)
+		if err == nil {
+			params.Set("filters", string(b))
+		}
+	}
+	return alertExternalURL + "/ui/?" + params.Encode() + "#events"
+}
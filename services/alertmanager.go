@@ -0,0 +1,348 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	// alertNameLabel is the label naming the rule an alert came from
+	alertNameLabel = "alertname"
+	// alertmanagerVersion is the webhook payload version sent and accepted
+	alertmanagerVersion = "4"
+	alertNotifyTimeout  = 10 * time.Second
+)
+
+// alertmanagerSeverityLevels maps the conventional severity label to event levels
+var alertmanagerSeverityLevels = map[string]string{
+	"critical": "error",
+	"error":    "error",
+	"warning":  "warn",
+	"info":     "info",
+}
+
+// AlertmanagerAlert is one alert in an Alertmanager webhook payload
+type AlertmanagerAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	// GeneratorURL links to the alert's events in the explorer (with ALERT_EXTERNAL_URL)
+	GeneratorURL string `json:"generatorURL"`
+	Fingerprint  string `json:"fingerprint"`
+
+	// Top is the rule's top_by values while the alert fired, for channel templates; the
+	// Alertmanager format carries them in the top annotation
+	Top []structs.TopNRow `json:"-"`
+}
+
+// AlertmanagerPayload is the body Alertmanager POSTs to webhook receivers (version 4)
+type AlertmanagerPayload struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	TruncatedAlerts   int                 `json:"truncatedAlerts"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts"`
+}
+
+// AlertChannel is a webhook receiving notifications in the Alertmanager format, so anything
+// built for Alertmanager (chat bridges, paging tools, Alertmanager itself) can route them
+type AlertChannel struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Template is a Go text/template rendering the request body instead, for channels that
+	// want their own message format (e.g. a Slack incoming webhook); see AlertNotification
+	Template string `json:"template,omitempty"`
+	// ContentType is the Content-Type of templated bodies (default application/json)
+	ContentType string `json:"content_type,omitempty"`
+
+	template *template.Template
+}
+
+// AlertNotification is what channel templates render: the Alertmanager payload, with the
+// alerts split by status and the rule they came from
+type AlertNotification struct {
+	*AlertmanagerPayload
+	Firing   []AlertmanagerAlert
+	Resolved []AlertmanagerAlert
+	Rule     *structs.AlertRule
+}
+
+// alertTemplateFuncs are available in channel templates
+var alertTemplateFuncs = template.FuncMap{
+	// json encodes a value, e.g. to embed text in a JSON body: {"text": {{json .CommonAnnotations.summary}}}
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+}
+
+// alertChannels holds the configured channels by name (set by LoadAlertChannels)
+var alertChannels = map[string]*AlertChannel{}
+
+// alertExternalURL is the base URL of this server that notification links point to
+var alertExternalURL string
+
+var alertClient = &http.Client{Timeout: alertNotifyTimeout}
+
+// LoadAlertChannels reads the channels in configPath, a JSON object of channel name to
+// AlertChannel, and sets the base URL notifications link to (empty leaves links out)
+func LoadAlertChannels(configPath, externalURL string) error {
+	if externalURL != "" {
+		u, err := url.Parse(externalURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid external url: %q", externalURL)
+		}
+	}
+	alertExternalURL = strings.TrimSuffix(externalURL, "/")
+
+	channels := map[string]*AlertChannel{}
+	if configPath != "" {
+		b, err := os.ReadFile(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to read alert channels: %w", err)
+		}
+		if err := json.Unmarshal(b, &channels); err != nil {
+			return fmt.Errorf("invalid alert channels: %w", err)
+		}
+	}
+	for name, channel := range channels {
+		if channel == nil || channel.URL == "" {
+			return fmt.Errorf("alert channel %q: url is required", name)
+		}
+		if channel.Template != "" {
+			t, err := template.New(name).Funcs(alertTemplateFuncs).Option("missingkey=zero").Parse(channel.Template)
+			if err != nil {
+				return fmt.Errorf("alert channel %q: invalid template: %w", name, err)
+			}
+			channel.template = t
+		}
+		if channel.ContentType == "" {
+			channel.ContentType = "application/json"
+		}
+	}
+	alertChannels = channels
+	return nil
+}
+
+// alertFingerprint identifies an alert by its labels, like Alertmanager's fingerprint
+func alertFingerprint(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(labels[name]))
+		h.Write([]byte{0xff})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// newAlertmanagerPayload groups alerts of one rule into a webhook payload. The payload is
+// firing while any of its alerts is, and the common labels and annotations are the ones
+// every alert shares.
+func newAlertmanagerPayload(rule string, receiver string, alerts []AlertmanagerAlert) *AlertmanagerPayload {
+	payload := &AlertmanagerPayload{
+		Version:           alertmanagerVersion,
+		GroupKey:          fmt.Sprintf("{}:{%s=%q}", alertNameLabel, rule),
+		Status:            "resolved",
+		Receiver:          receiver,
+		GroupLabels:       map[string]string{alertNameLabel: rule},
+		CommonLabels:      map[string]string{},
+		CommonAnnotations: map[string]string{},
+		ExternalURL:       alertExternalURL,
+		Alerts:            alerts,
+	}
+	for i, alert := range alerts {
+		if alert.Status == "firing" {
+			payload.Status = "firing"
+		}
+		if i == 0 {
+			for k, v := range alert.Labels {
+				payload.CommonLabels[k] = v
+			}
+			for k, v := range alert.Annotations {
+				payload.CommonAnnotations[k] = v
+			}
+			continue
+		}
+		for k, v := range payload.CommonLabels {
+			if alert.Labels[k] != v {
+				delete(payload.CommonLabels, k)
+			}
+		}
+		for k, v := range payload.CommonAnnotations {
+			if alert.Annotations[k] != v {
+				delete(payload.CommonAnnotations, k)
+			}
+		}
+	}
+	return payload
+}
+
+// notifyAlertChannel sends alerts of a rule to one of the configured channels. Failures
+// are logged as alert.notify_failed self events; the next notification is sent regardless.
+func notifyAlertChannel(ctx context.Context, rule *structs.AlertRule, name string, alerts []AlertmanagerAlert) {
+	channel, ok := alertChannels[name]
+	if !ok {
+		return
+	}
+	payload := newAlertmanagerPayload(rule.Name, name, alerts)
+	if err := channel.send(ctx, rule, payload); err != nil {
+		EmitInternal("alert.notify_failed", "error", map[string]interface{}{
+			"rule":    rule.Name,
+			"channel": name,
+			"alerts":  len(alerts),
+			"error":   err.Error(),
+		})
+	}
+}
+
+func (c *AlertChannel) send(ctx context.Context, rule *structs.AlertRule, payload *AlertmanagerPayload) error {
+	body, err := c.render(rule, payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", c.ContentType)
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("channel returned %s", resp.Status)
+	}
+	return nil
+}
+
+// render returns the request body: the channel's template, or the Alertmanager JSON payload
+func (c *AlertChannel) render(rule *structs.AlertRule, payload *AlertmanagerPayload) ([]byte, error) {
+	if c.template == nil {
+		return json.Marshal(payload)
+	}
+	data := &AlertNotification{AlertmanagerPayload: payload, Rule: rule}
+	for _, alert := range payload.Alerts {
+		if alert.Status == "firing" {
+			data.Firing = append(data.Firing, alert)
+		} else {
+			data.Resolved = append(data.Resolved, alert)
+		}
+	}
+	var buf bytes.Buffer
+	if err := c.template.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("template failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseAlertmanagerWebhook maps an Alertmanager webhook payload to one alert.firing or
+// alert.resolved event per alert, the same events the alert engine emits, so alerts from
+// Prometheus and from monitor-core rules can be queried and routed together
+// The service comes from the service or job label, falling back to defaultService.
+func ParseAlertmanagerWebhook(body []byte, defaultService string) ([]*structs.Event, error) {
+	var payload AlertmanagerPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if len(payload.Alerts) == 0 {
+		return nil, fmt.Errorf("alerts are required")
+	}
+
+	now := time.Now().UTC()
+	events := make([]*structs.Event, 0, len(payload.Alerts))
+	for _, alert := range payload.Alerts {
+		resolved := alert.Status == "resolved"
+
+		service := alert.Labels["service"]
+		if service == "" {
+			service = alert.Labels["job"]
+		}
+		if service == "" {
+			service = defaultService
+		}
+
+		level := "info"
+		if !resolved {
+			level = "warn"
+			if severity, ok := alertmanagerSeverityLevels[alert.Labels["severity"]]; ok {
+				level = severity
+			}
+		}
+
+		// Alertmanager repeats firing alerts with their original startsAt, so each
+		// notification is timestamped when it arrives
+		name := "alert.firing"
+		timestamp := now
+		if resolved {
+			name = "alert.resolved"
+			timestamp = alert.EndsAt
+		}
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+
+		fingerprint := alert.Fingerprint
+		if fingerprint == "" {
+			fingerprint = alertFingerprint(alert.Labels)
+		}
+
+		data := map[string]interface{}{
+			"alertname":   alert.Labels[alertNameLabel],
+			"fingerprint": fingerprint,
+			"labels":      alert.Labels,
+			"annotations": alert.Annotations,
+			"starts_at":   alert.StartsAt,
+			"receiver":    payload.Receiver,
+			"source":      "alertmanager",
+		}
+		if resolved {
+			data["ends_at"] = alert.EndsAt
+		}
+		if alert.GeneratorURL != "" {
+			data["generator_url"] = alert.GeneratorURL
+		}
+
+		events = append(events, &structs.Event{
+			Timestamp: timestamp.UTC(),
+			Service:   service,
+			Env:       alert.Labels["env"],
+			Name:      name,
+			Level:     level,
+			Data:      data,
+		})
+	}
+	return events, nil
+}
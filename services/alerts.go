@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	// maxAlertFor caps how many consecutive buckets a rule can wait for before firing
+	maxAlertFor = 1440
+	// maxAlertConditions caps the sub-queries of a composite rule
+	maxAlertConditions = 5
+)
+
+// alertOperators compare a bucket's value to a rule's threshold
+var alertOperators = map[string]func(value, threshold float64) bool{
+	"gt":  func(v, t float64) bool { return v > t },
+	"gte": func(v, t float64) bool { return v >= t },
+	"lt":  func(v, t float64) bool { return v < t },
+	"lte": func(v, t float64) bool { return v <= t },
+}
+
+// setAlertRuleDefaults fills in the defaults of a rule and checks its conditions
+func setAlertRuleDefaults(rule *structs.AlertRule) error {
+	if rule.Interval == "" {
+		rule.Interval = structs.IntervalMinute
+	}
+	if rule.For == 0 {
+		rule.For = 1
+	}
+	if rule.For < 1 || rule.For > maxAlertFor {
+		return fmt.Errorf("invalid for: must be between 1 and %d buckets", maxAlertFor)
+	}
+
+	if len(rule.Conditions) == 0 {
+		if rule.Match != "" {
+			return fmt.Errorf("invalid match: only composite rules (with conditions) have one")
+		}
+		if rule.Aggregation == "" {
+			rule.Aggregation = structs.AggCount
+		}
+		return checkAlertOperator(rule.Operator)
+	}
+
+	if rule.Aggregation != "" || rule.Field != "" || rule.Operator != "" {
+		return fmt.Errorf("invalid rule: composite rules set aggregation, field, and operator per condition")
+	}
+	if len(rule.Conditions) > maxAlertConditions {
+		return fmt.Errorf("too many conditions (max %d)", maxAlertConditions)
+	}
+	if rule.Match == "" {
+		rule.Match = "all"
+	}
+	if rule.Match != "all" && rule.Match != "any" {
+		return fmt.Errorf("invalid match: %s (use all or any)", rule.Match)
+	}
+	for i := range rule.Conditions {
+		c := &rule.Conditions[i]
+		if c.Aggregation == "" {
+			c.Aggregation = structs.AggCount
+		}
+		if err := checkAlertOperator(c.Operator); err != nil {
+			return fmt.Errorf("conditions[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func checkAlertOperator(operator string) error {
+	if operator == "" {
+		return fmt.Errorf("operator is required")
+	}
+	if _, ok := alertOperators[operator]; !ok {
+		return fmt.Errorf("invalid operator: %s (use gt, gte, lt, or lte)", operator)
+	}
+	return nil
+}
+
+// alertConditions returns the sub-queries of a rule; a simple rule is a single condition
+func alertConditions(rule *structs.AlertRule) []structs.AlertCondition {
+	if len(rule.Conditions) > 0 {
+		return rule.Conditions
+	}
+	return []structs.AlertCondition{{
+		Aggregation: rule.Aggregation,
+		Field:       rule.Field,
+		Operator:    rule.Operator,
+		Threshold:   rule.Threshold,
+	}}
+}
+
+// alertZeroFilled reports whether empty buckets count as 0 for an aggregation, so "fewer
+// than N" rules see them; other aggregations have no value there
+func alertZeroFilled(agg structs.AggregationType) bool {
+	return agg == structs.AggCount || agg == structs.AggCountUnique || agg == structs.AggSum
+}
+
+// alertSeriesQuery is the time series of one condition of a rule
+func alertSeriesQuery(rule *structs.AlertRule, condition structs.AlertCondition, from, to time.Time) *structs.TimeSeriesQuery {
+	return &structs.TimeSeriesQuery{
+		Aggregation: condition.Aggregation,
+		Field:       condition.Field,
+		Interval:    rule.Interval,
+		GroupBy:     rule.GroupBy,
+		Filters:     append(slices.Clone(rule.Filters), condition.Filters...),
+		From:        from,
+		To:          to,
+		FillZeros:   alertZeroFilled(condition.Aggregation),
+	}
+}
+
+// alertPoint is one bucket of an evaluated series. Value is the first condition's value,
+// and HasValue is false when that condition had no value in the bucket.
+type alertPoint struct {
+	Timestamp time.Time
+	Value     float64
+	HasValue  bool
+	Breach    bool
+}
+
+// alertSeries is the evaluated buckets of one group of a rule, oldest first, with the
+// buckets no condition had a value in left out
+type alertSeries struct {
+	Groups map[string]string
+	Points []alertPoint
+}
+
+// queryAlertSeries runs each condition of a rule and combines them by group and bucket
+func (s *Service) queryAlertSeries(ctx context.Context, rule *structs.AlertRule, from, to time.Time) ([]alertSeries, error) {
+	conditions := alertConditions(rule)
+
+	type group struct {
+		groups map[string]string
+		// values is the bucket values of each condition, by Unix time
+		values []map[int64]float64
+	}
+	groups := map[string]*group{}
+	var keys []string
+	for i, condition := range conditions {
+		result, err := s.QueryTimeSeries(ctx, alertSeriesQuery(rule, condition, from, to))
+		if err != nil {
+			if len(conditions) > 1 {
+				return nil, fmt.Errorf("conditions[%d]: %w", i, err)
+			}
+			return nil, err
+		}
+		for _, series := range result.Series {
+			key := alertGroupKey(rule.GroupBy, series.Groups)
+			g, ok := groups[key]
+			if !ok {
+				g = &group{groups: series.Groups, values: make([]map[int64]float64, len(conditions))}
+				groups[key] = g
+				keys = append(keys, key)
+			}
+			g.values[i] = make(map[int64]float64, len(series.DataPoints))
+			for _, p := range series.DataPoints {
+				g.values[i][p.Timestamp.Unix()] = p.Value
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	all := make([]alertSeries, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		series := alertSeries{Groups: g.groups}
+		for t := truncateTime(from, rule.Interval); !t.After(to); t = advanceTime(t, rule.Interval) {
+			point := alertPoint{Timestamp: t, Breach: rule.Match != "any"}
+			found := false
+			for i, condition := range conditions {
+				value, ok := g.values[i][t.Unix()]
+				if !ok && alertZeroFilled(condition.Aggregation) {
+					ok = true
+				}
+				if ok {
+					found = true
+				}
+				if i == 0 {
+					point.Value, point.HasValue = value, ok
+				}
+				breach := ok && alertOperators[condition.Operator](value, condition.Threshold)
+				if rule.Match == "any" {
+					point.Breach = point.Breach || breach
+				} else {
+					point.Breach = point.Breach && breach
+				}
+			}
+			if found {
+				series.Points = append(series.Points, point)
+			}
+		}
+		all = append(all, series)
+	}
+	return all, nil
+}
+
+// alertGroupKey identifies a group by its group_by values, in group_by order
+func alertGroupKey(groupBy []string, groups map[string]string) string {
+	values := make([]string, len(groupBy))
+	for i, g := range groupBy {
+		values[i] = groups[g]
+	}
+	return strings.Join(values, "\x00")
+}
+
+// evaluateAlertSeries returns the periods a series breaches the rule for at least For
+// consecutive buckets; a missing bucket ends a run
+func evaluateAlertSeries(rule *structs.AlertRule, series alertSeries) []structs.AlertFiring {
+	further := alertOperators[alertConditions(rule)[0].Operator]
+
+	var firings []structs.AlertFiring
+	var run []alertPoint
+	flush := func() {
+		if len(run) >= rule.For {
+			firing := structs.AlertFiring{
+				Groups:  series.Groups,
+				Start:   run[0].Timestamp,
+				FiredAt: run[rule.For-1].Timestamp,
+				End:     advanceTime(run[len(run)-1].Timestamp, rule.Interval),
+			}
+			peaked := false
+			for _, p := range run {
+				if p.HasValue && (!peaked || further(p.Value, firing.Peak)) {
+					firing.Peak, peaked = p.Value, true
+				}
+			}
+			firings = append(firings, firing)
+		}
+		run = run[:0]
+	}
+	for _, p := range series.Points {
+		if len(run) > 0 && !p.Timestamp.Equal(advanceTime(run[len(run)-1].Timestamp, rule.Interval)) {
+			flush()
+		}
+		if p.Breach {
+			run = append(run, p)
+		} else {
+			flush()
+		}
+	}
+	flush()
+	return firings
+}
+
+// BacktestAlertRule evaluates a proposed rule over a past time range and returns every
+// period it would have fired for, so thresholds can be tuned before the rule pages anyone
+// Inhibitions are ignored, since they depend on other rules.
+func (s *Service) BacktestAlertRule(ctx context.Context, query *structs.AlertBacktestQuery) (*structs.AlertBacktestResult, error) {
+	rule := &query.Rule
+	if err := setAlertRuleDefaults(rule); err != nil {
+		return nil, err
+	}
+	if query.From.IsZero() || query.To.IsZero() {
+		return nil, fmt.Errorf("from and to are required")
+	}
+	if !query.To.After(query.From) {
+		return nil, fmt.Errorf("invalid time range: to must be after from")
+	}
+
+	series, err := s.queryAlertSeries(ctx, rule, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &structs.AlertBacktestResult{Series: len(series), Windows: []structs.AlertFiring{}, Rule: rule}
+	for _, s := range series {
+		result.Windows = append(result.Windows, evaluateAlertSeries(rule, s)...)
+	}
+	sort.SliceStable(result.Windows, func(i, j int) bool {
+		return result.Windows[i].FiredAt.Before(result.Windows[j].FiredAt)
+	})
+	result.Firings = len(result.Windows)
+	for _, w := range result.Windows {
+		result.FiringTime += w.End.Sub(w.FiredAt).Seconds()
+	}
+	return result, nil
+}
+
+// checkAlertRule fills in a rule's defaults and checks it the way the validator checks a
+// time series query, returning the first problem
+func checkAlertRule(ctx context.Context, rule *structs.AlertRule) error {
+	if err := setAlertRuleDefaults(rule); err != nil {
+		return err
+	}
+	v := &QueryValidation{}
+	v.groupBy(ctx, rule.GroupBy)
+	v.filters(ctx, "filters", rule.Filters)
+	if len(v.Errors) > 0 {
+		return fmt.Errorf("invalid rule: %s: %s", v.Errors[0].Path, v.Errors[0].Message)
+	}
+	if rule.TopBy != "" {
+		v.group(ctx, "top_by", rule.TopBy)
+		if len(v.Errors) > 0 {
+			return fmt.Errorf("invalid rule: %s: %s", v.Errors[0].Path, v.Errors[0].Message)
+		}
+	}
+	for i, condition := range alertConditions(rule) {
+		v := &QueryValidation{}
+		v.aggregation(ctx, condition.Aggregation, condition.Field)
+		v.filters(ctx, "filters", condition.Filters)
+		if len(v.Errors) == 0 {
+			continue
+		}
+		if len(rule.Conditions) > 0 {
+			return fmt.Errorf("invalid rule: conditions[%d].%s: %s", i, v.Errors[0].Path, v.Errors[0].Message)
+		}
+		return fmt.Errorf("invalid rule: %s: %s", v.Errors[0].Path, v.Errors[0].Message)
+	}
+	if _, err := buildIntervalExpr(rule.Interval); err != nil {
+		return err
+	}
+	for name := range rule.Labels {
+		if !safeIdentifierRegex.MatchString(name) || name == alertNameLabel {
+			return fmt.Errorf("invalid label name: %s", name)
+		}
+	}
+	for i, inhibition := range rule.InhibitedBy {
+		if !safeIdentifierRegex.MatchString(inhibition.Rule) || inhibition.Rule == rule.Name {
+			return fmt.Errorf("invalid inhibited_by[%d]: rule must name another alert rule", i)
+		}
+		for _, label := range inhibition.Equal {
+			if !safeIdentifierRegex.MatchString(label) {
+				return fmt.Errorf("invalid inhibited_by[%d]: invalid label name: %s", i, label)
+			}
+		}
+	}
+	if err := checkAlertChannels(rule.Channels); err != nil {
+		return err
+	}
+	var after time.Duration
+	for i, step := range rule.Escalations {
+		path := fmt.Sprintf("escalations[%d].after", i)
+		d, err := parseOffset(path, step.After)
+		if err != nil {
+			return err
+		}
+		if d <= after && i > 0 {
+			return fmt.Errorf("invalid %s: escalations must be in increasing after order", path)
+		}
+		after = d
+		if len(step.Channels) == 0 {
+			return fmt.Errorf("escalations[%d].channels are required", i)
+		}
+		if err := checkAlertChannels(step.Channels); err != nil {
+			return err
+		}
+	}
+	for name, value := range map[string]string{"repeat_interval": rule.RepeatInterval, "resolve_after": rule.ResolveAfter} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseOffset(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkAlertChannels(channels []string) error {
+	for _, channel := range channels {
+		if _, ok := alertChannels[channel]; !ok {
+			return fmt.Errorf("invalid channel: %s is not in ALERT_CHANNELS", channel)
+		}
+	}
+	return nil
+}
+
+// ListAlertRules returns every alert rule
+func (s *Service) ListAlertRules(ctx context.Context) ([]structs.AlertRule, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf("SELECT name, rule FROM %s.alert_rules FINAL WHERE deleted = 0 ORDER BY name", db.Database))
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []structs.AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+// GetAlertRule returns an alert rule, or nil if it doesn't exist
+func (s *Service) GetAlertRule(ctx context.Context, name string) (*structs.AlertRule, error) {
+	row := s.queryRow(ctx, fmt.Sprintf("SELECT name, rule FROM %s.alert_rules FINAL WHERE name = ? AND deleted = 0", db.Database), name)
+	rule, err := scanAlertRule(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return rule, err
+}
+
+func scanAlertRule(scan func(dest ...interface{}) error) (*structs.AlertRule, error) {
+	var name, body string
+	if err := scan(&name, &body); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	var rule structs.AlertRule
+	if err := json.Unmarshal([]byte(body), &rule); err != nil {
+		return nil, fmt.Errorf("invalid stored alert rule %s: %w", name, err)
+	}
+	rule.Name = name
+	return &rule, nil
+}
+
+// PutAlertRule validates and stores an alert rule. The engine evaluates rules without an
+// access role, so callers restricted to some services or envs can't save them.
+func (s *Service) PutAlertRule(ctx context.Context, rule *structs.AlertRule) error {
+	if err := checkPutAlertRule(ctx, rule); err != nil {
+		return err
+	}
+	return s.writeAlertRule(ctx, rule)
+}
+
+// checkPutAlertRule validates a rule about to be saved, filling in its defaults
+func checkPutAlertRule(ctx context.Context, rule *structs.AlertRule) error {
+	if !safeIdentifierRegex.MatchString(rule.Name) {
+		return fmt.Errorf("invalid alert rule name: %s", rule.Name)
+	}
+	if AccessRoleFromContext(ctx).RestrictsEvents() {
+		return fmt.Errorf("invalid request: alert rules can't be saved by a role restricted to some services or envs")
+	}
+	return checkAlertRule(ctx, rule)
+}
+
+func (s *Service) writeAlertRule(ctx context.Context, rule *structs.AlertRule) error {
+	body, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.alert_rules (name, rule) VALUES (?, ?)", db.Database), rule.Name, string(body)); err != nil {
+		return fmt.Errorf("failed to save alert rule: %w", err)
+	}
+	return nil
+}
+
+// DeleteAlertRule removes an alert rule; its firing alerts resolve on the next evaluation
+func (s *Service) DeleteAlertRule(ctx context.Context, name string) error {
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.alert_rules (name, rule, deleted) VALUES (?, '{}', 1)", db.Database), name); err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+	return nil
+}
@@ -2,42 +2,74 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/aidenappl/monitor-core/db"
 	"github.com/aidenappl/monitor-core/structs"
 )
 
 // MaxTimeSeriesPoints is the maximum number of data points allowed in a time series
 const MaxTimeSeriesPoints = 10000
 
+// MaxWindowBuckets is the maximum number of buckets a rolling window may span
+const MaxWindowBuckets = 400
+
+// MaxSparklinePoints is the maximum number of buckets in a gauge sparkline
+const MaxSparklinePoints = 200
+
+// MaxBatchGauges is the maximum number of gauges in one batch request
+const MaxBatchGauges = 50
+
+// batchGaugeConcurrency bounds the gauges of a batch queried at once
+const batchGaugeConcurrency = 4
+
+// maxAnalyticsLimit is the most rows an analytics query returns
+const maxAnalyticsLimit = 10000
+
 // MaxQueryDuration is the maximum time range allowed for queries (90 days)
 const MaxQueryDuration = 90 * 24 * time.Hour
 
 // safeIdentifierRegex validates field names to prevent SQL injection
 var safeIdentifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 
-// validGroupByColumns are columns that can be used in GROUP BY
-var validGroupByColumns = map[string]bool{
-	"service":    true,
-	"env":        true,
-	"job_id":     true,
-	"request_id": true,
-	"trace_id":   true,
-	"user_id":    true,
-	"name":       true,
-	"level":      true,
+// validGroupByColumns are columns that can be used in GROUP BY (set by SetLabelColumns)
+var validGroupByColumns map[string]bool
+
+// derivedNumericFields are computed fields usable in numeric aggregations and filters
+var derivedNumericFields = map[string]string{
+	// ingest_lag is the milliseconds between the event timestamp and when the server received it
+	"ingest_lag": "toFloat64(dateDiff('millisecond', timestamp, received_at))",
+}
+
+// bucketGroupRegex matches bucket(field, width) group by fields
+var bucketGroupRegex = regexp.MustCompile(`^bucket\(\s*([a-zA-Z0-9_.]+)\s*,\s*([0-9]+(?:\.[0-9]+)?)\s*\)$`)
+
+// timeDimensions are time parts usable in group_by, for traffic-shape and seasonality reports.
+// Values are strings like other groups; hours are zero-padded so they sort naturally.
+var timeDimensions = map[string]string{
+	"time.hour_of_day": "leftPad(toString(toHour(timestamp)), 2, '0')",
+	"time.day_of_week": "toString(toDayOfWeek(timestamp))", // 1 = Monday ... 7 = Sunday
 }
 
+// errorLevels are the levels counted as errors
+const errorLevels = "level IN ('error', 'fatal')"
+
 // buildAggregationExpr builds the SQL aggregation expression
 // All expressions are wrapped in toFloat64() for consistent Go scanning
 func buildAggregationExpr(agg structs.AggregationType, field string) (string, error) {
 	switch agg {
 	case structs.AggCount:
 		return "toFloat64(count())", nil
+	case structs.AggErrorRate:
+		return fmt.Sprintf("toFloat64(countIf(%s) / greatest(count(), 1))", errorLevels), nil
 	case structs.AggCountUnique:
 		if field == "" {
 			return "", fmt.Errorf("field is required for count_unique aggregation")
@@ -124,14 +156,101 @@ func buildAggregationExpr(agg structs.AggregationType, field string) (string, er
 	}
 }
 
+// conditionalAggregations are the -If forms of the aggregations that take a field
+var conditionalAggregations = map[structs.AggregationType]string{
+	structs.AggCountUnique: "uniqIf",
+	structs.AggSum:         "sumIf",
+	structs.AggAvg:         "avgIf",
+	structs.AggMin:         "minIf",
+	structs.AggMax:         "maxIf",
+	structs.AggP50:         "quantileIf(0.5)",
+	structs.AggP90:         "quantileIf(0.9)",
+	structs.AggP95:         "quantileIf(0.95)",
+	structs.AggP99:         "quantileIf(0.99)",
+}
+
+// buildConditionalAggregationExpr builds an aggregation over only the rows matching cond,
+// using the -If combinator so several can share one scan. An empty cond is the plain
+// aggregation.
+func buildConditionalAggregationExpr(agg structs.AggregationType, field, cond string) (string, error) {
+	expr, err := buildAggregationExpr(agg, field)
+	if err != nil || cond == "" {
+		return expr, err
+	}
+	var col string
+	switch agg {
+	case structs.AggCount:
+		return fmt.Sprintf("toFloat64(countIf(%s))", cond), nil
+	case structs.AggErrorRate:
+		return fmt.Sprintf("toFloat64(countIf(%s AND (%s)) / greatest(countIf(%s), 1))", errorLevels, cond, cond), nil
+	case structs.AggCountUnique:
+		col, err = buildFieldExpr(field)
+	default:
+		col, err = buildNumericFieldExpr(field)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("toFloat64(%s(%s, %s))", conditionalAggregations[agg], col, cond), nil
+}
+
+// maxColumns is the most columns an analytics query can add
+const maxColumns = 20
+
+// buildColumnExprs builds the SELECT expressions of an analytics query's columns, aliased
+// col_0, col_1, ..., and the arguments of their conditions
+func buildColumnExprs(columns []structs.AggregationColumn) ([]string, []interface{}, error) {
+	if len(columns) > maxColumns {
+		return nil, nil, fmt.Errorf("too many columns (max %d)", maxColumns)
+	}
+	var exprs []string
+	var args []interface{}
+	seen := make(map[string]bool)
+	for i, c := range columns {
+		if !safeIdentifierRegex.MatchString(c.Name) || c.Name == "value" {
+			return nil, nil, fmt.Errorf("invalid column name: %q", c.Name)
+		}
+		if seen[c.Name] {
+			return nil, nil, fmt.Errorf("invalid column name: %s is used twice", c.Name)
+		}
+		seen[c.Name] = true
+
+		agg := c.Aggregation
+		if agg == "" {
+			agg = structs.AggCount
+		}
+		cond, condArgs, err := buildFilterClause(c.Filters)
+		if err != nil {
+			return nil, nil, fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		expr, err := buildConditionalAggregationExpr(agg, c.Field, cond)
+		if err != nil {
+			return nil, nil, fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		exprs = append(exprs, fmt.Sprintf("%s AS col_%d", expr, i))
+		args = append(args, condArgs...)
+	}
+	return exprs, args, nil
+}
+
+// columnFields returns the fields and filters the columns of an analytics query read
+func columnFields(columns []structs.AggregationColumn) ([]string, []structs.QueryFilter) {
+	var fields []string
+	var filters []structs.QueryFilter
+	for _, c := range columns {
+		fields = append(fields, c.Field)
+		filters = append(filters, c.Filters...)
+	}
+	return fields, filters
+}
+
 // buildFieldExpr builds a SQL expression for a field (column or JSON path)
 func buildFieldExpr(field string) (string, error) {
-	if strings.HasPrefix(field, "data.") {
-		key := strings.TrimPrefix(field, "data.")
-		if !safeIdentifierRegex.MatchString(key) {
-			return "", fmt.Errorf("invalid data field name: %s", key)
-		}
-		return fmt.Sprintf("JSONExtractString(data, '%s')", key), nil
+	if expr, ok, err := buildLookupExpr(field); ok {
+		return expr, err
+	}
+	if key, ok, err := dataKey(field); ok {
+		return dataStringExpr(key), err
 	}
 	if !validGroupByColumns[field] {
 		return "", fmt.Errorf("invalid field: %s", field)
@@ -141,14 +260,13 @@ func buildFieldExpr(field string) (string, error) {
 
 // buildNumericFieldExpr builds a SQL expression for a numeric field
 func buildNumericFieldExpr(field string) (string, error) {
-	if strings.HasPrefix(field, "data.") {
-		key := strings.TrimPrefix(field, "data.")
-		if !safeIdentifierRegex.MatchString(key) {
-			return "", fmt.Errorf("invalid data field name: %s", key)
-		}
-		return fmt.Sprintf("toFloat64OrNull(JSONExtractRaw(data, '%s'))", key), nil
+	if key, ok, err := dataKey(field); ok {
+		return dataNumberExpr(key), err
+	}
+	if expr, ok := derivedNumericFields[field]; ok {
+		return expr, nil
 	}
-	return "", fmt.Errorf("numeric aggregation only supported on data.* fields")
+	return "", fmt.Errorf("numeric aggregation only supported on data.* and derived fields")
 }
 
 // buildGroupByExprs builds GROUP BY expressions
@@ -162,135 +280,99 @@ func buildGroupByExprs(groupBy []string) ([]string, []string, error) {
 
 	for i, g := range groupBy {
 		alias := fmt.Sprintf("group_%d", i)
-		if strings.HasPrefix(g, "data.") {
-			key := strings.TrimPrefix(g, "data.")
-			if !safeIdentifierRegex.MatchString(key) {
-				return nil, nil, fmt.Errorf("invalid data field name: %s", key)
-			}
-			exprs = append(exprs, fmt.Sprintf("JSONExtractString(data, '%s') AS %s", key, alias))
-		} else if validGroupByColumns[g] {
-			exprs = append(exprs, fmt.Sprintf("%s AS %s", g, alias))
-		} else {
-			return nil, nil, fmt.Errorf("invalid group by field: %s", g)
+		expr, err := buildGroupExpr(g)
+		if err != nil {
+			return nil, nil, err
 		}
+		exprs = append(exprs, fmt.Sprintf("%s AS %s", expr, alias))
 		aliases = append(aliases, alias)
 	}
 	return exprs, aliases, nil
 }
 
-// buildFilterClause builds WHERE clause from filters
-func buildFilterClause(filters []structs.QueryFilter) (string, []interface{}, error) {
-	if len(filters) == 0 {
-		return "", nil, nil
+// buildGroupExpr builds the expression of a single group by field
+func buildGroupExpr(g string) (string, error) {
+	if key, ok, err := dataKey(g); ok {
+		return dataStringExpr(key), err
 	}
-
-	var conditions []string
-	var args []interface{}
-
-	for _, f := range filters {
-		cond, condArgs, err := buildSingleFilter(f)
-		if err != nil {
-			return "", nil, err
-		}
-		conditions = append(conditions, cond)
-		args = append(args, condArgs...)
+	if validGroupByColumns[g] {
+		return g, nil
 	}
-
-	return strings.Join(conditions, " AND "), args, nil
+	if expr, ok, err := buildLookupExpr(g); ok {
+		return expr, err
+	}
+	if expr, ok := timeDimensions[g]; ok {
+		return expr, nil
+	}
+	if m := bucketGroupRegex.FindStringSubmatch(g); m != nil {
+		return buildBucketExpr(m[1], m[2])
+	}
+	return "", fmt.Errorf("invalid group by field: %s", g)
 }
 
-// buildSingleFilter builds a single filter condition
-func buildSingleFilter(f structs.QueryFilter) (string, []interface{}, error) {
-	var fieldExpr string
-
-	if strings.HasPrefix(f.Field, "data.") {
-		key := strings.TrimPrefix(f.Field, "data.")
-		if !safeIdentifierRegex.MatchString(key) {
-			return "", nil, fmt.Errorf("invalid data field name: %s", key)
-		}
-		// Check if operator suggests numeric comparison
-		switch f.Operator {
-		case "lt", "gt", "lte", "gte":
-			fieldExpr = fmt.Sprintf("toFloat64OrNull(JSONExtractRaw(data, '%s'))", key)
-		default:
-			fieldExpr = fmt.Sprintf("JSONExtractString(data, '%s')", key)
-		}
-	} else if validColumns[f.Field] {
-		fieldExpr = f.Field
-	} else {
-		return "", nil, fmt.Errorf("invalid filter field: %s", f.Field)
-	}
-
-	switch f.Operator {
-	case "eq", "":
-		return fmt.Sprintf("%s = ?", fieldExpr), []interface{}{f.Value}, nil
-	case "neq":
-		return fmt.Sprintf("%s != ?", fieldExpr), []interface{}{f.Value}, nil
-	case "lt":
-		return fmt.Sprintf("%s < ?", fieldExpr), []interface{}{f.Value}, nil
-	case "gt":
-		return fmt.Sprintf("%s > ?", fieldExpr), []interface{}{f.Value}, nil
-	case "lte":
-		return fmt.Sprintf("%s <= ?", fieldExpr), []interface{}{f.Value}, nil
-	case "gte":
-		return fmt.Sprintf("%s >= ?", fieldExpr), []interface{}{f.Value}, nil
-	case "contains":
-		return fmt.Sprintf("%s LIKE ?", fieldExpr), []interface{}{fmt.Sprintf("%%%v%%", f.Value)}, nil
-	case "startswith":
-		return fmt.Sprintf("%s LIKE ?", fieldExpr), []interface{}{fmt.Sprintf("%v%%", f.Value)}, nil
-	case "endswith":
-		return fmt.Sprintf("%s LIKE ?", fieldExpr), []interface{}{fmt.Sprintf("%%%v", f.Value)}, nil
-	case "in":
-		if values, ok := f.Value.([]interface{}); ok {
-			placeholders := make([]string, len(values))
-			for i := range values {
-				placeholders[i] = "?"
-			}
-			return fmt.Sprintf("%s IN (%s)", fieldExpr, strings.Join(placeholders, ", ")), values, nil
-		}
-		if values, ok := f.Value.([]string); ok {
-			placeholders := make([]string, len(values))
-			args := make([]interface{}, len(values))
-			for i, v := range values {
-				placeholders[i] = "?"
-				args[i] = v
-			}
-			return fmt.Sprintf("%s IN (%s)", fieldExpr, strings.Join(placeholders, ", ")), args, nil
-		}
-		return "", nil, fmt.Errorf("in operator requires array value")
-	default:
-		return "", nil, fmt.Errorf("unsupported operator: %s", f.Operator)
+// buildBucketExpr groups a numeric field into buckets of width, labelled by their lower bound.
+// Events where the field isn't numeric fall into the "" group.
+func buildBucketExpr(field, width string) (string, error) {
+	col, err := buildNumericFieldExpr(field)
+	if err != nil {
+		return "", fmt.Errorf("invalid bucket field: %s", field)
 	}
+	w, err := strconv.ParseFloat(width, 64)
+	if err != nil || w <= 0 {
+		return "", fmt.Errorf("invalid bucket width: %s", width)
+	}
+	return fmt.Sprintf("ifNull(toString(floor(%s / %s) * %s), '')", col, width, width), nil
 }
 
 // buildIntervalExpr builds the time bucket expression
 func buildIntervalExpr(interval structs.IntervalType) (string, error) {
+	return bucketExpr(interval, "timestamp")
+}
+
+// bucketExpr buckets a time column by the interval
+func bucketExpr(interval structs.IntervalType, column string) (string, error) {
 	switch interval {
 	case structs.IntervalMinute:
-		return "toStartOfMinute(timestamp)", nil
+		return fmt.Sprintf("toStartOfMinute(%s)", column), nil
 	case structs.IntervalHour:
-		return "toStartOfHour(timestamp)", nil
+		return fmt.Sprintf("toStartOfHour(%s)", column), nil
 	case structs.IntervalDay:
-		return "toStartOfDay(timestamp)", nil
+		return fmt.Sprintf("toStartOfDay(%s)", column), nil
 	case structs.IntervalWeek:
-		return "toStartOfWeek(timestamp)", nil
+		return fmt.Sprintf("toStartOfWeek(%s)", column), nil
 	case structs.IntervalMonth:
-		return "toStartOfMonth(timestamp)", nil
+		return fmt.Sprintf("toStartOfMonth(%s)", column), nil
 	default:
 		return "", fmt.Errorf("unsupported interval: %s", interval)
 	}
 }
 
 // QueryAnalytics executes an analytics query
-func QueryAnalytics(ctx context.Context, query *structs.AnalyticsQuery) (*structs.AnalyticsResult, error) {
+func (s *Service) QueryAnalytics(ctx context.Context, query *structs.AnalyticsQuery) (*structs.AnalyticsResult, error) {
+	colFields, colFilters := columnFields(query.Columns)
+	fields := append(append(append([]string{query.Field}, query.GroupBy...), sortFields(query)...), colFields...)
+	if err := checkSensitiveFields(ctx, fields, append(append([]structs.QueryFilter{}, query.Filters...), colFilters...)); err != nil {
+		return nil, err
+	}
+	// The filled-in range isn't written back, so cursors match the query as sent
+	from, to := query.From, query.To
+	if err := applyTimeRange(RangeAnalytics, &from, &to); err != nil {
+		return nil, err
+	}
+
 	// Build aggregation expression
 	aggExpr, err := buildAggregationExpr(query.Aggregation, query.Field)
 	if err != nil {
 		return nil, err
 	}
 
-	// Build SELECT clause
+	// Build SELECT clause; the columns' conditions come before the WHERE arguments
 	selectParts := []string{fmt.Sprintf("%s AS value", aggExpr)}
+	columnExprs, columnArgs, err := buildColumnExprs(query.Columns)
+	if err != nil {
+		return nil, err
+	}
+	selectParts = append(selectParts, columnExprs...)
 
 	// Build GROUP BY
 	var groupByAliases []string
@@ -308,13 +390,13 @@ func QueryAnalytics(ctx context.Context, query *structs.AnalyticsQuery) (*struct
 	var args []interface{}
 
 	// Time range
-	if !query.From.IsZero() {
+	if !from.IsZero() {
 		whereParts = append(whereParts, "timestamp >= ?")
-		args = append(args, query.From)
+		args = append(args, from)
 	}
-	if !query.To.IsZero() {
+	if !to.IsZero() {
 		whereParts = append(whereParts, "timestamp <= ?")
-		args = append(args, query.To)
+		args = append(args, to)
 	}
 
 	// Filters
@@ -329,8 +411,14 @@ func QueryAnalytics(ctx context.Context, query *structs.AnalyticsQuery) (*struct
 		}
 	}
 
+	// Access role restrictions
+	if clause, clauseArgs := accessClause(ctx); clause != "" {
+		whereParts = append(whereParts, clause)
+		args = append(args, clauseArgs...)
+	}
+
 	// Build query
-	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectParts, ", "), eventsTable())
+	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectParts, ", "), eventsTable(ctx))
 
 	if len(whereParts) > 0 {
 		sql += " WHERE " + strings.Join(whereParts, " AND ")
@@ -341,34 +429,28 @@ func QueryAnalytics(ctx context.Context, query *structs.AnalyticsQuery) (*struct
 	}
 
 	// ORDER BY
-	orderBy := "value"
-	if query.OrderBy != "" {
-		// Check if ordering by a group field
-		for i, g := range query.GroupBy {
-			if g == query.OrderBy {
-				orderBy = groupByAliases[i]
-				break
-			}
-		}
-	}
-	orderDir := "DESC"
-	if !query.OrderDesc {
-		orderDir = "ASC"
+	orderBy, err := buildOrderBy(query, groupByAliases)
+	if err != nil {
+		return nil, err
 	}
-	sql += fmt.Sprintf(" ORDER BY %s %s", orderBy, orderDir)
+	sql += " ORDER BY " + orderBy
 
 	// LIMIT
 	limit := query.Limit
 	if limit <= 0 {
 		limit = 100
 	}
-	if limit > 10000 {
-		limit = 10000
+	if limit > maxAnalyticsLimit {
+		limit = maxAnalyticsLimit
 	}
-	sql += fmt.Sprintf(" LIMIT %d", limit)
+	offset, err := analyticsOffset(query)
+	if err != nil {
+		return nil, err
+	}
+	sql += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
 
 	// Execute query
-	rows, err := db.Conn.Query(ctx, sql, args...)
+	rows, err := s.queryRows(ctx, sql, append(columnArgs, args...)...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -378,11 +460,14 @@ func QueryAnalytics(ctx context.Context, query *structs.AnalyticsQuery) (*struct
 	for rows.Next() {
 		// Build scan destinations
 		var value float64
+		columnValues := make([]float64, len(query.Columns))
 		groupValues := make([]string, len(groupByAliases))
-		scanDest := make([]interface{}, 1+len(groupByAliases))
-		scanDest[0] = &value
-		for i := range groupByAliases {
-			scanDest[i+1] = &groupValues[i]
+		scanDest := []interface{}{&value}
+		for i := range columnValues {
+			scanDest = append(scanDest, &columnValues[i])
+		}
+		for i := range groupValues {
+			scanDest = append(scanDest, &groupValues[i])
 		}
 
 		if err := rows.Scan(scanDest...); err != nil {
@@ -393,6 +478,13 @@ func QueryAnalytics(ctx context.Context, query *structs.AnalyticsQuery) (*struct
 			Value: value,
 		}
 
+		if len(query.Columns) > 0 {
+			row.Columns = make(map[string]float64, len(query.Columns))
+			for i, c := range query.Columns {
+				row.Columns[c.Name] = columnValues[i]
+			}
+		}
+
 		if len(query.GroupBy) > 0 {
 			row.Groups = make(map[string]string)
 			for i, g := range query.GroupBy {
@@ -411,113 +503,180 @@ func QueryAnalytics(ctx context.Context, query *structs.AnalyticsQuery) (*struct
 		data = []structs.AnalyticsRow{}
 	}
 
-	return &structs.AnalyticsResult{
+	result := &structs.AnalyticsResult{
 		Data:  data,
 		Total: len(data),
 		Query: query,
-	}, nil
-}
+	}
+	if len(groupByAliases) == 0 {
+		return result, nil
+	}
 
-// QueryTimeSeries executes a time series query
-func QueryTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery) (*structs.TimeSeriesResult, error) {
-	// Validate time range to prevent excessive data points
-	if !query.From.IsZero() && !query.To.IsZero() {
-		duration := query.To.Sub(query.From)
-		if duration > MaxQueryDuration {
-			return nil, fmt.Errorf("time range too large (max %v)", MaxQueryDuration)
-		}
-		// Estimate number of data points
-		var interval time.Duration
-		switch query.Interval {
-		case structs.IntervalMinute:
-			interval = time.Minute
-		case structs.IntervalHour:
-			interval = time.Hour
-		case structs.IntervalDay:
-			interval = 24 * time.Hour
-		case structs.IntervalWeek:
-			interval = 7 * 24 * time.Hour
-		case structs.IntervalMonth:
-			interval = 30 * 24 * time.Hour
-		default:
-			interval = time.Hour
-		}
-		estimatedPoints := int(duration / interval)
-		if estimatedPoints > MaxTimeSeriesPoints {
-			return nil, fmt.Errorf("query would return too many data points (estimated %d, max %d); use a larger interval or smaller time range", estimatedPoints, MaxTimeSeriesPoints)
+	// Count every group, not just this page's
+	groupExprs := make([]string, len(query.GroupBy))
+	for i, g := range query.GroupBy {
+		if groupExprs[i], err = buildGroupExpr(g); err != nil {
+			return nil, err
 		}
 	}
-
-	// Build aggregation expression
-	aggExpr, err := buildAggregationExpr(query.Aggregation, query.Field)
-	if err != nil {
-		return nil, err
+	countSQL := fmt.Sprintf("SELECT uniqExact(%s) FROM %s", strings.Join(groupExprs, ", "), eventsTable(ctx))
+	if len(whereParts) > 0 {
+		countSQL += " WHERE " + strings.Join(whereParts, " AND ")
 	}
-
-	// Build interval expression
-	intervalExpr, err := buildIntervalExpr(query.Interval)
-	if err != nil {
-		return nil, err
+	if err := s.queryRow(ctx, countSQL, args...).Scan(&result.TotalGroups); err != nil {
+		return nil, fmt.Errorf("failed to count groups: %w", err)
 	}
-
-	// Build SELECT clause
-	selectParts := []string{
-		fmt.Sprintf("%s AS bucket", intervalExpr),
-		fmt.Sprintf("%s AS value", aggExpr),
+	if next := offset + len(data); len(data) == limit && uint64(next) < result.TotalGroups {
+		result.NextCursor = encodeAnalyticsCursor(query, next)
 	}
+	return result, nil
+}
 
-	// Build GROUP BY aliases
-	groupByParts := []string{"bucket"}
-	var groupByAliases []string
+// maxSortKeys is the most keys an analytics query can be ordered by
+const maxSortKeys = 12
+
+// sortAggregationRegex matches aggregation sort keys like count or avg(data.duration_ms)
+var sortAggregationRegex = regexp.MustCompile(`^([a-z0-9_]+)(?:\((.+)\))?$`)
+
+// buildOrderBy builds the ORDER BY of an analytics query from its sort keys, or order_by
+// without them, then the remaining groups ascending, so ties (and pages) are stable
+func buildOrderBy(query *structs.AnalyticsQuery, groupByAliases []string) (string, error) {
+	keys := query.Sort
+	if len(keys) == 0 {
+		by := query.OrderBy
+		// An order_by that isn't a group has always meant value
+		if _, ok := groupAlias(query.GroupBy, groupByAliases, by); !ok {
+			by = "value"
+		}
+		keys = []structs.SortKey{{By: by, Desc: query.OrderDesc}}
+	}
+	if len(keys) > maxSortKeys {
+		return "", fmt.Errorf("too many sort keys (max %d)", maxSortKeys)
+	}
 
-	if len(query.GroupBy) > 0 {
-		groupByExprs, aliases, err := buildGroupByExprs(query.GroupBy)
+	var parts []string
+	used := make(map[string]bool)
+	for _, key := range keys {
+		expr, err := sortExpr(query, groupByAliases, key.By)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
-		selectParts = append(selectParts, groupByExprs...)
-		groupByAliases = aliases
-		groupByParts = append(groupByParts, aliases...)
+		dir := "ASC"
+		if key.Desc {
+			dir = "DESC"
+		}
+		parts = append(parts, expr+" "+dir)
+		used[expr] = true
 	}
+	for _, alias := range groupByAliases {
+		if !used[alias] {
+			parts = append(parts, alias+" ASC")
+		}
+	}
+	return strings.Join(parts, ", "), nil
+}
 
-	// Build WHERE clause
-	var whereParts []string
-	var args []interface{}
-
-	// Time range
-	if !query.From.IsZero() {
-		whereParts = append(whereParts, "timestamp >= ?")
-		args = append(args, query.From)
+// sortExpr resolves a sort key to the selected value, a group or column alias, or an aggregation
+func sortExpr(query *structs.AnalyticsQuery, groupByAliases []string, by string) (string, error) {
+	if by == "" || by == "value" {
+		return "value", nil
 	}
-	if !query.To.IsZero() {
-		whereParts = append(whereParts, "timestamp <= ?")
-		args = append(args, query.To)
+	if alias, ok := groupAlias(query.GroupBy, groupByAliases, by); ok {
+		return alias, nil
+	}
+	for i, c := range query.Columns {
+		if c.Name == by {
+			return fmt.Sprintf("col_%d", i), nil
+		}
+	}
+	if m := sortAggregationRegex.FindStringSubmatch(by); m != nil {
+		if expr, err := buildAggregationExpr(structs.AggregationType(m[1]), m[2]); err == nil {
+			return expr, nil
+		}
 	}
+	return "", fmt.Errorf("invalid sort key: %s (use value, a group_by field, a column, or an aggregation like count or avg(data.duration_ms))", by)
+}
 
-	// Filters
-	if len(query.Filters) > 0 {
-		filterClause, filterArgs, err := buildFilterClause(query.Filters)
-		if err != nil {
-			return nil, err
+// sortFields returns the fields read by aggregation sort keys
+func sortFields(query *structs.AnalyticsQuery) []string {
+	var fields []string
+	for _, key := range query.Sort {
+		if m := sortAggregationRegex.FindStringSubmatch(key.By); m != nil && m[2] != "" {
+			fields = append(fields, m[2])
 		}
-		if filterClause != "" {
-			whereParts = append(whereParts, filterClause)
-			args = append(args, filterArgs...)
+	}
+	return fields
+}
+
+func groupAlias(groupBy, aliases []string, field string) (string, bool) {
+	for i, g := range groupBy {
+		if g == field {
+			return aliases[i], true
 		}
 	}
+	return "", false
+}
 
-	// Build query
-	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectParts, ", "), eventsTable())
+// analyticsCursor is the position of the next page of an analytics query. Fingerprint
+// ties it to the query, so it can't be used to page through a different one.
+type analyticsCursor struct {
+	Offset      int    `json:"o"`
+	Fingerprint uint64 `json:"f"`
+}
 
-	if len(whereParts) > 0 {
-		sql += " WHERE " + strings.Join(whereParts, " AND ")
+// analyticsFingerprint hashes the query without its paging options
+func analyticsFingerprint(query *structs.AnalyticsQuery) uint64 {
+	q := *query
+	q.Offset, q.Cursor, q.Limit = 0, "", 0
+	b, _ := json.Marshal(q)
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+func encodeAnalyticsCursor(query *structs.AnalyticsQuery, offset int) string {
+	b, _ := json.Marshal(analyticsCursor{Offset: offset, Fingerprint: analyticsFingerprint(query)})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// analyticsOffset returns the groups to skip, from the cursor or the offset
+func analyticsOffset(query *structs.AnalyticsQuery) (int, error) {
+	if query.Cursor == "" {
+		if query.Offset < 0 {
+			return 0, fmt.Errorf("invalid offset: %d", query.Offset)
+		}
+		return query.Offset, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	var cursor analyticsCursor
+	if err != nil || json.Unmarshal(b, &cursor) != nil || cursor.Offset < 0 {
+		return 0, fmt.Errorf("invalid cursor")
 	}
+	if cursor.Fingerprint != analyticsFingerprint(query) {
+		return 0, fmt.Errorf("invalid cursor: it belongs to a different query")
+	}
+	return cursor.Offset, nil
+}
 
-	sql += " GROUP BY " + strings.Join(groupByParts, ", ")
-	sql += " ORDER BY bucket ASC"
+// QueryTimeSeries executes a time series query
+func (s *Service) QueryTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery) (*structs.TimeSeriesResult, error) {
+	// Fill in the time range left out, leaving the caller's query as it was
+	q := *query
+	query = &q
+	defaultTimeRange(RangeTimeSeries, &query.From, &query.To)
+
+	sql, args, groupByAliases, plan, err := s.buildTimeSeriesSQL(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var rollupBefore *time.Time
+	if plan.tier != nil {
+		rollupBefore = &plan.boundary
+		noteRollup(ctx, plan.tier.precision)
+	}
 
 	// Execute query
-	rows, err := db.Conn.Query(ctx, sql, args...)
+	rows, err := s.queryRows(ctx, sql, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -532,37 +691,17 @@ func QueryTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery) (*stru
 	var seriesOrder []string
 
 	for rows.Next() {
-		var bucket time.Time
-		var value float64
-		groupValues := make([]string, len(groupByAliases))
-
-		scanDest := make([]interface{}, 2+len(groupByAliases))
-		scanDest[0] = &bucket
-		scanDest[1] = &value
-		for i := range groupByAliases {
-			scanDest[i+2] = &groupValues[i]
-		}
-
-		if err := rows.Scan(scanDest...); err != nil {
-			return nil, fmt.Errorf("scan failed: %w", err)
-		}
-
-		// Build series key
-		seriesKey := ""
-		var groups map[string]string
-		if len(query.GroupBy) > 0 {
-			groups = make(map[string]string)
-			keyParts := make([]string, len(query.GroupBy))
-			for i, g := range query.GroupBy {
-				groups[g] = groupValues[i]
-				keyParts[i] = groupValues[i]
-			}
-			seriesKey = strings.Join(keyParts, "|")
+		seriesKey, groups, point, err := scanTimeSeriesPoint(rows.Scan, query, len(groupByAliases))
+		if err != nil {
+			return nil, err
 		}
 
-		// Get or create series
+		// Get or create series; rows of series past the cap are dropped
 		sd, exists := seriesMap[seriesKey]
 		if !exists {
+			if !allowSeries(ctx, len(seriesOrder)) {
+				continue
+			}
 			sd = &seriesData{
 				groups:     groups,
 				dataPoints: []structs.DataPoint{},
@@ -571,16 +710,50 @@ func QueryTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery) (*stru
 			seriesOrder = append(seriesOrder, seriesKey)
 		}
 
-		sd.dataPoints = append(sd.dataPoints, structs.DataPoint{
-			Timestamp: bucket,
-			Value:     value,
-		})
+		sd.dataPoints = append(sd.dataPoints, point)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("row iteration failed: %w", err)
 	}
 
+	// Groups asked for by include_groups get a series even without data
+	for _, groups := range query.IncludeGroups {
+		keyParts := make([]string, len(query.GroupBy))
+		for i, g := range query.GroupBy {
+			keyParts[i] = groups[g]
+		}
+		key := strings.Join(keyParts, "|")
+		if _, exists := seriesMap[key]; !exists {
+			seriesMap[key] = &seriesData{groups: groups, dataPoints: []structs.DataPoint{}}
+			seriesOrder = append(seriesOrder, key)
+		}
+	}
+
+	// Zero-filled series share one bucket set: the time range, or without one the
+	// buckets between the first and last seen in any series
+	fillFrom, fillTo := query.From, query.To
+	if query.FillZeros && (fillFrom.IsZero() || fillTo.IsZero()) {
+		var first, last time.Time
+		for _, sd := range seriesMap {
+			for _, p := range sd.dataPoints {
+				if first.IsZero() || p.Timestamp.Before(first) {
+					first = p.Timestamp
+				}
+				if p.Timestamp.After(last) {
+					last = p.Timestamp
+				}
+			}
+		}
+		if fillFrom.IsZero() {
+			fillFrom = first
+		}
+		if fillTo.IsZero() {
+			fillTo = last
+		}
+	}
+	fill := query.FillZeros && !fillFrom.IsZero() && !fillTo.IsZero()
+
 	// Build result
 	var series []structs.TimeSeries
 	for _, key := range seriesOrder {
@@ -593,17 +766,17 @@ func QueryTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery) (*stru
 		}
 
 		// Fill zeros if requested
-		if query.FillZeros && !query.From.IsZero() && !query.To.IsZero() {
-			ts.DataPoints = fillTimeSeriesZeros(ts.DataPoints, query.From, query.To, query.Interval)
+		if fill {
+			ts.DataPoints = fillTimeSeriesZeros(ts.DataPoints, fillFrom, fillTo, query.Interval)
 		}
 
 		series = append(series, ts)
 	}
 
 	// If no data and fillZeros requested, create empty series
-	if len(series) == 0 && query.FillZeros && !query.From.IsZero() && !query.To.IsZero() {
+	if len(series) == 0 && fill {
 		series = []structs.TimeSeries{{
-			DataPoints: fillTimeSeriesZeros(nil, query.From, query.To, query.Interval),
+			DataPoints: fillTimeSeriesZeros(nil, fillFrom, fillTo, query.Interval),
 		}}
 	}
 
@@ -611,30 +784,482 @@ func QueryTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery) (*stru
 		series = []structs.TimeSeries{}
 	}
 
+	// Same query over the earlier window, moved forward onto the current buckets
+	if query.CompareOffset != "" {
+		if query.From.IsZero() || query.To.IsZero() {
+			return nil, fmt.Errorf("from and to are required with compare_offset")
+		}
+		offset, err := parseOffset("compare_offset", query.CompareOffset)
+		if err != nil {
+			return nil, err
+		}
+
+		shifted := *query
+		shifted.From = query.From.Add(-offset)
+		shifted.To = query.To.Add(-offset)
+		shifted.CompareOffset = ""
+		previous, err := s.QueryTimeSeries(ctx, &shifted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query offset series: %w", err)
+		}
+		for _, ts := range previous.Series {
+			for i := range ts.DataPoints {
+				ts.DataPoints[i].Timestamp = ts.DataPoints[i].Timestamp.Add(offset)
+			}
+			ts.Offset = query.CompareOffset
+			series = append(series, ts)
+		}
+	}
+
 	return &structs.TimeSeriesResult{
-		Series: series,
-		Query:  query,
+		Series:       series,
+		Query:        query,
+		Precision:    plan.precision(),
+		RollupBefore: rollupBefore,
 	}, nil
 }
 
-// fillTimeSeriesZeros fills in missing time buckets with zero values
-func fillTimeSeriesZeros(points []structs.DataPoint, from, to time.Time, interval structs.IntervalType) []structs.DataPoint {
-	// Create a map of existing points
-	existing := make(map[int64]float64)
-	for _, p := range points {
-		existing[p.Timestamp.Unix()] = p.Value
+// StreamTimeSeries executes a time series query, calling emit with each series as soon as
+// its last point is read instead of collecting the whole result. Series come ordered by
+// their groups, then those only asked for by include_groups, then the compare_offset
+// series. Zero filling can't wait for the buckets of every series, so fill_zeros needs
+// from and to.
+func (s *Service) StreamTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery, emit func(structs.TimeSeries) error) error {
+	// Fill in the time range left out, leaving the caller's query as it was
+	q := *query
+	query = &q
+	defaultTimeRange(RangeTimeSeries, &query.From, &query.To)
+
+	if query.FillZeros && (query.From.IsZero() || query.To.IsZero()) {
+		return fmt.Errorf("from and to are required with fill_zeros when streaming")
+	}
+	if query.CompareOffset != "" && (query.From.IsZero() || query.To.IsZero()) {
+		return fmt.Errorf("from and to are required with compare_offset")
 	}
 
-	// Generate all expected buckets
-	var result []structs.DataPoint
-	current := truncateTime(from, interval)
-	end := to
+	sql, args, groupByAliases, plan, err := s.buildTimeSeriesSQL(ctx, query)
+	if err != nil {
+		return err
+	}
+	if plan.tier != nil {
+		noteRollup(ctx, plan.tier.precision)
+	}
+	if len(groupByAliases) > 0 {
+		// Each series' rows arrive together, so a series is complete when the next starts
+		sql = fmt.Sprintf("SELECT * FROM (%s) ORDER BY %s, bucket ASC", sql, strings.Join(groupByAliases, ", "))
+	}
 
-	for !current.After(end) {
-		value := float64(0)
-		if v, ok := existing[current.Unix()]; ok {
-			value = v
-		}
+	rows, err := s.queryRows(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	var current *structs.TimeSeries
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		ts := *current
+		current = nil
+		if query.FillZeros {
+			ts.DataPoints = fillTimeSeriesZeros(ts.DataPoints, query.From, query.To, query.Interval)
+		}
+		return emit(ts)
+	}
+
+	for rows.Next() {
+		seriesKey, groups, point, err := scanTimeSeriesPoint(rows.Scan, query, len(groupByAliases))
+		if err != nil {
+			return err
+		}
+		if current == nil || current.Name != seriesKey {
+			if err := flush(); err != nil {
+				return err
+			}
+			if !allowSeries(ctx, len(seen)) {
+				break
+			}
+			current = &structs.TimeSeries{Name: seriesKey, Groups: groups}
+			seen[seriesKey] = true
+		}
+		current.DataPoints = append(current.DataPoints, point)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration failed: %w", err)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	// Groups asked for by include_groups get a series even without data
+	for _, groups := range query.IncludeGroups {
+		keyParts := make([]string, len(query.GroupBy))
+		for i, g := range query.GroupBy {
+			keyParts[i] = groups[g]
+		}
+		key := strings.Join(keyParts, "|")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		current = &structs.TimeSeries{Name: key, Groups: groups, DataPoints: []structs.DataPoint{}}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	// If no data and fillZeros requested, emit an empty series
+	if len(seen) == 0 && query.FillZeros {
+		current = &structs.TimeSeries{}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	// Same query over the earlier window, moved forward onto the current buckets
+	if query.CompareOffset != "" {
+		offset, err := parseOffset("compare_offset", query.CompareOffset)
+		if err != nil {
+			return err
+		}
+
+		shifted := *query
+		shifted.From = query.From.Add(-offset)
+		shifted.To = query.To.Add(-offset)
+		shifted.CompareOffset = ""
+		return s.StreamTimeSeries(ctx, &shifted, func(ts structs.TimeSeries) error {
+			for i := range ts.DataPoints {
+				ts.DataPoints[i].Timestamp = ts.DataPoints[i].Timestamp.Add(offset)
+			}
+			ts.Offset = query.CompareOffset
+			return emit(ts)
+		})
+	}
+	return nil
+}
+
+// buildTimeSeriesSQL builds the query behind a time series, returning its group by
+// aliases and the rollups it reads. Each row is a bucket, its value, then the groups.
+func (s *Service) buildTimeSeriesSQL(ctx context.Context, query *structs.TimeSeriesQuery) (string, []interface{}, []string, *timeSeriesPlan, error) {
+	if err := checkSensitiveFields(ctx, append([]string{query.Field}, query.GroupBy...), query.Filters); err != nil {
+		return "", nil, nil, nil, err
+	}
+	if err := checkIncludeGroups(query); err != nil {
+		return "", nil, nil, nil, err
+	}
+
+	// Validate time range to prevent excessive data points
+	if !query.From.IsZero() && !query.To.IsZero() {
+		duration := query.To.Sub(query.From)
+		if maxDuration := maxTimeSeriesDuration(query); duration > maxDuration {
+			return "", nil, nil, nil, fmt.Errorf("time range too large (max %v)", maxDuration)
+		}
+		// Estimate number of data points
+		estimatedPoints := estimatePoints(query.From, query.To, query.Interval)
+		if estimatedPoints > MaxTimeSeriesPoints {
+			return "", nil, nil, nil, fmt.Errorf("query would return too many data points (estimated %d, max %d); use a larger interval or smaller time range", estimatedPoints, MaxTimeSeriesPoints)
+		}
+	}
+
+	// Rolling windows count each bucket over the trailing window
+	var windowSize int
+	var windowStep time.Duration
+	if query.Window != "" {
+		var err error
+		if windowSize, windowStep, err = windowBuckets(query); err != nil {
+			return "", nil, nil, nil, err
+		}
+	}
+
+	// Build aggregation expression
+	aggExpr, err := buildAggregationExpr(query.Aggregation, query.Field)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+
+	// Build interval expression
+	intervalExpr, err := buildIntervalExpr(query.Interval)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+
+	// Build SELECT clause
+	selectParts := []string{
+		fmt.Sprintf("%s AS bucket", intervalExpr),
+		fmt.Sprintf("%s AS value", aggExpr),
+	}
+
+	// Build GROUP BY aliases
+	groupByParts := []string{"bucket"}
+	var groupByAliases []string
+
+	if len(query.GroupBy) > 0 {
+		groupByExprs, aliases, err := buildGroupByExprs(query.GroupBy)
+		if err != nil {
+			return "", nil, nil, nil, err
+		}
+		selectParts = append(selectParts, groupByExprs...)
+		groupByAliases = aliases
+		groupByParts = append(groupByParts, aliases...)
+	}
+
+	// Build WHERE clause
+	var whereParts []string
+	var args []interface{}
+
+	// Time range
+	if !query.From.IsZero() {
+		from := query.From
+		if windowSize > 0 {
+			// The first bucket's window starts up to windowSize buckets earlier
+			from = from.Add(-time.Duration(windowSize) * windowStep)
+		}
+		whereParts = append(whereParts, "timestamp >= ?")
+		args = append(args, from)
+	}
+	if !query.To.IsZero() {
+		whereParts = append(whereParts, "timestamp <= ?")
+		args = append(args, query.To)
+	}
+
+	// Filters
+	if len(query.Filters) > 0 {
+		filterClause, filterArgs, err := buildFilterClause(query.Filters)
+		if err != nil {
+			return "", nil, nil, nil, err
+		}
+		if filterClause != "" {
+			whereParts = append(whereParts, filterClause)
+			args = append(args, filterArgs...)
+		}
+	}
+
+	// Spans only
+	if query.Spans {
+		whereParts = append(whereParts, spanCondition)
+	}
+
+	// Access role restrictions
+	if clause, clauseArgs := accessClause(ctx); clause != "" {
+		whereParts = append(whereParts, clause)
+		args = append(args, clauseArgs...)
+	}
+
+	// Build query
+	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectParts, ", "), eventsTable(ctx))
+
+	if len(whereParts) > 0 {
+		sql += " WHERE " + strings.Join(whereParts, " AND ")
+	}
+
+	sql += " GROUP BY " + strings.Join(groupByParts, ", ")
+	sql += " ORDER BY bucket ASC"
+
+	if windowSize > 0 {
+		sql, args = buildRollingUniqSQL(ctx, query, windowSize, windowStep, intervalExpr, selectParts[2:], groupByAliases, whereParts, args)
+	}
+
+	// Rolled-up steps are read from rollups when the series can use them
+	plan, err := s.planTimeSeries(ctx, query)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	if plan.tier != nil {
+		if sql, args, err = buildStitchedSQL(ctx, query, plan); err != nil {
+			return "", nil, nil, nil, err
+		}
+	}
+
+	return sql, args, groupByAliases, plan, nil
+}
+
+// scanTimeSeriesPoint scans a row of buildTimeSeriesSQL into its series key and groups
+// and the data point
+func scanTimeSeriesPoint(scan func(dest ...interface{}) error, query *structs.TimeSeriesQuery, groupCount int) (string, map[string]string, structs.DataPoint, error) {
+	var point structs.DataPoint
+	groupValues := make([]string, groupCount)
+
+	scanDest := make([]interface{}, 2+groupCount)
+	scanDest[0] = &point.Timestamp
+	scanDest[1] = &point.Value
+	for i := range groupValues {
+		scanDest[i+2] = &groupValues[i]
+	}
+
+	if err := scan(scanDest...); err != nil {
+		return "", nil, point, fmt.Errorf("scan failed: %w", err)
+	}
+
+	// Build series key
+	if len(query.GroupBy) == 0 {
+		return "", nil, point, nil
+	}
+	groups := make(map[string]string)
+	keyParts := make([]string, len(query.GroupBy))
+	for i, g := range query.GroupBy {
+		groups[g] = groupValues[i]
+		keyParts[i] = groupValues[i]
+	}
+	return strings.Join(keyParts, "|"), groups, point, nil
+}
+
+// maxIncludeGroups is the most series a time series query can ask for by include_groups
+const maxIncludeGroups = 100
+
+// checkIncludeGroups checks each include_groups entry has a value for every group_by field
+// and nothing else
+func checkIncludeGroups(query *structs.TimeSeriesQuery) error {
+	if len(query.IncludeGroups) == 0 {
+		return nil
+	}
+	if len(query.GroupBy) == 0 {
+		return fmt.Errorf("group_by is required with include_groups")
+	}
+	if len(query.IncludeGroups) > maxIncludeGroups {
+		return fmt.Errorf("too many include_groups (max %d)", maxIncludeGroups)
+	}
+	for i, groups := range query.IncludeGroups {
+		if len(groups) != len(query.GroupBy) {
+			return fmt.Errorf("invalid include_groups[%d]: needs a value for each group_by field and no others", i)
+		}
+		for _, g := range query.GroupBy {
+			if _, ok := groups[g]; !ok {
+				return fmt.Errorf("invalid include_groups[%d]: missing %s", i, g)
+			}
+		}
+	}
+	return nil
+}
+
+// estimatePoints estimates the buckets of each series between from and to
+func estimatePoints(from, to time.Time, interval structs.IntervalType) int {
+	var step time.Duration
+	switch interval {
+	case structs.IntervalMinute:
+		step = time.Minute
+	case structs.IntervalHour:
+		step = time.Hour
+	case structs.IntervalDay:
+		step = 24 * time.Hour
+	case structs.IntervalWeek:
+		step = 7 * 24 * time.Hour
+	case structs.IntervalMonth:
+		step = 30 * 24 * time.Hour
+	default:
+		step = time.Hour
+	}
+	return int(to.Sub(from) / step)
+}
+
+// parseOffset parses the offset option name, like 30m, 12h, 7d, or 4w
+func parseOffset(name, s string) (time.Duration, error) {
+	units := map[byte]time.Duration{'m': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid %s: %s", name, s)
+	}
+	unit, ok := units[s[len(s)-1]]
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s (use e.g. 30m, 12h, 7d, 4w)", name, s)
+	}
+	offset := time.Duration(n) * unit
+	if offset > MaxQueryDuration {
+		return 0, fmt.Errorf("invalid %s: %s (max %v)", name, s, MaxQueryDuration)
+	}
+	return offset, nil
+}
+
+// buildRollingUniqSQL builds a rolling count_unique: uniqState per bucket, with each bucket's
+// state fanned out to the windowSize buckets it belongs to and merged there with uniqMerge
+func buildRollingUniqSQL(ctx context.Context, query *structs.TimeSeriesQuery, windowSize int, windowStep time.Duration, intervalExpr string, groupByExprs, groupByAliases, whereParts []string, args []interface{}) (string, []interface{}) {
+	col, _ := buildFieldExpr(query.Field) // validated by buildAggregationExpr
+
+	inner := append([]string{
+		fmt.Sprintf("%s AS window_bucket", intervalExpr),
+		fmt.Sprintf("uniqState(%s) AS state", col),
+	}, groupByExprs...)
+	innerGroupBy := append([]string{"window_bucket"}, groupByAliases...)
+
+	outer := append([]string{"target AS bucket", "toFloat64(uniqMerge(state)) AS value"}, groupByAliases...)
+	outerGroupBy := append([]string{"target"}, groupByAliases...)
+
+	sql := fmt.Sprintf(`SELECT %s FROM (SELECT %s FROM %s WHERE %s GROUP BY %s)
+		ARRAY JOIN arrayMap(i -> %s(window_bucket, i), range(%d)) AS target
+		WHERE target > ? AND target <= ?
+		GROUP BY %s ORDER BY target ASC`,
+		strings.Join(outer, ", "),
+		strings.Join(inner, ", "), eventsTable(ctx), strings.Join(whereParts, " AND "), strings.Join(innerGroupBy, ", "),
+		windowAddFunctions[query.Interval], windowSize,
+		strings.Join(outerGroupBy, ", "),
+	)
+	// Keep the buckets overlapping [from, to]
+	return sql, append(args, query.From.Add(-windowStep), query.To)
+}
+
+// windowAddFunctions move a bucket forward by whole intervals
+var windowAddFunctions = map[structs.IntervalType]string{
+	structs.IntervalMinute: "addMinutes",
+	structs.IntervalHour:   "addHours",
+	structs.IntervalDay:    "addDays",
+	structs.IntervalWeek:   "addWeeks",
+}
+
+// windowBuckets returns how many buckets of interval the rolling window spans, and the bucket size
+func windowBuckets(query *structs.TimeSeriesQuery) (int, time.Duration, error) {
+	if query.Aggregation != structs.AggCountUnique {
+		return 0, 0, fmt.Errorf("invalid window: only supported with count_unique")
+	}
+	if query.From.IsZero() || query.To.IsZero() {
+		return 0, 0, fmt.Errorf("from and to are required with window")
+	}
+	window, err := parseOffset("window", query.Window)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var step time.Duration
+	switch query.Interval {
+	case structs.IntervalMinute:
+		step = time.Minute
+	case structs.IntervalHour:
+		step = time.Hour
+	case structs.IntervalDay:
+		step = 24 * time.Hour
+	case structs.IntervalWeek:
+		step = 7 * 24 * time.Hour
+	default:
+		return 0, 0, fmt.Errorf("invalid window: not supported with %s interval", query.Interval)
+	}
+	if window%step != 0 {
+		return 0, 0, fmt.Errorf("invalid window: %s is not a multiple of the %s interval", query.Window, query.Interval)
+	}
+	buckets := int(window / step)
+	if buckets > MaxWindowBuckets {
+		return 0, 0, fmt.Errorf("invalid window: spans %d buckets (max %d); use a larger interval", buckets, MaxWindowBuckets)
+	}
+	return buckets, step, nil
+}
+
+// fillTimeSeriesZeros fills in missing time buckets with zero values
+func fillTimeSeriesZeros(points []structs.DataPoint, from, to time.Time, interval structs.IntervalType) []structs.DataPoint {
+	// Create a map of existing points
+	existing := make(map[int64]float64)
+	for _, p := range points {
+		existing[p.Timestamp.Unix()] = p.Value
+	}
+
+	// Generate all expected buckets
+	var result []structs.DataPoint
+	current := truncateTime(from, interval)
+	end := to
+
+	for !current.After(end) {
+		value := float64(0)
+		if v, ok := existing[current.Unix()]; ok {
+			value = v
+		}
 		result = append(result, structs.DataPoint{
 			Timestamp: current,
 			Value:     value,
@@ -668,6 +1293,22 @@ func truncateTime(t time.Time, interval structs.IntervalType) time.Time {
 	}
 }
 
+// rewindTime moves time back by one interval
+func rewindTime(t time.Time, interval structs.IntervalType) time.Time {
+	switch interval {
+	case structs.IntervalMinute:
+		return t.Add(-time.Minute)
+	case structs.IntervalDay:
+		return t.AddDate(0, 0, -1)
+	case structs.IntervalWeek:
+		return t.AddDate(0, 0, -7)
+	case structs.IntervalMonth:
+		return t.AddDate(0, -1, 0)
+	default:
+		return t.Add(-time.Hour)
+	}
+}
+
 // advanceTime advances time by one interval
 func advanceTime(t time.Time, interval structs.IntervalType) time.Time {
 	switch interval {
@@ -687,7 +1328,18 @@ func advanceTime(t time.Time, interval structs.IntervalType) time.Time {
 }
 
 // QueryTopN executes a top N query
-func QueryTopN(ctx context.Context, query *structs.TopNQuery) (*structs.TopNResult, error) {
+func (s *Service) QueryTopN(ctx context.Context, query *structs.TopNQuery) (*structs.TopNResult, error) {
+	if err := checkSensitiveFields(ctx, []string{query.Field, query.GroupBy}, query.Filters); err != nil {
+		return nil, err
+	}
+
+	// Fill in the time range left out, leaving the caller's query as it was
+	q := *query
+	query = &q
+	if err := applyTimeRange(RangeTopN, &query.From, &query.To); err != nil {
+		return nil, err
+	}
+
 	// Build aggregation expression
 	aggExpr, err := buildAggregationExpr(query.Aggregation, query.Field)
 	if err != nil {
@@ -695,17 +1347,9 @@ func QueryTopN(ctx context.Context, query *structs.TopNQuery) (*structs.TopNResu
 	}
 
 	// Build group by expression
-	var groupExpr string
-	if strings.HasPrefix(query.GroupBy, "data.") {
-		key := strings.TrimPrefix(query.GroupBy, "data.")
-		if !safeIdentifierRegex.MatchString(key) {
-			return nil, fmt.Errorf("invalid data field name: %s", key)
-		}
-		groupExpr = fmt.Sprintf("JSONExtractString(data, '%s')", key)
-	} else if validGroupByColumns[query.GroupBy] {
-		groupExpr = query.GroupBy
-	} else {
-		return nil, fmt.Errorf("invalid group by field: %s", query.GroupBy)
+	groupExpr, err := buildGroupExpr(query.GroupBy)
+	if err != nil {
+		return nil, err
 	}
 
 	// Build WHERE clause
@@ -734,10 +1378,16 @@ func QueryTopN(ctx context.Context, query *structs.TopNQuery) (*structs.TopNResu
 		}
 	}
 
+	// Access role restrictions
+	if clause, clauseArgs := accessClause(ctx); clause != "" {
+		whereParts = append(whereParts, clause)
+		args = append(args, clauseArgs...)
+	}
+
 	// Build query
 	sql := fmt.Sprintf(
 		"SELECT %s AS key, %s AS value FROM %s",
-		groupExpr, aggExpr, eventsTable(),
+		groupExpr, aggExpr, eventsTable(ctx),
 	)
 
 	if len(whereParts) > 0 {
@@ -757,7 +1407,7 @@ func QueryTopN(ctx context.Context, query *structs.TopNQuery) (*structs.TopNResu
 	sql += fmt.Sprintf(" LIMIT %d", limit)
 
 	// Execute query
-	rows, err := db.Conn.Query(ctx, sql, args...)
+	rows, err := s.queryRows(ctx, sql, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -787,7 +1437,26 @@ func QueryTopN(ctx context.Context, query *structs.TopNQuery) (*structs.TopNResu
 }
 
 // QueryGauge executes a gauge query (single value)
-func QueryGauge(ctx context.Context, query *structs.GaugeQuery) (*structs.GaugeResult, error) {
+func (s *Service) QueryGauge(ctx context.Context, query *structs.GaugeQuery) (*structs.GaugeResult, error) {
+	if err := checkSensitiveFields(ctx, []string{query.Field}, query.Filters); err != nil {
+		return nil, err
+	}
+
+	// Fill in the time range left out, leaving the caller's query as it was
+	q := *query
+	query = &q
+	if err := applyTimeRange(RangeGauge, &query.From, &query.To); err != nil {
+		return nil, err
+	}
+	if query.Sparkline < 0 || query.Sparkline > MaxSparklinePoints {
+		return nil, fmt.Errorf("invalid sparkline: %d points (max %d)", query.Sparkline, MaxSparklinePoints)
+	}
+	if query.SparklineInterval != "" {
+		if _, err := buildIntervalExpr(query.SparklineInterval); err != nil {
+			return nil, fmt.Errorf("invalid sparkline_interval: %s", query.SparklineInterval)
+		}
+	}
+
 	// Build aggregation expression
 	aggExpr, err := buildAggregationExpr(query.Aggregation, query.Field)
 	if err != nil {
@@ -820,8 +1489,14 @@ func QueryGauge(ctx context.Context, query *structs.GaugeQuery) (*structs.GaugeR
 		}
 	}
 
+	// Access role restrictions
+	if clause, clauseArgs := accessClause(ctx); clause != "" {
+		whereParts = append(whereParts, clause)
+		args = append(args, clauseArgs...)
+	}
+
 	// Build query
-	sql := fmt.Sprintf("SELECT %s AS value FROM %s", aggExpr, eventsTable())
+	sql := fmt.Sprintf("SELECT %s AS value FROM %s", aggExpr, eventsTable(ctx))
 
 	if len(whereParts) > 0 {
 		sql += " WHERE " + strings.Join(whereParts, " AND ")
@@ -829,18 +1504,137 @@ func QueryGauge(ctx context.Context, query *structs.GaugeQuery) (*structs.GaugeR
 
 	// Execute query
 	var value float64
-	if err := db.Conn.QueryRow(ctx, sql, args...).Scan(&value); err != nil {
+	if err := s.queryRow(ctx, sql, args...).Scan(&value); err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 
-	return &structs.GaugeResult{
+	result := &structs.GaugeResult{
+		Name:  query.Name,
 		Value: value,
 		Query: query,
-	}, nil
+	}
+	if query.Sparkline > 0 {
+		if result.Sparkline, err = s.gaugeSparkline(ctx, query); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// gaugeSparkline returns the gauge's last Sparkline buckets, ending at To (or now)
+func (s *Service) gaugeSparkline(ctx context.Context, query *structs.GaugeQuery) ([]structs.DataPoint, error) {
+	interval := query.SparklineInterval
+	if interval == "" {
+		interval = structs.IntervalHour
+	}
+
+	end := query.To
+	if end.IsZero() {
+		end = time.Now()
+	}
+	end = end.UTC()
+	start := truncateTime(end, interval)
+	for i := 1; i < query.Sparkline; i++ {
+		start = rewindTime(start, interval)
+	}
+
+	ts, err := s.QueryTimeSeries(ctx, &structs.TimeSeriesQuery{
+		Aggregation: query.Aggregation,
+		Field:       query.Field,
+		Interval:    interval,
+		Filters:     query.Filters,
+		From:        start,
+		To:          end,
+		FillZeros:   true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sparkline: %w", err)
+	}
+	if len(ts.Series) == 0 {
+		return []structs.DataPoint{}, nil
+	}
+	return ts.Series[0].DataPoints, nil
+}
+
+// gaugeBatchQueries resolves the gauges of a batch, applying the shared settings and naming
+// unnamed gauges by position
+func gaugeBatchQueries(batch *structs.GaugeBatchQuery) ([]*structs.GaugeQuery, error) {
+	names := make(map[string]bool)
+	queries := make([]*structs.GaugeQuery, len(batch.Gauges))
+	for i, g := range batch.Gauges {
+		q := g
+		if q.Name == "" {
+			q.Name = fmt.Sprintf("gauge_%d", i)
+		}
+		if names[q.Name] {
+			return nil, fmt.Errorf("invalid gauges: duplicate name %s", q.Name)
+		}
+		names[q.Name] = true
+		if q.Aggregation == "" {
+			q.Aggregation = structs.AggCount
+		}
+		if q.From.IsZero() {
+			q.From = batch.From
+		}
+		if q.To.IsZero() {
+			q.To = batch.To
+		}
+		if q.Sparkline == 0 {
+			q.Sparkline = batch.Sparkline
+		}
+		if q.SparklineInterval == "" {
+			q.SparklineInterval = batch.SparklineInterval
+		}
+		q.Filters = append(append([]structs.QueryFilter{}, batch.Filters...), g.Filters...)
+		queries[i] = &q
+	}
+	return queries, nil
+}
+
+// QueryGauges runs a batch of gauges concurrently, returning their results in order
+func (s *Service) QueryGauges(ctx context.Context, batch *structs.GaugeBatchQuery) (*structs.GaugeBatchResult, error) {
+	if len(batch.Gauges) == 0 {
+		return nil, fmt.Errorf("gauges are required")
+	}
+	if len(batch.Gauges) > MaxBatchGauges {
+		return nil, fmt.Errorf("invalid gauges: too many (max %d)", MaxBatchGauges)
+	}
+
+	queries, err := gaugeBatchQueries(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]structs.GaugeResult, len(queries))
+	errs := make([]error, len(queries))
+	sem := make(chan struct{}, batchGaugeConcurrency)
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result, err := s.QueryGauge(ctx, q)
+			if err != nil {
+				errs[i] = fmt.Errorf("gauge %s: %w", q.Name, err)
+				return
+			}
+			results[i] = *result
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &structs.GaugeBatchResult{Gauges: results}, nil
 }
 
 // QueryCompare executes a comparison query between two time periods
-func QueryCompare(ctx context.Context, query *structs.CompareQuery) (*structs.CompareResult, error) {
+func (s *Service) QueryCompare(ctx context.Context, query *structs.CompareQuery) (*structs.CompareResult, error) {
 	// Calculate previous period if not specified
 	compareFrom := query.CompareFrom
 	compareTo := query.CompareTo
@@ -859,7 +1653,7 @@ func QueryCompare(ctx context.Context, query *structs.CompareQuery) (*structs.Co
 		From:        query.From,
 		To:          query.To,
 	}
-	currentResult, err := QueryGauge(ctx, currentQuery)
+	currentResult, err := s.QueryGauge(ctx, currentQuery)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query current period: %w", err)
 	}
@@ -872,7 +1666,7 @@ func QueryCompare(ctx context.Context, query *structs.CompareQuery) (*structs.Co
 		From:        compareFrom,
 		To:          compareTo,
 	}
-	previousResult, err := QueryGauge(ctx, previousQuery)
+	previousResult, err := s.QueryGauge(ctx, previousQuery)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query previous period: %w", err)
 	}
@@ -892,3 +1686,316 @@ func QueryCompare(ctx context.Context, query *structs.CompareQuery) (*structs.Co
 		Query:         query,
 	}, nil
 }
+
+// QueryFilterCompare runs the same aggregation over two filter sets, returning both totals and,
+// when an interval is set, zero-filled series aligned on the same buckets
+func (s *Service) QueryFilterCompare(ctx context.Context, query *structs.FilterCompareQuery) (*structs.FilterCompareResult, error) {
+	if len(query.A.Filters) == 0 || len(query.B.Filters) == 0 {
+		return nil, fmt.Errorf("filters are required for both a and b")
+	}
+	nameA, nameB := query.A.Name, query.B.Name
+	if nameA == "" {
+		nameA = "a"
+	}
+	if nameB == "" {
+		nameB = "b"
+	}
+
+	sides := []structs.FilterSet{query.A, query.B}
+	totals := make([]float64, 2)
+	for i, side := range sides {
+		gauge, err := s.QueryGauge(ctx, &structs.GaugeQuery{
+			Aggregation: query.Aggregation,
+			Field:       query.Field,
+			Filters:     append(append([]structs.QueryFilter{}, query.Filters...), side.Filters...),
+			From:        query.From,
+			To:          query.To,
+		})
+		if err != nil {
+			return nil, err
+		}
+		totals[i] = gauge.Value
+	}
+
+	result := &structs.FilterCompareResult{
+		A:          totals[0],
+		B:          totals[1],
+		Difference: totals[1] - totals[0],
+		Query:      query,
+	}
+	if totals[0] != 0 {
+		ratio := totals[1] / totals[0]
+		result.Ratio = &ratio
+	}
+
+	if query.Interval == "" {
+		return result, nil
+	}
+
+	// Values of each side by bucket; fill_zeros gives both sides every bucket
+	values := make([]map[int64]float64, 2)
+	var buckets []time.Time
+	seen := make(map[int64]bool)
+	for i, side := range sides {
+		ts, err := s.QueryTimeSeries(ctx, &structs.TimeSeriesQuery{
+			Aggregation: query.Aggregation,
+			Field:       query.Field,
+			Interval:    query.Interval,
+			Filters:     append(append([]structs.QueryFilter{}, query.Filters...), side.Filters...),
+			From:        query.From,
+			To:          query.To,
+			FillZeros:   true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		values[i] = make(map[int64]float64)
+		for _, s := range ts.Series {
+			for _, p := range s.DataPoints {
+				key := p.Timestamp.Unix()
+				values[i][key] = p.Value
+				if !seen[key] {
+					seen[key] = true
+					buckets = append(buckets, p.Timestamp)
+				}
+			}
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+
+	a := structs.TimeSeries{Name: nameA, DataPoints: make([]structs.DataPoint, len(buckets))}
+	b := structs.TimeSeries{Name: nameB, DataPoints: make([]structs.DataPoint, len(buckets))}
+	diff := structs.TimeSeries{Name: "difference", DataPoints: make([]structs.DataPoint, len(buckets))}
+	ratio := structs.TimeSeries{Name: "ratio", DataPoints: make([]structs.DataPoint, len(buckets))}
+	for i, bucket := range buckets {
+		va, vb := values[0][bucket.Unix()], values[1][bucket.Unix()]
+		a.DataPoints[i] = structs.DataPoint{Timestamp: bucket, Value: va}
+		b.DataPoints[i] = structs.DataPoint{Timestamp: bucket, Value: vb}
+		diff.DataPoints[i] = structs.DataPoint{Timestamp: bucket, Value: vb - va}
+		ratio.DataPoints[i] = structs.DataPoint{Timestamp: bucket}
+		if va != 0 {
+			ratio.DataPoints[i].Value = vb / va
+		}
+	}
+	result.Series = []structs.TimeSeries{a, b, diff, ratio}
+
+	return result, nil
+}
+
+// QueryRatio computes an aggregation over the numerator and denominator filter sets in a
+// single pass with countIf/sumIf, overall and optionally by group or time bucket
+func (s *Service) QueryRatio(ctx context.Context, query *structs.RatioQuery) (*structs.RatioResult, error) {
+	if len(query.Numerator) == 0 {
+		return nil, fmt.Errorf("numerator filters are required")
+	}
+	if len(query.GroupBy) > 0 && query.Interval != "" {
+		return nil, fmt.Errorf("invalid ratio query: use group_by or interval, not both")
+	}
+	filters := append(append(append([]structs.QueryFilter{}, query.Filters...), query.Numerator...), query.Denominator...)
+	if err := checkSensitiveFields(ctx, append([]string{query.Field}, query.GroupBy...), filters); err != nil {
+		return nil, err
+	}
+
+	// Fill in the time range left out, leaving the caller's query as it was
+	q := *query
+	query = &q
+	if err := applyTimeRange(RangeRatio, &query.From, &query.To); err != nil {
+		return nil, err
+	}
+
+	// Each side is its aggregation over the events matching its condition
+	var side func(cond string) string
+	switch query.Aggregation {
+	case "", structs.AggCount:
+		side = func(cond string) string { return fmt.Sprintf("toFloat64(countIf(%s))", cond) }
+	case structs.AggSum:
+		if query.Field == "" {
+			return nil, fmt.Errorf("field is required for sum aggregation")
+		}
+		col, err := buildNumericFieldExpr(query.Field)
+		if err != nil {
+			return nil, err
+		}
+		side = func(cond string) string { return fmt.Sprintf("toFloat64(sumIf(%s, %s))", col, cond) }
+	default:
+		return nil, fmt.Errorf("invalid ratio aggregation: %s (count or sum)", query.Aggregation)
+	}
+
+	numCond, args, err := buildFilterClause(query.Numerator)
+	if err != nil {
+		return nil, err
+	}
+	denCond := "1"
+	if len(query.Denominator) > 0 {
+		clause, denArgs, err := buildFilterClause(query.Denominator)
+		if err != nil {
+			return nil, err
+		}
+		denCond = clause
+		args = append(args, denArgs...)
+	}
+	selectParts := []string{
+		side(numCond) + " AS numerator",
+		side(denCond) + " AS denominator",
+	}
+
+	// Build GROUP BY (a group or the time bucket)
+	var groupByAliases []string
+	if len(query.GroupBy) > 0 {
+		groupByExprs, aliases, err := buildGroupByExprs(query.GroupBy)
+		if err != nil {
+			return nil, err
+		}
+		selectParts = append(selectParts, groupByExprs...)
+		groupByAliases = aliases
+	} else if query.Interval != "" {
+		intervalExpr, err := buildIntervalExpr(query.Interval)
+		if err != nil {
+			return nil, err
+		}
+		if query.From.IsZero() || query.To.IsZero() {
+			return nil, fmt.Errorf("from and to are required with interval")
+		}
+		if points := estimatePoints(query.From, query.To, query.Interval); points > MaxTimeSeriesPoints {
+			return nil, fmt.Errorf("query would return too many data points (estimated %d, max %d); use a larger interval or smaller time range", points, MaxTimeSeriesPoints)
+		}
+		selectParts = append(selectParts, intervalExpr+" AS bucket")
+		groupByAliases = []string{"bucket"}
+	}
+
+	// Build WHERE clause
+	var whereParts []string
+
+	// Time range
+	if !query.From.IsZero() {
+		whereParts = append(whereParts, "timestamp >= ?")
+		args = append(args, query.From)
+	}
+	if !query.To.IsZero() {
+		whereParts = append(whereParts, "timestamp <= ?")
+		args = append(args, query.To)
+	}
+
+	// Filters
+	if len(query.Filters) > 0 {
+		filterClause, filterArgs, err := buildFilterClause(query.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if filterClause != "" {
+			whereParts = append(whereParts, filterClause)
+			args = append(args, filterArgs...)
+		}
+	}
+
+	// Access role restrictions
+	if clause, clauseArgs := accessClause(ctx); clause != "" {
+		whereParts = append(whereParts, clause)
+		args = append(args, clauseArgs...)
+	}
+
+	// Build query
+	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectParts, ", "), eventsTable(ctx))
+
+	if len(whereParts) > 0 {
+		sql += " WHERE " + strings.Join(whereParts, " AND ")
+	}
+
+	result := &structs.RatioResult{Query: query}
+
+	// Overall only
+	if len(groupByAliases) == 0 {
+		if err := s.queryRow(ctx, sql, args...).Scan(&result.Numerator, &result.Denominator); err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+		result.Ratio = ratioOf(result.Numerator, result.Denominator)
+		return result, nil
+	}
+
+	// Groups come largest denominator first; the totals row gives the overall ratio
+	// across all groups, not just those within the limit
+	sql += " GROUP BY " + strings.Join(groupByAliases, ", ")
+	if query.Interval != "" {
+		sql += " ORDER BY bucket"
+	} else {
+		limit := query.Limit
+		if limit <= 0 {
+			limit = 100
+		}
+		if limit > maxAnalyticsLimit {
+			limit = maxAnalyticsLimit
+		}
+		sql += fmt.Sprintf(" WITH TOTALS ORDER BY denominator DESC, %s LIMIT %d", strings.Join(groupByAliases, ", "), limit)
+	}
+
+	rows, err := s.queryRows(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[int64]structs.RatioValue)
+	groupValues := make([]string, len(groupByAliases))
+	var bucket time.Time
+	for rows.Next() {
+		var v structs.RatioValue
+		scanDest := []interface{}{&v.Numerator, &v.Denominator}
+		if query.Interval != "" {
+			scanDest = append(scanDest, &bucket)
+		} else {
+			for i := range groupValues {
+				scanDest = append(scanDest, &groupValues[i])
+			}
+		}
+		if err := rows.Scan(scanDest...); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		v.Ratio = ratioOf(v.Numerator, v.Denominator)
+
+		if query.Interval != "" {
+			values[bucket.Unix()] = v
+			// Count and sum add up across buckets
+			result.Numerator += v.Numerator
+			result.Denominator += v.Denominator
+			continue
+		}
+		groups := make(map[string]string, len(query.GroupBy))
+		for i, g := range query.GroupBy {
+			groups[g] = groupValues[i]
+		}
+		result.Groups = append(result.Groups, structs.RatioGroup{RatioValue: v, Groups: groups})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+
+	if query.Interval == "" {
+		scanDest := []interface{}{&result.Numerator, &result.Denominator}
+		for i := range groupValues {
+			scanDest = append(scanDest, &groupValues[i])
+		}
+		if err := rows.Totals(scanDest...); err != nil {
+			return nil, fmt.Errorf("totals failed: %w", err)
+		}
+		if result.Groups == nil {
+			result.Groups = []structs.RatioGroup{}
+		}
+	} else {
+		// Every bucket in the range; empty ones have no ratio
+		for current := truncateTime(query.From, query.Interval); !current.After(query.To); current = advanceTime(current, query.Interval) {
+			result.Series = append(result.Series, structs.RatioPoint{RatioValue: values[current.Unix()], Timestamp: current})
+		}
+	}
+	result.Ratio = ratioOf(result.Numerator, result.Denominator)
+
+	return result, nil
+}
+
+// ratioOf divides numerator by denominator, or returns nil when the denominator is 0
+func ratioOf(numerator, denominator float64) *float64 {
+	if denominator == 0 {
+		return nil
+	}
+	ratio := numerator / denominator
+	return &ratio
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// backfillMaxFutureSkew is how far ahead of now a backfilled timestamp may be
+const backfillMaxFutureSkew = 5 * time.Minute
+
+// ErrBackfillWrite is returned when storage fails a backfill's insert, as opposed to
+// rejecting its events
+var ErrBackfillWrite = errors.New("failed to write partition")
+
+// BackfillResult summarizes a backfill request
+type BackfillResult struct {
+	Accepted int `json:"accepted"`
+	// Expired counts events older than their env's retention, which the table TTL would delete on the next merge
+	Expired int `json:"expired"`
+	// Partitions lists the daily partitions (YYYYMMDD) that received events
+	Partitions []string `json:"partitions"`
+	// RollupsRebuilt is set when the rollups of the hours written into were rebuilt
+	// before responding (rebuild_rollups)
+	RollupsRebuilt bool `json:"rollups_rebuilt,omitempty"`
+	// RollupError is why they couldn't be; they are rebuilt on the next rollup run instead
+	RollupError string `json:"rollup_error,omitempty"`
+}
+
+// Backfiller writes historical events directly to storage, bypassing the live queue
+type Backfiller struct {
+	writer    pipeline.Writer
+	batchSize int
+}
+
+// NewBackfiller creates a new backfiller
+func NewBackfiller(writer pipeline.Writer, batchSize int) *Backfiller {
+	return &Backfiller{
+		writer:    writer,
+		batchSize: batchSize,
+	}
+}
+
+// Write sorts events by timestamp and inserts them one daily partition at a time, so a
+// single insert never spans many partitions. Events are written synchronously; the result
+// is only returned once every partition has been written. When an insert fails, the error
+// wraps ErrBackfillWrite and the result counts the events and partitions written before it.
+func (b *Backfiller) Write(ctx context.Context, events []*structs.Event) (*BackfillResult, error) {
+	now := time.Now().UTC()
+	result := &BackfillResult{Partitions: []string{}}
+
+	accepted := make([]*structs.Event, 0, len(events))
+	for i, event := range events {
+		if err := prepareEvent(event, now, false); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i+1, err)
+		}
+		if event.Timestamp.After(now.Add(backfillMaxFutureSkew)) {
+			return nil, fmt.Errorf("event timestamp %s is in the future", event.Timestamp.Format(time.RFC3339))
+		}
+		if retention := db.Retention(event.Tenant, event.Env); retention > 0 && event.Timestamp.Before(now.Add(-retention)) {
+			result.Expired++
+			continue
+		}
+		accepted = append(accepted, event)
+	}
+
+	// Events may arrive in any order; sorting groups each partition into a contiguous run
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].Timestamp.Before(accepted[j].Timestamp)
+	})
+
+	start := time.Now()
+	for i := 0; i < len(accepted); {
+		partition := backfillPartition(accepted[i].Timestamp)
+		j := i
+		for j < len(accepted) && j-i < b.batchSize && backfillPartition(accepted[j].Timestamp) == partition {
+			j++
+		}
+
+		if err := b.writer.WriteBatch(ctx, accepted[i:j]); err != nil {
+			return result, fmt.Errorf("%w %s: %w", ErrBackfillWrite, partition, err)
+		}
+		markLate(accepted[i:j])
+		result.Accepted += j - i
+		if n := len(result.Partitions); n == 0 || result.Partitions[n-1] != partition {
+			result.Partitions = append(result.Partitions, partition)
+		}
+		i = j
+	}
+
+	EmitInternal("backfill.completed", "info", map[string]interface{}{
+		"accepted":    result.Accepted,
+		"expired":     result.Expired,
+		"partitions":  len(result.Partitions),
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+
+	return result, nil
+}
+
+// backfillPartition returns the partition ID of the events table for a timestamp
+func backfillPartition(t time.Time) string {
+	return t.UTC().Format("20060102")
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// recordingWriter keeps the events written to it
+type recordingWriter struct {
+	events []*structs.Event
+}
+
+func (w *recordingWriter) WriteBatch(ctx context.Context, events []*structs.Event) error {
+	w.events = append(w.events, events...)
+	return nil
+}
+
+// withPipelineRules runs rules on ingested events until the test ends
+func withPipelineRules(t *testing.T, rules ...*PipelineRule) {
+	for _, rule := range rules {
+		if err := checkPipelineRule(rule); err != nil {
+			t.Fatalf("rule %s: %v", rule.Name, err)
+		}
+	}
+	pipelineRulesMu.Lock()
+	previous := pipelineRules
+	pipelineRules = rules
+	pipelineRulesMu.Unlock()
+	t.Cleanup(func() {
+		pipelineRulesMu.Lock()
+		pipelineRules = previous
+		pipelineRulesMu.Unlock()
+	})
+}
+
+func TestBackfillAppliesRedactRules(t *testing.T) {
+	none := 0.0
+	withPipelineRules(t,
+		&PipelineRule{Name: "mask_card", Type: RuleRedact, Keys: []string{"card.number"}, Replacement: "***"},
+		&PipelineRule{Name: "drop_all", Type: RuleSample, Rate: &none},
+	)
+
+	writer := &recordingWriter{}
+	result, err := NewBackfiller(writer, 100).Write(context.Background(), []*structs.Event{{
+		Timestamp: time.Now().UTC().Add(-time.Hour),
+		Service:   "billing",
+		Name:      "charge",
+		Data:      map[string]interface{}{"card": map[string]interface{}{"number": "4242424242424242"}, "amount": 12},
+	}})
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Sample rules only drop live events, so the backfilled one is written
+	if result.Accepted != 1 || len(writer.events) != 1 {
+		t.Fatalf("accepted %d and wrote %d events, want 1", result.Accepted, len(writer.events))
+	}
+	card, _ := writer.events[0].Data["card"].(map[string]interface{})
+	if card["number"] != "***" {
+		t.Fatalf("data.card.number = %v, want it masked", card["number"])
+	}
+	if writer.events[0].Data["amount"] != 12 {
+		t.Fatalf("data.amount = %v, want it kept", writer.events[0].Data["amount"])
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// defaultCardinalityWindow is the window used when the request has no from
+	defaultCardinalityWindow = 24 * time.Hour
+	defaultCardinalityKeys   = 20
+	maxCardinalityKeys       = 100
+	// cardinalityTopServices is how many producers are reported per data key
+	cardinalityTopServices = 5
+)
+
+// LabelCardinality is the number of distinct values of a label column
+type LabelCardinality struct {
+	Label    string `json:"label"`
+	Distinct uint64 `json:"distinct"`
+}
+
+// ServiceCardinality is the number of distinct values one service sends for a data key
+type ServiceCardinality struct {
+	Service  string `json:"service"`
+	Distinct uint64 `json:"distinct"`
+	Events   uint64 `json:"events"`
+}
+
+// DataKeyCardinality is the number of distinct values of a data key and the services sending the most
+type DataKeyCardinality struct {
+	Key      string               `json:"key"`
+	Distinct uint64               `json:"distinct"`
+	Events   uint64               `json:"events"`
+	Services []ServiceCardinality `json:"services"`
+}
+
+// CardinalityReport lists label and data key cardinality over a window
+type CardinalityReport struct {
+	From     time.Time            `json:"from"`
+	To       time.Time            `json:"to"`
+	Labels   []LabelCardinality   `json:"labels"`
+	DataKeys []DataKeyCardinality `json:"data_keys"`
+}
+
+// GetCardinality counts (approximately, with uniq) the distinct values of every label column
+// and the data keys with the most distinct values, broken down by service
+func (s *Service) GetCardinality(ctx context.Context, params QueryParams) (*CardinalityReport, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+	if params.To.IsZero() {
+		params.To = time.Now().UTC()
+	}
+	if params.From.IsZero() {
+		params.From = params.To.Add(-defaultCardinalityWindow)
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultCardinalityKeys
+	}
+	limit = min(limit, maxCardinalityKeys)
+
+	report := &CardinalityReport{From: params.From, To: params.To}
+
+	labels, err := s.labelCardinality(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	report.Labels = labels
+
+	keys, err := s.dataKeyCardinality(ctx, params, limit)
+	if err != nil {
+		return nil, err
+	}
+	report.DataKeys = keys
+
+	return report, nil
+}
+
+func (s *Service) labelCardinality(ctx context.Context, params QueryParams) ([]LabelCardinality, error) {
+	labels := make([]string, 0, len(validLabels))
+	for label := range validLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	columns := make([]string, len(labels))
+	for i, label := range labels {
+		columns[i] = fmt.Sprintf("uniq(%s)", validLabels[label])
+	}
+
+	builder := sq.Select(columns...).
+		From(eventsTable(ctx)).
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	counts := make([]uint64, len(labels))
+	dest := make([]interface{}, len(labels))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+	if err := s.queryRow(ctx, querySQL, queryArgs...).Scan(dest...); err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	result := make([]LabelCardinality, len(labels))
+	for i, label := range labels {
+		result[i] = LabelCardinality{Label: label, Distinct: counts[i]}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Distinct > result[j].Distinct
+	})
+	return result, nil
+}
+
+func (s *Service) dataKeyCardinality(ctx context.Context, params QueryParams, limit int) ([]DataKeyCardinality, error) {
+	builder := sq.Select("key", "uniq(JSONExtractRaw(data, key)) AS distinct_values", "count() AS events").
+		From(eventsTable(ctx)+" ARRAY JOIN JSONExtractKeys(data) AS key").
+		GroupBy("key").
+		OrderBy("distinct_values DESC", "key").
+		Limit(uint64(limit)).
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	keys := []DataKeyCardinality{}
+	index := make(map[string]int)
+	names := make([]string, 0)
+	for rows.Next() {
+		var k DataKeyCardinality
+		if err := rows.Scan(&k.Key, &k.Distinct, &k.Events); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		k.Services = []ServiceCardinality{}
+		index[k.Key] = len(keys)
+		keys = append(keys, k)
+		names = append(names, k.Key)
+	}
+	rows.Close()
+	if len(keys) == 0 {
+		return keys, nil
+	}
+
+	// Which services contribute the distinct values of the top keys
+	builder = sq.Select("key", "service", "uniq(JSONExtractRaw(data, key)) AS distinct_values", "count() AS events").
+		From(eventsTable(ctx)+" ARRAY JOIN JSONExtractKeys(data) AS key").
+		Where(sq.Eq{"key": names}).
+		GroupBy("key", "service").
+		OrderBy("key", "distinct_values DESC").
+		Suffix(fmt.Sprintf("LIMIT %d BY key", cardinalityTopServices)).
+		PlaceholderFormat(sq.Question)
+	builder, err = applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, queryArgs, err = builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err = s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var s ServiceCardinality
+		if err := rows.Scan(&key, &s.Service, &s.Distinct, &s.Events); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		if i, ok := index[key]; ok {
+			keys[i].Services = append(keys[i].Services, s)
+		}
+	}
+
+	return keys, rows.Err()
+}
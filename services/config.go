@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// ConfigBundle is every saved query and alert rule, for managing them as code. Dashboards
+// are built from saved queries, so the saved queries are what a dashboard needs.
+type ConfigBundle struct {
+	SavedQueries []SavedQuery        `json:"saved_queries"`
+	AlertRules   []structs.AlertRule `json:"alert_rules"`
+}
+
+// ConfigChanges are the names of one kind of object an apply created, updated, left
+// unchanged, or deleted
+type ConfigChanges struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	Deleted   []string `json:"deleted"`
+}
+
+// ConfigApplyResult reports what applying a bundle changed, or would change on a dry run
+type ConfigApplyResult struct {
+	DryRun       bool          `json:"dry_run"`
+	SavedQueries ConfigChanges `json:"saved_queries"`
+	AlertRules   ConfigChanges `json:"alert_rules"`
+}
+
+// ExportConfig returns every saved query and alert rule
+func (s *Service) ExportConfig(ctx context.Context) (*ConfigBundle, error) {
+	queries, err := s.ListSavedQueries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.ListAlertRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigBundle{SavedQueries: queries, AlertRules: rules}, nil
+}
+
+// ApplyConfig makes the stored objects match the bundle. Only objects that differ are
+// written, so applying the same bundle again changes nothing. With prune, objects missing
+// from the bundle are deleted; with dryRun, nothing is written. Every object is validated
+// before any is written.
+func (s *Service) ApplyConfig(ctx context.Context, bundle *ConfigBundle, prune, dryRun bool) (*ConfigApplyResult, error) {
+	result := &ConfigApplyResult{
+		DryRun:       dryRun,
+		SavedQueries: newConfigChanges(),
+		AlertRules:   newConfigChanges(),
+	}
+
+	existingQueries, err := s.ListSavedQueries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]interface{}{}
+	for _, q := range existingQueries {
+		current[q.Name] = q
+	}
+	var queryWrites []*SavedQuery
+	seen := map[string]bool{}
+	for i := range bundle.SavedQueries {
+		q := &bundle.SavedQueries[i]
+		if seen[q.Name] {
+			return nil, fmt.Errorf("invalid bundle: duplicate saved query %s", q.Name)
+		}
+		seen[q.Name] = true
+		if err := checkSavedQuery(q); err != nil {
+			return nil, fmt.Errorf("saved query %s: %w", q.Name, err)
+		}
+		write, err := diffConfigObject(&result.SavedQueries, q.Name, current[q.Name], q)
+		if err != nil {
+			return nil, err
+		}
+		if write {
+			queryWrites = append(queryWrites, q)
+		}
+	}
+	queryDeletes := pruneConfigObjects(&result.SavedQueries, current, seen, prune)
+
+	existingRules, err := s.ListAlertRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current = map[string]interface{}{}
+	for _, rule := range existingRules {
+		current[rule.Name] = rule
+	}
+	var ruleWrites []*structs.AlertRule
+	seen = map[string]bool{}
+	for i := range bundle.AlertRules {
+		rule := &bundle.AlertRules[i]
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("invalid bundle: duplicate alert rule %s", rule.Name)
+		}
+		seen[rule.Name] = true
+		if err := checkPutAlertRule(ctx, rule); err != nil {
+			return nil, fmt.Errorf("alert rule %s: %w", rule.Name, err)
+		}
+		write, err := diffConfigObject(&result.AlertRules, rule.Name, current[rule.Name], rule)
+		if err != nil {
+			return nil, err
+		}
+		if write {
+			ruleWrites = append(ruleWrites, rule)
+		}
+	}
+	ruleDeletes := pruneConfigObjects(&result.AlertRules, current, seen, prune)
+
+	if dryRun {
+		return result, nil
+	}
+
+	for _, q := range queryWrites {
+		if err := s.writeSavedQuery(ctx, q); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range queryDeletes {
+		if err := s.DeleteSavedQuery(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range ruleWrites {
+		if err := s.writeAlertRule(ctx, rule); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range ruleDeletes {
+		if err := s.DeleteAlertRule(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func newConfigChanges() ConfigChanges {
+	return ConfigChanges{Created: []string{}, Updated: []string{}, Unchanged: []string{}, Deleted: []string{}}
+}
+
+// diffConfigObject records whether the desired object creates, updates, or matches the
+// stored one (nil when there is none), reporting whether it has to be written
+func diffConfigObject(changes *ConfigChanges, name string, stored, desired interface{}) (bool, error) {
+	if stored == nil {
+		changes.Created = append(changes.Created, name)
+		return true, nil
+	}
+	a, err := canonicalJSON(stored)
+	if err != nil {
+		return false, err
+	}
+	b, err := canonicalJSON(desired)
+	if err != nil {
+		return false, err
+	}
+	if a == b {
+		changes.Unchanged = append(changes.Unchanged, name)
+		return false, nil
+	}
+	changes.Updated = append(changes.Updated, name)
+	return true, nil
+}
+
+// pruneConfigObjects returns the stored objects missing from the bundle when prune is set
+func pruneConfigObjects(changes *ConfigChanges, stored map[string]interface{}, seen map[string]bool, prune bool) []string {
+	if !prune {
+		return nil
+	}
+	for name := range stored {
+		if !seen[name] {
+			changes.Deleted = append(changes.Deleted, name)
+		}
+	}
+	sort.Strings(changes.Deleted)
+	return changes.Deleted
+}
+
+// ETag identifies a version of a saved object for If-Match concurrency control. It is
+// derived from the object's canonical JSON, so it only changes when the object does.
+func ETag(v interface{}) (string, error) {
+	b, err := canonicalJSON(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(b))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`, nil
+}
+
+// canonicalJSON encodes v with sorted keys and no whitespace, so objects compare equal
+// however their JSON was formatted
+func canonicalJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return "", err
+	}
+	b, err = json.Marshal(tree)
+	return string(b), err
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	// maxTraceSpans caps the spans read for one trace
+	maxTraceSpans = 10000
+	// defaultCriticalPathTraces and maxCriticalPathTraces are how many of the most recent
+	// traces the critical path report analyzes
+	defaultCriticalPathTraces = 100
+	maxCriticalPathTraces     = 1000
+	// defaultCriticalPathOperations and maxCriticalPathOperations cap the operations reported
+	defaultCriticalPathOperations = 20
+	maxCriticalPathOperations     = 1000
+)
+
+// CriticalPathSpan is a span on the critical path of a trace
+type CriticalPathSpan struct {
+	SpanID       string    `json:"span_id"`
+	ParentSpanID string    `json:"parent_span_id,omitempty"`
+	Service      string    `json:"service"`
+	Name         string    `json:"name"`
+	Start        time.Time `json:"start"`
+	DurationMs   float64   `json:"duration_ms"`
+	// CriticalMs is the span's own time on the critical path: what it spent while none of
+	// its children on the path were running
+	CriticalMs float64 `json:"critical_ms"`
+}
+
+// CriticalPath is the chain of spans a trace's latency is spent in, in the order they
+// join the path. The critical_ms of its spans add up to the root span's duration.
+type CriticalPath struct {
+	TraceID    string             `json:"trace_id"`
+	DurationMs float64            `json:"duration_ms"`
+	Spans      []CriticalPathSpan `json:"spans"`
+	// Truncated is set when the trace had more spans than were read, by the span limit
+	// or a result cap
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// CriticalPathOperation is how often an operation is on the critical path of the traces
+// analyzed, and how much of their latency it accounts for
+type CriticalPathOperation struct {
+	Service string `json:"service"`
+	Name    string `json:"name"`
+	// Traces is how many traces have the operation on their critical path
+	Traces int `json:"traces"`
+	// CriticalMs is the operation's time on those critical paths, and Share its part of
+	// the duration of every trace analyzed
+	CriticalMs    float64 `json:"critical_ms"`
+	AvgCriticalMs float64 `json:"avg_critical_ms"`
+	Share         float64 `json:"share"`
+}
+
+// CriticalPathReport is the operations most often on the critical path over a time range
+type CriticalPathReport struct {
+	From       time.Time               `json:"from"`
+	To         time.Time               `json:"to"`
+	Traces     int                     `json:"traces"`
+	Operations []CriticalPathOperation `json:"operations"`
+}
+
+// traceSpan is a span of a trace with its children, latest ending first
+type traceSpan struct {
+	CriticalPathSpan
+	end      time.Time
+	children []*traceSpan
+}
+
+// GetCriticalPath computes the critical path of a trace from its spans (events with
+// data.span_id, like those ingested over OTLP), or returns nil when it has none the
+// request can read
+func (s *Service) GetCriticalPath(ctx context.Context, traceID string) (*CriticalPath, error) {
+	traceID = structs.NormalizeID(traceID)
+	if !structs.IsValidID(traceID) {
+		return nil, fmt.Errorf("invalid trace_id: must be a UUID")
+	}
+
+	spans, err := s.loadTraceSpans(ctx, []string{traceID})
+	if err != nil {
+		return nil, err
+	}
+	if len(spans[traceID]) == 0 {
+		return nil, nil
+	}
+	traceSpans, truncated := capTraceSpans(spans[traceID])
+	path := criticalPath(traceSpans)
+	path.TraceID = traceID
+	path.Truncated = truncated || resultCapped(ctx)
+	return path, nil
+}
+
+// GetCriticalPathReport analyzes the critical paths of the most recent traces (up to
+// traces) with spans matching params, and reports the operations most often on them
+func (s *Service) GetCriticalPathReport(ctx context.Context, params QueryParams, traces int) (*CriticalPathReport, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+	if err := applyTimeRange(RangeAnalytics, &params.From, &params.To); err != nil {
+		return nil, err
+	}
+	if traces <= 0 {
+		traces = defaultCriticalPathTraces
+	}
+	traces = min(traces, maxCriticalPathTraces)
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultCriticalPathOperations
+	}
+	limit = min(limit, maxCriticalPathOperations)
+
+	ids, err := s.recentTraceIDs(ctx, params, traces)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CriticalPathReport{From: params.From, To: params.To, Operations: []CriticalPathOperation{}}
+	if len(ids) == 0 {
+		return report, nil
+	}
+	spans, err := s.loadTraceSpans(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	type operationKey struct{ service, name string }
+	operations := map[operationKey]*CriticalPathOperation{}
+	var total float64
+	for _, id := range ids {
+		if len(spans[id]) == 0 {
+			continue
+		}
+		traceSpans, _ := capTraceSpans(spans[id])
+		path := criticalPath(traceSpans)
+		if len(path.Spans) == 0 {
+			continue
+		}
+		report.Traces++
+		total += path.DurationMs
+
+		seen := map[operationKey]bool{}
+		for _, span := range path.Spans {
+			key := operationKey{span.Service, span.Name}
+			op := operations[key]
+			if op == nil {
+				op = &CriticalPathOperation{Service: span.Service, Name: span.Name}
+				operations[key] = op
+			}
+			op.CriticalMs += span.CriticalMs
+			if !seen[key] {
+				seen[key] = true
+				op.Traces++
+			}
+		}
+	}
+
+	for _, op := range operations {
+		op.AvgCriticalMs = op.CriticalMs / float64(op.Traces)
+		if total > 0 {
+			op.Share = op.CriticalMs / total
+		}
+		report.Operations = append(report.Operations, *op)
+	}
+	sort.Slice(report.Operations, func(i, j int) bool {
+		a, b := report.Operations[i], report.Operations[j]
+		if a.Traces != b.Traces {
+			return a.Traces > b.Traces
+		}
+		if a.CriticalMs != b.CriticalMs {
+			return a.CriticalMs > b.CriticalMs
+		}
+		return a.Service+"\x00"+a.Name < b.Service+"\x00"+b.Name
+	})
+	if len(report.Operations) > limit {
+		report.Operations = report.Operations[:limit]
+	}
+	return report, nil
+}
+
+// recentTraceIDs returns the IDs of the most recent traces, up to n, with spans matching
+// params
+func (s *Service) recentTraceIDs(ctx context.Context, params QueryParams, n int) ([]string, error) {
+	builder := sq.Select("trace_id").
+		From(eventsTable(ctx)).
+		Where("trace_id != ''").
+		Where("JSONHas(data, 'span_id')").
+		GroupBy("trace_id").
+		OrderBy("max(timestamp) DESC").
+		Limit(uint64(n)).
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+	return ids, nil
+
+}
+
+// capTraceSpans drops the span past maxTraceSpans that loadTraceSpans reads to tell a
+// trace was cut short, reporting whether there was one
+func capTraceSpans(spans []*traceSpan) ([]*traceSpan, bool) {
+	if len(spans) > maxTraceSpans {
+		return spans[:maxTraceSpans], true
+	}
+	return spans, false
+}
+
+// loadTraceSpans reads the spans of traces the request can read, by trace ID, up to
+// maxTraceSpans + 1 per trace so callers can tell a trace was cut short
+func (s *Service) loadTraceSpans(ctx context.Context, traceIDs []string) (map[string][]*traceSpan, error) {
+	builder := applyAccess(ctx, sq.Select(
+		"trace_id",
+		dataStringExpr("span_id"),
+		dataStringExpr("parent_span_id"),
+		"service",
+		"name",
+		"timestamp",
+		dataNumberExpr("duration_ms"),
+	).
+		From(eventsTable(ctx)).
+		Where(sq.Eq{"trace_id": traceIDs}).
+		Where("JSONHas(data, 'span_id')").
+		OrderBy("timestamp").
+		Suffix(fmt.Sprintf("LIMIT %d BY trace_id", maxTraceSpans+1)).
+		PlaceholderFormat(sq.Question))
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	spans := make(map[string][]*traceSpan, len(traceIDs))
+	for rows.Next() {
+		var traceID string
+		var duration *float64
+		span := &traceSpan{}
+		if err := rows.Scan(&traceID, &span.SpanID, &span.ParentSpanID, &span.Service, &span.Name, &span.Start, &duration); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		span.Start = span.Start.UTC()
+		if duration != nil && *duration > 0 {
+			span.DurationMs = *duration
+		}
+		span.end = span.Start.Add(time.Duration(span.DurationMs * float64(time.Millisecond)))
+		spans[traceID] = append(spans[traceID], span)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+	return spans, nil
+}
+
+// criticalPath links the spans into a tree and walks it from the longest root. Spans
+// whose parent is missing count as roots, and a span ID seen twice keeps its first span.
+// When every span has a parent among them (their parent IDs form a cycle), there is no
+// root to walk, and the path is empty.
+func criticalPath(spans []*traceSpan) *CriticalPath {
+	byID := make(map[string]*traceSpan, len(spans))
+	unique := spans[:0:0]
+	for _, span := range spans {
+		if _, ok := byID[span.SpanID]; ok {
+			continue
+		}
+		byID[span.SpanID] = span
+		unique = append(unique, span)
+	}
+
+	var root *traceSpan
+	for _, span := range unique {
+		if parent, ok := byID[span.ParentSpanID]; ok && parent != span {
+			parent.children = append(parent.children, span)
+			continue
+		}
+		if root == nil || span.DurationMs > root.DurationMs ||
+			(span.DurationMs == root.DurationMs && span.Start.Before(root.Start)) {
+			root = span
+		}
+	}
+	if root == nil {
+		return &CriticalPath{Spans: []CriticalPathSpan{}}
+	}
+	for _, span := range unique {
+		sort.SliceStable(span.children, func(i, j int) bool { return span.children[i].end.After(span.children[j].end) })
+	}
+
+	var order []*traceSpan
+	critical := map[*traceSpan]time.Duration{}
+	first := map[*traceSpan]time.Time{}
+	walkCriticalPath(root, root.end, func(span *traceSpan, start, end time.Time) {
+		if _, ok := critical[span]; !ok {
+			order = append(order, span)
+		}
+		critical[span] += end.Sub(start)
+		if t, ok := first[span]; !ok || start.Before(t) {
+			first[span] = start
+		}
+	})
+
+	sort.SliceStable(order, func(i, j int) bool { return first[order[i]].Before(first[order[j]]) })
+	path := &CriticalPath{DurationMs: root.DurationMs, Spans: make([]CriticalPathSpan, 0, len(order))}
+	for _, span := range order {
+		s := span.CriticalPathSpan
+		s.CriticalMs = float64(critical[span]) / float64(time.Millisecond)
+		path.Spans = append(path.Spans, s)
+	}
+	return path
+}
+
+// walkCriticalPath walks a span backwards from until: the child finishing last before
+// the cursor is on the path, the span's own time after it is too, and the cursor moves
+// to the child's start. Children are clipped to the span, so the parts of the path add
+// up to its duration. add is called for each part, latest first.
+func walkCriticalPath(span *traceSpan, until time.Time, add func(span *traceSpan, start, end time.Time)) {
+	cursor := until
+	for _, child := range span.children {
+		if !cursor.After(span.Start) {
+			break
+		}
+		if !child.Start.Before(cursor) || !child.end.After(span.Start) {
+			continue
+		}
+		end := child.end
+		if end.After(cursor) {
+			end = cursor
+		}
+		if end.Before(cursor) {
+			add(span, end, cursor)
+		}
+		walkCriticalPath(child, end, func(s *traceSpan, start, end time.Time) {
+			if start.Before(span.Start) {
+				start = span.Start
+			}
+			if end.After(start) {
+				add(s, start, end)
+			}
+		})
+		cursor = child.Start
+		if cursor.Before(span.Start) {
+			cursor = span.Start
+		}
+	}
+	if cursor.After(span.Start) {
+		add(span, span.Start, cursor)
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// testSpan returns a span starting offset after the trace and lasting duration
+func testSpan(id, parent string, offset, duration time.Duration) *traceSpan {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC).Add(offset)
+	span := &traceSpan{end: start.Add(duration)}
+	span.SpanID, span.ParentSpanID, span.Name = id, parent, id
+	span.Start = start
+	span.DurationMs = float64(duration) / float64(time.Millisecond)
+	return span
+}
+
+func TestCriticalPath(t *testing.T) {
+	path := criticalPath([]*traceSpan{
+		testSpan("root", "", 0, 100*time.Millisecond),
+		testSpan("db", "root", 10*time.Millisecond, 30*time.Millisecond),
+		testSpan("cache", "root", 50*time.Millisecond, 40*time.Millisecond),
+	})
+
+	if path.DurationMs != 100 {
+		t.Fatalf("duration = %v, want 100", path.DurationMs)
+	}
+	want := map[string]float64{"root": 30, "db": 30, "cache": 40}
+	if len(path.Spans) != len(want) {
+		t.Fatalf("path has %d spans, want %d: %+v", len(path.Spans), len(want), path.Spans)
+	}
+	for _, span := range path.Spans {
+		if span.CriticalMs != want[span.SpanID] {
+			t.Errorf("%s critical_ms = %v, want %v", span.SpanID, span.CriticalMs, want[span.SpanID])
+		}
+	}
+}
+
+func TestCriticalPathParentCycle(t *testing.T) {
+	path := criticalPath([]*traceSpan{
+		testSpan("a", "b", 0, 10*time.Millisecond),
+		testSpan("b", "a", 0, 20*time.Millisecond),
+	})
+	if len(path.Spans) != 0 || path.DurationMs != 0 {
+		t.Fatalf("path = %+v, want an empty path", path)
+	}
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// DefaultRedactionMask replaces redacted values when the request has no mask
+const DefaultRedactionMask = "[REDACTED]"
+
+// DeleteEvents counts the events matching query and, unless it is a dry run, starts an
+// ALTER TABLE DELETE mutation on every events table. Mutations run asynchronously;
+// their progress is available from GetMutations. Rollups still count the deleted events
+// until the mutations finish and the hours of [from, to] are rolled up again.
+func (s *Service) DeleteEvents(ctx context.Context, query *structs.DeleteQuery) (*structs.MutationResult, error) {
+	where, args, err := buildMutationWhere(query.Filters, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.startMutations(ctx, "DELETE WHERE "+where, args, where, args, query.DryRun)
+	if err != nil || result.DryRun || result.Matched == 0 {
+		return result, err
+	}
+
+	result.RollupsStale = s.rebuildRollupsAfter(result.Mutations, query.From, query.To)
+
+	EmitInternal("events.deleted", "warn", map[string]interface{}{
+		"from":      query.From,
+		"to":        query.To,
+		"filters":   query.Filters,
+		"matched":   result.Matched,
+		"mutations": len(result.Mutations),
+	})
+
+	return result, nil
+}
+
+// RedactEvents replaces the values of top-level data keys with a mask in the events matching
+// query, using an ALTER TABLE UPDATE mutation on every events table. Keys are only rewritten
+// in events that have at least one of them. Like deletes, rollups keep the masked values
+// of numeric fields until the mutations finish and the hours of [from, to] are rolled up again.
+func (s *Service) RedactEvents(ctx context.Context, query *structs.RedactQuery) (*structs.MutationResult, error) {
+	if len(query.Keys) == 0 {
+		return nil, fmt.Errorf("keys are required")
+	}
+	for _, key := range query.Keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid key: keys must not be empty")
+		}
+	}
+	mask := query.Mask
+	if mask == "" {
+		mask = DefaultRedactionMask
+	}
+
+	where, args, err := buildMutationWhere(query.Filters, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	where += " AND arrayExists(k -> JSONHas(data, k), ?)"
+	args = append(args, query.Keys)
+
+	// Rebuild the object from its raw key/value pairs, masking the redacted keys
+	update := `UPDATE data = concat('{', arrayStringConcat(arrayMap(kv -> concat(toJSONString(kv.1), ':',
+		if(has(?, kv.1), toJSONString(?), kv.2)), JSONExtractKeysAndValuesRaw(data)), ','), '}') WHERE ` + where
+	updateArgs := append([]interface{}{query.Keys, mask}, args...)
+
+	result, err := s.startMutations(ctx, update, updateArgs, where, args, query.DryRun)
+	if err != nil || result.DryRun || result.Matched == 0 {
+		return result, err
+	}
+
+	result.RollupsStale = s.rebuildRollupsAfter(result.Mutations, query.From, query.To)
+
+	EmitInternal("events.redacted", "warn", map[string]interface{}{
+		"from":      query.From,
+		"to":        query.To,
+		"filters":   query.Filters,
+		"keys":      query.Keys,
+		"matched":   result.Matched,
+		"mutations": len(result.Mutations),
+	})
+
+	return result, nil
+}
+
+// GetMutations returns the most recent mutations on the events tables, or the mutation
+// with id when it is set
+func (s *Service) GetMutations(ctx context.Context, id string) ([]structs.Mutation, error) {
+	var names []string
+	for _, table := range append(db.EventTables(), db.TenantTables()...) {
+		names = append(names, table.QualifiedName())
+	}
+
+	where := "has(?, concat(database, '.', table))"
+	args := []interface{}{names}
+	if id != "" {
+		where += " AND mutation_id = ?"
+		args = append(args, id)
+	}
+	return s.queryMutations(ctx, where, args, 100)
+}
+
+// buildMutationWhere builds the condition of a delete or redaction, which always has a time range
+func buildMutationWhere(filters []structs.QueryFilter, from, to time.Time) (string, []interface{}, error) {
+	if from.IsZero() || to.IsZero() {
+		return "", nil, fmt.Errorf("from and to are required")
+	}
+	if !to.After(from) {
+		return "", nil, fmt.Errorf("invalid time range: to must be after from")
+	}
+
+	whereParts := []string{"timestamp >= ?", "timestamp <= ?"}
+	args := []interface{}{from, to}
+	filterClause, filterArgs, err := buildFilterClause(filters)
+	if err != nil {
+		return "", nil, err
+	}
+	if filterClause != "" {
+		whereParts = append(whereParts, filterClause)
+		args = append(args, filterArgs...)
+	}
+	return strings.Join(whereParts, " AND "), args, nil
+}
+
+// startMutations counts the events matching where and, unless dryRun is set, runs
+// ALTER TABLE <command> on every events table, tenant tables included
+func (s *Service) startMutations(ctx context.Context, command string, commandArgs []interface{}, where string, whereArgs []interface{}, dryRun bool) (*structs.MutationResult, error) {
+	result := &structs.MutationResult{DryRun: dryRun}
+	sources := []string{eventsTable(ctx)}
+	for _, tenant := range db.TenantTables() {
+		sources = append(sources, tenant.QualifiedName())
+	}
+	for _, source := range sources {
+		var matched uint64
+		countSQL := fmt.Sprintf("SELECT count() FROM %s WHERE %s", source, where)
+		if err := s.queryRow(ctx, countSQL, whereArgs...).Scan(&matched); err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+		result.Matched += matched
+	}
+	if dryRun || result.Matched == 0 {
+		return result, nil
+	}
+
+	kind, _, _ := strings.Cut(command, " ")
+	for _, table := range append(db.EventTables(), db.TenantTables()...) {
+		var started time.Time
+		if err := s.queryRow(ctx, "SELECT now()").Scan(&started); err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+		if err := exec(ctx, s.store, fmt.Sprintf("ALTER TABLE %s %s", table.QualifiedName(), command), commandArgs...); err != nil {
+			return nil, fmt.Errorf("failed to mutate %s: %w", table.QualifiedName(), err)
+		}
+
+		mutations, err := s.queryMutations(ctx,
+			"database = ? AND table = ? AND create_time >= ? AND startsWith(command, ?)",
+			[]interface{}{table.Database, table.Table, started, kind}, 1)
+		if err != nil {
+			return nil, err
+		}
+		result.Mutations = append(result.Mutations, mutations...)
+	}
+
+	return result, nil
+}
+
+// rebuildRollupsAfter rolls up the hours of [from, to] again once mutations have finished,
+// in the background, and reports whether it will: until then, their rollups are stale
+func (s *Service) rebuildRollupsAfter(mutations []structs.Mutation, from, to time.Time) bool {
+	if !RollupsEnabled() {
+		return false
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mutationWaitTimeout)
+		defer cancel()
+		if err := s.waitForMutations(ctx, mutations); err != nil {
+			log.Printf("rollups of %s to %s not rebuilt: %v", from.Format(time.RFC3339), to.Format(time.RFC3339), err)
+			return
+		}
+		if err := s.rebuildRollups(ctx, from, to); err != nil {
+			log.Printf("failed to rebuild rollups of %s to %s: %v", from.Format(time.RFC3339), to.Format(time.RFC3339), err)
+		}
+	}()
+	return true
+}
+
+// mutationWaitTimeout is how long rollups wait for a delete or redaction to finish
+const mutationWaitTimeout = 6 * time.Hour
+
+// waitForMutations polls system.mutations until every one of mutations is done
+func (s *Service) waitForMutations(ctx context.Context, mutations []structs.Mutation) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for len(mutations) > 0 {
+		m := mutations[0]
+		current, err := s.queryMutations(ctx, "database = ? AND table = ? AND mutation_id = ?",
+			[]interface{}{m.Database, m.Table, m.MutationID}, 1)
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 || current[0].IsDone {
+			mutations = mutations[1:]
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("mutation %s on %s.%s: %w", m.MutationID, m.Database, m.Table, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// rebuildRollups replaces the rollups of the hours of [from, to] already rolled up with
+// ones of the events now stored. Their rows are deleted first, as groups left without
+// events wouldn't be replaced.
+func (s *Service) rebuildRollups(ctx context.Context, from, to time.Time) error {
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{"mutations_sync": 1}))
+	for _, t := range rollupTiers {
+		if !t.enabled() {
+			continue
+		}
+		watermark, err := s.rollupWatermark(ctx, t)
+		if err != nil {
+			return err
+		}
+		start, end := from.UTC().Truncate(time.Hour), to.UTC().Truncate(time.Hour).Add(time.Hour)
+		if end.After(watermark) {
+			end = watermark
+		}
+		if !start.Before(end) {
+			continue
+		}
+
+		sql := fmt.Sprintf("ALTER TABLE %s.%s DELETE WHERE %s >= ? AND %s < ?", db.Database, t.table, t.column, t.column)
+		if err := exec(ctx, s.store, sql, start, end); err != nil {
+			return fmt.Errorf("failed to clear %s rollups: %w", t.precision, err)
+		}
+		for start.Before(end) {
+			next := start.Add(24 * time.Hour)
+			if next.After(end) {
+				next = end
+			}
+			if err := s.rollUpRange(ctx, t, start, next); err != nil {
+				return err
+			}
+			start = next
+		}
+	}
+	return nil
+}
+
+func (s *Service) queryMutations(ctx context.Context, where string, args []interface{}, limit int) ([]structs.Mutation, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`
+		SELECT database, table, mutation_id, command, create_time, parts_to_do, is_done, latest_fail_reason
+		FROM system.mutations
+		WHERE %s
+		ORDER BY create_time DESC
+		LIMIT %d
+	`, where, limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	mutations := []structs.Mutation{}
+	for rows.Next() {
+		var m structs.Mutation
+		var isDone uint8
+		if err := rows.Scan(&m.Database, &m.Table, &m.MutationID, &m.Command, &m.CreatedAt, &m.PartsToDo, &isDone, &m.FailureReason); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		m.IsDone = isDone == 1
+		mutations = append(mutations, m)
+	}
+	return mutations, rows.Err()
+}
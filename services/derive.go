@@ -0,0 +1,492 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// DerivedField is a data field computed at ingest from an expression
+type DerivedField struct {
+	Key    string
+	Source string
+	expr   deriveExpr
+}
+
+var derivedFields []DerivedField
+
+// SetDerivedFields configures the fields computed for every ingested event
+func SetDerivedFields(fields []DerivedField) {
+	derivedFields = fields
+}
+
+// ParseDerivedFields parses definitions separated by semicolons or newlines, e.g.
+//
+//	data.duration_bucket = bucketize(data.duration_ms, 100, 500, 1000); data.domain = parseHost(data.url)
+func ParseDerivedFields(spec string) ([]DerivedField, error) {
+	var fields []DerivedField
+	for _, def := range splitDefinitions(spec) {
+		target, source, ok := strings.Cut(def, "=")
+		if !ok {
+			return nil, fmt.Errorf("derived field %q: expected <field> = <expression>", def)
+		}
+
+		key := strings.TrimPrefix(strings.TrimSpace(target), "data.")
+		if !safeIdentifierRegex.MatchString(key) {
+			return nil, fmt.Errorf("derived field %q: target must be data.<key>", def)
+		}
+
+		source = strings.TrimSpace(source)
+		p := &deriveParser{src: source}
+		expr, err := p.parseExpr()
+		if err == nil {
+			p.skipSpace()
+			if p.pos < len(p.src) {
+				err = fmt.Errorf("unexpected %q at position %d", p.src[p.pos:], p.pos)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("derived field %s: %w", key, err)
+		}
+
+		fields = append(fields, DerivedField{Key: key, Source: source, expr: expr})
+	}
+	return fields, nil
+}
+
+// splitDefinitions splits on semicolons and newlines outside of quoted strings
+func splitDefinitions(spec string) []string {
+	var defs []string
+	var quote rune
+	start := 0
+	for i, r := range spec {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ';' || r == '\n':
+			defs = append(defs, spec[start:i])
+			start = i + 1
+		}
+	}
+	defs = append(defs, spec[start:])
+
+	result := defs[:0]
+	for _, def := range defs {
+		if def = strings.TrimSpace(def); def != "" && !strings.HasPrefix(def, "#") {
+			result = append(result, def)
+		}
+	}
+	return result
+}
+
+// applyDerivedFields evaluates the configured fields in order, so later fields can use
+// earlier ones. Values sent by the client are never overwritten, and fields whose
+// inputs are missing are skipped.
+func applyDerivedFields(event *structs.Event) {
+	for _, field := range derivedFields {
+		if _, exists := event.Data[field.Key]; exists {
+			continue
+		}
+		value := field.expr.eval(event)
+		if value == nil {
+			continue
+		}
+		if event.Data == nil {
+			event.Data = make(map[string]interface{})
+		}
+		event.Data[field.Key] = value
+	}
+}
+
+// deriveExpr is a node of a derived field expression
+type deriveExpr interface {
+	eval(event *structs.Event) interface{}
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+func (e literalExpr) eval(*structs.Event) interface{} {
+	return e.value
+}
+
+type fieldRefExpr struct {
+	path []string
+}
+
+func (e fieldRefExpr) eval(event *structs.Event) interface{} {
+	if e.path[0] != "data" {
+		return eventColumn(event, e.path[0])
+	}
+
+	var current interface{} = event.Data
+	for _, part := range e.path[1:] {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	if s, ok := current.(string); ok && s == "" {
+		return nil
+	}
+	return current
+}
+
+func eventColumn(event *structs.Event, column string) interface{} {
+	var value string
+	switch column {
+	case "service":
+		value = event.Service
+	case "env":
+		value = event.Env
+	case "name":
+		value = event.Name
+	case "level":
+		value = event.Level
+	case "job_id":
+		value = event.JobID
+	case "request_id":
+		value = event.RequestID
+	case "trace_id":
+		value = event.TraceID
+	case "user_id":
+		value = event.UserID
+	default:
+		value = event.Labels[column]
+	}
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+type callExpr struct {
+	fn   deriveFunc
+	args []deriveExpr
+}
+
+func (e callExpr) eval(event *structs.Event) interface{} {
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.eval(event)
+	}
+	return e.fn.call(args)
+}
+
+// deriveFunc is a built-in function; maxArgs of -1 means variadic
+type deriveFunc struct {
+	minArgs int
+	maxArgs int
+	call    func(args []interface{}) interface{}
+}
+
+var deriveFuncs = map[string]deriveFunc{
+	"bucketize":    {2, -1, deriveBucketize},
+	"parseHost":    {1, 1, deriveURLPart(func(u *url.URL) string { return u.Hostname() })},
+	"parsePath":    {1, 1, deriveURLPart(func(u *url.URL) string { return u.Path })},
+	"lower":        {1, 1, deriveString(strings.ToLower)},
+	"upper":        {1, 1, deriveString(strings.ToUpper)},
+	"concat":       {1, -1, deriveConcat},
+	"coalesce":     {1, -1, deriveCoalesce},
+	"regexExtract": {2, 2, deriveRegexExtract},
+	"div":          {2, 2, deriveDiv},
+	"round":        {1, 2, deriveRound},
+}
+
+// deriveBucketize labels a number by ascending bounds: <100, 100-500, 500-1000, >=1000
+func deriveBucketize(args []interface{}) interface{} {
+	x, ok := toNumber(args[0])
+	if !ok {
+		return nil
+	}
+	bounds := make([]float64, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		b, ok := toNumber(arg)
+		if !ok {
+			return nil
+		}
+		bounds = append(bounds, b)
+	}
+
+	if x < bounds[0] {
+		return "<" + formatNumber(bounds[0])
+	}
+	for i := 1; i < len(bounds); i++ {
+		if x < bounds[i] {
+			return formatNumber(bounds[i-1]) + "-" + formatNumber(bounds[i])
+		}
+	}
+	return ">=" + formatNumber(bounds[len(bounds)-1])
+}
+
+func deriveURLPart(part func(*url.URL) string) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil
+		}
+		if result := part(u); result != "" {
+			return result
+		}
+		return nil
+	}
+}
+
+func deriveString(fn func(string) string) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if args[0] == nil {
+			return nil
+		}
+		return fn(toString(args[0]))
+	}
+}
+
+func deriveConcat(args []interface{}) interface{} {
+	var b strings.Builder
+	for _, arg := range args {
+		if arg == nil {
+			return nil
+		}
+		b.WriteString(toString(arg))
+	}
+	return b.String()
+}
+
+func deriveCoalesce(args []interface{}) interface{} {
+	for _, arg := range args {
+		if arg != nil {
+			return arg
+		}
+	}
+	return nil
+}
+
+// deriveRegexCache holds compiled regexExtract patterns
+var deriveRegexCache sync.Map
+
+// deriveRegexExtract returns the first capture group (or the whole match) of the pattern
+func deriveRegexExtract(args []interface{}) interface{} {
+	s, ok := args[0].(string)
+	pattern, patternOK := args[1].(string)
+	if !ok || !patternOK {
+		return nil
+	}
+
+	cached, found := deriveRegexCache.Load(pattern)
+	if !found {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil
+		}
+		cached, _ = deriveRegexCache.LoadOrStore(pattern, re)
+	}
+
+	match := cached.(*regexp.Regexp).FindStringSubmatch(s)
+	switch {
+	case match == nil:
+		return nil
+	case len(match) > 1:
+		return match[1]
+	default:
+		return match[0]
+	}
+}
+
+func deriveDiv(args []interface{}) interface{} {
+	a, okA := toNumber(args[0])
+	b, okB := toNumber(args[1])
+	if !okA || !okB || b == 0 {
+		return nil
+	}
+	return a / b
+}
+
+func deriveRound(args []interface{}) interface{} {
+	x, ok := toNumber(args[0])
+	if !ok {
+		return nil
+	}
+	places := 0.0
+	if len(args) > 1 {
+		if places, ok = toNumber(args[1]); !ok {
+			return nil
+		}
+	}
+	scale := math.Pow(10, places)
+	return math.Round(x*scale) / scale
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return formatNumber(s)
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// deriveParser is a recursive descent parser for expressions:
+//
+//	expr  := call | field | string | number
+//	call  := ident "(" [expr ("," expr)*] ")"
+//	field := ident ("." ident)*
+type deriveParser struct {
+	src string
+	pos int
+}
+
+func (p *deriveParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *deriveParser) parseExpr() (deriveExpr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case c == '\'' || c == '"':
+		end := strings.IndexByte(p.src[p.pos+1:], c)
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated string at position %d", p.pos)
+		}
+		value := p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return literalExpr{value}, nil
+
+	case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || p.src[p.pos] >= '0' && p.src[p.pos] <= '9') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.src[start:p.pos])
+		}
+		return literalExpr{value}, nil
+
+	case isIdentChar(c):
+		ident := p.readIdent()
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '(' {
+			return p.parseCall(ident)
+		}
+
+		path := strings.Split(ident, ".")
+		if (len(path) == 1 && eventColumnNames[ident]) || (len(path) > 1 && path[0] == "data") {
+			return fieldRefExpr{path}, nil
+		}
+		return nil, fmt.Errorf("unknown field %q (use data.<key> or an event column)", ident)
+	}
+
+	return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+}
+
+func (p *deriveParser) parseCall(name string) (deriveExpr, error) {
+	fn, ok := deriveFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	p.pos++ // consume "("
+
+	var args []deriveExpr
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == ')' {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			p.skipSpace()
+			if p.pos >= len(p.src) {
+				return nil, fmt.Errorf("missing ) in call to %s", name)
+			}
+			if p.src[p.pos] == ')' {
+				p.pos++
+				break
+			}
+			if p.src[p.pos] != ',' {
+				return nil, fmt.Errorf("expected , or ) at position %d", p.pos)
+			}
+			p.pos++
+		}
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s", name)
+	}
+	if name == "regexExtract" {
+		if pattern, ok := args[1].(literalExpr); ok {
+			if _, err := regexp.Compile(toString(pattern.value)); err != nil {
+				return nil, fmt.Errorf("invalid pattern in regexExtract: %w", err)
+			}
+		}
+	}
+	return callExpr{fn: fn, args: args}, nil
+}
+
+func (p *deriveParser) readIdent() string {
+	start := p.pos
+	for p.pos < len(p.src) && (isIdentChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// eventColumnNames are the columns expressions may reference directly (set by SetLabelColumns)
+var eventColumnNames map[string]bool
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	defaultDigestRange = 7 * 24 * time.Hour
+	// maxDigestRange caps a digest's range; the previous period doubles what it scans
+	maxDigestRange     = 31 * 24 * time.Hour
+	defaultDigestLimit = 10
+	maxDigestLimit     = 100
+	// maxDigestErrorGroups caps the error groups compared between the two periods
+	maxDigestErrorGroups = 10000
+)
+
+// Digest summarizes a time range for scheduled reports: volume against the previous period,
+// the busiest services, error groups (service and event name) that are new or growing, and
+// how the status page's SLOs did
+type Digest struct {
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	PreviousFrom time.Time `json:"previous_from"`
+	PreviousTo   time.Time `json:"previous_to"`
+
+	Volume   DigestVolume    `json:"volume"`
+	Services []DigestService `json:"services"`
+	// NewErrors are error groups without errors in the previous period, most errors first
+	NewErrors []DigestErrorGroup `json:"new_errors"`
+	// Regressions are error groups with more errors than in the previous period, largest
+	// increase first
+	Regressions []DigestErrorGroup `json:"regressions"`
+	// SLOs are the status page components' availability over the range (with STATUS_PAGE_CONFIG)
+	SLOs []SLOStatus `json:"slos"`
+}
+
+// DigestVolume is the event and error volume of a digest's range
+type DigestVolume struct {
+	DigestCounts
+	Interval structs.IntervalType `json:"interval"`
+	Series   []structs.DataPoint  `json:"series"`
+}
+
+// DigestCounts are event and error counts in a digest's range and the previous period;
+// the change percentages are null when the previous count is 0
+type DigestCounts struct {
+	Events              uint64   `json:"events"`
+	PreviousEvents      uint64   `json:"previous_events"`
+	EventsChangePercent *float64 `json:"events_change_percent"`
+	Errors              uint64   `json:"errors"`
+	PreviousErrors      uint64   `json:"previous_errors"`
+	ErrorsChangePercent *float64 `json:"errors_change_percent"`
+}
+
+// DigestService is the volume of one service
+type DigestService struct {
+	Service string `json:"service"`
+	DigestCounts
+}
+
+// DigestErrorGroup is the errors of one event name of a service
+type DigestErrorGroup struct {
+	Service        string    `json:"service"`
+	Name           string    `json:"name"`
+	Errors         uint64    `json:"errors"`
+	PreviousErrors uint64    `json:"previous_errors"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+// GenerateDigest builds the digest of a time range (default the last 7 days), comparing
+// it to the period of the same length before it. SLOs come from pages when it's set.
+func (s *Service) GenerateDigest(ctx context.Context, query *structs.DigestQuery, pages *StatusPages) (*Digest, error) {
+	if err := checkSensitiveFields(ctx, nil, query.Filters); err != nil {
+		return nil, err
+	}
+	if query.To.IsZero() {
+		query.To = time.Now().UTC()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-defaultDigestRange)
+	}
+	if !query.From.Before(query.To) {
+		return nil, fmt.Errorf("invalid time range: from must be before to")
+	}
+	if query.To.Sub(query.From) > maxDigestRange {
+		return nil, fmt.Errorf("time range too large (max %v)", maxDigestRange)
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultDigestLimit
+	}
+	query.Limit = min(query.Limit, maxDigestLimit)
+
+	period := query.To.Sub(query.From)
+	digest := &Digest{
+		From:         query.From,
+		To:           query.To,
+		PreviousFrom: query.From.Add(-period),
+		PreviousTo:   query.From,
+		Volume:       DigestVolume{Interval: summaryInterval(query.From, query.To)},
+		Services:     []DigestService{},
+		NewErrors:    []DigestErrorGroup{},
+		Regressions:  []DigestErrorGroup{},
+		SLOs:         []SLOStatus{},
+	}
+	// Both periods are read at once, split by countIf on the start of the range
+	params := QueryParams{QueryFilters: query.Filters, From: digest.PreviousFrom, To: query.To}
+
+	queries := []func() error{
+		func() error {
+			return s.queryDigestServices(ctx, digest, params, query.Limit)
+		},
+		func() error {
+			return s.queryDigestErrorGroups(ctx, digest, params, query.Limit)
+		},
+		func() error {
+			intervalExpr, err := buildIntervalExpr(digest.Volume.Interval)
+			if err != nil {
+				return err
+			}
+			builder := sq.Select(intervalExpr+" AS bucket", "count() AS n").
+				From(eventsTable(ctx)).
+				GroupBy("bucket").
+				OrderBy("bucket").
+				PlaceholderFormat(sq.Question)
+			builder, err = applyFilters(ctx, builder, QueryParams{QueryFilters: query.Filters, From: query.From, To: query.To})
+			if err != nil {
+				return err
+			}
+			querySQL, queryArgs, err := builder.ToSql()
+			if err != nil {
+				return fmt.Errorf("failed to build query: %w", err)
+			}
+			rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+			if err != nil {
+				return fmt.Errorf("query failed: %w", err)
+			}
+			defer rows.Close()
+			var volume []structs.DataPoint
+			for rows.Next() {
+				var bucket time.Time
+				var n uint64
+				if err := rows.Scan(&bucket, &n); err != nil {
+					return fmt.Errorf("scan failed: %w", err)
+				}
+				volume = append(volume, structs.DataPoint{Timestamp: bucket, Value: float64(n)})
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			digest.Volume.Series = fillTimeSeriesZeros(volume, query.From, query.To, digest.Volume.Interval)
+			return nil
+		},
+	}
+	if pages != nil {
+		queries = append(queries, func() (err error) {
+			digest.SLOs, err = pages.Availability(ctx, query.From, query.To)
+			return err
+		})
+	}
+
+	errs := make([]error, len(queries))
+	var wg sync.WaitGroup
+	for i, query := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = query()
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return digest, nil
+}
+
+// queryDigestServices counts events and errors by service in both periods, setting the
+// busiest services and, from the totals, the overall volume
+func (s *Service) queryDigestServices(ctx context.Context, digest *Digest, params QueryParams, limit int) error {
+	builder := sq.Select("service").
+		Column(sq.Expr("countIf(timestamp >= ?) AS events", digest.From)).
+		Column(sq.Expr("countIf(timestamp < ?) AS previous_events", digest.From)).
+		Column(sq.Expr("countIf("+errorLevels+" AND timestamp >= ?) AS errors", digest.From)).
+		Column(sq.Expr("countIf("+errorLevels+" AND timestamp < ?) AS previous_errors", digest.From)).
+		From(eventsTable(ctx)).
+		GroupBy("service").
+		Suffix(fmt.Sprintf("WITH TOTALS ORDER BY events DESC, service LIMIT %d", limit)).
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return err
+	}
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	scan := func(scanner func(...any) error, service *string, c *DigestCounts) error {
+		if err := scanner(service, &c.Events, &c.PreviousEvents, &c.Errors, &c.PreviousErrors); err != nil {
+			return err
+		}
+		c.EventsChangePercent = digestChange(c.Events, c.PreviousEvents)
+		c.ErrorsChangePercent = digestChange(c.Errors, c.PreviousErrors)
+		return nil
+	}
+
+	for rows.Next() {
+		var s DigestService
+		if err := scan(rows.Scan, &s.Service, &s.DigestCounts); err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		digest.Services = append(digest.Services, s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration failed: %w", err)
+	}
+
+	if len(digest.Services) > 0 {
+		var service string
+		if err := scan(rows.Totals, &service, &digest.Volume.DigestCounts); err != nil {
+			return fmt.Errorf("totals failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// queryDigestErrorGroups compares the errors of each service and event name between the
+// two periods, setting the new error groups and the regressions
+func (s *Service) queryDigestErrorGroups(ctx context.Context, digest *Digest, params QueryParams, limit int) error {
+	builder := sq.Select("service", "name").
+		Column(sq.Expr("countIf(timestamp >= ?) AS errors", digest.From)).
+		Column(sq.Expr("countIf(timestamp < ?) AS previous_errors", digest.From)).
+		Column("min(timestamp) AS first_seen").
+		Column("max(timestamp) AS last_seen").
+		From(eventsTable(ctx)).
+		Where(errorLevels).
+		GroupBy("service", "name").
+		Having("errors > 0").
+		OrderBy("errors DESC").
+		Limit(maxDigestErrorGroups).
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return err
+	}
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g DigestErrorGroup
+		if err := rows.Scan(&g.Service, &g.Name, &g.Errors, &g.PreviousErrors, &g.FirstSeen, &g.LastSeen); err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		switch {
+		case g.PreviousErrors == 0:
+			digest.NewErrors = append(digest.NewErrors, g)
+		case g.Errors > g.PreviousErrors:
+			digest.Regressions = append(digest.Regressions, g)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration failed: %w", err)
+	}
+
+	// New errors are already ordered by count; regressions go by their increase
+	sort.SliceStable(digest.Regressions, func(i, j int) bool {
+		a, b := digest.Regressions[i], digest.Regressions[j]
+		return a.Errors-a.PreviousErrors > b.Errors-b.PreviousErrors
+	})
+	digest.NewErrors = digest.NewErrors[:min(len(digest.NewErrors), limit)]
+	digest.Regressions = digest.Regressions[:min(len(digest.Regressions), limit)]
+	return nil
+}
+
+// digestChange is the change from previous to current in percent, rounded to one decimal,
+// or nil without a previous count
+func digestChange(current, previous uint64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := math.Round((float64(current)-float64(previous))/float64(previous)*1000) / 10
+	return &change
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// DrainOptions controls how framed syslog drain payloads are mapped into events
+type DrainOptions struct {
+	// Service overrides the service for every event (required for Heroku, which omits the app name)
+	Service string
+	// Env is set on every event when not empty
+	Env string
+	// Heroku enables logplex-specific mapping (dyno, router logfmt fields)
+	Heroku bool
+	// DefaultService is used when a message has no APP-NAME and no Service override
+	DefaultService string
+}
+
+// ParseSyslogDrain converts an RFC6587 framed syslog payload (such as a Heroku logplex drain) into events
+func ParseSyslogDrain(r io.Reader, opts DrainOptions) ([]*structs.Event, error) {
+	var events []*structs.Event
+	now := time.Now().UTC()
+
+	err := ReadSyslogFrames(r, func(frame []byte) error {
+		event, err := ParseSyslogMessage(frame, opts.DefaultService, now)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", len(events)+1, err)
+		}
+
+		if opts.Heroku {
+			applyHerokuMapping(event)
+		}
+		if opts.Service != "" {
+			event.Service = opts.Service
+		}
+		if opts.Env != "" {
+			event.Env = opts.Env
+		}
+
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// applyHerokuMapping maps logplex fields: APP-NAME is the log source ("app" or "heroku"),
+// PROCID is the dyno ("web.1", "router"), and router/platform lines are logfmt
+// Logplex PRI values carry no real severity, so level defaults to info
+func applyHerokuMapping(event *structs.Event) {
+	source := event.Service
+	event.Data["source"] = source
+	event.Level = "info"
+	delete(event.Data, "facility")
+	delete(event.Data, "severity")
+
+	dyno, _ := event.Data["procid"].(string)
+	delete(event.Data, "procid")
+	if dyno != "" {
+		event.Data["dyno"] = dyno
+	}
+
+	dynoType, _, _ := strings.Cut(dyno, ".")
+	if dynoType == "" {
+		dynoType = "log"
+	}
+	event.Name = source + "." + dynoType
+
+	if source != "heroku" {
+		return
+	}
+
+	message, _ := event.Data["message"].(string)
+	for key, value := range parseLogfmt(message) {
+		key = unsafeKeyChars.ReplaceAllString(key, "_")
+		if _, exists := event.Data[key]; !exists {
+			event.Data[key] = value
+		}
+	}
+	if at, _ := event.Data["at"].(string); at == "error" {
+		event.Level = "error"
+	}
+}
+
+// parseLogfmt extracts key=value pairs (values may be double-quoted) from a log line
+func parseLogfmt(line string) map[string]string {
+	pairs := make(map[string]string)
+
+	for len(line) > 0 {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			break
+		}
+		key := line[:eq]
+		if strings.ContainsFunc(key, unicode.IsSpace) {
+			// Skip a bare word and continue with the next token
+			sp := strings.IndexFunc(line, unicode.IsSpace)
+			line = line[sp:]
+			continue
+		}
+		line = line[eq+1:]
+
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.IndexByte(line[1:], '"')
+			if end == -1 {
+				value, line = line[1:], ""
+			} else {
+				value, line = line[1:end+1], line[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(line, unicode.IsSpace)
+			if end == -1 {
+				value, line = line, ""
+			} else {
+				value, line = line[:end], line[end:]
+			}
+		}
+		pairs[key] = value
+	}
+
+	return pairs
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// defaultDuplicateWindow is the window used when the request has no from
+	defaultDuplicateWindow    = 24 * time.Hour
+	defaultDuplicateTolerance = time.Second
+	maxDuplicateTolerance     = time.Hour
+	defaultDuplicateProducers = 20
+	maxDuplicateProducers     = 100
+	// duplicateExamples is how many duplicated request IDs are listed per producer
+	duplicateExamples = 5
+)
+
+// DuplicateProducer is a service and event name that sent the same request's event more than once
+type DuplicateProducer struct {
+	Service string `json:"service"`
+	Name    string `json:"name"`
+	// Groups is the number of request/time slots with more than one copy
+	Groups uint64 `json:"groups"`
+	// ExtraEvents is the number of copies beyond the first
+	ExtraEvents uint64 `json:"extra_events"`
+	// MaxCopies is the most copies seen in one group
+	MaxCopies  uint64   `json:"max_copies"`
+	RequestIDs []string `json:"example_request_ids"`
+}
+
+// DuplicatesReport lists the producers of likely duplicate events over a window
+type DuplicatesReport struct {
+	From        time.Time           `json:"from"`
+	To          time.Time           `json:"to"`
+	Tolerance   string              `json:"tolerance"`
+	Groups      uint64              `json:"groups"`
+	ExtraEvents uint64              `json:"extra_events"`
+	Producers   []DuplicateProducer `json:"producers"`
+}
+
+// GetDuplicates finds likely duplicate events: events with the same service, name, and
+// request_id whose timestamps fall in the same tolerance-sized slot. Events without a
+// request_id are skipped, since nothing ties them to one operation. Producers are ranked
+// by extra events.
+func (s *Service) GetDuplicates(ctx context.Context, params QueryParams, tolerance time.Duration) (*DuplicatesReport, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+	if tolerance == 0 {
+		tolerance = defaultDuplicateTolerance
+	}
+	if tolerance < time.Millisecond || tolerance > maxDuplicateTolerance {
+		return nil, fmt.Errorf("invalid tolerance: must be between 1ms and %v", maxDuplicateTolerance)
+	}
+	if params.To.IsZero() {
+		params.To = time.Now().UTC()
+	}
+	if params.From.IsZero() {
+		params.From = params.To.Add(-defaultDuplicateWindow)
+	}
+	if params.To.Sub(params.From) > MaxQueryDuration {
+		return nil, fmt.Errorf("time range too large (max %v)", MaxQueryDuration)
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultDuplicateProducers
+	}
+	limit = min(limit, maxDuplicateProducers)
+
+	inner := sq.Select(
+		"service",
+		"name",
+		"request_id",
+		fmt.Sprintf("intDiv(toUnixTimestamp64Milli(timestamp), %d) AS slot", tolerance.Milliseconds()),
+		"count() AS n",
+	).
+		From(eventsTable(ctx)).
+		Where("request_id != ''").
+		GroupBy("service", "name", "request_id", "slot").
+		Having("n > 1").
+		PlaceholderFormat(sq.Question)
+	inner, err := applyFilters(ctx, inner, params)
+	if err != nil {
+		return nil, err
+	}
+	innerSQL, queryArgs, err := inner.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	querySQL := fmt.Sprintf(`SELECT service, name, count() AS dup_groups, sum(n - 1) AS extra, max(n) AS max_copies, groupArray(%d)(request_id) AS request_ids
+		FROM (%s)
+		GROUP BY service, name WITH TOTALS
+		ORDER BY extra DESC, service, name
+		LIMIT %d`, duplicateExamples, innerSQL, limit)
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	report := &DuplicatesReport{From: params.From, To: params.To, Tolerance: tolerance.String(), Producers: []DuplicateProducer{}}
+	for rows.Next() {
+		var p DuplicateProducer
+		if err := rows.Scan(&p.Service, &p.Name, &p.Groups, &p.ExtraEvents, &p.MaxCopies, &p.RequestIDs); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		report.Producers = append(report.Producers, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+
+	if len(report.Producers) > 0 {
+		var totals DuplicateProducer
+		if err := rows.Totals(&totals.Service, &totals.Name, &report.Groups, &report.ExtraEvents, &totals.MaxCopies, &totals.RequestIDs); err != nil {
+			return nil, fmt.Errorf("totals failed: %w", err)
+		}
+	}
+	return report, nil
+}
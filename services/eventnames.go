@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// defaultEventNamesWindow is the window used when the request has no from
+	defaultEventNamesWindow = 7 * 24 * time.Hour
+	defaultEventNames       = 100
+	maxEventNames           = 1000
+	// eventNameServices and eventNameDataKeys cap the services and data keys listed per name
+	eventNameServices = 50
+	eventNameDataKeys = 200
+)
+
+// EventNameInfo describes one event name: how often it is sent, by whom, and with which data keys
+type EventNameInfo struct {
+	Name      string    `json:"name"`
+	Count     uint64    `json:"count"`
+	Services  []string  `json:"services"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	DataKeys  []string  `json:"data_keys"`
+}
+
+// EventNamesResult lists the event names seen over a window, most frequent first
+type EventNamesResult struct {
+	From  time.Time       `json:"from"`
+	To    time.Time       `json:"to"`
+	Names []EventNameInfo `json:"names"`
+}
+
+// GetEventNames builds a data dictionary of the event names seen over a window: each
+// name's count, the services sending it, when it was first and last seen in the window,
+// and the data keys it carries
+func (s *Service) GetEventNames(ctx context.Context, params QueryParams) (*EventNamesResult, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+	if params.To.IsZero() {
+		params.To = time.Now().UTC()
+	}
+	if params.From.IsZero() {
+		params.From = params.To.Add(-defaultEventNamesWindow)
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultEventNames
+	}
+	limit = min(limit, maxEventNames)
+
+	builder := sq.Select(
+		"name",
+		"count() AS n",
+		fmt.Sprintf("groupUniqArray(%d)(service) AS services", eventNameServices),
+		"min(timestamp) AS first_seen",
+		"max(timestamp) AS last_seen",
+		fmt.Sprintf("groupUniqArrayArray(%d)(JSONExtractKeys(data)) AS data_keys", eventNameDataKeys),
+	).
+		From(eventsTable(ctx)).
+		GroupBy("name").
+		OrderBy("n DESC", "name").
+		Limit(uint64(limit)).
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	result := &EventNamesResult{From: params.From, To: params.To, Names: []EventNameInfo{}}
+	for rows.Next() {
+		var info EventNameInfo
+		if err := rows.Scan(&info.Name, &info.Count, &info.Services, &info.FirstSeen, &info.LastSeen, &info.DataKeys); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		sort.Strings(info.Services)
+		sort.Strings(info.DataKeys)
+		result.Names = append(result.Names, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Service runs the queries and writes that go to ClickHouse, through the store it is built
+// with. The rest of the package (parsing, validation, and in-memory state) needs no store.
+type Service struct {
+	store db.Store
+}
+
+// New returns a Service reading and writing store, a fake one in tests
+func New(store db.Store) *Service {
+	return &Service{store: store}
+}
+
+// QueryStats sum up the ClickHouse queries run for one request, so expensive panels can
+// be spotted. Rows and bytes read come from ClickHouse's progress reports.
+type QueryStats struct {
+	Queries   int     `json:"queries"`
+	ElapsedMs float64 `json:"elapsed_ms"`
+	RowsRead  uint64  `json:"rows_read"`
+	BytesRead uint64  `json:"bytes_read"`
+	// Rollup is the precision of the rollups a time series read, empty when it read only
+	// raw events
+	Rollup structs.Precision `json:"rollup,omitempty"`
+	// CacheHit is set when every query was answered from ClickHouse's query cache
+	// (QUERY_CACHE_TTL)
+	CacheHit bool `json:"cache_hit"`
+	// Truncated is set when a result cap cut the response short
+	Truncated *Truncation `json:"truncated,omitempty"`
+}
+
+// queryStats collects the QueryStats of a request; its queries may run concurrently
+type queryStats struct {
+	mu        sync.Mutex
+	stats     QueryStats
+	points    int
+	bytes     int
+	cacheHits int
+}
+
+// queryCacheTTL is how long ClickHouse caches the results of query requests, 0 when they
+// aren't cached (set by SetQueryCache)
+var queryCacheTTL time.Duration
+
+// SetQueryCache has ClickHouse cache the results of the queries behind query requests for
+// ttl, so a dashboard reloaded by several people runs its panels once. Queries with
+// nondeterministic functions such as now() aren't cached. 0 disables the cache.
+func SetQueryCache(ttl time.Duration) {
+	queryCacheTTL = ttl
+}
+
+type queryStatsKey struct{}
+
+// WithQueryStats returns a context whose queries are counted into the request's stats
+func WithQueryStats(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryStatsKey{}, &queryStats{})
+}
+
+// QueryStatsFrom returns the stats of the queries run with ctx so far, or nil if they
+// aren't collected
+func QueryStatsFrom(ctx context.Context) *QueryStats {
+	qs, ok := ctx.Value(queryStatsKey{}).(*queryStats)
+	if !ok {
+		return nil
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	stats := qs.stats
+	stats.CacheHit = stats.Queries > 0 && qs.cacheHits >= stats.Queries
+	return &stats
+}
+
+// noteRollup records that the request's time series read the rollups of precision
+func noteRollup(ctx context.Context, precision structs.Precision) {
+	if qs, ok := ctx.Value(queryStatsKey{}).(*queryStats); ok {
+		qs.mu.Lock()
+		qs.stats.Rollup = precision
+		qs.mu.Unlock()
+	}
+}
+
+// track sets up ctx to count a query's progress into its request's stats and returns
+// the function that records the query once it is done
+func track(ctx context.Context) (context.Context, func(time.Duration)) {
+	qs, ok := ctx.Value(queryStatsKey{}).(*queryStats)
+	if !ok {
+		return ctx, func(time.Duration) {}
+	}
+	var hit atomic.Bool
+	options := []clickhouse.QueryOption{
+		clickhouse.WithProgress(func(p *clickhouse.Progress) {
+			qs.mu.Lock()
+			qs.stats.RowsRead += p.Rows
+			qs.stats.BytesRead += p.Bytes
+			qs.mu.Unlock()
+		}),
+		clickhouse.WithProfileEvents(func(events []clickhouse.ProfileEvent) {
+			for _, event := range events {
+				if event.Name == "QueryCacheHits" && event.Value > 0 && hit.CompareAndSwap(false, true) {
+					qs.mu.Lock()
+					qs.cacheHits++
+					qs.mu.Unlock()
+				}
+			}
+		}),
+	}
+	if queryCacheTTL > 0 {
+		options = append(options, clickhouse.WithSettings(clickhouse.Settings{
+			"use_query_cache": 1,
+			"query_cache_ttl": int(queryCacheTTL.Seconds()),
+			"query_cache_nondeterministic_function_handling": "ignore",
+		}))
+	}
+	ctx = clickhouse.Context(ctx, options...)
+	return ctx, func(elapsed time.Duration) {
+		qs.mu.Lock()
+		qs.stats.Queries++
+		qs.stats.ElapsedMs += float64(elapsed.Microseconds()) / 1000
+		qs.mu.Unlock()
+	}
+}
+
+// queryRows runs a query against ClickHouse and reports slow queries
+func (s *Service) queryRows(ctx context.Context, sql string, args ...interface{}) (driver.Rows, error) {
+	sql, args, err := bindNamed(sql, args)
+	if err != nil {
+		return nil, err
+	}
+	ctx, done := track(ctx)
+	start := time.Now()
+	rows, err := s.store.Query(ctx, sql, args...)
+	observeQuery(sql, time.Since(start))
+	if err != nil {
+		done(time.Since(start))
+		return nil, err
+	}
+	qs, _ := ctx.Value(queryStatsKey{}).(*queryStats)
+	return &trackedRows{Rows: rows, start: start, done: done, qs: qs}, nil
+}
+
+// queryRow runs a single-row query against ClickHouse and reports slow queries
+func (s *Service) queryRow(ctx context.Context, sql string, args ...interface{}) driver.Row {
+	sql, args, err := bindNamed(sql, args)
+	if err != nil {
+		return errRow{err}
+	}
+	ctx, done := track(ctx)
+	start := time.Now()
+	row := s.store.QueryRow(ctx, sql, args...)
+	observeQuery(sql, time.Since(start))
+	done(time.Since(start))
+	return row
+}
+
+// exec runs a statement on store whose arguments may include filter parameters, like a
+// mutation narrowed by a filter
+func exec(ctx context.Context, store db.Store, sql string, args ...interface{}) error {
+	sql, args, err := bindNamed(sql, args)
+	if err != nil {
+		return err
+	}
+	return store.Exec(ctx, sql, args...)
+}
+
+// bindNamed readies a statement for the driver, which binds either every argument by
+// position or every one by name. When any argument is named (filter values are), the ?
+// placeholders become @p1, @p2, ... and their arguments are named to match; otherwise the
+// statement is returned as it is. A ? escaped as \? stays a literal ?.
+func bindNamed(sql string, args []interface{}) (string, []interface{}, error) {
+	named := false
+	for _, arg := range args {
+		if _, ok := arg.(driver.NamedValue); ok {
+			named = true
+			break
+		}
+	}
+	if !named {
+		return sql, args, nil
+	}
+
+	var positional, bound []interface{}
+	for _, arg := range args {
+		if _, ok := arg.(driver.NamedValue); ok {
+			bound = append(bound, arg)
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(sql); i++ {
+		switch {
+		case sql[i] == '\\' && i+1 < len(sql) && sql[i+1] == '?':
+			b.WriteByte('?')
+			i++
+		case sql[i] == '?':
+			if n == len(positional) {
+				return "", nil, fmt.Errorf("query has more ? placeholders than arguments")
+			}
+			name := fmt.Sprintf("p%d", n+1)
+			b.WriteString("@" + name)
+			bound = append(bound, clickhouse.Named(name, positional[n]))
+			n++
+		default:
+			b.WriteByte(sql[i])
+		}
+	}
+	if n != len(positional) {
+		return "", nil, fmt.Errorf("query has %d ? placeholders for %d arguments", n, len(positional))
+	}
+	return b.String(), bound, nil
+}
+
+// errRow is a driver.Row that fails with err
+type errRow struct {
+	err error
+}
+
+func (r errRow) Err() error           { return r.err }
+func (r errRow) Scan(...any) error    { return r.err }
+func (r errRow) ScanStruct(any) error { return r.err }
+
+// trackedRows records its query once the rows are read, since they are streamed. Rows
+// read for a request count toward its result caps, and stop once one is hit.
+type trackedRows struct {
+	driver.Rows
+	start time.Time
+	done  func(time.Duration)
+	once  sync.Once
+	qs    *queryStats
+	full  bool
+}
+
+func (r *trackedRows) Next() bool {
+	if r.full || !r.Rows.Next() {
+		return false
+	}
+	if r.qs != nil && !r.qs.admitRow() {
+		r.full = true
+		return false
+	}
+	return true
+}
+
+func (r *trackedRows) Scan(dest ...interface{}) error {
+	if err := r.Rows.Scan(dest...); err != nil {
+		return err
+	}
+	if r.qs != nil && !r.qs.admitBytes(scannedSize(dest)) {
+		r.full = true
+	}
+	return nil
+}
+
+func (r *trackedRows) Close() error {
+	r.once.Do(func() { r.done(time.Since(r.start)) })
+	return r.Rows.Close()
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Every filter, from the analytics queries and the events API alike, is built here. Values
+// are always bound as named parameters (@f1, @f2, ...), so a filter's condition holds
+// wherever it lands in a statement; the only user input written into SQL is a data key,
+// which dataKey checks is a plain identifier first. Fields are otherwise columns, derived
+// fields, or lookups, and operators come from the fixed set in buildSingleFilter.
+
+// filterParams numbers the filter parameters, so no two conditions share a name
+var filterParams atomic.Uint64
+
+// filterParam returns a new parameter binding value: its placeholder and its argument
+func filterParam(value interface{}) (string, interface{}) {
+	name := fmt.Sprintf("f%d", filterParams.Add(1))
+	return "@" + name, clickhouse.Named(name, value)
+}
+
+// dataKey returns the JSON key of a data.* field; ok is false for other fields, and err is
+// set when the key isn't a safe identifier
+func dataKey(field string) (key string, ok bool, err error) {
+	key, ok = strings.CutPrefix(field, "data.")
+	if !ok {
+		return "", false, nil
+	}
+	if !safeIdentifierRegex.MatchString(key) {
+		return "", true, fmt.Errorf("invalid data field name: %s", key)
+	}
+	return key, true, nil
+}
+
+// dataStringExpr reads a checked data key as a string
+func dataStringExpr(key string) string {
+	return fmt.Sprintf("JSONExtractString(data, '%s')", key)
+}
+
+// dataNumberExpr reads a checked data key as a number, NULL when it isn't one
+func dataNumberExpr(key string) string {
+	return fmt.Sprintf("toFloat64OrNull(JSONExtractRaw(data, '%s'))", key)
+}
+
+// buildFilterClause builds WHERE clause from filters
+func buildFilterClause(filters []structs.QueryFilter) (string, []interface{}, error) {
+	if len(filters) == 0 {
+		return "", nil, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+
+	for _, f := range filters {
+		cond, condArgs, err := buildSingleFilter(f)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, cond)
+		args = append(args, condArgs...)
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// buildSingleFilter builds a single filter condition
+func buildSingleFilter(f structs.QueryFilter) (string, []interface{}, error) {
+	var fieldExpr string
+
+	if key, ok, err := dataKey(f.Field); ok {
+		if err != nil {
+			return "", nil, err
+		}
+		// Check if operator suggests numeric comparison
+		switch f.Operator {
+		case "lt", "gt", "lte", "gte":
+			fieldExpr = dataNumberExpr(key)
+		default:
+			fieldExpr = dataStringExpr(key)
+		}
+	} else if validColumns[f.Field] {
+		fieldExpr = f.Field
+	} else if expr, ok := derivedNumericFields[f.Field]; ok {
+		fieldExpr = expr
+	} else if expr, ok, err := buildLookupExpr(f.Field); ok {
+		if err != nil {
+			return "", nil, err
+		}
+		fieldExpr = expr
+	} else {
+		return "", nil, fmt.Errorf("invalid filter field: %s", f.Field)
+	}
+
+	switch f.Operator {
+	case "eq", "":
+		return compare(fieldExpr, "=", f.Value)
+	case "neq":
+		return compare(fieldExpr, "!=", f.Value)
+	case "lt":
+		return compare(fieldExpr, "<", f.Value)
+	case "gt":
+		return compare(fieldExpr, ">", f.Value)
+	case "lte":
+		return compare(fieldExpr, "<=", f.Value)
+	case "gte":
+		return compare(fieldExpr, ">=", f.Value)
+	case "contains":
+		return compare(fieldExpr, "LIKE", fmt.Sprintf("%%%v%%", f.Value))
+	case "startswith":
+		return compare(fieldExpr, "LIKE", fmt.Sprintf("%v%%", f.Value))
+	case "endswith":
+		return compare(fieldExpr, "LIKE", fmt.Sprintf("%%%v", f.Value))
+	case "in":
+		var values []interface{}
+		switch v := f.Value.(type) {
+		case []interface{}:
+			values = v
+		case []string:
+			for _, value := range v {
+				values = append(values, value)
+			}
+		default:
+			return "", nil, fmt.Errorf("in operator requires array value")
+		}
+		placeholders := make([]string, len(values))
+		args := make([]interface{}, len(values))
+		for i, value := range values {
+			placeholders[i], args[i] = filterParam(value)
+		}
+		return fmt.Sprintf("%s IN (%s)", fieldExpr, strings.Join(placeholders, ", ")), args, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported operator: %s", f.Operator)
+	}
+}
+
+// compare builds a condition comparing fieldExpr to a bound value with op
+func compare(fieldExpr, op string, value interface{}) (string, []interface{}, error) {
+	placeholder, arg := filterParam(value)
+	return fmt.Sprintf("%s %s %s", fieldExpr, op, placeholder), []interface{}{arg}, nil
+}
+
+// applyFilters adds the request's filters (both kinds), time range, and access role restrictions
+func applyFilters(ctx context.Context, builder sq.SelectBuilder, params QueryParams) (sq.SelectBuilder, error) {
+	builder = applyAccess(ctx, builder)
+	for _, f := range params.Filters {
+		var err error
+		if builder, err = applyFilter(builder, f); err != nil {
+			return builder, err
+		}
+	}
+	if clause, args, err := buildFilterClause(params.QueryFilters); err != nil {
+		return builder, err
+	} else if clause != "" {
+		builder = builder.Where(clause, args...)
+	}
+
+	if !params.From.IsZero() {
+		builder = builder.Where(sq.GtOrEq{"timestamp": params.From})
+	}
+	if !params.To.IsZero() {
+		builder = builder.Where(sq.LtOrEq{"timestamp": params.To})
+	}
+
+	return builder, nil
+}
+
+// applyFilter adds an events API filter, built like an analytics filter. Params that
+// aren't data keys or columns are ignored, as they always have been.
+func applyFilter(builder sq.SelectBuilder, f Filter) (sq.SelectBuilder, error) {
+	field := f.Field
+	if f.IsData {
+		field = "data." + field
+	} else if !validColumns[field] {
+		return builder, nil
+	}
+	cond, args, err := buildSingleFilter(structs.QueryFilter{Field: field, Operator: string(f.Operator), Value: f.Value})
+	if err != nil {
+		return builder, err
+	}
+	return builder.Where(cond, args...), nil
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// filterValueRegex matches the right-hand side of a filter condition: only placeholders
+var filterValueRegex = regexp.MustCompile(`^(@f[0-9]+|\((@f[0-9]+(, @f[0-9]+)*)?\))$`)
+
+// filterParamRegex matches one filter placeholder
+var filterParamRegex = regexp.MustCompile(`@f[0-9]+`)
+
+// plainKeyRegex matches the data keys filters may name, checked apart from dataKey
+var plainKeyRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// filterOperators are the SQL operators a filter condition can use
+var filterOperators = []string{"=", "!=", "<", ">", "<=", ">=", "LIKE", "IN"}
+
+// withLookup registers the lookup dict.region, keyed by service, until the test ends
+func withLookup(t testing.TB) {
+	lookupsMu.Lock()
+	previous := lookupSources
+	lookupSources = map[string]string{"region": "service"}
+	lookupsMu.Unlock()
+	t.Cleanup(func() {
+		lookupsMu.Lock()
+		lookupSources = previous
+		lookupsMu.Unlock()
+	})
+}
+
+// fieldExprs returns the SQL expressions a filter on field may compile to; none means the
+// field must be rejected
+func fieldExprs(field string) []string {
+	var exprs []string
+	if validColumns[field] {
+		exprs = append(exprs, field)
+	}
+	if expr, ok := derivedNumericFields[field]; ok {
+		exprs = append(exprs, expr)
+	}
+	if key, ok := strings.CutPrefix(field, "data."); ok && plainKeyRegex.MatchString(key) {
+		exprs = append(exprs,
+			fmt.Sprintf("JSONExtractString(data, '%s')", key),
+			fmt.Sprintf("toFloat64OrNull(JSONExtractRaw(data, '%s'))", key))
+	}
+	if field == "dict.region" {
+		exprs = append(exprs, fmt.Sprintf("dictGetOrDefault('%s.lookups_dict', 'value', tuple('region', service), '')", db.Database))
+	}
+	return exprs
+}
+
+// checkFilterSQL fails the test unless cond is one of field's expressions, an operator,
+// and placeholders bound by name to args, so nothing from f reaches the SQL text
+func checkFilterSQL(t *testing.T, f structs.QueryFilter, cond string, args []interface{}) {
+	t.Helper()
+
+	var rest string
+	for _, expr := range fieldExprs(f.Field) {
+		if r, ok := strings.CutPrefix(cond, expr+" "); ok {
+			rest = r
+			break
+		}
+	}
+	if rest == "" {
+		t.Fatalf("filter %+v: condition %q doesn't start with an expression of its field", f, cond)
+	}
+
+	var value string
+	for _, op := range filterOperators {
+		if v, ok := strings.CutPrefix(rest, op+" "); ok {
+			value = v
+			break
+		}
+	}
+	if !filterValueRegex.MatchString(value) {
+		t.Fatalf("filter %+v: condition %q compares to something other than placeholders", f, cond)
+	}
+
+	placeholders := filterParamRegex.FindAllString(value, -1)
+	if len(placeholders) != len(args) {
+		t.Fatalf("filter %+v: %d placeholders for %d arguments", f, len(placeholders), len(args))
+	}
+	for i, arg := range args {
+		named, ok := arg.(driver.NamedValue)
+		if !ok || "@"+named.Name != placeholders[i] {
+			t.Fatalf("filter %+v: argument %d is %#v, want a value named %s", f, i, arg, placeholders[i])
+		}
+	}
+}
+
+func TestBuildSingleFilter(t *testing.T) {
+	withLookup(t)
+
+	tests := []struct {
+		name    string
+		filter  structs.QueryFilter
+		wantErr string
+	}{
+		{name: "column", filter: structs.QueryFilter{Field: "service", Operator: "eq", Value: "api"}},
+		{name: "default operator", filter: structs.QueryFilter{Field: "env", Value: "prod"}},
+		{name: "data string", filter: structs.QueryFilter{Field: "data.path", Operator: "startswith", Value: "/v1"}},
+		{name: "data number", filter: structs.QueryFilter{Field: "data.status", Operator: "gte", Value: 500}},
+		{name: "derived", filter: structs.QueryFilter{Field: "ingest_lag", Operator: "gt", Value: 1000}},
+		{name: "lookup", filter: structs.QueryFilter{Field: "dict.region", Operator: "neq", Value: "eu"}},
+		{name: "in", filter: structs.QueryFilter{Field: "level", Operator: "in", Value: []interface{}{"warn", "error"}}},
+		{name: "in strings", filter: structs.QueryFilter{Field: "level", Operator: "in", Value: []string{"warn", "error"}}},
+
+		{name: "quote in value", filter: structs.QueryFilter{Field: "service", Operator: "eq", Value: "api' OR '1'='1"}},
+		{name: "quote in like value", filter: structs.QueryFilter{Field: "data.path", Operator: "contains", Value: "x%' OR 1=1 --"}},
+		{name: "quote in list value", filter: structs.QueryFilter{Field: "service", Operator: "in", Value: []string{"a", "b') OR (1=1"}}},
+		{name: "comment in value", filter: structs.QueryFilter{Field: "user_id", Operator: "lt", Value: "1 --"}},
+		{name: "placeholder in value", filter: structs.QueryFilter{Field: "service", Operator: "eq", Value: "@f1 ? $1"}},
+
+		{name: "injected column", filter: structs.QueryFilter{Field: "service = service OR 1", Operator: "eq", Value: "x"}, wantErr: "invalid filter field"},
+		{name: "injected data key", filter: structs.QueryFilter{Field: "data.x') OR 1=1 --", Operator: "eq", Value: "x"}, wantErr: "invalid data field name"},
+		{name: "data key with space", filter: structs.QueryFilter{Field: "data.a b", Operator: "eq", Value: "x"}, wantErr: "invalid data field name"},
+		{name: "empty data key", filter: structs.QueryFilter{Field: "data.", Operator: "eq", Value: "x"}, wantErr: "invalid data field name"},
+		{name: "unknown lookup", filter: structs.QueryFilter{Field: "dict.country", Operator: "eq", Value: "x"}, wantErr: "invalid lookup"},
+		{name: "injected lookup", filter: structs.QueryFilter{Field: "dict.region')", Operator: "eq", Value: "x"}, wantErr: "invalid lookup"},
+		{name: "table column", filter: structs.QueryFilter{Field: "data", Operator: "eq", Value: "x"}, wantErr: "invalid filter field"},
+		{name: "sql operator", filter: structs.QueryFilter{Field: "service", Operator: "=", Value: "x"}, wantErr: "unsupported operator"},
+		{name: "injected operator", filter: structs.QueryFilter{Field: "service", Operator: "eq OR 1=1 --", Value: "x"}, wantErr: "unsupported operator"},
+		{name: "in without list", filter: structs.QueryFilter{Field: "service", Operator: "in", Value: "a,b"}, wantErr: "in operator requires array value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, args, err := buildSingleFilter(tt.filter)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q (condition %q)", err, tt.wantErr, cond)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			checkFilterSQL(t, tt.filter, cond, args)
+		})
+	}
+}
+
+func TestBuildFilterClauseNamesEveryValue(t *testing.T) {
+	clause, args, err := buildFilterClause([]structs.QueryFilter{
+		{Field: "service", Operator: "eq", Value: "api"},
+		{Field: "service", Operator: "eq", Value: "api"},
+		{Field: "level", Operator: "in", Value: []string{"warn", "error"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := map[string]bool{}
+	for _, arg := range args {
+		named := arg.(driver.NamedValue)
+		if names[named.Name] {
+			t.Fatalf("parameter %s is bound twice in %q", named.Name, clause)
+		}
+		names[named.Name] = true
+		if !strings.Contains(clause, "@"+named.Name) {
+			t.Fatalf("parameter %s isn't used in %q", named.Name, clause)
+		}
+	}
+	if len(names) != 4 {
+		t.Fatalf("clause %q binds %d parameters, want 4", clause, len(names))
+	}
+}
+
+func TestBindNamed(t *testing.T) {
+	_, filterArgs, err := buildSingleFilter(structs.QueryFilter{Field: "service", Value: "api"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	name := "@" + filterArgs[0].(driver.NamedValue).Name
+
+	sql, args, err := bindNamed("SELECT 1 WHERE env = ? AND service = "+name+" AND name != '\\?' AND level = ?", append([]interface{}{"prod"}, append(filterArgs, "error")...))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "SELECT 1 WHERE env = @p1 AND service = " + name + " AND name != '?' AND level = @p2"; sql != want {
+		t.Fatalf("sql = %q, want %q", sql, want)
+	}
+	if len(args) != 3 {
+		t.Fatalf("args = %v, want 3", args)
+	}
+	for _, arg := range args {
+		if _, ok := arg.(driver.NamedValue); !ok {
+			t.Fatalf("argument %#v isn't named", arg)
+		}
+	}
+
+	if sql, args, _ := bindNamed("SELECT 1 WHERE env = ?", []interface{}{"prod"}); sql != "SELECT 1 WHERE env = ?" || args[0] != "prod" {
+		t.Fatalf("positional statement changed to %q %v", sql, args)
+	}
+	if _, _, err := bindNamed("SELECT 1 WHERE env = ? AND level = ?", append([]interface{}{"prod"}, filterArgs...)); err == nil {
+		t.Fatal("expected an error for a ? without an argument")
+	}
+}
+
+func FuzzBuildSingleFilter(f *testing.F) {
+	for _, seed := range []struct {
+		field, operator, value string
+		list                   bool
+	}{
+		{"service", "eq", "api", false},
+		{"data.status", "gte", "500", false},
+		{"data.path", "contains", "%' OR 1=1 --", false},
+		{"dict.region", "in", "eu,us", true},
+		{"ingest_lag", "lt", "100", false},
+		{"data.x') OR ('1'='1", "eq", "x", false},
+		{"service", "eq) OR (1=1", "x", false},
+		{"level", "in", "warn,') OR 1=1 --", true},
+	} {
+		f.Add(seed.field, seed.operator, seed.value, seed.list)
+	}
+	withLookup(f)
+
+	f.Fuzz(func(t *testing.T, field, operator, value string, list bool) {
+		filter := structs.QueryFilter{Field: field, Operator: operator, Value: value}
+		if list {
+			filter.Value = strings.Split(value, ",")
+		}
+		cond, args, err := buildSingleFilter(filter)
+		if err != nil {
+			return
+		}
+		checkFilterSQL(t, filter, cond, args)
+	})
+}
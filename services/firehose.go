@@ -0,0 +1,186 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// maxFirehoseRecordSize caps the decompressed size of a single record
+const maxFirehoseRecordSize = 10 * 1024 * 1024
+
+// FirehoseRequest is the body Kinesis Data Firehose sends to HTTP endpoint destinations
+type FirehoseRequest struct {
+	RequestID string           `json:"requestId"`
+	Timestamp int64            `json:"timestamp"`
+	Records   []FirehoseRecord `json:"records"`
+}
+
+// FirehoseRecord is a single base64-encoded Firehose record
+type FirehoseRecord struct {
+	Data string `json:"data"`
+}
+
+// FirehoseResponse is the acknowledgement Firehose expects back
+type FirehoseResponse struct {
+	RequestID    string `json:"requestId"`
+	Timestamp    int64  `json:"timestamp"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+// FirehoseOptions controls how Firehose records are mapped into events
+type FirehoseOptions struct {
+	// Service and Env come from the X-Amz-Firehose-Common-Attributes header when configured
+	Service        string
+	Env            string
+	DefaultService string
+}
+
+// cloudWatchLogsData is a CloudWatch Logs subscription payload
+type cloudWatchLogsData struct {
+	MessageType         string   `json:"messageType"`
+	Owner               string   `json:"owner"`
+	LogGroup            string   `json:"logGroup"`
+	LogStream           string   `json:"logStream"`
+	SubscriptionFilters []string `json:"subscriptionFilters"`
+	LogEvents           []struct {
+		ID        string `json:"id"`
+		Timestamp int64  `json:"timestamp"`
+		Message   string `json:"message"`
+	} `json:"logEvents"`
+}
+
+// ParseFirehoseRecords converts Firehose records into events
+// Records may be gzip-compressed CloudWatch Logs subscription payloads, monitor-core events, or plain text
+func ParseFirehoseRecords(req *FirehoseRequest, opts FirehoseOptions) ([]*structs.Event, error) {
+	var events []*structs.Event
+	received := time.UnixMilli(req.Timestamp).UTC()
+	if req.Timestamp == 0 {
+		received = time.Now().UTC()
+	}
+
+	for i, record := range req.Records {
+		raw, err := base64.StdEncoding.DecodeString(record.Data)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid base64 data", i)
+		}
+		raw, err = maybeGunzip(raw)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+
+		recordEvents, err := parseFirehoseRecord(raw, received, opts)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		events = append(events, recordEvents...)
+	}
+
+	return events, nil
+}
+
+func parseFirehoseRecord(raw []byte, received time.Time, opts FirehoseOptions) ([]*structs.Event, error) {
+	trimmed := bytes.TrimSpace(raw)
+
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var cw cloudWatchLogsData
+		if err := json.Unmarshal(trimmed, &cw); err == nil && cw.MessageType != "" {
+			return cloudWatchToEvents(&cw, opts)
+		}
+
+		var event structs.Event
+		if err := json.Unmarshal(trimmed, &event); err == nil && event.Service != "" && event.Name != "" {
+			applyFirehoseOverrides(&event, opts)
+			if event.Timestamp.IsZero() {
+				event.Timestamp = received
+			}
+			if err := event.Validate(); err != nil {
+				return nil, err
+			}
+			return []*structs.Event{&event}, nil
+		}
+	}
+
+	event := &structs.Event{
+		Timestamp: received,
+		Service:   opts.DefaultService,
+		Name:      "firehose.record",
+		Data: map[string]interface{}{
+			"message": string(trimmed),
+		},
+	}
+	applyFirehoseOverrides(event, opts)
+	return []*structs.Event{event}, nil
+}
+
+// cloudWatchToEvents maps log events; the service defaults to the last segment of the log group
+// (e.g. /aws/lambda/checkout -> checkout). CONTROL_MESSAGE payloads are skipped.
+func cloudWatchToEvents(cw *cloudWatchLogsData, opts FirehoseOptions) ([]*structs.Event, error) {
+	if cw.MessageType != "DATA_MESSAGE" {
+		return nil, nil
+	}
+
+	service := path.Base(cw.LogGroup)
+	if service == "" || service == "." || service == "/" {
+		service = opts.DefaultService
+	}
+
+	events := make([]*structs.Event, 0, len(cw.LogEvents))
+	for _, le := range cw.LogEvents {
+		event := &structs.Event{
+			Timestamp: time.UnixMilli(le.Timestamp).UTC(),
+			Service:   service,
+			Name:      "cloudwatch.log",
+			Data: map[string]interface{}{
+				"message":    le.Message,
+				"log_id":     le.ID,
+				"log_group":  cw.LogGroup,
+				"log_stream": cw.LogStream,
+				"owner":      cw.Owner,
+			},
+		}
+		applyFirehoseOverrides(event, opts)
+		if err := event.Validate(); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func applyFirehoseOverrides(event *structs.Event, opts FirehoseOptions) {
+	if opts.Service != "" {
+		event.Service = opts.Service
+	}
+	if opts.Env != "" {
+		event.Env = opts.Env
+	}
+}
+
+// maybeGunzip decompresses gzip data and returns anything else unchanged
+func maybeGunzip(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+		return b, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip data: %w", err)
+	}
+	defer zr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(zr, maxFirehoseRecordSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip data: %w", err)
+	}
+	if len(out) > maxFirehoseRecordSize {
+		return nil, fmt.Errorf("record exceeds %d bytes when decompressed", maxFirehoseRecordSize)
+	}
+	return out, nil
+}
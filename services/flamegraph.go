@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultFlameGraphTraces and maxFlameGraphTraces are how many of the most recent
+	// traces a flame graph merges
+	defaultFlameGraphTraces = 100
+	maxFlameGraphTraces     = 1000
+)
+
+// FlameGraphNode is the spans at one position in the merged call tree: an operation
+// called by the same chain of operations in every trace
+type FlameGraphNode struct {
+	Service string `json:"service"`
+	Name    string `json:"name"`
+	// Count is how many spans were merged into the node
+	Count int `json:"count"`
+	// TotalMs is the spans' summed duration, and SelfMs the part not covered by a child
+	TotalMs  float64           `json:"total_ms"`
+	SelfMs   float64           `json:"self_ms"`
+	Children []*FlameGraphNode `json:"children"`
+}
+
+// FlameGraph is the spans of an operation over a time range merged into one call tree
+type FlameGraph struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Traces int       `json:"traces"`
+	// Root merges the spans of the operation, with their descendants below it
+	Root *FlameGraphNode `json:"root"`
+}
+
+// GetFlameGraph merges the spans named name in service, across the most recent traces
+// (up to traces) with spans matching params, into a call tree with the total and self
+// time of each node. Spans of the operation nested under another are merged with it.
+func (s *Service) GetFlameGraph(ctx context.Context, params QueryParams, service, name string, traces int) (*FlameGraph, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+	if err := applyTimeRange(RangeAnalytics, &params.From, &params.To); err != nil {
+		return nil, err
+	}
+	if service == "" || name == "" {
+		return nil, fmt.Errorf("invalid operation: service and name are required")
+	}
+	if traces <= 0 {
+		traces = defaultFlameGraphTraces
+	}
+	traces = min(traces, maxFlameGraphTraces)
+
+	params.Filters = append(params.Filters,
+		Filter{Field: "service", Operator: OpEq, Value: service},
+		Filter{Field: "name", Operator: OpEq, Value: name},
+	)
+	ids, err := s.recentTraceIDs(ctx, params, traces)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &FlameGraph{
+		From: params.From,
+		To:   params.To,
+		Root: &FlameGraphNode{Service: service, Name: name, Children: []*FlameGraphNode{}},
+	}
+	if len(ids) == 0 {
+		return graph, nil
+	}
+	spans, err := s.loadTraceSpans(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		roots := operationSpans(spans[id], service, name)
+		if len(roots) == 0 {
+			continue
+		}
+		graph.Traces++
+		visited := map[*traceSpan]bool{}
+		for _, span := range roots {
+			mergeFlameGraph(graph.Root, span, visited)
+		}
+	}
+	sortFlameGraph(graph.Root)
+	return graph, nil
+}
+
+// operationSpans links a trace's spans into a tree and returns those of the operation
+// that have no ancestor of the operation
+func operationSpans(spans []*traceSpan, service, name string) []*traceSpan {
+	byID := make(map[string]*traceSpan, len(spans))
+	for _, span := range spans {
+		if _, ok := byID[span.SpanID]; !ok {
+			byID[span.SpanID] = span
+		}
+	}
+	for _, span := range byID {
+		if parent, ok := byID[span.ParentSpanID]; ok && parent != span {
+			parent.children = append(parent.children, span)
+		}
+	}
+
+	var roots []*traceSpan
+	for _, span := range byID {
+		if span.Service != service || span.Name != name {
+			continue
+		}
+		nested := false
+		seen := map[*traceSpan]bool{span: true}
+		for parent := byID[span.ParentSpanID]; parent != nil && !seen[parent]; parent = byID[parent.ParentSpanID] {
+			if parent.Service == service && parent.Name == name {
+				nested = true
+				break
+			}
+			seen[parent] = true
+		}
+		if !nested {
+			roots = append(roots, span)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Start.Before(roots[j].Start) })
+	return roots
+}
+
+// mergeFlameGraph adds a span and its descendants to node. Self time is the span's
+// duration less the union of its children's time within it, so parallel children aren't
+// counted twice. visited guards against parent links that form a cycle.
+func mergeFlameGraph(node *FlameGraphNode, span *traceSpan, visited map[*traceSpan]bool) {
+	visited[span] = true
+	node.Count++
+	node.TotalMs += span.DurationMs
+
+	type interval struct{ start, end time.Time }
+	var covered []interval
+	for _, child := range span.children {
+		if visited[child] {
+			continue
+		}
+		start, end := child.Start, child.end
+		if start.Before(span.Start) {
+			start = span.Start
+		}
+		if end.After(span.end) {
+			end = span.end
+		}
+		if end.After(start) {
+			covered = append(covered, interval{start, end})
+		}
+
+		var next *FlameGraphNode
+		for _, c := range node.Children {
+			if c.Service == child.Service && c.Name == child.Name {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			next = &FlameGraphNode{Service: child.Service, Name: child.Name, Children: []*FlameGraphNode{}}
+			node.Children = append(node.Children, next)
+		}
+		mergeFlameGraph(next, child, visited)
+	}
+
+	sort.Slice(covered, func(i, j int) bool { return covered[i].start.Before(covered[j].start) })
+	var busy time.Duration
+	var until time.Time
+	for _, c := range covered {
+		if c.start.Before(until) {
+			c.start = until
+		}
+		if c.end.After(c.start) {
+			busy += c.end.Sub(c.start)
+			until = c.end
+		}
+	}
+	node.SelfMs += max(0, span.DurationMs-float64(busy)/float64(time.Millisecond))
+}
+
+// sortFlameGraph orders each node's children by total time, largest first
+func sortFlameGraph(node *FlameGraphNode) {
+	sort.Slice(node.Children, func(i, j int) bool {
+		a, b := node.Children[i], node.Children[j]
+		if a.TotalMs != b.TotalMs {
+			return a.TotalMs > b.TotalMs
+		}
+		return a.Service+"\x00"+a.Name < b.Service+"\x00"+b.Name
+	})
+	for _, child := range node.Children {
+		sortFlameGraph(child)
+	}
+}
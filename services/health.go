@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Components tracked by the health checks
+const (
+	HealthClickHouse = "clickhouse"
+	HealthFlush      = "flush"
+)
+
+// healthPingTimeout bounds each ClickHouse reachability check
+const healthPingTimeout = 5 * time.Second
+
+// HealthCheck is the result of one periodic check. Flush counts cover the batches
+// written since the previous check.
+type HealthCheck struct {
+	Time          time.Time `json:"time"`
+	ClickHouse    bool      `json:"clickhouse"`
+	LatencyMs     int64     `json:"latency_ms"`
+	Error         string    `json:"error,omitempty"`
+	Flush         bool      `json:"flush"`
+	FlushesOK     int64     `json:"flushes_ok"`
+	FlushesFailed int64     `json:"flushes_failed"`
+	Pending       int64     `json:"pending"`
+}
+
+// HealthIncident is a period a component was degraded; To is nil while it still is
+type HealthIncident struct {
+	Component string     `json:"component"`
+	From      time.Time  `json:"from"`
+	To        *time.Time `json:"to"`
+	Duration  float64    `json:"duration_seconds"`
+}
+
+// HealthHistory summarizes the checks kept in memory
+type HealthHistory struct {
+	From      time.Time          `json:"from"`
+	To        time.Time          `json:"to"`
+	Interval  string             `json:"interval"`
+	Uptime    map[string]float64 `json:"uptime"`
+	Incidents []HealthIncident   `json:"incidents"`
+	Checks    []HealthCheck      `json:"checks"`
+}
+
+var (
+	healthMu       sync.RWMutex
+	healthChecks   []HealthCheck
+	healthNext     int
+	healthFull     bool
+	healthInterval time.Duration
+
+	flushesOK     atomic.Int64
+	flushesFailed atomic.Int64
+)
+
+// recordFlush counts a batch write for the next health check
+func recordFlush(_ []*structs.Event, _ time.Duration, err error) {
+	if err == nil {
+		flushesOK.Add(1)
+	} else {
+		flushesFailed.Add(1)
+	}
+}
+
+// RunHealthChecks checks ClickHouse reachability and flush results every interval, keeping
+// the last size checks in a ring buffer. It lives in memory so the history survives the
+// outages it records, and emits health.degraded and health.recovered on changes.
+func RunHealthChecks(ctx context.Context, store db.Store, p *pipeline.Pipeline, interval time.Duration, size int) {
+	p.OnFlush(recordFlush)
+
+	healthMu.Lock()
+	healthChecks = make([]HealthCheck, max(size, 1))
+	healthInterval = interval
+	healthMu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	previous := HealthCheck{ClickHouse: true, Flush: true}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check := runHealthCheck(ctx, store, p.Queue())
+			recordHealthCheck(check)
+			emitHealthChange(HealthClickHouse, previous.ClickHouse, check.ClickHouse, check.Error)
+			emitHealthChange(HealthFlush, previous.Flush, check.Flush, "")
+			previous = check
+		}
+	}
+}
+
+func runHealthCheck(ctx context.Context, store db.Store, queue *pipeline.Queue) HealthCheck {
+	check := HealthCheck{Time: time.Now().UTC()}
+
+	pingCtx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	defer cancel()
+	start := time.Now()
+	err := store.Ping(pingCtx)
+	check.LatencyMs = time.Since(start).Milliseconds()
+	check.ClickHouse = err == nil
+	if err != nil {
+		check.Error = err.Error()
+	}
+
+	check.FlushesOK = flushesOK.Swap(0)
+	check.FlushesFailed = flushesFailed.Swap(0)
+	check.Flush = check.FlushesFailed == 0
+	_, _, pending := queue.Stats()
+	check.Pending = int64(pending)
+	return check
+}
+
+func recordHealthCheck(check HealthCheck) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	healthChecks[healthNext] = check
+	healthNext = (healthNext + 1) % len(healthChecks)
+	if healthNext == 0 {
+		healthFull = true
+	}
+}
+
+func emitHealthChange(component string, was, is bool, reason string) {
+	if was == is {
+		return
+	}
+	data := map[string]interface{}{"component": component}
+	if reason != "" {
+		data["error"] = reason
+	}
+	if is {
+		EmitInternal("health.recovered", "info", data)
+	} else {
+		EmitInternal("health.degraded", "error", data)
+	}
+}
+
+// GetHealthHistory returns the checks since from (all kept checks when zero) with each
+// component's uptime and the periods it was degraded
+func GetHealthHistory(from time.Time) *HealthHistory {
+	healthMu.RLock()
+	var ordered []HealthCheck
+	if healthFull {
+		ordered = append(ordered, healthChecks[healthNext:]...)
+	}
+	ordered = append(ordered, healthChecks[:healthNext]...)
+	interval := healthInterval
+	healthMu.RUnlock()
+
+	history := &HealthHistory{
+		Interval:  interval.String(),
+		Uptime:    map[string]float64{},
+		Incidents: []HealthIncident{},
+		Checks:    []HealthCheck{},
+	}
+	for _, check := range ordered {
+		if !check.Time.Before(from) {
+			history.Checks = append(history.Checks, check)
+		}
+	}
+	if len(history.Checks) == 0 {
+		return history
+	}
+	history.From = history.Checks[0].Time
+	history.To = history.Checks[len(history.Checks)-1].Time
+
+	for _, component := range []string{HealthClickHouse, HealthFlush} {
+		healthy := 0
+		var open *HealthIncident
+		for _, check := range history.Checks {
+			ok := check.ClickHouse
+			if component == HealthFlush {
+				ok = check.Flush
+			}
+			switch {
+			case ok:
+				healthy++
+				if open != nil {
+					to := check.Time
+					open.To = &to
+					open.Duration = to.Sub(open.From).Seconds()
+					history.Incidents = append(history.Incidents, *open)
+					open = nil
+				}
+			case open == nil:
+				open = &HealthIncident{Component: component, From: check.Time}
+			}
+		}
+		if open != nil {
+			open.Duration = time.Since(open.From).Seconds()
+			history.Incidents = append(history.Incidents, *open)
+		}
+		history.Uptime[component] = float64(healthy) / float64(len(history.Checks)) * 100
+	}
+
+	return history
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+	// historyWriteTimeout bounds the background write of a history entry
+	historyWriteTimeout = 5 * time.Second
+)
+
+// HistoryQuery is a query from the caller's history or starred queries. Running the same
+// query again updates its entry instead of adding one.
+type HistoryQuery struct {
+	// ID identifies the query by its type and body
+	ID    string          `json:"id"`
+	Type  string          `json:"type"`
+	Query json.RawMessage `json:"query"`
+	// Title is the name given when the query was starred
+	Title   string     `json:"title,omitempty"`
+	Starred bool       `json:"starred"`
+	LastRun *time.Time `json:"last_run,omitempty"`
+	Runs    uint64     `json:"runs,omitempty"`
+}
+
+// RecordQuery adds a query of a saved query type that ran successfully to the caller's
+// history. The write happens in the background, so the history never slows or fails a query.
+func (s *Service) RecordQuery(ctx context.Context, queryType string, query interface{}) {
+	principal := PrincipalFromContext(ctx)
+	if principal == "" {
+		return
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return
+	}
+	s.recordHistory(ctx, principal, queryType, body)
+}
+
+func (s *Service) recordHistory(ctx context.Context, principal, queryType string, body []byte) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), historyWriteTimeout)
+		defer cancel()
+		if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.query_history (principal, id, type, query) VALUES (?, ?, ?, ?)", db.Database),
+			principal, historyQueryID(queryType, body), queryType, string(body)); err != nil {
+			log.Printf("query history write failed: %v", err)
+		}
+	}()
+}
+
+// historyQueryID identifies a query by its type and body
+func historyQueryID(queryType string, body []byte) string {
+	sum := sha256.Sum256(append([]byte(queryType+"\n"), body...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ListQueryHistory returns the caller's distinct queries, most recently run first
+func (s *Service) ListQueryHistory(ctx context.Context, limit int) ([]HistoryQuery, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	limit = min(limit, maxHistoryLimit)
+
+	history := []HistoryQuery{}
+	principal := PrincipalFromContext(ctx)
+	if principal == "" {
+		return history, nil
+	}
+
+	starred, err := s.ListStarredQueries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	titles := make(map[string]string, len(starred))
+	for _, q := range starred {
+		titles[q.ID] = q.Title
+	}
+
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`SELECT id, any(type), any(query), max(ran_at) AS last_run, count()
+		FROM %s.query_history WHERE principal = ? GROUP BY id ORDER BY last_run DESC LIMIT %d`, db.Database, limit), principal)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var q HistoryQuery
+		var body string
+		var lastRun time.Time
+		if err := rows.Scan(&q.ID, &q.Type, &body, &lastRun, &q.Runs); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		q.Query = json.RawMessage(body)
+		q.LastRun = &lastRun
+		q.Title, q.Starred = titles[q.ID]
+		history = append(history, q)
+	}
+	return history, rows.Err()
+}
+
+// ListStarredQueries returns the caller's starred queries, by title
+func (s *Service) ListStarredQueries(ctx context.Context) ([]HistoryQuery, error) {
+	starred := []HistoryQuery{}
+	principal := PrincipalFromContext(ctx)
+	if principal == "" {
+		return starred, nil
+	}
+
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`SELECT id, type, query, title FROM %s.starred_queries FINAL
+		WHERE principal = ? AND deleted = 0 ORDER BY title, id`, db.Database), principal)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		q, err := scanStarredQuery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		starred = append(starred, *q)
+	}
+	return starred, rows.Err()
+}
+
+func scanStarredQuery(scan func(dest ...interface{}) error) (*HistoryQuery, error) {
+	var q HistoryQuery
+	var body string
+	if err := scan(&q.ID, &q.Type, &body, &q.Title); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	q.Query = json.RawMessage(body)
+	q.Starred = true
+	return &q, nil
+}
+
+// GetHistoryQuery returns a query from the caller's starred queries or history, or nil
+// if it has neither
+func (s *Service) GetHistoryQuery(ctx context.Context, id string) (*HistoryQuery, error) {
+	principal := PrincipalFromContext(ctx)
+	if principal == "" {
+		return nil, nil
+	}
+
+	row := s.queryRow(ctx, fmt.Sprintf("SELECT id, type, query, title FROM %s.starred_queries FINAL WHERE principal = ? AND id = ? AND deleted = 0", db.Database), principal, id)
+	q, err := scanStarredQuery(row.Scan)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return q, err
+	}
+
+	row = s.queryRow(ctx, fmt.Sprintf("SELECT id, type, query FROM %s.query_history WHERE principal = ? AND id = ? LIMIT 1", db.Database), principal, id)
+	q = &HistoryQuery{}
+	var body string
+	if err := row.Scan(&q.ID, &q.Type, &body); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	q.Query = json.RawMessage(body)
+	return q, nil
+}
+
+// StarQuery stars a query from the caller's history (or renames a starred one), copying
+// it so it is kept after the history expires
+func (s *Service) StarQuery(ctx context.Context, q *HistoryQuery, title string) error {
+	q.Title = title
+	q.Starred = true
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.starred_queries (principal, id, type, query, title) VALUES (?, ?, ?, ?, ?)", db.Database),
+		PrincipalFromContext(ctx), q.ID, q.Type, string(q.Query), q.Title); err != nil {
+		return fmt.Errorf("failed to star query: %w", err)
+	}
+	return nil
+}
+
+// UnstarQuery removes a query from the caller's starred queries; it stays in the history
+// until it expires
+func (s *Service) UnstarQuery(ctx context.Context, id string) error {
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.starred_queries (principal, id, type, query, title, deleted) VALUES (?, ?, '', '', '', 1)", db.Database),
+		PrincipalFromContext(ctx), id); err != nil {
+		return fmt.Errorf("failed to unstar query: %w", err)
+	}
+	return nil
+}
+
+// RunHistoryQuery runs a query from the history again, with the caller's current access
+// role, and records the run
+func (s *Service) RunHistoryQuery(ctx context.Context, q *HistoryQuery) (interface{}, error) {
+	query, err := decodeQuery(q.Type, q.Query)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.runQuery(ctx, q.Type, query)
+	if err != nil {
+		return nil, err
+	}
+	if series, ok := result.(*structs.TimeSeriesResult); ok {
+		s.AnnotateTimeSeries(ctx, query.(*structs.TimeSeriesQuery), series)
+	}
+	s.recordHistory(ctx, PrincipalFromContext(ctx), q.Type, q.Query)
+	return result, nil
+}
@@ -0,0 +1,437 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/google/uuid"
+)
+
+const (
+	// incidentAlertLookback is how far back alert.firing events are searched for an
+	// incident's alerts
+	incidentAlertLookback = 7 * 24 * time.Hour
+	maxIncidentAlerts     = 50
+	maxIncidentServices   = 50
+	// MaxIncidentExport caps the events of an incident export
+	MaxIncidentExport = 100000
+)
+
+// ListIncidents returns incidents, newest first, optionally only open or resolved ones
+func (s *Service) ListIncidents(ctx context.Context, status string) ([]structs.Incident, error) {
+	if status != "" && status != "open" && status != "resolved" {
+		return nil, fmt.Errorf("invalid status: %s (use open or resolved)", status)
+	}
+	rows, err := s.queryRows(ctx, fmt.Sprintf("SELECT id, incident FROM %s.incidents FINAL WHERE deleted = 0", db.Database))
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	incidents := []structs.Incident{}
+	for rows.Next() {
+		incident, err := scanIncident(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		if status == "" || incident.Status == status {
+			incidents = append(incidents, *incident)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(incidents, func(i, j int) bool {
+		return incidents[i].StartedAt.After(incidents[j].StartedAt)
+	})
+	return incidents, nil
+}
+
+// GetIncident returns an incident, or nil if it doesn't exist
+func (s *Service) GetIncident(ctx context.Context, id string) (*structs.Incident, error) {
+	row := s.queryRow(ctx, fmt.Sprintf("SELECT id, incident FROM %s.incidents FINAL WHERE id = ? AND deleted = 0", db.Database), id)
+	incident, err := scanIncident(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return incident, err
+}
+
+func scanIncident(scan func(dest ...interface{}) error) (*structs.Incident, error) {
+	var id, body string
+	if err := scan(&id, &body); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	var incident structs.Incident
+	if err := json.Unmarshal([]byte(body), &incident); err != nil {
+		return nil, fmt.Errorf("invalid stored incident %s: %w", id, err)
+	}
+	incident.ID = id
+	return &incident, nil
+}
+
+// CreateIncident opens an incident. Alerts attached by fingerprint default its title (their
+// alert names), services (their service labels), and start (the earliest alert's).
+func (s *Service) CreateIncident(ctx context.Context, req *structs.IncidentRequest) (*structs.Incident, error) {
+	if len(req.Alerts) > maxIncidentAlerts {
+		return nil, fmt.Errorf("too many alerts (max %d)", maxIncidentAlerts)
+	}
+	alerts, err := s.findIncidentAlerts(ctx, req.Alerts)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	incident := &structs.Incident{
+		ID:        uuid.NewString(),
+		Alerts:    alerts,
+		StartedAt: now,
+		CreatedAt: now,
+	}
+
+	var names, services []string
+	for i, alert := range alerts {
+		if !slices.Contains(names, alert.Alertname) {
+			names = append(names, alert.Alertname)
+		}
+		if service := alert.Labels["service"]; service != "" && !slices.Contains(services, service) {
+			services = append(services, service)
+		}
+		if i == 0 || alert.StartsAt.Before(incident.StartedAt) {
+			incident.StartedAt = alert.StartsAt
+		}
+	}
+	if req.Title == "" {
+		req.Title = strings.Join(names, ", ")
+	}
+	if req.Services == nil {
+		req.Services = services
+	}
+	if req.StartedAt == nil {
+		req.StartedAt = &incident.StartedAt
+	}
+
+	if err := applyIncidentRequest(incident, req); err != nil {
+		return nil, err
+	}
+	if err := s.putIncident(ctx, incident); err != nil {
+		return nil, err
+	}
+
+	EmitInternal("incident.opened", "warn", map[string]interface{}{
+		"incident_id": incident.ID,
+		"title":       incident.Title,
+		"services":    incident.Services,
+		"alerts":      len(incident.Alerts),
+	})
+	if incident.Status == "resolved" {
+		emitIncidentResolved(incident)
+	}
+	return incident, nil
+}
+
+// UpdateIncident replaces the title, severity, services, time range, and resolution of an
+// incident, attaching any new alerts; setting resolved_at resolves it. Returns nil if the
+// incident doesn't exist.
+func (s *Service) UpdateIncident(ctx context.Context, id string, req *structs.IncidentRequest) (*structs.Incident, error) {
+	incident, err := s.GetIncident(ctx, id)
+	if err != nil || incident == nil {
+		return nil, err
+	}
+	wasOpen := incident.Status == "open"
+
+	var added []string
+	for _, fingerprint := range req.Alerts {
+		if !slices.ContainsFunc(incident.Alerts, func(a structs.IncidentAlert) bool { return a.Fingerprint == fingerprint }) {
+			added = append(added, fingerprint)
+		}
+	}
+	if len(incident.Alerts)+len(added) > maxIncidentAlerts {
+		return nil, fmt.Errorf("too many alerts (max %d)", maxIncidentAlerts)
+	}
+	alerts, err := s.findIncidentAlerts(ctx, added)
+	if err != nil {
+		return nil, err
+	}
+	incident.Alerts = append(incident.Alerts, alerts...)
+
+	if req.StartedAt == nil {
+		req.StartedAt = &incident.StartedAt
+	}
+	if err := applyIncidentRequest(incident, req); err != nil {
+		return nil, err
+	}
+	if err := s.putIncident(ctx, incident); err != nil {
+		return nil, err
+	}
+
+	if wasOpen && incident.Status == "resolved" {
+		emitIncidentResolved(incident)
+	}
+	return incident, nil
+}
+
+// applyIncidentRequest validates and sets the editable fields of an incident
+func applyIncidentRequest(incident *structs.Incident, req *structs.IncidentRequest) error {
+	if strings.TrimSpace(req.Title) == "" {
+		return fmt.Errorf("title is required")
+	}
+	if len(req.Services) > maxIncidentServices {
+		return fmt.Errorf("too many services (max %d)", maxIncidentServices)
+	}
+	if req.ResolvedAt != nil && req.ResolvedAt.Before(*req.StartedAt) {
+		return fmt.Errorf("invalid resolved_at: before started_at")
+	}
+
+	incident.Title = req.Title
+	incident.Severity = req.Severity
+	incident.Services = req.Services
+	incident.StartedAt = req.StartedAt.UTC()
+	incident.ResolvedAt = nil
+	incident.Status = "open"
+	if req.ResolvedAt != nil {
+		resolvedAt := req.ResolvedAt.UTC()
+		incident.ResolvedAt = &resolvedAt
+		incident.Status = "resolved"
+	}
+	incident.Resolution = req.Resolution
+	return nil
+}
+
+func (s *Service) putIncident(ctx context.Context, incident *structs.Incident) error {
+	incident.UpdatedAt = time.Now().UTC()
+	body, err := json.Marshal(incident)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.incidents (id, incident) VALUES (?, ?)", db.Database), incident.ID, string(body)); err != nil {
+		return fmt.Errorf("failed to save incident: %w", err)
+	}
+	return nil
+}
+
+func emitIncidentResolved(incident *structs.Incident) {
+	EmitInternal("incident.resolved", "info", map[string]interface{}{
+		"incident_id": incident.ID,
+		"title":       incident.Title,
+		"services":    incident.Services,
+		"duration_s":  incident.ResolvedAt.Sub(incident.StartedAt).Seconds(),
+	})
+}
+
+// DeleteIncident removes an incident
+func (s *Service) DeleteIncident(ctx context.Context, id string) error {
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.incidents (id, incident, deleted) VALUES (?, '{}', 1)", db.Database), id); err != nil {
+		return fmt.Errorf("failed to delete incident: %w", err)
+	}
+	return nil
+}
+
+// findIncidentAlerts looks up alerts by fingerprint in the alert.firing events of the alert
+// engine and the Alertmanager receiver, using each alert's most recent firing
+func (s *Service) findIncidentAlerts(ctx context.Context, fingerprints []string) ([]structs.IncidentAlert, error) {
+	if len(fingerprints) == 0 {
+		return nil, nil
+	}
+	builder := sq.Select("service", "data").
+		From(eventsTable(ctx)).
+		Where("name = 'alert.firing'").
+		OrderBy("timestamp DESC").
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, QueryParams{
+		QueryFilters: []structs.QueryFilter{{Field: "data.fingerprint", Operator: "in", Value: fingerprints}},
+		From:         time.Now().UTC().Add(-incidentAlertLookback),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	found := map[string]structs.IncidentAlert{}
+	for rows.Next() {
+		var service, body string
+		if err := rows.Scan(&service, &body); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		var data struct {
+			Alertname   string            `json:"alertname"`
+			Fingerprint string            `json:"fingerprint"`
+			Labels      map[string]string `json:"labels"`
+			StartsAt    time.Time         `json:"starts_at"`
+			Source      string            `json:"source"`
+		}
+		if err := json.Unmarshal([]byte(body), &data); err != nil {
+			continue
+		}
+		if _, ok := found[data.Fingerprint]; ok {
+			continue
+		}
+		// Alertmanager alerts carry their service on the event instead of a label
+		if data.Labels == nil {
+			data.Labels = map[string]string{}
+		}
+		if data.Labels["service"] == "" && data.Source == "alertmanager" {
+			data.Labels["service"] = service
+		}
+		found[data.Fingerprint] = structs.IncidentAlert{
+			Fingerprint: data.Fingerprint,
+			Alertname:   data.Alertname,
+			Labels:      data.Labels,
+			StartsAt:    data.StartsAt.UTC(),
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+
+	alerts := make([]structs.IncidentAlert, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		alert, ok := found[fingerprint]
+		if !ok {
+			return nil, fmt.Errorf("invalid alert: no alert.firing event for fingerprint %s in the last %d days", fingerprint, int(incidentAlertLookback.Hours()/24))
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+// AnnotateTimeSeries adds the incidents overlapping a time series result to it, so charts
+// mark incident windows. With a service filter, incidents of other services are left out.
+// Annotations are best effort: the result is left as is if incidents can't be read.
+func (s *Service) AnnotateTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery, result *structs.TimeSeriesResult) {
+	from, to := query.From, query.To
+	for _, series := range result.Series {
+		for _, point := range series.DataPoints {
+			if from.IsZero() || point.Timestamp.Before(from) {
+				from = point.Timestamp
+			}
+			if to.IsZero() || point.Timestamp.After(to) {
+				to = point.Timestamp
+			}
+		}
+	}
+	if from.IsZero() {
+		return
+	}
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+
+	var services []string
+	for _, f := range query.Filters {
+		if f.Field != "service" {
+			continue
+		}
+		switch value := f.Value.(type) {
+		case string:
+			if f.Operator == "eq" || f.Operator == "" {
+				services = append(services, value)
+			}
+		case []interface{}:
+			if f.Operator == "in" {
+				for _, v := range value {
+					services = append(services, fmt.Sprint(v))
+				}
+			}
+		}
+	}
+
+	incidents, err := s.ListIncidents(ctx, "")
+	if err != nil {
+		return
+	}
+	for _, incident := range incidents {
+		if incident.StartedAt.After(to) || (incident.ResolvedAt != nil && incident.ResolvedAt.Before(from)) {
+			continue
+		}
+		if len(services) > 0 && len(incident.Services) > 0 &&
+			!slices.ContainsFunc(incident.Services, func(s string) bool { return slices.Contains(services, s) }) {
+			continue
+		}
+		result.Annotations = append(result.Annotations, structs.Annotation{
+			Time:       incident.StartedAt,
+			TimeEnd:    incident.ResolvedAt,
+			Title:      incident.Title,
+			Text:       incident.Resolution,
+			Tags:       append([]string{"incident", incident.Status}, incident.Services...),
+			IncidentID: incident.ID,
+		})
+	}
+}
+
+// ExportIncidentEvents writes the events of an incident's affected services during it as
+// NDJSON, oldest first, narrowed by params' filters. An open incident's window runs to
+// now. Returns how many events were written.
+func (s *Service) ExportIncidentEvents(ctx context.Context, incident *structs.Incident, params QueryParams, w io.Writer) (int, error) {
+	params.From = incident.StartedAt
+	params.To = time.Now().UTC()
+	if incident.ResolvedAt != nil {
+		params.To = *incident.ResolvedAt
+	}
+	if params.To.Sub(params.From) > MaxQueryDuration {
+		return 0, fmt.Errorf("time range too large (max %v)", MaxQueryDuration)
+	}
+	if len(incident.Services) > 0 {
+		params.QueryFilters = append(params.QueryFilters, structs.QueryFilter{Field: "service", Operator: "in", Value: incident.Services})
+	}
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return 0, err
+	}
+
+	builder := sq.Select(eventColumns()...).
+		From(eventsTable(ctx)).
+		OrderBy("timestamp").
+		Limit(MaxIncidentExport).
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return 0, err
+	}
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	enc := json.NewEncoder(w)
+	written := 0
+	for rows.Next() {
+		e, err := scanEvent(ctx, rows.Scan)
+		if err != nil {
+			return written, err
+		}
+		if err := enc.Encode(e); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, rows.Err()
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Timestamp policies applied to client timestamps outside the allowed clock skew
+const (
+	// TimestampPolicyRecord keeps the client timestamp; received_at still records the server time
+	TimestampPolicyRecord = "record"
+	// TimestampPolicyClamp replaces the timestamp with the receive time and keeps the original in data.client_timestamp
+	TimestampPolicyClamp = "clamp"
+	// TimestampPolicyReject drops the event
+	TimestampPolicyReject = "reject"
+)
+
+// ErrClockSkew is returned when an event is rejected by the timestamp policy
+var ErrClockSkew = errors.New("timestamp outside allowed clock skew")
+
+var (
+	timestampPolicy = TimestampPolicyRecord
+	maxClockSkew    time.Duration
+	maxEventAge     time.Duration
+)
+
+// SetTimestampPolicy configures how live ingestion treats client timestamps more than
+// maxSkew in the future or more than maxAge in the past
+func SetTimestampPolicy(policy string, maxSkew, maxAge time.Duration) error {
+	switch policy {
+	case TimestampPolicyRecord, TimestampPolicyClamp, TimestampPolicyReject:
+	default:
+		return fmt.Errorf("unknown timestamp policy %q (expected record, clamp, or reject)", policy)
+	}
+	timestampPolicy = policy
+	maxClockSkew = maxSkew
+	maxEventAge = maxAge
+	return nil
+}
+
+// PrepareEvent applies the server-side ingest policies to a live event before it is queued
+func PrepareEvent(event *structs.Event, receivedAt time.Time) error {
+	return prepareEvent(event, receivedAt, true)
+}
+
+// prepareEvent stamps the receive time, applies the timestamp policy to live events,
+// normalizes the level, computes derived fields, applies pipeline rules (sample rules only
+// to live events) and quotas to live events, offloads large payloads, enforces size limits,
+// and holds live events of sampled traces until their trace is decided
+// Backfilled events skip the policy since old timestamps are expected, and may carry their
+// original received_at; they are still redacted, routed, and enriched like live ones
+func prepareEvent(event *structs.Event, receivedAt time.Time, live bool) error {
+	if live || event.ReceivedAt.IsZero() {
+		event.ReceivedAt = receivedAt
+	}
+
+	if live && timestampPolicy != TimestampPolicyRecord && isSkewed(event.Timestamp, event.ReceivedAt) {
+		if timestampPolicy == TimestampPolicyReject {
+			return ErrClockSkew
+		}
+		if event.Data == nil {
+			event.Data = make(map[string]interface{})
+		}
+		event.Data["client_timestamp"] = event.Timestamp.UTC().Format(time.RFC3339Nano)
+		event.Timestamp = event.ReceivedAt
+	}
+
+	if err := normalizeLevel(event); err != nil {
+		return err
+	}
+
+	applyDerivedFields(event)
+	if err := applyPipelineRules(event, live); err != nil {
+		return err
+	}
+	if live {
+		if err := applyQuotas(event, receivedAt); err != nil {
+			return err
+		}
+	}
+	offloadPayload(event)
+	truncateEvent(event)
+
+	// Held events are queued as they are, so holding comes last
+	if live {
+		return holdForTrace(event, receivedAt)
+	}
+	return nil
+}
+
+func isSkewed(timestamp, receivedAt time.Time) bool {
+	if maxClockSkew > 0 && timestamp.After(receivedAt.Add(maxClockSkew)) {
+		return true
+	}
+	return maxEventAge > 0 && timestamp.Before(receivedAt.Add(-maxEventAge))
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// defaultIngestLagWindow is the window used when the request has no from
+	defaultIngestLagWindow   = time.Hour
+	defaultIngestLagServices = 50
+	maxIngestLagServices     = 1000
+)
+
+// IngestLag summarizes the milliseconds between event timestamps and when the server received them
+type IngestLag struct {
+	Events uint64  `json:"events"`
+	P50    float64 `json:"p50_ms"`
+	P95    float64 `json:"p95_ms"`
+	P99    float64 `json:"p99_ms"`
+	Max    float64 `json:"max_ms"`
+}
+
+// ServiceIngestLag is the ingest lag of one service
+type ServiceIngestLag struct {
+	Service string `json:"service"`
+	IngestLag
+}
+
+// IngestLagReport is the ingest lag over a window, overall and by service
+type IngestLagReport struct {
+	From     time.Time          `json:"from"`
+	To       time.Time          `json:"to"`
+	Overall  IngestLag          `json:"overall"`
+	Services []ServiceIngestLag `json:"services"`
+}
+
+// GetIngestLag reports ingest_lag percentiles by service, slowest p95 first, to find
+// producers whose buffering delays their events (and the alerts evaluated on them)
+func (s *Service) GetIngestLag(ctx context.Context, params QueryParams) (*IngestLagReport, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+	if params.To.IsZero() {
+		params.To = time.Now().UTC()
+	}
+	if params.From.IsZero() {
+		params.From = params.To.Add(-defaultIngestLagWindow)
+	}
+	if params.To.Sub(params.From) > MaxQueryDuration {
+		return nil, fmt.Errorf("time range too large (max %v)", MaxQueryDuration)
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultIngestLagServices
+	}
+	limit = min(limit, maxIngestLagServices)
+
+	lag := derivedNumericFields["ingest_lag"]
+	builder := sq.Select(
+		"service",
+		"count() AS events",
+		fmt.Sprintf("quantiles(0.5, 0.95, 0.99)(%s) AS lag_ms", lag),
+		fmt.Sprintf("max(%s) AS max_lag", lag),
+	).
+		From(eventsTable(ctx)).
+		GroupBy("service").
+		Suffix(fmt.Sprintf("WITH TOTALS ORDER BY lag_ms[2] DESC, service LIMIT %d", limit)).
+		PlaceholderFormat(sq.Question)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	scan := func(scanner func(...any) error, service *string, l *IngestLag) error {
+		var quantiles []float64
+		if err := scanner(service, &l.Events, &quantiles, &l.Max); err != nil {
+			return err
+		}
+		if len(quantiles) == 3 {
+			l.P50, l.P95, l.P99 = quantiles[0], quantiles[1], quantiles[2]
+		}
+		return nil
+	}
+
+	report := &IngestLagReport{From: params.From, To: params.To, Services: []ServiceIngestLag{}}
+	for rows.Next() {
+		var s ServiceIngestLag
+		if err := scan(rows.Scan, &s.Service, &s.IngestLag); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		report.Services = append(report.Services, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+
+	if len(report.Services) > 0 {
+		var service string
+		if err := scan(rows.Totals, &service, &report.Overall); err != nil {
+			return nil, fmt.Errorf("totals failed: %w", err)
+		}
+	}
+	return report, nil
+}
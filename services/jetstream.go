@@ -0,0 +1,408 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/google/uuid"
+)
+
+const (
+	// jetStreamBatch is how many messages one pull request asks for
+	jetStreamBatch = 100
+	// jetStreamExpires is how long a pull request waits for messages
+	jetStreamExpires = 30 * time.Second
+	// jetStreamAckWait is how long the server waits for an ack before redelivering, so it
+	// must cover the queue, a flush, and tail sampling's wait
+	jetStreamAckWait = time.Minute
+	// jetStreamMaxAckPending caps the messages delivered but not yet acked
+	jetStreamMaxAckPending = 10000
+	// jetStreamMaxBackoff caps the wait between reconnects
+	jetStreamMaxBackoff = 30 * time.Second
+)
+
+// JetStreamConfig selects the stream and durable consumer events are pulled from
+type JetStreamConfig struct {
+	// URL is the server, as nats://[user:password@]host:port, or tls:// to require TLS. A
+	// user without a password is sent as a token.
+	URL    string
+	Stream string
+	// Subject filters the stream's subjects; empty consumes all of them
+	Subject string
+	// Consumer is the durable consumer, created on connect if it doesn't exist
+	Consumer string
+}
+
+// JetStreamConsumer pulls NDJSON events from a NATS JetStream consumer into the queue and
+// acks each message once all of its events are written, so a crash or failed write leaves
+// them to be redelivered
+type JetStreamConsumer struct {
+	queue    *pipeline.Queue
+	config   JetStreamConfig
+	inbox    string
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// mu guards conn and pending
+	mu      sync.Mutex
+	conn    *natsConn
+	pending map[*structs.Event]*jetStreamMessage
+}
+
+// jetStreamMessage is a delivered message whose events aren't all written yet
+type jetStreamMessage struct {
+	reply     string
+	remaining int
+	received  time.Time
+	// answered is set once the message is acked or nacked
+	answered bool
+}
+
+// NewJetStreamConsumer creates a consumer that enqueues into the pipeline's queue and acks
+// from its flush and reject hooks
+func NewJetStreamConsumer(p *pipeline.Pipeline, config JetStreamConfig) (*JetStreamConsumer, error) {
+	if config.Stream == "" || config.Consumer == "" {
+		return nil, fmt.Errorf("a stream and consumer are required")
+	}
+	for _, name := range []string{config.Stream, config.Consumer} {
+		if strings.ContainsAny(name, " \t.*>") {
+			return nil, fmt.Errorf("invalid stream or consumer name %q", name)
+		}
+	}
+
+	c := &JetStreamConsumer{
+		queue:   p.Queue(),
+		config:  config,
+		inbox:   "_INBOX." + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		stop:    make(chan struct{}),
+		pending: make(map[*structs.Event]*jetStreamMessage),
+	}
+	p.OnFlush(func(batch []*structs.Event, _ time.Duration, err error) {
+		c.resolve(batch, err == nil)
+	})
+	// Rejected events (invalid, sampled out, or shed) are acked, since redelivery wouldn't change that
+	p.OnReject(func(event *structs.Event, _ error) {
+		c.resolve([]*structs.Event{event}, true)
+	})
+	return c, nil
+}
+
+// Run pulls events until the context is cancelled or Stop is called, reconnecting with
+// backoff when the connection fails
+func (c *JetStreamConsumer) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		connected, err := c.consume(ctx)
+		if err == nil {
+			return
+		}
+		log.Printf("nats jetstream consumer error: %v", err)
+		if connected {
+			backoff = time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, jetStreamMaxBackoff)
+	}
+}
+
+// Stop stops pulling messages. The connection stays open so the events already pulled are
+// still acked as they are written.
+func (c *JetStreamConsumer) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Close stops pulling and closes the connection; call it after the pipeline shuts down.
+// Messages not yet acked are redelivered.
+func (c *JetStreamConsumer) Close() {
+	c.Stop()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// consume connects, makes sure the consumer exists, and pulls batches while the queue has
+// room. It returns nil once stopped, leaving the connection open for acks.
+func (c *JetStreamConsumer) consume(ctx context.Context) (connected bool, err error) {
+	conn, err := dialNATS(c.config.URL, "monitor-core")
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+	c.mu.Unlock()
+
+	defer func() {
+		if err != nil {
+			c.mu.Lock()
+			if c.conn == conn {
+				c.conn = nil
+			}
+			c.mu.Unlock()
+			conn.Close()
+		}
+	}()
+
+	msgs := make(chan natsMsg, jetStreamBatch)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			msg, err := conn.readMsg()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err := conn.subscribe(c.inbox+".*", "1"); err != nil {
+		return false, err
+	}
+	if err := c.createConsumer(conn, msgs, readErr); err != nil {
+		return false, err
+	}
+	log.Printf("nats jetstream consumer %s running on stream %s", c.config.Consumer, c.config.Stream)
+
+	pull := fmt.Sprintf(`{"batch":%d,"expires":%d}`, jetStreamBatch, jetStreamExpires)
+	for seq := 1; ; seq++ {
+		// Pause while the queue is full, so messages wait in the stream instead of being dropped
+		for c.queue.Saturated() {
+			select {
+			case <-ctx.Done():
+				return true, nil
+			case <-c.stop:
+				return true, nil
+			case err := <-readErr:
+				return true, err
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		c.sweep(time.Now())
+
+		reply := fmt.Sprintf("%s.%d", c.inbox, seq)
+		subject := fmt.Sprintf("$JS.API.CONSUMER.MSG.NEXT.%s.%s", c.config.Stream, c.config.Consumer)
+		if err := conn.publish(subject, reply, []byte(pull)); err != nil {
+			return true, err
+		}
+
+		deadline := time.NewTimer(jetStreamExpires + natsTimeout)
+		received := 0
+	batch:
+		for received < jetStreamBatch {
+			select {
+			case <-ctx.Done():
+				deadline.Stop()
+				return true, nil
+			case <-c.stop:
+				deadline.Stop()
+				return true, nil
+			case err := <-readErr:
+				deadline.Stop()
+				return true, err
+			case <-deadline.C:
+				break batch
+			case msg := <-msgs:
+				if strings.HasPrefix(msg.reply, "$JS.ACK.") {
+					c.handle(conn, msg)
+					if msg.subject == reply {
+						received++
+					}
+					continue
+				}
+				if msg.subject != reply {
+					continue
+				}
+				// 404 no messages and 408 expired end the request; 409 is a limit or a deleted consumer
+				if msg.status == 409 && strings.Contains(strings.ToLower(msg.description), "deleted") {
+					deadline.Stop()
+					return true, fmt.Errorf("consumer %s was deleted", c.config.Consumer)
+				}
+				if msg.status >= 400 {
+					break batch
+				}
+			}
+		}
+		deadline.Stop()
+	}
+}
+
+// createConsumer creates the durable pull consumer, or leaves it as is when it exists
+func (c *JetStreamConsumer) createConsumer(conn *natsConn, msgs <-chan natsMsg, readErr <-chan error) error {
+	config := map[string]interface{}{
+		"durable_name":    c.config.Consumer,
+		"ack_policy":      "explicit",
+		"ack_wait":        int64(jetStreamAckWait),
+		"max_ack_pending": jetStreamMaxAckPending,
+	}
+	if c.config.Subject != "" {
+		config["filter_subject"] = c.config.Subject
+	}
+	body, err := json.Marshal(map[string]interface{}{"stream_name": c.config.Stream, "config": config})
+	if err != nil {
+		return err
+	}
+
+	reply := c.inbox + ".0"
+	subject := fmt.Sprintf("$JS.API.CONSUMER.DURABLE.CREATE.%s.%s", c.config.Stream, c.config.Consumer)
+	if err := conn.publish(subject, reply, body); err != nil {
+		return err
+	}
+
+	timeout := time.NewTimer(natsTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case err := <-readErr:
+			return err
+		case <-timeout.C:
+			return fmt.Errorf("timed out creating consumer %s", c.config.Consumer)
+		case msg := <-msgs:
+			if msg.subject != reply {
+				continue
+			}
+			if msg.status == 503 {
+				return fmt.Errorf("jetstream is not enabled on the server")
+			}
+			var resp struct {
+				Error *struct {
+					Code        int    `json:"code"`
+					Description string `json:"description"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(msg.data, &resp); err != nil {
+				return fmt.Errorf("invalid consumer create response: %w", err)
+			}
+			if resp.Error != nil {
+				return fmt.Errorf("failed to create consumer %s: %s (%d)", c.config.Consumer, resp.Error.Description, resp.Error.Code)
+			}
+			return nil
+		}
+	}
+}
+
+// handle enqueues the events of a message. A message that isn't valid NDJSON events is
+// terminated, since redelivering it wouldn't help.
+func (c *JetStreamConsumer) handle(conn *natsConn, msg natsMsg) {
+	events, err := parseJetStreamEvents(msg.data)
+	if err != nil {
+		log.Printf("nats jetstream message on %s rejected: %v", msg.subject, err)
+		conn.publish(msg.reply, "", []byte("+TERM"))
+		return
+	}
+	if len(events) == 0 {
+		conn.publish(msg.reply, "", []byte("+ACK"))
+		return
+	}
+
+	m := &jetStreamMessage{reply: msg.reply, remaining: len(events), received: time.Now()}
+	c.mu.Lock()
+	for _, event := range events {
+		c.pending[event] = m
+	}
+	c.mu.Unlock()
+
+	for _, event := range events {
+		// Rejected events are already resolved by the reject hook, so this only nacks drops
+		if !c.queue.Enqueue(event) {
+			c.resolve([]*structs.Event{event}, false)
+		}
+	}
+}
+
+// resolve settles events of pending messages: a message is acked once all of its events
+// are written or rejected, and nacked for redelivery as soon as one is lost
+func (c *JetStreamConsumer) resolve(events []*structs.Event, written bool) {
+	type answer struct{ reply, body string }
+	var answers []answer
+
+	c.mu.Lock()
+	for _, event := range events {
+		m, ok := c.pending[event]
+		if !ok {
+			continue
+		}
+		delete(c.pending, event)
+		m.remaining--
+		if m.answered {
+			continue
+		}
+		if !written {
+			m.answered = true
+			answers = append(answers, answer{m.reply, "-NAK"})
+		} else if m.remaining == 0 {
+			m.answered = true
+			answers = append(answers, answer{m.reply, "+ACK"})
+		}
+	}
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+	for _, a := range answers {
+		if err := conn.publish(a.reply, "", []byte(a.body)); err != nil {
+			log.Printf("failed to answer nats jetstream message: %v", err)
+			return
+		}
+	}
+}
+
+// sweep forgets messages pending longer than the ack wait, which the server has already
+// redelivered
+func (c *JetStreamConsumer) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for event, m := range c.pending {
+		if now.Sub(m.received) > jetStreamAckWait {
+			delete(c.pending, event)
+		}
+	}
+}
+
+// parseJetStreamEvents decodes a message of NDJSON events, like a POST /v1/events body
+func parseJetStreamEvents(data []byte) ([]*structs.Event, error) {
+	var events []*structs.Event
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var event structs.Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("line %d: invalid JSON: %w", i+1, err)
+		}
+		if err := event.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+)
+
+// MaxKeyRotationGrace is the longest a replaced key can stay valid
+const MaxKeyRotationGrace = 30 * 24 * time.Hour
+
+// APIKey is a managed API key. The key itself is only returned when it is issued.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	ReplacedBy string     `json:"replaced_by,omitempty"`
+	Revoked    bool       `json:"revoked"`
+	LastUsed   *time.Time `json:"last_used"`
+}
+
+type apiKeyEntry struct {
+	id        string
+	role      string
+	expiresAt *time.Time
+}
+
+var (
+	apiKeysMu sync.RWMutex
+	// apiKeys maps the SHA-256 of each unrevoked managed key to its entry
+	apiKeys = map[string]apiKeyEntry{}
+)
+
+// LoadAPIKeys refreshes the managed keys accepted by the auth middleware
+func (s *Service) LoadAPIKeys(ctx context.Context) error {
+	rows, err := s.queryRows(ctx, fmt.Sprintf("SELECT key_id, key_hash, role, expires_at FROM %s.api_keys FINAL WHERE revoked = 0", db.Database))
+	if err != nil {
+		return fmt.Errorf("failed to load api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]apiKeyEntry)
+	for rows.Next() {
+		var id, hash, role string
+		var expiresAt *time.Time
+		if err := rows.Scan(&id, &hash, &role, &expiresAt); err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		keys[hash] = apiKeyEntry{id: id, role: role, expiresAt: expiresAt}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	apiKeysMu.Lock()
+	apiKeys = keys
+	apiKeysMu.Unlock()
+	return nil
+}
+
+// RunAPIKeyRefresh reloads managed keys periodically, picking up keys issued, rotated,
+// or revoked through other instances
+func (s *Service) RunAPIKeyRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.LoadAPIKeys(ctx); err != nil {
+				log.Printf("api key refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+// APIKeysConfigured reports whether any managed key exists, which enforces authentication
+// even without API_KEY
+func APIKeysConfigured() bool {
+	apiKeysMu.RLock()
+	defer apiKeysMu.RUnlock()
+	return len(apiKeys) > 0
+}
+
+// LookupAPIKey reports whether key is an unrevoked managed key that hasn't expired,
+// and returns its access role
+func LookupAPIKey(key string) (role string, ok bool) {
+	apiKeysMu.RLock()
+	entry, ok := apiKeys[hashAPIKey(key)]
+	apiKeysMu.RUnlock()
+	if !ok || (entry.expiresAt != nil && !time.Now().Before(*entry.expiresAt)) {
+		return "", false
+	}
+	return entry.role, true
+}
+
+// ListAPIKeys returns every managed key with when it was last used, from the usage metering
+func (s *Service) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`
+		SELECT k.key_id, k.name, k.role, k.created_at, k.expires_at, k.replaced_by, k.revoked, u.last_used
+		FROM (SELECT * FROM %[1]s.api_keys FINAL) AS k
+		LEFT JOIN (SELECT key_id, max(minute) AS last_used FROM %[1]s.key_usage GROUP BY key_id) AS u ON u.key_id = k.key_id
+		ORDER BY k.name, k.created_at
+	`, db.Database))
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var revoked uint8
+		var lastUsed time.Time
+		if err := rows.Scan(&k.ID, &k.Name, &k.Role, &k.CreatedAt, &k.ExpiresAt, &k.ReplacedBy, &revoked, &lastUsed); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		k.Revoked = revoked == 1
+		// Keys never used get the zero DateTime from the join
+		if lastUsed.Unix() > 0 {
+			k.LastUsed = &lastUsed
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// CreateAPIKey issues a new key, restricted by an access role unless role is empty
+func (s *Service) CreateAPIKey(ctx context.Context, name, role string) (*APIKey, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if _, ok := LookupAccessRole(role); !ok || roleRank[role] > 0 {
+		return nil, fmt.Errorf("invalid role: %s", role)
+	}
+	key, err := s.issueAPIKey(ctx, name, role)
+	if err != nil {
+		return nil, err
+	}
+
+	EmitInternal("keys.created", "info", map[string]interface{}{"key_id": key.ID, "name": name, "role": role})
+	return key, nil
+}
+
+// RotateAPIKey issues a replacement for a key. The old key stays valid for grace so
+// producers can move over, then expires.
+func (s *Service) RotateAPIKey(ctx context.Context, id string, grace time.Duration) (*APIKey, error) {
+	if grace < 0 || grace > MaxKeyRotationGrace {
+		return nil, fmt.Errorf("invalid grace period: must be between 0 and %s", MaxKeyRotationGrace)
+	}
+	old, hash, err := s.getAPIKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if old == nil || old.Revoked {
+		return nil, nil
+	}
+	if old.ReplacedBy != "" {
+		return nil, fmt.Errorf("invalid rotation: key %s was already replaced by %s", id, old.ReplacedBy)
+	}
+
+	replacement, err := s.issueAPIKey(ctx, old.Name, old.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().UTC().Add(grace)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(expiresAt) {
+		expiresAt = *old.ExpiresAt
+	}
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.api_keys (key_id, key_hash, name, role, created_at, expires_at, replaced_by) VALUES (?, ?, ?, ?, ?, ?, ?)", db.Database),
+		old.ID, hash, old.Name, old.Role, old.CreatedAt, expiresAt, replacement.ID); err != nil {
+		return nil, fmt.Errorf("failed to expire old key: %w", err)
+	}
+	if err := s.LoadAPIKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	EmitInternal("keys.rotated", "info", map[string]interface{}{
+		"key_id":      old.ID,
+		"replaced_by": replacement.ID,
+		"name":        old.Name,
+		"expires_at":  expiresAt,
+	})
+	return replacement, nil
+}
+
+// RevokeAPIKey invalidates a key immediately. It returns false if the key doesn't exist.
+func (s *Service) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	key, hash, err := s.getAPIKey(ctx, id)
+	if err != nil || key == nil {
+		return false, err
+	}
+
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.api_keys (key_id, key_hash, name, role, created_at, expires_at, replaced_by, revoked) VALUES (?, ?, ?, ?, ?, ?, ?, 1)", db.Database),
+		key.ID, hash, key.Name, key.Role, key.CreatedAt, key.ExpiresAt, key.ReplacedBy); err != nil {
+		return false, fmt.Errorf("failed to revoke key: %w", err)
+	}
+	if err := s.LoadAPIKeys(ctx); err != nil {
+		return false, err
+	}
+
+	EmitInternal("keys.revoked", "warn", map[string]interface{}{"key_id": key.ID, "name": key.Name})
+	return true, nil
+}
+
+func (s *Service) issueAPIKey(ctx context.Context, name, role string) (*APIKey, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := &APIKey{
+		Name:      name,
+		Role:      role,
+		Key:       "mk_" + hex.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	key.ID = KeyID(key.Key)
+
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.api_keys (key_id, key_hash, name, role, created_at) VALUES (?, ?, ?, ?, ?)", db.Database),
+		key.ID, hashAPIKey(key.Key), key.Name, key.Role, key.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := s.LoadAPIKeys(ctx); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetAPIKey returns a managed key without its last use, or nil if it doesn't exist
+func (s *Service) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	key, _, err := s.getAPIKey(ctx, id)
+	return key, err
+}
+
+// getAPIKey returns a key and its hash, or nil if it doesn't exist
+func (s *Service) getAPIKey(ctx context.Context, id string) (*APIKey, string, error) {
+	var k APIKey
+	var hash string
+	var revoked uint8
+	err := s.queryRow(ctx, fmt.Sprintf("SELECT key_id, key_hash, name, role, created_at, expires_at, replaced_by, revoked FROM %s.api_keys FINAL WHERE key_id = ?", db.Database), id).
+		Scan(&k.ID, &hash, &k.Name, &k.Role, &k.CreatedAt, &k.ExpiresAt, &k.ReplacedBy, &revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("query failed: %w", err)
+	}
+	k.Revoked = revoked == 1
+	return &k, hash, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// ErrInvalidLevel is returned for unknown levels when strict level validation is enabled
+var ErrInvalidLevel = errors.New("level is not allowed")
+
+// defaultLevelMap normalizes the level spellings of common logging libraries
+// Numeric levels follow Python's logging module (10 debug ... 50 critical)
+var defaultLevelMap = map[string]string{
+	"trace":         "debug",
+	"verbose":       "debug",
+	"debug":         "debug",
+	"dbg":           "debug",
+	"10":            "debug",
+	"info":          "info",
+	"information":   "info",
+	"informational": "info",
+	"notice":        "info",
+	"log":           "info",
+	"20":            "info",
+	"warn":          "warn",
+	"warning":       "warn",
+	"30":            "warn",
+	"error":         "error",
+	"err":           "error",
+	"severe":        "error",
+	"40":            "error",
+	"fatal":         "fatal",
+	"critical":      "fatal",
+	"crit":          "fatal",
+	"alert":         "fatal",
+	"emerg":         "fatal",
+	"emergency":     "fatal",
+	"panic":         "fatal",
+	"50":            "fatal",
+}
+
+// defaultAllowedLevels are the levels stored when ALLOWED_LEVELS is not set
+var defaultAllowedLevels = []string{"debug", "info", "warn", "error", "fatal"}
+
+var (
+	levelMap      = defaultLevelMap
+	allowedLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true, "fatal": true}
+	defaultLevel  = "info"
+	strictLevels  bool
+)
+
+// ConfigureLevels sets the level normalization rules. overrides are merged over the built-in
+// mapping (keys are case-insensitive), allowed replaces the default allowed levels when not empty,
+// and unknown levels become fallback (keeping the original in data.raw_level) unless strict
+// is set, in which case the event is rejected.
+func ConfigureLevels(overrides map[string]string, allowed []string, fallback string, strict bool) error {
+	if len(allowed) == 0 {
+		allowed = defaultAllowedLevels
+	}
+	levels := make(map[string]bool, len(allowed))
+	for _, level := range allowed {
+		levels[level] = true
+	}
+
+	mapping := make(map[string]string, len(defaultLevelMap)+len(overrides))
+	for from, to := range defaultLevelMap {
+		if levels[to] {
+			mapping[from] = to
+		}
+	}
+	for from, to := range overrides {
+		if !levels[to] {
+			return fmt.Errorf("level mapping %s=%s targets a level that is not allowed", from, to)
+		}
+		mapping[strings.ToLower(from)] = to
+	}
+	// Allowed levels always map to themselves
+	for level := range levels {
+		if _, ok := mapping[level]; !ok {
+			mapping[level] = level
+		}
+	}
+
+	if !levels[fallback] {
+		return fmt.Errorf("default level %q is not allowed", fallback)
+	}
+
+	allowedLevels = levels
+	levelMap = mapping
+	defaultLevel = fallback
+	strictLevels = strict
+	return nil
+}
+
+// isAllowedLevel reports whether level is one of the allowed levels
+func isAllowedLevel(level string) bool {
+	return allowedLevels[level]
+}
+
+// normalizeLevel maps the event level onto the allowed levels; empty levels get the default
+func normalizeLevel(event *structs.Event) error {
+	raw := strings.TrimSpace(event.Level)
+	if raw == "" {
+		event.Level = defaultLevel
+		return nil
+	}
+
+	if level, ok := levelMap[strings.ToLower(raw)]; ok {
+		event.Level = level
+		return nil
+	}
+
+	if strictLevels {
+		return fmt.Errorf("%w: %q", ErrInvalidLevel, raw)
+	}
+	if event.Data == nil {
+		event.Data = make(map[string]interface{})
+	}
+	event.Data["raw_level"] = raw
+	event.Level = defaultLevel
+	return nil
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// lokiServiceLabels are checked in order to find the event service
+var lokiServiceLabels = []string{"service", "service_name", "app", "application", "job"}
+
+// lokiEnvLabels are checked in order to find the event env
+var lokiEnvLabels = []string{"env", "environment"}
+
+// lokiLevelLabels are checked in order to find the event level
+var lokiLevelLabels = []string{"level", "detected_level", "severity"}
+
+// lokiEntry is a single log line with its labels
+type lokiEntry struct {
+	timestamp time.Time
+	line      string
+	labels    map[string]string
+	metadata  map[string]string
+}
+
+// lokiJSONPush is the JSON form of a Loki push request
+type lokiJSONPush struct {
+	Streams []struct {
+		Stream map[string]string   `json:"stream"`
+		Values [][]json.RawMessage `json:"values"`
+	} `json:"streams"`
+}
+
+// ParseLokiPush converts a Loki push request body into events
+// JSON bodies are decoded as-is, anything else is treated as snappy-compressed protobuf,
+// unless snappyDecoded says the body was sent with Content-Encoding: snappy and decoded already
+func ParseLokiPush(body []byte, contentType string, snappyDecoded bool, defaultService string) ([]*structs.Event, error) {
+	var entries []lokiEntry
+	var err error
+
+	if strings.HasPrefix(contentType, "application/json") {
+		entries, err = parseLokiJSON(body)
+	} else {
+		entries, err = parseLokiProto(body, snappyDecoded)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*structs.Event, 0, len(entries))
+	for i, entry := range entries {
+		event := lokiEntryToEvent(entry, defaultService)
+		if err := event.Validate(); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// lokiEntryToEvent maps well-known labels to columns and everything else into data
+func lokiEntryToEvent(entry lokiEntry, defaultService string) *structs.Event {
+	labels := make(map[string]string, len(entry.labels)+len(entry.metadata))
+	for k, v := range entry.labels {
+		labels[k] = v
+	}
+	for k, v := range entry.metadata {
+		labels[k] = v
+	}
+
+	event := &structs.Event{
+		Timestamp: entry.timestamp,
+		Service:   takeLabel(labels, lokiServiceLabels...),
+		Env:       takeLabel(labels, lokiEnvLabels...),
+		Level:     strings.ToLower(takeLabel(labels, lokiLevelLabels...)),
+		Name:      takeLabel(labels, "name", "event"),
+		TraceID:   takeLabel(labels, "trace_id", "traceID"),
+	}
+	if event.Service == "" {
+		event.Service = defaultService
+	}
+	if event.Name == "" {
+		event.Name = "log"
+	}
+	// Drop malformed trace IDs rather than rejecting the whole push
+	if event.TraceID != "" && !structs.IsValidID(event.TraceID) {
+		labels["trace_id"] = event.TraceID
+		event.TraceID = ""
+	}
+
+	data := make(map[string]interface{}, len(labels)+1)
+	for k, v := range labels {
+		data[unsafeKeyChars.ReplaceAllString(k, "_")] = v
+	}
+	data["message"] = entry.line
+	event.Data = data
+
+	return event
+}
+
+// takeLabel returns and removes the first non-empty label among keys
+func takeLabel(labels map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := labels[k]; ok && v != "" {
+			delete(labels, k)
+			return v
+		}
+	}
+	return ""
+}
+
+func parseLokiJSON(body []byte) ([]lokiEntry, error) {
+	var push lokiJSONPush
+	if err := json.Unmarshal(body, &push); err != nil {
+		return nil, fmt.Errorf("invalid JSON push request: %w", err)
+	}
+
+	var entries []lokiEntry
+	for si, stream := range push.Streams {
+		for vi, value := range stream.Values {
+			if len(value) < 2 {
+				return nil, fmt.Errorf("stream %d value %d: expected [timestamp, line]", si, vi)
+			}
+
+			var tsStr, line string
+			if err := json.Unmarshal(value[0], &tsStr); err != nil {
+				return nil, fmt.Errorf("stream %d value %d: timestamp must be a string", si, vi)
+			}
+			if err := json.Unmarshal(value[1], &line); err != nil {
+				return nil, fmt.Errorf("stream %d value %d: line must be a string", si, vi)
+			}
+			ns, err := strconv.ParseInt(tsStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("stream %d value %d: invalid timestamp %q", si, vi, tsStr)
+			}
+
+			entry := lokiEntry{
+				timestamp: time.Unix(0, ns).UTC(),
+				line:      line,
+				labels:    stream.Stream,
+			}
+			if len(value) > 2 {
+				if err := json.Unmarshal(value[2], &entry.metadata); err != nil {
+					return nil, fmt.Errorf("stream %d value %d: invalid structured metadata", si, vi)
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// parseLokiProto decodes a snappy-compressed logproto.PushRequest, or an uncompressed one
+// when snappyDecoded (the decompress middleware decoded it from Content-Encoding: snappy)
+func parseLokiProto(body []byte, snappyDecoded bool) ([]lokiEntry, error) {
+	decoded := body
+	var err error
+	if !snappyDecoded {
+		if decoded, err = snappy.Decode(nil, body); err != nil {
+			return nil, fmt.Errorf("invalid snappy payload: %w", err)
+		}
+	}
+
+	var entries []lokiEntry
+	err = forEachField(decoded, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		streamEntries, err := parseLokiProtoStream(value)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, streamEntries...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf push request: %w", err)
+	}
+	return entries, nil
+}
+
+// parseLokiProtoStream decodes a StreamAdapter: labels=1, entries=2
+func parseLokiProtoStream(b []byte) ([]lokiEntry, error) {
+	var labels map[string]string
+	var entries []lokiEntry
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			parsed, err := ParsePromLabels(string(value))
+			if err != nil {
+				return err
+			}
+			labels = parsed
+		case 2:
+			entry, err := parseLokiProtoEntry(value)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		entries[i].labels = labels
+	}
+	return entries, nil
+}
+
+// parseLokiProtoEntry decodes an EntryAdapter: timestamp=1, line=2, structuredMetadata=3
+func parseLokiProtoEntry(b []byte) (lokiEntry, error) {
+	var entry lokiEntry
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			ts, err := parseProtoTimestamp(value)
+			if err != nil {
+				return err
+			}
+			entry.timestamp = ts
+		case 2:
+			entry.line = string(value)
+		case 3:
+			var name, val string
+			err := forEachField(value, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					name = string(v)
+				case 2:
+					val = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if entry.metadata == nil {
+				entry.metadata = make(map[string]string)
+			}
+			entry.metadata[name] = val
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// parseProtoTimestamp decodes a google.protobuf.Timestamp: seconds=1, nanos=2
+func parseProtoTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos int64
+	err := forEachVarint(b, func(num protowire.Number, v uint64) {
+		switch num {
+		case 1:
+			seconds = int64(v)
+		case 2:
+			nanos = int64(int32(v))
+		}
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+// ParsePromLabels parses a Prometheus label set such as {job="api", env="prod"}
+func ParsePromLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("invalid label set %q", s)
+	}
+	s = s[1 : len(s)-1]
+
+	labels := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if s == "" {
+			return labels, nil
+		}
+
+		eq := strings.Index(s, "=")
+		if eq <= 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return nil, fmt.Errorf("invalid label in set near %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+
+		value, rest, err := unquoteLabelValue(s[eq+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for label %s: %w", name, err)
+		}
+		labels[name] = value
+		s = rest
+	}
+}
+
+// unquoteLabelValue reads a double-quoted label value and returns the remainder
+func unquoteLabelValue(s string) (string, string, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", err
+			}
+			return value, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted string")
+}
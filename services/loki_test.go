@@ -0,0 +1,135 @@
+package services_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/klauspost/compress/snappy"
+)
+
+// lokiPushProto is a logproto.PushRequest with one stream of two entries, the second
+// with structured metadata
+func lokiPushProto() []byte {
+	return protoMessage(protoSubMessage(1,
+		protoString(1, `{service="checkout", env="prod", level="WARN", pod="checkout-7"}`),
+		protoSubMessage(2,
+			protoSubMessage(1, protoVarint(1, 1736935200), protoVarint(2, 500)),
+			protoString(2, "slow payment provider"),
+		),
+		protoSubMessage(2,
+			protoSubMessage(1, protoVarint(1, 1736935201)),
+			protoString(2, "retrying"),
+			protoSubMessage(3, protoString(1, "trace_id"), protoString(2, "0af76519-16cd-43dd-8448-eb211c80319c")),
+		),
+	))
+}
+
+func TestParseLokiPushProto(t *testing.T) {
+	body := lokiPushProto()
+
+	for _, tt := range []struct {
+		name          string
+		body          []byte
+		snappyDecoded bool
+	}{
+		{name: "snappy block", body: snappy.Encode(nil, body)},
+		{name: "decoded by content encoding", body: body, snappyDecoded: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := services.ParseLokiPush(tt.body, "application/x-protobuf", tt.snappyDecoded, "loki")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(events) != 2 {
+				t.Fatalf("got %d events, want 2", len(events))
+			}
+
+			first, second := events[0], events[1]
+			if want := time.Unix(1736935200, 500).UTC(); !first.Timestamp.Equal(want) {
+				t.Fatalf("timestamp = %v, want %v", first.Timestamp, want)
+			}
+			if first.Service != "checkout" || first.Env != "prod" || first.Level != "warn" || first.Name != "log" {
+				t.Fatalf("service, env, level, name = %q, %q, %q, %q", first.Service, first.Env, first.Level, first.Name)
+			}
+			if want := map[string]interface{}{"pod": "checkout-7", "message": "slow payment provider"}; !reflect.DeepEqual(first.Data, want) {
+				t.Fatalf("data = %v, want %v", first.Data, want)
+			}
+			if second.TraceID != "0af76519-16cd-43dd-8448-eb211c80319c" {
+				t.Fatalf("trace_id = %q, want the structured metadata's", second.TraceID)
+			}
+		})
+	}
+}
+
+func TestParseLokiPushJSON(t *testing.T) {
+	body := `{"streams": [{
+		"stream": {"app": "search", "environment": "staging", "http.route": "/q"},
+		"values": [
+			["1736935200000000000", "query took 3s"],
+			["1736935201000000000", "query failed", {"level": "error", "trace_id": "not-a-trace"}]
+		]
+	}]}`
+
+	events, err := services.ParseLokiPush([]byte(body), "application/json", false, "loki")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if events[0].Service != "search" || events[0].Env != "staging" {
+		t.Fatalf("service, env = %q, %q, want search, staging", events[0].Service, events[0].Env)
+	}
+	if want := map[string]interface{}{"http_route": "/q", "message": "query took 3s"}; !reflect.DeepEqual(events[0].Data, want) {
+		t.Fatalf("data = %v, want %v", events[0].Data, want)
+	}
+	// A malformed trace ID is kept in data rather than rejecting the push
+	if events[1].Level != "error" || events[1].TraceID != "" || events[1].Data["trace_id"] != "not-a-trace" {
+		t.Fatalf("level %q, trace_id %q, data %v", events[1].Level, events[1].TraceID, events[1].Data)
+	}
+}
+
+func TestParseLokiPushErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          []byte
+		contentType   string
+		snappyDecoded bool
+		wantErr       string
+	}{
+		{name: "bad json", body: []byte(`{"streams":`), contentType: "application/json", wantErr: "invalid JSON push request"},
+		{name: "short value", body: []byte(`{"streams":[{"stream":{},"values":[["1"]]}]}`), contentType: "application/json", wantErr: "expected [timestamp, line]"},
+		{name: "bad timestamp", body: []byte(`{"streams":[{"stream":{},"values":[["soon","x"]]}]}`), contentType: "application/json", wantErr: "invalid timestamp"},
+		{name: "not snappy", body: lokiPushProto(), contentType: "application/x-protobuf", wantErr: "invalid snappy payload"},
+		{name: "bad labels", body: protoMessage(protoSubMessage(1, protoString(1, "service=api"))), contentType: "application/x-protobuf", snappyDecoded: true, wantErr: "invalid label set"},
+		{name: "truncated protobuf", body: lokiPushProto()[:10], contentType: "application/x-protobuf", snappyDecoded: true, wantErr: "invalid protobuf push request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := services.ParseLokiPush(tt.body, tt.contentType, tt.snappyDecoded, "loki")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePromLabels(t *testing.T) {
+	labels, err := services.ParsePromLabels(`{job="api", path="/a,b", quote="say \"hi\""}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]string{"job": "api", "path": "/a,b", "quote": `say "hi"`}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("labels = %v, want %v", labels, want)
+	}
+
+	for _, s := range []string{`job="api"`, `{job=api}`, `{job="api}`} {
+		if _, err := services.ParsePromLabels(s); err == nil {
+			t.Fatalf("expected an error for %s", s)
+		}
+	}
+}
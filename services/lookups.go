@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+)
+
+// MaxLookupEntries is the maximum number of entries in a lookup table
+const MaxLookupEntries = 100000
+
+// Lookup maps the values of a source field (a label column or data.* field) to another
+// value, e.g. service -> team. It is queried as dict.<name>.
+type Lookup struct {
+	Name    string            `json:"name"`
+	Source  string            `json:"source"`
+	Entries map[string]string `json:"entries,omitempty"`
+	Count   uint64            `json:"count"`
+}
+
+var (
+	lookupsMu sync.RWMutex
+	// lookupSources maps lookup name to its source field
+	lookupSources = map[string]string{}
+)
+
+// LoadLookups refreshes the lookup definitions used by the query builders
+func (s *Service) LoadLookups(ctx context.Context) error {
+	rows, err := s.queryRows(ctx, fmt.Sprintf("SELECT name, source FROM %s.lookup_definitions FINAL WHERE deleted = 0", db.Database))
+	if err != nil {
+		return fmt.Errorf("failed to load lookups: %w", err)
+	}
+	defer rows.Close()
+
+	sources := make(map[string]string)
+	for rows.Next() {
+		var name, source string
+		if err := rows.Scan(&name, &source); err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		sources[name] = source
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	lookupsMu.Lock()
+	lookupSources = sources
+	lookupsMu.Unlock()
+	return nil
+}
+
+// RunLookupRefresh reloads lookup definitions periodically, picking up changes made
+// through other instances
+func (s *Service) RunLookupRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.LoadLookups(ctx); err != nil {
+				log.Printf("lookup refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+// ListLookups returns every lookup with its entry count
+func (s *Service) ListLookups(ctx context.Context) ([]Lookup, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`
+		SELECT d.name, d.source, count(l.key)
+		FROM (SELECT name, source, version FROM %[1]s.lookup_definitions FINAL WHERE deleted = 0) AS d
+		LEFT JOIN %[1]s.lookups AS l ON l.name = d.name AND l.version = d.version
+		GROUP BY d.name, d.source
+		ORDER BY d.name
+	`, db.Database))
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	lookups := []Lookup{}
+	for rows.Next() {
+		var l Lookup
+		if err := rows.Scan(&l.Name, &l.Source, &l.Count); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		lookups = append(lookups, l)
+	}
+	return lookups, rows.Err()
+}
+
+// GetLookup returns a lookup with its entries, or nil if it doesn't exist
+func (s *Service) GetLookup(ctx context.Context, name string) (*Lookup, error) {
+	var l Lookup
+	var version uint64
+	err := s.queryRow(ctx, fmt.Sprintf("SELECT name, source, version FROM %s.lookup_definitions FINAL WHERE name = ? AND deleted = 0", db.Database), name).
+		Scan(&l.Name, &l.Source, &version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, fmt.Sprintf("SELECT key, value FROM %s.lookups WHERE name = ? AND version = ?", db.Database), name, version)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	l.Entries = make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		l.Entries[key] = value
+	}
+	l.Count = uint64(len(l.Entries))
+	return &l, rows.Err()
+}
+
+// PutLookup creates or replaces a lookup. The entries are written as a new version,
+// which becomes current once the definition points at it; older versions are deleted.
+func (s *Service) PutLookup(ctx context.Context, name, source string, entries map[string]string) error {
+	if !safeIdentifierRegex.MatchString(name) {
+		return fmt.Errorf("invalid lookup name: %s", name)
+	}
+	if _, err := buildFieldExpr(source); err != nil || strings.HasPrefix(source, "dict.") {
+		return fmt.Errorf("invalid lookup source: %s", source)
+	}
+	if len(entries) > MaxLookupEntries {
+		return fmt.Errorf("invalid lookup: too many entries (max %d)", MaxLookupEntries)
+	}
+
+	version := uint64(time.Now().UnixNano())
+	batch, err := s.store.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.lookups (name, version, key, value)", db.Database))
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+	for key, value := range entries {
+		if err := batch.Append(name, version, key, value); err != nil {
+			return fmt.Errorf("failed to append entry: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to write entries: %w", err)
+	}
+
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.lookup_definitions (name, source, version) VALUES (?, ?, ?)", db.Database), name, source, version); err != nil {
+		return fmt.Errorf("failed to write lookup: %w", err)
+	}
+	if err := s.store.Exec(ctx, fmt.Sprintf("ALTER TABLE %s.lookups DELETE WHERE name = ? AND version != ?", db.Database), name, version); err != nil {
+		log.Printf("failed to delete old versions of lookup %s: %v", name, err)
+	}
+
+	return s.reloadLookups(ctx)
+}
+
+// DeleteLookup removes a lookup; dict.<name> becomes an invalid field
+func (s *Service) DeleteLookup(ctx context.Context, name string) error {
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.lookup_definitions (name, source, version, deleted) VALUES (?, '', 0, 1)", db.Database), name); err != nil {
+		return fmt.Errorf("failed to delete lookup: %w", err)
+	}
+	if err := s.store.Exec(ctx, fmt.Sprintf("ALTER TABLE %s.lookups DELETE WHERE name = ?", db.Database), name); err != nil {
+		log.Printf("failed to delete entries of lookup %s: %v", name, err)
+	}
+
+	return s.reloadLookups(ctx)
+}
+
+// reloadLookups makes a change visible immediately instead of after the dictionary lifetime
+func (s *Service) reloadLookups(ctx context.Context) error {
+	if err := s.store.Exec(ctx, fmt.Sprintf("SYSTEM RELOAD DICTIONARY %s.lookups_dict", db.Database)); err != nil {
+		return fmt.Errorf("failed to reload lookups: %w", err)
+	}
+	return s.LoadLookups(ctx)
+}
+
+// buildLookupExpr builds the expression of a dict.<name> field: the lookup value of
+// the source field, or ” when it has no entry
+func buildLookupExpr(field string) (string, bool, error) {
+	name, ok := strings.CutPrefix(field, "dict.")
+	if !ok {
+		return "", false, nil
+	}
+
+	lookupsMu.RLock()
+	source, exists := lookupSources[name]
+	lookupsMu.RUnlock()
+	if !exists || !safeIdentifierRegex.MatchString(name) {
+		return "", true, fmt.Errorf("invalid lookup: %s", name)
+	}
+
+	sourceExpr, err := buildFieldExpr(source)
+	if err != nil {
+		return "", true, err
+	}
+	return fmt.Sprintf("dictGetOrDefault('%s.lookups_dict', 'value', tuple('%s', %s), '')", db.Database, name, sourceExpr), true, nil
+}
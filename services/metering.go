@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Request classes metered per key
+const (
+	UsageIngest = "ingest"
+	UsageQuery  = "query"
+	UsageExport = "export"
+)
+
+// KeyID identifies an API key in usage stats without storing it
+func KeyID(key string) string {
+	return hashAPIKey(key)[:12]
+}
+
+type usageKey struct {
+	keyID  string
+	class  string
+	minute time.Time
+}
+
+type usageCounts struct {
+	requests, errors, bytesIn, bytesOut uint64
+}
+
+var (
+	usageMu sync.Mutex
+	usage   = map[usageKey]*usageCounts{}
+)
+
+// RecordUsage counts a request made with a key; status >= 400 counts as an error
+func RecordUsage(keyID, class string, status int, bytesIn, bytesOut int64) {
+	k := usageKey{keyID: keyID, class: class, minute: time.Now().UTC().Truncate(time.Minute)}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	c, ok := usage[k]
+	if !ok {
+		c = &usageCounts{}
+		usage[k] = c
+	}
+	c.requests++
+	if status >= 400 {
+		c.errors++
+	}
+	c.bytesIn += uint64(max(bytesIn, 0))
+	c.bytesOut += uint64(max(bytesOut, 0))
+}
+
+// RunMetering writes the recorded usage every interval, and once more when ctx is done
+func (s *Service) RunMetering(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := s.flushUsage(flushCtx); err != nil {
+				log.Printf("usage flush failed: %v", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := s.flushUsage(ctx); err != nil {
+				log.Printf("usage flush failed: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Service) flushUsage(ctx context.Context) error {
+	usageMu.Lock()
+	pending := usage
+	usage = map[usageKey]*usageCounts{}
+	usageMu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	batch, err := s.store.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.key_usage (minute, key_id, class, requests, errors, bytes_in, bytes_out)", db.Database))
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+	for k, c := range pending {
+		if err := batch.Append(k.minute, k.keyID, k.class, c.requests, c.errors, c.bytesIn, c.bytesOut); err != nil {
+			return fmt.Errorf("failed to append usage: %w", err)
+		}
+	}
+	return batch.Send()
+}
+
+// KeyUsageBucket is the usage of a key in one interval
+type KeyUsageBucket struct {
+	Time           time.Time `json:"time"`
+	IngestRequests uint64    `json:"ingest_requests"`
+	QueryRequests  uint64    `json:"query_requests"`
+	ExportRequests uint64    `json:"export_requests"`
+	Errors         uint64    `json:"errors"`
+	ErrorRate      float64   `json:"error_rate"`
+	BytesIn        uint64    `json:"bytes_in"`
+	BytesOut       uint64    `json:"bytes_out"`
+}
+
+// KeyUsage is the usage of a key over a time range
+type KeyUsage struct {
+	KeyID    string           `json:"key_id"`
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Interval string           `json:"interval"`
+	LastSeen *time.Time       `json:"last_seen"`
+	Total    KeyUsageBucket   `json:"total"`
+	Buckets  []KeyUsageBucket `json:"buckets"`
+}
+
+// GetKeyUsage returns a key's usage over [from, to) in interval buckets (hour by default)
+func (s *Service) GetKeyUsage(ctx context.Context, keyID string, from, to time.Time, interval structs.IntervalType) (*KeyUsage, error) {
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	if from.IsZero() {
+		from = to.Add(-7 * 24 * time.Hour)
+	}
+	if interval == "" {
+		interval = structs.IntervalHour
+	}
+	bucketExpr, err := buildIntervalExpr(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &KeyUsage{KeyID: keyID, From: from, To: to, Interval: string(interval), Buckets: []KeyUsageBucket{}}
+
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`
+		SELECT
+			%s AS bucket,
+			sumIf(requests, class = 'ingest'),
+			sumIf(requests, class = 'query'),
+			sumIf(requests, class = 'export'),
+			sum(errors),
+			sum(bytes_in),
+			sum(bytes_out),
+			max(minute)
+		FROM (SELECT minute AS timestamp, minute, class, requests, errors, bytes_in, bytes_out FROM %s.key_usage WHERE key_id = ? AND minute >= ? AND minute < ?)
+		GROUP BY bucket
+		ORDER BY bucket
+	`, bucketExpr, db.Database), keyID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b KeyUsageBucket
+		var last time.Time
+		if err := rows.Scan(&b.Time, &b.IngestRequests, &b.QueryRequests, &b.ExportRequests, &b.Errors, &b.BytesIn, &b.BytesOut, &last); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		b.ErrorRate = errorRate(b)
+		result.Buckets = append(result.Buckets, b)
+
+		result.Total.IngestRequests += b.IngestRequests
+		result.Total.QueryRequests += b.QueryRequests
+		result.Total.ExportRequests += b.ExportRequests
+		result.Total.Errors += b.Errors
+		result.Total.BytesIn += b.BytesIn
+		result.Total.BytesOut += b.BytesOut
+		result.LastSeen = &last
+	}
+	result.Total.Time = from
+	result.Total.ErrorRate = errorRate(result.Total)
+	return result, rows.Err()
+}
+
+// KeySummary is the totals of one key, for spotting abusive or dead keys
+type KeySummary struct {
+	KeyID    string    `json:"key_id"`
+	Requests uint64    `json:"requests"`
+	Errors   uint64    `json:"errors"`
+	BytesIn  uint64    `json:"bytes_in"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// ListKeyUsage returns the totals of every key seen since from, busiest first
+func (s *Service) ListKeyUsage(ctx context.Context, from time.Time) ([]KeySummary, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`
+		SELECT key_id, sum(requests) AS total, sum(errors), sum(bytes_in), max(minute)
+		FROM %s.key_usage
+		WHERE minute >= ?
+		GROUP BY key_id
+		ORDER BY total DESC
+	`, db.Database), from)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []KeySummary{}
+	for rows.Next() {
+		var k KeySummary
+		if err := rows.Scan(&k.KeyID, &k.Requests, &k.Errors, &k.BytesIn, &k.LastSeen); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func errorRate(b KeyUsageBucket) float64 {
+	requests := b.IngestRequests + b.QueryRequests + b.ExportRequests
+	if requests == 0 {
+		return 0
+	}
+	return float64(b.Errors) / float64(requests)
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// natsTimeout bounds connecting, the handshake, and each write
+	natsTimeout = 10 * time.Second
+	// maxNATSPayload is the largest message accepted from the server
+	maxNATSPayload = 64 * 1024 * 1024
+)
+
+// natsConn is a minimal NATS client connection: enough of the core protocol to publish,
+// subscribe, and read messages, with the server's pings answered while reading
+type natsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// mu serializes writes, which come from the reader (PONG) and publishers
+	mu sync.Mutex
+}
+
+// natsMsg is a message read from a subscription
+type natsMsg struct {
+	subject string
+	reply   string
+	// status and description are set on status messages, such as 408 Request Timeout
+	status      int
+	description string
+	data        []byte
+}
+
+// dialNATS connects to a nats:// or tls:// URL and completes the handshake. TLS is also
+// used when the server requires it.
+func dialNATS(rawURL, name string) (*natsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats url: %w", err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("invalid nats url: the scheme must be nats or tls")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, natsTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats at %s: %w", addr, err)
+	}
+	c := &natsConn{conn: conn, r: bufio.NewReaderSize(conn, 64*1024)}
+	if err := c.handshake(u, name); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake reads the server's INFO, upgrades to TLS when asked, and sends CONNECT,
+// waiting for the PONG to its PING so authentication errors surface here
+func (c *natsConn) handshake(u *url.URL, name string) error {
+	c.conn.SetDeadline(time.Now().Add(natsTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	line, err := c.readLine()
+	if err != nil {
+		return fmt.Errorf("failed to read nats server info: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected nats greeting: %.64s", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		return fmt.Errorf("invalid nats server info: %w", err)
+	}
+
+	if u.Scheme == "tls" || info.TLSRequired {
+		tlsConn := tls.Client(c.conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("nats tls handshake failed: %w", err)
+		}
+		c.conn = tlsConn
+		c.r = bufio.NewReaderSize(tlsConn, 64*1024)
+	}
+
+	options := map[string]interface{}{
+		"verbose":       false,
+		"pedantic":      false,
+		"headers":       true,
+		"no_responders": true,
+		"name":          name,
+		"lang":          "go",
+		"version":       "1.0.0",
+		"protocol":      1,
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options["user"] = u.User.Username()
+			options["pass"] = password
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	if err := c.write([]byte("CONNECT " + string(connect) + "\r\nPING\r\n")); err != nil {
+		return err
+	}
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return fmt.Errorf("failed to read nats handshake: %w", err)
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats connect failed: %s", strings.Trim(line[len("-ERR"):], " '"))
+		}
+	}
+}
+
+// publish sends a message, with reply as its reply subject when set
+func (c *natsConn) publish(subject, reply string, data []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString("PUB " + subject + " ")
+	if reply != "" {
+		buf.WriteString(reply + " ")
+	}
+	buf.WriteString(strconv.Itoa(len(data)) + "\r\n")
+	buf.Write(data)
+	buf.WriteString("\r\n")
+	return c.write(buf.Bytes())
+}
+
+// subscribe subscribes to a subject under the subscription ID sid
+func (c *natsConn) subscribe(subject, sid string) error {
+	return c.write([]byte("SUB " + subject + " " + sid + "\r\n"))
+}
+
+// readMsg reads until the next message, answering pings along the way. It isn't safe for
+// concurrent use.
+func (c *natsConn) readMsg() (natsMsg, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return natsMsg{}, err
+		}
+
+		switch {
+		case line == "PING":
+			if err := c.write([]byte("PONG\r\n")); err != nil {
+				return natsMsg{}, err
+			}
+		case line == "PONG", line == "+OK", strings.HasPrefix(line, "INFO "):
+		case strings.HasPrefix(line, "-ERR"):
+			return natsMsg{}, fmt.Errorf("nats error: %s", strings.Trim(line[len("-ERR"):], " '"))
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			return c.readPayload(line)
+		default:
+			return natsMsg{}, fmt.Errorf("unexpected nats message: %.64s", line)
+		}
+	}
+}
+
+// readPayload reads the payload announced by a MSG or HMSG line:
+// MSG <subject> <sid> [reply] <bytes> or HMSG <subject> <sid> [reply] <header bytes> <bytes>
+func (c *natsConn) readPayload(line string) (natsMsg, error) {
+	fields := strings.Fields(line)
+	headers := fields[0] == "HMSG"
+	args := fields[1:]
+
+	sizes := 1
+	if headers {
+		sizes = 2
+	}
+	if len(args) != 2+sizes && len(args) != 3+sizes {
+		return natsMsg{}, fmt.Errorf("invalid nats message line: %.64s", line)
+	}
+	msg := natsMsg{subject: args[0]}
+	if len(args) == 3+sizes {
+		msg.reply = args[2]
+	}
+
+	total, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || total < 0 || total > maxNATSPayload {
+		return natsMsg{}, fmt.Errorf("invalid nats message size: %.64s", line)
+	}
+	headerSize := 0
+	if headers {
+		headerSize, err = strconv.Atoi(args[len(args)-2])
+		if err != nil || headerSize < 0 || headerSize > total {
+			return natsMsg{}, fmt.Errorf("invalid nats header size: %.64s", line)
+		}
+	}
+
+	payload := make([]byte, total+2)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return natsMsg{}, err
+	}
+	if headers {
+		// The first header line is NATS/1.0, followed by a status and description on status messages
+		status, _, _ := strings.Cut(string(payload[:headerSize]), "\r\n")
+		if parts := strings.SplitN(status, " ", 3); len(parts) >= 2 {
+			msg.status, _ = strconv.Atoi(parts[1])
+			if len(parts) == 3 {
+				msg.description = parts[2]
+			}
+		}
+	}
+	msg.data = payload[headerSize:total]
+	return msg, nil
+}
+
+// readLine reads a protocol line without its CRLF
+func (c *natsConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *natsConn) write(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// Close closes the connection
+func (c *natsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,469 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// natsInfo is the greeting of a nats-server 2.10 with JetStream enabled
+const natsInfo = `INFO {"server_id":"NCXDJ3Q5FM3QYVAV7WWQ6DBGCYUJQ2OHJXHQ5A4SBD4KZ5XJOEPSV6TQ","server_name":"test","version":"2.10.22","proto":1,"go":"go1.22.8","host":"0.0.0.0","port":4222,"headers":true,"max_payload":1048576,"jetstream":true,"client_id":5,"client_ip":"127.0.0.1"}` + "\r\n"
+
+// natsPeer is the server side of a client connection, replaying a recorded conversation
+type natsPeer struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// natsServer listens on a local port and returns its URL and the peer of the first
+// client to connect
+func natsServer(t *testing.T) (string, func() *natsPeer) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	return "nats://" + ln.Addr().String(), func() *natsPeer {
+		t.Helper()
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("failed to accept: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return &natsPeer{t: t, conn: conn, r: bufio.NewReader(conn)}
+	}
+}
+
+func (p *natsPeer) send(s string) {
+	p.t.Helper()
+	if _, err := p.conn.Write([]byte(s)); err != nil {
+		p.t.Fatalf("failed to send %q: %v", s, err)
+	}
+}
+
+func (p *natsPeer) readLine() string {
+	p.t.Helper()
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		p.t.Fatalf("failed to read from the client: %v", err)
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		p.t.Fatalf("line %q doesn't end in CRLF", line)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// expect reads a line and fails unless it starts with prefix
+func (p *natsPeer) expect(prefix string) string {
+	p.t.Helper()
+	line := p.readLine()
+	if !strings.HasPrefix(line, prefix) {
+		p.t.Fatalf("client sent %q, want %q", line, prefix)
+	}
+	return line
+}
+
+// readPub reads a PUB and its payload
+func (p *natsPeer) readPub() (subject, reply string, data []byte) {
+	p.t.Helper()
+	fields := strings.Fields(p.expect("PUB "))
+	if len(fields) != 3 && len(fields) != 4 {
+		p.t.Fatalf("invalid PUB line %q", strings.Join(fields, " "))
+	}
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		p.t.Fatalf("invalid PUB size %q", fields[len(fields)-1])
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(p.r, payload); err != nil {
+		p.t.Fatalf("failed to read PUB payload: %v", err)
+	}
+	if string(payload[size:]) != "\r\n" {
+		p.t.Fatalf("PUB payload %q isn't followed by CRLF", payload)
+	}
+	if len(fields) == 4 {
+		reply = fields[2]
+	}
+	return fields[1], reply, payload[:size]
+}
+
+// accept plays the server's half of the handshake and returns the client's CONNECT options
+func (p *natsPeer) accept() map[string]interface{} {
+	p.t.Helper()
+	p.send(natsInfo)
+	line := p.expect("CONNECT ")
+	var options map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &options); err != nil {
+		p.t.Fatalf("invalid CONNECT %q: %v", line, err)
+	}
+	p.expect("PING")
+	p.send("PONG\r\n")
+	return options
+}
+
+// msgLine formats a MSG, as the server delivers it to subscription 1
+func msgLine(subject, reply, data string) string {
+	if reply != "" {
+		subject += " 1 " + reply
+	} else {
+		subject += " 1"
+	}
+	return fmt.Sprintf("MSG %s %d\r\n%s\r\n", subject, len(data), data)
+}
+
+// dialAsync dials url in the background, since the handshake waits on the server
+func dialAsync(url string) <-chan *natsConn {
+	conns := make(chan *natsConn, 1)
+	go func() {
+		conn, err := dialNATS(url, "monitor-core")
+		if err != nil {
+			conns <- nil
+			return
+		}
+		conns <- conn
+	}()
+	return conns
+}
+
+func TestNATSHandshake(t *testing.T) {
+	url, accept := natsServer(t)
+	conns := dialAsync(strings.Replace(url, "nats://", "nats://ingest:s3cret@", 1))
+
+	options := accept().accept()
+	conn := <-conns
+	if conn == nil {
+		t.Fatal("dial failed")
+	}
+	defer conn.Close()
+
+	for key, want := range map[string]interface{}{"user": "ingest", "pass": "s3cret", "name": "monitor-core", "headers": true, "verbose": false} {
+		if options[key] != want {
+			t.Fatalf("CONNECT %s = %v, want %v", key, options[key], want)
+		}
+	}
+	if _, ok := options["auth_token"]; ok {
+		t.Fatal("CONNECT sent a token along with a user and password")
+	}
+}
+
+func TestNATSHandshakeToken(t *testing.T) {
+	url, accept := natsServer(t)
+	conns := dialAsync(strings.Replace(url, "nats://", "nats://t0ken@", 1))
+
+	options := accept().accept()
+	if conn := <-conns; conn != nil {
+		conn.Close()
+	}
+	if options["auth_token"] != "t0ken" || options["user"] != nil {
+		t.Fatalf("CONNECT = %v, want only auth_token", options)
+	}
+}
+
+func TestNATSHandshakeError(t *testing.T) {
+	url, accept := natsServer(t)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := dialNATS(url, "monitor-core")
+		errs <- err
+	}()
+
+	peer := accept()
+	peer.send(natsInfo)
+	peer.expect("CONNECT ")
+	peer.expect("PING")
+	peer.send("-ERR 'Authorization Violation'\r\n")
+
+	err := <-errs
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Fatalf("error = %v, want the server's authorization error", err)
+	}
+}
+
+func TestNATSPublishSubscribe(t *testing.T) {
+	url, accept := natsServer(t)
+	conns := dialAsync(url)
+	peer := accept()
+	peer.accept()
+	conn := <-conns
+	defer conn.Close()
+
+	if err := conn.subscribe("_INBOX.abc.*", "1"); err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	if line := peer.readLine(); line != "SUB _INBOX.abc.* 1" {
+		t.Fatalf("client sent %q", line)
+	}
+
+	if err := conn.publish("$JS.API.CONSUMER.MSG.NEXT.EVENTS.monitor", "_INBOX.abc.1", []byte(`{"batch":100}`)); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	subject, reply, data := peer.readPub()
+	if subject != "$JS.API.CONSUMER.MSG.NEXT.EVENTS.monitor" || reply != "_INBOX.abc.1" || string(data) != `{"batch":100}` {
+		t.Fatalf("PUB %s %s %q", subject, reply, data)
+	}
+
+	// A payload with CRLF in it is sent by its size
+	if err := conn.publish("events", "", []byte("a\r\nb")); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	subject, reply, data = peer.readPub()
+	if subject != "events" || reply != "" || string(data) != "a\r\nb" {
+		t.Fatalf("PUB %s %s %q", subject, reply, data)
+	}
+}
+
+func TestNATSReadMsg(t *testing.T) {
+	url, accept := natsServer(t)
+	conns := dialAsync(url)
+	peer := accept()
+	peer.accept()
+	conn := <-conns
+	defer conn.Close()
+
+	// Pings are answered and informational lines skipped on the way to a message
+	peer.send("PING\r\n+OK\r\n" + natsInfo)
+	peer.send(msgLine("_INBOX.abc.1", "$JS.ACK.EVENTS.monitor.1.7.7.1760529600000000000.3", "line one\r\nline two"))
+	m, err := conn.readMsg()
+	if err != nil {
+		t.Fatalf("readMsg failed: %v", err)
+	}
+	if line := peer.readLine(); line != "PONG" {
+		t.Fatalf("client answered PING with %q", line)
+	}
+	if m.subject != "_INBOX.abc.1" || m.reply != "$JS.ACK.EVENTS.monitor.1.7.7.1760529600000000000.3" || string(m.data) != "line one\r\nline two" || m.status != 0 {
+		t.Fatalf("message = %+v", m)
+	}
+
+	peer.send(msgLine("events", "", ""))
+	if m, err = conn.readMsg(); err != nil || m.subject != "events" || m.reply != "" || len(m.data) != 0 {
+		t.Fatalf("message = %+v, %v", m, err)
+	}
+
+	// Headers are split from the data, and a status line is parsed
+	headers := "NATS/1.0\r\nNats-Msg-Id: 42\r\n\r\n"
+	peer.send(fmt.Sprintf("HMSG events 1 $JS.ACK.x %d %d\r\n%s{}\r\n", len(headers), len(headers)+2, headers))
+	if m, err = conn.readMsg(); err != nil || m.reply != "$JS.ACK.x" || string(m.data) != "{}" || m.status != 0 {
+		t.Fatalf("message = %+v, %v", m, err)
+	}
+
+	status := "NATS/1.0 408 Request Timeout\r\n\r\n"
+	peer.send(fmt.Sprintf("HMSG _INBOX.abc.2 1 %d %d\r\n%s\r\n", len(status), len(status), status))
+	if m, err = conn.readMsg(); err != nil || m.status != 408 || m.description != "Request Timeout" || len(m.data) != 0 {
+		t.Fatalf("message = %+v, %v", m, err)
+	}
+
+	peer.send("-ERR 'Slow Consumer'\r\n")
+	if _, err = conn.readMsg(); err == nil || !strings.Contains(err.Error(), "Slow Consumer") {
+		t.Fatalf("error = %v, want the server's error", err)
+	}
+}
+
+func TestNATSReadMsgInvalid(t *testing.T) {
+	for _, line := range []string{
+		"MSG events 1\r\n",
+		"MSG events 1 x\r\n",
+		"MSG events 1 -1\r\n",
+		"MSG events 1 " + strconv.Itoa(maxNATSPayload+1) + "\r\n",
+		"HMSG events 1 10 5\r\n",
+		"BOGUS\r\n",
+	} {
+		t.Run(strings.TrimSpace(line), func(t *testing.T) {
+			url, accept := natsServer(t)
+			conns := dialAsync(url)
+			peer := accept()
+			peer.accept()
+			conn := <-conns
+			defer conn.Close()
+
+			peer.send(line)
+			if m, err := conn.readMsg(); err == nil {
+				t.Fatalf("read %+v, want an error", m)
+			}
+		})
+	}
+}
+
+// flakyWriter fails its first failures writes and keeps the events of the rest
+type flakyWriter struct {
+	mu       sync.Mutex
+	failures int
+	events   []*structs.Event
+}
+
+func (w *flakyWriter) WriteBatch(ctx context.Context, events []*structs.Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("clickhouse is down")
+	}
+	w.events = append(w.events, events...)
+	return nil
+}
+
+func TestJetStreamConsumer(t *testing.T) {
+	url, accept := natsServer(t)
+
+	writer := &flakyWriter{failures: 1}
+	p := pipeline.New(writer, pipeline.Config{FlushInterval: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+	defer p.Shutdown(context.Background())
+
+	consumer, err := NewJetStreamConsumer(p, JetStreamConfig{URL: url, Stream: "EVENTS", Subject: "events.>", Consumer: "monitor"})
+	if err != nil {
+		t.Fatalf("NewJetStreamConsumer failed: %v", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(stopped)
+	}()
+	defer consumer.Close()
+
+	peer := accept()
+	peer.accept()
+	sub := strings.Fields(peer.expect("SUB "))
+	if len(sub) != 3 || !strings.HasPrefix(sub[1], "_INBOX.") || !strings.HasSuffix(sub[1], ".*") {
+		t.Fatalf("client subscribed with %v, want an inbox wildcard", sub)
+	}
+	inbox := strings.TrimSuffix(sub[1], ".*")
+
+	// The durable consumer is created, and answered like the server does
+	subject, reply, data := peer.readPub()
+	if subject != "$JS.API.CONSUMER.DURABLE.CREATE.EVENTS.monitor" || reply != inbox+".0" {
+		t.Fatalf("PUB %s %s, want the consumer create request", subject, reply)
+	}
+	var create struct {
+		StreamName string                 `json:"stream_name"`
+		Config     map[string]interface{} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &create); err != nil {
+		t.Fatalf("invalid consumer create request %q: %v", data, err)
+	}
+	if create.StreamName != "EVENTS" || create.Config["durable_name"] != "monitor" || create.Config["ack_policy"] != "explicit" || create.Config["filter_subject"] != "events.>" {
+		t.Fatalf("consumer create request = %+v", create)
+	}
+	peer.send(msgLine(reply, "", `{"type":"io.nats.jetstream.api.v1.consumer_create_response","stream_name":"EVENTS","name":"monitor"}`))
+
+	subject, pull, data := peer.readPub()
+	if subject != "$JS.API.CONSUMER.MSG.NEXT.EVENTS.monitor" || pull != inbox+".1" {
+		t.Fatalf("PUB %s %s, want the first pull request", subject, pull)
+	}
+	if string(data) != `{"batch":100,"expires":30000000000}` {
+		t.Fatalf("pull request = %s", data)
+	}
+
+	// A message that isn't NDJSON events is terminated, since redelivery wouldn't help
+	peer.send(msgLine(pull, "$JS.ACK.EVENTS.monitor.1.1.1.1760529600000000000.2", "not json"))
+	if ackSubject, _, body := peer.readPub(); ackSubject != "$JS.ACK.EVENTS.monitor.1.1.1.1760529600000000000.2" || string(body) != "+TERM" {
+		t.Fatalf("PUB %s %q, want +TERM", ackSubject, body)
+	}
+
+	// The first write fails, so the message is nacked and the server redelivers it
+	events := `{"timestamp":"2026-10-15T12:00:00Z","service":"billing","name":"charge","level":"info"}` + "\n" +
+		`{"timestamp":"2026-10-15T12:00:01Z","service":"billing","name":"refund","level":"warn"}` + "\n"
+	first := "$JS.ACK.EVENTS.monitor.1.2.2.1760529601000000000.1"
+	peer.send(msgLine(pull, first, events))
+	if ackSubject, _, body := peer.readPub(); ackSubject != first || string(body) != "-NAK" {
+		t.Fatalf("PUB %s %q, want -NAK for the failed write", ackSubject, body)
+	}
+
+	redelivered := "$JS.ACK.EVENTS.monitor.2.2.3.1760529601000000000.0"
+	peer.send(msgLine(pull, redelivered, events))
+	if ackSubject, _, body := peer.readPub(); ackSubject != redelivered || string(body) != "+ACK" {
+		t.Fatalf("PUB %s %q, want +ACK for the redelivery", ackSubject, body)
+	}
+	writer.mu.Lock()
+	written := len(writer.events)
+	writer.mu.Unlock()
+	if written != 2 {
+		t.Fatalf("wrote %d events, want the 2 redelivered", written)
+	}
+
+	// An expired pull request ends the batch, and the next one is sent
+	status := "NATS/1.0 408 Request Timeout\r\n\r\n"
+	peer.send(fmt.Sprintf("HMSG %s 1 %d %d\r\n%s\r\n", pull, len(status), len(status), status))
+	if subject, reply, _ := peer.readPub(); subject != "$JS.API.CONSUMER.MSG.NEXT.EVENTS.monitor" || reply != inbox+".2" {
+		t.Fatalf("PUB %s %s, want the second pull request", subject, reply)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer didn't stop when the context was cancelled")
+	}
+}
+
+func TestJetStreamConsumerDeleted(t *testing.T) {
+	url, accept := natsServer(t)
+	p := pipeline.New(&flakyWriter{}, pipeline.Config{})
+	consumer, err := NewJetStreamConsumer(p, JetStreamConfig{URL: url, Stream: "EVENTS", Consumer: "monitor"})
+	if err != nil {
+		t.Fatalf("NewJetStreamConsumer failed: %v", err)
+	}
+	defer consumer.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := consumer.consume(context.Background())
+		errs <- err
+	}()
+
+	peer := accept()
+	peer.accept()
+	peer.expect("SUB ")
+	_, reply, _ := peer.readPub()
+	peer.send(msgLine(reply, "", `{"type":"io.nats.jetstream.api.v1.consumer_create_response"}`))
+	_, pull, _ := peer.readPub()
+
+	status := "NATS/1.0 409 Consumer Deleted\r\n\r\n"
+	peer.send(fmt.Sprintf("HMSG %s 1 %d %d\r\n%s\r\n", pull, len(status), len(status), status))
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "consumer monitor was deleted") {
+		t.Fatalf("error = %v, want the consumer deleted", err)
+	}
+}
+
+func TestJetStreamConsumerNotEnabled(t *testing.T) {
+	url, accept := natsServer(t)
+	p := pipeline.New(&flakyWriter{}, pipeline.Config{})
+	consumer, err := NewJetStreamConsumer(p, JetStreamConfig{URL: url, Stream: "EVENTS", Consumer: "monitor"})
+	if err != nil {
+		t.Fatalf("NewJetStreamConsumer failed: %v", err)
+	}
+	defer consumer.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := consumer.consume(context.Background())
+		errs <- err
+	}()
+
+	peer := accept()
+	peer.accept()
+	peer.expect("SUB ")
+	_, reply, _ := peer.readPub()
+	status := "NATS/1.0 503\r\n\r\n"
+	peer.send(fmt.Sprintf("HMSG %s 1 %d %d\r\n%s\r\n", reply, len(status), len(status), status))
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "jetstream is not enabled") {
+		t.Fatalf("error = %v, want jetstream not enabled", err)
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/google/uuid"
+)
+
+const (
+	// PayloadIDKey and PayloadSizeKey are the data keys pointing at an offloaded payload
+	PayloadIDKey   = "_payload_id"
+	PayloadSizeKey = "_payload_size"
+
+	// offloadPreviewSize is what large values are cut down to in the data column
+	offloadPreviewSize = 512
+	// offloadTimeout bounds the upload so a slow store can't stall ingestion for long
+	offloadTimeout = 10 * time.Second
+)
+
+var (
+	payloadStore     ObjectStore
+	payloadPrefix    string
+	offloadThreshold int
+)
+
+// EnablePayloadOffload stores the data of events over threshold bytes in the object store,
+// keeping previews and a pointer in the data column
+func EnablePayloadOffload(store ObjectStore, threshold int, prefix string) {
+	payloadStore = store
+	offloadThreshold = threshold
+	payloadPrefix = prefix
+}
+
+// PayloadOffloadEnabled reports whether an object store is configured
+func PayloadOffloadEnabled() bool {
+	return payloadStore != nil
+}
+
+// GetPayload returns the full data JSON of an offloaded event, with sensitive keys hidden
+// unless the request has the pii:read scope
+func GetPayload(ctx context.Context, id string) ([]byte, error) {
+	payload, err := payloadStore.Get(ctx, payloadKey(id))
+	if err != nil || len(sensitiveKeys) == 0 || HasScope(ctx, ScopePIIRead) {
+		return payload, err
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	MaskSensitiveData(ctx, data)
+	return json.Marshal(data)
+}
+
+// offloadPayload uploads the full data of a large event, then replaces large values with
+// previews and adds data._payload_id. On upload failure the event is left unchanged,
+// and the size limits still apply.
+func offloadPayload(event *structs.Event) {
+	if payloadStore == nil || offloadThreshold <= 0 || len(event.Data) == 0 {
+		return
+	}
+
+	full := event.DataJSON()
+	if len(full) <= offloadThreshold {
+		return
+	}
+
+	id := uuid.NewString()
+	ctx, cancel := context.WithTimeout(context.Background(), offloadTimeout)
+	defer cancel()
+
+	if err := payloadStore.Put(ctx, payloadKey(id), []byte(full), "application/json"); err != nil {
+		log.Printf("failed to offload payload for %s: %v", event.Name, err)
+		EmitInternal("payload.offload_failed", "error", map[string]interface{}{
+			"event": event.Name,
+			"size":  len(full),
+			"error": err.Error(),
+		})
+		return
+	}
+
+	for key, value := range event.Data {
+		if preview, ok := truncateValue(value, offloadPreviewSize); ok {
+			event.Data[key] = preview
+		}
+	}
+	event.Data[PayloadIDKey] = id
+	event.Data[PayloadSizeKey] = len(full)
+}
+
+func payloadKey(id string) string {
+	return payloadPrefix + id + ".json"
+}
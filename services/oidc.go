@@ -0,0 +1,428 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles of OIDC users, from most to least privileged
+const (
+	RoleAdmin = "admin"
+	RoleQuery = "query"
+)
+
+// roleRank orders roles so a user in several groups gets the most privileged role
+var roleRank = map[string]int{RoleAdmin: 2, RoleQuery: 1}
+
+// rolePriority ranks a role for picking among a user's groups: admin, then query, then
+// the access roles, which grant query restricted to their services, envs, and endpoints
+func rolePriority(role string) int {
+	if rank, ok := roleRank[role]; ok {
+		return rank + 1
+	}
+	if r, _ := LookupAccessRole(role); r != nil {
+		return 1
+	}
+	return 0
+}
+
+// jwksRefreshInterval bounds how often unknown key IDs trigger a JWKS refetch
+const jwksRefreshInterval = 5 * time.Minute
+
+// OIDCConfig configures login through an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// GroupsClaim is the ID token claim listing the user's groups
+	GroupsClaim string
+	// RoleMap maps provider groups to roles; users in no mapped group can't log in
+	RoleMap map[string]string
+}
+
+// OIDCProvider implements the authorization code flow (with PKCE) against a provider
+// found through OpenID discovery, verifying RS256 and ES256 ID tokens with its JWKS
+type OIDCProvider struct {
+	config   OIDCConfig
+	authURL  string
+	tokenURL string
+	jwksURL  string
+	client   *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// OIDCIdentity is the verified user from an ID token
+type OIDCIdentity struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email"`
+	Name    string   `json:"name"`
+	Groups  []string `json:"groups"`
+	Role    string   `json:"role"`
+}
+
+// SessionCookie holds the signed Session of a logged-in user
+const SessionCookie = "monitor_session"
+
+// Session is a logged-in OIDC user
+type Session struct {
+	OIDCIdentity
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// HasRole reports whether the session is unexpired and grants at least role
+func (s *Session) HasRole(role string) bool {
+	if s == nil || time.Now().Unix() >= s.ExpiresAt {
+		return false
+	}
+	rank := roleRank[s.Role]
+	if rolePriority(s.Role) == 1 {
+		rank = roleRank[RoleQuery]
+	}
+	return rank >= roleRank[role]
+}
+
+// NewOIDCProvider reads the provider's discovery document
+func NewOIDCProvider(ctx context.Context, config OIDCConfig) (*OIDCProvider, error) {
+	for group, role := range config.RoleMap {
+		if rolePriority(role) == 0 {
+			return nil, fmt.Errorf("unknown role %q for group %q (expected %s, %s, or an access role)", role, group, RoleAdmin, RoleQuery)
+		}
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	p := &OIDCProvider{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+
+	var discovery struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(ctx, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	if discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc discovery returned issuer %q, expected %q", discovery.Issuer, config.Issuer)
+	}
+	p.authURL, p.tokenURL, p.jwksURL = discovery.AuthURL, discovery.TokenURL, discovery.JWKSURL
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AuthCodeURL returns the provider login URL for a state, nonce, and PKCE verifier
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + q.Encode()
+}
+
+// Exchange trades an authorization code for the user's verified identity
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*OIDCIdentity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed: %s: %s", resp.Status, body)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	return p.verify(ctx, token.IDToken, nonce)
+}
+
+// verify checks an ID token's signature and claims and maps its groups to a role
+func (p *OIDCProvider) verify(ctx context.Context, idToken, nonce string) (*OIDCIdentity, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid id token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token signature: %w", err)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("invalid id token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 ||
+			!ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return nil, fmt.Errorf("invalid id token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported id token key type")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid id token claims: %w", err)
+	}
+	if claims["iss"] != p.config.Issuer {
+		return nil, fmt.Errorf("invalid id token issuer")
+	}
+	if !audienceContains(claims["aud"], p.config.ClientID) {
+		return nil, fmt.Errorf("invalid id token audience")
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().Unix() > int64(exp) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("invalid id token nonce")
+	}
+
+	identity := &OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+	identity.Role = p.role(identity.Groups)
+	if identity.Role == "" {
+		return nil, fmt.Errorf("user is not in a group with a role")
+	}
+	return identity, nil
+}
+
+// role returns the most privileged role of the user's groups
+func (p *OIDCProvider) role(groups []string) string {
+	best := ""
+	for _, g := range groups {
+		if role := p.config.RoleMap[g]; rolePriority(role) > rolePriority(best) {
+			best = role
+		}
+	}
+	return best
+}
+
+// key returns the signing key for a key ID, refetching the JWKS for unknown IDs
+// (at most every jwksRefreshInterval) to follow provider key rotation
+func (p *OIDCProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetched) > jwksRefreshInterval
+	p.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if stale {
+		if err := p.refreshKeys(ctx); err != nil {
+			return nil, err
+		}
+		p.mu.RLock()
+		key, ok = p.keys[kid]
+		p.mu.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown id token key %q", kid)
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURL, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if v == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RandomToken returns a random URL-safe token, for OIDC state, nonces, and PKCE verifiers
+func RandomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// SignValue serializes v and signs it with an HMAC, for tamper-proof cookies
+func SignValue(secret []byte, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifyValue checks a value produced by SignValue and decodes it into v
+func VerifyValue(secret []byte, signed string, v interface{}) error {
+	payload, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return errors.New("invalid signed value")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	expected := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return errors.New("invalid signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,584 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpDefaultService is the service of telemetry without a service.name resource
+// attribute, as named by the OpenTelemetry SDKs
+const otlpDefaultService = "unknown_service"
+
+// otlpEnvAttributes are checked in order to find the event env
+var otlpEnvAttributes = []string{"deployment.environment.name", "deployment.environment"}
+
+// otlpUserAttributes are checked in order to find the event user ID
+var otlpUserAttributes = []string{"user.id", "enduser.id"}
+
+// otlpLogRecord is a log record with the attributes of its resource and the name of its
+// instrumentation scope
+type otlpLogRecord struct {
+	resource       map[string]interface{}
+	scope          string
+	timestamp      time.Time
+	observed       time.Time
+	severityNumber int
+	severityText   string
+	body           interface{}
+	attributes     map[string]interface{}
+	traceID        string
+	spanID         string
+	eventName      string
+}
+
+// ParseOTLPLogs converts an OTLP/HTTP ExportLogsServiceRequest into events. JSON bodies
+// are decoded as OTLP/JSON, anything else as protobuf.
+func ParseOTLPLogs(body []byte, contentType string) ([]*structs.Event, error) {
+	var records []otlpLogRecord
+	var err error
+
+	if strings.HasPrefix(contentType, "application/json") {
+		records, err = parseOTLPLogsJSON(body)
+	} else {
+		records, err = parseOTLPLogsProto(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	events := make([]*structs.Event, 0, len(records))
+	for i, record := range records {
+		event := otlpLogToEvent(record, now)
+		if err := event.Validate(); err != nil {
+			return nil, fmt.Errorf("log record %d: %w", i+1, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// otlpLogToEvent maps the service.name and deployment environment resource attributes to
+// columns, and the remaining resource and log attributes into data
+func otlpLogToEvent(record otlpLogRecord, now time.Time) *structs.Event {
+	resource := make(map[string]interface{}, len(record.resource))
+	for k, v := range record.resource {
+		resource[k] = v
+	}
+	attributes := make(map[string]interface{}, len(record.attributes))
+	for k, v := range record.attributes {
+		attributes[k] = v
+	}
+
+	event := &structs.Event{
+		Timestamp: record.timestamp,
+		Service:   takeAttribute(resource, "service.name"),
+		Env:       takeAttribute(resource, otlpEnvAttributes...),
+		UserID:    takeAttribute(attributes, otlpUserAttributes...),
+		Name:      record.eventName,
+		Level:     otlpSeverityLevel(record.severityNumber, record.severityText),
+		TraceID:   structs.NormalizeID(record.traceID),
+	}
+	if event.Env == "" {
+		event.Env = takeAttribute(attributes, otlpEnvAttributes...)
+	}
+	if name := takeAttribute(attributes, "event.name"); event.Name == "" {
+		event.Name = name
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = record.observed
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = now
+	}
+	if event.Service == "" {
+		event.Service = otlpDefaultService
+	}
+	if event.Name == "" {
+		event.Name = "log"
+	}
+
+	data := make(map[string]interface{}, len(resource)+len(attributes)+3)
+	for _, attrs := range []map[string]interface{}{resource, attributes} {
+		for k, v := range attrs {
+			data[unsafeKeyChars.ReplaceAllString(k, "_")] = v
+		}
+	}
+	// Drop malformed trace IDs rather than rejecting the whole export
+	if event.TraceID != "" && !structs.IsValidID(event.TraceID) {
+		data["trace_id"] = event.TraceID
+		event.TraceID = ""
+	}
+	if record.spanID != "" {
+		data["span_id"] = record.spanID
+	}
+	if record.scope != "" {
+		data["otel_scope"] = record.scope
+	}
+	switch body := record.body.(type) {
+	case nil:
+	case string:
+		data["message"] = body
+	default:
+		data["body"] = body
+	}
+	event.Data = data
+
+	return event
+}
+
+// takeAttribute returns and removes the first non-empty attribute among keys
+func takeAttribute(attributes map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := attributes[k]; ok && v != nil && v != "" {
+			delete(attributes, k)
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// otlpSeverityLevel maps an OTLP severity number to a level (1-8 trace and debug, 9-12
+// info, 13-16 warn, 17-20 error, 21-24 fatal), falling back to the severity text
+func otlpSeverityLevel(number int, text string) string {
+	switch {
+	case number <= 0:
+		return strings.ToLower(text)
+	case number <= 8:
+		return "debug"
+	case number <= 12:
+		return "info"
+	case number <= 16:
+		return "warn"
+	case number <= 20:
+		return "error"
+	default:
+		return "fatal"
+	}
+}
+
+// otlpJSONKeyValue is an attribute in OTLP/JSON
+type otlpJSONKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJSONAnyValue `json:"value"`
+}
+
+// otlpJSONAnyValue is an attribute value or log body in OTLP/JSON, where 64-bit integers
+// are usually strings
+type otlpJSONAnyValue struct {
+	StringValue *string     `json:"stringValue"`
+	BoolValue   *bool       `json:"boolValue"`
+	IntValue    json.Number `json:"intValue"`
+	DoubleValue *float64    `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpJSONAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpJSONKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue *string `json:"bytesValue"`
+}
+
+// otlpJSONResource is a resource in OTLP/JSON
+type otlpJSONResource struct {
+	Attributes []otlpJSONKeyValue `json:"attributes"`
+}
+
+// otlpJSONScope is an instrumentation scope in OTLP/JSON
+type otlpJSONScope struct {
+	Name string `json:"name"`
+}
+
+// otlpJSONLogs is the OTLP/JSON form of an ExportLogsServiceRequest
+type otlpJSONLogs struct {
+	ResourceLogs []struct {
+		Resource  otlpJSONResource `json:"resource"`
+		ScopeLogs []struct {
+			Scope      otlpJSONScope `json:"scope"`
+			LogRecords []struct {
+				TimeUnixNano         json.Number        `json:"timeUnixNano"`
+				ObservedTimeUnixNano json.Number        `json:"observedTimeUnixNano"`
+				SeverityNumber       int                `json:"severityNumber"`
+				SeverityText         string             `json:"severityText"`
+				Body                 *otlpJSONAnyValue  `json:"body"`
+				Attributes           []otlpJSONKeyValue `json:"attributes"`
+				TraceID              string             `json:"traceId"`
+				SpanID               string             `json:"spanId"`
+				EventName            string             `json:"eventName"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+func parseOTLPLogsJSON(body []byte) ([]otlpLogRecord, error) {
+	var request otlpJSONLogs
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid OTLP/JSON logs request: %w", err)
+	}
+
+	var records []otlpLogRecord
+	for _, resourceLogs := range request.ResourceLogs {
+		resource, err := otlpJSONAttributes(resourceLogs.Resource.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			for i, log := range scopeLogs.LogRecords {
+				record := otlpLogRecord{
+					resource:       resource,
+					scope:          scopeLogs.Scope.Name,
+					severityNumber: log.SeverityNumber,
+					severityText:   log.SeverityText,
+					traceID:        strings.ToLower(log.TraceID),
+					spanID:         strings.ToLower(log.SpanID),
+					eventName:      log.EventName,
+				}
+				if record.timestamp, err = otlpJSONTime(log.TimeUnixNano); err != nil {
+					return nil, fmt.Errorf("log record %d: invalid timeUnixNano: %w", i+1, err)
+				}
+				if record.observed, err = otlpJSONTime(log.ObservedTimeUnixNano); err != nil {
+					return nil, fmt.Errorf("log record %d: invalid observedTimeUnixNano: %w", i+1, err)
+				}
+				if log.Body != nil {
+					if record.body, err = log.Body.value(); err != nil {
+						return nil, fmt.Errorf("log record %d: invalid body: %w", i+1, err)
+					}
+				}
+				if record.attributes, err = otlpJSONAttributes(log.Attributes); err != nil {
+					return nil, fmt.Errorf("log record %d: %w", i+1, err)
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+// otlpJSONTime converts a timestamp in nanoseconds, which is zero when unset
+func otlpJSONTime(n json.Number) (time.Time, error) {
+	if n == "" {
+		return time.Time{}, nil
+	}
+	ns, err := strconv.ParseUint(string(n), 10, 64)
+	if err != nil || ns == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(ns)).UTC(), nil
+}
+
+func otlpJSONAttributes(attributes []otlpJSONKeyValue) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(attributes))
+	for _, attribute := range attributes {
+		value, err := attribute.Value.value()
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", attribute.Key, err)
+		}
+		values[attribute.Key] = value
+	}
+	return values, nil
+}
+
+// value returns the set value: a string, bool, int64, float64, []interface{}, or
+// map[string]interface{}, with bytes base64-encoded, or nil when none is
+func (v *otlpJSONAnyValue) value() (interface{}, error) {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue, nil
+	case v.BoolValue != nil:
+		return *v.BoolValue, nil
+	case v.IntValue != "":
+		return strconv.ParseInt(string(v.IntValue), 10, 64)
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, nil
+	case v.ArrayValue != nil:
+		values := make([]interface{}, len(v.ArrayValue.Values))
+		for i := range v.ArrayValue.Values {
+			value, err := v.ArrayValue.Values[i].value()
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case v.KvlistValue != nil:
+		return otlpJSONAttributes(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		return *v.BytesValue, nil
+	}
+	return nil, nil
+}
+
+// parseOTLPLogsProto decodes an ExportLogsServiceRequest: resource_logs=1
+func parseOTLPLogsProto(body []byte) ([]otlpLogRecord, error) {
+	var records []otlpLogRecord
+	err := forEachField(body, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		resourceRecords, err := parseOTLPResourceLogs(value)
+		if err != nil {
+			return err
+		}
+		records = append(records, resourceRecords...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf logs request: %w", err)
+	}
+	return records, nil
+}
+
+// parseOTLPResourceLogs decodes a ResourceLogs: resource=1, scope_logs=2
+func parseOTLPResourceLogs(b []byte) ([]otlpLogRecord, error) {
+	var resource map[string]interface{}
+	var records []otlpLogRecord
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			attributes, err := parseOTLPResource(value)
+			if err != nil {
+				return err
+			}
+			resource = attributes
+		case 2:
+			scopeRecords, err := parseOTLPScopeLogs(value)
+			if err != nil {
+				return err
+			}
+			records = append(records, scopeRecords...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		records[i].resource = resource
+	}
+	return records, nil
+}
+
+// parseOTLPResource decodes the attributes of a Resource: attributes=1
+func parseOTLPResource(b []byte) (map[string]interface{}, error) {
+	attributes := make(map[string]interface{})
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		return parseOTLPKeyValue(value, attributes)
+	})
+	return attributes, err
+}
+
+// parseOTLPScopeName decodes the name of an InstrumentationScope: name=1
+func parseOTLPScopeName(b []byte) (string, error) {
+	var name string
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num == 1 && typ == protowire.BytesType {
+			name = string(value)
+		}
+		return nil
+	})
+	return name, err
+}
+
+// parseOTLPScopeLogs decodes a ScopeLogs: scope=1, log_records=2
+func parseOTLPScopeLogs(b []byte) ([]otlpLogRecord, error) {
+	var scope string
+	var records []otlpLogRecord
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			name, err := parseOTLPScopeName(value)
+			if err != nil {
+				return err
+			}
+			scope = name
+		case 2:
+			record, err := parseOTLPLogRecord(value)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		records[i].scope = scope
+	}
+	return records, nil
+}
+
+// parseOTLPLogRecord decodes a LogRecord: time_unix_nano=1, severity_number=2,
+// severity_text=3, body=5, attributes=6, trace_id=9, span_id=10, observed_time_unix_nano=11,
+// event_name=12
+func parseOTLPLogRecord(b []byte) (otlpLogRecord, error) {
+	record := otlpLogRecord{attributes: make(map[string]interface{})}
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 3:
+			record.severityText = string(value)
+		case 5:
+			body, err := parseOTLPAnyValue(value)
+			if err != nil {
+				return err
+			}
+			record.body = body
+		case 6:
+			return parseOTLPKeyValue(value, record.attributes)
+		case 9:
+			record.traceID = hex.EncodeToString(value)
+		case 10:
+			record.spanID = hex.EncodeToString(value)
+		case 12:
+			record.eventName = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return record, err
+	}
+
+	if err := forEachVarint(b, func(num protowire.Number, v uint64) {
+		if num == 2 {
+			record.severityNumber = int(int32(v))
+		}
+	}); err != nil {
+		return record, err
+	}
+
+	err = forEachFixed64(b, func(num protowire.Number, v uint64) {
+		if v == 0 {
+			return
+		}
+		switch num {
+		case 1:
+			record.timestamp = time.Unix(0, int64(v)).UTC()
+		case 11:
+			record.observed = time.Unix(0, int64(v)).UTC()
+		}
+	})
+	return record, err
+}
+
+// parseOTLPKeyValue decodes a KeyValue (key=1, value=2) into attributes
+func parseOTLPKeyValue(b []byte, attributes map[string]interface{}) error {
+	var key string
+	var value interface{}
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			parsed, err := parseOTLPAnyValue(v)
+			if err != nil {
+				return err
+			}
+			value = parsed
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	attributes[key] = value
+	return nil
+}
+
+// parseOTLPAnyValue decodes an AnyValue: string=1, bool=2, int=3, double=4, array=5,
+// kvlist=6, bytes=7. Values are converted like otlpJSONAnyValue.value.
+func parseOTLPAnyValue(b []byte) (interface{}, error) {
+	var value interface{}
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			value = string(v)
+		case 5:
+			values := []interface{}{}
+			err := forEachField(v, func(num protowire.Number, typ protowire.Type, item []byte) error {
+				if num != 1 || typ != protowire.BytesType {
+					return nil
+				}
+				parsed, err := parseOTLPAnyValue(item)
+				if err != nil {
+					return err
+				}
+				values = append(values, parsed)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			value = values
+		case 6:
+			values := make(map[string]interface{})
+			err := forEachField(v, func(num protowire.Number, typ protowire.Type, item []byte) error {
+				if num != 1 || typ != protowire.BytesType {
+					return nil
+				}
+				return parseOTLPKeyValue(item, values)
+			})
+			if err != nil {
+				return err
+			}
+			value = values
+		case 7:
+			value = base64.StdEncoding.EncodeToString(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := forEachVarint(b, func(num protowire.Number, v uint64) {
+		switch num {
+		case 2:
+			value = v != 0
+		case 3:
+			value = int64(v)
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	err = forEachFixed64(b, func(num protowire.Number, v uint64) {
+		if num == 4 {
+			value = math.Float64frombits(v)
+		}
+	})
+	return value, err
+}
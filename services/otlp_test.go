@@ -0,0 +1,212 @@
+package services_test
+
+import (
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// otlpTraceID is a trace ID as OTLP sends it, and as it is stored
+const (
+	otlpTraceID   = "5b8efff798038103d269b633813fc60c"
+	otlpTraceUUID = "5b8efff7-9803-8103-d269-b633813fc60c"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// otlpAttribute is a KeyValue with a string value
+func otlpAttribute(key, value string) protoField {
+	return protoSubMessage(6, protoString(1, key), protoSubMessage(2, protoString(1, value)))
+}
+
+func TestParseOTLPLogsProto(t *testing.T) {
+	start := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	body := protoMessage(protoSubMessage(1,
+		// Resource attributes are KeyValues at field 1
+		protoSubMessage(1,
+			protoSubMessage(1, protoString(1, "service.name"), protoSubMessage(2, protoString(1, "checkout"))),
+			protoSubMessage(1, protoString(1, "deployment.environment"), protoSubMessage(2, protoString(1, "prod"))),
+		),
+		protoSubMessage(2,
+			protoSubMessage(1, protoString(1, "io.opentelemetry.slf4j")),
+			protoSubMessage(2,
+				protoFixed64(1, uint64(start.UnixNano())),
+				protoVarint(2, 17),
+				protoString(3, "ERROR"),
+				protoSubMessage(5, protoString(1, "payment failed")),
+				otlpAttribute("user.id", "u-42"),
+				otlpAttribute("http.status_code", "502"),
+				protoSubMessage(6, protoString(1, "retries"), protoSubMessage(2, protoVarint(3, 3))),
+				protoBytes(9, mustHex(t, otlpTraceID)),
+				protoBytes(10, mustHex(t, "eee19b7ec3c1b174")),
+			),
+			// A record without a time falls back to its observed time
+			protoSubMessage(2,
+				protoFixed64(11, uint64(start.Add(time.Second).UnixNano())),
+				protoString(12, "cart.updated"),
+			),
+		),
+	))
+
+	events, err := services.ParseOTLPLogs(body, "application/x-protobuf")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+
+	event := events[0]
+	if !event.Timestamp.Equal(start) || event.Service != "checkout" || event.Env != "prod" || event.Level != "error" ||
+		event.UserID != "u-42" || event.TraceID != otlpTraceUUID || event.Name != "log" {
+		t.Fatalf("unexpected columns: %+v", event)
+	}
+	want := map[string]interface{}{
+		"http_status_code": "502",
+		"retries":          int64(3),
+		"span_id":          "eee19b7ec3c1b174",
+		"otel_scope":       "io.opentelemetry.slf4j",
+		"message":          "payment failed",
+	}
+	if !reflect.DeepEqual(event.Data, want) {
+		t.Fatalf("data = %#v, want %#v", event.Data, want)
+	}
+
+	if !events[1].Timestamp.Equal(start.Add(time.Second)) || events[1].Name != "cart.updated" {
+		t.Fatalf("unexpected second event: %+v", events[1])
+	}
+}
+
+func TestParseOTLPLogsJSON(t *testing.T) {
+	body := `{"resourceLogs": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "search"}}]},
+		"scopeLogs": [{"logRecords": [{
+			"timeUnixNano": "1736935200000000000",
+			"severityNumber": 13,
+			"body": {"kvlistValue": {"values": [{"key": "query", "value": {"stringValue": "shoes"}}]}},
+			"attributes": [
+				{"key": "deployment.environment.name", "value": {"stringValue": "staging"}},
+				{"key": "took_ms", "value": {"intValue": "3012"}}
+			],
+			"traceId": "` + strings.ToUpper(otlpTraceID) + `"
+		}]}]
+	}]}`
+
+	events, err := services.ParseOTLPLogs([]byte(body), "application/json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	event := events[0]
+	if event.Service != "search" || event.Env != "staging" || event.Level != "warn" || event.TraceID != otlpTraceUUID {
+		t.Fatalf("unexpected columns: %+v", event)
+	}
+	if event.Data["took_ms"] != int64(3012) {
+		t.Fatalf("data.took_ms = %#v, want int64 3012", event.Data["took_ms"])
+	}
+	if body, ok := event.Data["body"].(map[string]interface{}); !ok || body["query"] != "shoes" {
+		t.Fatalf("data.body = %#v, want the kvlist body", event.Data["body"])
+	}
+}
+
+func TestParseOTLPTracesJSON(t *testing.T) {
+	body := `{"resourceSpans": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+		"scopeSpans": [{"spans": [{
+			"traceId": "` + otlpTraceID + `",
+			"spanId": "EEE19B7EC3C1B174",
+			"parentSpanId": "eee19b7ec3c1b173",
+			"name": "GET /users",
+			"kind": 2,
+			"startTimeUnixNano": "1736935200000000000",
+			"endTimeUnixNano": "1736935200250000000",
+			"events": [{"timeUnixNano": "1736935200100000000", "name": "cache.miss"}],
+			"status": {"code": 2, "message": "upstream timeout"}
+		}]}]
+	}]}`
+
+	events, err := services.ParseOTLPTraces([]byte(body), "application/json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	event := events[0]
+	if event.Service != "api" || event.Name != "GET /users" || event.Level != "error" || event.TraceID != otlpTraceUUID {
+		t.Fatalf("unexpected columns: %+v", event)
+	}
+	for key, want := range map[string]interface{}{
+		"span_id":        "eee19b7ec3c1b174",
+		"parent_span_id": "eee19b7ec3c1b173",
+		"duration_ms":    250.0,
+		"span_kind":      "server",
+		"status":         "error",
+		"status_message": "upstream timeout",
+	} {
+		if got := event.Data[key]; got != want {
+			t.Fatalf("data.%s = %#v, want %#v", key, got, want)
+		}
+	}
+	if spanEvents, ok := event.Data["span_events"].([]interface{}); !ok || len(spanEvents) != 1 {
+		t.Fatalf("data.span_events = %#v, want one event", event.Data["span_events"])
+	}
+}
+
+func TestParseOTLPTracesProto(t *testing.T) {
+	start := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	body := protoMessage(protoSubMessage(1,
+		protoSubMessage(2,
+			protoSubMessage(2,
+				protoBytes(1, mustHex(t, otlpTraceID)),
+				protoBytes(2, mustHex(t, "eee19b7ec3c1b174")),
+				protoString(5, "SELECT orders"),
+				protoVarint(6, 3),
+				protoFixed64(7, uint64(start.UnixNano())),
+				protoFixed64(8, uint64(start.Add(1500*time.Microsecond).UnixNano())),
+			),
+		),
+	))
+
+	events, err := services.ParseOTLPTraces(body, "application/x-protobuf")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	event := events[0]
+	if event.Service != "unknown_service" || event.Name != "SELECT orders" || event.Level != "info" || !event.Timestamp.Equal(start) {
+		t.Fatalf("unexpected columns: %+v", event)
+	}
+	if event.Data["span_kind"] != "client" || event.Data["status"] != "unset" || math.Abs(event.Data["duration_ms"].(float64)-1.5) > 1e-9 {
+		t.Fatalf("unexpected data: %v", event.Data)
+	}
+}
+
+func TestParseOTLPErrors(t *testing.T) {
+	if _, err := services.ParseOTLPLogs([]byte(`{"resourceLogs":`), "application/json"); err == nil || !strings.Contains(err.Error(), "invalid OTLP/JSON logs request") {
+		t.Fatalf("logs error = %v", err)
+	}
+	if _, err := services.ParseOTLPTraces([]byte(`{"resourceSpans":`), "application/json"); err == nil || !strings.Contains(err.Error(), "invalid OTLP/JSON traces request") {
+		t.Fatalf("traces error = %v", err)
+	}
+	truncated := protoMessage(protoSubMessage(1, protoSubMessage(2, protoString(2, "x"))))
+	if _, err := services.ParseOTLPLogs(truncated[:len(truncated)-1], "application/x-protobuf"); err == nil {
+		t.Fatal("expected an error for a truncated protobuf body")
+	}
+}
@@ -0,0 +1,448 @@
+package services
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// spanDurationField is the data key of the span duration in milliseconds
+const spanDurationField = "data.duration_ms"
+
+// spanCondition matches spans: the events with a span ID
+const spanCondition = "JSONHas(data, 'span_id')"
+
+// otlpSpanKinds names the OTLP SpanKind values
+var otlpSpanKinds = []string{"unspecified", "internal", "server", "client", "producer", "consumer"}
+
+// otlpStatusCodes names the OTLP Status codes
+var otlpStatusCodes = []string{"unset", "ok", "error"}
+
+// otlpSpan is a span with the attributes of its resource and the name of its
+// instrumentation scope
+type otlpSpan struct {
+	resource      map[string]interface{}
+	scope         string
+	traceID       string
+	spanID        string
+	parentSpanID  string
+	traceState    string
+	name          string
+	kind          int
+	start         time.Time
+	end           time.Time
+	attributes    map[string]interface{}
+	events        []interface{}
+	statusCode    int
+	statusMessage string
+}
+
+// ParseOTLPTraces converts an OTLP/HTTP ExportTraceServiceRequest into events, one per
+// span. JSON bodies are decoded as OTLP/JSON, anything else as protobuf.
+func ParseOTLPTraces(body []byte, contentType string) ([]*structs.Event, error) {
+	var spans []otlpSpan
+	var err error
+
+	if strings.HasPrefix(contentType, "application/json") {
+		spans, err = parseOTLPTracesJSON(body)
+	} else {
+		spans, err = parseOTLPTracesProto(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	events := make([]*structs.Event, 0, len(spans))
+	for i, span := range spans {
+		event := otlpSpanToEvent(span, now)
+		if err := event.Validate(); err != nil {
+			return nil, fmt.Errorf("span %d: %w", i+1, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// otlpSpanToEvent maps a span to an event named after the span at its start time, with
+// the span and parent span IDs, duration, kind, and status in data. Spans with an error
+// status are logged at error, the rest at info.
+func otlpSpanToEvent(span otlpSpan, now time.Time) *structs.Event {
+	resource := make(map[string]interface{}, len(span.resource))
+	for k, v := range span.resource {
+		resource[k] = v
+	}
+	attributes := make(map[string]interface{}, len(span.attributes))
+	for k, v := range span.attributes {
+		attributes[k] = v
+	}
+
+	event := &structs.Event{
+		Timestamp: span.start,
+		Service:   takeAttribute(resource, "service.name"),
+		Env:       takeAttribute(resource, otlpEnvAttributes...),
+		UserID:    takeAttribute(attributes, otlpUserAttributes...),
+		Name:      span.name,
+		Level:     "info",
+		TraceID:   structs.NormalizeID(span.traceID),
+	}
+	if event.Env == "" {
+		event.Env = takeAttribute(attributes, otlpEnvAttributes...)
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = now
+	}
+	if event.Service == "" {
+		event.Service = otlpDefaultService
+	}
+	if event.Name == "" {
+		event.Name = "span"
+	}
+	if span.statusCode == 2 {
+		event.Level = "error"
+	}
+
+	data := make(map[string]interface{}, len(resource)+len(attributes)+10)
+	for _, attrs := range []map[string]interface{}{resource, attributes} {
+		for k, v := range attrs {
+			data[unsafeKeyChars.ReplaceAllString(k, "_")] = v
+		}
+	}
+	// Drop malformed trace IDs rather than rejecting the whole export
+	if event.TraceID != "" && !structs.IsValidID(event.TraceID) {
+		data["trace_id"] = event.TraceID
+		event.TraceID = ""
+	}
+	if span.spanID != "" {
+		data["span_id"] = span.spanID
+	}
+	if span.parentSpanID != "" {
+		data["parent_span_id"] = span.parentSpanID
+	}
+	if span.traceState != "" {
+		data["trace_state"] = span.traceState
+	}
+	if !span.start.IsZero() && !span.end.Before(span.start) {
+		data["duration_ms"] = float64(span.end.Sub(span.start)) / float64(time.Millisecond)
+	}
+	data["span_kind"] = otlpEnumName(otlpSpanKinds, span.kind)
+	data["status"] = otlpEnumName(otlpStatusCodes, span.statusCode)
+	if span.statusMessage != "" {
+		data["status_message"] = span.statusMessage
+	}
+	if span.scope != "" {
+		data["otel_scope"] = span.scope
+	}
+	if len(span.events) > 0 {
+		data["span_events"] = span.events
+	}
+	event.Data = data
+
+	return event
+}
+
+// otlpEnumName returns the name of an enum value, or the number when it is unknown
+func otlpEnumName(names []string, value int) string {
+	if value >= 0 && value < len(names) {
+		return names[value]
+	}
+	return fmt.Sprint(value)
+}
+
+// otlpSpanEvent returns a span event as it is stored in span_events
+func otlpSpanEvent(name string, timestamp time.Time, attributes map[string]interface{}) map[string]interface{} {
+	event := map[string]interface{}{"name": name}
+	if !timestamp.IsZero() {
+		event["timestamp"] = timestamp
+	}
+	if len(attributes) > 0 {
+		event["attributes"] = attributes
+	}
+	return event
+}
+
+// otlpJSONTraces is the OTLP/JSON form of an ExportTraceServiceRequest
+type otlpJSONTraces struct {
+	ResourceSpans []struct {
+		Resource   otlpJSONResource `json:"resource"`
+		ScopeSpans []struct {
+			Scope otlpJSONScope `json:"scope"`
+			Spans []struct {
+				TraceID           string             `json:"traceId"`
+				SpanID            string             `json:"spanId"`
+				ParentSpanID      string             `json:"parentSpanId"`
+				TraceState        string             `json:"traceState"`
+				Name              string             `json:"name"`
+				Kind              int                `json:"kind"`
+				StartTimeUnixNano json.Number        `json:"startTimeUnixNano"`
+				EndTimeUnixNano   json.Number        `json:"endTimeUnixNano"`
+				Attributes        []otlpJSONKeyValue `json:"attributes"`
+				Events            []struct {
+					TimeUnixNano json.Number        `json:"timeUnixNano"`
+					Name         string             `json:"name"`
+					Attributes   []otlpJSONKeyValue `json:"attributes"`
+				} `json:"events"`
+				Status struct {
+					Message string `json:"message"`
+					Code    int    `json:"code"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func parseOTLPTracesJSON(body []byte) ([]otlpSpan, error) {
+	var request otlpJSONTraces
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid OTLP/JSON traces request: %w", err)
+	}
+
+	var spans []otlpSpan
+	for _, resourceSpans := range request.ResourceSpans {
+		resource, err := otlpJSONAttributes(resourceSpans.Resource.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for i, s := range scopeSpans.Spans {
+				span := otlpSpan{
+					resource:      resource,
+					scope:         scopeSpans.Scope.Name,
+					traceID:       strings.ToLower(s.TraceID),
+					spanID:        strings.ToLower(s.SpanID),
+					parentSpanID:  strings.ToLower(s.ParentSpanID),
+					traceState:    s.TraceState,
+					name:          s.Name,
+					kind:          s.Kind,
+					statusCode:    s.Status.Code,
+					statusMessage: s.Status.Message,
+				}
+				if span.start, err = otlpJSONTime(s.StartTimeUnixNano); err != nil {
+					return nil, fmt.Errorf("span %d: invalid startTimeUnixNano: %w", i+1, err)
+				}
+				if span.end, err = otlpJSONTime(s.EndTimeUnixNano); err != nil {
+					return nil, fmt.Errorf("span %d: invalid endTimeUnixNano: %w", i+1, err)
+				}
+				if span.attributes, err = otlpJSONAttributes(s.Attributes); err != nil {
+					return nil, fmt.Errorf("span %d: %w", i+1, err)
+				}
+				for _, e := range s.Events {
+					timestamp, err := otlpJSONTime(e.TimeUnixNano)
+					if err != nil {
+						return nil, fmt.Errorf("span %d: event %s: invalid timeUnixNano: %w", i+1, e.Name, err)
+					}
+					attributes, err := otlpJSONAttributes(e.Attributes)
+					if err != nil {
+						return nil, fmt.Errorf("span %d: event %s: %w", i+1, e.Name, err)
+					}
+					span.events = append(span.events, otlpSpanEvent(e.Name, timestamp, attributes))
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+	return spans, nil
+}
+
+// parseOTLPTracesProto decodes an ExportTraceServiceRequest: resource_spans=1
+func parseOTLPTracesProto(body []byte) ([]otlpSpan, error) {
+	var spans []otlpSpan
+	err := forEachField(body, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		resourceSpans, err := parseOTLPResourceSpans(value)
+		if err != nil {
+			return err
+		}
+		spans = append(spans, resourceSpans...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf traces request: %w", err)
+	}
+	return spans, nil
+}
+
+// parseOTLPResourceSpans decodes a ResourceSpans: resource=1, scope_spans=2
+func parseOTLPResourceSpans(b []byte) ([]otlpSpan, error) {
+	var resource map[string]interface{}
+	var spans []otlpSpan
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			attributes, err := parseOTLPResource(value)
+			if err != nil {
+				return err
+			}
+			resource = attributes
+		case 2:
+			scopeSpans, err := parseOTLPScopeSpans(value)
+			if err != nil {
+				return err
+			}
+			spans = append(spans, scopeSpans...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range spans {
+		spans[i].resource = resource
+	}
+	return spans, nil
+}
+
+// parseOTLPScopeSpans decodes a ScopeSpans: scope=1, spans=2
+func parseOTLPScopeSpans(b []byte) ([]otlpSpan, error) {
+	var scope string
+	var spans []otlpSpan
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			name, err := parseOTLPScopeName(value)
+			if err != nil {
+				return err
+			}
+			scope = name
+		case 2:
+			span, err := parseOTLPSpan(value)
+			if err != nil {
+				return err
+			}
+			spans = append(spans, span)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range spans {
+		spans[i].scope = scope
+	}
+	return spans, nil
+}
+
+// parseOTLPSpan decodes a Span: trace_id=1, span_id=2, trace_state=3, parent_span_id=4,
+// name=5, kind=6, start_time_unix_nano=7, end_time_unix_nano=8, attributes=9, events=11,
+// status=15
+func parseOTLPSpan(b []byte) (otlpSpan, error) {
+	span := otlpSpan{attributes: make(map[string]interface{})}
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			span.traceID = hex.EncodeToString(value)
+		case 2:
+			span.spanID = hex.EncodeToString(value)
+		case 3:
+			span.traceState = string(value)
+		case 4:
+			span.parentSpanID = hex.EncodeToString(value)
+		case 5:
+			span.name = string(value)
+		case 9:
+			return parseOTLPKeyValue(value, span.attributes)
+		case 11:
+			event, err := parseOTLPSpanEvent(value)
+			if err != nil {
+				return err
+			}
+			span.events = append(span.events, event)
+		case 15:
+			return parseOTLPStatus(value, &span)
+		}
+		return nil
+	})
+	if err != nil {
+		return span, err
+	}
+
+	if err := forEachVarint(b, func(num protowire.Number, v uint64) {
+		if num == 6 {
+			span.kind = int(int32(v))
+		}
+	}); err != nil {
+		return span, err
+	}
+
+	err = forEachFixed64(b, func(num protowire.Number, v uint64) {
+		if v == 0 {
+			return
+		}
+		switch num {
+		case 7:
+			span.start = time.Unix(0, int64(v)).UTC()
+		case 8:
+			span.end = time.Unix(0, int64(v)).UTC()
+		}
+	})
+	return span, err
+}
+
+// parseOTLPSpanEvent decodes a Span.Event: time_unix_nano=1, name=2, attributes=3
+func parseOTLPSpanEvent(b []byte) (map[string]interface{}, error) {
+	var name string
+	attributes := make(map[string]interface{})
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 2:
+			name = string(value)
+		case 3:
+			return parseOTLPKeyValue(value, attributes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var timestamp time.Time
+	err = forEachFixed64(b, func(num protowire.Number, v uint64) {
+		if num == 1 && v != 0 {
+			timestamp = time.Unix(0, int64(v)).UTC()
+		}
+	})
+	return otlpSpanEvent(name, timestamp, attributes), err
+}
+
+// parseOTLPStatus decodes a Status (message=2, code=3) into span
+func parseOTLPStatus(b []byte, span *otlpSpan) error {
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num == 2 && typ == protowire.BytesType {
+			span.statusMessage = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return forEachVarint(b, func(num protowire.Number, v uint64) {
+		if num == 3 {
+			span.statusCode = int(int32(v))
+		}
+	})
+}
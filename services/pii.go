@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// ScopePIIRead lets an access role read sensitive data keys
+const ScopePIIRead = "pii:read"
+
+// How sensitive data keys are hidden from callers without pii:read
+const (
+	SensitiveMask = "mask"
+	SensitiveOmit = "omit"
+)
+
+var (
+	sensitiveKeys = map[string]bool{}
+	sensitiveMode = SensitiveMask
+)
+
+// ConfigureSensitiveKeys sets the top-level data keys (with or without the data. prefix)
+// that are masked or omitted for callers without the pii:read scope
+func ConfigureSensitiveKeys(keys []string, mode string) error {
+	if mode != SensitiveMask && mode != SensitiveOmit {
+		return fmt.Errorf("invalid sensitive key mode %q (expected %s or %s)", mode, SensitiveMask, SensitiveOmit)
+	}
+	configured := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, "data.")
+		if !safeIdentifierRegex.MatchString(key) {
+			return fmt.Errorf("invalid sensitive key: %s", key)
+		}
+		configured[key] = true
+	}
+	sensitiveKeys = configured
+	sensitiveMode = mode
+	return nil
+}
+
+// HasScope reports whether the request may use scope. Requests without an access role
+// (API_KEY, keys without a role, and the admin and query OIDC roles) have every scope.
+func HasScope(ctx context.Context, scope string) bool {
+	role := AccessRoleFromContext(ctx)
+	return role == nil || slices.Contains(role.Scopes, scope)
+}
+
+// MaskSensitiveData masks or omits the sensitive keys of event data in place, unless the
+// request has the pii:read scope
+func MaskSensitiveData(ctx context.Context, data map[string]interface{}) {
+	if len(sensitiveKeys) == 0 || HasScope(ctx, ScopePIIRead) {
+		return
+	}
+	for key := range data {
+		if !sensitiveKeys[key] {
+			continue
+		}
+		if sensitiveMode == SensitiveOmit {
+			delete(data, key)
+		} else {
+			data[key] = DefaultRedactionMask
+		}
+	}
+}
+
+// checkSensitiveFields rejects queries that read sensitive keys through an aggregation,
+// group by, or filter without the pii:read scope; their results would reveal the values
+func checkSensitiveFields(ctx context.Context, fields []string, filters []structs.QueryFilter) error {
+	if len(sensitiveKeys) == 0 || HasScope(ctx, ScopePIIRead) {
+		return nil
+	}
+	for _, f := range filters {
+		fields = append(fields, f.Field)
+	}
+	for _, field := range fields {
+		if sensitiveField(field) {
+			return fmt.Errorf("invalid field %s: reading it requires the %s scope", field, ScopePIIRead)
+		}
+	}
+	return nil
+}
+
+// checkSensitiveParams rejects event query filters on sensitive keys without the pii:read scope
+func checkSensitiveParams(ctx context.Context, params QueryParams) error {
+	var fields []string
+	for _, f := range params.Filters {
+		if f.IsData {
+			fields = append(fields, "data."+f.Field)
+		}
+	}
+	return checkSensitiveFields(ctx, fields, params.QueryFilters)
+}
+
+// sensitiveField reports whether a field reads a sensitive key, directly, through a
+// numeric bucket, or as the source of a lookup
+func sensitiveField(field string) bool {
+	if m := bucketGroupRegex.FindStringSubmatch(field); m != nil {
+		field = m[1]
+	}
+	if name, ok := strings.CutPrefix(field, "dict."); ok {
+		lookupsMu.RLock()
+		field = lookupSources[name]
+		lookupsMu.RUnlock()
+	}
+	key, ok := strings.CutPrefix(field, "data.")
+	return ok && sensitiveKeys[key]
+}
@@ -0,0 +1,507 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Pipeline rule types
+const (
+	// RuleEnrich sets a data field from a derived field expression
+	RuleEnrich = "enrich"
+	// RuleSample keeps a fraction of the matching events
+	RuleSample = "sample"
+	// RuleRedact removes or masks data keys
+	RuleRedact = "redact"
+	// RuleRoute changes the env, which picks the ENV_ROUTES table the event is written to
+	RuleRoute = "route"
+)
+
+// ErrSampledOut is returned for events dropped by a sample rule
+var ErrSampledOut = errors.New("event sampled out")
+
+// PipelineRule is an ingest rule managed through the admin API. Rules run on live and
+// backfilled events after level normalization and DERIVED_FIELDS, by ascending order and
+// then name; sample rules only drop live events.
+type PipelineRule struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Match selects the events the rule applies to, with the query filter operators;
+	// an empty match applies to every event
+	Match    []structs.QueryFilter `json:"match,omitempty"`
+	Order    int                   `json:"order,omitempty"`
+	Disabled bool                  `json:"disabled,omitempty"`
+
+	// Field and Expression are the data.<key> an enrich rule sets and its expression
+	Field      string `json:"field,omitempty"`
+	Expression string `json:"expression,omitempty"`
+	// Rate is the fraction of matching events a sample rule keeps (0 drops them all).
+	// Events with a trace_id are kept or dropped with the rest of their trace.
+	Rate *float64 `json:"rate,omitempty"`
+	// Keys are the data keys (a.b for nested ones) a redact rule removes, or replaces with
+	// Replacement when it is set
+	Keys        []string `json:"keys,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+	// Env is the env a route rule writes the event as
+	Env string `json:"env,omitempty"`
+
+	Version   uint32    `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	expr deriveExpr
+}
+
+// PipelineTestResult is what the rules would do to a sample event
+type PipelineTestResult struct {
+	Event *structs.Event `json:"event"`
+	// Applied are the rules that matched the event, in the order they ran
+	Applied []string `json:"applied"`
+	// KeepRate is the chance the event is kept by the matching sample rules
+	KeepRate float64 `json:"keep_rate"`
+	Dropped  bool    `json:"dropped"`
+}
+
+var (
+	pipelineRulesMu sync.RWMutex
+	// pipelineRules are the enabled rules in the order they run
+	pipelineRules []*PipelineRule
+)
+
+// LoadPipelineRules refreshes the rules applied to ingested events
+func (s *Service) LoadPipelineRules(ctx context.Context) error {
+	rules, err := s.ListPipelineRules(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load pipeline rules: %w", err)
+	}
+
+	active := make([]*PipelineRule, 0, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Disabled {
+			continue
+		}
+		if err := checkPipelineRule(rule); err != nil {
+			log.Printf("skipping invalid pipeline rule %s: %v", rule.Name, err)
+			continue
+		}
+		active = append(active, rule)
+	}
+	sortPipelineRules(active)
+
+	pipelineRulesMu.Lock()
+	pipelineRules = active
+	pipelineRulesMu.Unlock()
+	return nil
+}
+
+// RunPipelineRuleRefresh reloads the rules periodically, picking up changes made through
+// other instances
+func (s *Service) RunPipelineRuleRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.LoadPipelineRules(ctx); err != nil {
+				log.Printf("pipeline rule refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+func sortPipelineRules(rules []*PipelineRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Order != rules[j].Order {
+			return rules[i].Order < rules[j].Order
+		}
+		return rules[i].Name < rules[j].Name
+	})
+}
+
+// applyPipelineRules runs the loaded rules on an event, returning ErrSampledOut when a
+// sample rule drops a live one. Backfills aren't sampled, as their events are chosen on
+// purpose, but a redact rule a backfill could get around wouldn't redact.
+func applyPipelineRules(event *structs.Event, live bool) error {
+	pipelineRulesMu.RLock()
+	rules := pipelineRules
+	pipelineRulesMu.RUnlock()
+
+	for _, rule := range rules {
+		if !rule.matches(event) {
+			continue
+		}
+		if rule.Type == RuleSample {
+			if live && !keepSampled(event, *rule.Rate) {
+				return ErrSampledOut
+			}
+			continue
+		}
+		rule.apply(event)
+	}
+	return nil
+}
+
+// keepSampled decides whether a sampled event is kept. Events of a trace hash to the same
+// decision, so sampled traces stay complete.
+func keepSampled(event *structs.Event, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if event.TraceID == "" {
+		return rand.Float64() < rate
+	}
+	h := fnv.New64a()
+	h.Write([]byte(event.TraceID))
+	return float64(h.Sum64()%1_000_000)/1_000_000 < rate
+}
+
+// matches reports whether every match filter holds for the event
+func (rule *PipelineRule) matches(event *structs.Event) bool {
+	for _, f := range rule.Match {
+		if !matchEventFilter(event, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply makes the rule's change to a matching event; sampling is decided by the caller
+func (rule *PipelineRule) apply(event *structs.Event) {
+	switch rule.Type {
+	case RuleEnrich:
+		key := strings.TrimPrefix(rule.Field, "data.")
+		if _, exists := event.Data[key]; exists {
+			return
+		}
+		if value := rule.expr.eval(event); value != nil {
+			if event.Data == nil {
+				event.Data = make(map[string]interface{})
+			}
+			event.Data[key] = value
+		}
+	case RuleRedact:
+		for _, key := range rule.Keys {
+			redactDataKey(event.Data, strings.Split(key, "."), rule.Replacement)
+		}
+	case RuleRoute:
+		event.Env = rule.Env
+	}
+}
+
+// redactDataKey removes the value at path, or replaces it when replacement is set
+func redactDataKey(data map[string]interface{}, path []string, replacement string) {
+	for _, part := range path[:len(path)-1] {
+		next, ok := data[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		data = next
+	}
+	key := path[len(path)-1]
+	if _, ok := data[key]; !ok {
+		return
+	}
+	if replacement == "" {
+		delete(data, key)
+		return
+	}
+	data[key] = replacement
+}
+
+// matchEventFilter evaluates a query filter against an event in memory, with the same
+// operators as the query filters
+func matchEventFilter(event *structs.Event, f structs.QueryFilter) bool {
+	value := fieldRefExpr{path: strings.Split(f.Field, ".")}.eval(event)
+	switch f.Operator {
+	case "eq", "":
+		return value != nil && toString(value) == toString(f.Value)
+	case "neq":
+		return value == nil || toString(value) != toString(f.Value)
+	case "contains":
+		return value != nil && strings.Contains(toString(value), toString(f.Value))
+	case "startswith":
+		return value != nil && strings.HasPrefix(toString(value), toString(f.Value))
+	case "endswith":
+		return value != nil && strings.HasSuffix(toString(value), toString(f.Value))
+	case "in":
+		values, _ := f.Value.([]interface{})
+		for _, v := range values {
+			if value != nil && toString(value) == toString(v) {
+				return true
+			}
+		}
+		return false
+	case "lt", "gt", "lte", "gte":
+		a, ok := toNumber(value)
+		b, ok2 := toNumber(f.Value)
+		if !ok || !ok2 {
+			return false
+		}
+		switch f.Operator {
+		case "lt":
+			return a < b
+		case "gt":
+			return a > b
+		case "lte":
+			return a <= b
+		default:
+			return a >= b
+		}
+	}
+	return false
+}
+
+// pipelineRuleColumns are the event columns a rule can match on, besides labels and data keys
+var pipelineRuleColumns = map[string]bool{
+	"service": true, "env": true, "name": true, "level": true,
+	"job_id": true, "request_id": true, "trace_id": true, "user_id": true,
+}
+
+// checkPipelineRule validates a rule and compiles its expression
+func checkPipelineRule(rule *PipelineRule) error {
+	if !safeIdentifierRegex.MatchString(rule.Name) {
+		return fmt.Errorf("invalid pipeline rule name: %s", rule.Name)
+	}
+	for i, f := range rule.Match {
+		if err := checkPipelineField(f.Field); err != nil {
+			return fmt.Errorf("invalid match[%d]: %w", i, err)
+		}
+		switch f.Operator {
+		case "", "eq", "neq", "contains", "startswith", "endswith", "lt", "gt", "lte", "gte":
+		case "in":
+			if _, ok := f.Value.([]interface{}); !ok {
+				return fmt.Errorf("invalid match[%d]: in operator requires array value", i)
+			}
+		default:
+			return fmt.Errorf("invalid match[%d]: unsupported operator: %s", i, f.Operator)
+		}
+	}
+
+	switch rule.Type {
+	case RuleEnrich:
+		fields, err := ParseDerivedFields(rule.Field + " = " + rule.Expression)
+		if err != nil || len(fields) != 1 {
+			return fmt.Errorf("invalid enrich rule: %v", err)
+		}
+		rule.expr = fields[0].expr
+	case RuleSample:
+		if rule.Rate == nil || *rule.Rate < 0 || *rule.Rate > 1 {
+			return fmt.Errorf("invalid sample rule: rate between 0 and 1 is required")
+		}
+	case RuleRedact:
+		if len(rule.Keys) == 0 {
+			return fmt.Errorf("invalid redact rule: keys are required")
+		}
+		for _, key := range rule.Keys {
+			if err := checkPipelineField("data." + key); err != nil {
+				return fmt.Errorf("invalid redact rule: %w", err)
+			}
+		}
+	case RuleRoute:
+		if rule.Env == "" {
+			return fmt.Errorf("invalid route rule: env is required")
+		}
+	default:
+		return fmt.Errorf("invalid pipeline rule type %q (use enrich, sample, redact, or route)", rule.Type)
+	}
+	return nil
+}
+
+func checkPipelineField(field string) error {
+	if path, ok := strings.CutPrefix(field, "data."); ok {
+		for _, part := range strings.Split(path, ".") {
+			if !safeIdentifierRegex.MatchString(part) {
+				return fmt.Errorf("invalid data field name: %s", path)
+			}
+		}
+		return nil
+	}
+	if _, ok := validLabels[field]; ok || pipelineRuleColumns[field] {
+		return nil
+	}
+	return fmt.Errorf("invalid field: %s", field)
+}
+
+// ListPipelineRules returns the current version of every rule, by name
+func (s *Service) ListPipelineRules(ctx context.Context) ([]PipelineRule, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`SELECT name, max(version) AS version, argMax(rule, version), argMax(updated_at, version)
+		FROM %s.pipeline_rules GROUP BY name HAVING argMax(deleted, version) = 0 ORDER BY name`, db.Database))
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []PipelineRule{}
+	for rows.Next() {
+		rule, err := scanPipelineRule(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+// GetPipelineRule returns the current version of a rule, or nil if it doesn't exist
+func (s *Service) GetPipelineRule(ctx context.Context, name string) (*PipelineRule, error) {
+	versions, err := s.ListPipelineRuleVersions(ctx, name)
+	if err != nil || len(versions) == 0 || versions[0].Name == "" {
+		return nil, err
+	}
+	return &versions[0], nil
+}
+
+// ListPipelineRuleVersions returns every version of a rule, newest first. A deleted
+// version has only its version and updated_at.
+func (s *Service) ListPipelineRuleVersions(ctx context.Context, name string) ([]PipelineRule, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`SELECT if(deleted = 1, '', name), version, rule, updated_at
+		FROM %s.pipeline_rules WHERE name = ? ORDER BY version DESC`, db.Database), name)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []PipelineRule{}
+	for rows.Next() {
+		rule, err := scanPipelineRule(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *rule)
+	}
+	return versions, rows.Err()
+}
+
+func scanPipelineRule(scan func(dest ...interface{}) error) (*PipelineRule, error) {
+	var name, body string
+	var version uint32
+	var updatedAt time.Time
+	if err := scan(&name, &version, &body, &updatedAt); err != nil {
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	var rule PipelineRule
+	if err := json.Unmarshal([]byte(body), &rule); err != nil {
+		return nil, fmt.Errorf("invalid stored pipeline rule %s: %w", name, err)
+	}
+	rule.Name = name
+	rule.Version = version
+	rule.UpdatedAt = updatedAt
+	return &rule, nil
+}
+
+// PutPipelineRule validates a rule and stores it as the rule's next version, applying it
+// on this instance right away (others pick it up within a minute)
+func (s *Service) PutPipelineRule(ctx context.Context, rule *PipelineRule) error {
+	if err := checkPipelineRule(rule); err != nil {
+		return err
+	}
+	return s.writePipelineRule(ctx, rule, false)
+}
+
+// DeletePipelineRule stores a deleted version of a rule; it returns false if the rule
+// doesn't exist
+func (s *Service) DeletePipelineRule(ctx context.Context, name string) (bool, error) {
+	rule, err := s.GetPipelineRule(ctx, name)
+	if err != nil || rule == nil {
+		return false, err
+	}
+	return true, s.writePipelineRule(ctx, rule, true)
+}
+
+func (s *Service) writePipelineRule(ctx context.Context, rule *PipelineRule, deleted bool) error {
+	var latest uint32
+	if err := s.queryRow(ctx, fmt.Sprintf("SELECT max(version) FROM %s.pipeline_rules WHERE name = ?", db.Database), rule.Name).Scan(&latest); err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	rule.Version = latest + 1
+	rule.UpdatedAt = time.Now().UTC()
+
+	body, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.pipeline_rules (name, version, rule, deleted, updated_at) VALUES (?, ?, ?, ?, ?)", db.Database),
+		rule.Name, rule.Version, string(body), boolToUInt8(deleted), rule.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to save pipeline rule: %w", err)
+	}
+
+	EmitInternal("pipeline.rule_changed", "info", map[string]interface{}{
+		"rule":    rule.Name,
+		"type":    rule.Type,
+		"version": rule.Version,
+		"deleted": deleted,
+	})
+	return s.LoadPipelineRules(ctx)
+}
+
+func boolToUInt8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// TestPipelineRules shows what the current rules would do to a sample event without
+// ingesting it. A candidate rule replaces the current rule of the same name (or is added),
+// so a change can be tried before it is saved. Sample rules are reported as a keep rate
+// instead of being decided at random; the event is only dropped when that rate is 0.
+func TestPipelineRules(event *structs.Event, candidate *PipelineRule) (*PipelineTestResult, error) {
+	pipelineRulesMu.RLock()
+	rules := make([]*PipelineRule, 0, len(pipelineRules)+1)
+	for _, rule := range pipelineRules {
+		if candidate == nil || rule.Name != candidate.Name {
+			rules = append(rules, rule)
+		}
+	}
+	pipelineRulesMu.RUnlock()
+
+	if candidate != nil {
+		if err := checkPipelineRule(candidate); err != nil {
+			return nil, err
+		}
+		if !candidate.Disabled {
+			rules = append(rules, candidate)
+			sortPipelineRules(rules)
+		}
+	}
+
+	result := &PipelineTestResult{Event: event, Applied: []string{}, KeepRate: 1}
+	if event.Data == nil {
+		event.Data = make(map[string]interface{})
+	}
+	if err := normalizeLevel(event); err != nil {
+		return nil, err
+	}
+	applyDerivedFields(event)
+
+	for _, rule := range rules {
+		if !rule.matches(event) {
+			continue
+		}
+		result.Applied = append(result.Applied, rule.Name)
+		if rule.Type == RuleSample {
+			result.KeepRate *= *rule.Rate
+			if result.KeepRate == 0 {
+				result.Dropped = true
+				break
+			}
+		}
+		rule.apply(event)
+	}
+	return result, nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// promServiceLabels are checked in order to find the event service
+var promServiceLabels = []string{"service", "job"}
+
+// promEnvLabels are checked in order to find the event env
+var promEnvLabels = []string{"env", "environment"}
+
+// promSample is a single sample with the labels of its series
+type promSample struct {
+	labels    map[string]string
+	value     float64
+	timestamp time.Time
+}
+
+// ParsePromWrite converts a Prometheus remote-write WriteRequest (1.0), already
+// snappy-decoded, into events, one per sample. Stale markers and other NaN or infinite
+// samples are skipped since they can't be stored as JSON numbers.
+func ParsePromWrite(body []byte, defaultService string) ([]*structs.Event, error) {
+	samples, err := parsePromWriteRequest(body)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*structs.Event, 0, len(samples))
+	for i, sample := range samples {
+		if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+			continue
+		}
+		event := promSampleToEvent(sample, defaultService)
+		if err := event.Validate(); err != nil {
+			return nil, fmt.Errorf("sample %d: %w", i+1, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// promSampleToEvent names the event after the metric and maps the service and env labels
+// to columns, with the value and remaining labels in data
+func promSampleToEvent(sample promSample, defaultService string) *structs.Event {
+	labels := make(map[string]string, len(sample.labels))
+	for k, v := range sample.labels {
+		labels[k] = v
+	}
+
+	event := &structs.Event{
+		Timestamp: sample.timestamp,
+		Name:      takeLabel(labels, "__name__"),
+		Service:   takeLabel(labels, promServiceLabels...),
+		Env:       takeLabel(labels, promEnvLabels...),
+		Level:     "info",
+	}
+	if event.Service == "" {
+		event.Service = defaultService
+	}
+
+	data := make(map[string]interface{}, len(labels)+1)
+	for k, v := range labels {
+		data[k] = v
+	}
+	data["value"] = sample.value
+	event.Data = data
+
+	return event
+}
+
+// parsePromWriteRequest decodes a WriteRequest: timeseries=1. Metadata and native
+// histograms are ignored.
+func parsePromWriteRequest(b []byte) ([]promSample, error) {
+	var samples []promSample
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		series, err := parsePromTimeSeries(value)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, series...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf write request: %w", err)
+	}
+	return samples, nil
+}
+
+// parsePromTimeSeries decodes a TimeSeries: labels=1, samples=2
+func parsePromTimeSeries(b []byte) ([]promSample, error) {
+	labels := make(map[string]string)
+	var samples []promSample
+
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			var name, val string
+			err := forEachField(value, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					name = string(v)
+				case 2:
+					val = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			labels[name] = val
+		case 2:
+			sample, err := parsePromSample(value)
+			if err != nil {
+				return err
+			}
+			samples = append(samples, sample)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if labels["__name__"] == "" {
+		return nil, fmt.Errorf("time series without a __name__ label")
+	}
+	for i := range samples {
+		samples[i].labels = labels
+	}
+	return samples, nil
+}
+
+// parsePromSample decodes a Sample: value=1 (double), timestamp=2 (milliseconds)
+func parsePromSample(b []byte) (promSample, error) {
+	var sample promSample
+
+	if err := forEachFixed64(b, func(num protowire.Number, v uint64) {
+		if num == 1 {
+			sample.value = math.Float64frombits(v)
+		}
+	}); err != nil {
+		return sample, err
+	}
+
+	err := forEachVarint(b, func(num protowire.Number, v uint64) {
+		if num == 2 {
+			sample.timestamp = time.UnixMilli(int64(v)).UTC()
+		}
+	})
+	return sample, err
+}
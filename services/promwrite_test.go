@@ -0,0 +1,75 @@
+package services_test
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// promLabel is a Label of a TimeSeries: name=1, value=2
+func promLabel(name, value string) protoField {
+	return protoSubMessage(1, protoString(1, name), protoString(2, value))
+}
+
+// promSample is a Sample of a TimeSeries: value=1, timestamp=2
+func promSample(value float64, at time.Time) protoField {
+	return protoSubMessage(2, protoFixed64(1, math.Float64bits(value)), protoVarint(2, uint64(at.UnixMilli())))
+}
+
+func TestParsePromWrite(t *testing.T) {
+	at := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	body := protoMessage(
+		protoSubMessage(1,
+			promLabel("__name__", "http_requests_total"),
+			promLabel("job", "api"),
+			promLabel("env", "prod"),
+			promLabel("code", "500"),
+			promSample(42, at),
+			promSample(math.NaN(), at.Add(15*time.Second)),
+			promSample(43, at.Add(30*time.Second)),
+		),
+		protoSubMessage(1,
+			promLabel("__name__", "up"),
+			promSample(1, at),
+		),
+	)
+
+	events, err := services.ParsePromWrite(body, "prometheus")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The NaN stale marker is skipped
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+
+	event := events[0]
+	if event.Name != "http_requests_total" || event.Service != "api" || event.Env != "prod" || event.Level != "info" || !event.Timestamp.Equal(at) {
+		t.Fatalf("unexpected columns: %+v", event)
+	}
+	if want := map[string]interface{}{"code": "500", "value": 42.0}; !reflect.DeepEqual(event.Data, want) {
+		t.Fatalf("data = %v, want %v", event.Data, want)
+	}
+	if !events[1].Timestamp.Equal(at.Add(30*time.Second)) || events[1].Data["value"] != 43.0 {
+		t.Fatalf("unexpected second sample: %+v", events[1])
+	}
+	if events[2].Name != "up" || events[2].Service != "prometheus" {
+		t.Fatalf("name, service = %q, %q, want up, prometheus", events[2].Name, events[2].Service)
+	}
+}
+
+func TestParsePromWriteErrors(t *testing.T) {
+	unnamed := protoMessage(protoSubMessage(1, promLabel("job", "api"), promSample(1, time.Now())))
+	if _, err := services.ParsePromWrite(unnamed, "prometheus"); err == nil || !strings.Contains(err.Error(), "without a __name__ label") {
+		t.Fatalf("error = %v, want one about the missing __name__", err)
+	}
+
+	truncated := protoMessage(protoSubMessage(1, promLabel("__name__", "up")))
+	if _, err := services.ParsePromWrite(truncated[:len(truncated)-2], "prometheus"); err == nil || !strings.Contains(err.Error(), "invalid protobuf write request") {
+		t.Fatalf("error = %v, want one about the truncated body", err)
+	}
+}
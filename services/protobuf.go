@@ -0,0 +1,93 @@
+package services
+
+import "google.golang.org/protobuf/encoding/protowire"
+
+// forEachField walks the length-delimited fields of a protobuf message
+// Non-bytes fields are skipped with a nil value
+func forEachField(b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if err := fn(num, typ, value); err != nil {
+				return err
+			}
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err := fn(num, typ, nil); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// forEachVarint walks the varint fields of a protobuf message
+func forEachVarint(b []byte, fn func(num protowire.Number, v uint64)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, v)
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// forEachFixed64 walks the fixed64 fields of a protobuf message
+func forEachFixed64(b []byte, fn func(num protowire.Number, v uint64)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.Fixed64Type {
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, v)
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package services_test
+
+import "google.golang.org/protobuf/encoding/protowire"
+
+// protoField appends one field of a protobuf message built by a test
+type protoField func([]byte) []byte
+
+// protoMessage encodes fields in order, the way the wire parsers receive them
+func protoMessage(fields ...protoField) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = f(b)
+	}
+	return b
+}
+
+func protoBytes(num protowire.Number, value []byte) protoField {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, value)
+	}
+}
+
+func protoString(num protowire.Number, value string) protoField {
+	return protoBytes(num, []byte(value))
+}
+
+func protoSubMessage(num protowire.Number, fields ...protoField) protoField {
+	return protoBytes(num, protoMessage(fields...))
+}
+
+func protoVarint(num protowire.Number, value uint64) protoField {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, value)
+	}
+}
+
+func protoFixed64(num protowire.Number, value uint64) protoField {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, value)
+	}
+}
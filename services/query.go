@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -35,10 +37,13 @@ type Filter struct {
 
 type QueryParams struct {
 	Filters []Filter
-	From    time.Time
-	To      time.Time
-	Limit   int
-	Offset  int
+	// QueryFilters are analytics-style filters, so autocomplete can follow a query
+	// builder's current filters
+	QueryFilters []structs.QueryFilter
+	From         time.Time
+	To           time.Time
+	Limit        int
+	Offset       int
 }
 
 type QueryResult struct {
@@ -48,108 +53,83 @@ type QueryResult struct {
 
 type LabelValuesResult struct {
 	Values []string `json:"values"`
+	// Counts are the values with their event counts, most frequent first, when requested
+	Counts []LabelValueCount `json:"counts,omitempty"`
 }
 
-type DataKeysResult struct {
-	Keys []string `json:"keys"`
+// LabelValueCount is a label value and the number of matching events that have it
+type LabelValueCount struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
 }
 
-func eventsTable() string {
-	return fmt.Sprintf("%s.events", db.Database)
+// LabelValuesOptions narrow and rank label values for large deployments
+type LabelValuesOptions struct {
+	// Search keeps values containing it, case-insensitively
+	Search string
+	// WithCounts returns each value's event count
+	WithCounts bool
 }
 
-var validColumns = map[string]bool{
-	"service":    true,
-	"env":        true,
-	"job_id":     true,
-	"request_id": true,
-	"trace_id":   true,
-	"user_id":    true,
-	"name":       true,
-	"level":      true,
+type DataKeysResult struct {
+	Keys []string `json:"keys"`
 }
 
-func applyFilters(builder sq.SelectBuilder, params QueryParams) sq.SelectBuilder {
-	for _, f := range params.Filters {
-		if f.IsData {
-			builder = applyDataFilter(builder, f)
-		} else {
-			builder = applyColumnFilter(builder, f)
-		}
+// eventsTable returns the table queries of the request read from: its tenant's table, or
+// the shared tables
+func eventsTable(ctx context.Context) string {
+	if tenant, ok := db.TenantTable(TenantFromContext(ctx)); ok {
+		return tenant.QualifiedName()
 	}
+	return db.ReadTable()
+}
 
-	if !params.From.IsZero() {
-		builder = builder.Where(sq.GtOrEq{"timestamp": params.From})
-	}
-	if !params.To.IsZero() {
-		builder = builder.Where(sq.LtOrEq{"timestamp": params.To})
-	}
+// builtinColumns are the string columns of every events table
+var builtinColumns = []string{"service", "env", "job_id", "request_id", "trace_id", "user_id", "name", "level"}
 
-	return builder
-}
+// builtinLabels are the columns whose values /v1/labels lists
+var builtinLabels = []string{"service", "env", "user_id", "name", "level"}
 
-func applyColumnFilter(builder sq.SelectBuilder, f Filter) sq.SelectBuilder {
-	if !validColumns[f.Field] {
-		return builder
-	}
-
-	switch f.Operator {
-	case OpEq, "":
-		builder = builder.Where(sq.Eq{f.Field: f.Value})
-	case OpNeq:
-		builder = builder.Where(sq.NotEq{f.Field: f.Value})
-	case OpLt:
-		builder = builder.Where(sq.Lt{f.Field: f.Value})
-	case OpGt:
-		builder = builder.Where(sq.Gt{f.Field: f.Value})
-	case OpLte:
-		builder = builder.Where(sq.LtOrEq{f.Field: f.Value})
-	case OpGte:
-		builder = builder.Where(sq.GtOrEq{f.Field: f.Value})
-	case OpContains:
-		builder = builder.Where(sq.Like{f.Field: fmt.Sprintf("%%%v%%", f.Value)})
-	case OpStartsWith:
-		builder = builder.Where(sq.Like{f.Field: fmt.Sprintf("%v%%", f.Value)})
-	case OpEndsWith:
-		builder = builder.Where(sq.Like{f.Field: fmt.Sprintf("%%%v", f.Value)})
-	case OpIn:
-		if values, ok := f.Value.([]string); ok {
-			builder = builder.Where(sq.Eq{f.Field: values})
-		}
-	}
+// labelColumns are the configured extra label columns (set by SetLabelColumns)
+var labelColumns []string
+
+var (
+	// validColumns are the columns filters can match
+	validColumns map[string]bool
+	// validLabels maps the labels /v1/labels lists to their column
+	validLabels map[string]string
+)
 
-	return builder
+func init() {
+	SetLabelColumns(nil)
 }
 
-func applyDataFilter(builder sq.SelectBuilder, f Filter) sq.SelectBuilder {
-	extractStr := fmt.Sprintf("JSONExtractString(data, '%s')", f.Field)
-	extractNum := fmt.Sprintf("toFloat64OrNull(JSONExtractRaw(data, '%s'))", f.Field)
-
-	switch f.Operator {
-	case OpEq, "":
-		builder = builder.Where(fmt.Sprintf("%s = ?", extractStr), f.Value)
-	case OpNeq:
-		builder = builder.Where(fmt.Sprintf("%s != ?", extractStr), f.Value)
-	case OpLt:
-		builder = builder.Where(fmt.Sprintf("%s < ?", extractNum), f.Value)
-	case OpGt:
-		builder = builder.Where(fmt.Sprintf("%s > ?", extractNum), f.Value)
-	case OpLte:
-		builder = builder.Where(fmt.Sprintf("%s <= ?", extractNum), f.Value)
-	case OpGte:
-		builder = builder.Where(fmt.Sprintf("%s >= ?", extractNum), f.Value)
-	case OpContains:
-		builder = builder.Where(fmt.Sprintf("%s LIKE ?", extractStr), fmt.Sprintf("%%%v%%", f.Value))
-	case OpStartsWith:
-		builder = builder.Where(fmt.Sprintf("%s LIKE ?", extractStr), fmt.Sprintf("%v%%", f.Value))
-	case OpEndsWith:
-		builder = builder.Where(fmt.Sprintf("%s LIKE ?", extractStr), fmt.Sprintf("%%%v", f.Value))
-	}
-
-	return builder
+// SetLabelColumns rebuilds the column sets to include the extra label columns, which
+// can then be filtered on, grouped by, and listed like the built-in labels. It isn't
+// safe to call while queries run; main calls it once at startup.
+func SetLabelColumns(columns []string) {
+	labelColumns = columns
+	validColumns = make(map[string]bool)
+	validGroupByColumns = make(map[string]bool)
+	eventColumnNames = make(map[string]bool)
+	validLabels = make(map[string]string)
+	for _, column := range append(builtinColumns, columns...) {
+		validColumns[column] = true
+		validGroupByColumns[column] = true
+		eventColumnNames[column] = true
+	}
+	for _, label := range append(builtinLabels, columns...) {
+		validLabels[label] = label
+	}
 }
 
-func QueryEvents(ctx context.Context, params QueryParams) (*QueryResult, error) {
+func (s *Service) QueryEvents(ctx context.Context, params QueryParams) (*QueryResult, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+	if err := applyTimeRange(RangeEvents, &params.From, &params.To); err != nil {
+		return nil, err
+	}
 	if params.Limit <= 0 {
 		params.Limit = 100
 	}
@@ -159,9 +139,12 @@ func QueryEvents(ctx context.Context, params QueryParams) (*QueryResult, error)
 
 	// Count query
 	countBuilder := sq.Select("count()").
-		From(eventsTable()).
+		From(eventsTable(ctx)).
 		PlaceholderFormat(sq.Question)
-	countBuilder = applyFilters(countBuilder, params)
+	countBuilder, err := applyFilters(ctx, countBuilder, params)
+	if err != nil {
+		return nil, err
+	}
 
 	countSQL, countArgs, err := countBuilder.ToSql()
 	if err != nil {
@@ -169,25 +152,28 @@ func QueryEvents(ctx context.Context, params QueryParams) (*QueryResult, error)
 	}
 
 	var total uint64
-	if err := db.Conn.QueryRow(ctx, countSQL, countArgs...).Scan(&total); err != nil {
+	if err := s.queryRow(ctx, countSQL, countArgs...).Scan(&total); err != nil {
 		return nil, fmt.Errorf("count query failed: %w", err)
 	}
 
 	// Data query
-	queryBuilder := sq.Select("timestamp", "service", "env", "job_id", "request_id", "trace_id", "user_id", "name", "level", "data").
-		From(eventsTable()).
+	queryBuilder := sq.Select(eventColumns()...).
+		From(eventsTable(ctx)).
 		OrderBy("timestamp DESC").
 		Limit(uint64(params.Limit)).
 		Offset(uint64(params.Offset)).
 		PlaceholderFormat(sq.Question)
-	queryBuilder = applyFilters(queryBuilder, params)
+	queryBuilder, err = applyFilters(ctx, queryBuilder, params)
+	if err != nil {
+		return nil, err
+	}
 
 	querySQL, queryArgs, err := queryBuilder.ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
 	}
 
-	rows, err := db.Conn.Query(ctx, querySQL, queryArgs...)
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -195,15 +181,11 @@ func QueryEvents(ctx context.Context, params QueryParams) (*QueryResult, error)
 
 	var events []*structs.Event
 	for rows.Next() {
-		var e structs.Event
-		var dataStr string
-		if err := rows.Scan(&e.Timestamp, &e.Service, &e.Env, &e.JobID, &e.RequestID, &e.TraceID, &e.UserID, &e.Name, &e.Level, &dataStr); err != nil {
-			return nil, fmt.Errorf("scan failed: %w", err)
-		}
-		if dataStr != "" && dataStr != "{}" {
-			json.Unmarshal([]byte(dataStr), &e.Data)
+		e, err := scanEvent(ctx, rows.Scan)
+		if err != nil {
+			return nil, err
 		}
-		events = append(events, &e)
+		events = append(events, e)
 	}
 
 	if events == nil {
@@ -216,43 +198,88 @@ func QueryEvents(ctx context.Context, params QueryParams) (*QueryResult, error)
 	}, nil
 }
 
-var validLabels = map[string]string{
-	"service": "service",
-	"env":     "env",
-	"user_id": "user_id",
-	"name":    "name",
-	"level":   "level",
+// eventColumns are the columns scanEvent reads
+func eventColumns() []string {
+	return append([]string{"timestamp", "received_at", "service", "env", "job_id", "request_id", "trace_id", "user_id", "name", "level", "data"}, labelColumns...)
 }
 
-func GetLabelValues(ctx context.Context, label string, params QueryParams) (*LabelValuesResult, error) {
+// scanEvent scans a row of eventColumns, masking sensitive data keys for the caller's role
+func scanEvent(ctx context.Context, scan func(dest ...any) error) (*structs.Event, error) {
+	var e structs.Event
+	var dataStr string
+	labels := make([]string, len(labelColumns))
+	dest := []any{&e.Timestamp, &e.ReceivedAt, &e.Service, &e.Env, &e.JobID, &e.RequestID, &e.TraceID, &e.UserID, &e.Name, &e.Level, &dataStr}
+	for i := range labels {
+		dest = append(dest, &labels[i])
+	}
+	if err := scan(dest...); err != nil {
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	for i, value := range labels {
+		if value == "" {
+			continue
+		}
+		if e.Labels == nil {
+			e.Labels = make(map[string]string, len(labels))
+		}
+		e.Labels[labelColumns[i]] = value
+	}
+	if dataStr != "" && dataStr != "{}" {
+		json.Unmarshal([]byte(dataStr), &e.Data)
+		MaskSensitiveData(ctx, e.Data)
+	}
+	return &e, nil
+}
+
+// GetLabelValues returns the distinct values of a label, alphabetically, or most frequent
+// first when searching or counting
+func (s *Service) GetLabelValues(ctx context.Context, label string, params QueryParams, opts LabelValuesOptions) (*LabelValuesResult, error) {
 	column, ok := validLabels[label]
 	if !ok {
 		return nil, fmt.Errorf("invalid label: %s", label)
 	}
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+	limit := uint64(1000)
+	if params.Limit > 0 && params.Limit < 1000 {
+		limit = uint64(params.Limit)
+	}
 
 	builder := sq.Select(fmt.Sprintf("DISTINCT %s", column)).
-		From(eventsTable()).
+		From(eventsTable(ctx)).
 		OrderBy(column).
-		Limit(1000).
+		Limit(limit).
 		PlaceholderFormat(sq.Question)
+	byFrequency := opts.Search != "" || opts.WithCounts
+	if byFrequency {
+		builder = sq.Select(column, "count() AS n").
+			From(eventsTable(ctx)).
+			GroupBy(column).
+			OrderBy("n DESC", column).
+			Limit(limit).
+			PlaceholderFormat(sq.Question)
+	}
+	if opts.Search != "" {
+		builder = builder.Where(fmt.Sprintf("positionCaseInsensitive(%s, ?) > 0", column), opts.Search)
+	}
 
-	// Apply filters except the one we're getting values for
+	// Apply filters except those on the label we're getting values for
+	scoped := params
+	scoped.Filters, scoped.QueryFilters = nil, nil
 	for _, f := range params.Filters {
-		if !f.IsData && f.Field == column {
-			continue
-		}
-		if f.IsData {
-			builder = applyDataFilter(builder, f)
-		} else {
-			builder = applyColumnFilter(builder, f)
+		if f.IsData || f.Field != column {
+			scoped.Filters = append(scoped.Filters, f)
 		}
 	}
-
-	if !params.From.IsZero() {
-		builder = builder.Where(sq.GtOrEq{"timestamp": params.From})
+	for _, f := range params.QueryFilters {
+		if f.Field != column {
+			scoped.QueryFilters = append(scoped.QueryFilters, f)
+		}
 	}
-	if !params.To.IsZero() {
-		builder = builder.Where(sq.LtOrEq{"timestamp": params.To})
+	builder, err := applyFilters(ctx, builder, scoped)
+	if err != nil {
+		return nil, err
 	}
 
 	querySQL, queryArgs, err := builder.ToSql()
@@ -260,20 +287,27 @@ func GetLabelValues(ctx context.Context, label string, params QueryParams) (*Lab
 		return nil, fmt.Errorf("failed to build query: %w", err)
 	}
 
-	rows, err := db.Conn.Query(ctx, querySQL, queryArgs...)
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 	defer rows.Close()
 
 	var values []string
+	var counts []LabelValueCount
 	for rows.Next() {
 		var v string
-		if err := rows.Scan(&v); err != nil {
+		var n uint64
+		dest := []interface{}{&v}
+		if byFrequency {
+			dest = append(dest, &n)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, fmt.Errorf("scan failed: %w", err)
 		}
 		if v != "" {
 			values = append(values, v)
+			counts = append(counts, LabelValueCount{Value: v, Count: n})
 		}
 	}
 
@@ -281,23 +315,36 @@ func GetLabelValues(ctx context.Context, label string, params QueryParams) (*Lab
 		values = []string{}
 	}
 
-	return &LabelValuesResult{Values: values}, nil
+	result := &LabelValuesResult{Values: values}
+	if opts.WithCounts {
+		result.Counts = counts
+		if result.Counts == nil {
+			result.Counts = []LabelValueCount{}
+		}
+	}
+	return result, nil
 }
 
-func GetDataKeys(ctx context.Context, params QueryParams) (*DataKeysResult, error) {
+func (s *Service) GetDataKeys(ctx context.Context, params QueryParams) (*DataKeysResult, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
 	builder := sq.Select("DISTINCT arrayJoin(JSONExtractKeys(data)) AS key").
-		From(eventsTable()).
+		From(eventsTable(ctx)).
 		OrderBy("key").
 		Limit(1000).
 		PlaceholderFormat(sq.Question)
-	builder = applyFilters(builder, params)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
 
 	querySQL, queryArgs, err := builder.ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
 	}
 
-	rows, err := db.Conn.Query(ctx, querySQL, queryArgs...)
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -319,18 +366,27 @@ func GetDataKeys(ctx context.Context, params QueryParams) (*DataKeysResult, erro
 	return &DataKeysResult{Keys: keys}, nil
 }
 
-func GetDataValues(ctx context.Context, key string, params QueryParams) (*LabelValuesResult, error) {
+func (s *Service) GetDataValues(ctx context.Context, key string, params QueryParams) (*LabelValuesResult, error) {
 	if key == "" {
 		return nil, fmt.Errorf("key is required")
 	}
+	if err := checkSensitiveFields(ctx, []string{"data." + key}, nil); err != nil {
+		return nil, err
+	}
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
 
 	builder := sq.Select("DISTINCT JSONExtractString(data, ?) AS value").
-		From(eventsTable()).
+		From(eventsTable(ctx)).
 		Where("JSONExtractString(data, ?) != ''").
 		OrderBy("value").
 		Limit(1000).
 		PlaceholderFormat(sq.Question)
-	builder = applyFilters(builder, params)
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
 
 	querySQL, queryArgs, err := builder.ToSql()
 	if err != nil {
@@ -340,7 +396,7 @@ func GetDataValues(ctx context.Context, key string, params QueryParams) (*LabelV
 	// Prepend the key arguments for JSONExtractString (SELECT and WHERE)
 	queryArgs = append([]interface{}{key, key}, queryArgs...)
 
-	rows, err := db.Conn.Query(ctx, querySQL, queryArgs...)
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -361,3 +417,39 @@ func GetDataValues(ctx context.Context, key string, params QueryParams) (*LabelV
 
 	return &LabelValuesResult{Values: values}, nil
 }
+
+// idColumns are the ID columns EventExists looks up, each with a bloom filter skip index
+var idColumns = map[string]bool{"trace_id": true, "request_id": true, "job_id": true}
+
+// EventExists reports whether an event the request can read has id in an ID column. The
+// column's bloom filter index lets ClickHouse skip the granules that can't hold id, so the
+// lookup reads a few granules instead of scanning the table.
+func (s *Service) EventExists(ctx context.Context, column, id string) (bool, error) {
+	if !idColumns[column] {
+		return false, fmt.Errorf("invalid column: %s", column)
+	}
+	id = structs.NormalizeID(id)
+	if !structs.IsValidID(id) {
+		return false, fmt.Errorf("invalid %s: must be a UUID", column)
+	}
+
+	builder := applyAccess(ctx, sq.Select("1").
+		From(eventsTable(ctx)).
+		Where(column+" = ?", id).
+		Limit(1).
+		PlaceholderFormat(sq.Question))
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var found uint8
+	err = s.queryRow(ctx, querySQL+" SETTINGS use_skip_indexes = 1", queryArgs...).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("query failed: %w", err)
+	}
+	return true, nil
+}
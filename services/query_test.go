@@ -0,0 +1,70 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/aidenappl/monitor-core/services"
+	"github.com/aidenappl/monitor-core/testutil"
+)
+
+func TestQueryEventsByService(t *testing.T) {
+	store := testutil.ClickHouse(t)
+	testutil.Seed(t, store,
+		testutil.Event("api", "request", map[string]interface{}{"status": 500}),
+		testutil.Event("api", "request", map[string]interface{}{"status": 200}),
+		testutil.Event("worker", "job.done", nil),
+	)
+	svc := services.New(store)
+
+	testutil.AssertEventCount(t, svc, services.QueryParams{
+		Filters: []services.Filter{{Field: "service", Operator: services.OpEq, Value: "api"}},
+	}, 2)
+	testutil.AssertEventCount(t, svc, services.QueryParams{
+		Filters: []services.Filter{
+			{Field: "service", Operator: services.OpEq, Value: "api"},
+			{Field: "status", Operator: services.OpGte, Value: "500", IsData: true},
+		},
+	}, 1)
+	testutil.AssertCount(t, store, 3, "env = ?", "test")
+}
+
+func TestQueryEventsSQL(t *testing.T) {
+	t.Parallel()
+
+	rec := testutil.NewRecorder()
+	_, err := services.New(rec).QueryEvents(context.Background(), services.QueryParams{
+		Filters: []services.Filter{
+			{Field: "service", Operator: services.OpEq, Value: "api"},
+			{Field: "status", Operator: services.OpGte, Value: "500", IsData: true},
+		},
+		From: time.Now().Add(-time.Hour),
+	})
+	if !errors.Is(err, testutil.ErrRecorded) {
+		t.Fatalf("QueryEvents error = %v, want ErrRecorded", err)
+	}
+
+	// Filter values are named; the time range's ? is named to match when the query runs
+	statement := rec.AssertQueried(t, "SELECT count() FROM", "service = @f",
+		"toFloat64OrNull(JSONExtractRaw(data, 'status')) >= @f", "timestamp >= @p1")
+	for _, value := range []string{"api", "500"} {
+		if !containsArg(statement.Args, value) {
+			t.Errorf("%q is not bound as an argument: %v", value, statement.Args)
+		}
+	}
+}
+
+func containsArg(args []interface{}, value string) bool {
+	for _, arg := range args {
+		if named, ok := arg.(driver.NamedValue); ok {
+			arg = named.Value
+		}
+		if arg == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// ErrQuotaShed is returned for events shed because their tenant or service is over its quota
+var ErrQuotaShed = errors.New("event shed by ingest quota")
+
+// defaultShedOrder is the order levels are shed in when QUOTA_SHED_ORDER is not set
+var defaultShedOrder = []string{"debug", "info", "warn"}
+
+// QuotaUsage is the current window of a tenant or service quota and the events it shed
+type QuotaUsage struct {
+	// Key is service:<name> or tenant:<name>; services of a tenant are tenant/service:<name>
+	Key         string    `json:"key"`
+	Quota       int64     `json:"quota"`
+	WindowStart time.Time `json:"window_start"`
+	Received    int64     `json:"received"`
+	// Shed counts the events shed in the current window by level
+	Shed map[string]int64 `json:"shed"`
+	// ShedTotal counts the events shed since startup by level
+	ShedTotal map[string]int64 `json:"shed_total"`
+}
+
+// quotaWindow counts the events a quota key received in one window by shed rank
+type quotaWindow struct {
+	limit     int64
+	start     time.Time
+	received  []int64
+	shed      map[string]int64
+	shedTotal map[string]int64
+}
+
+var (
+	quotaMu     sync.Mutex
+	quotas      map[string]int64
+	shedRanks   map[string]int
+	quotaPeriod = time.Minute
+	quotaUsage  = map[string]*quotaWindow{}
+)
+
+// ConfigureQuotas sets the events per window each tenant or service may ingest, keyed by
+// service:<name> or tenant:<name>, where service:* and tenant:* apply to those without
+// their own. Over quota, levels are shed in shedOrder: the first only has what the levels
+// after it leave of the quota, and levels not listed are never shed.
+func ConfigureQuotas(limits map[string]string, shedOrder []string, window time.Duration) error {
+	if window <= 0 {
+		return fmt.Errorf("the quota window must be more than 0")
+	}
+	parsed := make(map[string]int64, len(limits))
+	for key, value := range limits {
+		kind, name, ok := strings.Cut(key, ":")
+		if !ok || (kind != "service" && kind != "tenant") || name == "" {
+			return fmt.Errorf("invalid quota %q (use service:<name> or tenant:<name>)", key)
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("quota %s must be a positive number of events", key)
+		}
+		parsed[key] = limit
+	}
+
+	if len(shedOrder) == 0 {
+		shedOrder = defaultShedOrder
+	}
+	ranks := make(map[string]int, len(shedOrder))
+	for i, level := range shedOrder {
+		if !allowedLevels[level] {
+			return fmt.Errorf("quota shed order: %q is not an allowed level", level)
+		}
+		if _, ok := ranks[level]; ok {
+			return fmt.Errorf("quota shed order: %q is listed twice", level)
+		}
+		ranks[level] = i
+	}
+
+	quotaMu.Lock()
+	quotas, shedRanks, quotaPeriod = parsed, ranks, window
+	quotaUsage = map[string]*quotaWindow{}
+	quotaMu.Unlock()
+	return nil
+}
+
+// applyQuotas counts a live event against the quotas of its tenant and service, returning
+// ErrQuotaShed when either sheds its level. Internal events are never shed.
+func applyQuotas(event *structs.Event, now time.Time) error {
+	if len(quotas) == 0 || event.Service == SelfServiceName {
+		return nil
+	}
+
+	// Each tenant has its own windows for the service quotas
+	checks := []struct{ key, quota string }{{"service:" + event.Service, "service:" + event.Service}}
+	if event.Tenant != "" {
+		checks[0].key = event.Tenant + "/" + checks[0].key
+		checks = append(checks, struct{ key, quota string }{"tenant:" + event.Tenant, "tenant:" + event.Tenant})
+	}
+
+	quotaMu.Lock()
+	start := now.Truncate(quotaPeriod)
+	var closed []QuotaUsage
+	shed := false
+	for _, check := range checks {
+		key := check.key
+		limit, ok := quotaFor(check.quota)
+		if !ok {
+			continue
+		}
+		window := quotaUsage[key]
+		if window == nil {
+			window = &quotaWindow{received: make([]int64, len(shedRanks)+1), shedTotal: map[string]int64{}}
+			quotaUsage[key] = window
+		}
+		window.limit = limit
+		if !window.start.Equal(start) {
+			if len(window.shed) > 0 {
+				closed = append(closed, window.usage(key))
+			}
+			window.start = start
+			clear(window.received)
+			window.shed = nil
+		}
+		if window.admit(event.Level, limit) {
+			continue
+		}
+		shed = true
+		if window.shed == nil {
+			window.shed = map[string]int64{}
+		}
+		window.shed[event.Level]++
+		window.shedTotal[event.Level]++
+	}
+	quotaMu.Unlock()
+
+	// Windows are reported as they close, once the lock is released since reporting enqueues
+	for _, usage := range closed {
+		EmitInternal("quota.shed", "warn", map[string]interface{}{
+			"key":          usage.Key,
+			"quota":        usage.Quota,
+			"window_start": usage.WindowStart,
+			"received":     usage.Received,
+			"shed":         usage.Shed,
+		})
+	}
+
+	if shed {
+		return ErrQuotaShed
+	}
+	return nil
+}
+
+// quotaFor returns the quota of key, falling back to the * quota of its kind. Callers must
+// hold quotaMu.
+func quotaFor(key string) (int64, bool) {
+	if limit, ok := quotas[key]; ok {
+		return limit, true
+	}
+	kind, _, _ := strings.Cut(key, ":")
+	limit, ok := quotas[kind+":*"]
+	return limit, ok
+}
+
+// admit counts an event of level and reports whether it fits the quota: the events of its
+// level and the levels shed after it must not exceed limit, so the levels shed last keep
+// the whole quota
+func (w *quotaWindow) admit(level string, limit int64) bool {
+	rank, sheddable := shedRanks[level]
+	if !sheddable {
+		rank = len(shedRanks)
+	}
+	w.received[rank]++
+	if !sheddable {
+		return true
+	}
+	var total int64
+	for _, count := range w.received[rank:] {
+		total += count
+	}
+	return total <= limit
+}
+
+// usage returns the window as QuotaUsage. Callers must hold quotaMu.
+func (w *quotaWindow) usage(key string) QuotaUsage {
+	usage := QuotaUsage{
+		Key:         key,
+		Quota:       w.limit,
+		WindowStart: w.start,
+		Shed:        make(map[string]int64, len(w.shed)),
+		ShedTotal:   make(map[string]int64, len(w.shedTotal)),
+	}
+	for _, count := range w.received {
+		usage.Received += count
+	}
+	for level, count := range w.shed {
+		usage.Shed[level] = count
+	}
+	for level, count := range w.shedTotal {
+		usage.ShedTotal[level] = count
+	}
+	return usage
+}
+
+// GetQuotaUsage returns the current window of every quota key that has received events
+// and that the request's role can see: its tenant's keys and the services it may read.
+// Windows past their end show no usage.
+func GetQuotaUsage(ctx context.Context) []QuotaUsage {
+	role := AccessRoleFromContext(ctx)
+	tenant := TenantFromContext(ctx)
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	start := time.Now().UTC().Truncate(quotaPeriod)
+	usages := make([]QuotaUsage, 0, len(quotaUsage))
+	for key, window := range quotaUsage {
+		if !quotaVisible(key, tenant, role) {
+			continue
+		}
+		usage := window.usage(key)
+		if !window.start.Equal(start) {
+			usage.WindowStart, usage.Received, usage.Shed = start, 0, map[string]int64{}
+		}
+		usages = append(usages, usage)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Key < usages[j].Key })
+	return usages
+}
+
+// quotaVisible reports whether a request of tenant with role may see the quota key
+func quotaVisible(key, tenant string, role *AccessRole) bool {
+	if tenant != "" && key == "tenant:"+tenant {
+		return true
+	}
+	prefix := "service:"
+	if tenant != "" {
+		prefix = tenant + "/" + prefix
+	}
+	service, ok := strings.CutPrefix(key, prefix)
+	if !ok {
+		return false
+	}
+	return role == nil || len(role.Services) == 0 || slices.Contains(role.Services, service)
+}
+
+// ShedStats returns the number of events shed by quotas since startup
+func ShedStats() int64 {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	var total int64
+	for _, window := range quotaUsage {
+		for _, count := range window.shedTotal {
+			total += count
+		}
+	}
+	return total
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+	"github.com/google/uuid"
+)
+
+// Replay job statuses
+const (
+	ReplayRunning   = "running"
+	ReplayDone      = "done"
+	ReplayFailed    = "failed"
+	ReplayCancelled = "cancelled"
+)
+
+// defaultReplayPath matches every Parquet object under the archive prefix
+const defaultReplayPath = "**.parquet"
+
+// maxReplayJobs caps the finished jobs kept for GET /v1/admin/replays
+const maxReplayJobs = 50
+
+var (
+	// ErrReplayRunning is returned when a replay is started while another is running
+	ErrReplayRunning = errors.New("a replay is already running")
+	// ErrReplayDisabled is returned when no archive or replay table is configured
+	ErrReplayDisabled = errors.New("replay is not configured")
+)
+
+// archiveSource is where ClickHouse reads the Parquet archives from
+type archiveSource struct {
+	url             string
+	accessKeyID     string
+	secretAccessKey string
+}
+
+// replayJob is a ReplayJob with its progress counters and cancel function
+type replayJob struct {
+	mu          sync.Mutex
+	job         structs.ReplayJob
+	rowsRead    atomic.Uint64
+	bytesRead   atomic.Uint64
+	rowsWritten atomic.Uint64
+	cancel      context.CancelFunc
+}
+
+var (
+	archive *archiveSource
+
+	replayMu   sync.Mutex
+	replayJobs []*replayJob
+)
+
+// EnableArchiveReplay lets replays read the Parquet archives in bucket under prefix. The
+// objects are read by ClickHouse itself with the s3 table function, so the ClickHouse
+// server needs network access to the store; without credentials, it uses its own.
+func EnableArchiveReplay(endpoint, bucket, region, prefix, accessKeyID, secretAccessKey string) {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	archive = &archiveSource{
+		url:             strings.TrimSuffix(endpoint, "/") + "/" + awsURIEncode(bucket, false) + "/" + awsURIEncode(prefix, true),
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+	}
+}
+
+// ReplayEnabled reports whether archives can be replayed
+func ReplayEnabled() bool {
+	return archive != nil && db.ReplayTable != nil
+}
+
+// StartReplay re-inserts the archived events matching query into the replay table, where
+// queries see them next to the live events. The replay runs in the background; its
+// progress is available from ListReplays. A dry run only counts the matching events.
+func (s *Service) StartReplay(ctx context.Context, query *structs.ReplayQuery) (*structs.ReplayJob, error) {
+	if !ReplayEnabled() {
+		return nil, ErrReplayDisabled
+	}
+
+	path := query.Path
+	if path == "" {
+		path = defaultReplayPath
+	}
+	if strings.HasPrefix(path, "/") || strings.Contains(path, "..") {
+		return nil, fmt.Errorf("invalid path: must be relative to the archive prefix")
+	}
+
+	filters := query.Filters
+	if len(query.Services) > 0 {
+		services := make([]interface{}, len(query.Services))
+		for i, service := range query.Services {
+			services[i] = service
+		}
+		filters = append(filters[:len(filters):len(filters)], structs.QueryFilter{Field: "service", Operator: "in", Value: services})
+	}
+	where, args, err := buildMutationWhere(filters, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+
+	source, sourceArgs := archive.tableFunction(path)
+	args = append(sourceArgs, args...)
+
+	job := &replayJob{job: structs.ReplayJob{
+		ID:        uuid.NewString(),
+		Query:     *query,
+		Table:     db.ReplayTable.QualifiedName(),
+		StartedAt: time.Now().UTC(),
+	}}
+
+	if query.DryRun {
+		if err := s.queryRow(ctx, fmt.Sprintf("SELECT count() FROM %s WHERE %s", source, where), args...).Scan(&job.job.Matched); err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+		job.job.Status = ReplayDone
+		job.job.FinishedAt = &job.job.StartedAt
+		return &job.job, nil
+	}
+
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	for _, other := range replayJobs {
+		if other.snapshot().Status == ReplayRunning {
+			return nil, ErrReplayRunning
+		}
+	}
+
+	// The replay outlives the request that started it
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job.cancel = cancel
+	job.job.Status = ReplayRunning
+	replayJobs = append(replayJobs, job)
+	if len(replayJobs) > maxReplayJobs {
+		replayJobs = replayJobs[len(replayJobs)-maxReplayJobs:]
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE %s SETTINGS input_format_parquet_allow_missing_columns = 1",
+		job.job.Table, source, where)
+	go job.run(runCtx, s.store, sql, args)
+
+	EmitInternal("replay.started", "info", map[string]interface{}{
+		"replay_id": job.job.ID,
+		"table":     job.job.Table,
+		"from":      query.From,
+		"to":        query.To,
+		"filters":   filters,
+		"path":      path,
+	})
+	return job.snapshot(), nil
+}
+
+// tableFunction returns the s3 table function reading the Parquet objects matching path
+func (a *archiveSource) tableFunction(path string) (string, []interface{}) {
+	url := a.url + path
+	if a.accessKeyID == "" {
+		return "s3(?, 'Parquet')", []interface{}{url}
+	}
+	return "s3(?, ?, ?, 'Parquet')", []interface{}{url, a.accessKeyID, a.secretAccessKey}
+}
+
+func (j *replayJob) run(ctx context.Context, store db.Store, sql string, args []interface{}) {
+	defer j.cancel()
+
+	ctx = clickhouse.Context(ctx,
+		clickhouse.WithQueryID(j.job.ID),
+		clickhouse.WithProgress(func(p *clickhouse.Progress) {
+			j.rowsRead.Add(p.Rows)
+			j.bytesRead.Add(p.Bytes)
+			j.rowsWritten.Add(p.WroteRows)
+		}),
+	)
+	start := time.Now()
+	err := exec(ctx, store, sql, args...)
+	observeQuery(sql, time.Since(start))
+
+	j.mu.Lock()
+	finished := time.Now().UTC()
+	j.job.FinishedAt = &finished
+	switch {
+	case ctx.Err() != nil:
+		j.job.Status = ReplayCancelled
+	case err != nil:
+		j.job.Status = ReplayFailed
+		j.job.Error = err.Error()
+	default:
+		j.job.Status = ReplayDone
+	}
+	j.mu.Unlock()
+
+	job := j.snapshot()
+	level := "info"
+	if job.Status == ReplayFailed {
+		level = "error"
+		log.Printf("replay %s failed: %v", job.ID, err)
+	}
+	EmitInternal("replay.finished", level, map[string]interface{}{
+		"replay_id":    job.ID,
+		"status":       job.Status,
+		"rows_written": job.RowsWritten,
+		"duration_ms":  finished.Sub(job.StartedAt).Milliseconds(),
+		"error":        job.Error,
+	})
+}
+
+// snapshot returns the job with its current progress
+func (j *replayJob) snapshot() *structs.ReplayJob {
+	j.mu.Lock()
+	job := j.job
+	j.mu.Unlock()
+	job.RowsRead = j.rowsRead.Load()
+	job.BytesRead = j.bytesRead.Load()
+	job.RowsWritten = j.rowsWritten.Load()
+	return &job
+}
+
+// ListReplays returns the replays started on this instance, newest first
+func ListReplays() []*structs.ReplayJob {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	jobs := make([]*structs.ReplayJob, 0, len(replayJobs))
+	for i := len(replayJobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, replayJobs[i].snapshot())
+	}
+	return jobs
+}
+
+// GetReplay returns a replay started on this instance, or nil if there is none with id
+func GetReplay(id string) *structs.ReplayJob {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	for _, job := range replayJobs {
+		if job.job.ID == id {
+			return job.snapshot()
+		}
+	}
+	return nil
+}
+
+// CancelReplay stops a running replay; events already written stay in the replay table.
+// It returns nil if there is no replay with id.
+func CancelReplay(id string) *structs.ReplayJob {
+	replayMu.Lock()
+	var job *replayJob
+	for _, j := range replayJobs {
+		if j.job.ID == id {
+			job = j
+		}
+	}
+	replayMu.Unlock()
+
+	if job == nil {
+		return nil
+	}
+	if job.cancel != nil {
+		job.cancel()
+	}
+	return job.snapshot()
+}
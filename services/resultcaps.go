@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// Result caps, reported as the limit in the truncated meta block
+const (
+	CapSeries = "series"
+	CapPoints = "points"
+	CapBytes  = "bytes"
+)
+
+// Per-request result caps; 0 disables a cap
+var (
+	maxResultSeries int
+	maxResultPoints int
+	maxResultBytes  int
+)
+
+// Truncation says which result cap cut a query response short
+type Truncation struct {
+	Limit string `json:"limit"`
+	Max   int    `json:"max"`
+}
+
+// SetResultCaps configures what one query request may assemble in memory: the series of
+// a time series, the rows read across its ClickHouse queries, and the bytes of the values
+// scanned from them, roughly the size of the response. 0 disables a cap. Hitting a cap
+// stops reading and returns what was read so far, marked as truncated.
+func SetResultCaps(series, points, bytes int) {
+	maxResultSeries = series
+	maxResultPoints = points
+	maxResultBytes = bytes
+}
+
+// truncate records that a cap cut the request's result short; the first cap hit is kept
+func (qs *queryStats) truncate(limit string, max int) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if qs.stats.Truncated == nil {
+		qs.stats.Truncated = &Truncation{Limit: limit, Max: max}
+	}
+}
+
+// admitRow counts a row toward the request's points cap and reports whether it fits
+func (qs *queryStats) admitRow() bool {
+	qs.mu.Lock()
+	qs.points++
+	fits := maxResultPoints <= 0 || qs.points <= maxResultPoints
+	qs.mu.Unlock()
+	if !fits {
+		qs.truncate(CapPoints, maxResultPoints)
+	}
+	return fits
+}
+
+// admitBytes counts scanned bytes toward the request's bytes cap and reports whether the
+// request is still under it
+func (qs *queryStats) admitBytes(n int) bool {
+	qs.mu.Lock()
+	qs.bytes += n
+	fits := maxResultBytes <= 0 || qs.bytes <= maxResultBytes
+	qs.mu.Unlock()
+	if !fits {
+		qs.truncate(CapBytes, maxResultBytes)
+	}
+	return fits
+}
+
+// allowSeries reports whether a request that has assembled count series may start
+// another, marking its result truncated if not
+func allowSeries(ctx context.Context, count int) bool {
+	if maxResultSeries <= 0 || count < maxResultSeries {
+		return true
+	}
+	if qs, ok := ctx.Value(queryStatsKey{}).(*queryStats); ok {
+		qs.truncate(CapSeries, maxResultSeries)
+	}
+	return false
+}
+
+// resultCapped reports whether a result cap has cut the request's result short, so results
+// read with ctx may be missing rows
+func resultCapped(ctx context.Context) bool {
+	stats := QueryStatsFrom(ctx)
+	return stats != nil && stats.Truncated != nil
+}
+
+// scannedSize estimates the bytes of the values a row was scanned into
+func scannedSize(dest []interface{}) int {
+	size := 0
+	for _, d := range dest {
+		switch v := d.(type) {
+		case *string:
+			size += len(*v)
+		case *[]string:
+			for _, s := range *v {
+				size += len(s)
+			}
+		case *map[string]string:
+			for k, s := range *v {
+				size += len(k) + len(s)
+			}
+		case *[]byte:
+			size += len(*v)
+		case *time.Time:
+			size += 24
+		default:
+			size += 8
+		}
+	}
+	return size
+}
@@ -0,0 +1,519 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// rollupDimensions are the columns rollups keep. Time series grouped or filtered by
+// anything else only read raw events.
+var rollupDimensions = map[string]bool{"service": true, "env": true, "name": true, "level": true}
+
+// rollupTier is a rollup table and the step it aggregates events by
+type rollupTier struct {
+	precision structs.Precision
+	table     string
+	column    string
+	step      time.Duration
+	interval  structs.IntervalType
+	// retentionDays is how long the tier is kept, 0 when it is disabled
+	retentionDays func() int
+
+	mu sync.Mutex
+	// late are the hours events were written into after they may have been rolled up
+	late map[time.Time]bool
+}
+
+var (
+	minuteTier = &rollupTier{
+		precision:     structs.PrecisionMinute,
+		table:         db.MinuteRollupTable,
+		column:        "minute",
+		step:          time.Minute,
+		interval:      structs.IntervalMinute,
+		retentionDays: func() int { return db.MinuteRollupDays },
+	}
+	hourTier = &rollupTier{
+		precision:     structs.PrecisionHour,
+		table:         db.RollupTable,
+		column:        "hour",
+		step:          time.Hour,
+		interval:      structs.IntervalHour,
+		retentionDays: func() int { return db.RollupRetentionDays },
+	}
+	rollupTiers = []*rollupTier{minuteTier, hourTier}
+)
+
+func (t *rollupTier) enabled() bool {
+	return t.retentionDays() > 0
+}
+
+// rollupFields are the numeric fields rolled up next to the event count (set by EnableRollups)
+var rollupFields []string
+
+// spanMetrics is set when spans are rolled up apart from other events (by EnableSpanMetrics):
+// their count and duration, under fields named with spanRollupPrefix
+var spanMetrics bool
+
+// spanRollupPrefix marks the rollup fields of spans: "span:" for their count and
+// "span:data.duration_ms" for their duration
+const spanRollupPrefix = "span:"
+
+// EnableRollups keeps hourly aggregates of the event count and of each numeric field
+// (data.* keys or derived numeric fields) for retentionDays, and per-minute ones for
+// minuteDays (0 for none)
+func EnableRollups(retentionDays, minuteDays int, fields []string) error {
+	if retentionDays <= 0 {
+		return fmt.Errorf("rollup retention must be a positive number of days")
+	}
+	if minuteDays < 0 || minuteDays > retentionDays {
+		return fmt.Errorf("minute rollup retention must be between 0 and the rollup retention")
+	}
+	for _, field := range fields {
+		if _, err := buildNumericFieldExpr(field); err != nil {
+			return fmt.Errorf("rollup field %q: %w", field, err)
+		}
+	}
+	db.RollupRetentionDays = retentionDays
+	db.MinuteRollupDays = minuteDays
+	rollupFields = fields
+	return nil
+}
+
+// EnableSpanMetrics rolls up the count and duration of spans apart from other events, so
+// request rate, error rate, and duration percentiles per service and operation (span name)
+// of series limited to spans read rollups, uncounted by other events sharing a span's name.
+// Rollups must be enabled first.
+func EnableSpanMetrics() error {
+	if !RollupsEnabled() {
+		return fmt.Errorf("span metrics need rollups (ROLLUP_RETENTION_DAYS)")
+	}
+	spanMetrics = true
+	return nil
+}
+
+// RollupsEnabled reports whether rollups are kept and read
+func RollupsEnabled() bool {
+	return hourTier.enabled()
+}
+
+// RunRollups rolls up each hour once its events have settled. Live events older than
+// settle (MAX_EVENT_AGE) are rejected, so an hour is complete settle after it ends;
+// backfilled hours already rolled up are rolled up again on the next run. Rolling up an
+// hour again replaces its rows, so every instance can run this.
+func (s *Service) RunRollups(ctx context.Context, interval, settle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, tier := range rollupTiers {
+			if !tier.enabled() {
+				continue
+			}
+			if err := s.rollUp(ctx, tier, time.Now().UTC(), settle); err != nil {
+				log.Printf("%s rollup failed: %v", tier.precision, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rollUp aggregates the settled hours after the last rolled-up step; the first run covers
+// every raw event still kept, up to the tier's retention
+func (s *Service) rollUp(ctx context.Context, t *rollupTier, now time.Time, settle time.Duration) error {
+	cutoff := now.Add(-settle).Truncate(time.Hour)
+	start, err := s.rollupWatermark(ctx, t)
+	if err != nil {
+		return err
+	}
+	if start.IsZero() {
+		_, longest := rawRetentionDays()
+		start = rawStart(now, min(longest, t.retentionDays()))
+	}
+
+	for start.Before(cutoff) {
+		end := start.Add(24 * time.Hour)
+		if end.After(cutoff) {
+			end = cutoff
+		}
+		if err := s.rollUpRange(ctx, t, start, end); err != nil {
+			return err
+		}
+		start = end
+	}
+	return s.rollUpLate(ctx, t, start)
+}
+
+// RollUpLate rolls up again, now rather than on the next rollup run, the hours backfills
+// have written into since they were rolled up
+func (s *Service) RollUpLate(ctx context.Context) error {
+	for _, tier := range rollupTiers {
+		if !tier.enabled() {
+			continue
+		}
+		watermark, err := s.rollupWatermark(ctx, tier)
+		if err != nil {
+			return err
+		}
+		if err := s.rollUpLate(ctx, tier, watermark); err != nil {
+			return fmt.Errorf("%s rollup failed: %w", tier.precision, err)
+		}
+	}
+	return nil
+}
+
+// markLate records the hours of events written after those hours may have been rolled up,
+// as backfilled events are. Tenants' events are left out, since their tables aren't rolled up.
+func markLate(events []*structs.Event) {
+	for _, t := range rollupTiers {
+		if !t.enabled() {
+			continue
+		}
+		t.mu.Lock()
+		if t.late == nil {
+			t.late = make(map[time.Time]bool)
+		}
+		for _, event := range events {
+			if event.Tenant == "" {
+				t.late[event.Timestamp.UTC().Truncate(time.Hour)] = true
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// rollUpLate rolls up again the late hours before watermark, so their rollups include the
+// events written since. Later hours haven't been rolled up yet and will be in turn. Hours
+// that fail stay late, for the next run.
+func (s *Service) rollUpLate(ctx context.Context, t *rollupTier, watermark time.Time) error {
+	t.mu.Lock()
+	var hours []time.Time
+	for hour := range t.late {
+		if hour.Before(watermark) {
+			hours = append(hours, hour)
+		}
+	}
+	clear(t.late)
+	t.mu.Unlock()
+	slices.SortFunc(hours, time.Time.Compare)
+
+	for i := 0; i < len(hours); {
+		// Consecutive hours are rolled up together, a day at most, like rollUp does
+		j := i + 1
+		for j < len(hours) && j-i < 24 && hours[j].Equal(hours[j-1].Add(time.Hour)) {
+			j++
+		}
+		// Part of the last hour may not be rolled up yet at minute precision
+		end := hours[j-1].Add(time.Hour)
+		if end.After(watermark) {
+			end = watermark
+		}
+		if err := s.rollUpRange(ctx, t, hours[i], end); err != nil {
+			t.mu.Lock()
+			for _, hour := range hours[i:] {
+				t.late[hour] = true
+			}
+			t.mu.Unlock()
+			return err
+		}
+		i = j
+	}
+	return nil
+}
+
+// rollUpRange writes the aggregates of the events in [start, end), one row per step,
+// dimensions, and field, reading the events once
+func (s *Service) rollUpRange(ctx context.Context, t *rollupTier, start, end time.Time) error {
+	values := []string{"('', toNullable(toFloat64(0)))"}
+	for _, field := range rollupFields {
+		expr, err := buildNumericFieldExpr(field)
+		if err != nil {
+			return err
+		}
+		values = append(values, fmt.Sprintf("('%s', %s)", field, expr))
+	}
+	if spanMetrics {
+		values = append(values,
+			fmt.Sprintf("('%s', if(%s, toNullable(toFloat64(0)), NULL))", spanRollupPrefix, spanCondition),
+			fmt.Sprintf("('%s%s', if(%s, %s, NULL))", spanRollupPrefix, spanDurationField, spanCondition, dataNumberExpr("duration_ms")))
+	}
+	bucket, err := bucketExpr(t.interval, "timestamp")
+	if err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf(`INSERT INTO %[1]s.%[2]s (%[3]s, service, env, name, level, field, count, sum, min, max, quantiles)
+		SELECT %[4]s AS %[3]s, service, env, name, level, value.1 AS field,
+			count(), sum(assumeNotNull(value.2)), min(assumeNotNull(value.2)), max(assumeNotNull(value.2)),
+			quantileTDigestState(assumeNotNull(value.2))
+		FROM %[5]s ARRAY JOIN arrayFilter(v -> isNotNull(v.2), [%[6]s]) AS value
+		WHERE timestamp >= ? AND timestamp < ?
+		GROUP BY %[3]s, service, env, name, level, field`,
+		db.Database, t.table, t.column, bucket, eventsTable(ctx), strings.Join(values, ", "))
+	if err := s.store.Exec(ctx, sql, start, end); err != nil {
+		return fmt.Errorf("failed to roll up %s to %s: %w", start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// rollupWatermark returns the end of the tier's last rolled-up step, or zero when nothing
+// has been rolled up
+func (s *Service) rollupWatermark(ctx context.Context, t *rollupTier) (time.Time, error) {
+	var last time.Time
+	if err := s.queryRow(ctx, fmt.Sprintf("SELECT max(%s) FROM %s.%s", t.column, db.Database, t.table)).Scan(&last); err != nil {
+		return time.Time{}, fmt.Errorf("query failed: %w", err)
+	}
+	if last.Unix() <= 0 {
+		return time.Time{}, nil
+	}
+	return last.UTC().Add(t.step), nil
+}
+
+// rawRetentionDays returns the shortest and longest retention of the events tables, not
+// counting the replay table
+func rawRetentionDays() (int, int) {
+	shortest, longest := db.RetentionDays, db.RetentionDays
+	for _, table := range db.EventTables() {
+		if db.ReplayTable != nil && table == *db.ReplayTable {
+			continue
+		}
+		shortest = min(shortest, table.RetentionDays)
+		longest = max(longest, table.RetentionDays)
+	}
+	return shortest, longest
+}
+
+// rawStart returns the earliest time the TTL of a table kept for days hasn't expired yet.
+// The TTL is by date, so a day's events expire together.
+func rawStart(now time.Time, days int) time.Time {
+	return now.Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+}
+
+// tierFor returns the rollup tier a time series can read, or nil when it needs raw
+// events: minute series read minute rollups and longer intervals hour rollups, when the
+// aggregation and field are rolled up and it groups and filters only by rollup dimensions.
+// Series of spans read the span rollups, so need span metrics.
+func tierFor(query *structs.TimeSeriesQuery) *rollupTier {
+	if query.Window != "" || (query.Spans && !spanMetrics) {
+		return nil
+	}
+	var tier *rollupTier
+	switch query.Interval {
+	case structs.IntervalMinute:
+		tier = minuteTier
+	case structs.IntervalHour, structs.IntervalDay, structs.IntervalWeek, structs.IntervalMonth:
+		tier = hourTier
+	default:
+		return nil
+	}
+	if !tier.enabled() {
+		return nil
+	}
+
+	switch query.Aggregation {
+	case structs.AggCount, structs.AggErrorRate:
+	case structs.AggSum, structs.AggAvg, structs.AggMin, structs.AggMax,
+		structs.AggP50, structs.AggP90, structs.AggP95, structs.AggP99:
+		if query.Spans && query.Field != spanDurationField {
+			return nil
+		}
+		if !query.Spans && !slices.Contains(rollupFields, query.Field) {
+			return nil
+		}
+	default:
+		return nil
+	}
+	for _, g := range query.GroupBy {
+		if !rollupDimensions[g] {
+			return nil
+		}
+	}
+	for _, f := range query.Filters {
+		if !rollupDimensions[f.Field] {
+			return nil
+		}
+	}
+	return tier
+}
+
+// maxTimeSeriesDuration is the longest time range of a time series; series that can read
+// rollups may span their retention, unless time series have a maximum of their own
+func maxTimeSeriesDuration(query *structs.TimeSeriesQuery) time.Duration {
+	limit := maxRangeFor(RangeTimeSeries)
+	if _, own := rangeMaxes[RangeTimeSeries]; own {
+		return limit
+	}
+	if tier := tierFor(query); tier != nil {
+		return max(limit, time.Duration(tier.retentionDays())*24*time.Hour)
+	}
+	return limit
+}
+
+// timeSeriesPlan is where a time series reads from: the rollups of tier before boundary
+// and raw events after it, or only raw events when tier is nil
+type timeSeriesPlan struct {
+	tier     *rollupTier
+	boundary time.Time
+}
+
+func (p *timeSeriesPlan) precision() structs.Precision {
+	if p.tier == nil {
+		return structs.PrecisionRaw
+	}
+	return p.tier.precision
+}
+
+// planTimeSeries picks the source of a time series. Rollups hold far fewer rows than raw
+// events, so a series that can read them does for every step rolled up so far, and reads
+// raw events for the recent steps that aren't. Series starting after the last rolled-up
+// step read raw events, and so do tenants, whose tables aren't rolled up.
+func (s *Service) planTimeSeries(ctx context.Context, query *structs.TimeSeriesQuery) (*timeSeriesPlan, error) {
+	tier := tierFor(query)
+	if tier == nil || query.From.IsZero() || TenantFromContext(ctx) != "" {
+		return &timeSeriesPlan{}, nil
+	}
+	watermark, err := s.rollupWatermark(ctx, tier)
+	if err != nil {
+		return nil, err
+	}
+	if !watermark.After(query.From) {
+		return &timeSeriesPlan{}, nil
+	}
+	return &timeSeriesPlan{tier: tier, boundary: watermark}, nil
+}
+
+// rollupPartials are the partial aggregates an aggregation needs for each step: how to
+// compute each from raw events (x is the field) and from the rollup columns, and how the
+// bucket value merges them
+type rollupPartials struct {
+	raw    []string
+	rollup []string
+	value  string
+}
+
+func partialsFor(agg structs.AggregationType) rollupPartials {
+	switch agg {
+	case structs.AggSum:
+		return rollupPartials{[]string{"sum(x) AS total"}, []string{"sum AS total"}, "sum(total)"}
+	case structs.AggAvg:
+		return rollupPartials{[]string{"sum(x) AS total", "count() AS n"}, []string{"sum AS total", "count AS n"}, "sum(total) / sum(n)"}
+	case structs.AggMin:
+		return rollupPartials{[]string{"min(x) AS low"}, []string{"min AS low"}, "min(low)"}
+	case structs.AggMax:
+		return rollupPartials{[]string{"max(x) AS high"}, []string{"max AS high"}, "max(high)"}
+	case structs.AggP50, structs.AggP90, structs.AggP95, structs.AggP99:
+		level := map[structs.AggregationType]string{structs.AggP50: "0.5", structs.AggP90: "0.9", structs.AggP95: "0.95", structs.AggP99: "0.99"}[agg]
+		return rollupPartials{[]string{"quantileTDigestState(x) AS digest"}, []string{"quantiles AS digest"}, fmt.Sprintf("quantileTDigestMerge(%s)(digest)", level)}
+	case structs.AggErrorRate:
+		return rollupPartials{
+			[]string{fmt.Sprintf("countIf(%s) AS errors", errorLevels), "count() AS n"},
+			[]string{fmt.Sprintf("if(%s, count, 0) AS errors", errorLevels), "count AS n"},
+			"sum(errors) / greatest(sum(n), 1)",
+		}
+	default:
+		return rollupPartials{[]string{"count() AS n"}, []string{"count AS n"}, "sum(n)"}
+	}
+}
+
+// buildStitchedSQL builds a time series that reads the plan's rollups before its boundary
+// and raw events after it. Both sides are reduced to partial aggregates per step and
+// merged into the query's buckets, so a bucket spanning the boundary is complete.
+// Rollups are by whole steps, so the first bucket starts at the step of from.
+func buildStitchedSQL(ctx context.Context, query *structs.TimeSeriesQuery, plan *timeSeriesPlan) (string, []interface{}, error) {
+	tier := plan.tier
+	bucket, err := bucketExpr(query.Interval, "step")
+	if err != nil {
+		return "", nil, err
+	}
+	stepExpr, err := bucketExpr(tier.interval, "timestamp")
+	if err != nil {
+		return "", nil, err
+	}
+	selectParts := []string{bucket + " AS bucket"}
+	groupByParts := []string{"bucket"}
+
+	partials := partialsFor(query.Aggregation)
+	selectParts = append(selectParts, fmt.Sprintf("toFloat64(%s) AS value", partials.value))
+	if len(query.GroupBy) > 0 {
+		groupByExprs, aliases, err := buildGroupByExprs(query.GroupBy)
+		if err != nil {
+			return "", nil, err
+		}
+		selectParts = append(selectParts, groupByExprs...)
+		groupByParts = append(groupByParts, aliases...)
+	}
+
+	// Filters and access restrictions are on rollup dimensions, so they apply to both sides
+	var conditions []string
+	var conditionArgs []interface{}
+	if clause, clauseArgs, err := buildFilterClause(query.Filters); err != nil {
+		return "", nil, err
+	} else if clause != "" {
+		conditions = append(conditions, clause)
+		conditionArgs = append(conditionArgs, clauseArgs...)
+	}
+	if clause, clauseArgs := accessClause(ctx); clause != "" {
+		conditions = append(conditions, clause)
+		conditionArgs = append(conditionArgs, clauseArgs...)
+	}
+
+	rawWhere := []string{"timestamp >= ?"}
+	rawArgs := []interface{}{plan.boundary}
+	if !query.To.IsZero() {
+		rawWhere = append(rawWhere, "timestamp <= ?")
+		rawArgs = append(rawArgs, query.To)
+	}
+	raw := strings.Join(partials.raw, ", ")
+	field := ""
+	if query.Aggregation != structs.AggCount && query.Aggregation != structs.AggErrorRate {
+		field = query.Field
+		expr, err := buildNumericFieldExpr(field)
+		if err != nil {
+			return "", nil, err
+		}
+		raw = strings.ReplaceAll(raw, "(x)", "(assumeNotNull("+expr+"))")
+		rawWhere = append(rawWhere, fmt.Sprintf("isNotNull(%s)", expr))
+	}
+	if query.Spans {
+		rawWhere = append(rawWhere, spanCondition)
+		field = spanRollupPrefix + field
+	}
+	rawWhere = append(rawWhere, conditions...)
+	rawArgs = append(rawArgs, conditionArgs...)
+
+	rollupStart, err := bucketExpr(tier.interval, "?")
+	if err != nil {
+		return "", nil, err
+	}
+	rollupWhere := []string{"field = ?", fmt.Sprintf("%s >= %s", tier.column, rollupStart), tier.column + " < ?"}
+	rollupWhere = append(rollupWhere, conditions...)
+	rollupArgs := append([]interface{}{field, query.From, plan.boundary}, conditionArgs...)
+	if !query.To.IsZero() {
+		rollupWhere = append(rollupWhere, tier.column+" <= ?")
+		rollupArgs = append(rollupArgs, query.To)
+	}
+
+	sql := fmt.Sprintf(`SELECT %s FROM (
+		SELECT %s AS step, service, env, name, level, %s FROM %s WHERE %s
+		GROUP BY step, service, env, name, level
+		UNION ALL
+		SELECT %s AS step, service, env, name, level, %s FROM %s.%s FINAL WHERE %s
+	) GROUP BY %s ORDER BY bucket ASC`,
+		strings.Join(selectParts, ", "),
+		stepExpr, raw, eventsTable(ctx), strings.Join(rawWhere, " AND "),
+		tier.column, strings.Join(partials.rollup, ", "), db.Database, tier.table, strings.Join(rollupWhere, " AND "),
+		strings.Join(groupByParts, ", "))
+	return sql, append(rawArgs, rollupArgs...), nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	// MaxRUMBodySize limits beacon bodies; browsers cap sendBeacon payloads at 64KB
+	MaxRUMBodySize = 64 * 1024
+	// maxRUMBeacons limits the number of beacons in a single batched request
+	maxRUMBeacons = 20
+
+	maxRUMURLLength     = 2048
+	maxRUMMessageLength = 1024
+	maxRUMStackLength   = 8192
+	maxRUMIDLength      = 128
+	maxRUMVitalValue    = 3600000
+)
+
+var (
+	// rumNameRegex restricts client-supplied service and env names
+	rumNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+	// rumSessionRegex restricts session IDs to URL-safe tokens
+	rumSessionRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+	rumVitals = map[string]bool{
+		"LCP": true, "FID": true, "INP": true, "CLS": true, "FCP": true, "TTFB": true,
+	}
+	rumRatings = map[string]bool{
+		"good": true, "needs-improvement": true, "poor": true,
+	}
+)
+
+// RUMBeacon is a single page view, web vital, or JavaScript error reported by a browser
+type RUMBeacon struct {
+	Type      string `json:"type"`
+	Service   string `json:"service"`
+	Env       string `json:"env"`
+	URL       string `json:"url"`
+	Referrer  string `json:"referrer"`
+	SessionID string `json:"session_id"`
+	UserID    string `json:"user_id"`
+
+	// Web vital fields
+	Name   string   `json:"name"`
+	Value  *float64 `json:"value"`
+	Rating string   `json:"rating"`
+
+	// JavaScript error fields
+	Message string `json:"message"`
+	Source  string `json:"source"`
+	Lineno  int    `json:"lineno"`
+	Colno   int    `json:"colno"`
+	Stack   string `json:"stack"`
+}
+
+// ParseRUMBeacons decodes a POST body containing a single beacon or an array of beacons
+// Unknown fields are rejected so that malformed or hostile payloads fail loudly
+func ParseRUMBeacons(body []byte) ([]RUMBeacon, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, errors.New("empty body")
+	}
+
+	var beacons []RUMBeacon
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	if body[0] == '[' {
+		if err := decoder.Decode(&beacons); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		var beacon RUMBeacon
+		if err := decoder.Decode(&beacon); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		beacons = append(beacons, beacon)
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+
+	if len(beacons) == 0 {
+		return nil, errors.New("no beacons in request")
+	}
+	if len(beacons) > maxRUMBeacons {
+		return nil, fmt.Errorf("too many beacons (max %d)", maxRUMBeacons)
+	}
+	return beacons, nil
+}
+
+// RUMBeaconFromQuery builds a beacon from GET query parameters (image pixel or sendBeacon fallback)
+func RUMBeaconFromQuery(q url.Values) (RUMBeacon, error) {
+	beacon := RUMBeacon{
+		Type:      q.Get("type"),
+		Service:   q.Get("service"),
+		Env:       q.Get("env"),
+		URL:       q.Get("url"),
+		Referrer:  q.Get("referrer"),
+		SessionID: q.Get("session_id"),
+		UserID:    q.Get("user_id"),
+		Name:      q.Get("name"),
+		Rating:    q.Get("rating"),
+		Message:   q.Get("message"),
+		Source:    q.Get("source"),
+		Stack:     q.Get("stack"),
+	}
+
+	if v := q.Get("value"); v != "" {
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return beacon, errors.New("value must be a number")
+		}
+		beacon.Value = &value
+	}
+	for param, dest := range map[string]*int{"lineno": &beacon.Lineno, "colno": &beacon.Colno} {
+		if v := q.Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return beacon, fmt.Errorf("%s must be an integer", param)
+			}
+			*dest = n
+		}
+	}
+
+	return beacon, nil
+}
+
+// BuildRUMEvent validates a beacon and converts it into an event
+// The timestamp is always the receive time since browser clocks can't be trusted
+func BuildRUMEvent(b RUMBeacon, defaultService, userAgent string, now time.Time) (*structs.Event, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	event := &structs.Event{
+		Timestamp: now,
+		Service:   b.Service,
+		Env:       b.Env,
+		UserID:    b.UserID,
+		Name:      "rum." + b.Type,
+		Level:     "info",
+		Data:      map[string]interface{}{},
+	}
+	if event.Service == "" {
+		event.Service = defaultService
+	}
+
+	setIfNotEmpty(event.Data, "url", b.URL)
+	if u, err := url.Parse(b.URL); err == nil && b.URL != "" {
+		event.Data["path"] = u.Path
+		event.Data["host"] = u.Host
+	}
+	setIfNotEmpty(event.Data, "referrer", b.Referrer)
+	setIfNotEmpty(event.Data, "session_id", b.SessionID)
+	setIfNotEmpty(event.Data, "user_agent", truncateString(userAgent, maxRUMMessageLength))
+
+	switch b.Type {
+	case "vital":
+		event.Data["vital"] = b.Name
+		event.Data["value"] = *b.Value
+		setIfNotEmpty(event.Data, "rating", b.Rating)
+		if b.Rating == "poor" {
+			event.Level = "warn"
+		}
+	case "error":
+		event.Level = "error"
+		event.Data["message"] = b.Message
+		setIfNotEmpty(event.Data, "source", b.Source)
+		if b.Lineno > 0 {
+			event.Data["lineno"] = b.Lineno
+		}
+		if b.Colno > 0 {
+			event.Data["colno"] = b.Colno
+		}
+		setIfNotEmpty(event.Data, "stack", b.Stack)
+	}
+
+	return event, nil
+}
+
+func (b *RUMBeacon) validate() error {
+	switch b.Type {
+	case "pageview", "vital", "error":
+	case "":
+		return errors.New("type is required")
+	default:
+		return errors.New("type must be one of pageview, vital, error")
+	}
+
+	if b.Service != "" && !rumNameRegex.MatchString(b.Service) {
+		return errors.New("service contains invalid characters")
+	}
+	if b.Env != "" && !rumNameRegex.MatchString(b.Env) {
+		return errors.New("env contains invalid characters")
+	}
+	if err := validateRUMURL("url", b.URL); err != nil {
+		return err
+	}
+	if err := validateRUMURL("referrer", b.Referrer); err != nil {
+		return err
+	}
+	if b.SessionID != "" && !rumSessionRegex.MatchString(b.SessionID) {
+		return errors.New("session_id contains invalid characters")
+	}
+	if len(b.UserID) > maxRUMIDLength {
+		return fmt.Errorf("user_id exceeds %d characters", maxRUMIDLength)
+	}
+
+	switch b.Type {
+	case "vital":
+		if !rumVitals[b.Name] {
+			return errors.New("name must be one of LCP, FID, INP, CLS, FCP, TTFB")
+		}
+		if b.Value == nil {
+			return errors.New("value is required for vitals")
+		}
+		if math.IsNaN(*b.Value) || *b.Value < 0 || *b.Value > maxRUMVitalValue {
+			return errors.New("value is out of range")
+		}
+		if b.Rating != "" && !rumRatings[b.Rating] {
+			return errors.New("rating must be one of good, needs-improvement, poor")
+		}
+	case "error":
+		if b.Message == "" {
+			return errors.New("message is required for errors")
+		}
+		if len(b.Message) > maxRUMMessageLength {
+			return fmt.Errorf("message exceeds %d characters", maxRUMMessageLength)
+		}
+		if err := validateRUMURL("source", b.Source); err != nil {
+			return err
+		}
+		if len(b.Stack) > maxRUMStackLength {
+			return fmt.Errorf("stack exceeds %d characters", maxRUMStackLength)
+		}
+		if b.Lineno < 0 || b.Colno < 0 {
+			return errors.New("lineno and colno must not be negative")
+		}
+	}
+
+	return nil
+}
+
+// validateRUMURL allows empty values or absolute http(s) URLs
+func validateRUMURL(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) > maxRUMURLLength {
+		return fmt.Errorf("%s exceeds %d characters", field, maxRUMURLLength)
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute http(s) URL", field)
+	}
+	return nil
+}
+
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max], "")
+}
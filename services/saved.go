@@ -0,0 +1,412 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Saved query types, matching the query endpoints
+const (
+	SavedQueryAnalytics  = "analytics"
+	SavedQueryTimeSeries = "timeseries"
+	SavedQueryTopN       = "topn"
+	SavedQueryGauge      = "gauge"
+	SavedQueryCompare    = "compare"
+)
+
+// Variable types
+const (
+	VariableString = "string"
+	VariableNumber = "number"
+	VariableTime   = "time"
+	VariableList   = "list"
+)
+
+// variableRegex matches {{name}} references in a saved query
+var variableRegex = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\}\}`)
+
+// QueryVariable declares a variable of a saved query
+type QueryVariable struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Default  interface{} `json:"default,omitempty"`
+	Required bool        `json:"required,omitempty"`
+}
+
+// SavedQuery is a named query definition whose values may reference {{variables}}
+type SavedQuery struct {
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Query     json.RawMessage `json:"query"`
+	Variables []QueryVariable `json:"variables"`
+}
+
+// ListSavedQueries returns every saved query
+func (s *Service) ListSavedQueries(ctx context.Context) ([]SavedQuery, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf("SELECT name, type, query, variables FROM %s.saved_queries FINAL WHERE deleted = 0 ORDER BY name", db.Database))
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	queries := []SavedQuery{}
+	for rows.Next() {
+		q, err := scanSavedQuery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, *q)
+	}
+	return queries, rows.Err()
+}
+
+// GetSavedQuery returns a saved query, or nil if it doesn't exist
+func (s *Service) GetSavedQuery(ctx context.Context, name string) (*SavedQuery, error) {
+	row := s.queryRow(ctx, fmt.Sprintf("SELECT name, type, query, variables FROM %s.saved_queries FINAL WHERE name = ? AND deleted = 0", db.Database), name)
+	q, err := scanSavedQuery(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return q, err
+}
+
+func scanSavedQuery(scan func(dest ...interface{}) error) (*SavedQuery, error) {
+	var q SavedQuery
+	var query, variables string
+	if err := scan(&q.Name, &q.Type, &query, &variables); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	q.Query = json.RawMessage(query)
+	if err := json.Unmarshal([]byte(variables), &q.Variables); err != nil {
+		return nil, fmt.Errorf("invalid stored variables for %s: %w", q.Name, err)
+	}
+	return &q, nil
+}
+
+// PutSavedQuery validates and stores a saved query. Every {{variable}} must be declared, and the
+// query must be valid with the variables' defaults (or placeholder values for required ones).
+func (s *Service) PutSavedQuery(ctx context.Context, q *SavedQuery) error {
+	if err := checkSavedQuery(q); err != nil {
+		return err
+	}
+	return s.writeSavedQuery(ctx, q)
+}
+
+// checkSavedQuery validates a saved query, defaulting its variables to none
+func checkSavedQuery(q *SavedQuery) error {
+	if !safeIdentifierRegex.MatchString(q.Name) {
+		return fmt.Errorf("invalid saved query name: %s", q.Name)
+	}
+	if len(q.Query) == 0 {
+		return fmt.Errorf("query is required")
+	}
+	if q.Variables == nil {
+		q.Variables = []QueryVariable{}
+	}
+
+	declared := make(map[string]bool)
+	sample := make(map[string]interface{})
+	for _, v := range q.Variables {
+		if !safeIdentifierRegex.MatchString(v.Name) || declared[v.Name] {
+			return fmt.Errorf("invalid variable name: %s", v.Name)
+		}
+		declared[v.Name] = true
+		if v.Default != nil {
+			if _, err := coerceVariable(v, v.Default); err != nil {
+				return err
+			}
+		} else if !v.Required {
+			return fmt.Errorf("invalid variable %s: a default is required unless the variable is required", v.Name)
+		}
+		sample[v.Name] = sampleValue(v)
+	}
+	for _, m := range variableRegex.FindAllStringSubmatch(string(q.Query), -1) {
+		if !declared[m[1]] {
+			return fmt.Errorf("invalid query: variable %s is not declared", m[1])
+		}
+	}
+
+	// Dry-run the substitution and decoding, so a broken definition fails now instead of at run time
+	if _, err := bindSavedQuery(q, sample); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Service) writeSavedQuery(ctx context.Context, q *SavedQuery) error {
+	variables, err := json.Marshal(q.Variables)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.saved_queries (name, type, query, variables) VALUES (?, ?, ?, ?)", db.Database),
+		q.Name, q.Type, string(q.Query), string(variables)); err != nil {
+		return fmt.Errorf("failed to save query: %w", err)
+	}
+	return nil
+}
+
+// DeleteSavedQuery removes a saved query
+func (s *Service) DeleteSavedQuery(ctx context.Context, name string) error {
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.saved_queries (name, type, query, variables, deleted) VALUES (?, '', '', '[]', 1)", db.Database), name); err != nil {
+		return fmt.Errorf("failed to delete saved query: %w", err)
+	}
+	return nil
+}
+
+// RunSavedQuery substitutes values (falling back to defaults) into a saved query and runs it.
+// Variables only ever replace whole JSON values, which the query builders then validate or bind
+// as parameters, so a variable can't change the structure of the SQL.
+func (s *Service) RunSavedQuery(ctx context.Context, q *SavedQuery, values map[string]interface{}) (interface{}, error) {
+	query, err := resolveSavedQuery(q, values)
+	if err != nil {
+		return nil, err
+	}
+	return s.runQuery(ctx, q.Type, query)
+}
+
+// resolveSavedQuery binds values (falling back to defaults) into a saved query
+func resolveSavedQuery(q *SavedQuery, values map[string]interface{}) (interface{}, error) {
+	resolved := make(map[string]interface{}, len(q.Variables))
+	for _, v := range q.Variables {
+		raw, ok := values[v.Name]
+		if !ok || raw == nil {
+			if v.Required {
+				return nil, fmt.Errorf("variable %s is required", v.Name)
+			}
+			raw = v.Default
+		}
+		value, err := coerceVariable(v, raw)
+		if err != nil {
+			return nil, err
+		}
+		resolved[v.Name] = value
+	}
+
+	return bindSavedQuery(q, resolved)
+}
+
+// runQuery runs a bound query of a saved query type
+func (s *Service) runQuery(ctx context.Context, queryType string, query interface{}) (interface{}, error) {
+	switch query := query.(type) {
+	case *structs.AnalyticsQuery:
+		return s.QueryAnalytics(ctx, query)
+	case *structs.TimeSeriesQuery:
+		return s.QueryTimeSeries(ctx, query)
+	case *structs.TopNQuery:
+		return s.QueryTopN(ctx, query)
+	case *structs.GaugeQuery:
+		return s.QueryGauge(ctx, query)
+	case *structs.CompareQuery:
+		return s.QueryCompare(ctx, query)
+	default:
+		return nil, fmt.Errorf("invalid saved query type: %s", queryType)
+	}
+}
+
+// bindSavedQuery replaces every JSON string that is exactly {{name}} with the variable's
+// value and decodes the result into the query struct for the saved query's type
+func bindSavedQuery(q *SavedQuery, values map[string]interface{}) (interface{}, error) {
+	var tree interface{}
+	if err := json.Unmarshal(q.Query, &tree); err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	tree, err := substituteVariables(tree, values)
+	if err != nil {
+		return nil, err
+	}
+	bound, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	return decodeQuery(q.Type, bound)
+}
+
+// decodeQuery decodes a query into the struct for a saved query type
+func decodeQuery(queryType string, raw []byte) (interface{}, error) {
+	var query interface{}
+	switch queryType {
+	case SavedQueryAnalytics:
+		query = &structs.AnalyticsQuery{}
+	case SavedQueryTimeSeries:
+		query = &structs.TimeSeriesQuery{}
+	case SavedQueryTopN:
+		query = &structs.TopNQuery{}
+	case SavedQueryGauge:
+		query = &structs.GaugeQuery{}
+	case SavedQueryCompare:
+		query = &structs.CompareQuery{}
+	default:
+		return nil, fmt.Errorf("invalid saved query type: %s", queryType)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(query); err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	setDefaultAggregation(query)
+	return query, nil
+}
+
+func substituteVariables(node interface{}, values map[string]interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, v := range n {
+			replaced, err := substituteVariables(v, values)
+			if err != nil {
+				return nil, err
+			}
+			n[k] = replaced
+		}
+		return n, nil
+	case []interface{}:
+		for i, v := range n {
+			replaced, err := substituteVariables(v, values)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = replaced
+		}
+		return n, nil
+	case string:
+		m := variableRegex.FindStringSubmatchIndex(n)
+		if m == nil {
+			return n, nil
+		}
+		if m[0] != 0 || m[1] != len(n) {
+			return nil, fmt.Errorf("invalid query: variables must be a whole value, not part of %q", n)
+		}
+		return values[n[m[2]:m[3]]], nil
+	default:
+		return node, nil
+	}
+}
+
+// coerceVariable checks a value against the variable's type, parsing strings from query params
+func coerceVariable(v QueryVariable, raw interface{}) (interface{}, error) {
+	invalid := fmt.Errorf("invalid value for variable %s: expected %s", v.Name, v.Type)
+	switch v.Type {
+	case VariableString, "":
+		if s, ok := raw.(string); ok {
+			return s, nil
+		}
+		return nil, invalid
+	case VariableNumber:
+		switch n := raw.(type) {
+		case float64:
+			return n, nil
+		case string:
+			f, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			return f, nil
+		}
+		return nil, invalid
+	case VariableTime:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, invalid
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			unix, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			t = time.Unix(unix, 0)
+		}
+		return t.UTC().Format(time.RFC3339Nano), nil
+	case VariableList:
+		switch l := raw.(type) {
+		case []interface{}:
+			for _, item := range l {
+				if _, ok := item.(string); !ok {
+					return nil, invalid
+				}
+			}
+			return l, nil
+		case []string:
+			list := make([]interface{}, len(l))
+			for i, item := range l {
+				list[i] = item
+			}
+			return list, nil
+		case string:
+			var list []interface{}
+			for _, item := range strings.Split(l, ",") {
+				list = append(list, item)
+			}
+			return list, nil
+		}
+		return nil, invalid
+	default:
+		return nil, fmt.Errorf("invalid variable %s: unknown type %s", v.Name, v.Type)
+	}
+}
+
+// sampleValue is a stand-in used to validate a definition
+func sampleValue(v QueryVariable) interface{} {
+	if v.Default != nil {
+		value, _ := coerceVariable(v, v.Default)
+		return value
+	}
+	switch v.Type {
+	case VariableNumber:
+		return float64(1)
+	case VariableTime:
+		return time.Now().UTC().Format(time.RFC3339)
+	case VariableList:
+		return []interface{}{"sample"}
+	default:
+		return "sample"
+	}
+}
+
+// setDefaultAggregation defaults an empty aggregation to count, as the query endpoints do
+func setDefaultAggregation(query interface{}) {
+	switch q := query.(type) {
+	case *structs.AnalyticsQuery:
+		if q.Aggregation == "" {
+			q.Aggregation = structs.AggCount
+		}
+	case *structs.TimeSeriesQuery:
+		if q.Aggregation == "" {
+			q.Aggregation = structs.AggCount
+		}
+	case *structs.TopNQuery:
+		if q.Aggregation == "" {
+			q.Aggregation = structs.AggCount
+		}
+	case *structs.GaugeQuery:
+		if q.Aggregation == "" {
+			q.Aggregation = structs.AggCount
+		}
+	case *structs.CompareQuery:
+		if q.Aggregation == "" {
+			q.Aggregation = structs.AggCount
+		}
+	case *structs.FilterCompareQuery:
+		if q.Aggregation == "" {
+			q.Aggregation = structs.AggCount
+		}
+	case *structs.RatioQuery:
+		if q.Aggregation == "" {
+			q.Aggregation = structs.AggCount
+		}
+	}
+}
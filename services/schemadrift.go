@@ -0,0 +1,527 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	// schemaDriftSettle is how long events are given to arrive before their window is checked
+	schemaDriftSettle = time.Minute
+	// schemaLearningPeriod is how long a new event name's shape is learned before its drift
+	// is reported
+	schemaLearningPeriod = 24 * time.Hour
+	// schemaRemovedShare is the share of the previous window's events a key must have been in
+	// for its absence to be reported
+	schemaRemovedShare = 0.9
+	// schemaMinEvents is how many events a name needs in both windows for removed keys to be
+	// reported, so a handful of events missing an optional key aren't
+	schemaMinEvents = 100
+	// schemaMaxKeys is how many keys a name can have before new keys are neither learned nor
+	// reported: past it the keys are likely data, such as IDs used as keys
+	schemaMaxKeys = 500
+	// maxSchemaObservations caps the (name, key, type) rows read per window
+	maxSchemaObservations = 100000
+	// maxDriftsPerWindow caps the drift recorded per window, so a deploy renaming every key
+	// doesn't flood the alert channel
+	maxDriftsPerWindow = 100
+	// schemaDriftServices is how many of the services sending a change are kept
+	schemaDriftServices = 5
+
+	defaultSchemaDrifts = 100
+	maxSchemaDrifts     = 1000
+)
+
+// Schema drift kinds
+const (
+	DriftKeyAdded    = "key_added"
+	DriftKeyRemoved  = "key_removed"
+	DriftTypeChanged = "type_changed"
+)
+
+// SchemaDrift is a change in the data shape of an event name
+type SchemaDrift struct {
+	DetectedAt time.Time `json:"detected_at"`
+	Name       string    `json:"name"`
+	Key        string    `json:"key"`
+	Kind       string    `json:"kind"`
+	// Type is the key's new type; empty when the key was removed
+	Type string `json:"type"`
+	// PreviousTypes are the types the key was seen with before; empty when it was added
+	PreviousTypes []string `json:"previous_types"`
+	// Services are (up to 5 of) the services that sent the changed events
+	Services []string `json:"services"`
+	// Events is how many events in the window had the change
+	Events uint64 `json:"events"`
+}
+
+// SchemaDrifts is the schema drift recorded over a time range
+type SchemaDrifts struct {
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Drifts []SchemaDrift `json:"drifts"`
+}
+
+// schemaObservation is a data key of an event name seen with a type in a window; key is ”
+// for the events themselves
+type schemaObservation struct {
+	name     string
+	key      string
+	typ      string
+	events   uint64
+	services []string
+	lastSeen time.Time
+}
+
+// schemaShape is the learned shape of an event name
+type schemaShape struct {
+	firstSeen time.Time
+	// keys has the types each data key was seen with
+	keys map[string]map[string]bool
+}
+
+// schemaDriftDetector compares each window of events with the shapes learned so far
+type schemaDriftDetector struct {
+	svc      *Service
+	interval time.Duration
+	channel  string
+	// shapes is nil until loaded from event_schemas
+	shapes map[string]*schemaShape
+	// previous has each name's event count (under '') and key counts in the last window
+	previous map[string]map[string]uint64
+	// checked is the end of the last window checked
+	checked time.Time
+}
+
+// RunSchemaDrift checks the events received since the last check against the learned shape
+// of their names every interval, recording the drift found and sending it to channel when
+// set. It should run on a single instance.
+func (s *Service) RunSchemaDrift(ctx context.Context, interval time.Duration, channel string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	d := &schemaDriftDetector{svc: s, interval: interval, channel: channel}
+	for {
+		if err := d.check(ctx, time.Now().UTC()); err != nil {
+			log.Printf("schema drift check failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckSchemaDriftChannel validates the alert channel schema drift is sent to
+func CheckSchemaDriftChannel(channel string) error {
+	if channel == "" {
+		return nil
+	}
+	return checkAlertChannels([]string{channel})
+}
+
+// check compares the settled events after the last window with the learned shapes; the
+// first window after starting is one interval long
+func (d *schemaDriftDetector) check(ctx context.Context, now time.Time) error {
+	if d.shapes == nil {
+		shapes, err := d.svc.loadSchemaShapes(ctx)
+		if err != nil {
+			return err
+		}
+		d.shapes = shapes
+	}
+
+	to := now.Add(-schemaDriftSettle)
+	from := d.checked
+	if from.IsZero() {
+		from = to.Add(-d.interval)
+	}
+	if !from.Before(to) {
+		return nil
+	}
+
+	observations, err := d.svc.observeSchemas(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	drifts := d.compare(observations, to)
+	if err := d.svc.recordSchemaDrifts(ctx, drifts); err != nil {
+		return err
+	}
+	if err := d.learn(ctx, observations, to); err != nil {
+		return err
+	}
+	d.checked = to
+
+	for _, drift := range drifts {
+		EmitInternal("schema.drift", "warn", map[string]interface{}{
+			"name":           drift.Name,
+			"key":            drift.Key,
+			"kind":           drift.Kind,
+			"type":           drift.Type,
+			"previous_types": drift.PreviousTypes,
+			"services":       drift.Services,
+			"events":         drift.Events,
+		})
+	}
+	if d.channel != "" && len(drifts) > 0 {
+		notifyAlertChannel(ctx, &structs.AlertRule{Name: "schema_drift"}, d.channel, schemaDriftAlerts(drifts))
+	}
+	return nil
+}
+
+// compare finds the drift in a window's observations: keys new to a name, known keys seen
+// with a new type, and keys nearly every event of the previous window had that none had in
+// this one. Names still being learned are only learned.
+func (d *schemaDriftDetector) compare(observations []schemaObservation, now time.Time) []SchemaDrift {
+	byName := map[string][]schemaObservation{}
+	for _, o := range observations {
+		byName[o.name] = append(byName[o.name], o)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	drifts := []SchemaDrift{}
+	previous := make(map[string]map[string]uint64, len(byName))
+	for _, name := range names {
+		current := map[string]uint64{}
+		var services []string
+		for _, o := range byName[name] {
+			current[o.key] += o.events
+			if o.key == "" {
+				services = o.services
+			}
+		}
+		last := d.previous[name]
+		previous[name] = current
+
+		shape := d.shapes[name]
+		if shape == nil || now.Sub(shape.firstSeen) < schemaLearningPeriod {
+			continue
+		}
+
+		// Observations are ordered by events, so a new key is reported with its main type
+		reported := map[string]bool{}
+		for _, o := range byName[name] {
+			if o.key == "" || reported[o.key] {
+				continue
+			}
+			types, known := shape.keys[o.key]
+			switch {
+			case !known && len(shape.keys) < schemaMaxKeys:
+				drifts = append(drifts, o.drift(now, DriftKeyAdded, nil))
+				reported[o.key] = true
+			case known && o.typ != "null" && !types[o.typ]:
+				drifts = append(drifts, o.drift(now, DriftTypeChanged, knownTypes(types)))
+			}
+		}
+
+		if last[""] < schemaMinEvents || current[""] < schemaMinEvents {
+			continue
+		}
+		keys := make([]string, 0, len(last))
+		for key := range last {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "" || current[key] > 0 || float64(last[key]) < schemaRemovedShare*float64(last[""]) {
+				continue
+			}
+			drifts = append(drifts, SchemaDrift{
+				DetectedAt:    now,
+				Name:          name,
+				Key:           key,
+				Kind:          DriftKeyRemoved,
+				PreviousTypes: knownTypes(shape.keys[key]),
+				Services:      services,
+				Events:        current[""],
+			})
+		}
+	}
+	d.previous = previous
+
+	if len(drifts) > maxDriftsPerWindow {
+		log.Printf("schema drift: %d changes found, recording the first %d", len(drifts), maxDriftsPerWindow)
+		drifts = drifts[:maxDriftsPerWindow]
+	}
+	return drifts
+}
+
+func (o schemaObservation) drift(now time.Time, kind string, previousTypes []string) SchemaDrift {
+	if previousTypes == nil {
+		previousTypes = []string{}
+	}
+	return SchemaDrift{
+		DetectedAt:    now,
+		Name:          o.name,
+		Key:           o.key,
+		Kind:          kind,
+		Type:          o.typ,
+		PreviousTypes: previousTypes,
+		Services:      o.services,
+		Events:        o.events,
+	}
+}
+
+// knownTypes lists a key's learned types, leaving out null, which any key can be
+func knownTypes(types map[string]bool) []string {
+	list := []string{}
+	for typ := range types {
+		if typ != "null" {
+			list = append(list, typ)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// learn adds a window's observations to the learned shapes, in memory and in event_schemas.
+// Names past schemaMaxKeys keys only learn new types of their known keys. A name is first
+// seen when it is detected, now, so its learning period runs from then even when its events'
+// timestamps are much older, as backfilled ones are.
+func (d *schemaDriftDetector) learn(ctx context.Context, observations []schemaObservation, now time.Time) error {
+	learned := make([]schemaObservation, 0, len(observations))
+	for _, o := range observations {
+		shape := d.shapes[o.name]
+		if shape == nil {
+			shape = &schemaShape{firstSeen: now, keys: map[string]map[string]bool{}}
+			d.shapes[o.name] = shape
+		}
+		if o.key == "" {
+			learned = append(learned, o)
+			continue
+		}
+		types, known := shape.keys[o.key]
+		if !known {
+			if len(shape.keys) >= schemaMaxKeys {
+				continue
+			}
+			types = map[string]bool{}
+			shape.keys[o.key] = types
+		}
+		types[o.typ] = true
+		learned = append(learned, o)
+	}
+	if len(learned) == 0 {
+		return nil
+	}
+
+	batch, err := d.svc.store.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.event_schemas (name, key, type, events, first_seen, last_seen)", db.Database))
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+	for _, o := range learned {
+		if err := batch.Append(o.name, o.key, o.typ, o.events, now, o.lastSeen); err != nil {
+			return fmt.Errorf("failed to append schema: %w", err)
+		}
+	}
+	return batch.Send()
+}
+
+func (s *Service) recordSchemaDrifts(ctx context.Context, drifts []SchemaDrift) error {
+	if len(drifts) == 0 {
+		return nil
+	}
+	batch, err := s.store.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.schema_drifts (detected_at, name, key, kind, type, previous_types, services, events)", db.Database))
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+	for _, drift := range drifts {
+		if err := batch.Append(drift.DetectedAt, drift.Name, drift.Key, drift.Kind, drift.Type, drift.PreviousTypes, drift.Services, drift.Events); err != nil {
+			return fmt.Errorf("failed to append schema drift: %w", err)
+		}
+	}
+	return batch.Send()
+}
+
+// loadSchemaShapes reads the learned shapes
+func (s *Service) loadSchemaShapes(ctx context.Context) (map[string]*schemaShape, error) {
+	rows, err := s.queryRows(ctx, fmt.Sprintf("SELECT name, key, type, min(first_seen) FROM %s.event_schemas GROUP BY name, key, type", db.Database))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load event schemas: %w", err)
+	}
+	defer rows.Close()
+
+	shapes := map[string]*schemaShape{}
+	for rows.Next() {
+		var name, key, typ string
+		var firstSeen time.Time
+		if err := rows.Scan(&name, &key, &typ, &firstSeen); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		shape := shapes[name]
+		if shape == nil {
+			shape = &schemaShape{firstSeen: firstSeen, keys: map[string]map[string]bool{}}
+			shapes[name] = shape
+		}
+		if firstSeen.Before(shape.firstSeen) {
+			shape.firstSeen = firstSeen
+		}
+		if key == "" {
+			continue
+		}
+		if shape.keys[key] == nil {
+			shape.keys[key] = map[string]bool{}
+		}
+		shape.keys[key][typ] = true
+	}
+	return shapes, rows.Err()
+}
+
+// observeSchemas reads the names, and the keys and types of their data, of the events
+// received in [from, to), leaving out monitor-core's own. Windows are by received_at, not
+// timestamp, so late and backfilled events are checked once, in the window they arrive in.
+// Integers and floats are both number, as the same key is often sent as either.
+func (s *Service) observeSchemas(ctx context.Context, from, to time.Time) ([]schemaObservation, error) {
+	table := eventsTable(ctx)
+	var observations []schemaObservation
+
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`
+		SELECT name, count(), groupUniqArray(%d)(service), max(received_at)
+		FROM %s
+		WHERE received_at >= ? AND received_at < ? AND service != ?
+		GROUP BY name
+		LIMIT %d
+	`, schemaDriftServices, table, maxSchemaObservations), from, to, SelfServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o schemaObservation
+		if err := rows.Scan(&o.name, &o.events, &o.services, &o.lastSeen); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		observations = append(observations, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keyRows, err := s.queryRows(ctx, fmt.Sprintf(`
+		SELECT name, key, type, count() AS events, groupUniqArray(%d)(service), max(received_at)
+		FROM (
+			SELECT name, service, received_at, key,
+				multiIf(raw_type IN ('Int64', 'UInt64', 'Double'), 'number', lower(raw_type)) AS type
+			FROM (
+				SELECT name, service, received_at, key, toString(JSONType(data, key)) AS raw_type
+				FROM %s
+				ARRAY JOIN JSONExtractKeys(data) AS key
+				WHERE received_at >= ? AND received_at < ? AND service != ?
+			)
+		)
+		GROUP BY name, key, type
+		ORDER BY events DESC
+		LIMIT %d
+	`, schemaDriftServices, table, maxSchemaObservations), from, to, SelfServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer keyRows.Close()
+	for keyRows.Next() {
+		var o schemaObservation
+		if err := keyRows.Scan(&o.name, &o.key, &o.typ, &o.events, &o.services, &o.lastSeen); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		observations = append(observations, o)
+	}
+	return observations, keyRows.Err()
+}
+
+// schemaDriftAlerts converts drift to firing alerts for an alert channel
+func schemaDriftAlerts(drifts []SchemaDrift) []AlertmanagerAlert {
+	alerts := make([]AlertmanagerAlert, 0, len(drifts))
+	for _, drift := range drifts {
+		labels := map[string]string{
+			alertNameLabel: "schema_drift",
+			"event_name":   drift.Name,
+			"key":          drift.Key,
+			"kind":         drift.Kind,
+			"severity":     "warning",
+		}
+		alerts = append(alerts, AlertmanagerAlert{
+			Status:      "firing",
+			Labels:      labels,
+			Annotations: map[string]string{"summary": drift.summary()},
+			StartsAt:    drift.DetectedAt,
+			Fingerprint: alertFingerprint(labels),
+		})
+	}
+	return alerts
+}
+
+func (d SchemaDrift) summary() string {
+	switch d.Kind {
+	case DriftKeyAdded:
+		return fmt.Sprintf("%s events have a new data key %s (%s)", d.Name, d.Key, d.Type)
+	case DriftTypeChanged:
+		return fmt.Sprintf("%s events have data key %s as %s, previously %s", d.Name, d.Key, d.Type, strings.Join(d.PreviousTypes, ", "))
+	default:
+		return fmt.Sprintf("%s events no longer have data key %s", d.Name, d.Key)
+	}
+}
+
+// GetSchemaDrifts returns the schema drift recorded in [from, to), newest first, optionally
+// for one event name and kind of drift
+func (s *Service) GetSchemaDrifts(ctx context.Context, from, to time.Time, name, kind string, limit int) (*SchemaDrifts, error) {
+	if kind != "" && kind != DriftKeyAdded && kind != DriftKeyRemoved && kind != DriftTypeChanged {
+		return nil, fmt.Errorf("invalid kind: %s (must be %s, %s, or %s)", kind, DriftKeyAdded, DriftKeyRemoved, DriftTypeChanged)
+	}
+	if limit <= 0 {
+		limit = defaultSchemaDrifts
+	}
+	if limit > maxSchemaDrifts {
+		limit = maxSchemaDrifts
+	}
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	if from.IsZero() {
+		from = to.Add(-7 * 24 * time.Hour)
+	}
+
+	conditions := []string{"detected_at >= ?", "detected_at < ?"}
+	args := []interface{}{from, to}
+	if name != "" {
+		conditions = append(conditions, "name = ?")
+		args = append(args, name)
+	}
+	if kind != "" {
+		conditions = append(conditions, "kind = ?")
+		args = append(args, kind)
+	}
+
+	rows, err := s.queryRows(ctx, fmt.Sprintf(`
+		SELECT detected_at, name, key, kind, type, previous_types, services, events
+		FROM %s.schema_drifts
+		WHERE %s
+		ORDER BY detected_at DESC, name, key
+		LIMIT %d
+	`, db.Database, strings.Join(conditions, " AND "), limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	result := &SchemaDrifts{From: from, To: to, Drifts: []SchemaDrift{}}
+	for rows.Next() {
+		var d SchemaDrift
+		if err := rows.Scan(&d.DetectedAt, &d.Name, &d.Key, &d.Kind, &d.Type, &d.PreviousTypes, &d.Services, &d.Events); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		result.Drifts = append(result.Drifts, d)
+	}
+	return result, rows.Err()
+}
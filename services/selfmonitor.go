@@ -0,0 +1,135 @@
+package services
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// SelfServiceName is the service name used for monitor-core's own events
+const SelfServiceName = "monitor-core"
+
+// maxLoggedQueryLength caps the SQL text attached to slow query events
+const maxLoggedQueryLength = 1000
+
+// selfQueue receives internal events (nil when self-monitoring is disabled)
+var selfQueue *pipeline.Queue
+
+// slowQueryThreshold is the duration above which a query is reported as slow
+var slowQueryThreshold time.Duration
+
+// unreportedDrops and unreportedRejects count events not yet reported, since reporting
+// each one would flood the queue that is dropping them
+var (
+	unreportedDrops   atomic.Int64
+	unreportedRejects atomic.Int64
+)
+
+// EnableSelfMonitoring routes monitor-core's own internal events into the pipeline and
+// reports its flushes, drops, rejections, and stalls
+func EnableSelfMonitoring(p *pipeline.Pipeline, slowQuery time.Duration) {
+	selfQueue = p.Queue()
+	slowQueryThreshold = slowQuery
+
+	p.OnDrop(func(count int) { unreportedDrops.Add(int64(count)) })
+	p.OnReject(func(_ *structs.Event, err error) {
+		// sampled-out events are dropped on purpose and shed events reported by quota.shed,
+		// so neither counts as rejected
+		if !errors.Is(err, ErrSampledOut) && !errors.Is(err, ErrQuotaShed) {
+			unreportedRejects.Add(1)
+		}
+	})
+	p.OnFlush(reportFlush)
+	p.OnStall(reportStall)
+}
+
+// reportFlush emits the batch result along with the drops and rejections since the last flush
+func reportFlush(batch []*structs.Event, duration time.Duration, err error) {
+	if err != nil {
+		EmitInternal("batch.failed", "error", map[string]interface{}{
+			"size":        len(batch),
+			"duration_ms": duration.Milliseconds(),
+			"error":       err.Error(),
+		})
+	} else if !isInternalBatch(batch) {
+		// Skip batches of internal events only, otherwise every flush would schedule another
+		EmitInternal("batch.flushed", "info", map[string]interface{}{
+			"size":        len(batch),
+			"duration_ms": duration.Milliseconds(),
+		})
+	}
+
+	if dropped := unreportedDrops.Swap(0); dropped > 0 {
+		EmitInternal("queue.overflow", "warn", map[string]interface{}{
+			"dropped": dropped,
+		})
+	}
+
+	if rejected := unreportedRejects.Swap(0); rejected > 0 {
+		EmitInternal("events.rejected", "warn", map[string]interface{}{
+			"rejected": rejected,
+		})
+	}
+}
+
+func reportStall(stall pipeline.Stall, recovered bool) {
+	if recovered {
+		EmitInternal("batcher.recovered", "info", map[string]interface{}{
+			"stalled_ms": stall.Duration.Milliseconds(),
+		})
+		return
+	}
+
+	data := map[string]interface{}{
+		"stalled_ms": stall.Duration.Milliseconds(),
+		"pending":    stall.Pending,
+		"batched":    stall.Batched,
+		"restarted":  stall.Restarted,
+	}
+	if stall.Flushing > 0 {
+		data["flush_ms"] = stall.Flushing.Milliseconds()
+	}
+	EmitInternal("batcher.stalled", "error", data)
+}
+
+// EmitInternal enqueues an internal event under service=monitor-core
+// It is a no-op when self-monitoring is disabled
+func EmitInternal(name, level string, data map[string]interface{}) {
+	if selfQueue == nil {
+		return
+	}
+	selfQueue.Enqueue(&structs.Event{
+		Timestamp: time.Now().UTC(),
+		Service:   SelfServiceName,
+		Name:      name,
+		Level:     level,
+		Data:      data,
+	})
+}
+
+// isInternalBatch reports whether a batch only contains internal events
+func isInternalBatch(events []*structs.Event) bool {
+	for _, e := range events {
+		if e.Service != SelfServiceName {
+			return false
+		}
+	}
+	return true
+}
+
+// observeQuery emits a slow query event when a query exceeds the threshold
+func observeQuery(sql string, duration time.Duration) {
+	if slowQueryThreshold <= 0 || duration < slowQueryThreshold {
+		return
+	}
+	if len(sql) > maxLoggedQueryLength {
+		sql = sql[:maxLoggedQueryLength]
+	}
+	EmitInternal("query.slow", "warn", map[string]interface{}{
+		"duration_ms": duration.Milliseconds(),
+		"query":       sql,
+	})
+}
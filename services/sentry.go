@@ -0,0 +1,455 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// maxSentryFrames caps the number of stack frames stored per exception
+const maxSentryFrames = 50
+
+// sentryLevels maps Sentry levels to event levels
+var sentryLevels = map[string]string{
+	"fatal":   "fatal",
+	"error":   "error",
+	"warning": "warn",
+	"info":    "info",
+	"log":     "info",
+	"debug":   "debug",
+}
+
+// sentryEvent is the subset of the Sentry event payload that gets mapped into events
+type sentryEvent struct {
+	EventID     string          `json:"event_id"`
+	Timestamp   json.RawMessage `json:"timestamp"`
+	Platform    string          `json:"platform"`
+	Level       string          `json:"level"`
+	Logger      string          `json:"logger"`
+	Transaction string          `json:"transaction"`
+	ServerName  string          `json:"server_name"`
+	Release     string          `json:"release"`
+	Environment string          `json:"environment"`
+	Message     json.RawMessage `json:"message"`
+	LogEntry    *struct {
+		Formatted string `json:"formatted"`
+		Message   string `json:"message"`
+	} `json:"logentry"`
+	Exception   json.RawMessage `json:"exception"`
+	Breadcrumbs json.RawMessage `json:"breadcrumbs"`
+	Tags        json.RawMessage `json:"tags"`
+	Fingerprint []string        `json:"fingerprint"`
+	User        *struct {
+		ID        json.RawMessage `json:"id"`
+		Email     string          `json:"email"`
+		IPAddress string          `json:"ip_address"`
+	} `json:"user"`
+	Contexts struct {
+		Trace struct {
+			TraceID string `json:"trace_id"`
+			SpanID  string `json:"span_id"`
+		} `json:"trace"`
+	} `json:"contexts"`
+	Request *struct {
+		URL    string `json:"url"`
+		Method string `json:"method"`
+	} `json:"request"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Module     string `json:"module"`
+	Stacktrace *struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+type sentryFrame struct {
+	Filename string `json:"filename"`
+	Function string `json:"function"`
+	Module   string `json:"module"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryBreadcrumb struct {
+	Timestamp json.RawMessage        `json:"timestamp"`
+	Type      string                 `json:"type"`
+	Category  string                 `json:"category"`
+	Message   string                 `json:"message"`
+	Level     string                 `json:"level"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// ParseSentryEnvelope converts the event items of a Sentry envelope into events
+// Each error/message item becomes one event, and each of its breadcrumbs becomes a
+// sentry.breadcrumb event linked by data.sentry_event_id. Other item types are ignored.
+// Returns the envelope's event ID for the response.
+func ParseSentryEnvelope(body []byte, service string) ([]*structs.Event, string, error) {
+	headerLine, rest, _ := bytes.Cut(body, []byte("\n"))
+
+	var header struct {
+		EventID string `json:"event_id"`
+	}
+	if err := json.Unmarshal(headerLine, &header); err != nil {
+		return nil, "", fmt.Errorf("invalid envelope header: %w", err)
+	}
+
+	var events []*structs.Event
+	for len(bytes.TrimSpace(rest)) > 0 {
+		var itemHeaderLine []byte
+		itemHeaderLine, rest, _ = bytes.Cut(rest, []byte("\n"))
+		if len(bytes.TrimSpace(itemHeaderLine)) == 0 {
+			continue
+		}
+
+		var itemHeader struct {
+			Type   string `json:"type"`
+			Length *int   `json:"length"`
+		}
+		if err := json.Unmarshal(itemHeaderLine, &itemHeader); err != nil {
+			return nil, "", fmt.Errorf("invalid envelope item header: %w", err)
+		}
+
+		var payload []byte
+		if itemHeader.Length != nil {
+			if *itemHeader.Length < 0 || *itemHeader.Length > len(rest) {
+				return nil, "", fmt.Errorf("envelope item length %d exceeds payload", *itemHeader.Length)
+			}
+			payload, rest = rest[:*itemHeader.Length], rest[*itemHeader.Length:]
+			rest = bytes.TrimPrefix(rest, []byte("\n"))
+		} else {
+			payload, rest, _ = bytes.Cut(rest, []byte("\n"))
+		}
+
+		if itemHeader.Type != "event" {
+			continue
+		}
+
+		itemEvents, err := parseSentryEvent(payload, service)
+		if err != nil {
+			return nil, "", err
+		}
+		events = append(events, itemEvents...)
+	}
+
+	return events, header.EventID, nil
+}
+
+func parseSentryEvent(payload []byte, service string) ([]*structs.Event, error) {
+	var se sentryEvent
+	if err := json.Unmarshal(payload, &se); err != nil {
+		return nil, fmt.Errorf("invalid sentry event: %w", err)
+	}
+
+	timestamp := parseSentryTimestamp(se.Timestamp)
+	if timestamp.IsZero() {
+		timestamp = time.Now().UTC()
+	}
+
+	tags := parseSentryTags(se.Tags)
+	if tagService := tags["service"]; tagService != "" {
+		service = tagService
+		delete(tags, "service")
+	}
+
+	base := structs.Event{
+		Service: service,
+		Env:     se.Environment,
+	}
+	if traceID := structs.NormalizeID(se.Contexts.Trace.TraceID); structs.IsValidID(traceID) {
+		base.TraceID = traceID
+	}
+	if se.User != nil {
+		base.UserID = rawJSONString(se.User.ID)
+	}
+
+	data := map[string]interface{}{
+		"sentry_event_id": se.EventID,
+	}
+	setIfNotEmpty(data, "platform", se.Platform)
+	setIfNotEmpty(data, "logger", se.Logger)
+	setIfNotEmpty(data, "transaction", se.Transaction)
+	setIfNotEmpty(data, "server_name", se.ServerName)
+	setIfNotEmpty(data, "release", se.Release)
+	if se.Request != nil {
+		setIfNotEmpty(data, "url", se.Request.URL)
+		setIfNotEmpty(data, "method", se.Request.Method)
+	}
+	if se.User != nil {
+		setIfNotEmpty(data, "user_email", se.User.Email)
+		setIfNotEmpty(data, "user_ip", se.User.IPAddress)
+	}
+	for k, v := range tags {
+		key := unsafeKeyChars.ReplaceAllString(k, "_")
+		if _, exists := data[key]; !exists {
+			data[key] = v
+		}
+	}
+
+	main := base
+	main.Timestamp = timestamp
+	main.Level = sentryLevel(se.Level, "error")
+	main.Name = "sentry.message"
+
+	var exceptions []sentryException
+	for _, raw := range sentryValues(se.Exception) {
+		var ex sentryException
+		if err := json.Unmarshal(raw, &ex); err == nil {
+			exceptions = append(exceptions, ex)
+		}
+	}
+
+	message := sentryMessage(&se)
+	if len(exceptions) > 0 {
+		// The last exception in the chain is the one that was raised
+		primary := exceptions[len(exceptions)-1]
+		main.Name = "sentry.exception"
+		data["exception_type"] = primary.Type
+		data["exception_value"] = primary.Value
+		setIfNotEmpty(data, "exception_module", primary.Module)
+		if primary.Stacktrace != nil && len(primary.Stacktrace.Frames) > 0 {
+			data["stacktrace"] = formatSentryFrames(primary.Stacktrace.Frames)
+			if culprit := sentryCulprit(primary.Stacktrace.Frames); culprit != "" {
+				data["culprit"] = culprit
+			}
+		}
+		if message == "" {
+			message = strings.TrimSpace(primary.Type + ": " + primary.Value)
+		}
+	}
+	setIfNotEmpty(data, "message", message)
+	data["fingerprint"] = sentryFingerprint(&se, exceptions, message)
+	main.Data = data
+
+	if err := main.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid sentry event: %w", err)
+	}
+	events := []*structs.Event{&main}
+
+	for _, raw := range sentryValues(se.Breadcrumbs) {
+		var crumb sentryBreadcrumb
+		if err := json.Unmarshal(raw, &crumb); err != nil {
+			continue
+		}
+
+		event := base
+		event.Timestamp = parseSentryTimestamp(crumb.Timestamp)
+		if event.Timestamp.IsZero() {
+			event.Timestamp = timestamp
+		}
+		event.Name = "sentry.breadcrumb"
+		event.Level = sentryLevel(crumb.Level, "info")
+
+		crumbData := map[string]interface{}{
+			"sentry_event_id": se.EventID,
+		}
+		setIfNotEmpty(crumbData, "category", crumb.Category)
+		setIfNotEmpty(crumbData, "type", crumb.Type)
+		setIfNotEmpty(crumbData, "message", crumb.Message)
+		for k, v := range crumb.Data {
+			key := unsafeKeyChars.ReplaceAllString(k, "_")
+			if _, exists := crumbData[key]; !exists {
+				crumbData[key] = v
+			}
+		}
+		event.Data = crumbData
+
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// sentryValues unwraps fields that may be either a list or {"values": [...]}
+func sentryValues(raw json.RawMessage) []json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+	var wrapped struct {
+		Values []json.RawMessage `json:"values"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err == nil {
+		return wrapped.Values
+	}
+	return nil
+}
+
+// parseSentryTimestamp accepts RFC3339 strings or unix seconds (possibly fractional)
+func parseSentryTimestamp(raw json.RawMessage) time.Time {
+	if len(raw) == 0 {
+		return time.Time{}
+	}
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		return time.UnixMicro(int64(seconds * 1e6)).UTC()
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.UTC()
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// parseSentryTags accepts tags as an object or as a list of [key, value] pairs
+func parseSentryTags(raw json.RawMessage) map[string]string {
+	tags := make(map[string]string)
+	if len(raw) == 0 {
+		return tags
+	}
+	var obj map[string]string
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		return obj
+	}
+	var pairs [][2]string
+	if err := json.Unmarshal(raw, &pairs); err == nil {
+		for _, p := range pairs {
+			tags[p[0]] = p[1]
+		}
+	}
+	return tags
+}
+
+// sentryMessage returns the event message from either message or logentry
+func sentryMessage(se *sentryEvent) string {
+	if se.LogEntry != nil {
+		if se.LogEntry.Formatted != "" {
+			return se.LogEntry.Formatted
+		}
+		return se.LogEntry.Message
+	}
+	if len(se.Message) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(se.Message, &s); err == nil {
+		return s
+	}
+	var obj struct {
+		Formatted string `json:"formatted"`
+		Message   string `json:"message"`
+	}
+	if err := json.Unmarshal(se.Message, &obj); err == nil {
+		if obj.Formatted != "" {
+			return obj.Formatted
+		}
+		return obj.Message
+	}
+	return ""
+}
+
+// sentryFingerprint computes a stable grouping key: the SDK-provided fingerprint when set,
+// otherwise the exception type and in-app frames, falling back to the message
+func sentryFingerprint(se *sentryEvent, exceptions []sentryException, message string) string {
+	var parts []string
+
+	if len(se.Fingerprint) > 0 && !(len(se.Fingerprint) == 1 && se.Fingerprint[0] == "{{ default }}") {
+		parts = se.Fingerprint
+	} else if len(exceptions) > 0 {
+		for _, ex := range exceptions {
+			parts = append(parts, ex.Type)
+			if ex.Stacktrace == nil {
+				parts = append(parts, ex.Value)
+				continue
+			}
+			frames := ex.Stacktrace.Frames
+			if inApp := inAppFrames(frames); len(inApp) > 0 {
+				frames = inApp
+			}
+			for _, f := range frames {
+				parts = append(parts, f.Module+"|"+f.Filename+"|"+f.Function)
+			}
+		}
+	} else {
+		parts = []string{se.Logger, message}
+	}
+
+	sum := sha1.Sum([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func inAppFrames(frames []sentryFrame) []sentryFrame {
+	var result []sentryFrame
+	for _, f := range frames {
+		if f.InApp {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// sentryCulprit returns the most recent in-app frame (or the most recent frame)
+func sentryCulprit(frames []sentryFrame) string {
+	frame := frames[len(frames)-1]
+	if inApp := inAppFrames(frames); len(inApp) > 0 {
+		frame = inApp[len(inApp)-1]
+	}
+	location := frame.Filename
+	if location == "" {
+		location = frame.Module
+	}
+	if frame.Function == "" {
+		return location
+	}
+	return fmt.Sprintf("%s (%s)", frame.Function, location)
+}
+
+// formatSentryFrames renders frames most recent first, as in a typical stack trace
+func formatSentryFrames(frames []sentryFrame) string {
+	var b strings.Builder
+	count := 0
+	for i := len(frames) - 1; i >= 0 && count < maxSentryFrames; i-- {
+		f := frames[i]
+		location := f.Filename
+		if location == "" {
+			location = f.Module
+		}
+		if f.Lineno > 0 {
+			location += ":" + strconv.Itoa(f.Lineno)
+		}
+		fmt.Fprintf(&b, "at %s (%s)\n", f.Function, location)
+		count++
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func sentryLevel(level, defaultLevel string) string {
+	if mapped, ok := sentryLevels[level]; ok {
+		return mapped
+	}
+	return defaultLevel
+}
+
+// rawJSONString renders a JSON string or number as a plain string
+func rawJSONString(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return strings.Trim(string(raw), `"`)
+}
+
+func setIfNotEmpty(data map[string]interface{}, key, value string) {
+	if value != "" {
+		data[key] = value
+	}
+}
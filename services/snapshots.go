@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/google/uuid"
+)
+
+const (
+	maxSnapshotPanels = 50
+	// maxSnapshotSize caps the stored snapshot, results included
+	maxSnapshotSize = 16 << 20
+)
+
+// SnapshotPanel is one panel of a dashboard snapshot: a saved query (run with Variables)
+// or an inline query of a saved query type, and its result when the snapshot was taken
+type SnapshotPanel struct {
+	Title      string                 `json:"title,omitempty"`
+	SavedQuery string                 `json:"saved_query,omitempty"`
+	Variables  map[string]interface{} `json:"variables,omitempty"`
+	// Type and Query are the query that ran, with variables and defaults filled in
+	Type   string          `json:"type"`
+	Query  json.RawMessage `json:"query"`
+	Result json.RawMessage `json:"result"`
+}
+
+// Snapshot freezes a dashboard's queries and results, so incident reviews can show data
+// the events table no longer has. Snapshots can't be changed once taken.
+type Snapshot struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// IncidentID links the snapshot to an incident review
+	IncidentID string          `json:"incident_id,omitempty"`
+	TakenAt    time.Time       `json:"taken_at"`
+	Panels     []SnapshotPanel `json:"panels"`
+	// Role is the access role whose restrictions the results were queried with, if any;
+	// only that role can read the snapshot
+	Role string `json:"role,omitempty"`
+}
+
+// SnapshotSummary lists a snapshot without its panels
+type SnapshotSummary struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	IncidentID string    `json:"incident_id,omitempty"`
+	TakenAt    time.Time `json:"taken_at"`
+	Panels     int       `json:"panels"`
+}
+
+// CreateSnapshot runs every panel's query and stores the snapshot. A panel that fails
+// fails the snapshot, so a stored snapshot is always complete.
+func (s *Service) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	if snapshot.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	if len(snapshot.Panels) == 0 {
+		return fmt.Errorf("panels are required")
+	}
+	if len(snapshot.Panels) > maxSnapshotPanels {
+		return fmt.Errorf("too many panels (max %d)", maxSnapshotPanels)
+	}
+	if snapshot.IncidentID != "" {
+		incident, err := s.GetIncident(ctx, snapshot.IncidentID)
+		if err != nil {
+			return err
+		}
+		if incident == nil {
+			return fmt.Errorf("invalid incident_id: incident %s not found", snapshot.IncidentID)
+		}
+	}
+
+	snapshot.ID = uuid.NewString()
+	snapshot.TakenAt = time.Now().UTC()
+	snapshot.Role = ""
+	if role := AccessRoleFromContext(ctx); role.RestrictsEvents() {
+		snapshot.Role = role.Name
+	}
+	for i := range snapshot.Panels {
+		if err := s.runSnapshotPanel(ctx, &snapshot.Panels[i]); err != nil {
+			return fmt.Errorf("panels[%d]: %w", i, err)
+		}
+	}
+
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if len(body) > maxSnapshotSize {
+		return fmt.Errorf("snapshot too large (%d bytes, max %d); narrow its queries or split it", len(body), maxSnapshotSize)
+	}
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.snapshots (id, snapshot) VALUES (?, ?)", db.Database), snapshot.ID, string(body)); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// runSnapshotPanel resolves a panel's query, runs it, and freezes both
+func (s *Service) runSnapshotPanel(ctx context.Context, panel *SnapshotPanel) error {
+	q := &SavedQuery{Type: panel.Type, Query: panel.Query}
+	if panel.SavedQuery != "" {
+		saved, err := s.GetSavedQuery(ctx, panel.SavedQuery)
+		if err != nil {
+			return err
+		}
+		if saved == nil {
+			return fmt.Errorf("invalid saved_query: %s not found", panel.SavedQuery)
+		}
+		q = saved
+	} else if len(panel.Query) == 0 {
+		return fmt.Errorf("saved_query or query is required")
+	}
+
+	query, err := resolveSavedQuery(q, panel.Variables)
+	if err != nil {
+		return err
+	}
+	result, err := s.runQuery(ctx, q.Type, query)
+	if err != nil {
+		return err
+	}
+
+	panel.Type = q.Type
+	if panel.Query, err = json.Marshal(query); err != nil {
+		return err
+	}
+	panel.Result, err = json.Marshal(result)
+	return err
+}
+
+// ListSnapshots returns snapshots, newest first, optionally only those of an incident.
+// The summaries are extracted in ClickHouse, so results aren't read.
+func (s *Service) ListSnapshots(ctx context.Context, incidentID string) ([]SnapshotSummary, error) {
+	query := fmt.Sprintf(`SELECT id, JSONExtractString(snapshot, 'title'), JSONExtractString(snapshot, 'incident_id'),
+		parseDateTime64BestEffort(JSONExtractString(snapshot, 'taken_at'), 3, 'UTC') AS taken_at, JSONLength(snapshot, 'panels'),
+		JSONExtractString(snapshot, 'role')
+		FROM %s.snapshots FINAL WHERE deleted = 0`, db.Database)
+	var args []interface{}
+	if incidentID != "" {
+		query += " AND JSONExtractString(snapshot, 'incident_id') = ?"
+		args = append(args, incidentID)
+	}
+	rows, err := s.queryRows(ctx, query+" ORDER BY taken_at DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []SnapshotSummary{}
+	for rows.Next() {
+		var s SnapshotSummary
+		var panels uint64
+		var role string
+		if err := rows.Scan(&s.ID, &s.Title, &s.IncidentID, &s.TakenAt, &panels, &role); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		if !canReadSnapshot(ctx, role) {
+			continue
+		}
+		s.Panels = int(panels)
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// GetSnapshot returns a snapshot, or nil if it doesn't exist or the caller can't read it
+func (s *Service) GetSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	row := s.queryRow(ctx, fmt.Sprintf("SELECT id, snapshot FROM %s.snapshots FINAL WHERE id = ? AND deleted = 0", db.Database), id)
+	snapshot, err := scanSnapshot(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !canReadSnapshot(ctx, snapshot.Role)) {
+		return nil, nil
+	}
+	return snapshot, err
+}
+
+// canReadSnapshot reports whether the caller may read a snapshot taken with role. Callers
+// restricted to some services or envs only read snapshots taken with their own role.
+func canReadSnapshot(ctx context.Context, role string) bool {
+	caller := AccessRoleFromContext(ctx)
+	return !caller.RestrictsEvents() || caller.Name == role
+}
+
+func scanSnapshot(scan func(dest ...interface{}) error) (*Snapshot, error) {
+	var id, body string
+	if err := scan(&id, &body); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal([]byte(body), &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid stored snapshot %s: %w", id, err)
+	}
+	snapshot.ID = id
+	return &snapshot, nil
+}
+
+// DeleteSnapshot removes a snapshot
+func (s *Service) DeleteSnapshot(ctx context.Context, id string) error {
+	if err := s.store.Exec(ctx, fmt.Sprintf("INSERT INTO %s.snapshots (id, snapshot, deleted) VALUES (?, '{}', 1)", db.Database), id); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	return nil
+}
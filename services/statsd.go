@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// maxStatsDPacketSize is the largest UDP datagram accepted by the listener
+const maxStatsDPacketSize = 65535
+
+// statsdTypes maps StatsD metric type suffixes to event data types
+var statsdTypes = map[string]string{
+	"c":  "counter",
+	"g":  "gauge",
+	"ms": "timer",
+	"h":  "histogram",
+	"d":  "distribution",
+	"s":  "set",
+}
+
+// unsafeKeyChars matches characters not allowed in data keys
+var unsafeKeyChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// StatsDListener converts StatsD metrics received over UDP into events
+type StatsDListener struct {
+	queue   *pipeline.Queue
+	service string
+}
+
+// NewStatsDListener creates a listener that enqueues metrics into the queue
+// defaultService is used when a metric carries no "service" tag
+func NewStatsDListener(queue *pipeline.Queue, defaultService string) *StatsDListener {
+	return &StatsDListener{
+		queue:   queue,
+		service: defaultService,
+	}
+}
+
+// Run listens on the UDP address until the context is cancelled
+func (l *StatsDListener) Run(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for statsd on %s: %w", addr, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.Printf("statsd listener running on %s", addr)
+
+	buf := make([]byte, maxStatsDPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("statsd read error: %v", err)
+			continue
+		}
+
+		now := time.Now().UTC()
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			event, err := ParseStatsDLine(line, l.service, now)
+			if err != nil {
+				log.Printf("statsd: %v", err)
+				continue
+			}
+			l.queue.Enqueue(event)
+		}
+	}
+}
+
+// statsdReservedKeys are the data keys a metric sets itself; tags named like them are
+// stored with a tag_ prefix so they can't replace the metric's value or type
+var statsdReservedKeys = map[string]bool{"value": true, "type": true, "sample_rate": true, "delta": true}
+
+// ParseStatsDLine converts a single StatsD line into an event
+// Format: <name>:<value>|<type>[|@<sample_rate>][|#<tag>:<value>,...]
+func ParseStatsDLine(line, defaultService string, now time.Time) (*structs.Event, error) {
+	// Tags may contain colons too, so only look for the separator before the first pipe
+	pipe := strings.Index(line, "|")
+	if pipe == -1 {
+		return nil, fmt.Errorf("invalid metric line: %q", line)
+	}
+	colon := strings.LastIndex(line[:pipe], ":")
+	if colon <= 0 {
+		return nil, fmt.Errorf("invalid metric line: %q", line)
+	}
+
+	name := line[:colon]
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid metric line: %q", line)
+	}
+
+	rawValue := parts[0]
+	metricType, ok := statsdTypes[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("unsupported metric type %q in %q", parts[1], line)
+	}
+
+	data := map[string]interface{}{
+		"type": metricType,
+	}
+
+	if metricType == "set" {
+		data["value"] = rawValue
+	} else {
+		value, err := strconv.ParseFloat(rawValue, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric value %q in %q", rawValue, line)
+		}
+		data["value"] = value
+		if metricType == "gauge" && (strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")) {
+			data["delta"] = true
+		}
+	}
+
+	event := &structs.Event{
+		Timestamp: now,
+		Service:   defaultService,
+		Name:      name,
+	}
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q in %q", part, line)
+			}
+			data["sample_rate"] = rate
+		case strings.HasPrefix(part, "#"):
+			for _, tag := range strings.Split(part[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				key, value, _ := strings.Cut(tag, ":")
+				switch key {
+				case "service":
+					event.Service = value
+				case "env":
+					event.Env = value
+				default:
+					key = unsafeKeyChars.ReplaceAllString(key, "_")
+					if statsdReservedKeys[key] {
+						key = "tag_" + key
+					}
+					data[key] = value
+				}
+			}
+		}
+	}
+
+	event.Data = data
+	if err := event.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid metric %q: %w", line, err)
+	}
+	return event, nil
+}
@@ -0,0 +1,121 @@
+package services_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+func TestParseStatsDLine(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		line        string
+		wantService string
+		wantEnv     string
+		wantName    string
+		wantData    map[string]interface{}
+	}{
+		{
+			name:        "counter",
+			line:        "api.requests:1|c",
+			wantService: "statsd",
+			wantName:    "api.requests",
+			wantData:    map[string]interface{}{"type": "counter", "value": 1.0},
+		},
+		{
+			name:        "sample rate and tags",
+			line:        "api.requests:1|c|@0.5|#service:users,env:production,route:/login",
+			wantService: "users",
+			wantEnv:     "production",
+			wantName:    "api.requests",
+			wantData:    map[string]interface{}{"type": "counter", "value": 1.0, "sample_rate": 0.5, "route": "/login"},
+		},
+		{
+			name:        "gauge delta",
+			line:        "queue.depth:-3|g",
+			wantService: "statsd",
+			wantName:    "queue.depth",
+			wantData:    map[string]interface{}{"type": "gauge", "value": -3.0, "delta": true},
+		},
+		{
+			name:        "set",
+			line:        "users.unique:u-42|s",
+			wantService: "statsd",
+			wantName:    "users.unique",
+			wantData:    map[string]interface{}{"type": "set", "value": "u-42"},
+		},
+		{
+			name:        "tag with colon in value",
+			line:        "latency:12|ms|#url:http://api",
+			wantService: "statsd",
+			wantName:    "latency",
+			wantData:    map[string]interface{}{"type": "timer", "value": 12.0, "url": "http://api"},
+		},
+		{
+			name:        "unsafe tag key",
+			line:        "latency:12|ms|#http.route:/v1",
+			wantService: "statsd",
+			wantName:    "latency",
+			wantData:    map[string]interface{}{"type": "timer", "value": 12.0, "http_route": "/v1"},
+		},
+		{
+			name:        "reserved tags",
+			line:        "latency:12|ms|#value:x,type:y,delta:z|@0.1|#sample_rate:w",
+			wantService: "statsd",
+			wantName:    "latency",
+			wantData: map[string]interface{}{
+				"type": "timer", "value": 12.0, "sample_rate": 0.1,
+				"tag_value": "x", "tag_type": "y", "tag_delta": "z", "tag_sample_rate": "w",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := services.ParseStatsDLine(tt.line, "statsd", now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if event.Service != tt.wantService || event.Env != tt.wantEnv || event.Name != tt.wantName {
+				t.Fatalf("service, env, name = %q, %q, %q, want %q, %q, %q",
+					event.Service, event.Env, event.Name, tt.wantService, tt.wantEnv, tt.wantName)
+			}
+			if !event.Timestamp.Equal(now) {
+				t.Fatalf("timestamp = %v, want %v", event.Timestamp, now)
+			}
+			if !reflect.DeepEqual(event.Data, tt.wantData) {
+				t.Fatalf("data = %v, want %v", event.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestParseStatsDLineErrors(t *testing.T) {
+	tests := []struct {
+		line    string
+		wantErr string
+	}{
+		{"api.requests", "invalid metric line"},
+		{"api.requests|c", "invalid metric line"},
+		{":1|c", "invalid metric line"},
+		{"api.requests:1", "invalid metric line"},
+		{"api.requests:1|x", "unsupported metric type"},
+		{"api.requests:abc|c", "invalid metric value"},
+		{"api.requests:1|c|@2", "invalid sample rate"},
+		{"api.requests:1|c|@0", "invalid sample rate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			_, err := services.ParseStatsDLine(tt.line, "statsd", time.Now())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
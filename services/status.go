@@ -0,0 +1,376 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	// statusDays is how many days of availability the status page shows
+	statusDays = 90
+	// statusCacheTTL is how long a generated status page is served before it is queried again
+	statusCacheTTL        = time.Minute
+	defaultStatusTarget   = 99.9
+	defaultStatusInterval = "5m"
+	minStatusInterval     = time.Minute
+)
+
+// StatusComponent is one service on the status page. Its availability comes from an SLO
+// query (the share of its events that aren't bad) or a heartbeat monitor (the share of
+// intervals it sent an event in).
+type StatusComponent struct {
+	Name string `json:"name"`
+	// Type is slo or heartbeat
+	Type string `json:"type"`
+	// Filters select the component's events (e.g. service = api)
+	Filters []structs.QueryFilter `json:"filters"`
+	// Bad selects the failed events of an slo component (e.g. level = error)
+	Bad []structs.QueryFilter `json:"bad,omitempty"`
+	// Interval is how often a heartbeat component sends an event (default 5m)
+	Interval string `json:"interval,omitempty"`
+	// Target is the availability in percent below which a day is degraded (default 99.9)
+	Target float64 `json:"target,omitempty"`
+
+	interval time.Duration
+}
+
+// StatusPageConfig is the STATUS_PAGE_CONFIG file
+type StatusPageConfig struct {
+	Title      string            `json:"title"`
+	Components []StatusComponent `json:"components"`
+}
+
+// StatusDay is a component's availability on one day, nil without data
+type StatusDay struct {
+	Date         string   `json:"date"`
+	Availability *float64 `json:"availability"`
+}
+
+// ComponentStatus is a component's availability over the status page's days
+type ComponentStatus struct {
+	Name string `json:"name"`
+	// Status is operational, degraded (today is below target), down (a heartbeat missed
+	// two intervals), or unknown (no data today)
+	Status       string      `json:"status"`
+	Availability *float64    `json:"availability"`
+	Target       float64     `json:"target"`
+	Days         []StatusDay `json:"days"`
+}
+
+// StatusPage is the generated status page
+type StatusPage struct {
+	Title       string            `json:"title"`
+	Status      string            `json:"status"`
+	GeneratedAt time.Time         `json:"generated_at"`
+	Components  []ComponentStatus `json:"components"`
+}
+
+// StatusPages generates the status page, caching it for a minute since it is public
+type StatusPages struct {
+	svc    *Service
+	config StatusPageConfig
+
+	mu        sync.Mutex
+	page      *StatusPage
+	expiresAt time.Time
+}
+
+// LoadStatusPage reads the components in configPath
+func (s *Service) LoadStatusPage(configPath string) (*StatusPages, error) {
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status page config: %w", err)
+	}
+	var config StatusPageConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("invalid status page config: %w", err)
+	}
+	if config.Title == "" {
+		config.Title = "Status"
+	}
+	if len(config.Components) == 0 {
+		return nil, fmt.Errorf("status page components are required")
+	}
+
+	names := map[string]bool{}
+	for i := range config.Components {
+		c := &config.Components[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("status component %d: name is required", i)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("status component %q: duplicate name", c.Name)
+		}
+		names[c.Name] = true
+
+		if len(c.Filters) == 0 {
+			return nil, fmt.Errorf("status component %q: filters are required", c.Name)
+		}
+		if _, _, err := buildFilterClause(c.Filters); err != nil {
+			return nil, fmt.Errorf("status component %q: %w", c.Name, err)
+		}
+		switch c.Type {
+		case "slo":
+			if len(c.Bad) == 0 {
+				return nil, fmt.Errorf("status component %q: bad filters are required", c.Name)
+			}
+			if _, _, err := buildFilterClause(c.Bad); err != nil {
+				return nil, fmt.Errorf("status component %q: %w", c.Name, err)
+			}
+		case "heartbeat":
+			if c.Interval == "" {
+				c.Interval = defaultStatusInterval
+			}
+			interval, err := parseOffset("interval", c.Interval)
+			if err != nil {
+				return nil, fmt.Errorf("status component %q: %w", c.Name, err)
+			}
+			if interval < minStatusInterval || interval > 24*time.Hour {
+				return nil, fmt.Errorf("status component %q: interval must be between 1m and 1d", c.Name)
+			}
+			c.interval = interval
+		default:
+			return nil, fmt.Errorf("status component %q: invalid type %q (use slo or heartbeat)", c.Name, c.Type)
+		}
+		if c.Target == 0 {
+			c.Target = defaultStatusTarget
+		}
+		if c.Target < 0 || c.Target > 100 {
+			return nil, fmt.Errorf("status component %q: target must be between 0 and 100", c.Name)
+		}
+	}
+	return &StatusPages{svc: s, config: config}, nil
+}
+
+// Get returns the status page, generating it when the cached one has expired
+func (s *StatusPages) Get(ctx context.Context) (*StatusPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	if s.page != nil && now.Before(s.expiresAt) {
+		return s.page, nil
+	}
+
+	page := &StatusPage{
+		Title:       s.config.Title,
+		Status:      "operational",
+		GeneratedAt: now,
+		Components:  make([]ComponentStatus, 0, len(s.config.Components)),
+	}
+	for i := range s.config.Components {
+		status, err := s.svc.componentStatus(ctx, &s.config.Components[i], now)
+		if err != nil {
+			return nil, fmt.Errorf("status component %q: %w", s.config.Components[i].Name, err)
+		}
+		page.Components = append(page.Components, *status)
+		page.Status = worseStatus(page.Status, status.Status)
+	}
+
+	s.page = page
+	s.expiresAt = now.Add(statusCacheTTL)
+	return page, nil
+}
+
+// SLOStatus is a status page component's availability over a report's time range
+type SLOStatus struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Status is met, missed, or no_data
+	Status       string   `json:"status"`
+	Availability *float64 `json:"availability"`
+	Target       float64  `json:"target"`
+}
+
+// Availability reports each component's availability over from-to against its target
+func (s *StatusPages) Availability(ctx context.Context, from, to time.Time) ([]SLOStatus, error) {
+	statuses := make([]SLOStatus, 0, len(s.config.Components))
+	for i := range s.config.Components {
+		c := &s.config.Components[i]
+		counts, err := s.svc.queryStatusDays(ctx, c, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("status component %q: %w", c.Name, err)
+		}
+
+		var up, total float64
+		for _, count := range counts {
+			switch c.Type {
+			case "slo":
+				up += float64(count.events - count.bad)
+				total += float64(count.events)
+			case "heartbeat":
+				up += float64(count.slots)
+			}
+		}
+		if c.Type == "heartbeat" && len(counts) > 0 {
+			total = math.Ceil(float64(to.Sub(from)) / float64(c.interval))
+			up = min(up, total)
+		}
+
+		status := SLOStatus{Name: c.Name, Type: c.Type, Status: "no_data", Target: c.Target}
+		if total > 0 {
+			status.Availability = statusPercent(up, total)
+			status.Status = "met"
+			if *status.Availability < c.Target {
+				status.Status = "missed"
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// statusRank orders component statuses from best to worst
+var statusRank = map[string]int{"operational": 0, "unknown": 1, "degraded": 2, "down": 3}
+
+func worseStatus(a, b string) string {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+	return a
+}
+
+// statusDayCounts is what a component's daily query returns for one day
+type statusDayCounts struct {
+	events uint64
+	// bad counts failed events of slo components, slots the intervals with an event of
+	// heartbeat components
+	bad      uint64
+	slots    uint64
+	lastSeen time.Time
+}
+
+// componentStatus queries a component's daily counts over the status page's days
+func (s *Service) componentStatus(ctx context.Context, c *StatusComponent, now time.Time) (*ComponentStatus, error) {
+	today := now.Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, -(statusDays - 1))
+
+	counts, err := s.queryStatusDays(ctx, c, from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ComponentStatus{Name: c.Name, Status: "unknown", Target: c.Target, Days: make([]StatusDay, 0, statusDays)}
+
+	// Heartbeats count days without events as down, but only once the monitor first reported
+	var first time.Time
+	var lastSeen time.Time
+	for day, count := range counts {
+		if first.IsZero() || day.Before(first) {
+			first = day
+		}
+		if count.lastSeen.After(lastSeen) {
+			lastSeen = count.lastSeen
+		}
+	}
+
+	var up, total float64
+	for day := from; !day.After(today); day = day.AddDate(0, 0, 1) {
+		entry := StatusDay{Date: day.Format(time.DateOnly)}
+		count, ok := counts[day]
+
+		switch c.Type {
+		case "slo":
+			if ok && count.events > 0 {
+				good := float64(count.events - count.bad)
+				entry.Availability = statusPercent(good, float64(count.events))
+				up += good
+				total += float64(count.events)
+			}
+		case "heartbeat":
+			if first.IsZero() || day.Before(first) {
+				break
+			}
+			// Today only counts the intervals that have started
+			elapsed := min(now.Sub(day), 24*time.Hour)
+			expected := math.Ceil(float64(elapsed) / float64(c.interval))
+			slots := min(float64(count.slots), expected)
+			entry.Availability = statusPercent(slots, expected)
+			up += slots
+			total += expected
+		}
+		status.Days = append(status.Days, entry)
+	}
+	if total > 0 {
+		status.Availability = statusPercent(up, total)
+	}
+
+	if current := status.Days[len(status.Days)-1].Availability; current != nil {
+		status.Status = "operational"
+		if *current < c.Target {
+			status.Status = "degraded"
+		}
+	}
+	if c.Type == "heartbeat" && !lastSeen.IsZero() && now.Sub(lastSeen) > 2*c.interval {
+		status.Status = "down"
+	}
+	return status, nil
+}
+
+// queryStatusDays counts a component's events per UTC day
+func (s *Service) queryStatusDays(ctx context.Context, c *StatusComponent, from, to time.Time) (map[time.Time]statusDayCounts, error) {
+	filterSQL, filterArgs, err := buildFilterClause(c.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := sq.Select("toStartOfDay(timestamp) AS day", "count() AS events").
+		From(eventsTable(ctx)).
+		Where("timestamp >= ? AND timestamp <= ?", from, to).
+		Where(filterSQL, filterArgs...).
+		GroupBy("day").
+		OrderBy("day").
+		PlaceholderFormat(sq.Question)
+	switch c.Type {
+	case "slo":
+		badSQL, badArgs, err := buildFilterClause(c.Bad)
+		if err != nil {
+			return nil, err
+		}
+		builder = builder.Column(sq.Expr("countIf("+badSQL+") AS bad", badArgs...)).
+			Column("toUInt64(0) AS slots")
+	case "heartbeat":
+		builder = builder.Column("toUInt64(0) AS bad").
+			Column(fmt.Sprintf("toUInt64(uniqExact(intDiv(toUnixTimestamp(timestamp), %d))) AS slots", int64(c.interval.Seconds())))
+	}
+	builder = builder.Column("max(timestamp) AS last_seen")
+
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[time.Time]statusDayCounts{}
+	for rows.Next() {
+		var day time.Time
+		var count statusDayCounts
+		if err := rows.Scan(&day, &count.events, &count.bad, &count.slots, &count.lastSeen); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		counts[day.UTC().Truncate(24*time.Hour)] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+	return counts, nil
+}
+
+// statusPercent is n of total as a percentage rounded to three decimals
+func statusPercent(n, total float64) *float64 {
+	p := math.Round(n/total*100*1000) / 1000
+	return &p
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aidenappl/monitor-core/db"
+)
+
+// StorageSizes holds the sizes of a group of active parts
+type StorageSizes struct {
+	Rows              uint64  `json:"rows"`
+	Parts             uint64  `json:"parts"`
+	BytesOnDisk       uint64  `json:"bytes_on_disk"`
+	CompressedBytes   uint64  `json:"compressed_bytes"`
+	UncompressedBytes uint64  `json:"uncompressed_bytes"`
+	CompressionRatio  float64 `json:"compression_ratio"`
+}
+
+// PartitionStats describes one partition of an events table
+type PartitionStats struct {
+	Partition string `json:"partition"`
+	StorageSizes
+}
+
+// TableStats describes an events table and its partitions, oldest first
+type TableStats struct {
+	Database      string           `json:"database"`
+	Table         string           `json:"table"`
+	Tenant        string           `json:"tenant,omitempty"`
+	RetentionDays int              `json:"retention_days"`
+	Partitions    []PartitionStats `json:"partitions"`
+	StorageSizes
+}
+
+// StorageStats describes every events table
+type StorageStats struct {
+	Tables []*TableStats `json:"tables"`
+	Total  StorageSizes  `json:"total"`
+}
+
+func (s *StorageSizes) add(other StorageSizes) {
+	s.Rows += other.Rows
+	s.Parts += other.Parts
+	s.BytesOnDisk += other.BytesOnDisk
+	s.CompressedBytes += other.CompressedBytes
+	s.UncompressedBytes += other.UncompressedBytes
+	if s.CompressedBytes > 0 {
+		s.CompressionRatio = float64(s.UncompressedBytes) / float64(s.CompressedBytes)
+	}
+}
+
+// GetStorageStats reports the active parts of the default, routed, and tenant events tables
+// from system.parts
+func (s *Service) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	stats := &StorageStats{Tables: []*TableStats{}}
+	byName := make(map[string]*TableStats)
+	names := make([]string, 0)
+	for _, route := range append(db.EventTables(), db.TenantTables()...) {
+		table := &TableStats{
+			Database:      route.Database,
+			Table:         route.Table,
+			Tenant:        route.Tenant,
+			RetentionDays: route.RetentionDays,
+			Partitions:    []PartitionStats{},
+		}
+		stats.Tables = append(stats.Tables, table)
+		byName[route.QualifiedName()] = table
+		names = append(names, route.QualifiedName())
+	}
+
+	rows, err := s.queryRows(ctx, `
+		SELECT
+			database,
+			table,
+			partition,
+			sum(rows),
+			count(),
+			sum(bytes_on_disk),
+			sum(data_compressed_bytes),
+			sum(data_uncompressed_bytes)
+		FROM system.parts
+		WHERE active AND has(?, concat(database, '.', table))
+		GROUP BY database, table, partition
+		ORDER BY database, table, partition
+	`, names)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var database, table string
+		var partition PartitionStats
+		var sizes StorageSizes
+		if err := rows.Scan(&database, &table, &partition.Partition, &sizes.Rows, &sizes.Parts,
+			&sizes.BytesOnDisk, &sizes.CompressedBytes, &sizes.UncompressedBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		partition.add(sizes)
+
+		tableStats := byName[database+"."+table]
+		tableStats.Partitions = append(tableStats.Partitions, partition)
+		tableStats.add(sizes)
+		stats.Total.add(sizes)
+	}
+
+	return stats, rows.Err()
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// summaryTopN is how many names and services an events summary lists
+const summaryTopN = 10
+
+// EventSummary describes the events matching a filter set, for the explorer header
+type EventSummary struct {
+	Total    uint64            `json:"total"`
+	ByLevel  map[string]uint64 `json:"by_level"`
+	TopNames []LabelValueCount `json:"top_names"`
+	// TopServices are the services with the most matching events
+	TopServices []LabelValueCount `json:"top_services"`
+	// Interval is the bucket size of Volume, chosen from the time range
+	Interval structs.IntervalType `json:"interval"`
+	Volume   []structs.DataPoint  `json:"volume"`
+}
+
+// summaryInterval picks a coarse bucket size for a volume sparkline over from-to
+func summaryInterval(from, to time.Time) structs.IntervalType {
+	switch d := to.Sub(from); {
+	case d <= 3*time.Hour:
+		return structs.IntervalMinute
+	case d <= 7*24*time.Hour:
+		return structs.IntervalHour
+	default:
+		return structs.IntervalDay
+	}
+}
+
+// QueryEventSummary counts the events matching params in total, by level, by name and
+// service, and over time, running the queries in parallel. Without a time range the
+// volume covers the last 24 hours.
+func (s *Service) QueryEventSummary(ctx context.Context, params QueryParams) (*EventSummary, error) {
+	if err := checkSensitiveParams(ctx, params); err != nil {
+		return nil, err
+	}
+
+	volumeParams := params
+	if volumeParams.To.IsZero() {
+		volumeParams.To = time.Now().UTC()
+	}
+	if volumeParams.From.IsZero() {
+		volumeParams.From = volumeParams.To.Add(-24 * time.Hour)
+	}
+	if volumeParams.To.Sub(volumeParams.From) > MaxQueryDuration {
+		return nil, fmt.Errorf("time range too large (max %v)", MaxQueryDuration)
+	}
+	interval := summaryInterval(volumeParams.From, volumeParams.To)
+	intervalExpr, err := buildIntervalExpr(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &EventSummary{ByLevel: map[string]uint64{}, Interval: interval}
+	var volume []structs.DataPoint
+	queries := []func() error{
+		func() error {
+			counts, err := s.summaryCounts(ctx, "level", params, 0)
+			for _, c := range counts {
+				summary.ByLevel[c.Value] = c.Count
+				summary.Total += c.Count
+			}
+			return err
+		},
+		func() (err error) {
+			summary.TopNames, err = s.summaryCounts(ctx, "name", params, summaryTopN)
+			return err
+		},
+		func() (err error) {
+			summary.TopServices, err = s.summaryCounts(ctx, "service", params, summaryTopN)
+			return err
+		},
+		func() error {
+			builder := sq.Select(intervalExpr+" AS bucket", "count() AS n").
+				From(eventsTable(ctx)).
+				GroupBy("bucket").
+				OrderBy("bucket").
+				PlaceholderFormat(sq.Question)
+			builder, err := applyFilters(ctx, builder, volumeParams)
+			if err != nil {
+				return err
+			}
+			querySQL, queryArgs, err := builder.ToSql()
+			if err != nil {
+				return fmt.Errorf("failed to build query: %w", err)
+			}
+			rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+			if err != nil {
+				return fmt.Errorf("query failed: %w", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var bucket time.Time
+				var n uint64
+				if err := rows.Scan(&bucket, &n); err != nil {
+					return fmt.Errorf("scan failed: %w", err)
+				}
+				volume = append(volume, structs.DataPoint{Timestamp: bucket, Value: float64(n)})
+			}
+			return rows.Err()
+		},
+	}
+
+	errs := make([]error, len(queries))
+	var wg sync.WaitGroup
+	for i, query := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = query()
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	summary.Volume = fillTimeSeriesZeros(volume, volumeParams.From, volumeParams.To, interval)
+	return summary, nil
+}
+
+// summaryCounts counts the matching events by a label column, most frequent first; a
+// limit of 0 returns every value
+func (s *Service) summaryCounts(ctx context.Context, column string, params QueryParams, limit uint64) ([]LabelValueCount, error) {
+	builder := sq.Select(column, "count() AS n").
+		From(eventsTable(ctx)).
+		GroupBy(column).
+		OrderBy("n DESC", column).
+		PlaceholderFormat(sq.Question)
+	if limit > 0 {
+		builder = builder.Limit(limit)
+	}
+	builder, err := applyFilters(ctx, builder, params)
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []LabelValueCount{}
+	for rows.Next() {
+		var c LabelValueCount
+		if err := rows.Scan(&c.Value, &c.Count); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// maxSyslogMessageSize is the largest syslog message accepted (per RFC5425 recommendation)
+const maxSyslogMessageSize = 64 * 1024
+
+// syslogIdleTimeout closes TCP connections that stay silent for too long
+const syslogIdleTimeout = 5 * time.Minute
+
+// syslogSeverityLevels maps syslog severities (PRI % 8) to event levels
+var syslogSeverityLevels = [8]string{
+	"fatal", // emergency
+	"fatal", // alert
+	"fatal", // critical
+	"error", // error
+	"warn",  // warning
+	"info",  // notice
+	"info",  // informational
+	"debug", // debug
+}
+
+// syslogFacilities maps syslog facility codes (PRI / 8) to names
+var syslogFacilities = [24]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// SyslogListener converts syslog messages received over UDP and TCP into events
+type SyslogListener struct {
+	queue   *pipeline.Queue
+	service string
+}
+
+// NewSyslogListener creates a listener that enqueues syslog messages into the queue
+// defaultService is used when a message has no APP-NAME
+func NewSyslogListener(queue *pipeline.Queue, defaultService string) *SyslogListener {
+	return &SyslogListener{
+		queue:   queue,
+		service: defaultService,
+	}
+}
+
+// RunUDP listens for one syslog message per datagram until the context is cancelled
+func (l *SyslogListener) RunUDP(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for syslog on udp %s: %w", addr, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.Printf("syslog udp listener running on %s", addr)
+
+	buf := make([]byte, maxSyslogMessageSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("syslog udp read error: %v", err)
+			continue
+		}
+		l.handle(buf[:n])
+	}
+}
+
+// RunTCP accepts syslog streams (RFC6587 octet counting or newline framing) until the context is cancelled
+func (l *SyslogListener) RunTCP(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for syslog on tcp %s: %w", addr, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	log.Printf("syslog tcp listener running on %s", addr)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("syslog tcp accept error: %v", err)
+			continue
+		}
+		go l.serveConn(ctx, conn)
+	}
+}
+
+func (l *SyslogListener) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	reader := &deadlineReader{conn: conn, timeout: syslogIdleTimeout}
+	err := ReadSyslogFrames(reader, func(frame []byte) error {
+		l.handle(frame)
+		return nil
+	})
+	if err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("syslog tcp connection from %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+func (l *SyslogListener) handle(frame []byte) {
+	event, err := ParseSyslogMessage(frame, l.service, time.Now().UTC())
+	if err != nil {
+		log.Printf("syslog: %v", err)
+		return
+	}
+	l.queue.Enqueue(event)
+}
+
+// deadlineReader refreshes the read deadline before every read
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.conn.Read(p)
+}
+
+// ReadSyslogFrames splits a syslog stream into messages and calls fn for each
+// Frames using octet counting ("<len> <msg>") and newline-terminated frames are both supported
+func ReadSyslogFrames(r io.Reader, fn func(frame []byte) error) error {
+	br := bufio.NewReaderSize(r, maxSyslogMessageSize)
+
+	for {
+		first, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var frame []byte
+		switch {
+		case first[0] >= '1' && first[0] <= '9':
+			// Octet counting: MSG-LEN SP SYSLOG-MSG
+			lenStr, err := br.ReadString(' ')
+			if err != nil {
+				return fmt.Errorf("invalid octet-counted frame: %w", err)
+			}
+			size, err := strconv.Atoi(strings.TrimSuffix(lenStr, " "))
+			if err != nil || size <= 0 || size > maxSyslogMessageSize {
+				return fmt.Errorf("invalid octet-counted frame length %q", strings.TrimSpace(lenStr))
+			}
+			frame = make([]byte, size)
+			if _, err := io.ReadFull(br, frame); err != nil {
+				return fmt.Errorf("truncated octet-counted frame: %w", err)
+			}
+		case first[0] == '\n' || first[0] == '\r' || first[0] == ' ':
+			br.ReadByte()
+			continue
+		default:
+			// Non-transparent framing: message terminated by LF
+			line, err := br.ReadSlice('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("invalid newline-terminated frame: %w", err)
+			}
+			frame = append([]byte(nil), line...)
+		}
+
+		frame = []byte(strings.TrimRight(string(frame), "\r\n\x00"))
+		if len(frame) == 0 {
+			continue
+		}
+		if err := fn(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// ParseSyslogMessage converts an RFC5424 (or legacy RFC3164) message into an event
+func ParseSyslogMessage(msg []byte, defaultService string, receivedAt time.Time) (*structs.Event, error) {
+	s := string(msg)
+	if !strings.HasPrefix(s, "<") {
+		return nil, fmt.Errorf("invalid syslog message: missing PRI")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("invalid syslog message: malformed PRI")
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return nil, fmt.Errorf("invalid syslog message: PRI out of range")
+	}
+	rest := s[end+1:]
+
+	event := &structs.Event{
+		Timestamp: receivedAt,
+		Service:   defaultService,
+		Name:      "syslog",
+		Level:     syslogSeverityLevels[pri%8],
+	}
+	data := map[string]interface{}{
+		"facility": syslogFacilities[pri/8],
+		"severity": pri % 8,
+	}
+
+	if strings.HasPrefix(rest, "1 ") {
+		if err := parseRFC5424(rest[2:], event, data); err != nil {
+			return nil, err
+		}
+	} else {
+		parseRFC3164(rest, event, data, receivedAt)
+	}
+
+	event.Data = data
+	if err := event.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid syslog message: %w", err)
+	}
+	return event, nil
+}
+
+// parseRFC5424 parses: TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseRFC5424(s string, event *structs.Event, data map[string]interface{}) error {
+	fields := make([]string, 5)
+	for i := range fields {
+		sp := strings.IndexByte(s, ' ')
+		if sp == -1 {
+			return fmt.Errorf("invalid syslog message: missing header fields")
+		}
+		fields[i] = s[:sp]
+		s = s[sp+1:]
+	}
+	timestamp, hostname, appName, procID, msgID := fields[0], fields[1], fields[2], fields[3], fields[4]
+
+	if timestamp != "-" {
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return fmt.Errorf("invalid syslog timestamp %q", timestamp)
+		}
+		event.Timestamp = t.UTC()
+	}
+	if hostname != "-" {
+		data["hostname"] = hostname
+	}
+	if appName != "-" {
+		event.Service = appName
+	}
+	if procID != "-" {
+		data["procid"] = procID
+	}
+	if msgID != "-" {
+		data["msgid"] = msgID
+		event.Name = msgID
+	}
+
+	rest, err := parseStructuredData(s, data)
+	if err != nil {
+		return err
+	}
+	rest = strings.TrimPrefix(rest, " ")
+	rest = strings.TrimPrefix(rest, "\ufeff")
+	if rest != "" {
+		data["message"] = rest
+	}
+	return nil
+}
+
+// parseStructuredData parses SD-ELEMENTs into data and returns the remaining message
+// Params are stored as data.<param>, prefixed with the SD-ID when names collide
+func parseStructuredData(s string, data map[string]interface{}) (string, error) {
+	if strings.HasPrefix(s, "-") {
+		return s[1:], nil
+	}
+
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		idEnd := strings.IndexAny(s, " ]")
+		if idEnd <= 0 {
+			return "", fmt.Errorf("invalid syslog structured data")
+		}
+		sdID := s[:idEnd]
+		s = s[idEnd:]
+
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, "=\"")
+			if eq <= 0 {
+				return "", fmt.Errorf("invalid syslog structured data param in %s", sdID)
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return "", fmt.Errorf("unterminated syslog structured data param %s", name)
+			}
+
+			key := unsafeKeyChars.ReplaceAllString(name, "_")
+			if _, exists := data[key]; exists {
+				key = unsafeKeyChars.ReplaceAllString(sdID+"_"+name, "_")
+			}
+			data[key] = value.String()
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return "", fmt.Errorf("invalid syslog structured data element %s", sdID)
+		}
+		s = s[1:]
+	}
+
+	return s, nil
+}
+
+// parseRFC3164 leniently parses legacy BSD syslog: TIMESTAMP HOSTNAME TAG[PID]: MSG
+func parseRFC3164(s string, event *structs.Event, data map[string]interface{}, receivedAt time.Time) {
+	if len(s) >= 16 {
+		if t, err := time.Parse(time.Stamp, s[:15]); err == nil {
+			t = time.Date(receivedAt.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+			if t.After(receivedAt.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			event.Timestamp = t
+			s = s[16:]
+
+			if sp := strings.IndexByte(s, ' '); sp > 0 {
+				data["hostname"] = s[:sp]
+				s = s[sp+1:]
+			}
+		}
+	}
+
+	if colon := strings.Index(s, ": "); colon > 0 && !strings.ContainsAny(s[:colon], " ") {
+		tag := s[:colon]
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			data["procid"] = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		event.Service = tag
+		s = s[colon+2:]
+	}
+
+	if s != "" {
+		data["message"] = s
+	}
+}
@@ -0,0 +1,120 @@
+package services_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+func TestParseSyslogMessage(t *testing.T) {
+	receivedAt := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		msg           string
+		wantTimestamp time.Time
+		wantService   string
+		wantName      string
+		wantLevel     string
+		wantData      map[string]interface{}
+	}{
+		{
+			name:          "rfc5424",
+			msg:           `<165>1 2025-01-15T09:59:58.123Z web-1 billing 4242 charge.failed [meta@1 card="visa" amount="12"] payment declined`,
+			wantTimestamp: time.Date(2025, 1, 15, 9, 59, 58, 123000000, time.UTC),
+			wantService:   "billing",
+			wantName:      "charge.failed",
+			wantLevel:     "info",
+			wantData: map[string]interface{}{
+				"facility": "local4", "severity": 5, "hostname": "web-1", "procid": "4242",
+				"msgid": "charge.failed", "card": "visa", "amount": "12", "message": "payment declined",
+			},
+		},
+		{
+			name:          "rfc5424 nil values",
+			msg:           `<11>1 - - - - - -`,
+			wantTimestamp: receivedAt,
+			wantService:   "syslog",
+			wantName:      "syslog",
+			wantLevel:     "error",
+			wantData:      map[string]interface{}{"facility": "user", "severity": 3},
+		},
+		{
+			name:          "rfc5424 escaped and colliding params",
+			msg:           `<14>1 - host app - - [a hostname="x\"y\]"][b@2 hostname="z"]`,
+			wantTimestamp: receivedAt,
+			wantService:   "app",
+			wantName:      "syslog",
+			wantLevel:     "info",
+			wantData: map[string]interface{}{
+				"facility": "user", "severity": 6, "hostname": "host", "a_hostname": `x"y]`, "b_2_hostname": "z",
+			},
+		},
+		{
+			name:          "rfc3164",
+			msg:           `<34>Jan 15 09:30:00 mail-1 postfix[811]: connection refused`,
+			wantTimestamp: time.Date(2025, 1, 15, 9, 30, 0, 0, time.UTC),
+			wantService:   "postfix",
+			wantName:      "syslog",
+			wantLevel:     "fatal",
+			wantData: map[string]interface{}{
+				"facility": "auth", "severity": 2, "hostname": "mail-1", "procid": "811", "message": "connection refused",
+			},
+		},
+		{
+			name:          "rfc3164 from last year",
+			msg:           `<13>Dec 31 23:59:59 host cron: done`,
+			wantTimestamp: time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+			wantService:   "cron",
+			wantName:      "syslog",
+			wantLevel:     "info",
+			wantData:      map[string]interface{}{"facility": "user", "severity": 5, "hostname": "host", "message": "done"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := services.ParseSyslogMessage([]byte(tt.msg), "syslog", receivedAt)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !event.Timestamp.Equal(tt.wantTimestamp) {
+				t.Fatalf("timestamp = %v, want %v", event.Timestamp, tt.wantTimestamp)
+			}
+			if event.Service != tt.wantService || event.Name != tt.wantName || event.Level != tt.wantLevel {
+				t.Fatalf("service, name, level = %q, %q, %q, want %q, %q, %q",
+					event.Service, event.Name, event.Level, tt.wantService, tt.wantName, tt.wantLevel)
+			}
+			if !reflect.DeepEqual(event.Data, tt.wantData) {
+				t.Fatalf("data = %v, want %v", event.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestParseSyslogMessageErrors(t *testing.T) {
+	tests := []struct {
+		msg     string
+		wantErr string
+	}{
+		{"hello", "missing PRI"},
+		{"<>1 - - - - - -", "malformed PRI"},
+		{"<192>1 - - - - - -", "PRI out of range"},
+		{"<14>1 - host app", "missing header fields"},
+		{"<14>1 yesterday host app - - -", "invalid syslog timestamp"},
+		{`<14>1 - host app - - [meta key="open`, "unterminated syslog structured data"},
+		{`<14>1 - host app - - [meta key]`, "invalid syslog structured data param"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			_, err := services.ParseSyslogMessage([]byte(tt.msg), "syslog", time.Now())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aidenappl/monitor-core/pipeline"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// heldTrace is a trace whose events wait for the keep or drop decision
+type heldTrace struct {
+	deadline time.Time
+	events   []*structs.Event
+	// keep is set once an error or slow span is seen, so the trace is kept whatever the rate
+	keep bool
+}
+
+// traceDecision is remembered after a trace is released, so its late events follow it
+type traceDecision struct {
+	keep    bool
+	expires time.Time
+}
+
+var (
+	tailMu         sync.Mutex
+	tailRate       = 1.0
+	tailWait       time.Duration
+	tailSlow       float64
+	tailMaxTraces  int
+	tailMaxEvents  int
+	heldTraces     = map[string]*heldTrace{}
+	traceDecisions = map[string]traceDecision{}
+	tracesKept     int64
+	tracesDropped  int64
+	tracesCapped   int64
+)
+
+// ConfigureTailSampling holds live events with a trace ID for wait, then keeps or drops
+// each trace whole: traces with an error or fatal event, or a span with data.duration_ms
+// of at least slow, are kept, and rate of the rest. A rate of 1 disables it; past
+// maxTraces held at once, new traces are decided by their first event, and a trace with
+// maxEvents held is decided early, so one huge trace can't hold unbounded memory.
+func ConfigureTailSampling(rate float64, wait, slow time.Duration, maxTraces, maxEvents int) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("the tail sample rate must be between 0 and 1")
+	}
+	if rate < 1 && wait <= 0 {
+		return fmt.Errorf("the tail sampling wait must be more than 0")
+	}
+	if rate < 1 && maxTraces <= 0 {
+		return fmt.Errorf("the tail sampling trace limit must be more than 0")
+	}
+	if rate < 1 && maxEvents <= 0 {
+		return fmt.Errorf("the tail sampling event limit must be more than 0")
+	}
+
+	tailMu.Lock()
+	tailRate, tailWait, tailMaxTraces, tailMaxEvents = rate, wait, maxTraces, maxEvents
+	tailSlow = float64(slow) / float64(time.Millisecond)
+	tailMu.Unlock()
+	return nil
+}
+
+// TailSamplingEnabled reports whether live traces are held for tail sampling
+func TailSamplingEnabled() bool {
+	return tailRate < 1
+}
+
+// holdForTrace holds a prepared live event until its trace is decided, returning
+// pipeline.ErrHeld. Events of a trace decided in the last wait follow the decision, and
+// internal events and events without a trace ID pass through. The event that fills a
+// trace to tailMaxEvents decides it: the held events are released on the next tick and
+// later ones follow the decision, so an error after that point doesn't keep the trace.
+func holdForTrace(event *structs.Event, now time.Time) error {
+	if tailRate >= 1 || event.TraceID == "" || event.Service == SelfServiceName {
+		return nil
+	}
+
+	tailMu.Lock()
+	defer tailMu.Unlock()
+
+	if decision, ok := traceDecisions[event.TraceID]; ok && now.Before(decision.expires) {
+		if decision.keep {
+			return nil
+		}
+		return ErrSampledOut
+	}
+
+	trace := heldTraces[event.TraceID]
+	if trace == nil {
+		if len(heldTraces) >= tailMaxTraces {
+			if keepTraceEvent(event) || keepSampled(event, tailRate) {
+				return nil
+			}
+			return ErrSampledOut
+		}
+		trace = &heldTrace{deadline: now.Add(tailWait)}
+		heldTraces[event.TraceID] = trace
+	}
+	trace.events = append(trace.events, event)
+	if keepTraceEvent(event) {
+		trace.keep = true
+	}
+	if len(trace.events) >= tailMaxEvents {
+		keep := trace.keep || keepSampled(trace.events[0], tailRate)
+		traceDecisions[event.TraceID] = traceDecision{keep: keep, expires: now.Add(tailWait)}
+		trace.deadline = now
+		tracesCapped++
+	}
+	return pipeline.ErrHeld
+}
+
+// keepTraceEvent reports whether an event keeps its whole trace: an error or a slow span
+func keepTraceEvent(event *structs.Event) bool {
+	if event.Level == "error" || event.Level == "fatal" {
+		return true
+	}
+	if tailSlow <= 0 {
+		return false
+	}
+	duration, ok := toNumber(event.Data["duration_ms"])
+	return ok && duration >= tailSlow
+}
+
+// decideTraces decides the traces past their deadline, or all of them, and returns the
+// events of those kept and dropped. Sampled traces are chosen by trace ID like sample
+// rules, so instances holding parts of the same trace agree unless only one saw an error.
+func decideTraces(now time.Time, all bool) (kept, dropped []*structs.Event) {
+	tailMu.Lock()
+	defer tailMu.Unlock()
+
+	for id, trace := range heldTraces {
+		if !all && now.Before(trace.deadline) {
+			continue
+		}
+		delete(heldTraces, id)
+		keep := trace.keep || keepSampled(trace.events[0], tailRate)
+		traceDecisions[id] = traceDecision{keep: keep, expires: now.Add(tailWait)}
+		if keep {
+			kept = append(kept, trace.events...)
+			tracesKept++
+		} else {
+			dropped = append(dropped, trace.events...)
+			tracesDropped++
+		}
+	}
+	for id, decision := range traceDecisions {
+		if !now.Before(decision.expires) {
+			delete(traceDecisions, id)
+		}
+	}
+	return kept, dropped
+}
+
+// RunTailSampling releases the kept traces into the queue as they are decided, and reports
+// the events of dropped traces as sampled out, until the context is cancelled
+func RunTailSampling(ctx context.Context, queue *pipeline.Queue) {
+	interval := min(time.Second, tailWait)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			releaseTraces(queue, time.Now().UTC(), false)
+		}
+	}
+}
+
+// FlushTailSampling decides every held trace now and releases those kept, so they are
+// written before the queue closes on shutdown
+func FlushTailSampling(queue *pipeline.Queue) {
+	releaseTraces(queue, time.Now().UTC(), true)
+}
+
+// releaseTraces decides traces like decideTraces, queues the events of those kept and
+// rejects those of the dropped, so reject hooks see them like events sampled out on arrival
+func releaseTraces(queue *pipeline.Queue, now time.Time, all bool) {
+	kept, dropped := decideTraces(now, all)
+	if len(kept) > 0 {
+		queue.Release(kept)
+	}
+	if len(dropped) > 0 {
+		queue.Reject(dropped, ErrSampledOut)
+	}
+}
+
+// TailSampleStats returns the number of traces held now and the most events held per trace,
+// and the traces kept, dropped, and decided early at that limit since startup
+func TailSampleStats() (held, maxEvents int, kept, dropped, capped int64) {
+	tailMu.Lock()
+	defer tailMu.Unlock()
+	return len(heldTraces), tailMaxEvents, tracesKept, tracesDropped, tracesCapped
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+// Query types with their own default and maximum time ranges. Compares and gauge
+// batches are gauges.
+const (
+	RangeEvents     = "events"
+	RangeAnalytics  = "analytics"
+	RangeTimeSeries = "timeseries"
+	RangeTopN       = "topn"
+	RangeGauge      = "gauge"
+	RangeRatio      = "ratio"
+)
+
+var rangeTypes = map[string]bool{
+	RangeEvents:     true,
+	RangeAnalytics:  true,
+	RangeTimeSeries: true,
+	RangeTopN:       true,
+	RangeGauge:      true,
+	RangeRatio:      true,
+}
+
+var (
+	// defaultRange is how far back a query without from reads; 0 leaves it open
+	defaultRange  time.Duration
+	maxRange      = MaxQueryDuration
+	rangeDefaults map[string]time.Duration
+	rangeMaxes    map[string]time.Duration
+)
+
+// ConfigureTimeRanges sets what a query without from reads (the defaultRange before to,
+// which is now when missing too) and the longest range a query may ask for, with
+// overrides by query type like timeseries=24h. A default of 0 reads every retained event.
+func ConfigureTimeRanges(defaultDuration, maxDuration time.Duration, defaults, maxes map[string]string) error {
+	if defaultDuration < 0 || maxDuration <= 0 {
+		return fmt.Errorf("the default time range must be 0 or more and the maximum more than 0")
+	}
+	parse := func(overrides map[string]string) (map[string]time.Duration, error) {
+		ranges := make(map[string]time.Duration, len(overrides))
+		for kind, value := range overrides {
+			if !rangeTypes[kind] {
+				return nil, fmt.Errorf("unknown query type %q (use events, analytics, timeseries, topn, gauge, or ratio)", kind)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid time range %s=%s", kind, value)
+			}
+			ranges[kind] = d
+		}
+		return ranges, nil
+	}
+	d, err := parse(defaults)
+	if err != nil {
+		return err
+	}
+	m, err := parse(maxes)
+	if err != nil {
+		return err
+	}
+	for kind, limit := range m {
+		if limit == 0 {
+			return fmt.Errorf("invalid time range maximum %s=0", kind)
+		}
+	}
+
+	defaultRange, maxRange = defaultDuration, maxDuration
+	rangeDefaults, rangeMaxes = d, m
+	return nil
+}
+
+// defaultRangeFor returns how far back a query of kind without from reads
+func defaultRangeFor(kind string) time.Duration {
+	if d, ok := rangeDefaults[kind]; ok {
+		return d
+	}
+	return defaultRange
+}
+
+// maxRangeFor returns the longest time range a query of kind may ask for
+func maxRangeFor(kind string) time.Duration {
+	if m, ok := rangeMaxes[kind]; ok {
+		return m
+	}
+	return maxRange
+}
+
+// defaultTimeRange fills the from and to a query of kind left out. Without a default
+// range, a missing from stays open and so does to.
+func defaultTimeRange(kind string, from, to *time.Time) {
+	if !from.IsZero() {
+		return
+	}
+	d := defaultRangeFor(kind)
+	if d == 0 {
+		return
+	}
+	if to.IsZero() {
+		*to = time.Now().UTC()
+	}
+	*from = to.Add(-d)
+}
+
+// rangeEnd returns the end of a time range, now when to is left out
+func rangeEnd(to time.Time) time.Time {
+	if to.IsZero() {
+		return time.Now().UTC()
+	}
+	return to
+}
+
+// applyTimeRange fills the from and to a query of kind left out and checks the range
+// against the maximum for kind
+func applyTimeRange(kind string, from, to *time.Time) error {
+	defaultTimeRange(kind, from, to)
+	if from.IsZero() {
+		return nil
+	}
+	if limit := maxRangeFor(kind); rangeEnd(*to).Sub(*from) > limit {
+		return fmt.Errorf("time range too large (max %v)", limit)
+	}
+	return nil
+}
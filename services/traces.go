@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+const (
+	// defaultTraceLogs and maxTraceLogs cap the log events returned for a trace
+	defaultTraceLogs = 500
+	maxTraceLogs     = 5000
+)
+
+// Trace is the spans of a trace, oldest first
+type Trace struct {
+	TraceID    string           `json:"trace_id"`
+	Start      time.Time        `json:"start"`
+	End        time.Time        `json:"end"`
+	DurationMs float64          `json:"duration_ms"`
+	Services   []string         `json:"services"`
+	Spans      []*structs.Event `json:"spans"`
+	// Truncated is set when the trace had more spans than were read, by the span limit
+	// or a result cap
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// TraceLogs is the log-style events correlated with a trace, oldest first
+type TraceLogs struct {
+	TraceID string           `json:"trace_id"`
+	Events  []*structs.Event `json:"events"`
+	// Truncated is set when there were more events than the limit or a result cap allowed
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// GetTrace returns the spans (events with data.span_id) of a trace, or nil when it has
+// none the request can read
+func (s *Service) GetTrace(ctx context.Context, traceID string) (*Trace, error) {
+	traceID = structs.NormalizeID(traceID)
+	if !structs.IsValidID(traceID) {
+		return nil, fmt.Errorf("invalid trace_id: must be a UUID")
+	}
+
+	builder := applyAccess(ctx, sq.Select(eventColumns()...).
+		From(eventsTable(ctx)).
+		Where("trace_id = ?", traceID).
+		Where("JSONHas(data, 'span_id')").
+		OrderBy("timestamp").
+		Limit(maxTraceSpans+1).
+		PlaceholderFormat(sq.Question))
+	spans, err := s.queryEvents(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+	if len(spans) == 0 {
+		return nil, nil
+	}
+
+	trace := &Trace{TraceID: traceID, Services: []string{}}
+	if len(spans) > maxTraceSpans {
+		spans, trace.Truncated = spans[:maxTraceSpans], true
+	}
+	if resultCapped(ctx) {
+		trace.Truncated = true
+	}
+	trace.Spans = spans
+
+	seen := map[string]bool{}
+	trace.Start = spans[0].Timestamp
+	for _, span := range spans {
+		end := span.Timestamp
+		if duration, ok := toNumber(span.Data["duration_ms"]); ok && duration > 0 {
+			end = end.Add(time.Duration(duration * float64(time.Millisecond)))
+		}
+		if end.After(trace.End) {
+			trace.End = end
+		}
+		if !seen[span.Service] {
+			seen[span.Service] = true
+			trace.Services = append(trace.Services, span.Service)
+		}
+	}
+	sort.Strings(trace.Services)
+	trace.DurationMs = float64(trace.End.Sub(trace.Start)) / float64(time.Millisecond)
+	return trace, nil
+}
+
+// GetRequestTrace returns the trace of a request: the most recent trace ID on the
+// request's events, or nil when none of them has one
+func (s *Service) GetRequestTrace(ctx context.Context, requestID string) (*Trace, error) {
+	requestID = structs.NormalizeID(requestID)
+	if !structs.IsValidID(requestID) {
+		return nil, fmt.Errorf("invalid request_id: must be a UUID")
+	}
+
+	builder := applyAccess(ctx, sq.Select("trace_id").
+		From(eventsTable(ctx)).
+		Where("request_id = ?", requestID).
+		Where("trace_id != ''").
+		OrderBy("timestamp DESC").
+		Limit(1).
+		PlaceholderFormat(sq.Question))
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	var traceID string
+	if rows.Next() {
+		if err := rows.Scan(&traceID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+	if traceID == "" {
+		return nil, nil
+	}
+	return s.GetTrace(ctx, traceID)
+}
+
+// GetTraceLogs returns the log-style events of a trace: its events that aren't spans,
+// and events without a trace ID that share a request ID with it, up to limit
+func (s *Service) GetTraceLogs(ctx context.Context, traceID string, limit int) (*TraceLogs, error) {
+	traceID = structs.NormalizeID(traceID)
+	if !structs.IsValidID(traceID) {
+		return nil, fmt.Errorf("invalid trace_id: must be a UUID")
+	}
+	if limit <= 0 {
+		limit = defaultTraceLogs
+	}
+	limit = min(limit, maxTraceLogs)
+
+	table := eventsTable(ctx)
+	builder := applyAccess(ctx, sq.Select(eventColumns()...).
+		From(table).
+		Where(sq.Or{
+			sq.Eq{"trace_id": traceID},
+			sq.Expr("(trace_id = '' AND request_id != '' AND request_id IN (SELECT request_id FROM "+table+" WHERE trace_id = ? AND request_id != ''))", traceID),
+		}).
+		Where("NOT JSONHas(data, 'span_id')").
+		OrderBy("timestamp").
+		Limit(uint64(limit+1)).
+		PlaceholderFormat(sq.Question))
+	events, err := s.queryEvents(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := &TraceLogs{TraceID: traceID, Events: events}
+	if len(events) > limit {
+		logs.Events, logs.Truncated = events[:limit], true
+	}
+	if resultCapped(ctx) {
+		logs.Truncated = true
+	}
+	if logs.Events == nil {
+		logs.Events = []*structs.Event{}
+	}
+	return logs, nil
+}
+
+// queryEvents runs a query selecting eventColumns and scans its events
+func (s *Service) queryEvents(ctx context.Context, builder sq.SelectBuilder) ([]*structs.Event, error) {
+	querySQL, queryArgs, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.queryRows(ctx, querySQL, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*structs.Event
+	for rows.Next() {
+		e, err := scanEvent(ctx, rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration failed: %w", err)
+	}
+	return events, nil
+}
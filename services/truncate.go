@@ -0,0 +1,132 @@
+package services
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// TruncatedMarker is the data key listing the fields that were truncated
+const TruncatedMarker = "_truncated"
+
+// truncatedPreviewSize is what a value is cut down to when the whole event is over the size cap
+const truncatedPreviewSize = 1024
+
+var (
+	maxEventSize int
+	maxFieldSize int
+
+	truncatedEvents atomic.Int64
+	truncatedFields atomic.Int64
+)
+
+// SetSizeLimits configures the per-event (serialized data) and per-field caps in bytes; 0 disables a cap
+func SetSizeLimits(maxEvent, maxField int) {
+	maxEventSize = maxEvent
+	maxFieldSize = maxField
+}
+
+// TruncationStats returns the number of events and data fields truncated since startup
+func TruncationStats() (events, fields int64) {
+	return truncatedEvents.Load(), truncatedFields.Load()
+}
+
+// truncateEvent enforces the size caps on event data. Oversized values are cut down rather
+// than failing the event, and their keys are listed under data._truncated.
+func truncateEvent(event *structs.Event) {
+	if len(event.Data) == 0 || (maxEventSize <= 0 && maxFieldSize <= 0) {
+		return
+	}
+
+	truncated := make(map[string]bool)
+
+	if maxFieldSize > 0 {
+		for key, value := range event.Data {
+			if cut, ok := truncateValue(value, maxFieldSize); ok {
+				event.Data[key] = cut
+				truncated[key] = true
+			}
+		}
+	}
+
+	if maxEventSize > 0 {
+		sizes := make(map[string]int, len(event.Data))
+		total := 2
+		for key, value := range event.Data {
+			sizes[key] = jsonSize(value)
+			total += len(key) + 4 + sizes[key]
+		}
+
+		if total > maxEventSize {
+			// Shrink the largest values first, then drop values if that isn't enough
+			keys := make([]string, 0, len(sizes))
+			for key := range sizes {
+				keys = append(keys, key)
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				return sizes[keys[i]] > sizes[keys[j]]
+			})
+
+			for _, key := range keys {
+				if total <= maxEventSize {
+					break
+				}
+				if cut, ok := truncateValue(event.Data[key], truncatedPreviewSize); ok {
+					event.Data[key] = cut
+					total -= sizes[key] - jsonSize(cut)
+					sizes[key] = jsonSize(cut)
+					truncated[key] = true
+				}
+			}
+			for _, key := range keys {
+				if total <= maxEventSize {
+					break
+				}
+				delete(event.Data, key)
+				total -= len(key) + 4 + sizes[key]
+				truncated[key] = true
+			}
+		}
+	}
+
+	if len(truncated) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(truncated))
+	for key := range truncated {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	event.Data[TruncatedMarker] = keys
+
+	truncatedEvents.Add(1)
+	truncatedFields.Add(int64(len(keys)))
+}
+
+// truncateValue cuts strings to limit bytes; objects and arrays over the limit are
+// replaced by their truncated JSON text
+func truncateValue(value interface{}, limit int) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if len(v) > limit {
+			return truncateString(v, limit), true
+		}
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err == nil && len(b) > limit {
+			return truncateString(string(b), limit), true
+		}
+	}
+	return value, false
+}
+
+func jsonSize(value interface{}) int {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
@@ -0,0 +1,429 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Query types that can be validated but not saved
+const (
+	// QueryTypeFilterCompare is the A/B comparison of /v1/compare/filters
+	QueryTypeFilterCompare = "filter_compare"
+	// QueryTypeRatio is the numerator/denominator query of /v1/ratio
+	QueryTypeRatio = "ratio"
+)
+
+// ValidationIssue is a problem with one part of a query, located by its JSON path
+// (e.g. "filters[1].operator")
+type ValidationIssue struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// QueryValidation is the outcome of validating a query without running it. Errors make
+// the query fail; warnings point out queries that run but likely not as intended.
+type QueryValidation struct {
+	Valid    bool              `json:"valid"`
+	Type     string            `json:"type"`
+	Errors   []ValidationIssue `json:"errors"`
+	Warnings []ValidationIssue `json:"warnings"`
+	// EstimatedPoints is the number of buckets per series, for queries with an interval
+	EstimatedPoints int `json:"estimated_points,omitempty"`
+
+	// prefix locates the part being checked, like a gauge of a batch
+	prefix string
+}
+
+func (v *QueryValidation) fail(path string, err error) {
+	v.Errors = append(v.Errors, ValidationIssue{Path: v.prefix + path, Message: err.Error()})
+}
+
+func (v *QueryValidation) warn(path, format string, args ...interface{}) {
+	v.Warnings = append(v.Warnings, ValidationIssue{Path: v.prefix + path, Message: fmt.Sprintf(format, args...)})
+}
+
+// gaugeBody is the body of /v1/gauge: a gauge, or a batch when gauges is set
+type gaugeBody struct {
+	structs.GaugeQuery
+	Gauges []structs.GaugeQuery `json:"gauges"`
+}
+
+// ValidateQuery checks a query body of the given type (analytics, timeseries, topn, gauge,
+// compare, filter_compare, or ratio) the way the query endpoints would, collecting every problem
+// instead of stopping at the first, without executing it
+func ValidateQuery(ctx context.Context, queryType string, body json.RawMessage) *QueryValidation {
+	v := &QueryValidation{Type: queryType, Errors: []ValidationIssue{}, Warnings: []ValidationIssue{}}
+
+	var query interface{}
+	switch queryType {
+	case SavedQueryAnalytics:
+		query = &structs.AnalyticsQuery{}
+	case SavedQueryTimeSeries:
+		query = &structs.TimeSeriesQuery{}
+	case SavedQueryTopN:
+		query = &structs.TopNQuery{}
+	case SavedQueryGauge:
+		query = &gaugeBody{}
+	case SavedQueryCompare:
+		query = &structs.CompareQuery{}
+	case QueryTypeFilterCompare:
+		query = &structs.FilterCompareQuery{}
+	case QueryTypeRatio:
+		query = &structs.RatioQuery{}
+	default:
+		v.fail("type", fmt.Errorf("invalid query type: %q (use analytics, timeseries, topn, gauge, compare, filter_compare, or ratio)", queryType))
+		return v
+	}
+
+	if len(body) == 0 {
+		v.fail("query", fmt.Errorf("query is required"))
+		return v
+	}
+	dec := json.NewDecoder(strings.NewReader(string(body)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(query); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			v.fail(typeErr.Field, fmt.Errorf("invalid value: expected %s", typeErr.Type))
+		} else {
+			v.fail("query", fmt.Errorf("invalid query: %w", err))
+		}
+		return v
+	}
+	setDefaultAggregation(query)
+
+	switch q := query.(type) {
+	case *structs.AnalyticsQuery:
+		v.aggregation(ctx, q.Aggregation, q.Field)
+		v.columns(ctx, q.Columns)
+		v.groupBy(ctx, q.GroupBy)
+		v.filters(ctx, "filters", q.Filters)
+		v.timeRange(RangeAnalytics, q.From, q.To, false, maxRangeFor(RangeAnalytics))
+		v.sort(ctx, q)
+		if q.Limit > maxAnalyticsLimit {
+			v.warn("limit", "limit is capped at %d", maxAnalyticsLimit)
+		}
+		if _, err := analyticsOffset(q); err != nil {
+			path := "offset"
+			if q.Cursor != "" {
+				path = "cursor"
+			}
+			v.fail(path, err)
+		}
+	case *structs.TimeSeriesQuery:
+		v.aggregation(ctx, q.Aggregation, q.Field)
+		v.groupBy(ctx, q.GroupBy)
+		v.filters(ctx, "filters", q.Filters)
+		v.timeRange(RangeTimeSeries, q.From, q.To, false, maxTimeSeriesDuration(q))
+		// The rest is checked against the range the query will read
+		filled := *q
+		q = &filled
+		defaultTimeRange(RangeTimeSeries, &q.From, &q.To)
+		v.interval(q.Interval, q.From, q.To)
+		if q.Window != "" {
+			if _, _, err := windowBuckets(q); err != nil {
+				v.fail("window", err)
+			}
+		}
+		if q.CompareOffset != "" {
+			if _, err := parseOffset("compare_offset", q.CompareOffset); err != nil {
+				v.fail("compare_offset", err)
+			} else if q.From.IsZero() || q.To.IsZero() {
+				v.fail("compare_offset", fmt.Errorf("from and to are required with compare_offset"))
+			}
+		}
+		if q.FillZeros && (q.From.IsZero() || q.To.IsZero()) {
+			v.warn("fill_zeros", "without from and to, series are only filled between the first and last buckets with data")
+		}
+		if err := checkIncludeGroups(q); err != nil {
+			v.fail("include_groups", err)
+		}
+	case *structs.TopNQuery:
+		v.aggregation(ctx, q.Aggregation, q.Field)
+		if q.GroupBy == "" {
+			v.fail("group_by", fmt.Errorf("group_by is required"))
+		} else {
+			v.group(ctx, "group_by", q.GroupBy)
+		}
+		v.filters(ctx, "filters", q.Filters)
+		v.timeRange(RangeTopN, q.From, q.To, false, maxRangeFor(RangeTopN))
+	case *gaugeBody:
+		if q.Gauges == nil {
+			v.gauge(ctx, &q.GaugeQuery)
+			break
+		}
+		v.gaugeBatch(ctx, &structs.GaugeBatchQuery{
+			Gauges:            q.Gauges,
+			Filters:           q.Filters,
+			From:              q.From,
+			To:                q.To,
+			Sparkline:         q.Sparkline,
+			SparklineInterval: q.SparklineInterval,
+		})
+	case *structs.CompareQuery:
+		v.aggregation(ctx, q.Aggregation, q.Field)
+		v.filters(ctx, "filters", q.Filters)
+		v.timeRange(RangeGauge, q.From, q.To, true, maxRangeFor(RangeGauge))
+		if q.CompareFrom.IsZero() != q.CompareTo.IsZero() {
+			v.warn("compare_from", "compare_from and compare_to are only used together, so the previous period is calculated")
+		}
+	case *structs.FilterCompareQuery:
+		v.aggregation(ctx, q.Aggregation, q.Field)
+		v.filters(ctx, "filters", q.Filters)
+		for i, side := range []structs.FilterSet{q.A, q.B} {
+			name := []string{"a", "b"}[i]
+			if len(side.Filters) == 0 {
+				v.fail(name+".filters", fmt.Errorf("filters are required for both a and b"))
+			}
+			v.filters(ctx, name+".filters", side.Filters)
+		}
+		v.timeRange(RangeGauge, q.From, q.To, true, maxRangeFor(RangeGauge))
+		if q.Interval != "" {
+			v.interval(q.Interval, q.From, q.To)
+		}
+	case *structs.RatioQuery:
+		v.ratio(ctx, q)
+	}
+
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+// columns checks the name, aggregation, and filters of each analytics column
+func (v *QueryValidation) columns(ctx context.Context, columns []structs.AggregationColumn) {
+	if len(columns) > maxColumns {
+		v.fail("columns", fmt.Errorf("too many columns (max %d)", maxColumns))
+	}
+	seen := make(map[string]bool)
+	for i, c := range columns {
+		v.prefix = fmt.Sprintf("columns[%d].", i)
+		switch {
+		case !safeIdentifierRegex.MatchString(c.Name) || c.Name == "value":
+			v.fail("name", fmt.Errorf("invalid column name: %q", c.Name))
+		case seen[c.Name]:
+			v.fail("name", fmt.Errorf("invalid column name: %s is used twice", c.Name))
+		}
+		seen[c.Name] = true
+		agg := c.Aggregation
+		if agg == "" {
+			agg = structs.AggCount
+		}
+		v.aggregation(ctx, agg, c.Field)
+		v.filters(ctx, "filters", c.Filters)
+	}
+	v.prefix = ""
+}
+
+// sort checks the sort keys of an analytics query, or its order_by without them
+func (v *QueryValidation) sort(ctx context.Context, q *structs.AnalyticsQuery) {
+	if len(q.Sort) == 0 {
+		if q.OrderBy != "" && q.OrderBy != "value" && !contains(q.GroupBy, q.OrderBy) {
+			v.warn("order_by", "%s is not in group_by, so results are ordered by value", q.OrderBy)
+		}
+		return
+	}
+	if q.OrderBy != "" {
+		v.warn("order_by", "order_by is ignored with sort")
+	}
+	if len(q.Sort) > maxSortKeys {
+		v.fail("sort", fmt.Errorf("too many sort keys (max %d)", maxSortKeys))
+	}
+	aliases := make([]string, len(q.GroupBy))
+	for i := range q.GroupBy {
+		aliases[i] = fmt.Sprintf("group_%d", i)
+	}
+	for i, key := range q.Sort {
+		path := fmt.Sprintf("sort[%d].by", i)
+		if _, err := sortExpr(q, aliases, key.By); err != nil {
+			v.fail(path, err)
+		}
+	}
+	for _, field := range sortFields(q) {
+		v.sensitive(ctx, "sort", field)
+	}
+}
+
+// ratio checks a ratio query's aggregation, both filter sets, and its breakdown
+func (v *QueryValidation) ratio(ctx context.Context, q *structs.RatioQuery) {
+	switch q.Aggregation {
+	case structs.AggCount, structs.AggSum:
+		v.aggregation(ctx, q.Aggregation, q.Field)
+	default:
+		v.fail("aggregation", fmt.Errorf("invalid ratio aggregation: %s (count or sum)", q.Aggregation))
+	}
+	if len(q.Numerator) == 0 {
+		v.fail("numerator", fmt.Errorf("numerator filters are required"))
+	}
+	v.filters(ctx, "numerator", q.Numerator)
+	if len(q.Denominator) == 0 {
+		v.warn("denominator", "no denominator: the ratio is over every event matching filters")
+	}
+	v.filters(ctx, "denominator", q.Denominator)
+	v.filters(ctx, "filters", q.Filters)
+	v.timeRange(RangeRatio, q.From, q.To, q.Interval != "" && defaultRangeFor(RangeRatio) == 0, maxRangeFor(RangeRatio))
+	v.groupBy(ctx, q.GroupBy)
+	if q.Interval != "" {
+		if len(q.GroupBy) > 0 {
+			v.fail("interval", fmt.Errorf("invalid ratio query: use group_by or interval, not both"))
+		}
+		from, to := q.From, q.To
+		defaultTimeRange(RangeRatio, &from, &to)
+		v.interval(q.Interval, from, to)
+	}
+	if q.Limit > maxAnalyticsLimit {
+		v.warn("limit", "limit is capped at %d", maxAnalyticsLimit)
+	}
+}
+
+// gauge checks a single gauge and its sparkline
+func (v *QueryValidation) gauge(ctx context.Context, q *structs.GaugeQuery) {
+	if q.Aggregation == "" {
+		q.Aggregation = structs.AggCount
+	}
+	v.aggregation(ctx, q.Aggregation, q.Field)
+	v.filters(ctx, "filters", q.Filters)
+	v.timeRange(RangeGauge, q.From, q.To, false, maxRangeFor(RangeGauge))
+	if q.Sparkline < 0 || q.Sparkline > MaxSparklinePoints {
+		v.fail("sparkline", fmt.Errorf("invalid sparkline: %d points (max %d)", q.Sparkline, MaxSparklinePoints))
+	}
+	if q.SparklineInterval != "" {
+		if _, err := buildIntervalExpr(q.SparklineInterval); err != nil {
+			v.fail("sparkline_interval", fmt.Errorf("invalid sparkline_interval: %s", q.SparklineInterval))
+		}
+	}
+}
+
+// gaugeBatch checks every gauge of a batch with the shared settings applied
+func (v *QueryValidation) gaugeBatch(ctx context.Context, batch *structs.GaugeBatchQuery) {
+	if len(batch.Gauges) == 0 {
+		v.fail("gauges", fmt.Errorf("gauges are required"))
+		return
+	}
+	if len(batch.Gauges) > MaxBatchGauges {
+		v.fail("gauges", fmt.Errorf("invalid gauges: too many (max %d)", MaxBatchGauges))
+	}
+	queries, err := gaugeBatchQueries(batch)
+	if err != nil {
+		v.fail("gauges", err)
+		return
+	}
+	for i, q := range queries {
+		v.prefix = fmt.Sprintf("gauges[%d].", i)
+		v.gauge(ctx, q)
+	}
+	v.prefix = ""
+}
+
+// aggregation checks the aggregation and the field it needs
+func (v *QueryValidation) aggregation(ctx context.Context, agg structs.AggregationType, field string) {
+	if _, err := buildAggregationExpr(agg, field); err != nil {
+		path := "field"
+		if strings.HasPrefix(err.Error(), "unsupported aggregation") {
+			path = "aggregation"
+		}
+		v.fail(path, err)
+		return
+	}
+	if field != "" {
+		v.sensitive(ctx, "field", field)
+	}
+}
+
+// groupBy checks each group by field
+func (v *QueryValidation) groupBy(ctx context.Context, groupBy []string) {
+	if len(groupBy) > 10 {
+		v.fail("group_by", fmt.Errorf("too many group by fields (max 10)"))
+	}
+	for i, g := range groupBy {
+		v.group(ctx, fmt.Sprintf("group_by[%d]", i), g)
+	}
+}
+
+func (v *QueryValidation) group(ctx context.Context, path, g string) {
+	if _, err := buildGroupExpr(g); err != nil {
+		v.fail(path, err)
+		return
+	}
+	v.sensitive(ctx, path, g)
+}
+
+// filters checks each filter's field, operator, and value
+func (v *QueryValidation) filters(ctx context.Context, path string, filters []structs.QueryFilter) {
+	for i, f := range filters {
+		filterPath := fmt.Sprintf("%s[%d]", path, i)
+		if _, _, err := buildSingleFilter(f); err != nil {
+			switch {
+			case strings.HasPrefix(err.Error(), "unsupported operator"):
+				filterPath += ".operator"
+			case strings.HasPrefix(err.Error(), "in operator"):
+				filterPath += ".value"
+			default:
+				filterPath += ".field"
+			}
+			v.fail(filterPath, err)
+			continue
+		}
+		v.sensitive(ctx, filterPath+".field", f.Field)
+	}
+}
+
+// sensitive rejects reading a sensitive key without the pii:read scope
+func (v *QueryValidation) sensitive(ctx context.Context, path, field string) {
+	if err := checkSensitiveFields(ctx, []string{field}, nil); err != nil {
+		v.fail(path, err)
+	}
+}
+
+// timeRange checks from and to of a query of kind against limit; required makes both
+// mandatory
+func (v *QueryValidation) timeRange(kind string, from, to time.Time, required bool, limit time.Duration) {
+	switch {
+	case required && from.IsZero():
+		v.fail("from", fmt.Errorf("from is required"))
+	case required && to.IsZero():
+		v.fail("to", fmt.Errorf("to is required"))
+	case from.IsZero() && defaultRangeFor(kind) > 0:
+		v.warn("from", "no from: the query reads the last %v", defaultRangeFor(kind))
+	case from.IsZero():
+		v.warn("from", "no from: the query scans every retained event")
+	case !to.IsZero() && to.Before(from):
+		v.warn("to", "to is before from, so nothing matches")
+	case rangeEnd(to).Sub(from) > limit:
+		v.fail("to", fmt.Errorf("time range too large (max %v)", limit))
+	}
+}
+
+// interval checks a bucket interval, estimates the buckets of each series, and applies
+// the cap on the number of points
+func (v *QueryValidation) interval(interval structs.IntervalType, from, to time.Time) {
+	if interval == "" {
+		v.fail("interval", fmt.Errorf("interval is required"))
+		return
+	}
+	if _, err := buildIntervalExpr(interval); err != nil {
+		v.fail("interval", err)
+		return
+	}
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return
+	}
+	v.EstimatedPoints = estimatePoints(from, to, interval)
+	if v.EstimatedPoints > MaxTimeSeriesPoints {
+		v.fail("interval", fmt.Errorf("query would return too many data points (estimated %d, max %d); use a larger interval or smaller time range", v.EstimatedPoints, MaxTimeSeriesPoints))
+	}
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
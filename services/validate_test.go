@@ -0,0 +1,40 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/services"
+)
+
+func TestValidateQueryRangeWithoutTo(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		from    time.Time
+		wantErr bool
+	}{
+		{name: "within the maximum", from: now.Add(-time.Hour)},
+		{name: "past the maximum", from: now.Add(-2 * services.MaxQueryDuration), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{"aggregation": "count", "from": tt.from})
+			v := services.ValidateQuery(context.Background(), services.SavedQueryAnalytics, body)
+
+			var rangeErr bool
+			for _, issue := range v.Errors {
+				if issue.Path == "to" && strings.Contains(issue.Message, "time range too large") {
+					rangeErr = true
+				}
+			}
+			if rangeErr != tt.wantErr {
+				t.Fatalf("time range error = %v, want %v (errors %v)", rangeErr, tt.wantErr, v.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// stripeSignatureTolerance is how old a Stripe signature timestamp may be before it's rejected
+const stripeSignatureTolerance = 5 * time.Minute
+
+// webhookPlaceholder matches {path} placeholders in mapping templates
+var webhookPlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// ErrWebhookSignature is returned when a webhook fails signature verification
+var ErrWebhookSignature = errors.New("invalid webhook signature")
+
+// WebhookSource describes how to verify and map payloads from one webhook sender
+// Templates may reference payload fields ({repository.full_name}, {commits.0.id}),
+// request headers ({header.X-GitHub-Event}), and query parameters ({query.job})
+type WebhookSource struct {
+	// Verify is the signature scheme: github, stripe, hmac-sha256, or token
+	Verify string `json:"verify"`
+	Secret string `json:"secret"`
+	// SignatureHeader is the header carrying the hex HMAC for hmac-sha256 (default X-Webhook-Signature)
+	SignatureHeader string `json:"signature_header,omitempty"`
+
+	Service string `json:"service"`
+	Env     string `json:"env,omitempty"`
+	Name    string `json:"name"`
+	// Level is rendered and then looked up in Levels; unmapped values that aren't allowed levels become info
+	Level  string            `json:"level,omitempty"`
+	Levels map[string]string `json:"levels,omitempty"`
+	// Data maps data keys to templates; a template that is a single placeholder keeps the value's JSON type
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// builtinWebhookSources are available once a secret is configured for them
+var builtinWebhookSources = map[string]WebhookSource{
+	"github": {
+		Verify:  "github",
+		Service: "github",
+		Name:    "github.{header.X-GitHub-Event}",
+		Level:   "{workflow_run.conclusion}{check_run.conclusion}{deployment_status.state}",
+		Levels: map[string]string{
+			"failure":   "error",
+			"timed_out": "error",
+			"error":     "error",
+			"cancelled": "warn",
+		},
+		Data: map[string]string{
+			"delivery_id": "{header.X-GitHub-Delivery}",
+			"repository":  "{repository.full_name}",
+			"action":      "{action}",
+			"sender":      "{sender.login}",
+			"ref":         "{ref}",
+			"commit":      "{after}",
+			"workflow":    "{workflow_run.name}",
+			"conclusion":  "{workflow_run.conclusion}",
+			"environment": "{deployment.environment}",
+			"state":       "{deployment_status.state}",
+			"url":         "{workflow_run.html_url}",
+		},
+	},
+	"stripe": {
+		Verify:  "stripe",
+		Service: "stripe",
+		Name:    "stripe.{type}",
+		Level:   "{type}",
+		Levels: map[string]string{
+			"charge.failed":                 "error",
+			"invoice.payment_failed":        "error",
+			"payment_intent.payment_failed": "error",
+			"charge.dispute.created":        "warn",
+		},
+		Data: map[string]string{
+			"stripe_event_id": "{id}",
+			"livemode":        "{livemode}",
+			"object":          "{data.object.object}",
+			"object_id":       "{data.object.id}",
+			"amount":          "{data.object.amount}",
+			"currency":        "{data.object.currency}",
+			"status":          "{data.object.status}",
+			"customer":        "{data.object.customer}",
+		},
+	},
+}
+
+// LoadWebhookSources merges the built-in sources with those in configPath (a JSON object of
+// source name to WebhookSource; same-named entries replace built-ins) and applies secrets
+// Sources without a secret are left out so they can't be called unauthenticated
+func LoadWebhookSources(configPath string, secrets map[string]string) (map[string]*WebhookSource, error) {
+	all := make(map[string]WebhookSource, len(builtinWebhookSources))
+	for name, source := range builtinWebhookSources {
+		all[name] = source
+	}
+
+	if configPath != "" {
+		b, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook config: %w", err)
+		}
+		var custom map[string]WebhookSource
+		if err := json.Unmarshal(b, &custom); err != nil {
+			return nil, fmt.Errorf("invalid webhook config: %w", err)
+		}
+		for name, source := range custom {
+			all[name] = source
+		}
+	}
+
+	sources := make(map[string]*WebhookSource)
+	for name, source := range all {
+		if secret := secrets[name]; secret != "" {
+			source.Secret = secret
+		}
+		if source.Secret == "" {
+			continue
+		}
+		switch source.Verify {
+		case "github", "stripe", "hmac-sha256", "token":
+		default:
+			return nil, fmt.Errorf("webhook source %q: unknown verify scheme %q", name, source.Verify)
+		}
+		if source.Service == "" || source.Name == "" {
+			return nil, fmt.Errorf("webhook source %q: service and name are required", name)
+		}
+		sources[name] = &source
+	}
+	return sources, nil
+}
+
+// VerifySignature checks the request signature against the source secret
+func (s *WebhookSource) VerifySignature(r *http.Request, body []byte, now time.Time) error {
+	switch s.Verify {
+	case "github":
+		sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || !validHMAC(s.Secret, body, sig) {
+			return ErrWebhookSignature
+		}
+	case "stripe":
+		return s.verifyStripe(r.Header.Get("Stripe-Signature"), body, now)
+	case "hmac-sha256":
+		header := s.SignatureHeader
+		if header == "" {
+			header = "X-Webhook-Signature"
+		}
+		sig := strings.TrimPrefix(r.Header.Get(header), "sha256=")
+		if !validHMAC(s.Secret, body, sig) {
+			return ErrWebhookSignature
+		}
+	case "token":
+		// For senders that can only call a URL (cron services, uptime pingers)
+		token := r.Header.Get("X-Webhook-Token")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if !hmac.Equal([]byte(token), []byte(s.Secret)) {
+			return ErrWebhookSignature
+		}
+	default:
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+// verifyStripe checks a Stripe-Signature header ("t=<unix>,v1=<hex>[,v1=...]")
+func (s *WebhookSource) verifyStripe(header string, body []byte, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrWebhookSignature)
+	}
+
+	signed := append([]byte(timestamp+"."), body...)
+	for _, sig := range signatures {
+		if validHMAC(s.Secret, signed, sig) {
+			return nil
+		}
+	}
+	return ErrWebhookSignature
+}
+
+func validHMAC(secret string, body []byte, hexSignature string) bool {
+	expected, err := hex.DecodeString(hexSignature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// MapWebhook renders the source's templates against a webhook payload to build an event
+// JSON and form-encoded (payload=...) bodies are supported; other bodies map with only headers and query
+func (s *WebhookSource) MapWebhook(sourceName string, r *http.Request, body []byte, now time.Time) (*structs.Event, error) {
+	payload := decodeWebhookPayload(r.Header.Get("Content-Type"), body)
+	ctx := webhookContext{payload: payload, header: r.Header, query: r.URL.Query()}
+
+	event := &structs.Event{
+		Timestamp: now,
+		Service:   ctx.render(s.Service),
+		Env:       ctx.render(s.Env),
+		Name:      strings.Trim(ctx.render(s.Name), "."),
+		Level:     "info",
+		Data: map[string]interface{}{
+			"webhook_source": sourceName,
+		},
+	}
+	if event.Name == "" {
+		event.Name = sourceName
+	}
+
+	level := ctx.render(s.Level)
+	if mapped, ok := s.Levels[level]; ok {
+		event.Level = mapped
+	} else if isAllowedLevel(level) {
+		event.Level = level
+	}
+
+	for key, tmpl := range s.Data {
+		if value := ctx.value(tmpl); value != nil && value != "" {
+			event.Data[key] = value
+		}
+	}
+
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func decodeWebhookPayload(contentType string, body []byte) interface{} {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil
+		}
+		if p := form.Get("payload"); p != "" {
+			body = []byte(p)
+		} else {
+			m := make(map[string]interface{}, len(form))
+			for k := range form {
+				m[k] = form.Get(k)
+			}
+			return m
+		}
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	return payload
+}
+
+type webhookContext struct {
+	payload interface{}
+	header  http.Header
+	query   url.Values
+}
+
+// value renders a template, keeping the JSON type when it is a single placeholder
+func (c webhookContext) value(tmpl string) interface{} {
+	if m := webhookPlaceholder.FindStringSubmatchIndex(tmpl); m != nil && m[0] == 0 && m[1] == len(tmpl) {
+		return c.lookup(tmpl[1 : len(tmpl)-1])
+	}
+	return c.render(tmpl)
+}
+
+func (c webhookContext) render(tmpl string) string {
+	return webhookPlaceholder.ReplaceAllStringFunc(tmpl, func(match string) string {
+		switch v := c.lookup(match[1 : len(match)-1]).(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(v)
+		default:
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+	})
+}
+
+func (c webhookContext) lookup(path string) interface{} {
+	if name, ok := strings.CutPrefix(path, "header."); ok {
+		if v := c.header.Get(name); v != "" {
+			return v
+		}
+		return nil
+	}
+	if name, ok := strings.CutPrefix(path, "query."); ok {
+		if v := c.query.Get(name); v != "" {
+			return v
+		}
+		return nil
+	}
+
+	current := c.payload
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			current = node[part]
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			current = node[i]
+		default:
+			return nil
+		}
+	}
+	return current
+}
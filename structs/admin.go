@@ -0,0 +1,87 @@
+package structs
+
+import "time"
+
+// DeleteQuery selects the events removed by a bulk delete
+type DeleteQuery struct {
+	Filters []QueryFilter `json:"filters,omitempty"`
+
+	// Time range (required)
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// DryRun only counts the matching events
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// RedactQuery selects the events whose data keys are masked by a redaction
+type RedactQuery struct {
+	Filters []QueryFilter `json:"filters,omitempty"`
+
+	// Time range (required)
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Keys are the top-level data keys to mask
+	Keys []string `json:"keys"`
+	// Mask replaces each key's value (default "[REDACTED]")
+	Mask string `json:"mask,omitempty"`
+
+	// DryRun only counts the matching events
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// MutationResult reports the events matched by a bulk delete or redaction and the mutations changing them
+type MutationResult struct {
+	Matched   uint64     `json:"matched"`
+	DryRun    bool       `json:"dry_run"`
+	Mutations []Mutation `json:"mutations,omitempty"`
+	// RollupsStale is set when rollups of the time range still count the events as they
+	// were; they are rolled up again once the mutations finish
+	RollupsStale bool `json:"rollups_stale,omitempty"`
+}
+
+// Mutation is the state of an ALTER TABLE mutation from system.mutations
+type Mutation struct {
+	Database      string    `json:"database"`
+	Table         string    `json:"table"`
+	MutationID    string    `json:"mutation_id"`
+	Command       string    `json:"command"`
+	CreatedAt     time.Time `json:"created_at"`
+	PartsToDo     int64     `json:"parts_to_do"`
+	IsDone        bool      `json:"is_done"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+}
+
+// ReplayQuery selects the archived events a replay re-inserts into the replay table
+type ReplayQuery struct {
+	Filters []QueryFilter `json:"filters,omitempty"`
+	// Services limits the replay to these services
+	Services []string `json:"services,omitempty"`
+
+	// Time range (required)
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Path is a glob of the Parquet objects to read under ARCHIVE_PREFIX (default **.parquet)
+	Path string `json:"path,omitempty"`
+
+	// DryRun only counts the matching archived events
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// ReplayJob is a replay of archived events, running in the background
+type ReplayJob struct {
+	ID     string      `json:"id"`
+	Query  ReplayQuery `json:"query"`
+	Table  string      `json:"table"`
+	Status string      `json:"status"`
+	// Matched is set by dry runs
+	Matched     uint64     `json:"matched,omitempty"`
+	RowsRead    uint64     `json:"rows_read"`
+	BytesRead   uint64     `json:"bytes_read"`
+	RowsWritten uint64     `json:"rows_written"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
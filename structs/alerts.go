@@ -0,0 +1,107 @@
+package structs
+
+import "time"
+
+// AlertRule fires when a time series breaches a threshold for a number of consecutive
+// buckets; with group_by each series (e.g. each service) is evaluated on its own
+type AlertRule struct {
+	Name        string          `json:"name,omitempty"`
+	Aggregation AggregationType `json:"aggregation,omitempty"`
+	Field       string          `json:"field,omitempty"`
+	Filters     []QueryFilter   `json:"filters,omitempty"`
+	GroupBy     []string        `json:"group_by,omitempty"`
+	// Interval is the evaluation step (default minute)
+	Interval IntervalType `json:"interval,omitempty"`
+
+	// Operator compares each bucket's value to Threshold: gt, gte, lt, or lte
+	Operator  string  `json:"operator,omitempty"`
+	Threshold float64 `json:"threshold"`
+	// For is how many consecutive breaching buckets it takes to fire (default 1)
+	For int `json:"for,omitempty"`
+
+	// Conditions make the rule composite, replacing Aggregation, Field, Operator, and
+	// Threshold: each is a sub-query sharing the rule's filters, group_by, and interval,
+	// and a bucket breaches when all of them (Match "all", the default) or any of them
+	// (Match "any") breach
+	Conditions []AlertCondition `json:"conditions,omitempty"`
+	Match      string           `json:"match,omitempty"`
+
+	// InhibitedBy suppresses the rule's alerts while another rule's alerts are firing
+	InhibitedBy []AlertInhibition `json:"inhibited_by,omitempty"`
+
+	// Labels are added to every alert of the rule (e.g. severity), next to alertname and
+	// the group_by values; Annotations are passed through to notifications
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Channels are the names of the ALERT_CHANNELS notified when alerts fire and resolve
+	Channels []string `json:"channels,omitempty"`
+	// Escalations notify more channels while an alert stays unresolved (e.g. page after
+	// 15m), in increasing After order
+	Escalations []AlertEscalation `json:"escalations,omitempty"`
+	// RepeatInterval re-sends firing alerts to the channels already notified (e.g. 4h);
+	// empty sends each alert once
+	RepeatInterval string `json:"repeat_interval,omitempty"`
+	// ResolveAfter is how long an alert has to stop breaching before it resolves (e.g.
+	// 10m), so a flapping series doesn't fire over and over; empty resolves right away
+	ResolveAfter string `json:"resolve_after,omitempty"`
+	// SendResolved is whether notified channels are told when alerts resolve (default true)
+	SendResolved *bool `json:"send_resolved,omitempty"`
+	// TopBy is a field whose top values while an alert fires (e.g. data.route) are added
+	// to its notifications
+	TopBy string `json:"top_by,omitempty"`
+}
+
+// AlertCondition is one sub-query of a composite rule
+type AlertCondition struct {
+	// Name labels the condition in alert summaries (default its aggregation)
+	Name        string          `json:"name,omitempty"`
+	Aggregation AggregationType `json:"aggregation"`
+	Field       string          `json:"field,omitempty"`
+	// Filters are added to the rule's filters
+	Filters   []QueryFilter `json:"filters,omitempty"`
+	Operator  string        `json:"operator"`
+	Threshold float64       `json:"threshold"`
+}
+
+// AlertEscalation notifies Channels once an alert has been firing for After (e.g. 15m)
+type AlertEscalation struct {
+	After    string   `json:"after"`
+	Channels []string `json:"channels"`
+}
+
+// AlertInhibition suppresses a rule's alerts while an alert of Rule is firing. With Equal,
+// only alerts with the same values for those labels are suppressed (e.g. equal ["env"]
+// keeps a cluster-down alert in staging from hiding production alerts).
+type AlertInhibition struct {
+	Rule  string   `json:"rule"`
+	Equal []string `json:"equal,omitempty"`
+}
+
+// AlertBacktestQuery evaluates a rule over a past time range
+type AlertBacktestQuery struct {
+	Rule AlertRule `json:"rule"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// AlertFiring is one period a rule would have fired for
+type AlertFiring struct {
+	Groups map[string]string `json:"groups,omitempty"`
+	// Start is the first breaching bucket, FiredAt the bucket the rule would have fired
+	// in, and End the end of the last breaching bucket
+	Start   time.Time `json:"start"`
+	FiredAt time.Time `json:"fired_at"`
+	End     time.Time `json:"end"`
+	// Peak is the value furthest past the threshold (of the first condition of composite rules)
+	Peak float64 `json:"peak"`
+}
+
+// AlertBacktestResult lists the periods a rule would have fired for, oldest first
+type AlertBacktestResult struct {
+	Firings int `json:"firings"`
+	// FiringTime is the total time spent firing, in seconds
+	FiringTime float64       `json:"firing_seconds"`
+	Series     int           `json:"series"`
+	Windows    []AlertFiring `json:"windows"`
+	Rule       *AlertRule    `json:"rule,omitempty"`
+}
@@ -16,6 +16,16 @@ const (
 	AggP90         AggregationType = "p90"
 	AggP95         AggregationType = "p95"
 	AggP99         AggregationType = "p99"
+	AggErrorRate   AggregationType = "error_rate"
+)
+
+// Precision is the data a time series was computed from
+type Precision string
+
+const (
+	PrecisionRaw    Precision = "raw"
+	PrecisionMinute Precision = "minute"
+	PrecisionHour   Precision = "hour"
 )
 
 // IntervalType defines time bucket intervals for time series
@@ -35,6 +45,10 @@ type AnalyticsQuery struct {
 	Aggregation AggregationType `json:"aggregation"`
 	Field       string          `json:"field,omitempty"` // Required for sum, avg, min, max, percentiles
 
+	// Columns are further aggregations computed for each row, each over the events
+	// matching its own filters, e.g. errors next to the request count
+	Columns []AggregationColumn `json:"columns,omitempty"`
+
 	// Grouping
 	GroupBy []string `json:"group_by,omitempty"` // e.g., ["service", "name", "data.status"]
 
@@ -48,9 +62,32 @@ type AnalyticsQuery struct {
 	// Ordering
 	OrderBy   string `json:"order_by,omitempty"` // "value" or a group_by field
 	OrderDesc bool   `json:"order_desc,omitempty"`
+	// Sort orders by several keys in turn, replacing order_by and order_desc
+	Sort []SortKey `json:"sort,omitempty"`
 
 	// Limits
 	Limit int `json:"limit,omitempty"`
+
+	// Paging through groups: Offset skips groups, or Cursor continues from a previous
+	// result's next_cursor
+	Offset int    `json:"offset,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// AggregationColumn is a named aggregation over the events of a row matching Filters (all
+// of them when empty)
+type AggregationColumn struct {
+	Name        string          `json:"name"`
+	Aggregation AggregationType `json:"aggregation"`
+	Field       string          `json:"field,omitempty"`
+	Filters     []QueryFilter   `json:"filters,omitempty"`
+}
+
+// SortKey is one key of an analytics ordering. By is "value", a group_by field, a column
+// name, or another aggregation such as "count" or "avg(data.duration_ms)".
+type SortKey struct {
+	By   string `json:"by"`
+	Desc bool   `json:"desc,omitempty"`
 }
 
 // TimeSeriesQuery represents a query for time series data
@@ -74,6 +111,19 @@ type TimeSeriesQuery struct {
 
 	// Fill empty buckets with zero
 	FillZeros bool `json:"fill_zeros,omitempty"`
+	// IncludeGroups are group_by values that get a series even when they have no data,
+	// e.g. [{"service": "api"}, {"service": "web"}]
+	IncludeGroups []map[string]string `json:"include_groups,omitempty"`
+
+	// CompareOffset adds each series shifted back by this offset (e.g. "7d") and
+	// re-aligned to the current timestamps
+	CompareOffset string `json:"compare_offset,omitempty"`
+	// Window turns count_unique into a rolling count over the trailing window (e.g. "7d")
+	// ending at each bucket, for DAU/WAU/MAU charts
+	Window string `json:"window,omitempty"`
+	// Spans limits the series to spans (events with data.span_id), which span metrics
+	// roll up apart from other events
+	Spans bool `json:"spans,omitempty"`
 }
 
 // QueryFilter represents a filter condition
@@ -85,27 +135,41 @@ type QueryFilter struct {
 
 // AnalyticsResult represents the result of an analytics query
 type AnalyticsResult struct {
-	Data  []AnalyticsRow  `json:"data"`
-	Total int             `json:"total"`
-	Query *AnalyticsQuery `json:"query,omitempty"`
+	Data  []AnalyticsRow `json:"data"`
+	Total int            `json:"total"`
+	// TotalGroups is the exact number of groups across all pages, for grouped queries
+	TotalGroups uint64 `json:"total_groups,omitempty"`
+	// NextCursor continues with the next page; it is empty on the last page
+	NextCursor string          `json:"next_cursor,omitempty"`
+	Query      *AnalyticsQuery `json:"query,omitempty"`
 }
 
 // AnalyticsRow represents a single row in analytics results
 type AnalyticsRow struct {
-	Value  float64           `json:"value"`
-	Groups map[string]string `json:"groups,omitempty"`
+	Value   float64            `json:"value"`
+	Columns map[string]float64 `json:"columns,omitempty"`
+	Groups  map[string]string  `json:"groups,omitempty"`
 }
 
 // TimeSeriesResult represents the result of a time series query
 type TimeSeriesResult struct {
 	Series []TimeSeries     `json:"series"`
 	Query  *TimeSeriesQuery `json:"query,omitempty"`
+	// Precision is the coarsest data the series was computed from: raw events, or minute
+	// or hour rollups
+	Precision Precision `json:"precision"`
+	// RollupBefore is set when buckets before it were read from rollups, and buckets
+	// after it from raw events
+	RollupBefore *time.Time `json:"rollup_before,omitempty"`
+	// Annotations are the incidents overlapping the series
+	Annotations []Annotation `json:"annotations,omitempty"`
 }
 
 // TimeSeries represents a single time series
 type TimeSeries struct {
 	Name       string            `json:"name,omitempty"`
 	Groups     map[string]string `json:"groups,omitempty"`
+	Offset     string            `json:"offset,omitempty"` // set on compare_offset series
 	DataPoints []DataPoint       `json:"data_points"`
 }
 
@@ -149,17 +213,42 @@ type TopNRow struct {
 
 // GaugeQuery represents a query for a single gauge value
 type GaugeQuery struct {
+	// Name identifies the gauge in a batch
+	Name        string          `json:"name,omitempty"`
 	Aggregation AggregationType `json:"aggregation"`
 	Field       string          `json:"field,omitempty"`
 	Filters     []QueryFilter   `json:"filters,omitempty"`
 	From        time.Time       `json:"from"`
 	To          time.Time       `json:"to"`
+
+	// Sparkline adds the last N buckets of SparklineInterval (default hour) ending at To
+	Sparkline         int          `json:"sparkline,omitempty"`
+	SparklineInterval IntervalType `json:"sparkline_interval,omitempty"`
 }
 
 // GaugeResult represents the result of a gauge query
 type GaugeResult struct {
-	Value float64     `json:"value"`
-	Query *GaugeQuery `json:"query,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Value     float64     `json:"value"`
+	Sparkline []DataPoint `json:"sparkline,omitempty"`
+	Query     *GaugeQuery `json:"query,omitempty"`
+}
+
+// GaugeBatchQuery runs several gauges in one request, e.g. a dashboard's KPI row. The
+// time range, filters, and sparkline settings apply to every gauge: a gauge's own range
+// and sparkline settings take precedence, and its filters are added to the shared ones.
+type GaugeBatchQuery struct {
+	Gauges            []GaugeQuery  `json:"gauges"`
+	Filters           []QueryFilter `json:"filters,omitempty"`
+	From              time.Time     `json:"from"`
+	To                time.Time     `json:"to"`
+	Sparkline         int           `json:"sparkline,omitempty"`
+	SparklineInterval IntervalType  `json:"sparkline_interval,omitempty"`
+}
+
+// GaugeBatchResult holds the results of a GaugeBatchQuery in request order
+type GaugeBatchResult struct {
+	Gauges []GaugeResult `json:"gauges"`
 }
 
 // CompareQuery represents a query comparing two time periods
@@ -185,3 +274,89 @@ type CompareResult struct {
 	ChangePercent float64       `json:"change_percent"` // Percentage change
 	Query         *CompareQuery `json:"query,omitempty"`
 }
+
+// FilterCompareQuery runs the same aggregation over two filter sets (an A/B comparison)
+type FilterCompareQuery struct {
+	Aggregation AggregationType `json:"aggregation"`
+	Field       string          `json:"field,omitempty"`
+
+	// Filters shared by both sides
+	Filters []QueryFilter `json:"filters,omitempty"`
+
+	// The two sides, each adding its own filters
+	A FilterSet `json:"a"`
+	B FilterSet `json:"b"`
+
+	// Time range
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Interval adds aligned series when set
+	Interval IntervalType `json:"interval,omitempty"`
+}
+
+// FilterSet is one side of a FilterCompareQuery
+type FilterSet struct {
+	Name    string        `json:"name,omitempty"`
+	Filters []QueryFilter `json:"filters"`
+}
+
+// FilterCompareResult holds the totals of both sides and, with an interval, the series
+// "a", "b", "difference" (b - a), and "ratio" (b / a, 0 where a is 0)
+type FilterCompareResult struct {
+	A          float64             `json:"a"`
+	B          float64             `json:"b"`
+	Difference float64             `json:"difference"`
+	Ratio      *float64            `json:"ratio"` // null when a is 0
+	Series     []TimeSeries        `json:"series,omitempty"`
+	Query      *FilterCompareQuery `json:"query,omitempty"`
+}
+
+// RatioQuery divides an aggregation over the events matching the numerator filters by the
+// same aggregation over the denominator filters, like errors / requests
+type RatioQuery struct {
+	Aggregation AggregationType `json:"aggregation"` // count or sum
+	Field       string          `json:"field,omitempty"`
+
+	// Numerator and Denominator select their events among those matching Filters; an
+	// empty Denominator counts all of them
+	Numerator   []QueryFilter `json:"numerator"`
+	Denominator []QueryFilter `json:"denominator,omitempty"`
+	Filters     []QueryFilter `json:"filters,omitempty"`
+
+	// Time range
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// GroupBy breaks the ratio down by group, or Interval into a series
+	GroupBy  []string     `json:"group_by,omitempty"`
+	Interval IntervalType `json:"interval,omitempty"`
+	Limit    int          `json:"limit,omitempty"` // groups returned (default 100)
+}
+
+// RatioValue is a numerator, a denominator, and their ratio (null when the denominator is 0)
+type RatioValue struct {
+	Numerator   float64  `json:"numerator"`
+	Denominator float64  `json:"denominator"`
+	Ratio       *float64 `json:"ratio"`
+}
+
+// RatioGroup is the ratio of one group
+type RatioGroup struct {
+	RatioValue
+	Groups map[string]string `json:"groups"`
+}
+
+// RatioPoint is the ratio of one time bucket
+type RatioPoint struct {
+	RatioValue
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RatioResult holds the overall ratio and its breakdown by group or time
+type RatioResult struct {
+	RatioValue
+	Groups []RatioGroup `json:"groups,omitempty"`
+	Series []RatioPoint `json:"series,omitempty"`
+	Query  *RatioQuery  `json:"query,omitempty"`
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -22,6 +23,15 @@ type Event struct {
 	Name      string                 `json:"name"`
 	Level     string                 `json:"level"`
 	Data      map[string]interface{} `json:"data"`
+	// Labels are the values of the deployment's extra label columns (LABEL_COLUMNS); other
+	// keys are dropped when the event is written
+	Labels map[string]string `json:"labels,omitempty"`
+	// Tenant is set by the server from the access role of the ingesting key, and selects
+	// the tenant's table (TENANT_TABLES)
+	Tenant string `json:"-"`
+
+	// ReceivedAt is set by the server when the event is ingested
+	ReceivedAt time.Time `json:"received_at"`
 }
 
 // Validate checks that all required fields are present and IDs are valid UUIDs
@@ -47,6 +57,23 @@ func (e *Event) Validate() error {
 	return nil
 }
 
+// IsValidID reports whether s is a valid UUID for the job, request, and trace ID fields
+func IsValidID(s string) bool {
+	return uuidRegex.MatchString(s)
+}
+
+// NormalizeID converts 32-character hex IDs (as used by Sentry and OpenTelemetry)
+// into hyphenated UUID form; other values are returned unchanged
+func NormalizeID(s string) string {
+	if len(s) != 32 || strings.ContainsFunc(s, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F')
+	}) {
+		return s
+	}
+	s = strings.ToLower(s)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
 // DataJSON returns the data field as a JSON string
 func (e *Event) DataJSON() string {
 	if e.Data == nil {
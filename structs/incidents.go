@@ -0,0 +1,52 @@
+package structs
+
+import "time"
+
+// Incident groups the alerts, affected services, and time range of an outage, with notes
+// on how it was resolved
+type Incident struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Status   string `json:"status"` // open or resolved
+	Severity string `json:"severity,omitempty"`
+	// Services are the affected services; charts of other services aren't annotated
+	Services []string        `json:"services,omitempty"`
+	Alerts   []IncidentAlert `json:"alerts,omitempty"`
+
+	StartedAt  time.Time  `json:"started_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Resolution string     `json:"resolution,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IncidentAlert is an alert attached to an incident, from its alert.firing event
+type IncidentAlert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Alertname   string            `json:"alertname"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	StartsAt    time.Time         `json:"starts_at"`
+}
+
+// IncidentRequest creates or updates an incident. Alerts are fingerprints of firing alerts
+// (from alert.firing events); on creation they default the title, services, and start.
+type IncidentRequest struct {
+	Title      string     `json:"title"`
+	Severity   string     `json:"severity"`
+	Services   []string   `json:"services"`
+	Alerts     []string   `json:"alerts"`
+	StartedAt  *time.Time `json:"started_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Resolution string     `json:"resolution"`
+}
+
+// Annotation marks a time range on charts, in the shape of Grafana annotations
+type Annotation struct {
+	Time       time.Time  `json:"time"`
+	TimeEnd    *time.Time `json:"time_end,omitempty"`
+	Title      string     `json:"title"`
+	Text       string     `json:"text,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	IncidentID string     `json:"incident_id,omitempty"`
+}
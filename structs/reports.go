@@ -0,0 +1,13 @@
+package structs
+
+import "time"
+
+// DigestQuery summarizes a time range against the period of the same length before it,
+// for scheduled email reports
+type DigestQuery struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Filters []QueryFilter `json:"filters,omitempty"`
+	// Limit is how many services, new error groups, and regressions are listed (default 10)
+	Limit int `json:"limit,omitempty"`
+}
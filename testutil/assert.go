@@ -0,0 +1,84 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/services"
+)
+
+// AssertEventCount runs params through svc.QueryEvents and checks the total matched
+func AssertEventCount(t testing.TB, svc *services.Service, params services.QueryParams, want int) *services.QueryResult {
+	t.Helper()
+
+	result, err := svc.QueryEvents(context.Background(), params)
+	if err != nil {
+		t.Fatalf("QueryEvents failed: %v", err)
+	}
+	if result.Total != want {
+		t.Errorf("QueryEvents matched %d events, want %d", result.Total, want)
+	}
+	return result
+}
+
+// AssertCount checks the number of rows in the events table matching where, a SQL
+// condition with ? placeholders (an empty where counts every row)
+func AssertCount(t testing.TB, store db.Store, want uint64, where string, args ...interface{}) {
+	t.Helper()
+
+	sql := "SELECT count() FROM " + db.ReadTable()
+	if where != "" {
+		sql += " WHERE " + where
+	}
+	var got uint64
+	if err := store.QueryRow(context.Background(), sql, args...).Scan(&got); err != nil {
+		t.Fatalf("count query failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("%s: got %d rows, want %d", sql, got, want)
+	}
+}
+
+// AssertRows runs sql and compares every row, scanned as strings, with want. Cast
+// non-string columns in the query, e.g. toString(count()).
+func AssertRows(t testing.TB, store db.Store, want [][]string, sql string, args ...interface{}) {
+	t.Helper()
+
+	rows, err := store.Query(context.Background(), sql, args...)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var got [][]string
+	for rows.Next() {
+		row := make([]string, len(rows.Columns()))
+		dest := make([]interface{}, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		got = append(got, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s:\n got  %s\n want %s", sql, formatRows(got), formatRows(want))
+	}
+}
+
+func formatRows(rows [][]string) string {
+	parts := make([]string, len(rows))
+	for i, row := range rows {
+		parts[i] = fmt.Sprintf("%q", row)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,159 @@
+// Package testutil is the harness for integration tests against a real ClickHouse. Point
+// MONITOR_TEST_CLICKHOUSE_ADDR at a server (dev up starts one on localhost:9000), or
+// leave it unset and one is started in Docker with testcontainers; tests using ClickHouse
+// are skipped when neither is available.
+//
+//	func TestQueryEventsByService(t *testing.T) {
+//		store := testutil.ClickHouse(t)
+//		testutil.Seed(t, store,
+//			testutil.Event("api", "request", map[string]interface{}{"status": 200}),
+//			testutil.Event("worker", "job.done", nil),
+//		)
+//		testutil.AssertEventCount(t, services.New(store), services.QueryParams{
+//			Filters: []services.Filter{{Field: "service", Operator: services.OpEq, Value: "api"}},
+//		}, 1)
+//	}
+//
+// ClickHouse points the db package at the test database, so tests using it must not run
+// in parallel. Tests of generated SQL need no server: they give services.New a Recorder.
+package testutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/migrations"
+	"github.com/testcontainers/testcontainers-go"
+	tcclickhouse "github.com/testcontainers/testcontainers-go/modules/clickhouse"
+)
+
+// Environment variables locating the test server
+const (
+	AddrEnv     = "MONITOR_TEST_CLICKHOUSE_ADDR"
+	UsernameEnv = "MONITOR_TEST_CLICKHOUSE_USERNAME"
+	PasswordEnv = "MONITOR_TEST_CLICKHOUSE_PASSWORD"
+)
+
+const (
+	// setupTimeout bounds connecting and migrating the test database
+	setupTimeout = time.Minute
+	// containerTimeout bounds pulling and starting the ClickHouse container
+	containerTimeout = 5 * time.Minute
+	// containerImage matches the server dev up runs
+	containerImage    = "clickhouse/clickhouse-server:24.3-alpine"
+	containerUser     = "monitor"
+	containerPassword = "monitor"
+)
+
+// The container is started once per test binary, for every test needing it, and removed
+// by testcontainers' reaper when the binary exits
+var (
+	containerOnce sync.Once
+	containerAddr string
+	containerErr  error
+	// dockerMissing is set when Docker isn't available, so the tests are skipped
+	dockerMissing bool
+)
+
+// ClickHouse creates a database with every migration applied and points the db package
+// at it until the test ends, when it is dropped. The server is the one at
+// MONITOR_TEST_CLICKHOUSE_ADDR, or else a container; the test is skipped when that is unset
+// and Docker isn't available.
+func ClickHouse(t testing.TB) db.Store {
+	t.Helper()
+
+	addr, username, password := os.Getenv(AddrEnv), os.Getenv(UsernameEnv), os.Getenv(PasswordEnv)
+	if addr == "" {
+		containerOnce.Do(startContainer)
+		if dockerMissing {
+			t.Skipf("%s is not set and Docker is not available: %v", AddrEnv, containerErr)
+		}
+		if containerErr != nil {
+			t.Fatalf("testutil: failed to start ClickHouse: %v", containerErr)
+		}
+		addr, username, password = containerAddr, containerUser, containerPassword
+	}
+	if username == "" {
+		username = "default"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+
+	// The test database doesn't exist yet, so connect to the default one
+	previousDatabase, previousRoutes, previousReplay := db.Database, db.Routes, db.ReplayTable
+	store, err := db.Connect(ctx, addr, "default", username, password)
+	if err != nil {
+		t.Fatalf("testutil: failed to connect to ClickHouse at %s: %v", addr, err)
+	}
+
+	db.Database = databaseName()
+	if err := db.ConfigureStorage(db.RetentionDays, nil); err != nil {
+		t.Fatalf("testutil: %v", err)
+	}
+	db.ReplayTable = nil
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+		defer cancel()
+		if err := store.Exec(ctx, "DROP DATABASE IF EXISTS "+db.Database); err != nil {
+			t.Logf("testutil: failed to drop %s: %v", db.Database, err)
+		}
+		store.Close()
+		db.Database, db.Routes, db.ReplayTable = previousDatabase, previousRoutes, previousReplay
+	})
+
+	if err := db.Migrate(ctx, store, migrations.FS); err != nil {
+		t.Fatalf("testutil: failed to migrate %s: %v", db.Database, err)
+	}
+	return store
+}
+
+// databaseName returns a unique name, so concurrent test binaries don't share a database
+func databaseName() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("monitor_test_%d_%s", time.Now().Unix(), hex.EncodeToString(b))
+}
+
+// startContainer starts the ClickHouse container, or records that Docker isn't available
+func startContainer() {
+	ctx, cancel := context.WithTimeout(context.Background(), containerTimeout)
+	defer cancel()
+
+	if err := dockerHealth(ctx); err != nil {
+		dockerMissing, containerErr = true, err
+		return
+	}
+	container, err := tcclickhouse.Run(ctx, containerImage,
+		tcclickhouse.WithUsername(containerUser),
+		tcclickhouse.WithPassword(containerPassword),
+	)
+	if err != nil {
+		containerErr = err
+		return
+	}
+	containerAddr, containerErr = container.ConnectionHost(ctx)
+}
+
+// dockerHealth checks that testcontainers can reach a Docker daemon; finding none can panic
+func dockerHealth(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+	return provider.Health(ctx)
+}
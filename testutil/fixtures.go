@@ -0,0 +1,63 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aidenappl/monitor-core/db"
+	"github.com/aidenappl/monitor-core/structs"
+)
+
+// Event returns an info event of service in env test named name, timestamped a minute ago
+func Event(service, name string, data map[string]interface{}) *structs.Event {
+	return &structs.Event{
+		Timestamp: time.Now().UTC().Add(-time.Minute).Truncate(time.Millisecond),
+		Service:   service,
+		Env:       "test",
+		Name:      name,
+		Level:     "info",
+		Data:      data,
+	}
+}
+
+// Series returns n copies of template spaced step apart from start, with each event
+// passed to vary (when set) to change its fields, e.g. to spread levels or latencies
+func Series(template structs.Event, n int, start time.Time, step time.Duration, vary func(i int, event *structs.Event)) []*structs.Event {
+	events := make([]*structs.Event, n)
+	for i := range events {
+		event := template
+		event.Timestamp = start.Add(time.Duration(i) * step)
+		if template.Data != nil {
+			event.Data = make(map[string]interface{}, len(template.Data))
+			for k, v := range template.Data {
+				event.Data[k] = v
+			}
+		}
+		if vary != nil {
+			vary(i, &event)
+		}
+		events[i] = &event
+	}
+	return events
+}
+
+// Seed writes events synchronously through db.Writer, as the batcher would. Missing
+// levels default to info and missing receive times to the timestamp.
+func Seed(t testing.TB, store db.Store, events ...*structs.Event) {
+	t.Helper()
+
+	for _, event := range events {
+		if event.Level == "" {
+			event.Level = "info"
+		}
+		if event.ReceivedAt.IsZero() {
+			event.ReceivedAt = event.Timestamp
+		}
+	}
+
+	writer := &db.Writer{Store: store}
+	if err := writer.WriteBatch(context.Background(), events); err != nil {
+		t.Fatalf("testutil: failed to seed %d events: %v", len(events), err)
+	}
+}
@@ -0,0 +1,111 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// ErrRecorded is returned by a Recorder for statements that would have returned rows
+var ErrRecorded = errors.New("testutil: statement recorded, not executed")
+
+// Statement is a statement sent to a Recorder
+type Statement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// Recorder is a db.Store that records statements instead of running them, so the SQL a
+// query builder generates can be checked without ClickHouse. Queries fail with ErrRecorded
+// and execs succeed. Each test gets its own, so recording tests can run in parallel.
+//
+//	rec := testutil.NewRecorder()
+//	services.New(rec).QueryEvents(ctx, params)
+//	rec.AssertQueried(t, "WHERE service = @f")
+type Recorder struct {
+	mu         sync.Mutex
+	statements []Statement
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) record(sql string, args []interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = append(r.statements, Statement{SQL: sql, Args: args})
+}
+
+// Statements returns the statements recorded so far
+func (r *Recorder) Statements() []Statement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Statement(nil), r.statements...)
+}
+
+// AssertQueried checks that a recorded statement contains each fragment, ignoring
+// differences in whitespace, and returns it
+func (r *Recorder) AssertQueried(t testing.TB, fragments ...string) Statement {
+	t.Helper()
+
+	for _, statement := range r.Statements() {
+		sql := strings.Join(strings.Fields(statement.SQL), " ")
+		matched := true
+		for _, fragment := range fragments {
+			if !strings.Contains(sql, strings.Join(strings.Fields(fragment), " ")) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return statement
+		}
+	}
+	t.Errorf("no statement contains %q; recorded:\n%s", fragments, r.dump())
+	return Statement{}
+}
+
+func (r *Recorder) dump() string {
+	var b strings.Builder
+	for _, statement := range r.Statements() {
+		b.WriteString("  " + strings.Join(strings.Fields(statement.SQL), " ") + "\n")
+	}
+	return b.String()
+}
+
+func (r *Recorder) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	r.record(query, args)
+	return nil, ErrRecorded
+}
+
+func (r *Recorder) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	r.record(query, args)
+	return recordedRow{}
+}
+
+func (r *Recorder) Exec(ctx context.Context, query string, args ...any) error {
+	r.record(query, args)
+	return nil
+}
+
+func (r *Recorder) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	r.record(query, nil)
+	return nil, ErrRecorded
+}
+
+func (r *Recorder) Ping(ctx context.Context) error { return nil }
+
+func (r *Recorder) Close() error { return nil }
+
+// recordedRow is the row of a recorded single-row query
+type recordedRow struct{}
+
+func (recordedRow) Err() error                { return ErrRecorded }
+func (recordedRow) Scan(dest ...any) error    { return ErrRecorded }
+func (recordedRow) ScanStruct(dest any) error { return ErrRecorded }
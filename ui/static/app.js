@@ -0,0 +1,405 @@
+"use strict";
+
+// Relative time ranges offered by every form, in milliseconds
+const RANGES = [
+  ["Last 15 minutes", 15 * 60e3],
+  ["Last hour", 60 * 60e3],
+  ["Last 6 hours", 6 * 60 * 60e3],
+  ["Last 24 hours", 24 * 60 * 60e3],
+  ["Last 7 days", 7 * 24 * 60 * 60e3],
+  ["Last 30 days", 30 * 24 * 60 * 60e3],
+];
+
+const PAGE_SIZE = 50;
+const COLORS = ["#0969da", "#cf222e", "#1a7f37", "#8250df", "#bf8700", "#0550ae", "#bc4c00", "#57606a"];
+
+const $ = (sel) => document.querySelector(sel);
+
+function el(tag, props = {}, ...children) {
+  const node = document.createElement(tag);
+  Object.assign(node, props);
+  node.append(...children);
+  return node;
+}
+
+// api calls the JSON API with the session cookie, or the API key entered in the header
+async function api(path, options = {}) {
+  const headers = { ...(options.headers || {}) };
+  const key = localStorage.getItem("apiKey");
+  if (key) headers["X-Api-Key"] = key;
+  if (options.body) headers["Content-Type"] = "application/json";
+
+  const res = await fetch(path, { ...options, headers, credentials: "same-origin" });
+  let body = null;
+  try {
+    body = await res.json();
+  } catch {
+    // Plain-text errors such as 401 Unauthorized
+  }
+  if (!res.ok || (body && body.success === false)) {
+    throw new Error((body && body.message) || `${res.status} ${res.statusText}`);
+  }
+  return body;
+}
+
+function setStatus(id, message, isError = false) {
+  const node = $(id);
+  node.textContent = message;
+  node.classList.toggle("error", isError);
+}
+
+function timeRange(form) {
+  // Absolute ranges come from links, as "from/to"
+  if (form.range.value.includes("/")) {
+    const [from, to] = form.range.value.split("/");
+    return { from, to };
+  }
+  const to = new Date();
+  const from = new Date(to.getTime() - Number(form.range.value));
+  return { from: from.toISOString(), to: to.toISOString() };
+}
+
+// parseFilters reads "field=value, field__op=value" into [field, operator, value] triples
+function parseFilters(text) {
+  return text
+    .split(",")
+    .map((part) => part.trim())
+    .filter(Boolean)
+    .map((part) => {
+      const eq = part.indexOf("=");
+      if (eq < 0) throw new Error(`invalid filter: ${part}`);
+      const key = part.slice(0, eq).trim();
+      const value = part.slice(eq + 1).trim();
+      const [field, operator = "eq"] = key.split("__");
+      return [field, operator, value];
+    });
+}
+
+// Tabs
+
+function showTab(name) {
+  document.querySelectorAll(".tab").forEach((tab) => (tab.hidden = tab.id !== `tab-${name}`));
+  document.querySelectorAll("nav a").forEach((a) => a.classList.toggle("active", a.dataset.tab === name));
+  if (name === "queries") loadQueries();
+}
+
+// Auth
+
+async function initAuth() {
+  const keyInput = $("#api-key");
+  keyInput.value = localStorage.getItem("apiKey") || "";
+  keyInput.addEventListener("change", () => {
+    localStorage.setItem("apiKey", keyInput.value.trim());
+    loadServices();
+  });
+
+  try {
+    const me = await fetch("/auth/me", { credentials: "same-origin" });
+    if (!me.ok) return;
+    const session = (await me.json()).data;
+    $("#user").textContent = `${session.email || session.name} (${session.role})`;
+    keyInput.hidden = true;
+    $("#logout").hidden = false;
+    $("#logout").addEventListener("click", async () => {
+      await fetch("/auth/logout", { method: "POST", credentials: "same-origin" });
+      location.reload();
+    });
+  } catch {
+    // No OIDC login on this listener; the API key is used instead
+  }
+}
+
+async function loadServices() {
+  try {
+    const res = await api("/v1/labels/service/values");
+    $("#services").replaceChildren(...res.data.map((s) => el("option", { value: s })));
+  } catch {
+    // Autocomplete is optional
+  }
+}
+
+// Event explorer
+
+let eventsOffset = 0;
+
+async function searchEvents(offset = 0) {
+  const form = $("#events-form");
+  const params = new URLSearchParams(timeRange(form));
+  for (const name of ["service", "env", "level", "name"]) {
+    if (form[name].value) params.set(name, form[name].value);
+  }
+  try {
+    // A JSON array (as in alert links) is passed through as analytics filters
+    if (form.filters.value.trim().startsWith("[")) {
+      params.set("filters", form.filters.value.trim());
+    } else {
+      for (const [field, operator, value] of parseFilters(form.filters.value)) {
+        params.set(operator === "eq" ? field : `${field}__${operator}`, value);
+      }
+    }
+  } catch (err) {
+    setStatus("#events-status", err.message, true);
+    return;
+  }
+  params.set("limit", PAGE_SIZE);
+  params.set("offset", offset);
+
+  setStatus("#events-status", "Loading…");
+  try {
+    const res = await api(`/v1/events?${params}`);
+    const total = (res.pagination && res.pagination.count) || 0;
+    eventsOffset = offset;
+    renderEvents(res.data);
+    setStatus("#events-status", total ? `${offset + 1}–${offset + res.data.length} of ${total} events` : "No events");
+    $("#events-prev").disabled = offset === 0;
+    $("#events-next").disabled = offset + PAGE_SIZE >= total;
+  } catch (err) {
+    setStatus("#events-status", err.message, true);
+  }
+}
+
+function renderEvents(events) {
+  const rows = events.map((e) =>
+    el(
+      "tr",
+      { className: `level-${e.level}` },
+      el("td", { className: "time", textContent: new Date(e.timestamp).toLocaleString() }),
+      el("td", { textContent: e.service }),
+      el("td", { textContent: e.env }),
+      el("td", { textContent: e.level }),
+      el("td", { textContent: e.name }),
+      el("td", { className: "data", textContent: e.data ? JSON.stringify(e.data) : "" }),
+    ),
+  );
+  $("#events-table tbody").replaceChildren(...rows);
+}
+
+// Time series chart builder
+
+function chartQuery() {
+  const form = $("#chart-form");
+  const query = {
+    aggregation: form.aggregation.value,
+    interval: form.interval.value,
+    fill_zeros: true,
+    filters: parseFilters(form.filters.value).map(([field, operator, value]) => ({ field, operator, value })),
+  };
+  if (form.field.value.trim()) query.field = form.field.value.trim();
+  const groupBy = form.group_by.value.split(",").map((g) => g.trim()).filter(Boolean);
+  if (groupBy.length) query.group_by = groupBy;
+  return query;
+}
+
+async function runChart() {
+  let query;
+  try {
+    query = { ...chartQuery(), ...timeRange($("#chart-form")) };
+  } catch (err) {
+    setStatus("#chart-status", err.message, true);
+    return;
+  }
+
+  setStatus("#chart-status", "Loading…");
+  try {
+    const res = await api("/v1/timeseries", { method: "POST", body: JSON.stringify(query) });
+    drawChart(res.data.series, res.data.annotations);
+    setStatus("#chart-status", `${res.data.series.length} series`);
+  } catch (err) {
+    setStatus("#chart-status", err.message, true);
+  }
+}
+
+function drawChart(series, annotations = []) {
+  const svg = $("#chart");
+  const width = 900, height = 320, pad = 40;
+  const ns = "http://www.w3.org/2000/svg";
+  const node = (tag, attrs, text) => {
+    const n = document.createElementNS(ns, tag);
+    for (const [k, v] of Object.entries(attrs)) n.setAttribute(k, v);
+    if (text !== undefined) n.textContent = text;
+    return n;
+  };
+
+  const points = series.flatMap((s) => s.data_points);
+  if (!points.length) {
+    svg.replaceChildren(node("text", { x: width / 2, y: height / 2, "text-anchor": "middle" }, "No data"));
+    $("#legend").replaceChildren();
+    return;
+  }
+  const times = points.map((p) => new Date(p.timestamp).getTime());
+  const minT = Math.min(...times), maxT = Math.max(...times);
+  const maxV = Math.max(...points.map((p) => p.value), 0) || 1;
+  const x = (t) => pad + ((t - minT) / (maxT - minT || 1)) * (width - 2 * pad);
+  const y = (v) => height - pad - (v / maxV) * (height - 2 * pad);
+
+  const children = [
+    node("line", { class: "axis", x1: pad, y1: height - pad, x2: width - pad, y2: height - pad }),
+    node("line", { class: "axis", x1: pad, y1: pad, x2: pad, y2: height - pad }),
+    node("text", { x: pad - 4, y: pad, "text-anchor": "end" }, formatValue(maxV)),
+    node("text", { x: pad - 4, y: height - pad, "text-anchor": "end" }, "0"),
+    node("text", { x: pad, y: height - pad + 16 }, new Date(minT).toLocaleString()),
+    node("text", { x: width - pad, y: height - pad + 16, "text-anchor": "end" }, new Date(maxT).toLocaleString()),
+  ];
+  // Incident windows, clipped to the chart; open incidents run to its end
+  for (const a of annotations) {
+    const start = Math.max(new Date(a.time).getTime(), minT);
+    const end = Math.min(a.time_end ? new Date(a.time_end).getTime() : maxT, maxT);
+    if (end < start) continue;
+    const band = node("rect", { class: "incident", x: x(start), y: pad, width: Math.max(x(end) - x(start), 2), height: height - 2 * pad });
+    band.appendChild(node("title", {}, a.title));
+    children.push(band);
+  }
+  series.forEach((s, i) => {
+    const coords = s.data_points.map((p) => `${x(new Date(p.timestamp).getTime())},${y(p.value)}`).join(" ");
+    children.push(node("polyline", { points: coords, stroke: COLORS[i % COLORS.length] }));
+  });
+  svg.replaceChildren(...children);
+
+  $("#legend").replaceChildren(
+    ...series.map((s, i) => {
+      const swatch = el("span");
+      swatch.style.background = COLORS[i % COLORS.length];
+      return el("li", {}, swatch, seriesName(s));
+    }),
+  );
+}
+
+function seriesName(s) {
+  if (s.name) return s.name;
+  if (s.groups) return Object.entries(s.groups).map(([k, v]) => `${k}=${v}`).join(", ");
+  return "total";
+}
+
+function formatValue(v) {
+  return Math.abs(v) >= 1000 ? v.toExponential(2) : String(Math.round(v * 100) / 100);
+}
+
+// saveChart stores the chart as a saved query whose time range is a pair of variables
+async function saveChart() {
+  const name = prompt("Saved query name (letters, digits, and underscores)");
+  if (!name) return;
+  try {
+    const query = { ...chartQuery(), from: "{{from}}", to: "{{to}}" };
+    const saved = {
+      type: "timeseries",
+      query,
+      variables: [
+        { name: "from", type: "time", required: true },
+        { name: "to", type: "time", required: true },
+      ],
+    };
+    await api(`/v1/queries/${encodeURIComponent(name)}`, { method: "PUT", body: JSON.stringify(saved) });
+    setStatus("#chart-status", `Saved as ${name}`);
+  } catch (err) {
+    setStatus("#chart-status", err.message, true);
+  }
+}
+
+// Saved queries
+
+async function loadQueries() {
+  setStatus("#queries-status", "Loading…");
+  try {
+    const res = await api("/v1/queries");
+    const rows = res.data.map((q) =>
+      el(
+        "tr",
+        {},
+        el("td", { textContent: q.name }),
+        el("td", { textContent: q.type }),
+        el("td", { textContent: q.variables.map((v) => v.name).join(", ") }),
+        el("td", {}, el("button", { textContent: "Run", onclick: () => prepareRun(q) })),
+      ),
+    );
+    $("#queries-table tbody").replaceChildren(...rows);
+    setStatus("#queries-status", rows.length ? "" : "No saved queries");
+  } catch (err) {
+    setStatus("#queries-status", err.message, true);
+  }
+}
+
+function prepareRun(q) {
+  const form = $("#run-form");
+  form.hidden = false;
+  form.dataset.name = q.name;
+  $("#run-name").textContent = q.name;
+
+  const now = new Date();
+  const inputs = q.variables.map((v) => {
+    let value = v.default === undefined ? "" : Array.isArray(v.default) ? v.default.join(",") : String(v.default);
+    if (v.type === "time" && !value) {
+      value = v.name === "from" ? new Date(now.getTime() - 24 * 60 * 60e3).toISOString() : now.toISOString();
+    }
+    return el("input", { name: v.name, placeholder: `${v.name} (${v.type || "string"})`, value, title: v.name });
+  });
+  $("#run-vars").replaceChildren(...inputs);
+  $("#run-result").textContent = "";
+}
+
+async function runSaved() {
+  const form = $("#run-form");
+  const params = new URLSearchParams();
+  form.querySelectorAll("#run-vars input").forEach((input) => {
+    if (input.value) params.set(input.name, input.value);
+  });
+  try {
+    const res = await api(`/v1/queries/${encodeURIComponent(form.dataset.name)}/run?${params}`);
+    $("#run-result").textContent = JSON.stringify(res.data, null, 2);
+  } catch (err) {
+    $("#run-result").textContent = err.message;
+  }
+}
+
+// applyLink fills the explorer from the query string of a link, such as an alert's
+// generatorURL: service, env, level, name, filters, and an absolute from and to
+function applyLink() {
+  const params = new URLSearchParams(location.search);
+  const form = $("#events-form");
+  for (const name of ["service", "env", "level", "name", "filters"]) {
+    if (params.get(name)) form[name].value = params.get(name);
+  }
+  const from = params.get("from");
+  const to = params.get("to");
+  if (from && to) {
+    const label = `${new Date(from).toLocaleString()} – ${new Date(to).toLocaleString()}`;
+    form.range.append(el("option", { value: `${from}/${to}`, textContent: label }));
+    form.range.value = `${from}/${to}`;
+  }
+}
+
+// Wiring
+
+document.addEventListener("DOMContentLoaded", () => {
+  document.querySelectorAll("select.range").forEach((select) => {
+    select.replaceChildren(...RANGES.map(([label, ms]) => el("option", { value: ms, textContent: label })));
+    select.value = String(RANGES[1][1]);
+  });
+
+  applyLink();
+
+  $("#events-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    searchEvents(0);
+  });
+  $("#events-prev").addEventListener("click", () => searchEvents(Math.max(0, eventsOffset - PAGE_SIZE)));
+  $("#events-next").addEventListener("click", () => searchEvents(eventsOffset + PAGE_SIZE));
+
+  $("#chart-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    runChart();
+  });
+  $("#chart-save").addEventListener("click", saveChart);
+
+  $("#run-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    runSaved();
+  });
+
+  window.addEventListener("hashchange", () => showTab(location.hash.slice(1) || "events"));
+  showTab(location.hash.slice(1) || "events");
+
+  initAuth().then(() => {
+    loadServices();
+    searchEvents(0);
+  });
+});
@@ -0,0 +1,14 @@
+// Package ui embeds the web UI (event explorer, time series charts, and saved queries)
+// served by the binary at /ui/
+package ui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed static
+var static embed.FS
+
+// FS holds the UI's static files
+var FS, _ = fs.Sub(static, "static")